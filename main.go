@@ -16,6 +16,7 @@ package main
 //go:generate go run server/errors_gen.go
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -58,6 +59,8 @@ Logging Options:
 JetStream Options:
     -js, --jetstream                 Enable JetStream functionality
     -sd, --store_dir <dir>           Set the storage directory
+        --verify_store               Verify checksums, subject indexes and consumer states
+                                     of the storage directory, print a report and exit
 
 Authorization Options:
         --user <user>                User required for connections
@@ -114,6 +117,20 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Verify the store directory, we exit with 2 if any problems were found.
+	if opts.VerifyStore {
+		r, err := server.VerifyStore(opts)
+		if err != nil {
+			server.PrintAndDie(fmt.Sprintf("%s: %s", exe, err))
+		}
+		b, _ := json.MarshalIndent(r, "", "  ")
+		fmt.Println(string(b))
+		if !r.OK {
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
 	// Create the server with appropriate options.
 	s, err := server.NewServer(opts)
 	if err != nil {
@@ -78,6 +78,8 @@ type Account struct {
 	exports      exportMap
 	js           *jsAccount
 	jsLimits     map[string]JetStreamAccountLimits
+	jsTrash      time.Duration
+	talkers      *subjectTalkers
 	limits
 	expired      bool
 	incomplete   bool
@@ -101,6 +103,14 @@ type limits struct {
 	mconns         int32
 	mleafs         int32
 	disallowBearer bool
+	// Overrides for client connections, server or listener values are used if not set.
+	pingInterval  time.Duration
+	maxPingsOut   int
+	writeDeadline time.Duration
+	// Client connections with no subscriptions and no messages for this long are closed.
+	idleTimeout time.Duration
+	// Users of the account need to connect over TLS.
+	tlsRequired *TLSRequirement
 }
 
 // Used to track remote clients and leafnodes per remote server.
@@ -144,6 +154,7 @@ type serviceImport struct {
 	tracking    bool
 	didDeliver  bool
 	trackingHdr http.Header // header from request
+	ckey        string      // response cache key of the request
 }
 
 // This is used to record when we create a mapping for implicit service
@@ -222,6 +233,14 @@ type serviceExport struct {
 	latency    *serviceLatency
 	rtmr       *time.Timer
 	respThresh time.Duration
+	// Limits on requests awaiting a response, per importing account and in total.
+	maxConcurrent int
+	maxPending    int
+	pending       int
+	pendingAcc    map[string]int
+	// Optional cache of responses.
+	cacheTTL time.Duration
+	cache    map[string]*svcCacheEntry
 }
 
 // Used to track service latency.
@@ -249,7 +268,7 @@ type importMap struct {
 func NewAccount(name string) *Account {
 	a := &Account{
 		Name:     name,
-		limits:   limits{-1, -1, -1, -1, false},
+		limits:   limits{mpay: -1, msubs: -1, mconns: -1, mleafs: -1},
 		eventIds: nuid.New(),
 	}
 	return a
@@ -304,6 +323,7 @@ func (a *Account) shallowCopy() *Account {
 	}
 	// JetStream
 	na.jsLimits = a.jsLimits
+	na.jsTrash = a.jsTrash
 	// Server config account limits.
 	na.limits = a.limits
 
@@ -1649,6 +1669,9 @@ func (a *Account) removeRespServiceImport(si *serviceImport, reason rsiReason) {
 
 	a.mu.Lock()
 	c := a.ic
+	if _, ok := a.exports.responses[si.from]; ok && si.se != nil {
+		si.se.releasePending(si.acc)
+	}
 	delete(a.exports.responses, si.from)
 	dest, to, tracking, rc, didDeliver := si.acc, si.to, si.tracking, si.rc, si.didDeliver
 	a.mu.Unlock()
@@ -1897,7 +1920,7 @@ func (a *Account) addServiceImport(dest *Account, from, to string, claim *jwt.Im
 	if claim != nil {
 		share = claim.Share
 	}
-	si := &serviceImport{dest, claim, se, nil, from, to, tr, 0, rt, lat, nil, nil, usePub, false, false, share, false, false, nil}
+	si := &serviceImport{dest, claim, se, nil, from, to, tr, 0, rt, lat, nil, nil, usePub, false, false, share, false, false, nil, _EMPTY_}
 	a.imports.services[from] = si
 	a.mu.Unlock()
 
@@ -2326,6 +2349,80 @@ func (a *Account) SetServiceExportResponseThreshold(export string, maxTime time.
 	return nil
 }
 
+// ServiceExportLimits returns the maximum number of requests awaiting a response
+// per importing account and in total for the export. Zero means unlimited.
+func (a *Account) ServiceExportLimits(export string) (maxConcurrent, maxPending int, err error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	se := a.getServiceExport(export)
+	if se == nil {
+		return 0, 0, fmt.Errorf("no export defined for %q", export)
+	}
+	return se.maxConcurrent, se.maxPending, nil
+}
+
+// SetServiceExportLimits sets the maximum number of requests that can be awaiting a
+// response from the service export. maxConcurrent applies to each importing account,
+// maxPending to all importers combined. Requests over either limit are rejected with
+// a 503 status. Zero means unlimited.
+func (a *Account) SetServiceExportLimits(export string, maxConcurrent, maxPending int) error {
+	if maxConcurrent < 0 || maxPending < 0 {
+		return fmt.Errorf("service export limits can not be negative")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.isClaimAccount() {
+		return fmt.Errorf("claim based accounts can not be updated directly")
+	}
+	se := a.getServiceExport(export)
+	if se == nil {
+		return fmt.Errorf("no export defined for %q", export)
+	}
+	se.maxConcurrent, se.maxPending = maxConcurrent, maxPending
+	return nil
+}
+
+// Returns a description of the limit reached if a new request from the
+// importing account can not be accepted by this service export.
+func (se *serviceExport) checkLimits(importer *Account) string {
+	acc := se.acc
+	if acc == nil {
+		return _EMPTY_
+	}
+	acc.mu.RLock()
+	defer acc.mu.RUnlock()
+	if se.maxPending > 0 && se.pending >= se.maxPending {
+		return "Service Pending Limit Exceeded"
+	}
+	if se.maxConcurrent > 0 && se.pendingAcc[importer.Name] >= se.maxConcurrent {
+		return "Service Concurrency Limit Exceeded"
+	}
+	return _EMPTY_
+}
+
+// Tracks a request from the importing account awaiting a response.
+// Account lock should be held.
+func (se *serviceExport) trackPending(importer *Account) {
+	if se.pendingAcc == nil {
+		se.pendingAcc = make(map[string]int)
+	}
+	se.pending++
+	se.pendingAcc[importer.Name]++
+}
+
+// Releases a request from the importing account that is no longer awaiting a response.
+// Account lock should be held.
+func (se *serviceExport) releasePending(importer *Account) {
+	if se.pending > 0 {
+		se.pending--
+	}
+	if n := se.pendingAcc[importer.Name]; n > 1 {
+		se.pendingAcc[importer.Name] = n - 1
+	} else {
+		delete(se.pendingAcc, importer.Name)
+	}
+}
+
 // This is for internal service import responses.
 func (a *Account) addRespServiceImport(dest *Account, to string, osi *serviceImport, tracking bool, header http.Header) *serviceImport {
 	nrr := string(osi.acc.newServiceReply(tracking))
@@ -2335,12 +2432,13 @@ func (a *Account) addRespServiceImport(dest *Account, to string, osi *serviceImp
 
 	// dest is the requestor's account. a is the service responder with the export.
 	// Marked as internal here, that is how we distinguish.
-	si := &serviceImport{dest, nil, osi.se, nil, nrr, to, nil, 0, rt, nil, nil, nil, false, true, false, osi.share, false, false, nil}
+	si := &serviceImport{dest, nil, osi.se, nil, nrr, to, nil, 0, rt, nil, nil, nil, false, true, false, osi.share, false, false, nil, _EMPTY_}
 
 	if a.exports.responses == nil {
 		a.exports.responses = make(map[string]*serviceImport)
 	}
 	a.exports.responses[nrr] = si
+	osi.se.trackPending(dest)
 
 	// Always grab time and make sure response threshold timer is running.
 	si.ts = time.Now().UnixNano()
@@ -2979,6 +3077,9 @@ func (s *Server) updateAccountClaimsWithRefresh(a *Account, ac *jwt.AccountClaim
 	s.Debugf("Updating account claims: %s/%s", a.Name, ac.Name)
 	a.checkExpiration(ac.Claims())
 
+	// Permissions for this account may have changed.
+	s.permCache.invalidate(a.Name)
+
 	a.mu.Lock()
 	// Clone to update, only select certain fields.
 	old := &Account{Name: a.Name, exports: a.exports, limits: a.limits, signingKeys: a.signingKeys}
@@ -3634,12 +3735,16 @@ func (ur *URLAccResolver) Fetch(name string) (string, error) {
 	return string(body), nil
 }
 
+// Key info used to derive the key for encrypting the resolver directory.
+const resolverKeyInfo = "$RESOLVER"
+
 // Resolver based on nats for synchronization and backing directory for storage.
 type DirAccResolver struct {
 	*DirJWTStore
 	*Server
 	syncInterval time.Duration
 	fetchTimeout time.Duration
+	encrypt      bool
 }
 
 func (dr *DirAccResolver) IsTrackingUpdate() bool {
@@ -3826,6 +3931,9 @@ func (dr *DirAccResolver) Start(s *Server) error {
 	if err != nil {
 		return err
 	}
+	if err := dr.setupEncryption(s); err != nil {
+		return err
+	}
 	dr.Lock()
 	defer dr.Unlock()
 	dr.Server = s
@@ -4019,6 +4127,46 @@ func FetchTimeout(to time.Duration) DirResOption {
 	}
 }
 
+// encrypts the jwt kept in the directory at rest, using the JetStream encryption key and cipher
+func EncryptAtRest() DirResOption {
+	return func(r *DirAccResolver) error {
+		r.encrypt = true
+		return nil
+	}
+}
+
+// Returns the directory resolver underlying ar, if any.
+func dirAccResolver(ar AccountResolver) *DirAccResolver {
+	switch r := ar.(type) {
+	case *DirAccResolver:
+		return r
+	case *CacheDirAccResolver:
+		return &r.DirAccResolver
+	}
+	return nil
+}
+
+// Sets up encryption of the directory if requested.
+// Lock should NOT be held.
+func (dr *DirAccResolver) setupEncryption(s *Server) error {
+	if !dr.encrypt {
+		return nil
+	}
+	prf := s.jsKeyGen(resolverKeyInfo)
+	if prf == nil {
+		return errors.New("resolver encryption requires a JetStream encryption key")
+	}
+	rb, err := prf(nil)
+	if err != nil {
+		return err
+	}
+	aek, err := genEncryptionKey(s.getOpts().JetStreamCipher, rb)
+	if err != nil {
+		return err
+	}
+	return dr.DirJWTStore.enableEncryption(aek)
+}
+
 func (dr *DirAccResolver) apply(opts ...DirResOption) error {
 	for _, o := range opts {
 		if err := o(dr); err != nil {
@@ -4044,7 +4192,7 @@ func NewDirAccResolver(path string, limit int64, syncInterval time.Duration, del
 		return nil, err
 	}
 
-	res := &DirAccResolver{store, nil, syncInterval, DEFAULT_ACCOUNT_FETCH_TIMEOUT}
+	res := &DirAccResolver{DirJWTStore: store, syncInterval: syncInterval, fetchTimeout: DEFAULT_ACCOUNT_FETCH_TIMEOUT}
 	if err := res.apply(opts...); err != nil {
 		return nil, err
 	}
@@ -4113,7 +4261,7 @@ func NewCacheDirAccResolver(path string, limit int64, ttl time.Duration, opts ..
 	if err != nil {
 		return nil, err
 	}
-	res := &CacheDirAccResolver{DirAccResolver{store, nil, 0, DEFAULT_ACCOUNT_FETCH_TIMEOUT}, ttl}
+	res := &CacheDirAccResolver{DirAccResolver{DirJWTStore: store, fetchTimeout: DEFAULT_ACCOUNT_FETCH_TIMEOUT}, ttl}
 	if err := res.apply(opts...); err != nil {
 		return nil, err
 	}
@@ -4125,6 +4273,9 @@ func (dr *CacheDirAccResolver) Start(s *Server) error {
 	if err != nil {
 		return err
 	}
+	if err := dr.setupEncryption(s); err != nil {
+		return err
+	}
 	dr.Lock()
 	defer dr.Unlock()
 	dr.Server = s
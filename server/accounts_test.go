@@ -3676,3 +3676,162 @@ func TestAccountImportDuplicateResponseDeliveryWithLeafnodes(t *testing.T) {
 		t.Fatalf("Expected only 1 response, got %d", n)
 	}
 }
+
+func TestAccountServiceExportLimits(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		accounts {
+			SVC {
+				users = [ { user: svc, password: pwd } ]
+				exports = [ { service: "req", max_concurrent: 2, max_pending: 3 } ]
+			}
+			A {
+				users = [ { user: a, password: pwd } ]
+				imports = [ { service: { account: SVC, subject: "req" } } ]
+			}
+			B {
+				users = [ { user: b, password: pwd } ]
+				imports = [ { service: { account: SVC, subject: "req" } } ]
+			}
+		}
+	`))
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	acc, err := s.LookupAccount("SVC")
+	require_NoError(t, err)
+	mc, mp, err := acc.ServiceExportLimits("req")
+	require_NoError(t, err)
+	require_True(t, mc == 2 && mp == 3)
+
+	snc := natsConnect(t, s.ClientURL(), nats.UserInfo("svc", "pwd"))
+	defer snc.Close()
+	reqs := natsSubSync(t, snc, "req")
+	natsFlush(t, snc)
+
+	anc := natsConnect(t, s.ClientURL(), nats.UserInfo("a", "pwd"))
+	defer anc.Close()
+	bnc := natsConnect(t, s.ClientURL(), nats.UserInfo("b", "pwd"))
+	defer bnc.Close()
+
+	// Requests that are held by the service count against the limits.
+	request := func(nc *nats.Conn) chan *nats.Msg {
+		t.Helper()
+		reply, ch := nats.NewInbox(), make(chan *nats.Msg, 1)
+		_, err := nc.ChanSubscribe(reply, ch)
+		require_NoError(t, err)
+		require_NoError(t, nc.PublishRequest("req", reply, nil))
+		natsFlush(t, nc)
+		return ch
+	}
+	expectUnavailable := func(ch chan *nats.Msg, desc string) {
+		t.Helper()
+		select {
+		case m := <-ch:
+			require_Equal(t, m.Header.Get("Status"), "503")
+			require_Equal(t, m.Header.Get("Description"), desc)
+		case <-time.After(time.Second):
+			t.Fatalf("Did not receive a response")
+		}
+	}
+
+	var held []*nats.Msg
+	for i := 0; i < 2; i++ {
+		request(anc)
+		m, err := reqs.NextMsg(time.Second)
+		require_NoError(t, err)
+		held = append(held, m)
+	}
+	// A is at its concurrency limit, but B can still make requests.
+	expectUnavailable(request(anc), "Service Concurrency Limit Exceeded")
+	rch := request(bnc)
+	m, err := reqs.NextMsg(time.Second)
+	require_NoError(t, err)
+
+	// Now the service has 3 pending requests in total.
+	expectUnavailable(request(bnc), "Service Pending Limit Exceeded")
+
+	// Once a response goes out a new request is accepted.
+	require_NoError(t, m.Respond([]byte("ok")))
+	select {
+	case resp := <-rch:
+		require_Equal(t, string(resp.Data), "ok")
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive a response")
+	}
+	request(bnc)
+	_, err = reqs.NextMsg(time.Second)
+	require_NoError(t, err)
+
+	// Limits can be changed.
+	require_NoError(t, acc.SetServiceExportLimits("req", 0, 0))
+	request(anc)
+	_, err = reqs.NextMsg(time.Second)
+	require_NoError(t, err)
+	require_Error(t, acc.SetServiceExportLimits("req", -1, 0))
+}
+
+func TestAccountServiceExportResponseCache(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		accounts {
+			SVC {
+				users = [ { user: svc, password: pwd } ]
+				exports = [ { service: "lookup.*", cache_ttl: "250ms" } ]
+			}
+			A {
+				users = [ { user: a, password: pwd } ]
+				imports = [ { service: { account: SVC, subject: "lookup.*" } } ]
+			}
+		}
+	`))
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	acc, err := s.LookupAccount("SVC")
+	require_NoError(t, err)
+	ttl, err := acc.ServiceExportResponseCache("lookup.*")
+	require_NoError(t, err)
+	require_True(t, ttl == 250*time.Millisecond)
+
+	snc := natsConnect(t, s.ClientURL(), nats.UserInfo("svc", "pwd"))
+	defer snc.Close()
+	var calls int32
+	_, err = snc.Subscribe("lookup.*", func(m *nats.Msg) {
+		n := atomic.AddInt32(&calls, 1)
+		m.Respond([]byte(fmt.Sprintf("%s:%s:%d", m.Subject, m.Data, n)))
+	})
+	require_NoError(t, err)
+	natsFlush(t, snc)
+
+	anc := natsConnect(t, s.ClientURL(), nats.UserInfo("a", "pwd"))
+	defer anc.Close()
+
+	request := func(subj, data string) string {
+		t.Helper()
+		resp, err := anc.Request(subj, []byte(data), time.Second)
+		require_NoError(t, err)
+		return string(resp.Data)
+	}
+
+	require_Equal(t, request("lookup.1", "x"), "lookup.1:x:1")
+	// Same request is answered from the cache.
+	require_Equal(t, request("lookup.1", "x"), "lookup.1:x:1")
+	require_True(t, atomic.LoadInt32(&calls) == 1)
+	// A different payload or subject goes to the service.
+	require_Equal(t, request("lookup.1", "y"), "lookup.1:y:2")
+	require_Equal(t, request("lookup.2", "x"), "lookup.2:x:3")
+	require_Equal(t, request("lookup.1", "x"), "lookup.1:x:1")
+
+	// Once expired the service is asked again.
+	time.Sleep(300 * time.Millisecond)
+	require_Equal(t, request("lookup.1", "x"), "lookup.1:x:4")
+
+	// Disable the cache.
+	require_NoError(t, acc.SetServiceExportResponseCache("lookup.*", 0))
+	require_Equal(t, request("lookup.1", "x"), "lookup.1:x:5")
+
+	// Only singleton responses can be cached.
+	require_NoError(t, acc.AddServiceExportWithResponse("stream.*", Streamed, nil))
+	require_Error(t, acc.SetServiceExportResponseCache("stream.*", time.Second))
+}
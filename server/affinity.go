@@ -0,0 +1,157 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CPUAffinityOpts restrict major worker pools of the server to sets of CPUs, for
+// instance to keep them on the CPUs of one NUMA node. Each pinned goroutine keeps
+// its own OS thread, so only a bounded number of goroutines per CPU of each pool
+// are pinned. Only supported on Linux.
+type CPUAffinityOpts struct {
+	// CPUs for the read and write loops of connections.
+	ClientIO []int
+	// CPUs for the flush loops of file stores.
+	Filestore []int
+	// CPUs for the run loops of raft groups and the goroutines applying their entries.
+	Raft []int
+}
+
+// Returns true if any pool is pinned.
+func (ao *CPUAffinityOpts) enabled() bool {
+	return len(ao.ClientIO) > 0 || len(ao.Filestore) > 0 || len(ao.Raft) > 0
+}
+
+func validateCPUAffinityOptions(o *Options) error {
+	ao := &o.CPUAffinity
+	for _, cpus := range [][]int{ao.ClientIO, ao.Filestore, ao.Raft} {
+		for _, cpu := range cpus {
+			if cpu < 0 || cpu >= runtime.NumCPU() {
+				return errors.New("cpu affinity cpus must be between 0 and the number of cpus")
+			}
+		}
+	}
+	return nil
+}
+
+// Parses a list of CPUs like "0-15,32-47", as used by taskset and /sys.
+func parseCPUList(s string) ([]int, error) {
+	seen := make(map[int]struct{})
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if r == _EMPTY_ {
+			continue
+		}
+		lo, hi := r, r
+		if i := strings.IndexByte(r, '-'); i > 0 {
+			lo, hi = r[:i], r[i+1:]
+		}
+		first, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu %q", lo)
+		}
+		last, err := strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu %q", hi)
+		}
+		if first < 0 || last < first {
+			return nil, fmt.Errorf("invalid cpu range %q", r)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = struct{}{}
+		}
+	}
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// Goroutines of a pinned pool each need their own OS thread, which would be two
+// for each connection. So only this many goroutines per cpu of a pool are pinned,
+// the others run unpinned. This keeps the threads we use well under the limit of
+// the runtime even with all pools pinned to all cpus.
+const maxPinnedPerCPU = 8
+
+// Names of the pools we pin.
+const (
+	cpuPoolClientIO  = "client io"
+	cpuPoolFilestore = "filestore"
+	cpuPoolRaft      = "raft"
+)
+
+// cpuPool bounds the goroutines pinned to a set of cpus.
+type cpuPool struct {
+	cpus  []int
+	slots chan struct{}
+}
+
+var (
+	cpuPoolsMu sync.Mutex
+	cpuPools   = make(map[string]*cpuPool)
+)
+
+// Returns the pool for name and cpus. Pools are kept across servers and
+// reloads, their cpus are fixed once created.
+func getCPUPool(name string, cpus []int) *cpuPool {
+	key := fmt.Sprintf("%s:%v", name, cpus)
+	cpuPoolsMu.Lock()
+	defer cpuPoolsMu.Unlock()
+	cp := cpuPools[key]
+	if cp == nil {
+		cp = &cpuPool{cpus: cpus, slots: make(chan struct{}, len(cpus)*maxPinnedPerCPU)}
+		cpuPools[key] = cp
+	}
+	return cp
+}
+
+// Locks the calling goroutine to its OS thread and restricts the thread to the
+// cpus of pool, if the pool has a slot left. The returned function needs to be
+// called when the goroutine exits to free the slot. The thread is never
+// unlocked, so it exits with the goroutine instead of going back to the runtime
+// with our affinity.
+func lockToCPUs(pool string, cpus []int) (func(), error) {
+	if len(cpus) == 0 || !cpuAffinitySupported {
+		return func() {}, nil
+	}
+	cp := getCPUPool(pool, cpus)
+	select {
+	case cp.slots <- struct{}{}:
+	default:
+		// Pool is full, run unpinned.
+		return func() {}, nil
+	}
+	release := func() { <-cp.slots }
+	runtime.LockOSThread()
+	return release, setThreadAffinity(cpus)
+}
+
+// Pins the calling goroutine to the cpus of pool, see lockToCPUs.
+func (s *Server) pinToCPUs(pool string, cpus []int) func() {
+	release, err := lockToCPUs(pool, cpus)
+	if err != nil {
+		s.Warnf("Could not pin %s goroutine to cpus %v: %v", pool, cpus, err)
+	}
+	return release
+}
@@ -63,6 +63,7 @@ type NkeyUser struct {
 	Account                *Account            `json:"account,omitempty"`
 	SigningKey             string              `json:"signing_key,omitempty"`
 	AllowedConnectionTypes map[string]struct{} `json:"connection_types,omitempty"`
+	TLS                    *TLSRequirement     `json:"tls_required,omitempty"`
 }
 
 // User is for multiple accounts/users.
@@ -72,6 +73,7 @@ type User struct {
 	Permissions            *Permissions        `json:"permissions,omitempty"`
 	Account                *Account            `json:"account,omitempty"`
 	AllowedConnectionTypes map[string]struct{} `json:"connection_types,omitempty"`
+	TLS                    *TLSRequirement     `json:"tls_required,omitempty"`
 }
 
 // clone performs a deep copy of the User struct, returning a new clone with
@@ -333,15 +335,22 @@ func (s *Server) checkAuthentication(c *client) bool {
 func (s *Server) isClientAuthorized(c *client) bool {
 	opts := s.getOpts()
 
-	// Check custom auth first, then jwts, then nkeys, then
-	// multiple users with TLS map if enabled, then token,
-	// then single user/pass.
-	if opts.CustomClientAuthentication != nil && !opts.CustomClientAuthentication.Check(c) {
-		return false
-	}
+	// In-process connections may have been authenticated programmatically.
+	if c.preauth != nil {
+		if !s.registerPreauthUser(c) {
+			return false
+		}
+	} else {
+		// Check custom auth first, then jwts, then nkeys, then
+		// multiple users with TLS map if enabled, then token,
+		// then single user/pass.
+		if opts.CustomClientAuthentication != nil && !opts.CustomClientAuthentication.Check(c) {
+			return false
+		}
 
-	if opts.CustomClientAuthentication == nil && !s.processClientOrLeafAuthentication(c, opts) {
-		return false
+		if opts.CustomClientAuthentication == nil && !s.processClientOrLeafAuthentication(c, opts) {
+			return false
+		}
 	}
 
 	if c.kind == CLIENT || c.kind == LEAF {
@@ -352,6 +361,27 @@ func (s *Server) isClientAuthorized(c *client) bool {
 	return true
 }
 
+// Registers the user of an in-process connection authenticated programmatically.
+// The account is looked up by name, as it could have been replaced since the
+// user was handed to us, say on a reload.
+// Returns false if the client needs to be disconnected.
+func (s *Server) registerPreauthUser(c *client) bool {
+	user := c.preauth.clone()
+	if user.Account != nil {
+		acc, err := s.LookupAccount(user.Account.Name)
+		if err != nil {
+			c.Debugf("Account %q of in-process user not found: %v", user.Account.Name, err)
+			return false
+		}
+		if acc.IsExpired() {
+			c.Debugf("Account %q of in-process user has expired", acc.Name)
+			return false
+		}
+		user.Account = acc
+	}
+	return c.registerUser(user) == nil
+}
+
 // returns false if the client needs to be disconnected
 func (c *client) matchesPinnedCert(tlsPinnedCerts PinnedCertSet) bool {
 	if tlsPinnedCerts == nil {
@@ -362,13 +392,19 @@ func (c *client) matchesPinnedCert(tlsPinnedCerts PinnedCertSet) bool {
 		c.Debugf("Failed pinned cert test as client did not provide a certificate")
 		return false
 	}
-	sha := sha256.Sum256(tlsState.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	cert := tlsState.PeerCertificates[0]
+	sha := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
 	keyId := hex.EncodeToString(sha[:])
-	if _, ok := tlsPinnedCerts[keyId]; !ok {
-		c.Debugf("Failed pinned cert test for key id: %s", keyId)
-		return false
+	if _, ok := tlsPinnedCerts[keyId]; ok {
+		return true
 	}
-	return true
+	// Also allow the fingerprint of the certificate itself.
+	sha = sha256.Sum256(cert.Raw)
+	if _, ok := tlsPinnedCerts[hex.EncodeToString(sha[:])]; ok {
+		return true
+	}
+	c.Debugf("Failed pinned cert test for key id: %s", keyId)
+	return false
 }
 
 func processUserPermissionsTemplate(lim jwt.UserPermissionLimits, ujwt *jwt.UserClaims, acc *Account) (jwt.UserPermissionLimits, error) {
@@ -601,6 +637,11 @@ func (s *Server) processClientOrLeafAuthentication(c *client, opts *Options) boo
 				token = wo.Token
 				ao = true
 			}
+		default:
+			// Clients of additional listeners use the listener's TLSMap.
+			if c.lst != nil {
+				tlsMap = c.lst.opts.TLSMap
+			}
 		}
 	} else {
 		tlsMap = opts.LeafNode.TLSMap
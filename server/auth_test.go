@@ -14,6 +14,8 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/url"
@@ -318,3 +320,122 @@ func TestNoAuthUserNoConnectProto(t *testing.T) {
 	time.Sleep(1200 * time.Millisecond)
 	checkClientsCount(t, s, 0)
 }
+
+func TestAuthTLSRequirements(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		tls {
+			ca_file: "../test/configs/certs/ca.pem"
+			cert_file: "../test/configs/certs/server-cert.pem"
+			key_file: "../test/configs/certs/server-key.pem"
+			verify: true
+		}
+		allow_non_tls: true
+		accounts {
+			LEGACY {
+				users [
+					{user: legacy, password: pwd}
+					{user: strict, password: pwd, tls_required: true}
+				]
+			}
+			TENANT {
+				tls_required: {min_version: "1.2", client_cert: true}
+				users [{user: tenant, password: pwd}]
+			}
+		}
+	`))
+	s, opts := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	acc, err := s.LookupAccount("TENANT")
+	require_NoError(t, err)
+	require_True(t, acc.tlsRequired != nil && acc.tlsRequired.MinVersion == tls.VersionTLS12 && acc.tlsRequired.ClientCert)
+
+	url := fmt.Sprintf("nats://127.0.0.1:%d", opts.Port)
+	connect := func(user string, secure bool) error {
+		t.Helper()
+		copts := []nats.Option{nats.UserInfo(user, "pwd"), nats.MaxReconnects(0)}
+		if secure {
+			copts = append(copts,
+				nats.ClientCert("../test/configs/certs/client-cert.pem", "../test/configs/certs/client-key.pem"),
+				nats.RootCAs("../test/configs/certs/ca.pem"))
+		}
+		nc, err := nats.Connect(url, copts...)
+		if err == nil {
+			nc.Close()
+		}
+		return err
+	}
+
+	// Legacy clients keep connecting without TLS.
+	require_NoError(t, connect("legacy", false))
+	require_NoError(t, connect("legacy", true))
+
+	// Users with requirements need TLS, either of their own or from their account.
+	for _, user := range []string{"strict", "tenant"} {
+		err := connect(user, false)
+		require_Error(t, err)
+		require_Contains(t, err.Error(), "Authorization Violation")
+		require_NoError(t, connect(user, true))
+	}
+}
+
+func TestAuthTLSRequirementsTokenAndNoAuth(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		auth  string
+		token string
+	}{
+		{"token", "authorization { token: s3cr3t }", "s3cr3t"},
+		{"no auth", _EMPTY_, _EMPTY_},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conf := createConfFile(t, []byte(fmt.Sprintf(`
+				listen: "127.0.0.1:-1"
+				tls {
+					cert_file: "../test/configs/certs/server-cert.pem"
+					key_file: "../test/configs/certs/server-key.pem"
+				}
+				allow_non_tls: true
+				%s
+			`, test.auth)))
+			s, opts := RunServerWithConfig(conf)
+			defer s.Shutdown()
+
+			// Those clients end up in the global account, which has no config block.
+			gacc := s.GlobalAccount()
+			gacc.mu.Lock()
+			gacc.tlsRequired = &TLSRequirement{}
+			gacc.mu.Unlock()
+
+			url := fmt.Sprintf("nats://127.0.0.1:%d", opts.Port)
+			connect := func(secure bool) error {
+				t.Helper()
+				copts := []nats.Option{nats.Token(test.token), nats.MaxReconnects(0)}
+				if secure {
+					copts = append(copts, nats.RootCAs("../test/configs/certs/ca.pem"))
+				}
+				nc, err := nats.Connect(url, copts...)
+				if err == nil {
+					nc.Close()
+				}
+				return err
+			}
+
+			err := connect(false)
+			require_Error(t, err)
+			require_Contains(t, err.Error(), "Authorization Violation")
+			require_NoError(t, connect(true))
+		})
+	}
+}
+
+func TestAuthTLSRequirementCheck(t *testing.T) {
+	req := &TLSRequirement{MinVersion: tls.VersionTLS13, ClientCert: true}
+	require_Error(t, req.check(nil))
+	require_Error(t, req.check(&tls.ConnectionState{}))
+	require_Error(t, req.check(&tls.ConnectionState{HandshakeComplete: true, Version: tls.VersionTLS12}))
+	require_Error(t, req.check(&tls.ConnectionState{HandshakeComplete: true, Version: tls.VersionTLS13}))
+	require_NoError(t, req.check(&tls.ConnectionState{HandshakeComplete: true, Version: tls.VersionTLS13,
+		PeerCertificates: []*x509.Certificate{{}}}))
+}
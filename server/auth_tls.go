@@ -0,0 +1,125 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TLSRequirement requires a user, or all users of an account, to connect over TLS.
+// Combined with allow_non_tls this lets a listener keep accepting plain connections
+// from legacy clients while tenants are required to use TLS, or mutual TLS.
+type TLSRequirement struct {
+	// Minimum TLS version, e.g. tls.VersionTLS13.
+	MinVersion uint16 `json:"min_version,omitempty"`
+	// Require a client certificate. The listener needs to ask for one, e.g. with verify.
+	ClientCert bool `json:"client_cert,omitempty"`
+}
+
+// Returns an error if the connection state does not meet the requirement.
+func (req *TLSRequirement) check(cs *tls.ConnectionState) error {
+	if cs == nil || !cs.HandshakeComplete {
+		return errors.New("TLS required")
+	}
+	if cs.Version < req.MinVersion {
+		return fmt.Errorf("TLS %s required, got %s", tlsVersion(req.MinVersion), tlsVersion(cs.Version))
+	}
+	if req.ClientCert && len(cs.PeerCertificates) == 0 {
+		return errors.New("TLS client certificate required")
+	}
+	return nil
+}
+
+// Returns true if the connection meets the TLS requirements of its user and account.
+func (c *client) tlsRequirementsMet(ureq *TLSRequirement, acc *Account) bool {
+	reqs := [2]*TLSRequirement{ureq}
+	if acc != nil {
+		acc.mu.RLock()
+		reqs[1] = acc.tlsRequired
+		acc.mu.RUnlock()
+	}
+	var cs *tls.ConnectionState
+	for i, req := range reqs {
+		if req == nil {
+			continue
+		}
+		if cs == nil {
+			cs = c.GetTLSConnectionState()
+		}
+		if err := req.check(cs); err != nil {
+			if i == 0 {
+				c.Debugf("User TLS requirement not met: %v", err)
+			} else {
+				c.Debugf("Account TLS requirement not met: %v", err)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// Parses `tls_required` for users and accounts, either a boolean or a map
+// with `min_version` and `client_cert`.
+func parseTLSRequirement(tk token, v interface{}, errors *[]error) *TLSRequirement {
+	switch vv := v.(type) {
+	case bool:
+		if vv {
+			return &TLSRequirement{}
+		}
+		return nil
+	case map[string]interface{}:
+		var lt token
+		req := &TLSRequirement{}
+		for mk, mv := range vv {
+			tk, mv := unwrapValue(mv, &lt)
+			switch strings.ToLower(mk) {
+			case "min_version", "minimum_version":
+				ver, ok := mv.(string)
+				if ok {
+					req.MinVersion, ok = tlsVersionFromString(ver)
+				}
+				if !ok {
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("invalid TLS version %v", mv)})
+				}
+			case "client_cert", "verify":
+				req.ClientCert, _ = mv.(bool)
+			default:
+				if !tk.IsUsedVariable() {
+					*errors = append(*errors, &unknownConfigFieldErr{field: mk, configErr: configErr{token: tk}})
+				}
+			}
+		}
+		return req
+	default:
+		*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected tls_required to be a boolean or map, got %T", v)})
+		return nil
+	}
+}
+
+func tlsVersionFromString(ver string) (uint16, bool) {
+	switch strings.TrimPrefix(strings.ToLower(ver), "tls") {
+	case "1.0", "10":
+		return tls.VersionTLS10, true
+	case "1.1", "11":
+		return tls.VersionTLS11, true
+	case "1.2", "12":
+		return tls.VersionTLS12, true
+	case "1.3", "13":
+		return tls.VersionTLS13, true
+	}
+	return 0, false
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// Default number of connections that can busy poll at the same time.
+	defaultBusyPollMaxConn = 4
+	// Default time a read loop polls without data before blocking again.
+	defaultBusyPollSpin = 200 * time.Microsecond
+)
+
+// Tracks the connections that are busy polling.
+type busyPollState struct {
+	mu   sync.Mutex
+	n    int
+	next int
+}
+
+// busyPoller is held by the read loop of a client that busy polls its socket.
+// Reads spin on a non-blocking socket for up to spin before falling back to a
+// regular blocking read, so the read loop does not wait for the scheduler to
+// wake it up when data arrives shortly after the previous read.
+type busyPoller struct {
+	spin time.Duration
+	// CPU to pin the read loop to, or -1.
+	cpu int
+	// Set once the thread of the read loop was pinned to cpu.
+	pinned bool
+	// Platform specific state, set once the read loop started polling.
+	raw interface{}
+}
+
+func validateBusyPollOptions(o *Options) error {
+	bo := &o.BusyPoll
+	if bo.MaxConn < 0 {
+		return errors.New("busy poll max connections can not be negative")
+	}
+	if bo.Spin < 0 {
+		return errors.New("busy poll spin can not be negative")
+	}
+	for _, cpu := range bo.CPUs {
+		if cpu < 0 || cpu >= runtime.NumCPU() {
+			return errors.New("busy poll cpus must be between 0 and the number of cpus")
+		}
+	}
+	return nil
+}
+
+// Returns true if the connection is selected for busy polling.
+func (bo *BusyPollOpts) selects(acc *Account, user string) bool {
+	if acc != nil {
+		for _, name := range bo.Accounts {
+			if name == acc.Name {
+				return true
+			}
+		}
+	}
+	if user != _EMPTY_ {
+		for _, u := range bo.Users {
+			if u == user {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Sets up busy polling for a client if it is selected and a slot is available.
+// Called when processing the CONNECT of a client.
+func (s *Server) assignBusyPoll(c *client) {
+	bo := &s.getOpts().BusyPoll
+	if len(bo.Accounts) == 0 && len(bo.Users) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.bp != nil || c.isWebsocket() || c.isMqtt() {
+		return
+	}
+	// Only plain TCP connections are polled, we need to read the socket directly.
+	if _, ok := c.nc.(*net.TCPConn); !ok {
+		return
+	}
+	var user string
+	// Do not match on tokens.
+	if c.opts.Token == _EMPTY_ {
+		user = c.getRawAuthUser()
+	}
+	if !bo.selects(c.acc, user) {
+		return
+	}
+
+	maxConn, spin := bo.MaxConn, bo.Spin
+	if maxConn == 0 {
+		maxConn = defaultBusyPollMaxConn
+	}
+	if spin == 0 {
+		spin = defaultBusyPollSpin
+	}
+
+	bps := &s.busyPoll
+	bps.mu.Lock()
+	defer bps.mu.Unlock()
+	if bps.n >= maxConn {
+		c.Debugf("Not busy polling, limit of %d connections reached", maxConn)
+		return
+	}
+	bps.n++
+	cpu := -1
+	if len(bo.CPUs) > 0 {
+		cpu = bo.CPUs[bps.next%len(bo.CPUs)]
+		bps.next++
+	}
+	c.bp = &busyPoller{spin: spin, cpu: cpu}
+}
+
+// Releases the busy poll slot of a client, if any.
+func (s *Server) releaseBusyPoll(c *client) {
+	c.mu.Lock()
+	bp := c.bp
+	c.bp = nil
+	c.mu.Unlock()
+	if bp == nil {
+		return
+	}
+	bps := &s.busyPoll
+	bps.mu.Lock()
+	bps.n--
+	bps.mu.Unlock()
+}
+
+// Returns the number of connections busy polling.
+func (s *Server) numBusyPoll() int {
+	bps := &s.busyPoll
+	bps.mu.Lock()
+	defer bps.mu.Unlock()
+	return bps.n
+}
+
+// Called from the read loop once it has a busy poller. The read loop stays on
+// its OS thread from then on, optionally pinned to a CPU.
+func (bp *busyPoller) start(c *client, nc net.Conn) {
+	runtime.LockOSThread()
+	if bp.cpu >= 0 {
+		if err := pinThreadToCPU(bp.cpu); err != nil {
+			c.Warnf("Busy poll could not pin read loop to cpu %d: %v", bp.cpu, err)
+		} else {
+			bp.pinned = true
+		}
+	}
+	if tc, ok := nc.(*net.TCPConn); ok {
+		bp.raw = newBusyPollConn(tc)
+	}
+}
+
+// Called when the read loop exits. A pinned thread is never unlocked, so it
+// exits with the read loop instead of going back to the runtime with our affinity.
+func (bp *busyPoller) stop() {
+	if bp.pinned {
+		return
+	}
+	runtime.UnlockOSThread()
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func pinThreadToCPU(cpu int) error {
+	var set unix.CPUSet
+	set.Set(cpu)
+	// Pid 0 is the calling thread.
+	return unix.SchedSetaffinity(0, &set)
+}
+
+func newBusyPollConn(tc *net.TCPConn) interface{} {
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	return rc
+}
+
+// Reads from the connection, polling the socket for up to bp.spin before
+// falling back to a blocking read.
+func (bp *busyPoller) read(nc net.Conn, b []byte) (int, error) {
+	rc, ok := bp.raw.(syscall.RawConn)
+	if !ok {
+		return nc.Read(b)
+	}
+	var deadline time.Time
+	for {
+		var n int
+		var err error
+		// Returning true from the callback means we never park in the poller.
+		if rerr := rc.Read(func(fd uintptr) bool {
+			n, err = syscall.Read(int(fd), b)
+			return true
+		}); rerr != nil {
+			return 0, rerr
+		}
+		switch err {
+		case nil:
+			if n == 0 {
+				return 0, io.EOF
+			}
+			return n, nil
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN:
+			now := time.Now()
+			if deadline.IsZero() {
+				deadline = now.Add(bp.spin)
+			} else if now.After(deadline) {
+				return nc.Read(b)
+			}
+		default:
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"net"
+)
+
+// Pinning read loops to CPUs is only supported on Linux.
+func pinThreadToCPU(cpu int) error {
+	return nil
+}
+
+func newBusyPollConn(tc *net.TCPConn) interface{} {
+	return nil
+}
+
+// Busy polling is only supported on Linux, other platforms do regular reads
+// but still keep the read loop on its own thread.
+func (bp *busyPoller) read(nc net.Conn, b []byte) (int, error) {
+	return nc.Read(b)
+}
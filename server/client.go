@@ -139,6 +139,7 @@ const (
 	skipFlushOnClose                              // Marks that flushOutbound() should not be called on connection close.
 	expectConnect                                 // Marks if this connection is expected to send a CONNECT
 	connectProcessFinished                        // Marks if this connection has finished the connect process.
+	gwSubjectQuotaReported                        // Marks that the gateway subject quota advisory has been sent.
 )
 
 // set the flag (would be equivalent to set the boolean to true)
@@ -207,6 +208,8 @@ const (
 	DuplicateServerName
 	MinimumVersionRequired
 	ClusterNamesIdentical
+	IdleConnection
+	ServerInStandby
 )
 
 // Some flags passed to processMsgResults
@@ -232,6 +235,7 @@ type client struct {
 	rrTracking *rrTracking
 	mpay       int32
 	msubs      int32
+	gwsubs     int32 // Subjects this connection propagated to gateways, used with atomic operations.
 	mcl        int32
 	mu         sync.Mutex
 	cid        uint64
@@ -251,9 +255,12 @@ type client struct {
 	pcd        map[*client]struct{}
 	atmr       *time.Timer
 	ping       pinfo
+	idle       idleInfo
 	msgb       [msgScratchSize]byte
 	last       time.Time
 	headers    bool
+	cmp        *clientCompression
+	cmsg       *compressedMsg
 
 	rtt      time.Duration
 	rttStart time.Time
@@ -276,6 +283,18 @@ type client struct {
 	nameTag string
 
 	tlsTo *time.Timer
+
+	// For in-process connections authenticated programmatically.
+	preauth *User
+
+	// TLS requirement of the authenticated user, if any.
+	tlsReq *TLSRequirement
+
+	// Set if the client connected through an additional listener.
+	lst *clientListener
+
+	// Set if the read loop of this client busy polls the socket.
+	bp *busyPoller
 }
 
 type rrTracking struct {
@@ -289,6 +308,16 @@ type pinfo struct {
 	tmr  *time.Timer
 	last time.Time
 	out  int
+	intv time.Duration // Account or listener override of the ping interval.
+	max  int           // Account or listener override of the max pings outstanding.
+}
+
+// idleInfo tracks activity for closing idle connections.
+type idleInfo struct {
+	tmr  *time.Timer
+	to   time.Duration // Idle timeout from our account.
+	last time.Time     // Last time we saw activity.
+	msgs int64         // Messages in and out when last checked.
 }
 
 // outbound holds pending data for a socket.
@@ -314,13 +343,16 @@ type perm struct {
 }
 
 type permissions struct {
-	// Have these 2 first for memory alignment due to the use of atomic.
-	pcsz   int32
-	prun   int32
-	sub    perm
-	pub    perm
-	resp   *ResponsePermission
-	pcache sync.Map
+	sub  perm
+	pub  perm
+	resp *ResponsePermission
+	// Fingerprint of the permissions, used to share decisions.
+	fp string
+	// Cached decisions, *permDecisions. These are shared with all
+	// connections that have the same permissions.
+	dc atomic.Value
+	// The shared decisions we hold a reference on.
+	pd *permDecisions
 }
 
 // This is used to dynamically track responses and reply subjects
@@ -350,7 +382,7 @@ type routeTarget struct {
 const (
 	maxResultCacheSize   = 512
 	maxDenyPermCacheSize = 256
-	maxPermCacheSize     = 128
+	maxPermCacheSize     = 1024
 	pruneSize            = 32
 	routeTargetInit      = 8
 	replyPermLimit       = 4096
@@ -528,6 +560,7 @@ type subscription struct {
 	qw      int32
 	closed  int32
 	mqtt    *mqttSub
+	gwq     bool // Counted against the connection's gateway subject quota.
 }
 
 // Indicate that this subscription is closed.
@@ -561,6 +594,9 @@ type ClientOpts struct {
 	AccountNew   bool   `json:"new_account,omitempty"`
 	Headers      bool   `json:"headers,omitempty"`
 	NoResponders bool   `json:"no_responders,omitempty"`
+	// Payload compression, see CompressionHdr.
+	Compression          string `json:"compression,omitempty"`
+	CompressionThreshold int    `json:"compression_threshold,omitempty"`
 
 	// Routes and Leafnodes only
 	Import *SubjectPermission `json:"import,omitempty"`
@@ -590,6 +626,7 @@ func (c *client) initClient() {
 	// Snapshots to avoid mutex access in fast paths.
 	c.out.wdl = opts.WriteDeadline
 	c.out.mp = opts.MaxPending
+	c.applyKeepaliveOverrides()
 	// Snapshot max control line since currently can not be changed on reload and we
 	// were checking it on each call to parse. If this changes and we allow MaxControlLine
 	// to be reloaded without restart, this code will need to change.
@@ -783,6 +820,8 @@ func (c *client) applyAccountLimits() {
 	}
 	minLimit(&c.mpay, c.acc.mpay)
 	minLimit(&c.msubs, c.acc.msubs)
+	c.applyKeepaliveOverrides()
+	c.setIdleTimer()
 	s := c.srv
 	opts := s.getOpts()
 	mPay := opts.MaxPayload
@@ -815,19 +854,26 @@ func (c *client) applyAccountLimits() {
 // with the authenticated user. This is used to map
 // any permissions into the client and setup accounts.
 func (c *client) RegisterUser(user *User) {
+	c.registerUser(user)
+}
+
+// Same as RegisterUser but returns an error if the user's account could not be bound.
+func (c *client) registerUser(user *User) error {
 	// Register with proper account and sublist.
 	if user.Account != nil {
 		if err := c.registerWithAccount(user.Account); err != nil {
 			c.reportErrRegisterAccount(user.Account, err)
-			return
+			return err
 		}
 	}
 
 	c.mu.Lock()
+	c.tlsReq = user.TLS
 
 	// Assign permissions.
 	if user.Permissions == nil {
 		// Reset perms to nil in case client previously had them.
+		c.releasePermDecisions()
 		c.perms = nil
 		c.mperms = nil
 	} else {
@@ -841,6 +887,7 @@ func (c *client) RegisterUser(user *User) {
 	}
 
 	c.mu.Unlock()
+	return nil
 }
 
 // RegisterNkeyUser allows auth to call back into a new nkey
@@ -857,9 +904,11 @@ func (c *client) RegisterNkeyUser(user *NkeyUser) error {
 
 	c.mu.Lock()
 	c.user = user
+	c.tlsReq = user.TLS
 	// Assign permissions.
 	if user.Permissions == nil {
 		// Reset perms to nil in case client previously had them.
+		c.releasePermDecisions()
 		c.perms = nil
 		c.mperms = nil
 	} else {
@@ -887,6 +936,7 @@ func (c *client) setPermissions(perms *Permissions) {
 	if perms == nil {
 		return
 	}
+	c.releasePermDecisions()
 	c.perms = &permissions{}
 
 	// Loop over publish permissions
@@ -945,6 +995,8 @@ func (c *client) setPermissions(perms *Permissions) {
 		}
 	}
 
+	c.setPermDecisions(permsFingerprint(perms, c.kind))
+
 	// If we are a leafnode and we are the hub copy the extracted perms
 	// to resend back to soliciting server. These are reversed from the
 	// way routes interpret them since this is how the soliciting server
@@ -972,6 +1024,9 @@ func (c *client) mergeDenyPermissions(what denyType, denyPubs []string) {
 	if c.perms == nil {
 		c.perms = &permissions{}
 	}
+	// Move to the decisions for the merged permissions before we change them.
+	c.setPermDecisions(mergedPermsFingerprint(c.perms.fp, what, denyPubs))
+
 	var perms []*perm
 	switch what {
 	case pub:
@@ -1057,6 +1112,9 @@ func (c *client) writeLoop() {
 	c.flags.set(writeLoopStarted)
 	c.mu.Unlock()
 
+	release := c.srv.pinToCPUs(cpuPoolClientIO, c.srv.getOpts().CPUAffinity.ClientIO)
+	defer release()
+
 	// Used to check that we did flush from last wake up.
 	waitOk := true
 	var closed bool
@@ -1159,10 +1217,21 @@ func (c *client) readLoop(pre []byte) {
 	}
 	c.mu.Unlock()
 
+	// Busy polling may later pin us to a single one of these.
+	release := s.pinToCPUs(cpuPoolClientIO, s.getOpts().CPUAffinity.ClientIO)
+	defer release()
+
+	// Set once the client is selected for busy polling.
+	var bp *busyPoller
+
 	defer func() {
 		if c.isMqtt() {
 			s.mqttHandleClosedClient(c)
 		}
+		if bp != nil {
+			bp.stop()
+		}
+		s.releaseBusyPoll(c)
 		// These are used only in the readloop, so we can set them to nil
 		// on exit of the readLoop.
 		c.in.results, c.in.pacache = nil, nil
@@ -1194,7 +1263,11 @@ func (c *client) readLoop(pre []byte) {
 			n = len(pre)
 			pre = nil
 		} else {
-			n, err = nc.Read(b)
+			if bp != nil {
+				n, err = bp.read(nc, b)
+			} else {
+				n, err = nc.Read(b)
+			}
 			// If we have any data we will try to parse and exit at the end.
 			if n == 0 && err != nil {
 				c.closeConnection(closedStateForErr(err))
@@ -1305,6 +1378,11 @@ func (c *client) readLoop(pre []byte) {
 		acc = c.acc
 		// Refresh nc because in some cases, we have upgraded c.nc to TLS.
 		nc = c.nc
+		// Pick up busy polling, assigned when processing the CONNECT.
+		if bp == nil && c.bp != nil && nc != nil {
+			bp = c.bp
+			bp.start(c, nc)
+		}
 		c.mu.Unlock()
 
 		// Connection was closed
@@ -1817,6 +1895,7 @@ func (c *client) processConnect(arg []byte) error {
 
 	// For headers both client and server need to support.
 	c.headers = supportsHeaders && c.opts.Headers
+	c.cmp = c.negotiateCompression()
 	c.mu.Unlock()
 
 	if srv != nil {
@@ -1861,10 +1940,29 @@ func (c *client) processConnect(arg []byte) error {
 			// By default register with the global account.
 			c.registerWithAccount(srv.globalAccount())
 		}
+
+		// Check that the account can use the listener the client connected through.
+		if c.lst != nil && !c.lst.allowsAccount(c.acc) {
+			c.Errorf("Account %q not allowed on listener %q", c.acc.Name, c.lst.opts.Name)
+			c.authViolation()
+			return ErrAuthentication
+		}
+
+		// Check the TLS requirements of the user and its account, whichever
+		// way the client authenticated.
+		c.mu.Lock()
+		tlsReq := c.tlsReq
+		c.mu.Unlock()
+		if !c.tlsRequirementsMet(tlsReq, c.acc) {
+			c.authViolation()
+			return ErrAuthentication
+		}
 	}
 
 	switch kind {
 	case CLIENT:
+		// Select the client for busy polling if configured.
+		srv.assignBusyPoll(c)
 		// Check client protocol request if it exists.
 		if proto < ClientProtoZero || proto > ClientProtoInfo {
 			c.sendErr(ErrBadClientProtocol.Error())
@@ -2309,7 +2407,8 @@ func (c *client) processHeaderPub(arg []byte) error {
 	}
 	maxPayload := atomic.LoadInt32(&c.mpay)
 	// Use int64() to avoid int32 overrun...
-	if maxPayload != jwt.NoLimit && int64(c.pa.size) > int64(maxPayload) {
+	// Streams chunking their messages accept publishes over it.
+	if maxPayload != jwt.NoLimit && int64(c.pa.size) > int64(maxPayload) && !c.chunkedPubAllowed(c.pa.subject, c.pa.size) {
 		c.maxPayloadViolation(c.pa.size, maxPayload)
 		return ErrMaxPayload
 	}
@@ -2362,7 +2461,8 @@ func (c *client) processPub(arg []byte) error {
 	}
 	maxPayload := atomic.LoadInt32(&c.mpay)
 	// Use int64() to avoid int32 overrun...
-	if maxPayload != jwt.NoLimit && int64(c.pa.size) > int64(maxPayload) {
+	// Streams chunking their messages accept publishes over it.
+	if maxPayload != jwt.NoLimit && int64(c.pa.size) > int64(maxPayload) && !c.chunkedPubAllowed(c.pa.subject, c.pa.size) {
 		c.maxPayloadViolation(c.pa.size, maxPayload)
 		return ErrMaxPayload
 	}
@@ -2432,6 +2532,13 @@ func (c *client) processSubEx(subject, queue, bsid []byte, cb msgHandler, noForw
 	// Create the subscription
 	sub := &subscription{client: c, subject: subject, queue: queue, sid: bsid, icb: cb, si: si, rsi: rsi}
 
+	// Check that this would not bring us over our gateway subject quota.
+	// This is done before grabbing our lock since it needs the gateway interest lock.
+	if !noForward && c.gwSubjectQuotaReached(sub) {
+		c.gwSubjectQuotaExceeded(sub)
+		return nil, ErrGatewaySubjectQuota
+	}
+
 	c.mu.Lock()
 
 	// Indicate activity.
@@ -2534,8 +2641,8 @@ func (c *client) processSubEx(subject, queue, bsid []byte, cb msgHandler, noForw
 	// If we are routing and this is a local sub, add to the route map for the associated account.
 	if kind == CLIENT || kind == SYSTEM || kind == JETSTREAM || kind == ACCOUNT {
 		srv.updateRouteSubscriptionMap(acc, sub, 1)
-		if updateGWs {
-			srv.gatewayUpdateSubInterest(acc.Name, sub, 1)
+		if updateGWs && srv.gatewayUpdateSubInterest(acc.Name, sub, 1) && kind == CLIENT {
+			c.trackGatewaySubject(sub)
 		}
 	}
 	// Now check on leafnode updates.
@@ -2715,14 +2822,34 @@ func (c *client) canSubscribe(subject string, optQueue ...string) bool {
 		return true
 	}
 
-	allowed := true
-
 	// Optional queue group.
 	var queue string
 	if len(optQueue) > 0 {
 		queue = optQueue[0]
 	}
 
+	// Queue permissions can differ from the subject alone so key on both.
+	key := subject
+	if queue != _EMPTY_ {
+		key = subject + " " + queue
+	}
+	dc := c.perms.decisions()
+	allowed, ok := dc.lookup(&dc.sub, key)
+	if !ok {
+		allowed = c.subAllowed(subject, queue)
+		dc.sub.store(key, allowed)
+	}
+	if allowed {
+		c.checkMsgDenyFilter(subject)
+	}
+	return allowed
+}
+
+// subAllowed checks the subscribe permissions without the decision cache.
+// Lock should be held.
+func (c *client) subAllowed(subject, queue string) bool {
+	allowed := true
+
 	// Check allow list. If no allow list that means all are allowed. Deny can overrule.
 	if c.perms.sub.allow != nil {
 		r := c.perms.sub.allow.Match(subject)
@@ -2747,22 +2874,26 @@ func (c *client) canSubscribe(subject string, optQueue ...string) bool {
 			// If the queue appears in the deny list, then DO NOT allow.
 			allowed = !queueMatches(queue, r.qsubs)
 		}
+	}
+	return allowed
+}
 
-		// We use the actual subscription to signal us to spin up the deny mperms
-		// and cache. We check if the subject is a wildcard that contains any of
-		// the deny clauses.
-		// FIXME(dlc) - We could be smarter and track when these go away and remove.
-		if allowed && c.mperms == nil && subjectHasWildcard(subject) {
-			// Whip through the deny array and check if this wildcard subject is within scope.
-			for _, sub := range c.darray {
-				if subjectIsSubsetMatch(sub, subject) {
-					c.loadMsgDenyFilter()
-					break
-				}
-			}
+// We use the actual subscription to signal us to spin up the deny mperms
+// and cache. We check if the subject is a wildcard that contains any of
+// the deny clauses.
+// FIXME(dlc) - We could be smarter and track when these go away and remove.
+// Lock should be held.
+func (c *client) checkMsgDenyFilter(subject string) {
+	if c.mperms != nil || c.perms.sub.deny == nil || !subjectHasWildcard(subject) {
+		return
+	}
+	// Whip through the deny array and check if this wildcard subject is within scope.
+	for _, sub := range c.darray {
+		if subjectIsSubsetMatch(sub, subject) {
+			c.loadMsgDenyFilter()
+			break
 		}
 	}
-	return allowed
 }
 
 func queueMatches(queue string, qsubs [][]*subscription) bool {
@@ -2809,6 +2940,10 @@ func (c *client) unsubscribe(acc *Account, sub *subscription, force, remove bool
 		if acc != nil {
 			acc.sl.Remove(sub)
 		}
+		if sub.gwq {
+			sub.gwq = false
+			atomic.AddInt32(&c.gwsubs, -1)
+		}
 	}
 
 	// Check to see if we have shadow subscriptions.
@@ -3089,6 +3224,12 @@ func (c *client) deliverMsg(prodIsMQTT bool, sub *subscription, acc *Account, su
 		return false
 	}
 	client := sub.client
+
+	// Check with any registered interceptor, do this before grabbing the lock.
+	if client.kind == CLIENT && client.srv != nil && !c.interceptDeliver(client, acc, subject, reply, msg) {
+		return false
+	}
+
 	client.mu.Lock()
 
 	// Check echo
@@ -3163,6 +3304,13 @@ func (c *client) deliverMsg(prodIsMQTT bool, sub *subscription, acc *Account, su
 		msg = msg[c.pa.hdr:]
 	}
 
+	// Compress the payload if this client negotiated compression.
+	if client.cmp != nil && sub.icb == nil && !prodIsMQTT {
+		if cmh, cmsg := c.compressMsgFor(client, mh, msg); cmsg != nil {
+			mh, msg = cmh, cmsg
+		}
+	}
+
 	// Update statistics
 
 	// The msg includes the CR_LF, so pull back out for accounting.
@@ -3395,35 +3543,45 @@ func (c *client) pruneDenyCache() {
 	}
 }
 
-// prunePubPermsCache will prune the cache via randomly
-// deleting items. Doing so pruneSize items at a time.
-func (c *client) prunePubPermsCache() {
-	// There is a case where we can invoke this from multiple go routines,
-	// (in deliverMsg() if sub.client is a LEAF), so we make sure to prune
-	// from only one go routine at a time.
-	if !atomic.CompareAndSwapInt32(&c.perms.prun, 0, 1) {
-		return
-	}
-	const maxPruneAtOnce = 1000
-	r := 0
-	c.perms.pcache.Range(func(k, _ interface{}) bool {
-		c.perms.pcache.Delete(k)
-		if r++; (r > pruneSize && atomic.LoadInt32(&c.perms.pcsz) < int32(maxPermCacheSize)) ||
-			(r > maxPruneAtOnce) {
-			return false
-		}
-		return true
-	})
-	atomic.AddInt32(&c.perms.pcsz, -int32(r))
-	atomic.StoreInt32(&c.perms.prun, 0)
-}
-
 // pubAllowed checks on publish permissioning.
 // Lock should not be held.
 func (c *client) pubAllowed(subject string) bool {
 	return c.pubAllowedFullCheck(subject, true, false)
 }
 
+// pubAllowedAll checks a subject that can have wildcards against our publish
+// permissions. Unlike pubAllowed, the wildcards are not taken literally and all
+// subjects they match need to be allowed. The allow list is optional to check.
+func (c *client) pubAllowedAll(subject string, checkAllow bool) bool {
+	if c.perms == nil {
+		return true
+	}
+	var subs []*subscription
+	if checkAllow && c.perms.pub.allow != nil {
+		c.perms.pub.allow.All(&subs)
+		var allowed bool
+		for _, sub := range subs {
+			if subjectIsSubsetMatch(subject, string(sub.subject)) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	if c.perms.pub.deny != nil {
+		subs = subs[:0]
+		c.perms.pub.deny.All(&subs)
+		for _, sub := range subs {
+			if SubjectsCollide(subject, string(sub.subject)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // pubAllowedFullCheck checks on all publish permissioning depending
 // on the flag for dynamic reply permissions.
 func (c *client) pubAllowedFullCheck(subject string, fullCheck, hasLock bool) bool {
@@ -3431,9 +3589,9 @@ func (c *client) pubAllowedFullCheck(subject string, fullCheck, hasLock bool) bo
 		return true
 	}
 	// Check if published subject is allowed if we have permissions in place.
-	v, ok := c.perms.pcache.Load(subject)
-	if ok {
-		return v.(bool)
+	dc := c.perms.decisions()
+	if allowed, ok := dc.lookup(&dc.pub, subject); ok {
+		return allowed
 	}
 	allowed := true
 	// Cache miss, check allow then deny as needed.
@@ -3448,7 +3606,7 @@ func (c *client) pubAllowedFullCheck(subject string, fullCheck, hasLock bool) bo
 	}
 
 	// If we are currently not allowed but we are tracking reply subjects
-	// dynamically, check to see if we are allowed here but avoid pcache.
+	// dynamically, check to see if we are allowed here but avoid the cache.
 	// We need to acquire the lock though.
 	if !allowed && fullCheck && c.perms.resp != nil {
 		if !hasLock {
@@ -3470,10 +3628,7 @@ func (c *client) pubAllowedFullCheck(subject string, fullCheck, hasLock bool) bo
 		}
 	} else {
 		// Update our cache here.
-		c.perms.pcache.Store(string(subject), allowed)
-		if n := atomic.AddInt32(&c.perms.pcsz, 1); n > maxPermCacheSize {
-			c.prunePubPermsCache()
-		}
+		dc.pub.store(subject, allowed)
 	}
 	return allowed
 }
@@ -3545,9 +3700,11 @@ func (c *client) processInboundClientMsg(msg []byte) (bool, bool) {
 	}
 
 	// Check pub permissions
-	if c.perms != nil && (c.perms.pub.allow != nil || c.perms.pub.deny != nil) && !c.pubAllowed(string(c.pa.subject)) {
-		c.pubPermissionViolation(c.pa.subject)
-		return false, true
+	if c.perms != nil && (c.perms.pub.allow != nil || c.perms.pub.deny != nil) {
+		if !c.pubAllowed(string(c.pa.subject)) {
+			c.pubPermissionViolation(c.pa.subject)
+			return false, true
+		}
 	}
 
 	// Now check for reserved replies. These are used for service imports.
@@ -3556,10 +3713,37 @@ func (c *client) processInboundClientMsg(msg []byte) (bool, bool) {
 		return false, true
 	}
 
+	// Decompress now so that everything past here sees the original message.
+	if c.cmp != nil && c.pa.hdr > 0 {
+		var ok bool
+		if msg, ok = c.decompressInboundMsg(msg); !ok {
+			return false, false
+		}
+	}
+
+	// Some JetStream API requests are permissioned on what they do as well,
+	// which we can only tell from the decompressed request.
+	if c.perms != nil && (c.perms.pub.allow != nil || c.perms.pub.deny != nil) {
+		if denied := c.jsAPIRequestDenied(string(c.pa.subject), msg); denied != _EMPTY_ {
+			c.pubPermissionViolation([]byte(denied))
+			return false, true
+		}
+	}
+
+	// Check with any interceptor registered by an embedding application.
+	if c.kind == CLIENT && !c.interceptPublish(msg) {
+		return false, false
+	}
+
 	if c.opts.Verbose {
 		c.sendOK()
 	}
 
+	// Track our busiest subjects if enabled.
+	if c.kind == CLIENT && c.acc.talkers != nil {
+		c.acc.talkers.record(c.pa.subject, len(msg)-LEN_CR_LF, c.in.start)
+	}
+
 	// If MQTT client, check for retain flag now that we have passed permissions check
 	if c.isMqtt() {
 		c.mqttHandlePubRetain()
@@ -3687,6 +3871,23 @@ func (c *client) processInboundClientMsg(msg []byte) (bool, bool) {
 	return didDeliver, false
 }
 
+// Will let a requestor know that a service is not able to take on their request with a
+// 503 status. Like no responders this requires the requestor to support headers and to
+// be subscribed on this connection.
+func (c *client) sendServiceUnavailable(reply []byte, desc string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.kind != CLIENT || !c.headers {
+		return
+	}
+	if sub := c.subForReply(reply); sub != nil {
+		hdr := fmt.Sprintf("NATS/1.0 503 %s\r\n\r\n", desc)
+		proto := fmt.Sprintf("HMSG %s %s %d %d\r\n%s\r\n", reply, sub.sid, len(hdr), len(hdr), hdr)
+		c.queueOutbound([]byte(proto))
+		c.addToPCD(c)
+	}
+}
+
 // Return the subscription for this reply subject. Only look at normal subs for this client.
 func (c *client) subForReply(reply []byte) *subscription {
 	r := c.acc.sl.Match(string(reply))
@@ -3893,8 +4094,35 @@ func (c *client) processServiceImport(si *serviceImport, acc *Account, msg []byt
 		// TODO(dlc) - Formalize as a service import option for reply rewrite.
 		// For now we can't do $JS.ACK since that breaks pull consumers across accounts.
 		if !bytes.HasPrefix(c.pa.reply, []byte(jsAckPre)) {
+			// Answer from the response cache if we can.
+			var ckey string
+			if !isResponse && si.se.cachingResponses() {
+				_, body := c.msgParts(msg)
+				ckey = svcCacheKey(si.mapRequestSubject(string(c.pa.subject)), body)
+				if e := si.se.cachedResponse(ckey); e != nil && c.deliverCachedResponse(acc, c.pa.reply, e) {
+					return
+				}
+			}
+			// Make sure the service export can take on another request.
+			if !isResponse && si.se != nil {
+				if desc := si.se.checkLimits(acc); desc != _EMPTY_ {
+					c.sendServiceUnavailable(c.pa.reply, desc)
+					return
+				}
+			}
 			if rsi = c.setupResponseServiceImport(acc, si, tracking, headers); rsi != nil {
 				nrr = []byte(rsi.from)
+				if ckey != _EMPTY_ {
+					si.acc.mu.Lock()
+					rsi.ckey = ckey
+					si.acc.mu.Unlock()
+				}
+				// Streamed JetStream lists send many responses, and are cleaned up by the response threshold.
+				if _, body := c.msgParts(msg); si.to == jsAllAPI && isStreamingListRequest(string(c.pa.subject), body) {
+					si.acc.mu.Lock()
+					rsi.rt = Streamed
+					si.acc.mu.Unlock()
+				}
 			}
 		} else {
 			// This only happens when we do a pull subscriber that trampolines through another account.
@@ -3916,14 +4144,8 @@ func (c *client) processServiceImport(si *serviceImport, acc *Account, msg []byt
 	}
 
 	// Pick correct "to" subject. If we matched on a wildcard use the literal publish subject.
-	to, subject := si.to, string(c.pa.subject)
-
-	if si.tr != nil {
-		// FIXME(dlc) - This could be slow, may want to look at adding cache to bare transforms?
-		to, _ = si.tr.transformSubject(subject)
-	} else if si.usePub {
-		to = subject
-	}
+	subject := string(c.pa.subject)
+	to := si.mapRequestSubject(subject)
 
 	// Copy our pubArg since this gets modified as we process the service import itself.
 	pacopy := c.pa
@@ -4028,6 +4250,16 @@ func (c *client) processServiceImport(si *serviceImport, acc *Account, msg []byt
 	c.in.rts = orts
 	c.pa = pacopy
 
+	// Cache the response if this was for a cacheable request.
+	if isResponse && didDeliver && si.se != nil {
+		acc.mu.RLock()
+		ckey := si.ckey
+		acc.mu.RUnlock()
+		if ckey != _EMPTY_ {
+			si.se.cacheResponse(ckey, msg, c.pa.hdr)
+		}
+	}
+
 	// Determine if we should remove this service import. This is for response service imports.
 	// We will remove if we did not deliver, or if we are a response service import and we are
 	// a singleton, or we have an EOF message.
@@ -4102,6 +4334,8 @@ func (c *client) processMsgResults(acc *Account, r *SublistResult, msg, deliver,
 	if c.in.rts != nil {
 		c.in.rts = c.in.rts[:0]
 	}
+	// Any compressed message we have is for a previous message.
+	c.cmsg = nil
 
 	var rplyHasGWPrefix bool
 	var creply = reply
@@ -4547,10 +4781,7 @@ func (c *client) processPingTimer() {
 
 	var sendPing bool
 
-	pingInterval := c.srv.getOpts().PingInterval
-	if c.kind == GATEWAY {
-		pingInterval = adjustPingIntervalForGateway(pingInterval)
-	}
+	pingInterval := c.pingInterval()
 	now := time.Now()
 	needRTT := c.rtt == 0 || now.Sub(c.rttStart) > DEFAULT_RTT_MEASUREMENT_INTERVAL
 
@@ -4572,7 +4803,7 @@ func (c *client) processPingTimer() {
 
 	if sendPing {
 		// Check for violation
-		if c.ping.out+1 > c.srv.getOpts().MaxPingsOut {
+		if c.ping.out+1 > c.maxPingsOut() {
 			c.Debugf("Stale Client Connection - Closing")
 			c.enqueueProto([]byte(fmt.Sprintf(errProto, "Stale Connection")))
 			c.mu.Unlock()
@@ -4602,11 +4833,67 @@ func (c *client) setPingTimer() {
 	if c.srv == nil {
 		return
 	}
+	c.ping.tmr = time.AfterFunc(c.pingInterval(), c.processPingTimer)
+}
+
+// Returns the ping interval for this connection, taking into account
+// any account or listener override.
+// Lock should be held
+func (c *client) pingInterval() time.Duration {
+	if c.ping.intv > 0 {
+		return c.ping.intv
+	}
 	d := c.srv.getOpts().PingInterval
 	if c.kind == GATEWAY {
 		d = adjustPingIntervalForGateway(d)
 	}
-	c.ping.tmr = time.AfterFunc(d, c.processPingTimer)
+	return d
+}
+
+// Returns the max pings outstanding for this connection, taking into
+// account any account or listener override.
+// Lock should be held
+func (c *client) maxPingsOut() int {
+	if c.ping.max > 0 {
+		return c.ping.max
+	}
+	return c.srv.getOpts().MaxPingsOut
+}
+
+// Applies any ping interval, max pings outstanding and write deadline
+// overrides for client connections. Account values take precedence over
+// the websocket listener's, which take precedence over the server's.
+// Lock should be held
+func (c *client) applyKeepaliveOverrides() {
+	if c.kind != CLIENT || c.srv == nil {
+		return
+	}
+	opts := c.srv.getOpts()
+	intv, mpo, wdl := time.Duration(0), 0, opts.WriteDeadline
+	if c.isWebsocket() {
+		wo := &opts.Websocket
+		intv, mpo = wo.PingInterval, wo.MaxPingsOut
+		if wo.WriteDeadline > 0 {
+			wdl = wo.WriteDeadline
+		}
+	}
+	if acc := c.acc; acc != nil {
+		if acc.pingInterval > 0 {
+			intv = acc.pingInterval
+		}
+		if acc.maxPingsOut > 0 {
+			mpo = acc.maxPingsOut
+		}
+		if acc.writeDeadline > 0 {
+			wdl = acc.writeDeadline
+		}
+	}
+	reset := intv != c.ping.intv
+	c.ping.intv, c.ping.max, c.out.wdl = intv, mpo, wdl
+	// If our interval changed, e.g. once authenticated, pick it up now.
+	if reset && c.ping.tmr != nil {
+		c.ping.tmr.Reset(c.pingInterval())
+	}
 }
 
 // Lock should be held
@@ -4618,6 +4905,82 @@ func (c *client) clearPingTimer() {
 	c.ping.tmr = nil
 }
 
+// Bounds on how often we check if a connection is idle.
+const (
+	minIdleCheckInterval = 100 * time.Millisecond
+	maxIdleCheckInterval = time.Minute
+)
+
+// Returns how often to check for idleness for the given timeout.
+func idleCheckInterval(to time.Duration) time.Duration {
+	d := to / 4
+	if d < minIdleCheckInterval {
+		return minIdleCheckInterval
+	} else if d > maxIdleCheckInterval {
+		return maxIdleCheckInterval
+	}
+	return d
+}
+
+// Starts or stops checking for idleness based on our account's idle timeout.
+// Lock should be held
+func (c *client) setIdleTimer() {
+	var to time.Duration
+	if c.kind == CLIENT && c.acc != nil {
+		to = c.acc.idleTimeout
+	}
+	c.idle.to = to
+	if to <= 0 {
+		c.clearIdleTimer()
+		return
+	}
+	if c.idle.tmr == nil {
+		if c.idle.last.IsZero() {
+			c.idle.last = time.Now()
+		}
+		c.idle.tmr = time.AfterFunc(idleCheckInterval(to), c.processIdleTimer)
+	}
+}
+
+// Lock should be held
+func (c *client) clearIdleTimer() {
+	if c.idle.tmr == nil {
+		return
+	}
+	c.idle.tmr.Stop()
+	c.idle.tmr = nil
+}
+
+// Closes the connection if it has had no subscriptions and no messages
+// in or out for our idle timeout. Connections in the system account are
+// never closed. The disconnect advisory will carry the reason.
+func (c *client) processIdleTimer() {
+	c.mu.Lock()
+	c.idle.tmr = nil
+	to, acc, srv := c.idle.to, c.acc, c.srv
+	if c.isClosed() || to <= 0 {
+		c.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if msgs := atomic.LoadInt64(&c.inMsgs) + c.outMsgs; msgs != c.idle.msgs || len(c.subs) > 0 {
+		c.idle.msgs, c.idle.last = msgs, now
+	}
+	idle := now.Sub(c.idle.last)
+	if idle < to {
+		c.idle.tmr = time.AfterFunc(idleCheckInterval(to), c.processIdleTimer)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	if acc == srv.SystemAccount() {
+		return
+	}
+	c.Debugf("Idle Client Connection for %v - Closing", idle.Round(time.Millisecond))
+	c.closeConnection(IdleConnection)
+}
+
 func (c *client) clearTlsToTimer() {
 	if c.tlsTo == nil {
 		return
@@ -4803,8 +5166,10 @@ func (c *client) closeConnection(reason ClosedState) {
 	c.flags.set(closeConnection)
 	c.clearAuthTimer()
 	c.clearPingTimer()
+	c.clearIdleTimer()
 	c.clearTlsToTimer()
 	c.markConnAsClosed(reason)
+	c.releasePermDecisions()
 
 	// Unblock anyone who is potentially stalled waiting on us.
 	if c.out.stc != nil {
@@ -5445,16 +5810,13 @@ func (c *client) setFirstPingTimer() {
 		return
 	}
 	opts := s.getOpts()
-	d := opts.PingInterval
+	d := c.pingInterval()
 
 	if !opts.DisableShortFirstPing {
 		if c.kind != CLIENT {
 			if d > firstPingInterval {
 				d = firstPingInterval
 			}
-			if c.kind == GATEWAY {
-				d = adjustPingIntervalForGateway(d)
-			}
 		} else if d > firstClientPingInterval {
 			d = firstClientPingInterval
 		}
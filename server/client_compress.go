@@ -0,0 +1,172 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/nats-io/jwt/v2"
+)
+
+const (
+	// CompressionHdr marks a message payload as compressed, the value is the algorithm used.
+	CompressionHdr = "Nats-Compression"
+	// CompressionS2 is the payload compression clients can negotiate.
+	CompressionS2 = "s2"
+	// Payloads smaller than this are not compressed for a client unless it asks for a threshold.
+	defaultClientCompressionThreshold = 1024
+)
+
+// Payload compression negotiated by a client in its CONNECT.
+// Compressed messages published by the client are decompressed when received, so
+// subscribers that did not negotiate compression, and the server itself, never see
+// them. Messages of at least threshold bytes are compressed when delivered to it.
+type clientCompression struct {
+	threshold int
+}
+
+// The compressed form of the message a producer is delivering, so we only
+// compress once no matter how many subscribers negotiated compression.
+type compressedMsg struct {
+	src []byte
+	hdr int
+	msg []byte // nil when not worth compressing.
+}
+
+// Will return the compression for a client based on its CONNECT, if any.
+// Lock should be held.
+func (c *client) negotiateCompression() *clientCompression {
+	if c.kind != CLIENT || !c.headers || c.opts.Compression != CompressionS2 || !c.srv.supportsClientCompression() {
+		return nil
+	}
+	threshold := c.opts.CompressionThreshold
+	if threshold <= 0 {
+		threshold = defaultClientCompressionThreshold
+	}
+	return &clientCompression{threshold: threshold}
+}
+
+// Returns if clients can negotiate payload compression.
+func (s *Server) supportsClientCompression() bool {
+	if s == nil {
+		return false
+	}
+	opts := s.getOpts()
+	return !opts.NoHeaderSupport && !opts.NoClientCompression
+}
+
+// Will decompress the payload of a message published by a client that negotiated
+// compression, if marked as such, and update the pubArgs.
+// Returns false if the message should be dropped.
+func (c *client) decompressInboundMsg(msg []byte) ([]byte, bool) {
+	hdr := msg[:c.pa.hdr]
+	alg := getHeader(CompressionHdr, hdr)
+	if alg == nil {
+		return msg, true
+	}
+	payload := msg[c.pa.hdr : len(msg)-LEN_CR_LF]
+	n, err := s2.DecodedLen(payload)
+	if err != nil || string(alg) != CompressionS2 {
+		c.sendErr("Invalid Compressed Payload")
+		return nil, false
+	}
+	nhdr := removeHeaderIfPresent(append([]byte(nil), hdr...), CompressionHdr)
+	if maxPayload := atomic.LoadInt32(&c.mpay); maxPayload != jwt.NoLimit && int64(len(nhdr)+n) > int64(maxPayload) {
+		c.maxPayloadViolation(len(nhdr)+n, maxPayload)
+		return nil, false
+	}
+	nmsg := make([]byte, len(nhdr)+n, len(nhdr)+n+LEN_CR_LF)
+	copy(nmsg, nhdr)
+	if _, err := s2.Decode(nmsg[len(nhdr):], payload); err != nil {
+		c.sendErr("Invalid Compressed Payload")
+		return nil, false
+	}
+	nmsg = append(nmsg, _CRLF_...)
+
+	// Update pubArgs.
+	if len(nhdr) > 0 {
+		c.pa.hdr = len(nhdr)
+		c.pa.hdb = []byte(strconv.Itoa(c.pa.hdr))
+	} else {
+		c.pa.hdr, c.pa.hdb = -1, nil
+	}
+	c.pa.size = len(nmsg) - LEN_CR_LF
+	c.pa.szb = []byte(strconv.Itoa(c.pa.size))
+	return nmsg, true
+}
+
+// Will return the protocol line and payload to deliver msg compressed to a client
+// that negotiated compression, or nil if it should be delivered as is.
+// Should be called from the producer's go routine, client lock held.
+func (c *client) compressMsgFor(client *client, mh, msg []byte) ([]byte, []byte) {
+	hdr := 0
+	if c.pa.hdr > 0 {
+		hdr = c.pa.hdr
+	}
+	if len(msg)-hdr-LEN_CR_LF < client.cmp.threshold {
+		return nil, nil
+	}
+	cm := c.cmsg
+	if cm == nil || len(cm.src) != len(msg) || &cm.src[0] != &msg[0] {
+		cm = &compressedMsg{src: msg}
+		c.cmsg = cm
+		if hdr == 0 || getHeader(CompressionHdr, msg[:hdr]) == nil {
+			payload := msg[hdr : len(msg)-LEN_CR_LF]
+			var nhdr []byte
+			if hdr > 0 {
+				nhdr = genHeader(msg[:hdr], CompressionHdr, CompressionS2)
+			} else {
+				nhdr = genHeader(nil, CompressionHdr, CompressionS2)
+			}
+			// Only worth it if we actually save something.
+			if enc := s2.Encode(nil, payload); len(nhdr)+len(enc) < hdr+len(payload) {
+				cm.hdr = len(nhdr)
+				cm.msg = make([]byte, 0, len(nhdr)+len(enc)+LEN_CR_LF)
+				cm.msg = append(cm.msg, nhdr...)
+				cm.msg = append(cm.msg, enc...)
+				cm.msg = append(cm.msg, _CRLF_...)
+			}
+		}
+	}
+	if cm.msg == nil {
+		return nil, nil
+	}
+	return rebuildMsgProto(mh, cm.hdr, len(cm.msg)-LEN_CR_LF), cm.msg
+}
+
+// Will rebuild a MSG or HMSG protocol line for a payload with a header of size hdr
+// and a total size of size.
+func rebuildMsgProto(mh []byte, hdr, size int) []byte {
+	line := mh[:len(mh)-LEN_CR_LF]
+	// Drop the sizes.
+	line = line[:bytes.LastIndexByte(line, ' ')]
+	if line[0] == 'H' {
+		line = line[1:bytes.LastIndexByte(line, ' ')]
+	}
+	nmh := make([]byte, 0, len(line)+24)
+	if hdr > 0 {
+		nmh = append(nmh, 'H')
+	}
+	nmh = append(nmh, line...)
+	nmh = append(nmh, ' ')
+	if hdr > 0 {
+		nmh = strconv.AppendInt(nmh, int64(hdr), 10)
+		nmh = append(nmh, ' ')
+	}
+	nmh = strconv.AppendInt(nmh, int64(size), 10)
+	return append(nmh, _CRLF_...)
+}
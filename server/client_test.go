@@ -25,6 +25,7 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -34,6 +35,7 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 
+	"github.com/klauspost/compress/s2"
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nkeys"
@@ -1040,6 +1042,114 @@ func TestQueueSubscribePermissions(t *testing.T) {
 	}
 }
 
+func TestClientSharedPermissionDecisions(t *testing.T) {
+	s := RunServer(DefaultOptions())
+	defer s.Shutdown()
+
+	perms := &Permissions{
+		Publish:   &SubjectPermission{Allow: []string{"foo.>"}, Deny: []string{"foo.bar"}},
+		Subscribe: &SubjectPermission{Allow: []string{"foo", "bar baz"}},
+	}
+	newClient := func(perms *Permissions) *client {
+		c := &client{srv: s, kind: CLIENT, acc: s.globalAccount()}
+		c.mu.Lock()
+		c.setPermissions(perms)
+		c.mu.Unlock()
+		return c
+	}
+	c1, c2 := newClient(perms), newClient(perms)
+	if c1.perms.decisions() != c2.perms.decisions() {
+		t.Fatalf("Expected connections with the same permissions to share decisions")
+	}
+	c3 := newClient(&Permissions{Publish: &SubjectPermission{Allow: []string{">"}}})
+	if c1.perms.decisions() == c3.perms.decisions() {
+		t.Fatalf("Expected connections with different permissions to not share decisions")
+	}
+
+	base := s.permCache.stats()
+	require_True(t, c1.pubAllowed("foo.baz"))
+	require_False(t, c1.pubAllowed("foo.bar"))
+	// These should be hits from the decisions made for c1.
+	require_True(t, c2.pubAllowed("foo.baz"))
+	require_False(t, c2.pubAllowed("foo.bar"))
+
+	// Queue subscriptions are cached separately from plain ones.
+	c1.mu.Lock()
+	require_False(t, c1.canSubscribe("bar"))
+	require_True(t, c1.canSubscribe("bar", "baz"))
+	require_False(t, c1.canSubscribe("bar", "other"))
+	c1.mu.Unlock()
+	c2.mu.Lock()
+	require_True(t, c2.canSubscribe("bar", "baz"))
+	require_False(t, c2.canSubscribe("bar"))
+	c2.mu.Unlock()
+
+	ps := s.permCache.stats()
+	if hits, misses := ps.Hits-base.Hits, ps.Misses-base.Misses; hits != 4 || misses != 5 {
+		t.Fatalf("Expected 4 hits and 5 misses, got %d and %d", hits, misses)
+	}
+
+	// Merging denies should move to different decisions.
+	c2.mu.Lock()
+	c2.mergeDenyPermissions(pub, []string{"foo.baz"})
+	c2.mu.Unlock()
+	require_True(t, c1.pubAllowed("foo.baz"))
+	require_False(t, c2.pubAllowed("foo.baz"))
+
+	// Invalidating the account should clear the decisions.
+	s.permCache.invalidate(globalAccountName)
+	if ps := s.permCache.stats(); ps.Invalidations == 0 {
+		t.Fatalf("Expected invalidations to be reported")
+	}
+	base = s.permCache.stats()
+	c2.mu.Lock()
+	require_True(t, c2.canSubscribe("bar", "baz"))
+	c2.mu.Unlock()
+	if ps := s.permCache.stats(); ps.Misses-base.Misses != 1 {
+		t.Fatalf("Expected a miss after invalidation, got %d", ps.Misses-base.Misses)
+	}
+
+	v, err := s.Varz(nil)
+	require_NoError(t, err)
+	if v.PermCache == nil || v.PermCache.Sets < 3 || v.PermCache.Hits == 0 {
+		t.Fatalf("Unexpected permission cache stats: %+v", v.PermCache)
+	}
+
+	// Decisions no client uses anymore are dropped.
+	sets := s.permCache.stats().Sets
+	c3.mu.Lock()
+	c3.setPermissions(&Permissions{Publish: &SubjectPermission{Allow: []string{"other"}}})
+	c3.mu.Unlock()
+	require_True(t, s.permCache.stats().Sets == sets)
+}
+
+func TestClientPermissionDecisionsReleasedOnClose(t *testing.T) {
+	o := DefaultOptions()
+	for i := 0; i < 10; i++ {
+		o.Users = append(o.Users, &User{
+			Username:    fmt.Sprintf("u%d", i),
+			Password:    "pwd",
+			Permissions: &Permissions{Publish: &SubjectPermission{Allow: []string{fmt.Sprintf("u%d.>", i)}}},
+		})
+	}
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	sets := s.permCache.stats().Sets
+	for i := 0; i < 10; i++ {
+		nc := natsConnect(t, s.ClientURL(), nats.UserInfo(fmt.Sprintf("u%d", i), "pwd"))
+		natsFlush(t, nc)
+		require_True(t, s.permCache.stats().Sets == sets+1)
+		nc.Close()
+		checkFor(t, time.Second, 15*time.Millisecond, func() error {
+			if n := s.permCache.stats().Sets; n != sets {
+				return fmt.Errorf("Expected %d decision sets, got %d", sets, n)
+			}
+			return nil
+		})
+	}
+}
+
 func TestClientPubWithQueueSubNoEcho(t *testing.T) {
 	opts := DefaultOptions()
 	s := RunServer(opts)
@@ -2604,3 +2714,144 @@ func TestClientAuthRequiredNoAuthUser(t *testing.T) {
 		t.Fatalf("Expected AuthRequired to be false due to 'no_auth_user'")
 	}
 }
+
+func TestClientIdleTimeout(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		system_account: SYS
+		accounts {
+			IOT {
+				users [{user: iot, password: pwd}]
+				idle_timeout: "250ms"
+			}
+			SYS {
+				users [{user: sys, password: pwd}]
+				idle_timeout: "250ms"
+			}
+		}
+	`))
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	ncSys := natsConnect(t, s.ClientURL(), nats.UserInfo("sys", "pwd"))
+	defer ncSys.Close()
+	dsub := natsSubSync(t, ncSys, fmt.Sprintf(disconnectEventSubj, "IOT"))
+	natsFlush(t, ncSys)
+
+	closed := make(chan struct{})
+	nc := natsConnect(t, s.ClientURL(), nats.UserInfo("iot", "pwd"),
+		nats.NoReconnect(), nats.ClosedHandler(func(*nats.Conn) { close(closed) }))
+	defer nc.Close()
+
+	// One with a subscription is not idle.
+	ncSub := natsConnect(t, s.ClientURL(), nats.UserInfo("iot", "pwd"))
+	defer ncSub.Close()
+	natsSubSync(t, ncSub, "foo")
+	natsFlush(t, ncSub)
+
+	// Nor one sending messages.
+	ncPub := natsConnect(t, s.ClientURL(), nats.UserInfo("iot", "pwd"))
+	defer ncPub.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Millisecond):
+				ncPub.Publish("bar", nil)
+			}
+		}
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Idle connection was not closed")
+	}
+
+	msg := natsNexMsg(t, dsub, time.Second)
+	var dem DisconnectEventMsg
+	require_NoError(t, json.Unmarshal(msg.Data, &dem))
+	require_True(t, dem.Reason == IdleConnection.String())
+
+	// Give the others time to be checked again.
+	time.Sleep(500 * time.Millisecond)
+	require_True(t, ncSub.IsConnected())
+	require_True(t, ncPub.IsConnected())
+	// System account connections are never closed.
+	require_True(t, ncSys.IsConnected())
+}
+
+func TestClientCompressionNegotiation(t *testing.T) {
+	o := DefaultOptions()
+	s := RunServer(o)
+	defer s.Shutdown()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(o.Host, strconv.Itoa(o.Port)))
+	require_NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+
+	l, err := br.ReadString('\n')
+	require_NoError(t, err)
+	var info Info
+	require_NoError(t, json.Unmarshal([]byte(l[5:]), &info))
+	require_Equal(t, info.Compression, CompressionS2)
+
+	_, err = conn.Write([]byte("CONNECT {\"verbose\":false,\"echo\":false,\"headers\":true,\"compression\":\"s2\",\"compression_threshold\":64}\r\nSUB foo 1\r\nPING\r\n"))
+	require_NoError(t, err)
+	l, err = br.ReadString('\n')
+	require_NoError(t, err)
+	require_Equal(t, l, "PONG\r\n")
+
+	// Reads a message and returns its header and payload.
+	readMsg := func() (string, []byte) {
+		t.Helper()
+		l, err := br.ReadString('\n')
+		require_NoError(t, err)
+		args := strings.Fields(l)
+		hdr, size := 0, 0
+		if args[0] == "HMSG" {
+			hdr, size = int(parseSize([]byte(args[3]))), int(parseSize([]byte(args[4])))
+		} else {
+			size = int(parseSize([]byte(args[3])))
+		}
+		buf := make([]byte, size+LEN_CR_LF)
+		_, err = io.ReadFull(br, buf)
+		require_NoError(t, err)
+		return string(buf[:hdr]), buf[hdr:size]
+	}
+
+	nc := natsConnect(t, s.ClientURL())
+	defer nc.Close()
+	sub := natsSubSync(t, nc, "foo")
+	natsFlush(t, nc)
+
+	// Compressed messages are decompressed for subscribers that did not ask for compression.
+	data := bytes.Repeat([]byte("compress me "), 100)
+	enc := s2.Encode(nil, data)
+	hdr := fmt.Sprintf("NATS/1.0\r\n%s: %s\r\n\r\n", CompressionHdr, CompressionS2)
+	_, err = conn.Write([]byte(fmt.Sprintf("HPUB foo %d %d\r\n%s%s\r\n", len(hdr), len(hdr)+len(enc), hdr, enc)))
+	require_NoError(t, err)
+	m := natsNexMsg(t, sub, time.Second)
+	require_True(t, bytes.Equal(m.Data, data))
+	require_True(t, len(m.Header) == 0)
+
+	// Large enough messages are compressed when delivered to us.
+	natsPub(t, nc, "foo", data)
+	mhdr, payload := readMsg()
+	require_True(t, getHeader(CompressionHdr, []byte(mhdr)) != nil)
+	require_True(t, len(payload) < len(data))
+	dec, err := s2.Decode(nil, payload)
+	require_NoError(t, err)
+	require_True(t, bytes.Equal(dec, data))
+
+	// Small ones are not.
+	natsPub(t, nc, "foo", []byte("small"))
+	mhdr, payload = readMsg()
+	require_Equal(t, mhdr, _EMPTY_)
+	require_Equal(t, string(payload), "small")
+}
@@ -51,6 +51,12 @@ type ConsumerInfo struct {
 	NumPending     uint64          `json:"num_pending"`
 	Cluster        *ClusterInfo    `json:"cluster,omitempty"`
 	PushBound      bool            `json:"push_bound,omitempty"`
+	// Messages allowed in flight when adaptive prefetch is on.
+	PrefetchWindow int `json:"prefetch_window,omitempty"`
+	// Bytes of the messages pending an ack, only tracked with MaxBytesPending.
+	NumAckPendingBytes int64 `json:"num_ack_pending_bytes,omitempty"`
+	// Paused since our stream is idle, see StreamConfig.IdlePause.
+	Idle bool `json:"idle,omitempty"`
 }
 
 type ConsumerConfig struct {
@@ -74,6 +80,18 @@ type ConsumerConfig struct {
 	Heartbeat       time.Duration   `json:"idle_heartbeat,omitempty"`
 	FlowControl     bool            `json:"flow_control,omitempty"`
 	HeadersOnly     bool            `json:"headers_only,omitempty"`
+	// Deliver tombstone records left by removed messages, these are skipped otherwise.
+	DeliverTombstones bool `json:"deliver_tombstones,omitempty"`
+	// Republish messages that exceeded MaxDeliver to this subject.
+	DeadLetterSubject string `json:"dead_letter_subject,omitempty"`
+	// Deliver messages compressed by the stream as stored, with their JSCompressed header.
+	DeliverCompressed bool `json:"deliver_compressed,omitempty"`
+	// The consumption group of a work queue stream this consumer belongs to.
+	ConsumptionGroup string `json:"consumption_group,omitempty"`
+	// Bytes of the messages allowed pending an ack, next to MaxAckPending.
+	MaxBytesPending int64 `json:"max_bytes_pending,omitempty"`
+	// Deliver the chunks of messages chunked by the stream as the message they make up.
+	ReassembleChunks bool `json:"reassemble_chunks,omitempty"`
 
 	// Pull based options.
 	MaxRequestBatch    int           `json:"max_batch,omitempty"`
@@ -83,6 +101,8 @@ type ConsumerConfig struct {
 	// Push based consumers.
 	DeliverSubject string `json:"deliver_subject,omitempty"`
 	DeliverGroup   string `json:"deliver_group,omitempty"`
+	// Adjust how many messages are in flight, up to MaxAckPending, to how fast they are acked.
+	AdaptivePrefetch bool `json:"adaptive_prefetch,omitempty"`
 
 	// Ephemeral inactivity threshold.
 	InactiveThreshold time.Duration `json:"inactive_threshold,omitempty"`
@@ -254,6 +274,8 @@ type consumer struct {
 	ackSubj           string
 	nextMsgSubj       string
 	maxp              int
+	pwnd              int    // Adaptive prefetch window, 0 if not adaptive.
+	rahead            uint64 // Stream sequence at which we next ask the store to read ahead.
 	pblimit           int
 	maxpb             int
 	pbytes            int
@@ -263,6 +285,7 @@ type consumer struct {
 	outq              *jsOutQ
 	pending           map[uint64]*Pending
 	ptmr              *time.Timer
+	idle              bool
 	rdq               []uint64
 	rdqi              map[uint64]struct{}
 	rdc               map[uint64]uint64
@@ -276,6 +299,8 @@ type consumer struct {
 	filterWC          bool
 	dtmr              *time.Timer
 	gwdtmr            *time.Timer
+	rbtmr             *time.Timer
+	members           []ConsumerGroupMember
 	dthresh           time.Duration
 	mch               chan struct{}
 	qch               chan struct{}
@@ -284,11 +309,19 @@ type consumer struct {
 	ackEventT         string
 	nakEventT         string
 	deliveryExcEventT string
+	advl              map[string]*rateCounter
+	advtmr            *time.Timer
+	hist              statsHistory
 	created           time.Time
 	ldt               time.Time
 	lat               time.Time
 	closed            bool
 
+	// MaxBytesPending, with the bytes and sizes of the messages pending an ack while set.
+	maxab int64
+	ab    int64
+	absz  map[uint64]int64
+
 	// Clustered.
 	ca        *consumerAssignment
 	node      RaftNode
@@ -327,6 +360,8 @@ const (
 	JsFlowControlMaxPending = 32 * 1024 * 1024
 	// JsDefaultMaxAckPending is set for consumers with explicit ack that do not set the max ack pending.
 	JsDefaultMaxAckPending = 1000
+	// JsDefaultAdvisoryWindow is the window used for advisory rate limiting when none is configured.
+	JsDefaultAdvisoryWindow = 10 * time.Second
 )
 
 // Helper function to set consumer config defaults from above.
@@ -402,6 +437,21 @@ func checkConsumerCfg(
 		return NewJSConsumerDescriptionTooLongError(JSMaxDescriptionLen)
 	}
 
+	if err := checkConsumerConsumptionGroup(cfg, config); err != nil {
+		return NewJSConsumerConsumptionGroupInvalidError(err)
+	}
+
+	if dls := config.DeadLetterSubject; dls != _EMPTY_ {
+		switch {
+		case !IsValidPublishSubject(dls):
+			return NewJSConsumerDeadLetterSubjectInvalidError(fmt.Errorf("%q is not a valid publish subject", dls))
+		case config.MaxDeliver <= 0:
+			return NewJSConsumerDeadLetterSubjectInvalidError(errors.New("max deliver is required"))
+		case dls == config.DeliverSubject:
+			return NewJSConsumerDeadLetterSubjectInvalidError(errors.New("can not be the deliver subject"))
+		}
+	}
+
 	// For now expect a literal subject if its not empty. Empty means work queue mode (pull mode).
 	if config.DeliverSubject != _EMPTY_ {
 		if !subjectIsLiteral(config.DeliverSubject) {
@@ -416,12 +466,15 @@ func checkConsumerCfg(
 		if config.MaxWaiting != 0 {
 			return NewJSConsumerPushMaxWaitingError()
 		}
-		if config.MaxAckPending > 0 && config.AckPolicy == AckNone {
+		if (config.MaxAckPending > 0 || config.MaxBytesPending > 0) && config.AckPolicy == AckNone {
 			return NewJSConsumerMaxPendingAckPolicyRequiredError()
 		}
 		if config.Heartbeat > 0 && config.Heartbeat < 100*time.Millisecond {
 			return NewJSConsumerSmallHeartbeatError()
 		}
+		if config.AdaptivePrefetch && config.AckPolicy != AckExplicit {
+			return NewJSConsumerInvalidPolicyError(errors.New("adaptive prefetch requires explicit acks"))
+		}
 	} else {
 		// Pull mode with work queue retention from the stream requires an explicit ack.
 		if config.AckPolicy == AckNone && cfg.Retention == WorkQueuePolicy {
@@ -439,6 +492,9 @@ func checkConsumerCfg(
 		if config.FlowControl {
 			return NewJSConsumerFCRequiresPushError()
 		}
+		if config.AdaptivePrefetch {
+			return NewJSConsumerInvalidPolicyError(errors.New("adaptive prefetch requires a push consumer"))
+		}
 		if config.MaxRequestBatch < 0 {
 			return NewJSConsumerMaxRequestBatchNegativeError()
 		}
@@ -452,6 +508,9 @@ func checkConsumerCfg(
 	if srvLim.MaxAckPending > 0 && config.MaxAckPending > srvLim.MaxAckPending {
 		return NewJSConsumerMaxPendingAckExcessError(srvLim.MaxAckPending)
 	}
+	if config.MaxBytesPending < 0 {
+		return NewJSConsumerInvalidPolicyError(errors.New("max bytes pending can not be negative"))
+	}
 	if accLim.MaxAckPending > 0 && config.MaxAckPending > accLim.MaxAckPending {
 		return NewJSConsumerMaxPendingAckExcessError(accLim.MaxAckPending)
 	}
@@ -658,11 +717,12 @@ func (mset *stream) addConsumerWithAssignment(config *ConsumerConfig, oname stri
 			return nil, NewJSConsumerWQRequiresExplicitAckError()
 		}
 
-		if len(mset.consumers) > 0 {
+		// Consumers only need to be unique within their consumption group.
+		if mset.numGroupConsumers(config.ConsumptionGroup) > 0 {
 			if config.FilterSubject == _EMPTY_ {
 				mset.mu.Unlock()
 				return nil, NewJSConsumerWQMultipleUnfilteredError()
-			} else if !mset.partitionUnique(config.FilterSubject) {
+			} else if !mset.partitionUnique(config.ConsumptionGroup, config.FilterSubject) {
 				// Prior to v2.9.7, on a stream with WorkQueue policy, the servers
 				// were not catching the error of having multiple consumers with
 				// overlapping filter subjects depending on the scope, for instance
@@ -708,9 +768,11 @@ func (mset *stream) addConsumerWithAssignment(config *ConsumerConfig, oname stri
 		sfreq:     int32(sampleFreq),
 		maxdc:     uint64(config.MaxDeliver),
 		maxp:      config.MaxAckPending,
+		maxab:     config.MaxBytesPending,
 		retention: retention,
 		created:   time.Now().UTC(),
 	}
+	o.setAdaptivePrefetch(config.AdaptivePrefetch)
 
 	// Bind internal client to the user account.
 	o.client.registerWithAccount(a)
@@ -759,6 +821,21 @@ func (mset *stream) addConsumerWithAssignment(config *ConsumerConfig, oname stri
 	o.nakEventT = JSAdvisoryConsumerMsgNakPre + "." + o.stream + "." + o.name
 	o.deliveryExcEventT = JSAdvisoryConsumerMaxDeliveryExceedPre + "." + o.stream + "." + o.name
 
+	// Setup advisory rate limiting if configured.
+	if lim := s.getOpts().JetStreamLimits; lim.MaxAdvisories > 0 {
+		window := lim.AdvisoryWindow
+		if window <= 0 {
+			window = JsDefaultAdvisoryWindow
+		}
+		termEventT := JSAdvisoryConsumerMsgTerminatedPre + "." + o.stream + "." + o.name
+		o.advl = make(map[string]*rateCounter)
+		for _, subj := range []string{o.nakEventT, termEventT, o.deliveryExcEventT} {
+			rc := newRateCounter(int64(lim.MaxAdvisories))
+			rc.interval = window
+			o.advl[subj] = rc
+		}
+	}
+
 	if !isValidName(o.name) {
 		mset.mu.Unlock()
 		o.deleteWithoutAdvisory()
@@ -1050,6 +1127,12 @@ func (o *consumer) setLeader(isLeader bool) {
 				stopAndClearTimer(&o.gwdtmr)
 				o.gwdtmr = time.AfterFunc(time.Second, func() { o.watchGWinterest() })
 			}
+			// Let applications know when members of our queue group come and go.
+			if o.cfg.DeliverGroup != _EMPTY_ {
+				o.members = o.groupMembers()
+				stopAndClearTimer(&o.rbtmr)
+				o.rbtmr = time.AfterFunc(consumerGroupCheckInterval, o.watchGroupMembers)
+			}
 		}
 
 		if o.dthresh > 0 && (o.isPullMode() || !o.active) {
@@ -1104,6 +1187,7 @@ func (o *consumer) setLeader(isLeader bool) {
 		stopAndClearTimer(&o.ptmr)
 		o.rdq, o.rdqi = nil, nil
 		o.pending = nil
+		o.resetPendingBytes()
 		// ok if they are nil, we protect inside unsubscribe()
 		o.unsubscribe(o.ackSub)
 		o.unsubscribe(o.reqSub)
@@ -1119,8 +1203,12 @@ func (o *consumer) setLeader(isLeader bool) {
 			if !o.isDurable() {
 				stopAndClearTimer(&o.dtmr)
 			}
-		} else if o.srv.gateway.enabled {
-			stopAndClearTimer(&o.gwdtmr)
+		} else {
+			if o.srv.gateway.enabled {
+				stopAndClearTimer(&o.gwdtmr)
+			}
+			stopAndClearTimer(&o.rbtmr)
+			o.members = nil
 		}
 		o.mu.Unlock()
 
@@ -1169,6 +1257,58 @@ func (o *consumer) sendAdvisory(subj string, msg []byte) {
 	o.outq.sendMsg(subj, msg)
 }
 
+// Sends advisories that can be generated at high rates under failure conditions.
+// If rate limiting is configured, advisories over the limit are dropped and
+// summarized in a single suppressed advisory at the end of the window.
+// Lock should be held.
+func (o *consumer) sendRateLimitedAdvisory(subj string, msg []byte) {
+	if rc := o.advl[subj]; rc != nil && !rc.allow() {
+		if o.advtmr == nil {
+			o.advtmr = time.AfterFunc(rc.interval, o.sendSuppressedAdvisories)
+		}
+		return
+	}
+	o.sendAdvisory(subj, msg)
+}
+
+// Sends a summary advisory for each advisory kind that was suppressed.
+func (o *consumer) sendSuppressedAdvisories() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.advtmr = nil
+	if o.closed {
+		return
+	}
+
+	for subj, rc := range o.advl {
+		n := rc.countBlocked()
+		if n == 0 {
+			continue
+		}
+		e := JSConsumerAdvisoriesSuppressedAdvisory{
+			TypedEvent: TypedEvent{
+				Type: JSConsumerAdvisoriesSuppressedAdvisoryType,
+				ID:   nuid.Next(),
+				Time: time.Now().UTC(),
+			},
+			Stream:     o.stream,
+			Consumer:   o.name,
+			Subject:    subj,
+			Suppressed: n,
+			Window:     rc.interval,
+			Domain:     o.srv.getOpts().JetStreamDomain,
+		}
+
+		j, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+
+		o.sendAdvisory(JSAdvisoryConsumerSuppressedPre+"."+o.stream+"."+o.name, j)
+	}
+}
+
 func (o *consumer) sendDeleteAdvisoryLocked() {
 	e := JSConsumerActionAdvisory{
 		TypedEvent: TypedEvent{
@@ -1413,6 +1553,138 @@ func (o *consumer) watchGWinterest() {
 	o.mu.Unlock()
 }
 
+// How often we check on the members of the queue group a push consumer delivers to.
+const consumerGroupCheckInterval = time.Second
+
+// Returns the connections with members in our queue group, sorted.
+// Lock should be held.
+func (o *consumer) groupMembers() []ConsumerGroupMember {
+	subj := o.dsubj
+	if subj == _EMPTY_ {
+		subj = o.cfg.DeliverSubject
+	}
+	var members []ConsumerGroupMember
+	conns := make(map[*client]int)
+	for _, qsubs := range o.acc.sl.Match(subj).qsubs {
+		if len(qsubs) == 0 || string(qsubs[0].queue) != o.cfg.DeliverGroup {
+			continue
+		}
+		for _, sub := range qsubs {
+			c := sub.client
+			if c == nil {
+				continue
+			}
+			// Routes and leafnodes carry the number of members behind them as the weight.
+			n := int32(1)
+			if c.kind != CLIENT && sub.qw > 0 {
+				n = sub.qw
+			}
+			if i, ok := conns[c]; ok {
+				members[i].Count += n
+				continue
+			}
+			m := ConsumerGroupMember{Server: o.srv.Name(), Kind: c.kindString(), Count: n}
+			switch c.kind {
+			case CLIENT:
+				m.ClientID, m.Name = c.cid, c.opts.Name
+			case ROUTER:
+				m.Server = c.route.remoteName
+			case LEAF:
+				m.Server = c.leaf.remoteServer
+			}
+			conns[c] = len(members)
+			members = append(members, m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		mi, mj := members[i], members[j]
+		if mi.Server != mj.Server {
+			return mi.Server < mj.Server
+		}
+		if mi.Kind != mj.Kind {
+			return mi.Kind < mj.Kind
+		}
+		return mi.ClientID < mj.ClientID
+	})
+	return members
+}
+
+// Returns the connections that gained and lost members, with the count being how many.
+func diffGroupMembers(before, after []ConsumerGroupMember) (joined, left []ConsumerGroupMember) {
+	key := func(m ConsumerGroupMember) string {
+		return fmt.Sprintf("%s|%s|%d", m.Server, m.Kind, m.ClientID)
+	}
+	counts := make(map[string]ConsumerGroupMember, len(before))
+	for _, m := range before {
+		counts[key(m)] = m
+	}
+	for _, m := range after {
+		k := key(m)
+		om, ok := counts[k]
+		delete(counts, k)
+		if !ok {
+			joined = append(joined, m)
+		} else if m.Count > om.Count {
+			m.Count -= om.Count
+			joined = append(joined, m)
+		} else if m.Count < om.Count {
+			m.Count = om.Count - m.Count
+			left = append(left, m)
+		}
+	}
+	for _, m := range before {
+		if _, ok := counts[key(m)]; ok {
+			left = append(left, m)
+		}
+	}
+	return joined, left
+}
+
+// Checks on the members of our queue group and sends an advisory describing them when they changed.
+func (o *consumer) watchGroupMembers() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed || o.rbtmr == nil {
+		return
+	}
+	members := o.groupMembers()
+	if joined, left := diffGroupMembers(o.members, members); len(joined)+len(left) > 0 {
+		o.members = members
+		o.sendGroupRebalanceAdvisory(joined, left)
+	}
+	o.rbtmr.Reset(consumerGroupCheckInterval)
+}
+
+// Lock should be held.
+func (o *consumer) sendGroupRebalanceAdvisory(joined, left []ConsumerGroupMember) {
+	members := o.members
+	if members == nil {
+		members = []ConsumerGroupMember{}
+	}
+	e := JSConsumerGroupRebalanceAdvisory{
+		TypedEvent: TypedEvent{
+			Type: JSConsumerGroupRebalanceAdvisoryType,
+			ID:   nuid.Next(),
+			Time: time.Now().UTC(),
+		},
+		Stream:   o.stream,
+		Consumer: o.name,
+		Group:    o.cfg.DeliverGroup,
+		Members:  members,
+		Joined:   joined,
+		Left:     left,
+		Domain:   o.srv.getOpts().JetStreamDomain,
+	}
+
+	j, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	o.sendAdvisory(JSAdvisoryConsumerRebalancePre+"."+o.stream+"."+o.name, j)
+}
+
 // Config returns the consumer's configuration.
 func (o *consumer) config() ConsumerConfig {
 	o.mu.Lock()
@@ -1520,6 +1792,9 @@ func (acc *Account) checkNewConsumerConfig(cfg, ncfg *ConsumerConfig) error {
 	if cfg.MaxWaiting != ncfg.MaxWaiting {
 		return errors.New("max waiting can not be updated")
 	}
+	if cfg.ConsumptionGroup != ncfg.ConsumptionGroup {
+		return errors.New("consumption group can not be updated")
+	}
 
 	// Deliver Subject is conditional on if its bound.
 	if cfg.DeliverSubject != ncfg.DeliverSubject {
@@ -1569,6 +1844,17 @@ func (o *consumer) updateConfig(cfg *ConsumerConfig) error {
 		o.maxp = cfg.MaxAckPending
 		o.signalNewMessages()
 	}
+	// MaxBytesPending
+	if cfg.MaxBytesPending != o.cfg.MaxBytesPending {
+		o.maxab = cfg.MaxBytesPending
+		o.resetPendingBytes()
+		o.signalNewMessages()
+	}
+	// Adaptive prefetch, also keeps the window within MaxAckPending.
+	if cfg.AdaptivePrefetch != o.cfg.AdaptivePrefetch || cfg.MaxAckPending != o.cfg.MaxAckPending {
+		o.setAdaptivePrefetch(cfg.AdaptivePrefetch)
+		o.signalNewMessages()
+	}
 	// AckWait
 	if cfg.AckWait != o.cfg.AckWait {
 		if o.ptmr != nil {
@@ -1703,6 +1989,10 @@ func (o *consumer) pushAck(_ *subscription, c *client, _ *Account, subject, repl
 func (o *consumer) processAck(subject, reply string, hdr int, rmsg []byte) {
 	defer atomic.AddInt64(&o.awl, -1)
 
+	if mset := o.getStream(); mset != nil {
+		mset.noteActivity()
+	}
+
 	var msg []byte
 	if hdr > 0 {
 		msg = rmsg[hdr:]
@@ -1998,7 +2288,7 @@ func (o *consumer) processNak(sseq, dseq, dc uint64, nak []byte) {
 		return
 	}
 
-	o.sendAdvisory(o.nakEventT, j)
+	o.sendRateLimitedAdvisory(o.nakEventT, j)
 
 	// Check to see if we have delays attached.
 	if len(nak) > len(AckNak) {
@@ -2075,7 +2365,7 @@ func (o *consumer) processTerm(sseq, dseq, dc uint64) {
 	}
 
 	subj := JSAdvisoryConsumerMsgTerminatedPre + "." + o.stream + "." + o.name
-	o.sendAdvisory(subj, j)
+	o.sendRateLimitedAdvisory(subj, j)
 }
 
 // Introduce a small delay in when timer fires to check pending.
@@ -2142,6 +2432,7 @@ func (o *consumer) applyState(state *ConsumerState) {
 	o.asflr = state.AckFloor.Stream
 	o.pending = state.Pending
 	o.rdc = state.Redelivered
+	o.resetPendingBytes()
 
 	// Setup tracking timer if we have restored pending.
 	if len(o.pending) > 0 && o.ptmr == nil {
@@ -2261,6 +2552,11 @@ func (o *consumer) infoWithSnapAndReply(snap bool, reply string) *ConsumerInfo {
 		NumRedelivered: len(o.rdc),
 		NumPending:     o.streamNumPending(),
 		PushBound:      o.isPushMode() && o.active,
+		PrefetchWindow: o.pwnd,
+		Idle:           o.idle,
+	}
+	if o.maxab > 0 {
+		info.NumAckPendingBytes = o.ab
 	}
 	// Adjust active based on non-zero etc. Also make UTC here.
 	if !o.ldt.IsZero() {
@@ -2362,10 +2658,12 @@ func (o *consumer) processAckMsg(sseq, dseq, dc uint64, doSample bool) {
 			if doSample {
 				o.sampleAck(sseq, dseq, dc)
 			}
-			if o.maxp > 0 && len(o.pending) >= o.maxp {
+			o.hist.record(time.Now(), 1, 0)
+			if maxp := o.maxPending(); maxp > 0 && len(o.pending) >= maxp || o.pendingBytesFull() {
 				needSignal = true
 			}
-			delete(o.pending, sseq)
+			o.prefetchAcked(p.Timestamp)
+			o.removePending(sseq)
 			// Use the original deliver sequence from our pending record.
 			dseq = p.Sequence
 		}
@@ -2391,13 +2689,14 @@ func (o *consumer) processAckMsg(sseq, dseq, dc uint64, doSample bool) {
 			o.mu.Unlock()
 			return
 		}
-		if o.maxp > 0 && len(o.pending) >= o.maxp {
+		if o.maxp > 0 && len(o.pending) >= o.maxp || o.pendingBytesFull() {
 			needSignal = true
 		}
 		sagap = sseq - o.asflr
 		o.adflr, o.asflr = dseq, sseq
+		o.hist.record(time.Now(), sagap, 0)
 		for seq := sseq; seq > sseq-sagap; seq-- {
-			delete(o.pending, seq)
+			o.removePending(seq)
 			delete(o.rdc, seq)
 			o.removeFromRedeliverQueue(seq)
 		}
@@ -2410,14 +2709,21 @@ func (o *consumer) processAckMsg(sseq, dseq, dc uint64, doSample bool) {
 	// Update underlying store.
 	o.updateAcks(dseq, sseq)
 
+	// Reassembled messages are acked along with their other chunks.
+	var chunks []uint64
+	if o.retention != LimitsPolicy {
+		chunks = o.chunkSeqs(sseq)
+	}
+
 	mset := o.mset
 	clustered := o.node != nil
+	retention := o.retention
 	o.mu.Unlock()
 
 	// Let the owning stream know if we are interest or workqueue retention based.
 	// If this consumer is clustered this will be handled by processReplicatedAck
 	// after the ack has propagated.
-	if !clustered && mset != nil && mset.cfg.Retention != LimitsPolicy {
+	if !clustered && mset != nil && retention != LimitsPolicy {
 		if sagap > 1 {
 			// FIXME(dlc) - This is very inefficient, will need to fix.
 			for seq := sseq; seq > sseq-sagap; seq-- {
@@ -2426,6 +2732,9 @@ func (o *consumer) processAckMsg(sseq, dseq, dc uint64, doSample bool) {
 		} else {
 			mset.ackMsg(o, sseq)
 		}
+		for _, seq := range chunks {
+			mset.ackMsg(o, seq)
+		}
 	}
 
 	// If we had max ack pending set and were at limit we need to unblock ourselves.
@@ -2804,6 +3113,11 @@ func (o *consumer) processNextMsgReq(_ *subscription, c *client, _ *Account, _,
 }
 
 func (o *consumer) processNextMsgRequest(reply string, msg []byte) {
+	// Resumes our stream if idle, needs to happen before we take our lock.
+	if mset := o.getStream(); mset != nil {
+		mset.noteActivity()
+	}
+
 	o.mu.Lock()
 	defer o.mu.Unlock()
 
@@ -2943,7 +3257,38 @@ func (o *consumer) notifyDeliveryExceeded(sseq, dc uint64) {
 		return
 	}
 
-	o.sendAdvisory(o.deliveryExcEventT, j)
+	o.sendRateLimitedAdvisory(o.deliveryExcEventT, j)
+}
+
+// Headers for messages sent to a dead letter subject, along with the
+// stream, subject, sequence and timestamp of the original message.
+const (
+	JSConsumer     = "Nats-Consumer"
+	JSNumDelivered = "Nats-Num-Delivered"
+)
+
+// Republishes a message that exceeded max deliveries to our dead letter subject.
+// Lock should be held.
+func (o *consumer) sendToDeadLetter(sseq, dc uint64) {
+	if o.cfg.DeadLetterSubject == _EMPTY_ || o.mset == nil || o.mset.store == nil {
+		return
+	}
+	sm, err := o.mset.store.LoadMsg(sseq, nil)
+	if err != nil {
+		return
+	}
+	// Drop anything that would have the message rejected or withheld again.
+	hdr := copyBytes(sm.hdr)
+	for _, key := range schedStripHeaders {
+		hdr = removeHeaderIfPresent(hdr, key)
+	}
+	hdr = genHeader(hdr, JSStream, o.stream)
+	hdr = genHeader(hdr, JSConsumer, o.name)
+	hdr = genHeader(hdr, JSSubject, sm.subj)
+	hdr = genHeader(hdr, JSSequence, strconv.FormatUint(sm.seq, 10))
+	hdr = genHeader(hdr, JSTimeStamp, time.Unix(0, sm.ts).UTC().Format(time.RFC3339Nano))
+	hdr = genHeader(hdr, JSNumDelivered, strconv.FormatUint(dc, 10))
+	o.outq.send(newJSPubMsg(o.cfg.DeadLetterSubject, _EMPTY_, _EMPTY_, hdr, copyBytes(sm.msg), nil, 0))
 }
 
 // Check to see if the candidate subject matches a filter if its present.
@@ -2971,6 +3316,46 @@ var (
 // Is partition aware and redeliver aware.
 // Lock should be held.
 func (o *consumer) getNextMsg() (*jsPubMsg, uint64, error) {
+	for {
+		pmsg, dc, err := o.getNextStoredMsg()
+		// Skip over tombstones unless asked for. Redeliveries are left alone.
+		if pmsg != nil && dc == 1 && !o.cfg.DeliverTombstones && isTombstone(pmsg.hdr) {
+			pmsg.returnToPool()
+			continue
+		}
+		// Scheduled messages are delivered as new messages when due.
+		if pmsg != nil && dc == 1 && len(pmsg.hdr) > 0 && o.mset.isScheduled(pmsg.seq) {
+			pmsg.returnToPool()
+			continue
+		}
+		// Chunks are delivered as the message they make up when asked for.
+		if pmsg != nil && o.cfg.ReassembleChunks && isChunk(pmsg.hdr) {
+			if string(getHeader(JSChunkSeq, pmsg.hdr)) != "1" {
+				if dc == 1 {
+					// Delivered with the first chunk, let the stream know if ack none.
+					if o.cfg.AckPolicy == AckNone && o.retention != LimitsPolicy && (o.node == nil || o.cfg.Direct) {
+						o.mset.ackq.push(pmsg.seq)
+					}
+					pmsg.returnToPool()
+					continue
+				}
+			} else if !o.reassembleChunks(pmsg) && dc == 1 {
+				// Wait for the rest of the chunks.
+				o.sseq = pmsg.seq
+				pmsg.returnToPool()
+				return nil, 0, ErrStoreEOF
+			}
+		}
+		return pmsg, dc, err
+	}
+}
+
+// Messages ahead of us we ask the store to load when reading through the stream.
+const consumerReadAhead = 4096
+
+// Returns the next message from the store, including tombstones.
+// Lock should be held.
+func (o *consumer) getNextStoredMsg() (*jsPubMsg, uint64, error) {
 	if o.mset == nil || o.mset.store == nil {
 		return nil, 0, errBadConsumer
 	}
@@ -2983,9 +3368,10 @@ func (o *consumer) getNextMsg() (*jsPubMsg, uint64, error) {
 				// Only send once
 				if dc == o.maxdc+1 {
 					o.notifyDeliveryExceeded(seq, dc-1)
+					o.sendToDeadLetter(seq, dc-1)
 				}
 				// Make sure to remove from pending.
-				delete(o.pending, seq)
+				o.removePending(seq)
 				continue
 			}
 			if seq > 0 {
@@ -3015,12 +3401,18 @@ func (o *consumer) getNextMsg() (*jsPubMsg, uint64, error) {
 	}
 
 	// Check if we have max pending.
-	if o.maxp > 0 && len(o.pending) >= o.maxp {
+	if maxp := o.maxPending(); maxp > 0 && len(o.pending) >= maxp || o.pendingBytesFull() {
 		// maxp only set when ack policy != AckNone and user set MaxAckPending
-		// Stall if we have hit max pending.
+		// or adaptive prefetch. Stall if we have hit max pending, in messages or bytes.
 		return nil, 0, errMaxAckPending
 	}
 
+	// Have the store load what we are about to read next.
+	if seq >= o.rahead {
+		o.mset.store.Prefetch(seq, consumerReadAhead)
+		o.rahead = seq + consumerReadAhead/2
+	}
+
 	// Grab next message applicable to us.
 	pmsg := getJSPubMsgFromPool()
 	sm, sseq, err := o.mset.store.LoadNextMsg(o.cfg.FilterSubject, o.filterWC, seq, &pmsg.StoreMsg)
@@ -3281,6 +3673,8 @@ func (o *consumer) loopAndGatherMsgs(qch chan struct{}) {
 		// Add in msg size itself as header.
 		if o.cfg.HeadersOnly {
 			convertToHeadersOnly(pmsg)
+		} else if !o.cfg.DeliverCompressed {
+			decompressPubMsg(pmsg)
 		}
 		// Calculate payload size. This can be calculated on client side.
 		// We do not include transport subject here since not generally known on client.
@@ -3508,12 +3902,13 @@ func (o *consumer) deliverMsg(dsubj, ackReply string, pmsg *jsPubMsg, dc uint64,
 	ap := o.cfg.AckPolicy
 
 	// Cant touch pmsg after this sending so capture what we need.
-	seq, ts := pmsg.seq, pmsg.ts
+	seq, ts, asz := pmsg.seq, pmsg.ts, int64(len(pmsg.hdr)+len(pmsg.msg))
 	// Send message.
 	o.outq.send(pmsg)
 
 	if ap == AckExplicit || ap == AckAll {
 		o.trackPending(seq, dseq)
+		o.trackPendingBytes(seq, asz)
 	} else if ap == AckNone {
 		o.adflr = dseq
 		o.asflr = seq
@@ -3761,7 +4156,7 @@ func (o *consumer) checkPending() {
 		}
 		// Check if these are no longer valid.
 		if seq < fseq {
-			delete(o.pending, seq)
+			o.removePending(seq)
 			delete(o.rdc, seq)
 			o.removeFromRedeliverQueue(seq)
 			shouldUpdateState = true
@@ -3797,6 +4192,7 @@ func (o *consumer) checkPending() {
 		// We need to sort.
 		sort.Slice(expired, func(i, j int) bool { return expired[i] < expired[j] })
 		o.addToRedeliverQueue(expired...)
+		o.prefetchExpired()
 		// Now we should update the timestamp here since we are redelivering.
 		// We will use an incrementing time to preserve order for any other redelivery.
 		off := now - o.pending[expired[0]].Timestamp
@@ -4077,6 +4473,7 @@ func (o *consumer) purge(sseq uint64, slseq uint64) {
 		}
 	}
 	o.pending = nil
+	o.resetPendingBytes()
 
 	// We need to remove all those being queued for redelivery under o.rdq
 	if len(o.rdq) > 0 {
@@ -4169,6 +4566,8 @@ func (o *consumer) stopWithFlags(dflag, sdflag, doSignal, advisory bool) error {
 	stopAndClearTimer(&o.ptmr)
 	stopAndClearTimer(&o.dtmr)
 	stopAndClearTimer(&o.gwdtmr)
+	stopAndClearTimer(&o.rbtmr)
+	stopAndClearTimer(&o.advtmr)
 	delivery := o.cfg.DeliverSubject
 	o.waiting = nil
 	// Break us out of the readLoop.
@@ -4229,7 +4628,9 @@ func (o *consumer) stopWithFlags(dflag, sdflag, doSignal, advisory bool) error {
 		mset.mu.RUnlock()
 
 		for _, seq := range rmseqs {
-			mset.store.RemoveMsg(seq)
+			if !mset.holdAcked(seq) {
+				mset.store.RemoveMsg(seq)
+			}
 		}
 	}
 
@@ -4350,6 +4751,32 @@ func (o *consumer) decStreamPending(sseq uint64, subj string) {
 	}
 }
 
+// Called when a range of messages was removed from the stream.
+func (o *consumer) decStreamPendingRange(first, last uint64) {
+	type pendingMsg struct{ sseq, dseq, rdc uint64 }
+	var wasPending []pendingMsg
+
+	o.mu.Lock()
+	// Recalculate our cached num pending.
+	o.streamNumPending()
+	for sseq, p := range o.pending {
+		if sseq < first || sseq > last {
+			continue
+		}
+		var rdc uint64 = 1
+		if o.rdc != nil {
+			rdc = o.rdc[sseq]
+		}
+		wasPending = append(wasPending, pendingMsg{sseq, p.Sequence, rdc})
+	}
+	o.mu.Unlock()
+
+	// Any that were pending are processed like an ack.
+	for _, pm := range wasPending {
+		o.processTerm(pm.sseq, pm.dseq, pm.rdc)
+	}
+}
+
 func (o *consumer) account() *Account {
 	o.mu.RLock()
 	a := o.acc
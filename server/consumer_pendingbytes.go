@@ -0,0 +1,70 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+// With MaxBytesPending a consumer bounds the bytes, headers and payload, of the
+// messages pending an ack next to their number bound by MaxAckPending, so clients
+// consuming messages of mixed sizes can bound their memory. New messages are not
+// delivered while at or over the limit, redeliveries are. A single message larger
+// than the limit is still delivered when nothing else is pending.
+// Sizes are only tracked while the limit is set.
+
+// Tracks the size of a message delivered to us that is now pending an ack.
+// Lock should be held.
+func (o *consumer) trackPendingBytes(sseq uint64, sz int64) {
+	if o.maxab <= 0 {
+		return
+	}
+	if o.absz == nil {
+		o.absz = make(map[uint64]int64)
+	}
+	// Redeliveries are already accounted for.
+	if _, ok := o.absz[sseq]; ok {
+		return
+	}
+	o.absz[sseq] = sz
+	o.ab += sz
+}
+
+// Removes a message from pending, along with its size.
+// Lock should be held.
+func (o *consumer) removePending(sseq uint64) {
+	delete(o.pending, sseq)
+	if sz, ok := o.absz[sseq]; ok {
+		o.ab -= sz
+		delete(o.absz, sseq)
+	}
+}
+
+// Recomputes the bytes pending from the stored messages in pending, for when our
+// pending was replaced or the limit was set.
+// Lock should be held.
+func (o *consumer) resetPendingBytes() {
+	o.ab, o.absz = 0, nil
+	if o.maxab <= 0 || len(o.pending) == 0 || o.mset == nil || o.mset.store == nil {
+		return
+	}
+	var smv StoreMsg
+	for seq := range o.pending {
+		if sm, err := o.mset.store.LoadMsg(seq, &smv); err == nil && sm != nil {
+			o.trackPendingBytes(seq, int64(len(sm.hdr)+len(sm.msg)))
+		}
+	}
+}
+
+// Returns true if we have reached MaxBytesPending.
+// Lock should be held.
+func (o *consumer) pendingBytesFull() bool {
+	return o.maxab > 0 && o.ab >= o.maxab
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import "time"
+
+// Messages in flight a consumer with adaptive prefetch starts with.
+const adaptivePrefetchStart = 32
+
+// With adaptive prefetch a push consumer limits the messages in flight to its
+// delivery target to a window that adapts to how fast they are acked, much like
+// a congestion window. Acks well within the ack wait grow the window by one while
+// it is in use, up to MaxAckPending. Acks close to the ack wait shrink it by one
+// and messages that are not acked in time halve it, so a slow client is not buried
+// under redeliveries while a fast one is not throttled by a conservative default.
+
+// Turns adaptive prefetch on or off, keeping the window within MaxAckPending.
+// Lock should be held.
+func (o *consumer) setAdaptivePrefetch(enabled bool) {
+	if !enabled {
+		o.pwnd = 0
+		return
+	}
+	if o.pwnd == 0 {
+		o.pwnd = adaptivePrefetchStart
+	}
+	if o.maxp > 0 && o.pwnd > o.maxp {
+		o.pwnd = o.maxp
+	}
+}
+
+// Returns the max messages allowed pending, 0 if unlimited.
+// Lock should be held.
+func (o *consumer) maxPending() int {
+	if o.pwnd > 0 && (o.maxp <= 0 || o.pwnd < o.maxp) {
+		return o.pwnd
+	}
+	return o.maxp
+}
+
+// Adjusts the window for an ack of a message delivered at ts.
+// Lock should be held.
+func (o *consumer) prefetchAcked(ts int64) {
+	if o.pwnd == 0 {
+		return
+	}
+	latency, aw := time.Duration(time.Now().UnixNano()-ts), o.cfg.AckWait
+	switch {
+	case aw > 0 && latency >= aw/2:
+		if o.pwnd > 1 {
+			o.pwnd--
+		}
+	case aw <= 0 || latency < aw/4:
+		// Only grow if we were using the window, this ack is still pending.
+		if len(o.pending) >= o.pwnd && (o.maxp <= 0 || o.pwnd < o.maxp) {
+			o.pwnd++
+		}
+	}
+}
+
+// Shrinks the window since messages were not acked in time.
+// Lock should be held.
+func (o *consumer) prefetchExpired() {
+	if o.pwnd > 1 {
+		o.pwnd /= 2
+	}
+}
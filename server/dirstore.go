@@ -17,6 +17,8 @@ import (
 	"bytes"
 	"container/heap"
 	"container/list"
+	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
 	"errors"
 	"fmt"
@@ -36,6 +38,11 @@ const (
 	fileExtension = ".jwt"
 )
 
+// Header of JWT files that are encrypted at rest, JWTs in the clear always start with "eyJ".
+var encryptedJWTHdr = []byte{magic, 1}
+
+var errNoJWTEncryptionKey = errors.New("jwt is encrypted but no encryption key is configured")
+
 // validatePathExists checks that the provided path exists and is a dir if requested
 func validatePathExists(path string, dir bool) (string, error) {
 	if path == _EMPTY_ {
@@ -84,6 +91,7 @@ type DirJWTStore struct {
 	expiration *expirationTracker
 	changed    JWTChanged
 	deleted    JWTChanged
+	aek        cipher.AEAD
 }
 
 func newDir(dirPath string, create bool) (string, error) {
@@ -174,7 +182,8 @@ func NewExpiringDirJWTStore(dirPath string, shard bool, create bool, delete dele
 	theStore.Lock()
 	err = filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
 		if strings.HasSuffix(path, fileExtension) {
-			if theJwt, err := os.ReadFile(path); err == nil {
+			// Encrypted JWTs will be indexed once the encryption key is set.
+			if theJwt, err := readJWTFile(path, nil); err == nil {
 				hash := sha256.Sum256(theJwt)
 				_, file := filepath.Split(path)
 				theStore.expiration.track(strings.TrimSuffix(file, fileExtension), &hash, string(theJwt))
@@ -240,7 +249,7 @@ func (store *DirJWTStore) Pack(maxJWTs int) (string, error) {
 					return nil // only include indexed files
 				}
 			}
-			jwtBytes, err := os.ReadFile(path)
+			jwtBytes, err := readJWTFile(path, store.aek)
 			if err != nil {
 				return err
 			}
@@ -272,6 +281,7 @@ func (store *DirJWTStore) PackWalk(maxJWTs int, cb func(partialPackMsg string))
 	store.Lock()
 	dir := store.directory
 	exp := store.expiration
+	aek := store.aek
 	store.Unlock()
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if info != nil && !info.IsDir() && strings.HasSuffix(path, fileExtension) { // this is a JWT
@@ -284,7 +294,7 @@ func (store *DirJWTStore) PackWalk(maxJWTs int, cb func(partialPackMsg string))
 				}
 			}
 			store.Unlock()
-			jwtBytes, err := os.ReadFile(path)
+			jwtBytes, err := readJWTFile(path, aek)
 			if err != nil {
 				return err
 			}
@@ -342,6 +352,7 @@ func (store *DirJWTStore) Reload() error {
 	idx := exp.idx
 	changed := store.changed
 	isCache := store.expiration.evictOnLimit
+	aek := store.aek
 	// clear out indexing data structures
 	exp.heap = make([]*jwtItem, 0, len(exp.heap))
 	exp.idx = make(map[string]*list.Element)
@@ -350,7 +361,7 @@ func (store *DirJWTStore) Reload() error {
 	store.Unlock()
 	return filepath.Walk(store.directory, func(path string, info os.FileInfo, err error) error {
 		if strings.HasSuffix(path, fileExtension) {
-			if theJwt, err := os.ReadFile(path); err == nil {
+			if theJwt, err := readJWTFile(path, aek); err == nil {
 				hash := sha256.Sum256(theJwt)
 				_, file := filepath.Split(path)
 				pkey := strings.TrimSuffix(file, fileExtension)
@@ -393,7 +404,7 @@ func (store *DirJWTStore) load(publicKey string) (string, error) {
 	defer store.Unlock()
 	if path := store.pathForKey(publicKey); path == _EMPTY_ {
 		return _EMPTY_, fmt.Errorf("invalid public key")
-	} else if data, err := os.ReadFile(path); err != nil {
+	} else if data, err := readJWTFile(path, store.aek); err != nil {
 		return _EMPTY_, err
 	} else {
 		if store.expiration != nil {
@@ -431,7 +442,7 @@ func (store *DirJWTStore) write(path string, publicKey string, theJWT string) (b
 			}
 		}
 	}
-	if err := os.WriteFile(path, []byte(theJWT), defaultFilePerms); err != nil {
+	if err := writeJWTFile(path, []byte(theJWT), store.aek); err != nil {
 		return false, err
 	} else if store.expiration != nil {
 		store.expiration.track(publicKey, newHash, theJWT)
@@ -510,10 +521,13 @@ func (store *DirJWTStore) saveIfNewer(publicKey string, theJWT string) error {
 			return err
 		}
 	}
+	store.Lock()
+	aek := store.aek
+	store.Unlock()
 	if _, err := os.Stat(path); err == nil {
 		if newJWT, err := jwt.DecodeGeneric(theJWT); err != nil {
 			return err
-		} else if existing, err := os.ReadFile(path); err != nil {
+		} else if existing, err := readJWTFile(path, aek); err != nil {
 			return err
 		} else if existingJWT, err := jwt.DecodeGeneric(string(existing)); err != nil {
 			// skip if it can't be decoded
@@ -539,6 +553,70 @@ func (store *DirJWTStore) saveIfNewer(publicKey string, theJWT string) error {
 	return nil
 }
 
+// Returns the JWT stored at path, decrypting it with aek if it is encrypted.
+func readJWTFile(path string, aek cipher.AEAD) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.HasPrefix(data, encryptedJWTHdr) {
+		return data, err
+	}
+	if aek == nil {
+		return nil, errNoJWTEncryptionKey
+	}
+	data = data[len(encryptedJWTHdr):]
+	ns := aek.NonceSize()
+	if len(data) < ns {
+		return nil, errors.New("encrypted jwt is too short")
+	}
+	return aek.Open(nil, data[:ns], data[ns:], nil)
+}
+
+// Writes the JWT to path, encrypting it with aek if set.
+func writeJWTFile(path string, theJWT []byte, aek cipher.AEAD) error {
+	if aek != nil {
+		nonce := make([]byte, aek.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		buf := make([]byte, 0, len(encryptedJWTHdr)+len(nonce)+len(theJWT)+aek.Overhead())
+		buf = append(buf, encryptedJWTHdr...)
+		buf = append(buf, nonce...)
+		theJWT = aek.Seal(buf, nonce, theJWT, nil)
+	}
+	return os.WriteFile(path, theJWT, defaultFilePerms)
+}
+
+// Encrypts the JWTs kept in the store at rest with aek.
+// JWTs that are still in the clear, including deleted ones, are encrypted in place
+// and the store is re-indexed.
+// Assumes lock is NOT held
+func (store *DirJWTStore) enableEncryption(aek cipher.AEAD) error {
+	store.Lock()
+	store.aek = aek
+	dir := store.directory
+	store.Unlock()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if !strings.HasSuffix(path, fileExtension) && !strings.HasSuffix(path, fileExtension+".deleted") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || bytes.HasPrefix(data, encryptedJWTHdr) {
+			return err
+		}
+		tmp := path + ".tmp"
+		if err := writeJWTFile(tmp, data, aek); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	})
+	if err != nil {
+		return err
+	}
+	return store.Reload()
+}
+
 func xorAssign(lVal *[sha256.Size]byte, rVal [sha256.Size]byte) {
 	for i := range rVal {
 		(*lVal)[i] ^= rVal[i]
@@ -0,0 +1,168 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import "fmt"
+
+// LifecycleCallbacks can be registered by applications embedding the server
+// to be notified of server state changes instead of polling for them.
+// Callbacks are invoked from server go routines and should not block.
+type LifecycleCallbacks struct {
+	// Ready is invoked once the server has started and is ready for connections.
+	Ready func(s *Server)
+	// LameDuckEntered is invoked when the server enters lame duck mode.
+	LameDuckEntered func(s *Server)
+	// JetStreamRecovered is invoked once JetStream is enabled and all
+	// account assets have been recovered.
+	JetStreamRecovered func(s *Server)
+}
+
+// ServerOption is a functional option used to configure a server created with NewEmbeddedServer.
+type ServerOption func(*Options) error
+
+// NewEmbeddedServer creates a new server configured by the given functional options.
+// Options are applied in order, so later options override earlier ones, e.g. values
+// loaded with WithConfigFile.
+func NewEmbeddedServer(optFns ...ServerOption) (*Server, error) {
+	opts := &Options{}
+	for _, fn := range optFns {
+		if err := fn(opts); err != nil {
+			return nil, err
+		}
+	}
+	return NewServer(opts)
+}
+
+// WithConfigFile loads the options from the given configuration file.
+func WithConfigFile(configFile string) ServerOption {
+	return func(o *Options) error {
+		return o.ProcessConfigFile(configFile)
+	}
+}
+
+// WithServerName sets the server name.
+func WithServerName(name string) ServerOption {
+	return func(o *Options) error {
+		o.ServerName = name
+		return nil
+	}
+}
+
+// WithListen sets the host and port to accept client connections on.
+// A port of -1 selects a random port.
+func WithListen(host string, port int) ServerOption {
+	return func(o *Options) error {
+		if port < -1 || port > 65535 {
+			return fmt.Errorf("invalid port: %d", port)
+		}
+		o.Host, o.Port = host, port
+		return nil
+	}
+}
+
+// WithoutListener prevents the server from accepting TCP client connections,
+// clients can then only connect with Server.InProcessConn.
+func WithoutListener() ServerOption {
+	return func(o *Options) error {
+		o.DontListen = true
+		return nil
+	}
+}
+
+// WithJetStream enables JetStream with the given storage directory.
+func WithJetStream(storeDir string) ServerOption {
+	return func(o *Options) error {
+		o.JetStream = true
+		o.StoreDir = storeDir
+		return nil
+	}
+}
+
+// WithJetStreamLimits sets the maximum memory and file storage for JetStream.
+// A negative value means unlimited.
+func WithJetStreamLimits(maxMem, maxStore int64) ServerOption {
+	return func(o *Options) error {
+		o.JetStreamMaxMemory, o.JetStreamMaxStore = maxMem, maxStore
+		o.maxMemSet, o.maxStoreSet = true, true
+		return nil
+	}
+}
+
+// WithLogging sets the debug and trace flags used when a logger is configured.
+func WithLogging(debug, trace bool) ServerOption {
+	return func(o *Options) error {
+		o.Debug, o.Trace = debug, trace
+		return nil
+	}
+}
+
+// WithMsgInterceptors registers message lifecycle interceptors.
+func WithMsgInterceptors(mi *MsgInterceptors) ServerOption {
+	return func(o *Options) error {
+		o.MsgInterceptors = mi
+		return nil
+	}
+}
+
+// WithLifecycleCallbacks registers server lifecycle callbacks.
+func WithLifecycleCallbacks(cb *LifecycleCallbacks) ServerOption {
+	return func(o *Options) error {
+		o.LifecycleCallbacks = cb
+		return nil
+	}
+}
+
+// WithJetStreamTierBackend sets the backend older stream message blocks are moved to.
+func WithJetStreamTierBackend(tb TierBackend) ServerOption {
+	return func(o *Options) error {
+		o.JetStreamTierBackend = tb
+		return nil
+	}
+}
+
+// WithJetStreamKafkaDialer sets how streams with a Kafka source connect to their brokers.
+// The server has no Kafka client of its own, so Kafka sources require this. In a
+// cluster all servers that can host such streams should have one.
+func WithJetStreamKafkaDialer(kd KafkaDialer) ServerOption {
+	return func(o *Options) error {
+		o.JetStreamKafkaDialer = kd
+		return nil
+	}
+}
+
+// Returns the registered lifecycle callbacks, if any.
+func (s *Server) lifecycleCallbacks() *LifecycleCallbacks {
+	return s.getOpts().LifecycleCallbacks
+}
+
+// Invoked when the server is ready for connections.
+func (s *Server) notifyReady() {
+	if cb := s.lifecycleCallbacks(); cb != nil && cb.Ready != nil {
+		cb.Ready(s)
+	}
+}
+
+// Invoked when the server enters lame duck mode.
+func (s *Server) notifyLameDuckEntered() {
+	if cb := s.lifecycleCallbacks(); cb != nil && cb.LameDuckEntered != nil {
+		cb.LameDuckEntered(s)
+	}
+}
+
+// Invoked when JetStream has recovered its state.
+func (s *Server) notifyJetStreamRecovered() {
+	if cb := s.lifecycleCallbacks(); cb != nil && cb.JetStreamRecovered != nil {
+		cb.JetStreamRecovered(s)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestEmbeddedServerOptionsAndCallbacks(t *testing.T) {
+	readyCh := make(chan struct{}, 1)
+	jsCh := make(chan struct{}, 1)
+	ldmCh := make(chan struct{}, 1)
+
+	s, err := NewEmbeddedServer(
+		WithServerName("EMBED"),
+		WithListen("127.0.0.1", -1),
+		WithJetStream(t.TempDir()),
+		WithLifecycleCallbacks(&LifecycleCallbacks{
+			Ready:              func(*Server) { readyCh <- struct{}{} },
+			JetStreamRecovered: func(*Server) { jsCh <- struct{}{} },
+			LameDuckEntered:    func(*Server) { ldmCh <- struct{}{} },
+		}),
+	)
+	require_NoError(t, err)
+	require_True(t, s.Name() == "EMBED")
+
+	go s.Start()
+	defer s.Shutdown()
+
+	for _, ch := range []chan struct{}{jsCh, readyCh} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Did not get lifecycle callback")
+		}
+	}
+	require_True(t, s.JetStreamEnabled())
+
+	nc, err := nats.Connect(s.ClientURL())
+	require_NoError(t, err)
+	nc.Close()
+
+	go s.lameDuckMode()
+	select {
+	case <-ldmCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not get lame duck callback")
+	}
+}
+
+func TestEmbeddedServerOptionsErrors(t *testing.T) {
+	_, err := NewEmbeddedServer(WithListen("127.0.0.1", 70000))
+	require_Error(t, err)
+
+	_, err = NewEmbeddedServer(WithConfigFile("missing.conf"))
+	require_Error(t, err)
+}
+
+func TestEmbeddedServerWithoutListener(t *testing.T) {
+	s, err := NewEmbeddedServer(WithoutListener())
+	require_NoError(t, err)
+	go s.Start()
+	defer s.Shutdown()
+
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("Server not ready")
+	}
+
+	nc, err := nats.Connect(_EMPTY_, nats.InProcessServer(s))
+	require_NoError(t, err)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	require_NoError(t, err)
+	require_NoError(t, nc.Publish("foo", []byte("hello")))
+	m, err := sub.NextMsg(time.Second)
+	require_NoError(t, err)
+	require_True(t, string(m.Data) == "hello")
+}
+
+type inProcessUserProvider struct {
+	s    *Server
+	user *User
+}
+
+func (p *inProcessUserProvider) InProcessConn() (net.Conn, error) {
+	return p.s.InProcessConnAsUser(p.user)
+}
+
+func TestEmbeddedServerInProcessConnAsUser(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		accounts {
+			A { users: [ {user: a, password: pwd} ] }
+		}
+	`))
+	s, err := NewEmbeddedServer(WithConfigFile(conf), WithoutListener())
+	require_NoError(t, err)
+	go s.Start()
+	defer s.Shutdown()
+
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("Server not ready")
+	}
+
+	_, err = s.InProcessConnAsUser(nil)
+	require_Error(t, err)
+
+	acc, err := s.LookupAccount("A")
+	require_NoError(t, err)
+	user := &User{
+		Username:    "embedded",
+		Account:     acc,
+		Permissions: &Permissions{Publish: &SubjectPermission{Deny: []string{"bar"}}},
+	}
+	nc, err := nats.Connect(_EMPTY_, nats.InProcessServer(&inProcessUserProvider{s, user}))
+	require_NoError(t, err)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync(">")
+	require_NoError(t, err)
+	require_NoError(t, nc.Publish("bar", []byte("denied")))
+	require_NoError(t, nc.Publish("foo", []byte("hello")))
+	m, err := sub.NextMsg(time.Second)
+	require_NoError(t, err)
+	require_True(t, m.Subject == "foo")
+
+	// Make sure we are bound to the account.
+	checkFor(t, time.Second, 20*time.Millisecond, func() error {
+		if n := acc.NumLocalConnections(); n != 1 {
+			return fmt.Errorf("Expected 1 connection in account, got %d", n)
+		}
+		return nil
+	})
+
+	// Users of accounts we do not know are rejected.
+	user = &User{Username: "embedded", Account: NewAccount("UNKNOWN")}
+	_, err = nats.Connect(_EMPTY_, nats.InProcessServer(&inProcessUserProvider{s, user}))
+	require_Error(t, err)
+
+	// The account is resolved by name, so a stale account is not bound.
+	user = &User{Username: "embedded", Account: NewAccount("A")}
+	nc2, err := nats.Connect(_EMPTY_, nats.InProcessServer(&inProcessUserProvider{s, user}))
+	require_NoError(t, err)
+	defer nc2.Close()
+	checkFor(t, time.Second, 20*time.Millisecond, func() error {
+		if n := acc.NumLocalConnections(); n != 2 {
+			return fmt.Errorf("Expected 2 connections in account, got %d", n)
+		}
+		return nil
+	})
+}
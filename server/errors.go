@@ -56,6 +56,9 @@ var (
 	// server has been reached.
 	ErrTooManyConnections = errors.New("maximum connections exceeded")
 
+	// ErrServerInStandby signals a client that the server is in standby and does not accept clients.
+	ErrServerInStandby = errors.New("server in standby")
+
 	// ErrTooManyAccountConnections signals that an account has reached its maximum number of active
 	// connections.
 	ErrTooManyAccountConnections = errors.New("maximum account active connections exceeded")
@@ -67,6 +70,10 @@ var (
 	// ErrTooManySubTokens signals a client that the subject has too many tokens.
 	ErrTooManySubTokens = errors.New("subject has exceeded number of tokens limit")
 
+	// ErrGatewaySubjectQuota signals a client that it has reached the maximum number of
+	// subjects it can propagate as interest to remote gateways.
+	ErrGatewaySubjectQuota = errors.New("maximum gateway subjects exceeded")
+
 	// ErrClientConnectedToRoutePort represents an error condition when a client
 	// attempted to connect to the route listen port.
 	ErrClientConnectedToRoutePort = errors.New("attempted to connect to route port")
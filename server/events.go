@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -59,8 +60,10 @@ const (
 	serverPingReqSubj        = "$SYS.REQ.SERVER.PING.%s"
 	serverStatsPingReqSubj   = "$SYS.REQ.SERVER.PING"             // use $SYS.REQ.SERVER.PING.STATSZ instead
 	leafNodeConnectEventSubj = "$SYS.ACCOUNT.%s.LEAFNODE.CONNECT" // for internal use only
+	gwSubjectQuotaEventSubj  = "$SYS.ACCOUNT.%s.GATEWAY.SUBJECT_QUOTA"
 	remoteLatencyEventSubj   = "$SYS.LATENCY.M2.%s"
 	inboxRespSubj            = "$SYS._INBOX.%s.%s"
+	serverDiscoverReqSubj    = "$SYS.REQ.SERVER.DISCOVER"
 
 	// FIXME(dlc) - Should account scope, even with wc for now, but later on
 	// we can then shard as needed.
@@ -93,6 +96,7 @@ type internal struct {
 	stmr     *time.Timer
 	replies  map[string]msgHandler
 	sendq    *ipQueue // of *pubMsg
+	asendq   *ipQueue // of *pubMsg, sent on behalf of other accounts
 	resetCh  chan struct{}
 	wg       sync.WaitGroup
 	sq       *sendq
@@ -134,6 +138,20 @@ type DisconnectEventMsg struct {
 // DisconnectEventMsgType is the schema type for DisconnectEventMsg
 const DisconnectEventMsgType = "io.nats.server.advisory.v1.client_disconnect"
 
+// GatewaySubjectQuotaEventMsg is sent the first time a connection has a subscription
+// rejected for reaching the maximum number of subjects it can propagate to gateways.
+type GatewaySubjectQuotaEventMsg struct {
+	TypedEvent
+	Server  ServerInfo `json:"server"`
+	Client  ClientInfo `json:"client"`
+	Subject string     `json:"subject"`
+	Queue   string     `json:"queue,omitempty"`
+	Quota   int        `json:"quota"`
+}
+
+// GatewaySubjectQuotaEventMsgType is the schema type for GatewaySubjectQuotaEventMsg
+const GatewaySubjectQuotaEventMsgType = "io.nats.server.advisory.v1.gateway_subject_quota"
+
 // AccountNumConns is an event that will be sent from a server that is tracking
 // a given account when the number of connections changes. It will also HB
 // updates in the absence of any changes.
@@ -175,6 +193,8 @@ type ServerInfo struct {
 	Seq       uint64    `json:"seq"`
 	JetStream bool      `json:"jetstream"`
 	Time      time.Time `json:"time"`
+	// Metadata is free form information about the server, gossiped with its statsz.
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // ClientInfo is detailed information about the client forming a connection.
@@ -289,6 +309,8 @@ func (pm *pubMsg) returnToPool() {
 type serverUpdate struct {
 	seq   uint64
 	ltime time.Time
+	// Latest info we heard, used for discovery.
+	si *ServerInfo
 }
 
 // TypedEvent is a event or advisory sent by the server that has nats type hints
@@ -313,6 +335,7 @@ RESET:
 	sysc := s.sys.client
 	resetCh := s.sys.resetCh
 	sendq := s.sys.sendq
+	asendq := s.sys.asendq
 	id := s.info.ID
 	host := s.info.Host
 	servername := s.info.Name
@@ -325,129 +348,155 @@ RESET:
 	}
 	s.mu.RUnlock()
 
-	// Grab tags.
-	tags := s.getOpts().Tags
+	// Sends a single message, returns true if it was the last one.
+	send := func(pm *pubMsg) bool {
+		// Grab tags and metadata, these can be reloaded.
+		opts := s.getOpts()
+		tags, metadata := opts.Tags, opts.Metadata
+		if pm.si != nil {
+			pm.si.Name = servername
+			pm.si.Domain = domain
+			pm.si.Host = host
+			pm.si.Cluster = cluster
+			pm.si.ID = id
+			pm.si.Seq = atomic.AddUint64(seqp, 1)
+			pm.si.Version = VERSION
+			pm.si.Time = time.Now().UTC()
+			pm.si.JetStream = js
+			pm.si.Tags = tags
+			pm.si.Metadata = metadata
+		}
+		var b []byte
+		if pm.msg != nil {
+			switch v := pm.msg.(type) {
+			case string:
+				b = []byte(v)
+			case []byte:
+				b = v
+			default:
+				b, _ = json.Marshal(pm.msg)
+			}
+		}
+		// Setup our client. If the user wants to use a non-system account use our internal
+		// account scoped here so that we are not changing out accounts for the system client.
+		var c *client
+		if pm.c != nil {
+			c = pm.c
+		} else {
+			c = sysc
+		}
 
-	for s.eventsRunning() {
-		select {
-		case <-sendq.ch:
-			msgs := sendq.pop()
-			for _, pmi := range msgs {
-				pm := pmi.(*pubMsg)
-				if pm.si != nil {
-					pm.si.Name = servername
-					pm.si.Domain = domain
-					pm.si.Host = host
-					pm.si.Cluster = cluster
-					pm.si.ID = id
-					pm.si.Seq = atomic.AddUint64(seqp, 1)
-					pm.si.Version = VERSION
-					pm.si.Time = time.Now().UTC()
-					pm.si.JetStream = js
-					pm.si.Tags = tags
-				}
-				var b []byte
-				if pm.msg != nil {
-					switch v := pm.msg.(type) {
-					case string:
-						b = []byte(v)
-					case []byte:
-						b = v
-					default:
-						b, _ = json.Marshal(pm.msg)
-					}
-				}
-				// Setup our client. If the user wants to use a non-system account use our internal
-				// account scoped here so that we are not changing out accounts for the system client.
-				var c *client
-				if pm.c != nil {
-					c = pm.c
-				} else {
-					c = sysc
-				}
+		// Grab client lock.
+		c.mu.Lock()
+
+		// Prep internal structures needed to send message.
+		c.pa.subject, c.pa.reply = []byte(pm.sub), []byte(pm.rply)
+		c.pa.size, c.pa.szb = len(b), []byte(strconv.FormatInt(int64(len(b)), 10))
+		c.pa.hdr, c.pa.hdb = -1, nil
+		trace := c.trace
+
+		// Now check for optional compression.
+		var contentHeader string
+		var bb bytes.Buffer
+
+		if len(b) > 0 {
+			switch pm.oct {
+			case gzipCompression:
+				zw := gzip.NewWriter(&bb)
+				zw.Write(b)
+				zw.Close()
+				b = bb.Bytes()
+				contentHeader = "gzip"
+			case snappyCompression:
+				sw := s2.NewWriter(&bb, s2.WriterSnappyCompat())
+				sw.Write(b)
+				sw.Close()
+				b = bb.Bytes()
+				contentHeader = "snappy"
+			case unsupportedCompression:
+				contentHeader = "identity"
+			}
+		}
+		// Optional Echo
+		replaceEcho := c.echo != pm.echo
+		if replaceEcho {
+			c.echo = !c.echo
+		}
+		c.mu.Unlock()
 
-				// Grab client lock.
-				c.mu.Lock()
+		// Add in NL
+		b = append(b, _CRLF_...)
 
-				// Prep internal structures needed to send message.
-				c.pa.subject, c.pa.reply = []byte(pm.sub), []byte(pm.rply)
-				c.pa.size, c.pa.szb = len(b), []byte(strconv.FormatInt(int64(len(b)), 10))
-				c.pa.hdr, c.pa.hdb = -1, nil
-				trace := c.trace
-
-				// Now check for optional compression.
-				var contentHeader string
-				var bb bytes.Buffer
-
-				if len(b) > 0 {
-					switch pm.oct {
-					case gzipCompression:
-						zw := gzip.NewWriter(&bb)
-						zw.Write(b)
-						zw.Close()
-						b = bb.Bytes()
-						contentHeader = "gzip"
-					case snappyCompression:
-						sw := s2.NewWriter(&bb, s2.WriterSnappyCompat())
-						sw.Write(b)
-						sw.Close()
-						b = bb.Bytes()
-						contentHeader = "snappy"
-					case unsupportedCompression:
-						contentHeader = "identity"
-					}
-				}
-				// Optional Echo
-				replaceEcho := c.echo != pm.echo
-				if replaceEcho {
-					c.echo = !c.echo
-				}
-				c.mu.Unlock()
+		// Check if we should set content-encoding
+		if contentHeader != _EMPTY_ {
+			b = c.setHeader(contentEncodingHeader, contentHeader, b)
+		}
 
-				// Add in NL
-				b = append(b, _CRLF_...)
+		// Optional header processing.
+		if pm.hdr != nil {
+			for k, v := range pm.hdr {
+				b = c.setHeader(k, v, b)
+			}
+		}
+		// Tracing
+		if trace {
+			c.traceInOp(fmt.Sprintf("PUB %s %s %d", c.pa.subject, c.pa.reply, c.pa.size), nil)
+			c.traceMsg(b)
+		}
 
-				// Check if we should set content-encoding
-				if contentHeader != _EMPTY_ {
-					b = c.setHeader(contentEncodingHeader, contentHeader, b)
-				}
+		// Process like a normal inbound msg.
+		c.processInboundClientMsg(b)
 
-				// Optional header processing.
-				if pm.hdr != nil {
-					for k, v := range pm.hdr {
-						b = c.setHeader(k, v, b)
-					}
-				}
-				// Tracing
-				if trace {
-					c.traceInOp(fmt.Sprintf("PUB %s %s %d", c.pa.subject, c.pa.reply, c.pa.size), nil)
-					c.traceMsg(b)
-				}
+		// Put echo back if needed.
+		if replaceEcho {
+			c.mu.Lock()
+			c.echo = !c.echo
+			c.mu.Unlock()
+		}
 
-				// Process like a normal inbound msg.
-				c.processInboundClientMsg(b)
+		// See if we are doing graceful shutdown.
+		if !pm.last {
+			c.flushClients(0) // Never spend time in place.
+			pm.returnToPool()
+			return false
+		}
+		// For the Shutdown event, we need to send in place otherwise
+		// there is a chance that the process will exit before the
+		// writeLoop has a chance to send it.
+		c.flushClients(time.Second)
+		return true
+	}
 
-				// Put echo back if needed.
-				if replaceEcho {
-					c.mu.Lock()
-					c.echo = !c.echo
-					c.mu.Unlock()
-				}
+	// Sends all that is queued on the system sendq, returns true if we sent the last message.
+	sendSystem := func() bool {
+		msgs := sendq.pop()
+		defer sendq.recycle(&msgs)
+		for _, pmi := range msgs {
+			if send(pmi.(*pubMsg)) {
+				return true
+			}
+		}
+		return false
+	}
 
-				// See if we are doing graceful shutdown.
-				if !pm.last {
-					c.flushClients(0) // Never spend time in place.
-				} else {
-					// For the Shutdown event, we need to send in place otherwise
-					// there is a chance that the process will exit before the
-					// writeLoop has a chance to send it.
-					c.flushClients(time.Second)
-					sendq.recycle(&msgs)
+	for s.eventsRunning() {
+		select {
+		case <-sendq.ch:
+			if sendSystem() {
+				return
+			}
+		case <-asendq.ch:
+			msgs := asendq.pop()
+			for _, pmi := range msgs {
+				// Traffic of the system account, e.g. heartbeats, always goes ahead of what
+				// we send for other accounts, so a busy account can not hold it back.
+				if sendq.len() > 0 && sendSystem() {
+					asendq.recycle(&msgs)
 					return
 				}
-				pm.returnToPool()
+				send(pmi.(*pubMsg))
 			}
-			sendq.recycle(&msgs)
+			asendq.recycle(&msgs)
 		case <-resetCh:
 			goto RESET
 		case <-s.quitCh:
@@ -494,7 +543,11 @@ func (s *Server) sendInternalAccountMsgWithReply(a *Account, subject, reply stri
 		c = a.internalClient()
 		a.mu.Unlock()
 	}
-	s.sys.sendq.push(newPubMsg(c, subject, reply, nil, hdr, msg, noCompression, echo, false))
+	sendq := s.sys.sendq
+	if a != nil && a != s.sys.account {
+		sendq = s.sys.asendq
+	}
+	sendq.push(newPubMsg(c, subject, reply, nil, hdr, msg, noCompression, echo, false))
 	s.mu.RUnlock()
 	return nil
 }
@@ -918,6 +971,21 @@ func (s *Server) initEventTracking() {
 			optz := &HealthzEventOptions{}
 			s.zReq(c, reply, msg, &optz.EventFilterOptions, optz, func() (interface{}, error) { return s.healthz(&optz.HealthzOptions), nil })
 		},
+		"BACKOFF": func(sub *subscription, c *client, _ *Account, subject, reply string, msg []byte) {
+			optz := &BackoffEventOptions{}
+			s.zReq(c, reply, msg, &optz.EventFilterOptions, optz, func() (interface{}, error) { return s.Backoff(&optz.BackoffOptions), nil })
+		},
+		"PROBEZ": func(sub *subscription, c *client, _ *Account, subject, reply string, msg []byte) {
+			optz := &ProbezEventOptions{}
+			s.zReq(c, reply, msg, &optz.EventFilterOptions, optz, func() (interface{}, error) { return s.Probez(&optz.ProbezOptions) })
+		},
+	}
+	// Discovery is answered from what we heard through gossip, so requestors can take the first response.
+	if _, err := s.sysSubscribe(serverDiscoverReqSubj, func(sub *subscription, c *client, _ *Account, subject, reply string, msg []byte) {
+		optz := &DiscoverzOptions{}
+		s.zReq(c, reply, msg, &EventFilterOptions{}, optz, func() (interface{}, error) { return s.Discoverz(optz), nil })
+	}); err != nil {
+		s.Errorf("Error setting up internal tracking: %v", err)
 	}
 	for name, req := range monSrvc {
 		subject = fmt.Sprintf(serverDirectReqSubj, s.info.ID, name)
@@ -929,6 +997,19 @@ func (s *Server) initEventTracking() {
 			s.Errorf("Error setting up internal tracking: %v", err)
 		}
 	}
+	// Probes of the synthetic traffic prober of any server are answered by all.
+	subject = fmt.Sprintf(serverDirectReqSubj, s.info.ID, "PROBE")
+	if _, err := s.sysSubscribe(subject, s.probeRequest); err != nil {
+		s.Errorf("Error setting up internal tracking: %v", err)
+	}
+	// Draining JetStream assets is only ever addressed to a single server.
+	subject = fmt.Sprintf(serverDirectReqSubj, s.info.ID, "JS.DRAIN")
+	if _, err := s.sysSubscribe(subject, func(sub *subscription, c *client, _ *Account, subject, reply string, msg []byte) {
+		optz := &JSDrainOptions{}
+		s.zReq(c, reply, msg, &EventFilterOptions{}, optz, func() (interface{}, error) { return s.JetStreamDrain(optz) })
+	}); err != nil {
+		s.Errorf("Error setting up internal tracking: %v", err)
+	}
 	extractAccount := func(c *client, subject string, msg []byte) (string, error) {
 		if tk := strings.Split(subject, tsep); len(tk) != accReqTokens {
 			return _EMPTY_, fmt.Errorf("subject %q is malformed", subject)
@@ -1014,6 +1095,17 @@ func (s *Server) initEventTracking() {
 				}
 			})
 		},
+		"TALKERZ": func(sub *subscription, c *client, _ *Account, subject, reply string, msg []byte) {
+			optz := &TalkerzEventOptions{}
+			s.zReq(c, reply, msg, &optz.EventFilterOptions, optz, func() (interface{}, error) {
+				if acc, err := extractAccount(c, subject, msg); err != nil {
+					return nil, err
+				} else {
+					optz.Account = acc
+					return s.Talkerz(&optz.TalkerzOptions)
+				}
+			})
+		},
 		"CONNS": s.connsRequest,
 	}
 	for name, req := range monAccSrvc {
@@ -1266,7 +1358,7 @@ func (s *Server) remoteServerUpdate(sub *subscription, c *client, _ *Account, su
 func (s *Server) updateRemoteServer(si *ServerInfo) {
 	su := s.sys.servers[si.ID]
 	if su == nil {
-		s.sys.servers[si.ID] = &serverUpdate{si.Seq, time.Now()}
+		s.sys.servers[si.ID] = &serverUpdate{si.Seq, time.Now(), si}
 		s.processNewServer(si)
 	} else {
 		// Should always be going up.
@@ -1276,6 +1368,7 @@ func (s *Server) updateRemoteServer(si *ServerInfo) {
 		}
 		su.seq = si.Seq
 		su.ltime = time.Now()
+		su.si = si
 	}
 }
 
@@ -1414,6 +1507,8 @@ type EventFilterOptions struct {
 	Host    string   `json:"host,omitempty"`        // filter by host name
 	Tags    []string `json:"tags,omitempty"`        // filter by tags (must match all tags)
 	Domain  string   `json:"domain,omitempty"`      // filter by JS domain
+	// Filter by metadata (must match all keys and values)
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // StatszEventOptions are options passed to Statsz
@@ -1482,6 +1577,24 @@ type JszEventOptions struct {
 	EventFilterOptions
 }
 
+// In the context of system events, BackoffEventOptions are options passed to Backoff
+type BackoffEventOptions struct {
+	BackoffOptions
+	EventFilterOptions
+}
+
+// In the context of system events, TalkerzEventOptions are options passed to Talkerz
+type TalkerzEventOptions struct {
+	TalkerzOptions
+	EventFilterOptions
+}
+
+// In the context of system events, ProbezEventOptions are options passed to Probez
+type ProbezEventOptions struct {
+	ProbezOptions
+	EventFilterOptions
+}
+
 // In the context of system events, HealthzEventOptions are options passed to Healthz
 type HealthzEventOptions struct {
 	HealthzOptions
@@ -1513,9 +1626,93 @@ func (s *Server) filterRequest(fOpts *EventFilterOptions) bool {
 	if fOpts.Domain != _EMPTY_ && s.getOpts().JetStreamDomain != fOpts.Domain {
 		return true
 	}
+	if len(fOpts.Metadata) > 0 && !metadataMatches(s.getOpts().Metadata, fOpts.Metadata) {
+		return true
+	}
 	return false
 }
 
+// Returns true if md has all of the keys and values in filter.
+func metadataMatches(md, filter map[string]string) bool {
+	for k, v := range filter {
+		if mv, ok := md[k]; !ok || mv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// DiscoverzOptions are options passed to Discoverz.
+type DiscoverzOptions struct {
+	Cluster  string            `json:"cluster,omitempty"`  // filter by cluster name
+	Tags     []string          `json:"tags,omitempty"`     // filter by tags (must match all tags)
+	Metadata map[string]string `json:"metadata,omitempty"` // filter by metadata (must match all keys and values)
+}
+
+// Discoverz lists the servers we know about through gossip, including ourselves.
+type Discoverz struct {
+	ID      string        `json:"server_id"`
+	Now     time.Time     `json:"now"`
+	Servers []*ServerInfo `json:"servers"`
+}
+
+// Discoverz returns the servers we heard from, with their tags and metadata.
+func (s *Server) Discoverz(opts *DiscoverzOptions) *Discoverz {
+	if opts == nil {
+		opts = &DiscoverzOptions{}
+	}
+	sopts := s.getOpts()
+
+	s.mu.RLock()
+	cluster := s.info.Cluster
+	if s.gateway.enabled {
+		cluster = s.getGatewayName()
+	}
+	ourselves := &ServerInfo{
+		Name:      s.info.Name,
+		Host:      s.info.Host,
+		ID:        s.info.ID,
+		Cluster:   cluster,
+		Domain:    s.info.Domain,
+		Version:   VERSION,
+		Tags:      sopts.Tags,
+		JetStream: s.info.JetStream,
+		Time:      time.Now().UTC(),
+		Metadata:  sopts.Metadata,
+	}
+	servers := []*ServerInfo{ourselves}
+	if s.sys != nil {
+		for _, su := range s.sys.servers {
+			if su.si != nil {
+				servers = append(servers, su.si)
+			}
+		}
+	}
+	dz := &Discoverz{ID: s.info.ID, Now: time.Now().UTC()}
+	s.mu.RUnlock()
+
+	hasTags := func(si *ServerInfo) bool {
+		tags := jwt.TagList(si.Tags)
+		for _, t := range opts.Tags {
+			if !tags.Contains(t) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, si := range servers {
+		if opts.Cluster != _EMPTY_ && si.Cluster != opts.Cluster {
+			continue
+		}
+		if !hasTags(si) || !metadataMatches(si.Metadata, opts.Metadata) {
+			continue
+		}
+		dz.Servers = append(dz.Servers, si)
+	}
+	sort.Slice(dz.Servers, func(i, j int) bool { return dz.Servers[i].Name < dz.Servers[j].Name })
+	return dz
+}
+
 // Encoding support (compression)
 type compressionType int8
 
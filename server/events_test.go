@@ -1661,7 +1661,7 @@ func TestSystemAccountWithGateways(t *testing.T) {
 
 	// If this tests fails with wrong number after 10 seconds we may have
 	// added a new inititial subscription for the eventing system.
-	checkExpectedSubs(t, 45, sa)
+	checkExpectedSubs(t, 53, sa)
 
 	// Create a client on B and see if we receive the event
 	urlb := fmt.Sprintf("nats://%s:%d", ob.Host, ob.Port)
@@ -2536,3 +2536,85 @@ func Benchmark_GetHash(b *testing.B) {
 	default:
 	}
 }
+
+func TestServerEventsDiscoverz(t *testing.T) {
+	zones := map[string]string{"S-1": "east", "S-2": "west", "S-3": "east"}
+	c := createJetStreamClusterWithTemplateAndModHook(t, jsClusterTempl, "R3S", 3,
+		func(serverName, clusterName, storeDir, conf string) string {
+			return conf + fmt.Sprintf("\nserver_metadata { zone: %q, gpus: 4 }\n", zones[serverName])
+		})
+	defer c.shutdown()
+
+	s := c.randomServer()
+	require_True(t, s.getOpts().Metadata["gpus"] == "4")
+
+	nc, err := nats.Connect(s.ClientURL(), nats.UserInfo("admin", "s3cr3t!"))
+	require_NoError(t, err)
+	defer nc.Close()
+
+	discover := func(opts *DiscoverzOptions) *Discoverz {
+		t.Helper()
+		req, err := json.Marshal(opts)
+		require_NoError(t, err)
+		resp, err := nc.Request(serverDiscoverReqSubj, req, time.Second)
+		require_NoError(t, err)
+		var sresp struct {
+			Data  *Discoverz `json:"data"`
+			Error *ApiError  `json:"error"`
+		}
+		require_NoError(t, json.Unmarshal(resp.Data, &sresp))
+		require_True(t, sresp.Error == nil)
+		return sresp.Data
+	}
+
+	// Wait for everyone to have heard from each other.
+	checkFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+		if dz := discover(&DiscoverzOptions{}); len(dz.Servers) != 3 {
+			return fmt.Errorf("Expected 3 servers, got %d", len(dz.Servers))
+		}
+		return nil
+	})
+	dz := discover(&DiscoverzOptions{})
+	for i, si := range dz.Servers {
+		require_True(t, si.Name == fmt.Sprintf("S-%d", i+1))
+		require_True(t, si.Cluster == "R3S")
+		require_True(t, si.Metadata["zone"] == zones[si.Name])
+		require_True(t, si.Metadata["gpus"] == "4")
+	}
+
+	dz = discover(&DiscoverzOptions{Metadata: map[string]string{"zone": "east"}})
+	require_True(t, len(dz.Servers) == 2)
+	require_True(t, dz.Servers[0].Name == "S-1" && dz.Servers[1].Name == "S-3")
+	dz = discover(&DiscoverzOptions{Metadata: map[string]string{"zone": "west", "gpus": "8"}})
+	require_True(t, len(dz.Servers) == 0)
+	dz = discover(&DiscoverzOptions{Cluster: "other"})
+	require_True(t, len(dz.Servers) == 0)
+
+	// Regular system requests can be filtered by metadata too.
+	sub, err := nc.SubscribeSync(nats.NewInbox())
+	require_NoError(t, err)
+	req, err := json.Marshal(&EventFilterOptions{Metadata: map[string]string{"zone": "west"}})
+	require_NoError(t, err)
+	require_NoError(t, nc.PublishRequest(fmt.Sprintf(serverPingReqSubj, "VARZ"), sub.Subject, req))
+	msg, err := sub.NextMsg(time.Second)
+	require_NoError(t, err)
+	var vresp ServerAPIResponse
+	require_NoError(t, json.Unmarshal(msg.Data, &vresp))
+	require_True(t, vresp.Server.Name == "S-2")
+	require_True(t, vresp.Server.Metadata["zone"] == "west")
+	_, err = sub.NextMsg(250 * time.Millisecond)
+	require_Error(t, err, nats.ErrTimeout)
+
+	// Metadata can be reloaded and is gossiped to the others.
+	s2 := c.serverByName("S-2")
+	conf, err := os.ReadFile(s2.getOpts().ConfigFile)
+	require_NoError(t, err)
+	reloadUpdateConfig(t, s2, s2.getOpts().ConfigFile, strings.Replace(string(conf), "west", "north", 1))
+	checkFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+		dz := discover(&DiscoverzOptions{Metadata: map[string]string{"zone": "north"}})
+		if len(dz.Servers) != 1 || dz.Servers[0].Name != "S-2" {
+			return fmt.Errorf("Expected S-2 to be in zone north, got %+v", dz.Servers)
+		}
+		return nil
+	})
+}
@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
@@ -54,11 +55,37 @@ type FileStoreConfig struct {
 	CacheExpire time.Duration
 	// SyncInterval is how often we sync to disk in the background.
 	SyncInterval time.Duration
+	// SyncAlways will sync to disk after every write instead.
+	SyncAlways bool
 	// AsyncFlush allows async flush to batch write operations.
 	AsyncFlush bool
 	// Cipher is the cipher to use when encrypting.
 	Cipher StoreCipher
-}
+	// Tier is an optional backend to move message blocks older than the stream's TierAge to.
+	Tier TierBackend
+	// RecoveryHandler, if set, will be called periodically with the progress of recovering message blocks.
+	RecoveryHandler func(RecoveryProgress)
+	// RecoveryWorkers limits how many message blocks are recovered concurrently.
+	// Defaults to and is bounded by GOMAXPROCS.
+	RecoveryWorkers int
+	// Holds are the subjects on hold when recovering, see SetHolds.
+	Holds []string
+	// FlushCPUs, if set, are the CPUs our flush loops are pinned to.
+	FlushCPUs []int
+}
+
+// RecoveryProgress describes how far along a file store is in recovering its message blocks.
+type RecoveryProgress struct {
+	Blocks    int           `json:"blocks"`
+	Recovered int           `json:"recovered"`
+	Percent   float64       `json:"percent"`
+	Elapsed   time.Duration `json:"elapsed"`
+	ETA       time.Duration `json:"eta,omitempty"`
+	Done      bool          `json:"done,omitempty"`
+}
+
+// How often we report recovery progress. Var for testing.
+var recoveryProgressInterval = 2 * time.Second
 
 // FileStreamInfo allows us to remember created time.
 type FileStreamInfo struct {
@@ -99,31 +126,49 @@ const (
 
 type psi struct {
 	total uint64
+	bytes uint64
 	fblk  uint32
 	lblk  uint32
 }
 
 type fileStore struct {
-	mu      sync.RWMutex
-	state   StreamState
-	ld      *LostStreamData
-	scb     StorageUpdateHandler
-	ageChk  *time.Timer
-	syncTmr *time.Timer
-	cfg     FileStreamInfo
-	fcfg    FileStoreConfig
-	prf     keyGen
-	aek     cipher.AEAD
-	lmb     *msgBlock
-	blks    []*msgBlock
-	bim     map[uint32]*msgBlock
-	psim    map[string]*psi
-	hh      hash.Hash64
-	qch     chan struct{}
-	cfs     []ConsumerStore
-	sips    int
-	closed  bool
-	fip     bool
+	mu       sync.RWMutex
+	state    StreamState
+	ld       *LostStreamData
+	scb      StorageUpdateHandler
+	ecb      EvictionHandler
+	ageChk   *time.Timer
+	syncTmr  *time.Timer
+	tierTmr  *time.Timer
+	scrubTmr *time.Timer
+	scrub    int32
+	cfg      FileStreamInfo
+	fcfg     FileStoreConfig
+	prf      keyGen
+	aek      cipher.AEAD
+	lmb      *msgBlock
+	blks     []*msgBlock
+	bim      map[uint32]*msgBlock
+	psim     map[string]*psi
+	hh       hash.Hash64
+	qch      chan struct{}
+	cfs      []ConsumerStore
+	sips     int
+	closed   bool
+	fip      bool
+	frozen   bool
+	holds    []string
+	rates    storeRates
+	// Messages scheduled for later delivery.
+	sched      map[uint64]int64
+	schedLseq  uint64
+	schedDirty bool
+	// Sync interval and always sync unless set by the stream config.
+	dsi time.Duration
+	dsa bool
+	// Rewriting of sealed blocks to our compression.
+	rcmp    *RecompressProgress
+	rcmpQch chan struct{}
 }
 
 // Represents a message store block and its data.
@@ -148,6 +193,8 @@ type msgBlock struct {
 	msgs    uint64 // User visible message count.
 	fss     map[string]*SimpleState
 	sfn     string
+	sbf     *subjectBloom // Subject bloom filter, kept when fss is not loaded.
+	bloom   bool
 	kfn     string
 	lwits   int64
 	lwts    int64
@@ -156,14 +203,17 @@ type msgBlock struct {
 	llseq   uint64
 	hh      hash.Hash64
 	cache   *cache
+	tsi     []msgId // Sparse timestamp index, nil until first needed.
 	cloads  uint64
 	cexp    time.Duration
 	ctmr    *time.Timer
 	werr    error
-	dmap    map[uint64]struct{}
+	dmap    deleteMap
 	fch     chan struct{}
 	qch     chan struct{}
 	lchk    [8]byte
+	ckey    string // Key in the tier backend once moved to cold storage.
+	cmp     StoreCompression
 	loading bool
 	flusher bool
 	noTrack bool
@@ -194,6 +244,8 @@ const (
 	magic = uint8(22)
 	// Version
 	version = uint8(1)
+	// Version of the per subject state files, these also track bytes.
+	fssVersion = uint8(2)
 	// hdrLen
 	hdrLen = 2
 	// This is where we keep the streams.
@@ -265,6 +317,8 @@ const (
 	wiThresh = int64(2 * time.Second)
 	// Time threshold to write index info for non FIFO cases
 	winfThresh = int64(500 * time.Millisecond)
+	// Number of messages between entries of the sparse timestamp index of a block.
+	tsIndexInterval = 64
 )
 
 func newFileStore(fcfg FileStoreConfig, cfg StreamConfig) (*fileStore, error) {
@@ -308,13 +362,17 @@ func newFileStoreWithCreated(fcfg FileStoreConfig, cfg StreamConfig, created tim
 	os.Remove(tmpfile.Name())
 
 	fs := &fileStore{
-		fcfg: fcfg,
-		psim: make(map[string]*psi),
-		bim:  make(map[uint32]*msgBlock),
-		cfg:  FileStreamInfo{Created: created, StreamConfig: cfg},
-		prf:  prf,
-		qch:  make(chan struct{}),
-	}
+		fcfg:  fcfg,
+		psim:  make(map[string]*psi),
+		bim:   make(map[uint32]*msgBlock),
+		cfg:   FileStreamInfo{Created: created, StreamConfig: cfg},
+		prf:   prf,
+		qch:   make(chan struct{}),
+		dsi:   fcfg.SyncInterval,
+		dsa:   fcfg.SyncAlways,
+		holds: copyStrings(fcfg.Holds),
+	}
+	fs.applySyncPolicy(&cfg)
 
 	// Set flush in place to AsyncFlush which by default is false.
 	fs.fip = !fcfg.AsyncFlush
@@ -341,6 +399,11 @@ func newFileStoreWithCreated(fcfg FileStoreConfig, cfg StreamConfig, created tim
 		return nil, err
 	}
 
+	// Recover any messages scheduled for later delivery.
+	fs.mu.Lock()
+	fs.recoverSchedule()
+	fs.mu.Unlock()
+
 	// Write our meta data if it does not exist or is zero'd out.
 	meta := filepath.Join(fcfg.StoreDir, JetStreamMetaFile)
 	fi, err := os.Stat(meta)
@@ -363,6 +426,21 @@ func newFileStoreWithCreated(fcfg FileStoreConfig, cfg StreamConfig, created tim
 
 	fs.syncTmr = time.AfterFunc(fs.fcfg.SyncInterval, fs.syncBlocks)
 
+	// Check if we will be moving older blocks to cold storage.
+	fs.mu.Lock()
+	fs.startTierTimer()
+	fs.applyScrubPolicy(&cfg)
+	// Catch up on any blocks not yet stored with our compression.
+	fs.startRecompressLocked()
+	fs.mu.Unlock()
+
+	// If we stopped scrubbing make sure nothing is left behind.
+	if sdir := filepath.Join(fcfg.StoreDir, scrubDir); !fs.scrubbing() {
+		if _, err := os.Stat(sdir); err == nil {
+			go scrubPath(sdir)
+		}
+	}
+
 	return fs, nil
 }
 
@@ -410,25 +488,44 @@ func (fs *fileStore) UpdateConfig(cfg *StreamConfig) error {
 		return err
 	}
 
-	// Limits checks and enforcement.
-	fs.enforceMsgLimit()
-	fs.enforceBytesLimit()
+	// Pick up any change to how often we sync.
+	if fs.applySyncPolicy(cfg) && fs.syncTmr != nil {
+		fs.syncTmr.Reset(fs.fcfg.SyncInterval)
+	}
+	if cfg.ScrubInterval != old_cfg.ScrubInterval {
+		fs.applyScrubPolicy(cfg)
+	}
+	if cfg.SubjectBloom != old_cfg.SubjectBloom {
+		fs.setSubjectBloom(cfg.SubjectBloom)
+	}
+	if cfg.Compression != old_cfg.Compression {
+		fs.startRecompressLocked()
+	}
+
+	// Limits checks and enforcement, while frozen these are applied once thawed.
+	if !fs.frozen {
+		fs.enforceMsgLimit()
+		fs.enforceBytesLimit()
+	}
 
 	// Do age timers.
-	if fs.ageChk == nil && fs.cfg.MaxAge != 0 {
+	if fs.ageChk == nil && fs.cfg.expiryAge() != 0 {
 		fs.startAgeChk()
 	}
-	if fs.ageChk != nil && fs.cfg.MaxAge == 0 {
+	if fs.ageChk != nil && fs.cfg.expiryAge() == 0 {
 		fs.ageChk.Stop()
 		fs.ageChk = nil
 	}
 
-	if cfg.MaxMsgsPer > 0 && cfg.MaxMsgsPer < old_cfg.MaxMsgsPer {
+	if !fs.frozen && cfg.MaxMsgsPer > 0 && cfg.MaxMsgsPer < old_cfg.MaxMsgsPer {
 		fs.enforceMsgPerSubjectLimit()
 	}
+	if !fs.frozen && cfg.MaxBytesPer > 0 && (old_cfg.MaxBytesPer <= 0 || cfg.MaxBytesPer < old_cfg.MaxBytesPer) {
+		fs.enforceAllBytesPerSubjectLimits()
+	}
 	fs.mu.Unlock()
 
-	if cfg.MaxAge != 0 {
+	if cfg.expiryAge() != 0 {
 		fs.expireMsgs()
 	}
 	return nil
@@ -643,12 +740,14 @@ func (fs *fileStore) noTrackSubjects() bool {
 	return !(len(fs.psim) > 0 || len(fs.cfg.Subjects) > 0 || fs.cfg.Mirror != nil || len(fs.cfg.Sources) > 0)
 }
 
-// Lock held on entry
-func (fs *fileStore) recoverMsgBlock(fi os.FileInfo, index uint32) (*msgBlock, error) {
-	mb := &msgBlock{fs: fs, index: index, cexp: fs.fcfg.CacheExpire, noTrack: fs.noTrackSubjects()}
+// recoverMsgBlock will recover the state of the msg block with the given index.
+// This only touches the block itself so it is safe to call concurrently for different blocks.
+// The returned block still needs to be added to the store with addRecoveredMsgBlock.
+func (fs *fileStore) recoverMsgBlock(index uint32, noTrack bool) (*msgBlock, *LostStreamData, error) {
+	mb := &msgBlock{fs: fs, index: index, cexp: fs.fcfg.CacheExpire, noTrack: noTrack, bloom: fs.cfg.SubjectBloom}
 
 	mdir := filepath.Join(fs.fcfg.StoreDir, msgDir)
-	mb.mfn = filepath.Join(mdir, fi.Name())
+	mb.mfn = filepath.Join(mdir, fmt.Sprintf(blkScan, index))
 	mb.ifn = filepath.Join(mdir, fmt.Sprintf(indexScan, index))
 	mb.sfn = filepath.Join(mdir, fmt.Sprintf(fssScan, index))
 
@@ -657,6 +756,23 @@ func (fs *fileStore) recoverMsgBlock(fi os.FileInfo, index uint32) (*msgBlock, e
 		mb.hh, _ = highwayhash.New64(key[:])
 	}
 
+	// Check if our data has been moved to cold storage.
+	ci, err := mb.readColdInfo()
+	if err == nil {
+		if _, err := os.Stat(mb.mfn); err == nil {
+			// We were interrupted moving the block, the local copy is still good.
+			os.Remove(mb.coldFileName())
+			if tier := fs.fcfg.Tier; tier != nil {
+				go tier.Delete(ci.Key)
+			}
+			ci = nil
+		} else if fs.fcfg.Tier == nil {
+			return nil, nil, errNoTierBackend
+		} else {
+			mb.ckey, mb.rbytes = ci.Key, ci.Size
+		}
+	}
+
 	var createdKeys bool
 
 	// Check if encryption is enabled.
@@ -666,109 +782,126 @@ func (fs *fileStore) recoverMsgBlock(fi os.FileInfo, index uint32) (*msgBlock, e
 			// We do not seem to have keys even though we should. Could be a plaintext conversion.
 			// Create the keys and we will double check below.
 			if err := fs.genEncryptionKeysForBlock(mb); err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			createdKeys = true
 		} else {
 			if len(ekey) < minBlkKeySize {
-				return nil, errBadKeySize
+				return nil, nil, errBadKeySize
 			}
 			// Recover key encryption key.
 			rb, err := fs.prf([]byte(fmt.Sprintf("%s:%d", fs.cfg.Name, mb.index)))
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
 			sc := fs.fcfg.Cipher
 			kek, err := genEncryptionKey(sc, rb)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			ns := kek.NonceSize()
 			seed, err := kek.Open(nil, ekey[:ns], ekey[ns:], nil)
 			if err != nil {
 				// We may be here on a cipher conversion, so attempt to convert.
+				if err = mb.thawLocked(); err != nil {
+					return nil, nil, err
+				}
 				if err = mb.convertCipher(); err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 			} else {
 				mb.seed, mb.nonce = seed, ekey[:ns]
 			}
 			mb.aek, err = genEncryptionKey(sc, mb.seed)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			if mb.bek, err = genBlockEncryptionKey(sc, mb.seed, mb.nonce); err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		}
 	}
 
 	// If we created keys here, let's check the data and if it is plaintext convert here.
 	if createdKeys {
+		if err := mb.thawLocked(); err != nil {
+			return nil, nil, err
+		}
 		if err := mb.convertToEncrypted(); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	// Open up the message file, but we will try to recover from the index file.
-	// We will check that the last checksums match.
-	file, err := os.Open(mb.mfn)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	if fi, err := file.Stat(); fi != nil {
-		mb.rbytes = uint64(fi.Size())
-	} else {
-		return nil, err
-	}
-	// Grab last checksum from main block file.
 	var lchk [8]byte
-	if mb.rbytes >= checksumSize {
-		if mb.bek != nil {
-			if buf, _ := mb.loadBlock(nil); len(buf) >= checksumSize {
-				mb.bek.XORKeyStream(buf, buf)
-				copy(lchk[0:], buf[len(buf)-checksumSize:])
-			}
+	if mb.ckey != _EMPTY_ {
+		// Our cold marker holds the last checksum.
+		copy(lchk[:], ci.LChk)
+	} else {
+		// Open up the message file, but we will try to recover from the index file.
+		// We will check that the last checksums match.
+		file, err := os.Open(mb.mfn)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+
+		if fi, err := file.Stat(); fi != nil {
+			mb.rbytes = uint64(fi.Size())
 		} else {
-			file.ReadAt(lchk[:], fi.Size()-checksumSize)
+			return nil, nil, err
+		}
+		// If compressed our raw bytes are those of the uncompressed data.
+		if alg, rsz, ok := readCompressedHeader(file); ok {
+			mb.cmp, mb.rbytes = alg, rsz
+		}
+		// Grab last checksum from main block file.
+		if mb.rbytes >= checksumSize {
+			if mb.bek != nil || mb.cmp != NoCompression {
+				if buf, _ := mb.loadBlock(nil); len(buf) >= checksumSize {
+					if mb.bek != nil {
+						mb.bek.XORKeyStream(buf, buf)
+					}
+					copy(lchk[0:], buf[len(buf)-checksumSize:])
+				}
+			} else {
+				file.ReadAt(lchk[:], int64(mb.rbytes)-checksumSize)
+			}
 		}
-	}
 
-	file.Close()
+		file.Close()
+	}
 
 	// Read our index file. Use this as source of truth if possible.
 	if err := mb.readIndexInfo(); err == nil {
 		// Quick sanity check here.
 		// Note this only checks that the message blk file is not newer then this file, or is empty and we expect empty.
 		if (mb.rbytes == 0 && mb.msgs == 0) || bytes.Equal(lchk[:], mb.lchk[:]) {
-			if mb.msgs > 0 && !mb.noTrack && fs.psim != nil {
-				fs.populateGlobalPerSubjectInfo(mb)
-				// Try to dump any state we needed on recovery.
-				mb.tryForceExpireCacheLocked()
-			}
-			fs.addMsgBlock(mb)
-			return mb, nil
+			fs.loadPerSubjectInfoForRecovery(mb)
+			return mb, nil, nil
 		}
 	}
 
+	ld, _ := mb.rebuildState()
+	fs.loadPerSubjectInfoForRecovery(mb)
+
+	// Rewrite this to make sure we are sync'd.
+	mb.writeIndexInfo()
+	mb.closeFDs()
+	return mb, ld, nil
+}
+
+// Will add a block returned from recoverMsgBlock to the store.
+// Lock should be held.
+func (fs *fileStore) addRecoveredMsgBlock(mb *msgBlock, ld *LostStreamData) {
 	// If we get data loss rebuilding the message block state record that with the fs itself.
-	if ld, _ := mb.rebuildState(); ld != nil {
-		fs.addLostData(ld)
-	}
+	fs.addLostData(ld)
 	if mb.msgs > 0 && !mb.noTrack && fs.psim != nil {
 		fs.populateGlobalPerSubjectInfo(mb)
 		// Try to dump any state we needed on recovery.
 		mb.tryForceExpireCacheLocked()
 	}
-
-	// Rewrite this to make sure we are sync'd.
-	mb.writeIndexInfo()
-	mb.closeFDs()
 	fs.addMsgBlock(mb)
-	return mb, nil
 }
 
 func (fs *fileStore) lostData() *LostStreamData {
@@ -865,7 +998,13 @@ func (mb *msgBlock) convertCipher() error {
 		return err
 	}
 
-	buf, _ := mb.loadBlock(nil)
+	buf, _ := mb.loadBlockFile(nil)
+	// A compressed block was encrypted with the old cipher as well.
+	if bytes.HasPrefix(buf, cmpMagic) {
+		if buf, err = expandBlock(buf, func() (cipher.Stream, error) { return genBlockEncryptionKey(osc, seed, nonce) }); err != nil {
+			return err
+		}
+	}
 	bek.XORKeyStream(buf, buf)
 	// Make sure we can parse with old cipher and key file.
 	if err = mb.indexCacheBuf(buf); err != nil {
@@ -933,6 +1072,9 @@ func (mb *msgBlock) rebuildState() (*LostStreamData, error) {
 func (mb *msgBlock) rebuildStateLocked() (*LostStreamData, error) {
 	startLastSeq := mb.last.seq
 
+	// We may need to truncate below, which we can only do without compression.
+	mb.decompressLocked()
+
 	buf, err := mb.loadBlock(nil)
 	if err != nil || len(buf) == 0 {
 		var ld *LostStreamData
@@ -941,13 +1083,13 @@ func (mb *msgBlock) rebuildStateLocked() (*LostStreamData, error) {
 			// We need to declare lost data here.
 			ld = &LostStreamData{Msgs: make([]uint64, 0, mb.msgs), Bytes: mb.bytes}
 			for seq := mb.first.seq; seq <= mb.last.seq; seq++ {
-				if _, ok := mb.dmap[seq]; !ok {
+				if !mb.dmap.exists(seq) {
 					ld.Msgs = append(ld.Msgs, seq)
 				}
 			}
 			// Clear invalid state. We will let this blk be added in here.
 			mb.msgs, mb.bytes, mb.rbytes, mb.fss = 0, 0, 0, nil
-			mb.dmap = nil
+			mb.dmap.empty()
 			mb.first.seq = mb.last.seq + 1
 		}
 		return ld, err
@@ -974,10 +1116,7 @@ func (mb *msgBlock) rebuildStateLocked() (*LostStreamData, error) {
 		if seq == 0 {
 			return
 		}
-		if mb.dmap == nil {
-			mb.dmap = make(map[uint64]struct{})
-		}
-		mb.dmap[seq] = struct{}{}
+		mb.dmap.insert(seq)
 	}
 
 	var le = binary.LittleEndian
@@ -995,6 +1134,7 @@ func (mb *msgBlock) rebuildStateLocked() (*LostStreamData, error) {
 		if fd == nil {
 			return
 		}
+		mb.scrubTail(fd, int64(index))
 		if err := fd.Truncate(int64(index)); err == nil {
 			// Update our checksum.
 			if index >= 8 {
@@ -1063,9 +1203,7 @@ func (mb *msgBlock) rebuildStateLocked() (*LostStreamData, error) {
 		}
 
 		var deleted bool
-		if mb.dmap != nil {
-			_, deleted = mb.dmap[seq]
-		}
+		deleted = mb.dmap.exists(seq)
 
 		// Always set last.
 		mb.last.seq = seq
@@ -1105,12 +1243,13 @@ func (mb *msgBlock) rebuildStateLocked() (*LostStreamData, error) {
 				// For the lookup, we cast the byte slice and there won't be any copy
 				if ss := mb.fss[string(data[:slen])]; ss != nil {
 					ss.Msgs++
+					ss.Bytes += uint64(rl)
 					ss.Last = seq
 				} else {
 					// This will either use a subject from the config, or make a copy
 					// so we don't reference the underlying buffer.
 					subj := mb.subjString(data[:slen])
-					mb.fss[subj] = &SimpleState{Msgs: 1, First: seq, Last: seq}
+					mb.fss[subj] = &SimpleState{Msgs: 1, Bytes: uint64(rl), First: seq, Last: seq}
 				}
 			}
 		}
@@ -1142,30 +1281,73 @@ func (fs *fileStore) recoverMsgs() error {
 		return errNotReadable
 	}
 
-	// Recover all of the msg blocks.
-	// These can come in a random order, so account for that.
+	// Collect the indexes of all msg blocks first so we can report progress.
+	var indexes []uint32
 	for _, fi := range fis {
 		var index uint32
-		if n, err := fmt.Sscanf(fi.Name(), blkScan, &index); err == nil && n == 1 {
-			finfo, err := fi.Info()
-			if err != nil {
-				return err
-			}
-			if mb, err := fs.recoverMsgBlock(finfo, index); err == nil && mb != nil {
-				if fs.state.FirstSeq == 0 || mb.first.seq < fs.state.FirstSeq {
-					fs.state.FirstSeq = mb.first.seq
-					fs.state.FirstTime = time.Unix(0, mb.first.ts).UTC()
+		n, err := fmt.Sscanf(fi.Name(), blkScan, &index)
+		if err != nil || n != 1 {
+			// Blocks in cold storage only have their marker. If the block file is
+			// also present we were interrupted moving it and will recover from that.
+			if n, err = fmt.Sscanf(fi.Name(), coldScan, &index); err == nil && n == 1 {
+				if _, err := os.Stat(filepath.Join(mdir, fmt.Sprintf(blkScan, index))); err == nil {
+					n = 0
 				}
-				if mb.last.seq > fs.state.LastSeq {
-					fs.state.LastSeq = mb.last.seq
-					fs.state.LastTime = time.Unix(0, mb.last.ts).UTC()
-				}
-				fs.state.Msgs += mb.msgs
-				fs.state.Bytes += mb.bytes
-			} else {
-				return err
 			}
 		}
+		if err == nil && n == 1 {
+			indexes = append(indexes, index)
+		}
+	}
+
+	rp := newRecoveryProgress(fs.fcfg.RecoveryHandler, len(indexes))
+	defer rp.done()
+
+	// Recovering the msg blocks is the bulk of the work on restart, so do that concurrently.
+	// Adding them to the store is done here as they come in.
+	type blkRecovery struct {
+		mb  *msgBlock
+		ld  *LostStreamData
+		err error
+	}
+	recovered := make(chan blkRecovery, len(indexes))
+	next, noTrack := int64(-1), fs.noTrackSubjects()
+	for w, n := 0, fs.recoveryWorkers(len(indexes)); w < n; w++ {
+		go func() {
+			for i := int(atomic.AddInt64(&next, 1)); i < len(indexes); i = int(atomic.AddInt64(&next, 1)) {
+				mb, ld, err := fs.recoverMsgBlock(indexes[i], noTrack)
+				recovered <- blkRecovery{mb, ld, err}
+			}
+		}()
+	}
+
+	// These can come in a random order, so account for that.
+	var rerr error
+	for range indexes {
+		r := <-recovered
+		rp.recovered()
+		// Wait for all of them on an error so nothing is still touching the blocks.
+		if rerr != nil || r.err != nil {
+			if rerr == nil {
+				rerr = r.err
+			}
+			continue
+		}
+		mb := r.mb
+		fs.addRecoveredMsgBlock(mb, r.ld)
+		if fs.state.FirstSeq == 0 || mb.first.seq < fs.state.FirstSeq {
+			fs.state.FirstSeq = mb.first.seq
+			fs.state.FirstTime = time.Unix(0, mb.first.ts).UTC()
+		}
+		if mb.last.seq > fs.state.LastSeq {
+			fs.state.LastSeq = mb.last.seq
+			fs.state.LastTime = time.Unix(0, mb.last.ts).UTC()
+		}
+		fs.state.Msgs += mb.msgs
+		fs.state.Bytes += mb.bytes
+	}
+	if rerr != nil {
+		return rerr
 	}
 
 	// Now make sure to sort blks for efficient lookup later with selectMsgBlock().
@@ -1221,7 +1403,11 @@ func (fs *fileStore) recoverMsgs() error {
 	fs.enforceBytesLimit()
 
 	// Do age checks too, make sure to call in place.
-	if fs.cfg.MaxAge != 0 {
+	// With subject retention overrides or holds whole blocks can not be expired here,
+	// so leave it to the age check timer.
+	if len(fs.cfg.SubjectRetention) > 0 || len(fs.holds) > 0 {
+		fs.startAgeChk()
+	} else if fs.cfg.MaxAge != 0 {
 		fs.expireMsgsOnRecover()
 		fs.startAgeChk()
 	}
@@ -1230,10 +1416,77 @@ func (fs *fileStore) recoverMsgs() error {
 	if fs.cfg.MaxMsgsPer > 0 {
 		fs.enforceMsgPerSubjectLimit()
 	}
+	// Same for max bytes per subject.
+	if fs.cfg.MaxBytesPer > 0 {
+		fs.enforceAllBytesPerSubjectLimits()
+	}
 
 	return nil
 }
 
+// Tracks and reports progress while recovering message blocks.
+type recoveryProgress struct {
+	cb    func(RecoveryProgress)
+	start time.Time
+	last  time.Time
+	p     RecoveryProgress
+}
+
+func newRecoveryProgress(cb func(RecoveryProgress), blocks int) *recoveryProgress {
+	if cb == nil {
+		return nil
+	}
+	now := time.Now()
+	rp := &recoveryProgress{cb: cb, start: now, last: now, p: RecoveryProgress{Blocks: blocks}}
+	rp.report(now)
+	return rp
+}
+
+// Returns how many go routines to use for recovering the given number of msg blocks.
+func (fs *fileStore) recoveryWorkers(blocks int) int {
+	n := runtime.GOMAXPROCS(0)
+	if fs.fcfg.RecoveryWorkers > 0 && fs.fcfg.RecoveryWorkers < n {
+		n = fs.fcfg.RecoveryWorkers
+	}
+	if blocks < n {
+		n = blocks
+	}
+	return n
+}
+
+// Called when a block has been recovered, will report if enough time has passed.
+func (rp *recoveryProgress) recovered() {
+	if rp == nil {
+		return
+	}
+	rp.p.Recovered++
+	if now := time.Now(); now.Sub(rp.last) >= recoveryProgressInterval {
+		rp.report(now)
+	}
+}
+
+// Called when we are done, regardless of outcome.
+func (rp *recoveryProgress) done() {
+	if rp == nil {
+		return
+	}
+	rp.p.Done = true
+	rp.report(time.Now())
+}
+
+func (rp *recoveryProgress) report(now time.Time) {
+	rp.last = now
+	rp.p.Elapsed = now.Sub(rp.start)
+	rp.p.Percent, rp.p.ETA = 100, 0
+	if rp.p.Blocks > 0 {
+		rp.p.Percent = float64(rp.p.Recovered) / float64(rp.p.Blocks) * 100
+	}
+	if rp.p.Recovered > 0 && rp.p.Recovered < rp.p.Blocks {
+		rp.p.ETA = rp.p.Elapsed / time.Duration(rp.p.Recovered) * time.Duration(rp.p.Blocks-rp.p.Recovered)
+	}
+	rp.cb(rp.p)
+}
+
 // Will expire msgs that have aged out on restart.
 // We will treat this differently in case we have a recovery
 // that will expire alot of messages on startup.
@@ -1298,12 +1551,7 @@ func (fs *fileStore) expireMsgsOnRecover() {
 			// Process interior deleted msgs.
 			if err == errDeletedMsg {
 				// Update dmap.
-				if len(mb.dmap) > 0 {
-					delete(mb.dmap, seq)
-					if len(mb.dmap) == 0 {
-						mb.dmap = nil
-					}
-				}
+				mb.dmap.delete(seq)
 				// Keep this update just in case since we are removing dmap entries.
 				mb.first.seq = seq
 				continue
@@ -1335,8 +1583,9 @@ func (fs *fileStore) expireMsgsOnRecover() {
 			}
 			// Update fss
 			// Make sure we have fss loaded.
-			mb.removeSeqPerSubject(sm.subj, seq, nil)
-			fs.removePerSubject(sm.subj)
+			sz := fileStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+			mb.removeSeqPerSubject(sm.subj, seq, sz, nil)
+			fs.removePerSubject(sm.subj, 1, sz)
 		}
 
 		// Check if empty after processing, could happen if tail of messages are all deleted.
@@ -1389,7 +1638,6 @@ func copyMsgBlocks(src []*msgBlock) []*msgBlock {
 
 // GetSeqFromTime looks for the first sequence number that has
 // the message with >= timestamp.
-// FIXME(dlc) - inefficient, and dumb really. Make this better.
 func (fs *fileStore) GetSeqFromTime(t time.Time) uint64 {
 	fs.mu.RLock()
 	lastSeq := fs.state.LastSeq
@@ -1405,22 +1653,67 @@ func (fs *fileStore) GetSeqFromTime(t time.Time) uint64 {
 		return lastSeq + 1
 	}
 
-	mb.mu.RLock()
-	fseq := mb.first.seq
-	lseq := mb.last.seq
-	mb.mu.RUnlock()
+	return mb.seqFromTime(t.UnixNano())
+}
 
-	var smv StoreMsg
+// Returns the first sequence in the block with a timestamp >= ts.
+// If none, which can happen when the last message was removed, returns the one after the block.
+func (mb *msgBlock) seqFromTime(ts int64) uint64 {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
 
-	// Linear search, hence the dumb part..
-	ts := t.UnixNano()
-	for seq := fseq; seq <= lseq; seq++ {
-		sm, _, _ := mb.fetchMsg(seq, &smv)
-		if sm != nil && sm.ts >= ts {
+	if mb.cacheNotLoaded() {
+		if err := mb.loadMsgsWithLock(); err != nil {
+			return 0
+		}
+	}
+	if mb.tsi == nil {
+		mb.buildTimeIndex()
+	}
+
+	// Only need to scan from the last indexed message before ts.
+	start := mb.first.seq
+	if i := sort.Search(len(mb.tsi), func(i int) bool { return mb.tsi[i].ts >= ts }); i > 0 {
+		if seq := mb.tsi[i-1].seq; seq > start {
+			start = seq
+		}
+	}
+	var smv StoreMsg
+	for seq := start; seq <= mb.last.seq; seq++ {
+		if sm, _ := mb.cacheLookup(seq, &smv); sm != nil && sm.ts >= ts {
 			return sm.seq
 		}
 	}
-	return 0
+	return mb.last.seq + 1
+}
+
+// Builds the sparse timestamp index from the loaded cache.
+// Lock should be held.
+func (mb *msgBlock) buildTimeIndex() {
+	var le = binary.LittleEndian
+	idx, buf := mb.cache.idx, mb.cache.buf
+	mb.tsi = make([]msgId, 0, len(idx)/tsIndexInterval+1)
+	for i := 0; i < len(idx); i++ {
+		li := int(idx[i]&^hbit) - mb.cache.off
+		if li < 0 || li+msgHdrSize > len(buf) {
+			break
+		}
+		hdr := buf[li : li+msgHdrSize]
+		seq, ts := le.Uint64(hdr[4:]), int64(le.Uint64(hdr[12:]))
+		// Erased messages do not keep their timestamp.
+		if seq&ebit != 0 || seq == 0 {
+			continue
+		}
+		mb.indexTime(seq, ts)
+	}
+}
+
+// Adds to the sparse timestamp index if far enough from the last entry.
+// Lock should be held.
+func (mb *msgBlock) indexTime(seq uint64, ts int64) {
+	if n := len(mb.tsi); n == 0 || seq >= mb.tsi[n-1].seq+tsIndexInterval {
+		mb.tsi = append(mb.tsi, msgId{seq, ts})
+	}
 }
 
 // Find the first matching message.
@@ -1428,6 +1721,9 @@ func (mb *msgBlock) firstMatching(filter string, wc bool, start uint64, sm *Stor
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
 
+	if mb.bloomExcludes(filter) {
+		return nil, false, ErrStoreMsgNotFound
+	}
 	if err := mb.ensurePerSubjectInfoLoaded(); err != nil {
 		return nil, false, err
 	}
@@ -1528,6 +1824,9 @@ func (mb *msgBlock) filteredPendingLocked(filter string, wc bool, seq uint64) (t
 	if isAll && seq <= mb.first.seq {
 		return mb.msgs, mb.first.seq, mb.last.seq
 	}
+	if mb.bloomExcludes(filter) {
+		return 0, 0, 0
+	}
 
 	// Make sure we have fss loaded.
 	mb.ensurePerSubjectInfoLoaded()
@@ -1703,29 +2002,33 @@ func (fs *fileStore) SubjectsState(subject string) map[string]SimpleState {
 		return nil
 	}
 
-	start, stop := fs.blks[0], fs.lmb
+	start, stop := fs.blks[0].index, fs.lmb.index
 	// We can short circuit if not a wildcard using psim for start and stop.
+	// Note the blocks themselves may have been removed already, so compare by index.
 	if !subjectHasWildcard(subject) {
 		info := fs.psim[subject]
 		if info == nil {
 			return nil
 		}
-		start, stop = fs.bim[info.fblk], fs.bim[info.lblk]
+		start, stop = info.fblk, info.lblk
 	}
 
 	// Aggregate fss.
 	fss := make(map[string]SimpleState)
-	var startFound bool
 
 	for _, mb := range fs.blks {
-		if !startFound {
-			if mb != start {
-				continue
-			}
-			startFound = true
+		if mb.index < start {
+			continue
+		}
+		if mb.index > stop {
+			break
 		}
 
 		mb.mu.Lock()
+		if mb.bloomExcludes(subject) {
+			mb.mu.Unlock()
+			continue
+		}
 		// Make sure we have fss loaded.
 		mb.ensurePerSubjectInfoLoaded()
 		for subj, ss := range mb.fss {
@@ -1735,19 +2038,74 @@ func (fs *fileStore) SubjectsState(subject string) map[string]SimpleState {
 					fss[subj] = *ss
 				} else {
 					// Merge here.
-					oss.Last, oss.Msgs = ss.Last, oss.Msgs+ss.Msgs
+					oss.Last, oss.Msgs, oss.Bytes = ss.Last, oss.Msgs+ss.Msgs, oss.Bytes+ss.Bytes
 					fss[subj] = oss
 				}
 			}
 		}
 		mb.mu.Unlock()
+	}
 
-		if mb == stop {
-			break
+	return fss
+}
+
+// SubjectsStateRange returns SimpleState for at most limit matching subjects that sort after
+// startSubject, along with the total number of matching subjects.
+// A limit of 0 means no limit.
+// Selection is done from our per subject index so only the blocks holding
+// the first and last message of each selected subject are consulted.
+func (fs *fileStore) SubjectsStateRange(subject, startSubject string, limit int) (map[string]SimpleState, int) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if fs.state.Msgs == 0 {
+		return nil, 0
+	}
+
+	sr := newSubjectRange(startSubject, limit)
+	for subj := range fs.psim {
+		if subject == _EMPTY_ || subject == fwcs || subjectIsSubsetMatch(subj, subject) {
+			sr.add(subj)
 		}
 	}
+	subjs := sr.subjects()
+	fss := make(map[string]SimpleState, len(subjs))
+	for _, subj := range subjs {
+		info := fs.psim[subj]
+		ss := SimpleState{Msgs: info.total, Bytes: info.bytes}
+		// Our sense of fblk is lazily updated so it may be behind.
+		for i := info.fblk; i <= info.lblk && ss.First == 0; i++ {
+			mb := fs.bim[i]
+			if mb == nil {
+				continue
+			}
+			mb.mu.Lock()
+			if !mb.bloomExcludes(subj) {
+				mb.ensurePerSubjectInfoLoaded()
+				if mss := mb.fss[subj]; mss != nil {
+					ss.First = mss.First
+				}
+			}
+			mb.mu.Unlock()
+		}
+		if mb := fs.bim[info.lblk]; mb != nil {
+			mb.mu.Lock()
+			mb.ensurePerSubjectInfoLoaded()
+			if mss := mb.fss[subj]; mss != nil {
+				ss.Last = mss.Last
+			}
+			mb.mu.Unlock()
+		}
+		fss[subj] = ss
+	}
+	return fss, sr.total
+}
 
-	return fss
+// RegisterEvictionHandler registers a callback consulted before messages are removed due to limits.
+func (fs *fileStore) RegisterEvictionHandler(cb EvictionHandler) {
+	fs.mu.Lock()
+	fs.ecb = cb
+	fs.mu.Unlock()
 }
 
 // RegisterStorageUpdates registers a callback for updates to storage changes.
@@ -1817,7 +2175,7 @@ func (fs *fileStore) newMsgBlockForWrite() (*msgBlock, error) {
 		}
 	}
 
-	mb := &msgBlock{fs: fs, index: index, cexp: fs.fcfg.CacheExpire, noTrack: fs.noTrackSubjects()}
+	mb := &msgBlock{fs: fs, index: index, cexp: fs.fcfg.CacheExpire, noTrack: fs.noTrackSubjects(), bloom: fs.cfg.SubjectBloom}
 
 	// Lock should be held to quiet race detector.
 	mb.mu.Lock()
@@ -1878,6 +2236,11 @@ func (fs *fileStore) newMsgBlockForWrite() (*msgBlock, error) {
 	// Add to our list of blocks and mark as last.
 	fs.addMsgBlock(mb)
 
+	// The block we were writing to is now sealed and can be compressed.
+	if index > 1 && fs.cfg.Compression != NoCompression {
+		fs.startRecompressLocked()
+	}
+
 	return mb, nil
 }
 
@@ -1909,6 +2272,9 @@ func (fs *fileStore) storeRawMsg(subj string, hdr, msg []byte, seq uint64, ts in
 	if fs.closed {
 		return ErrStoreClosed
 	}
+	if fs.frozen {
+		return ErrStoreFrozen
+	}
 
 	// Per subject max check needed.
 	var psmc uint64
@@ -1963,11 +2329,12 @@ func (fs *fileStore) storeRawMsg(subj string, hdr, msg []byte, seq uint64, ts in
 		index := fs.lmb.index
 		if info, ok := fs.psim[subj]; ok {
 			info.total++
+			info.bytes += n
 			if index > info.lblk {
 				info.lblk = index
 			}
 		} else {
-			fs.psim[subj] = &psi{total: 1, fblk: index, lblk: index}
+			fs.psim[subj] = &psi{total: 1, bytes: n, fblk: index, lblk: index}
 		}
 	}
 
@@ -1982,6 +2349,8 @@ func (fs *fileStore) storeRawMsg(subj string, hdr, msg []byte, seq uint64, ts in
 	fs.state.Bytes += n
 	fs.state.LastSeq = seq
 	fs.state.LastTime = now
+	fs.rates.in.record(time.Now().Unix(), 1, n)
+	fs.trackScheduled(seq, ts, hdr)
 
 	// Enforce per message limits.
 	// We snapshotted psmc before our actual write, so >= comparison needed.
@@ -1990,7 +2359,13 @@ func (fs *fileStore) storeRawMsg(subj string, hdr, msg []byte, seq uint64, ts in
 		if fseq == 0 {
 			fseq, _ = fs.firstSeqForSubj(subj)
 		}
-		fs.removeMsg(fseq, false, false)
+		if !fs.isHeld(subj) && fs.allowEviction(fseq, EvictMaxMsgsPer) {
+			fs.removeMsg(fseq, false, false)
+		}
+	}
+	// Enforce per subject byte limits.
+	if fs.cfg.MaxBytesPer > 0 && len(subj) > 0 {
+		fs.enforceBytesPerSubjectLimit(subj)
 	}
 
 	// Limits checks and enforcement.
@@ -2000,7 +2375,7 @@ func (fs *fileStore) storeRawMsg(subj string, hdr, msg []byte, seq uint64, ts in
 	fs.enforceBytesLimit()
 
 	// Check if we have and need the age expiration timer running.
-	if fs.ageChk == nil && fs.cfg.MaxAge != 0 {
+	if fs.ageChk == nil && fs.cfg.expiryAge() != 0 {
 		fs.startAgeChk()
 	}
 
@@ -2038,6 +2413,33 @@ func (fs *fileStore) StoreMsg(subj string, hdr, msg []byte) (uint64, int64, erro
 	return seq, ts, err
 }
 
+// StoreMsgIfLastSubjSeq stores a message only if the last sequence for its subject is lseq,
+// with 0 meaning we should have no messages for the subject. The check and the store happen
+// under the same lock, so concurrent writers to a subject can not both succeed.
+func (fs *fileStore) StoreMsgIfLastSubjSeq(subj string, hdr, msg []byte, lseq uint64) (uint64, int64, error) {
+	fs.mu.Lock()
+	var seq uint64
+	var ts int64
+	cur, err := fs.lastSeqForSubj(subj)
+	if err == nil && cur != lseq {
+		err = ErrLastSubjSeqMismatch
+	}
+	if err == nil {
+		seq, ts = fs.state.LastSeq+1, time.Now().UnixNano()
+		err = fs.storeRawMsg(subj, hdr, msg, seq, ts)
+	}
+	cb := fs.scb
+	fs.mu.Unlock()
+
+	if err != nil {
+		seq, ts = 0, 0
+	} else if cb != nil {
+		cb(1, int64(fileStoreMsgSize(subj, hdr, msg)), seq, subj)
+	}
+
+	return seq, ts, err
+}
+
 // skipMsg will update this message block for a skipped message.
 // If we do not have any messages, just update the metadata, otherwise
 // we will place and empty record marking the sequence as used. The
@@ -2064,10 +2466,7 @@ func (mb *msgBlock) skipMsg(seq uint64, now time.Time) {
 		}
 	} else {
 		needsRecord = true
-		if mb.dmap == nil {
-			mb.dmap = make(map[uint64]struct{})
-		}
-		mb.dmap[seq] = struct{}{}
+		mb.dmap.insert(seq)
 	}
 	mb.mu.Unlock()
 
@@ -2150,16 +2549,63 @@ func (fs *fileStore) firstSeqForSubj(subj string) (uint64, error) {
 	return 0, nil
 }
 
-// Will check the msg limit and drop firstSeq msg if needed.
+// Will return the last sequence for a literal subject, or 0 if we have none.
 // Lock should be held.
-func (fs *fileStore) enforceMsgLimit() {
-	if fs.cfg.MaxMsgs <= 0 || fs.state.Msgs <= uint64(fs.cfg.MaxMsgs) {
-		return
+func (fs *fileStore) lastSeqForSubj(subj string) (uint64, error) {
+	if fs.closed {
+		return 0, ErrStoreClosed
 	}
-	for nmsgs := fs.state.Msgs; nmsgs > uint64(fs.cfg.MaxMsgs); nmsgs = fs.state.Msgs {
-		if removed, err := fs.deleteFirstMsg(); err != nil || !removed {
-			fs.rebuildFirst()
-			return
+	info, ok := fs.psim[subj]
+	if !ok {
+		return 0, nil
+	}
+	// Walk blocks backwards.
+	for i := info.lblk; i >= info.fblk; i-- {
+		mb := fs.bim[i]
+		if mb == nil {
+			continue
+		}
+		mb.mu.Lock()
+		if err := mb.ensurePerSubjectInfoLoaded(); err != nil {
+			mb.mu.Unlock()
+			return 0, err
+		}
+		_, _, l := mb.filteredPendingLocked(subj, false, mb.first.seq)
+		mb.mu.Unlock()
+		if l > 0 {
+			return l, nil
+		}
+	}
+	return 0, nil
+}
+
+// Returns true if our eviction handler, if any, allows seq to be removed due to limits.
+// Lock should be held.
+func (fs *fileStore) allowEviction(seq uint64, reason EvictionReason) bool {
+	if fs.ecb == nil {
+		return true
+	}
+	mb := fs.selectMsgBlock(seq)
+	if mb == nil {
+		return true
+	}
+	var smv StoreMsg
+	sm, _, err := mb.fetchMsg(seq, &smv)
+	if err != nil {
+		return true
+	}
+	return allowEviction(fs.ecb, reason, sm)
+}
+
+// Will check the msg limit and drop firstSeq msg if needed.
+// Lock should be held.
+func (fs *fileStore) enforceMsgLimit() {
+	if fs.cfg.MaxMsgs <= 0 || fs.state.Msgs <= uint64(fs.cfg.MaxMsgs) {
+		return
+	}
+	for nmsgs := fs.state.Msgs; nmsgs > uint64(fs.cfg.MaxMsgs); nmsgs = fs.state.Msgs {
+		if !fs.evictFirstUnheld(EvictMaxMsgs) {
+			return
 		}
 	}
 }
@@ -2171,13 +2617,56 @@ func (fs *fileStore) enforceBytesLimit() {
 		return
 	}
 	for bs := fs.state.Bytes; bs > uint64(fs.cfg.MaxBytes); bs = fs.state.Bytes {
-		if removed, err := fs.deleteFirstMsg(); err != nil || !removed {
-			fs.rebuildFirst()
+		if !fs.evictFirstUnheld(EvictMaxBytes) {
 			return
 		}
 	}
 }
 
+// Removes the first message not on hold due to limits. Returns false if there was none,
+// its eviction was vetoed or it could not be removed.
+// Lock should be held.
+func (fs *fileStore) evictFirstUnheld(reason EvictionReason) bool {
+	seq := fs.firstUnheldSeq()
+	if seq == 0 || !fs.allowEviction(seq, reason) {
+		return false
+	}
+	if seq != fs.state.FirstSeq {
+		removed, _ := fs.removeMsg(seq, false, false)
+		return removed
+	}
+	if removed, err := fs.deleteFirstMsg(); err != nil || !removed {
+		fs.rebuildFirst()
+		return false
+	}
+	return true
+}
+
+// Returns true if subj is on hold.
+// Lock should be held.
+func (fs *fileStore) isHeld(subj string) bool {
+	return len(fs.holds) > 0 && isHeldSubject(fs.holds, subj)
+}
+
+// Returns the first sequence whose message is not on hold, 0 if all are.
+// Lock should be held.
+func (fs *fileStore) firstUnheldSeq() uint64 {
+	if len(fs.holds) == 0 {
+		return fs.state.FirstSeq
+	}
+	var smv StoreMsg
+	for seq := fs.state.FirstSeq; seq <= fs.state.LastSeq; seq++ {
+		mb := fs.selectMsgBlock(seq)
+		if mb == nil {
+			continue
+		}
+		if sm, _, err := mb.fetchMsg(seq, &smv); err == nil && sm != nil && !fs.isHeld(sm.subj) {
+			return seq
+		}
+	}
+	return 0
+}
+
 // Will make sure we have limits honored for max msgs per subject on recovery or config update.
 // We will make sure to go through all msg blocks etc. but in practice this
 // will most likely only be the last one, so can take a more conservative approach.
@@ -2194,7 +2683,7 @@ func (fs *fileStore) enforceMsgPerSubjectLimit() {
 	// collect all that are not correct.
 	needAttention := make(map[string]*psi)
 	for subj, psi := range fs.psim {
-		if psi.total > maxMsgsPer {
+		if psi.total > maxMsgsPer && !fs.isHeld(subj) {
 			needAttention[subj] = psi
 		}
 	}
@@ -2226,6 +2715,9 @@ func (fs *fileStore) enforceMsgPerSubjectLimit() {
 				m, _, err := mb.firstMatching(subj, false, seq, &sm)
 				if err == nil {
 					seq = m.seq + 1
+					if !allowEviction(fs.ecb, EvictMaxMsgsPer, m) {
+						continue
+					}
 					if removed, _ := fs.removeMsg(m.seq, false, false); removed {
 						total--
 						blks[mb] = struct{}{}
@@ -2245,6 +2737,51 @@ func (fs *fileStore) enforceMsgPerSubjectLimit() {
 	}
 }
 
+// Will remove the oldest messages for the subject until it fits within max bytes per subject.
+// We always keep the last message for the subject, even if it alone exceeds the limit.
+// Lock should be held.
+func (fs *fileStore) enforceBytesPerSubjectLimit(subj string) {
+	maxBytesPer := uint64(fs.cfg.MaxBytesPer)
+	if fs.isHeld(subj) {
+		return
+	}
+	for {
+		info, ok := fs.psim[subj]
+		if !ok || info.total <= 1 || info.bytes <= maxBytesPer {
+			return
+		}
+		fseq, err := fs.firstSeqForSubj(subj)
+		if err != nil || fseq == 0 || !fs.allowEviction(fseq, EvictMaxBytesPer) {
+			return
+		}
+		if removed, _ := fs.removeMsg(fseq, false, false); !removed {
+			return
+		}
+	}
+}
+
+// Will make sure we have limits honored for max bytes per subject on recovery or config update.
+// Lock should be held.
+func (fs *fileStore) enforceAllBytesPerSubjectLimits() {
+	maxBytesPer := uint64(fs.cfg.MaxBytesPer)
+
+	// We want to suppress callbacks from remove during this process
+	// since these should have already been deleted and accounted for.
+	cb := fs.scb
+	fs.scb = nil
+	defer func() { fs.scb = cb }()
+
+	var needAttention []string
+	for subj, info := range fs.psim {
+		if info.total > 1 && info.bytes > maxBytesPer {
+			needAttention = append(needAttention, subj)
+		}
+	}
+	for _, subj := range needAttention {
+		fs.enforceBytesPerSubjectLimit(subj)
+	}
+}
+
 // Lock should be held.
 func (fs *fileStore) deleteFirstMsg() (bool, error) {
 	return fs.removeMsg(fs.state.FirstSeq, false, false)
@@ -2260,16 +2797,188 @@ func (fs *fileStore) EraseMsg(seq uint64) (bool, error) {
 	return fs.removeMsg(seq, true, true)
 }
 
-// Convenience function to remove per subject tracking at the filestore level.
+// RemoveRange will remove all messages from first to last inclusive.
+// This is done in a single pass under our lock, with each affected message block
+// locked and its index written once instead of for every message.
+// Will return the number of messages removed.
+func (fs *fileStore) RemoveRange(first, last uint64) (uint64, error) {
+	if first == 0 || last < first {
+		return 0, ErrInvalidSequenceRange
+	}
+
+	fs.mu.Lock()
+	if fs.closed {
+		fs.mu.Unlock()
+		return 0, ErrStoreClosed
+	}
+	if fs.sips > 0 {
+		fs.mu.Unlock()
+		return 0, ErrStoreSnapshotInProgress
+	}
+	if fs.frozen {
+		fs.mu.Unlock()
+		return 0, ErrStoreFrozen
+	}
+
+	var removed, bytes uint64
+	var emptied []*msgBlock
+	var err error
+	for _, mb := range fs.blks {
+		mb.mu.Lock()
+		if mb.first.seq > last {
+			mb.mu.Unlock()
+			break
+		}
+		if mb.last.seq < first || mb.msgs == 0 {
+			mb.mu.Unlock()
+			continue
+		}
+		var n, sz uint64
+		n, sz, err = mb.removeRange(first, last)
+		removed, bytes = removed+n, bytes+sz
+		if mb.msgs == 0 {
+			emptied = append(emptied, mb)
+		} else if n > 0 {
+			mb.writeIndexInfoLocked()
+		}
+		mb.mu.Unlock()
+		if err != nil {
+			break
+		}
+	}
+	// Now clean up any blocks we emptied. If last block we will hold onto the index.
+	for _, mb := range emptied {
+		mb.mu.Lock()
+		if mb == fs.lmb {
+			mb.closeAndKeepIndex()
+		} else {
+			fs.removeMsgBlock(mb)
+		}
+		mb.mu.Unlock()
+	}
+
+	fs.state.Msgs -= removed
+	fs.state.Bytes -= bytes
+	if removed > 0 {
+		fs.selectNextFirst()
+		fs.removeSchedRange(first, last)
+	}
+	cb := fs.scb
+	fs.mu.Unlock()
+
+	if cb != nil && removed > 0 {
+		cb(-int64(removed), -int64(bytes), 0, _EMPTY_)
+	}
+	return removed, err
+}
+
+// Removes all messages from first to last inclusive from this block.
+// Returns the number of messages and bytes removed.
+// Both the fs and mb locks should be held.
+func (mb *msgBlock) removeRange(first, last uint64) (uint64, uint64, error) {
+	if first < mb.first.seq {
+		first = mb.first.seq
+	}
+	if last > mb.last.seq {
+		last = mb.last.seq
+	}
+	// If we cover the whole block we can drop it using our per subject state
+	// without loading any messages.
+	if first == mb.first.seq && last == mb.last.seq && mb.ensurePerSubjectInfoLoaded() == nil {
+		for subj, ss := range mb.fss {
+			mb.fs.removePerSubject(subj, ss.Msgs, ss.Bytes)
+		}
+		removed, bytes := mb.msgs, mb.bytes
+		mb.msgs, mb.bytes = 0, 0
+		mb.fss = make(map[string]*SimpleState)
+		mb.dmap.empty()
+		mb.first.seq, mb.first.ts = mb.last.seq+1, 0
+		mb.lrts = time.Now().UnixNano()
+		return removed, bytes, nil
+	}
+
+	if mb.cacheNotLoaded() {
+		if err := mb.loadMsgsWithLock(); err != nil {
+			return 0, 0, err
+		}
+	}
+	mb.ensurePerSubjectInfoLoaded()
+
+	var removed, bytes uint64
+	var smv StoreMsg
+	var err error
+	for seq := first; seq <= last; seq++ {
+		if mb.dmap.exists(seq) {
+			continue
+		}
+		var sm *StoreMsg
+		if sm, err = mb.cacheLookup(seq, &smv); err == errDeletedMsg {
+			err = nil
+			continue
+		} else if err != nil {
+			last = seq - 1
+			break
+		}
+		msz := fileStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+		mb.msgs--
+		mb.bytes -= msz
+		mb.removeSeqPerSubject(sm.subj, seq, msz, &smv)
+		mb.fs.removePerSubject(sm.subj, 1, msz)
+		removed, bytes = removed+1, bytes+msz
+	}
+	if removed == 0 {
+		return 0, 0, err
+	}
+	// Set cache timestamp for last remove.
+	mb.lrts = time.Now().UnixNano()
+
+	// Record the interior deletes as a single range, our first is never tracked.
+	if first == mb.first.seq {
+		mb.dmap.insertRange(first+1, last)
+		mb.selectNextFirst()
+	} else {
+		mb.dmap.insertRange(first, last)
+	}
+	// Check if <25% utilization and minimum size met.
+	if mb.msgs > 0 && mb.rbytes > compactMinimum && mb != mb.fs.lmb && mb.ckey == _EMPTY_ {
+		rbytes := mb.rbytes - uint64(mb.dmap.size()*emptyRecordLen)
+		if rbytes>>2 > mb.bytes {
+			mb.compact()
+		}
+	}
+	return removed, bytes, err
+}
+
+// Removes any scheduled expirations from first to last inclusive.
 // Lock should be held.
-func (fs *fileStore) removePerSubject(subj string) {
+func (fs *fileStore) removeSchedRange(first, last uint64) {
+	for seq := range fs.sched {
+		if seq >= first && seq <= last {
+			delete(fs.sched, seq)
+			fs.schedDirty = true
+		}
+	}
+}
+
+// Convenience function to remove n messages of sz total bytes from per subject tracking at the filestore level.
+// Lock should be held.
+func (fs *fileStore) removePerSubject(subj string, n, sz uint64) {
 	if len(subj) == 0 {
 		return
 	}
 
 	// We do not update sense of fblk here but will do so when we resolve during lookup.
 	if info, ok := fs.psim[subj]; ok {
-		info.total--
+		if info.total > n {
+			info.total -= n
+		} else {
+			info.total = 0
+		}
+		if info.bytes >= sz {
+			info.bytes -= sz
+		} else {
+			info.bytes = 0
+		}
 		if info.total == 0 {
 			delete(fs.psim, subj)
 		}
@@ -2302,6 +3011,10 @@ func (fs *fileStore) removeMsg(seq uint64, secure, needFSLock bool) (bool, error
 		fsUnlock()
 		return false, ErrStoreSnapshotInProgress
 	}
+	if fs.frozen {
+		fsUnlock()
+		return false, ErrStoreFrozen
+	}
 	// If in encrypted mode negate secure rewrite here.
 	if secure && fs.prf != nil {
 		secure = false
@@ -2326,12 +3039,10 @@ func (fs *fileStore) removeMsg(seq uint64, secure, needFSLock bool) (bool, error
 	}
 
 	// Now check dmap if it is there.
-	if mb.dmap != nil {
-		if _, ok := mb.dmap[seq]; ok {
-			mb.mu.Unlock()
-			fsUnlock()
-			return false, nil
-		}
+	if mb.dmap.exists(seq) {
+		mb.mu.Unlock()
+		fsUnlock()
+		return false, nil
 	}
 
 	// We used to not have to load in the messages except with callbacks or the filtered subject state (which is now always on).
@@ -2361,6 +3072,10 @@ func (fs *fileStore) removeMsg(seq uint64, secure, needFSLock bool) (bool, error
 	// Global stats
 	fs.state.Msgs--
 	fs.state.Bytes -= msz
+	if _, ok := fs.sched[seq]; ok {
+		delete(fs.sched, seq)
+		fs.schedDirty = true
+	}
 
 	// Now local mb updates.
 	mb.msgs--
@@ -2370,8 +3085,8 @@ func (fs *fileStore) removeMsg(seq uint64, secure, needFSLock bool) (bool, error
 	mb.ensurePerSubjectInfoLoaded()
 
 	// If we are tracking multiple subjects here make sure we update that accounting.
-	mb.removeSeqPerSubject(sm.subj, seq, &smv)
-	fs.removePerSubject(sm.subj)
+	mb.removeSeqPerSubject(sm.subj, seq, msz, &smv)
+	fs.removePerSubject(sm.subj, 1, msz)
 
 	if secure {
 		// Grab record info.
@@ -2395,14 +3110,11 @@ func (fs *fileStore) removeMsg(seq uint64, secure, needFSLock bool) (bool, error
 		}
 	} else if !isEmpty {
 		// Out of order delete.
-		if mb.dmap == nil {
-			mb.dmap = make(map[uint64]struct{})
-		}
-		mb.dmap[seq] = struct{}{}
+		mb.dmap.insert(seq)
 		// Check if <25% utilization and minimum size met.
-		if mb.rbytes > compactMinimum && !isLastBlock {
+		if mb.rbytes > compactMinimum && !isLastBlock && mb.ckey == _EMPTY_ {
 			// Remove the interior delete records
-			rbytes := mb.rbytes - uint64(len(mb.dmap)*emptyRecordLen)
+			rbytes := mb.rbytes - uint64(mb.dmap.size()*emptyRecordLen)
 			if rbytes>>2 > mb.bytes {
 				mb.compact()
 			}
@@ -2513,9 +3225,7 @@ func (mb *msgBlock) compact() {
 			return true
 		}
 		var deleted bool
-		if mb.dmap != nil {
-			_, deleted = mb.dmap[seq]
-		}
+		deleted = mb.dmap.exists(seq)
 		return deleted
 	}
 
@@ -2563,45 +3273,74 @@ func (mb *msgBlock) compact() {
 		index += rl
 	}
 
+	if err := mb.replaceBlockFile(nbuf); err != nil {
+		return
+	}
+
+	// Close cache and index file and wipe delete map, then rebuild.
+	mb.clearCacheAndOffset()
+	mb.removeIndexFileLocked()
+	mb.deleteDmap()
+	mb.rebuildStateLocked()
+
+	// If we entered with the msgs loaded make sure to reload them.
+	if wasLoaded {
+		mb.loadMsgsWithLock()
+	}
+}
+
+// Will replace our block file with the plaintext records in nbuf, encrypting
+// them if needed. FDs will be closed.
+// Lock should be held.
+func (mb *msgBlock) replaceBlockFile(nbuf []byte) error {
 	// Check for encryption.
 	if mb.bek != nil && len(nbuf) > 0 {
 		// Recreate to reset counter.
 		rbek, err := genBlockEncryptionKey(mb.fs.fcfg.Cipher, mb.seed, mb.nonce)
 		if err != nil {
-			return
+			return err
 		}
 		rbek.XORKeyStream(nbuf, nbuf)
 	}
+	return mb.writeBlockFile(nbuf, NoCompression)
+}
 
+// Will replace our block file with buf, which holds the block data compressed
+// with alg and encrypted as it should be stored. FDs will be closed.
+// Lock should be held.
+func (mb *msgBlock) writeBlockFile(buf []byte, alg StoreCompression) error {
 	// Close FDs first.
 	mb.closeFDsLocked()
 
 	// We will write to a new file and mv/rename it in case of failure.
 	mfn := filepath.Join(filepath.Join(mb.fs.fcfg.StoreDir, msgDir), fmt.Sprintf(newScan, mb.index))
-	if err := os.WriteFile(mfn, nbuf, defaultFilePerms); err != nil {
+	if err := os.WriteFile(mfn, buf, defaultFilePerms); err != nil {
 		os.Remove(mfn)
-		return
+		return err
+	}
+	// The old block still holds the data we are replacing, so keep it to be scrubbed.
+	var sfn string
+	if mb.fs.scrubbing() {
+		sfn = mb.fs.scrubName(mb.mfn)
+		if err := os.Rename(mb.mfn, sfn); err != nil {
+			os.Remove(mfn)
+			return err
+		}
 	}
 	if err := os.Rename(mfn, mb.mfn); err != nil {
+		if sfn != _EMPTY_ {
+			os.Rename(sfn, mb.mfn)
+		}
 		os.Remove(mfn)
-		return
-	}
-
-	// Close cache and index file and wipe delete map, then rebuild.
-	mb.clearCacheAndOffset()
-	mb.removeIndexFileLocked()
-	mb.deleteDmap()
-	mb.rebuildStateLocked()
-
-	// If we entered with the msgs loaded make sure to reload them.
-	if wasLoaded {
-		mb.loadMsgsWithLock()
+		return err
 	}
+	mb.cmp = alg
+	return nil
 }
 
 // Nil out our dmap.
 func (mb *msgBlock) deleteDmap() {
-	mb.dmap = nil
+	mb.dmap.empty()
 }
 
 // Grab info from a slot.
@@ -2634,6 +3373,61 @@ func (fs *fileStore) isClosed() bool {
 	return closed
 }
 
+// SetFrozen will have the store reject new messages and any removals, including
+// limits and age based ones, while still serving reads. Limits are applied again once thawed.
+func (fs *fileStore) SetFrozen(frozen bool) {
+	fs.mu.Lock()
+	thawed := fs.frozen && !frozen
+	fs.frozen = frozen
+	if thawed {
+		fs.enforceMsgLimit()
+		fs.enforceBytesLimit()
+		if fs.cfg.MaxMsgsPer > 0 {
+			fs.enforceMsgPerSubjectLimit()
+		}
+		if fs.cfg.MaxBytesPer > 0 {
+			fs.enforceAllBytesPerSubjectLimits()
+		}
+	}
+	maxAge := fs.cfg.expiryAge()
+	fs.mu.Unlock()
+
+	if thawed && maxAge != 0 {
+		fs.expireMsgs()
+	}
+}
+
+// IsFrozen returns if the store is frozen.
+func (fs *fileStore) IsFrozen() bool {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.frozen
+}
+
+// SetHolds places the subjects matching any of subjects on hold, replacing the prior holds.
+// Messages on hold are skipped by limits and max age. Limits are applied again right away,
+// so messages of released subjects are removed if the stream is over its limits.
+func (fs *fileStore) SetHolds(subjects []string) {
+	fs.mu.Lock()
+	fs.holds = copyStrings(subjects)
+	if !fs.frozen {
+		fs.enforceMsgLimit()
+		fs.enforceBytesLimit()
+		if fs.cfg.MaxMsgsPer > 0 {
+			fs.enforceMsgPerSubjectLimit()
+		}
+		if fs.cfg.MaxBytesPer > 0 {
+			fs.enforceAllBytesPerSubjectLimits()
+		}
+	}
+	maxAge, frozen := fs.cfg.expiryAge(), fs.frozen
+	fs.mu.Unlock()
+
+	if !frozen && maxAge != 0 {
+		fs.expireMsgs()
+	}
+}
+
 // Will spin up our flush loop.
 func (mb *msgBlock) spinUpFlushLoop() {
 	mb.mu.Lock()
@@ -2648,7 +3442,7 @@ func (mb *msgBlock) spinUpFlushLoop() {
 	mb.qch = make(chan struct{})
 	fch, qch := mb.fch, mb.qch
 
-	go mb.flushLoop(fch, qch)
+	go mb.flushLoop(fch, qch, mb.fs.fcfg.FlushCPUs)
 }
 
 // Raw low level kicker for flush loops.
@@ -2681,10 +3475,14 @@ func (mb *msgBlock) clearInFlusher() {
 }
 
 // flushLoop watches for messages, index info, or recently closed msg block updates.
-func (mb *msgBlock) flushLoop(fch, qch chan struct{}) {
+func (mb *msgBlock) flushLoop(fch, qch chan struct{}, cpus []int) {
 	mb.setInFlusher()
 	defer mb.clearInFlusher()
 
+	// We have no logger, cpus were validated by the server.
+	release, _ := lockToCPUs(cpuPoolFilestore, cpus)
+	defer release()
+
 	// Will use to test if we have meta data updates.
 	var firstSeq, lastSeq uint64
 	var dmapLen int
@@ -2693,10 +3491,10 @@ func (mb *msgBlock) flushLoop(fch, qch chan struct{}) {
 		mb.mu.RLock()
 		defer mb.mu.RUnlock()
 		var changed bool
-		if firstSeq != mb.first.seq || lastSeq != mb.last.seq || dmapLen != len(mb.dmap) {
+		if firstSeq != mb.first.seq || lastSeq != mb.last.seq || dmapLen != mb.dmap.size() {
 			changed = true
 			firstSeq, lastSeq = mb.first.seq, mb.last.seq
-			dmapLen = len(mb.dmap)
+			dmapLen = mb.dmap.size()
 		}
 		return changed
 	}
@@ -2746,6 +3544,13 @@ func (mb *msgBlock) flushLoop(fch, qch chan struct{}) {
 
 // Lock should be held.
 func (mb *msgBlock) eraseMsg(seq uint64, ri, rl int) error {
+	// We need our data back locally and uncompressed to rewrite it.
+	if err := mb.thawLocked(); err != nil {
+		return err
+	}
+	if err := mb.decompressLocked(); err != nil {
+		return err
+	}
 	var le = binary.LittleEndian
 	var hdr [msgHdrSize]byte
 
@@ -2817,18 +3622,18 @@ func (mb *msgBlock) truncate(sm *StoreMsg) (nmsgs, nbytes uint64, err error) {
 
 	mb.mu.Lock()
 
-	checkDmap := len(mb.dmap) > 0
+	// Sequences past the new last will be reused.
+	mb.tsi = nil
+
+	checkDmap := mb.dmap.size() > 0
 	var smv StoreMsg
 
 	for seq := mb.last.seq; seq > sm.seq; seq-- {
 		if checkDmap {
-			if _, ok := mb.dmap[seq]; ok {
+			if mb.dmap.exists(seq) {
 				// Delete and skip to next.
-				delete(mb.dmap, seq)
-				if len(mb.dmap) == 0 {
-					mb.dmap = nil
-					checkDmap = false
-				}
+				mb.dmap.delete(seq)
+				checkDmap = !mb.dmap.isEmpty()
 				continue
 			}
 		}
@@ -2848,6 +3653,7 @@ func (mb *msgBlock) truncate(sm *StoreMsg) (nmsgs, nbytes uint64, err error) {
 
 	// Truncate our msgs and close file.
 	if mb.mfd != nil {
+		mb.scrubTail(mb.mfd, eof)
 		mb.mfd.Truncate(eof)
 		mb.mfd.Sync()
 		// Update our checksum.
@@ -2886,14 +3692,10 @@ func (mb *msgBlock) isEmpty() bool {
 
 // Lock should be held.
 func (mb *msgBlock) selectNextFirst() {
-	var seq uint64
-	for seq = mb.first.seq + 1; seq <= mb.last.seq; seq++ {
-		if _, ok := mb.dmap[seq]; ok {
-			// We will move past this so we can delete the entry.
-			delete(mb.dmap, seq)
-		} else {
-			break
-		}
+	// We will move past any deletes so we can drop them.
+	seq := mb.dmap.advance(mb.first.seq + 1)
+	if seq > mb.last.seq+1 {
+		seq = mb.last.seq + 1
 	}
 	// Set new first sequence.
 	mb.first.seq = seq
@@ -3086,21 +3888,22 @@ func (mb *msgBlock) expireCacheLocked() {
 }
 
 func (fs *fileStore) startAgeChk() {
-	if fs.ageChk == nil && fs.cfg.MaxAge != 0 {
-		fs.ageChk = time.AfterFunc(fs.cfg.MaxAge, fs.expireMsgs)
+	if maxAge := fs.cfg.expiryAge(); fs.ageChk == nil && maxAge != 0 {
+		fs.ageChk = time.AfterFunc(expiryFireIn(maxAge, fs.cfg.ExpiryBatch), fs.expireMsgs)
 	}
 }
 
 // Lock should be held.
 func (fs *fileStore) resetAgeChk(delta int64) {
-	if fs.cfg.MaxAge == 0 {
+	fireIn := fs.cfg.expiryAge()
+	if fireIn == 0 {
 		return
 	}
 
-	fireIn := fs.cfg.MaxAge
 	if delta > 0 && time.Duration(delta) < fireIn {
 		fireIn = time.Duration(delta)
 	}
+	fireIn = expiryFireIn(fireIn, fs.cfg.ExpiryBatch)
 	if fs.ageChk != nil {
 		fs.ageChk.Reset(fireIn)
 	} else {
@@ -3116,28 +3919,127 @@ func (fs *fileStore) cancelAgeChk() {
 	}
 }
 
+// With subject retention overrides or holds messages expire per subject, so we walk the
+// first message of each subject instead of only the first messages of the stream.
+func (fs *fileStore) expireMsgsPerSubject() {
+	fs.mu.RLock()
+	cfg, ecb := fs.cfg.StreamConfig, fs.ecb
+	subjs := make([]string, 0, len(fs.psim))
+	for subj := range fs.psim {
+		if !fs.isHeld(subj) {
+			subjs = append(subjs, subj)
+		}
+	}
+	fs.mu.RUnlock()
+
+	var smv StoreMsg
+	var expired, expiredBytes uint64
+	var vetoed bool
+	// How long until the next message expires, 0 if none will.
+	var next int64
+
+	now := time.Now().UnixNano()
+	for _, subj := range subjs {
+		maxAge := cfg.maxAgeFor(subj)
+		if maxAge == 0 {
+			continue
+		}
+		minAge := now - int64(maxAge)
+		for !vetoed {
+			sm, _, err := fs.LoadNextMsg(subj, false, 0, &smv)
+			if err != nil || sm == nil {
+				break
+			}
+			if sm.ts > minAge {
+				if delta := sm.ts - minAge; next == 0 || delta < next {
+					next = delta
+				}
+				break
+			}
+			if !allowEviction(ecb, EvictMaxAge, sm) {
+				vetoed = true
+				break
+			}
+			msz := fileStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+			removed, _ := fs.removeMsg(sm.seq, false, true)
+			if !removed {
+				break
+			}
+			expired++
+			expiredBytes += msz
+		}
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if expired > 0 {
+		fs.rates.expired.record(time.Now().Unix(), expired, expiredBytes)
+	}
+	if fs.state.Msgs == 0 {
+		fs.cancelAgeChk()
+	} else if vetoed {
+		// Try again later.
+		fs.resetAgeChk(int64(evictionVetoRetry))
+	} else {
+		fs.resetAgeChk(next)
+	}
+}
+
 // Will expire msgs that are too old.
 func (fs *fileStore) expireMsgs() {
 	// We need to delete one by one here and can not optimize for the time being.
 	// Reason is that we need more information to adjust ack pending in consumers.
 	var smv StoreMsg
 	var sm *StoreMsg
-	fs.mu.RLock()
+	fs.mu.Lock()
+	// Nothing expires while frozen, we will check again once thawed.
+	if fs.frozen {
+		fs.cancelAgeChk()
+		fs.mu.Unlock()
+		return
+	}
+	if len(fs.cfg.SubjectRetention) > 0 || len(fs.holds) > 0 {
+		fs.mu.Unlock()
+		fs.expireMsgsPerSubject()
+		return
+	}
 	minAge := time.Now().UnixNano() - int64(fs.cfg.MaxAge)
+	fs.mu.Unlock()
+
+	fs.mu.RLock()
+	ecb := fs.ecb
 	fs.mu.RUnlock()
 
+	var expired, expiredBytes uint64
+	var vetoed bool
 	for sm, _ = fs.msgForSeq(0, &smv); sm != nil && sm.ts <= minAge; sm, _ = fs.msgForSeq(0, &smv) {
-		fs.removeMsg(sm.seq, false, true)
+		if !allowEviction(ecb, EvictMaxAge, sm) {
+			vetoed = true
+			break
+		}
+		msz := fileStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+		if removed, _ := fs.removeMsg(sm.seq, false, true); removed {
+			expired++
+			expiredBytes += msz
+		}
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if expired > 0 {
+		fs.rates.expired.record(time.Now().Unix(), expired, expiredBytes)
+	}
+
 	// Onky cancel if no message left, not on potential lookup error that would result in sm == nil.
 	if fs.state.Msgs == 0 {
 		fs.cancelAgeChk()
 	} else {
-		if sm == nil {
+		if vetoed {
+			// Try again later.
+			fs.resetAgeChk(int64(evictionVetoRetry))
+		} else if sm == nil {
 			fs.resetAgeChk(0)
 		} else {
 			fs.resetAgeChk(sm.ts - minAge)
@@ -3192,6 +4094,12 @@ func (mb *msgBlock) enableForWriting(fip bool) error {
 	if mb.mfd != nil {
 		return nil
 	}
+	if err := mb.thawLocked(); err != nil {
+		return err
+	}
+	if err := mb.decompressLocked(); err != nil {
+		return err
+	}
 	mfd, err := os.OpenFile(mb.mfn, os.O_CREATE|os.O_RDWR, defaultFilePerms)
 	if err != nil {
 		return fmt.Errorf("error opening msg block file [%q]: %v", mb.mfn, err)
@@ -3232,9 +4140,13 @@ func (mb *msgBlock) writeMsgRecord(rl, seq uint64, subj string, mhdr, msg []byte
 		}
 		if ss := mb.fss[subj]; ss != nil {
 			ss.Msgs++
+			ss.Bytes += rl
 			ss.Last = seq
 		} else {
-			mb.fss[subj] = &SimpleState{Msgs: 1, First: seq, Last: seq}
+			mb.fss[subj] = &SimpleState{Msgs: 1, Bytes: rl, First: seq, Last: seq}
+			if mb.sbf != nil {
+				mb.sbf.add(subj)
+			}
 		}
 	}
 
@@ -3303,6 +4215,9 @@ func (mb *msgBlock) writeMsgRecord(rl, seq uint64, subj string, mhdr, msg []byte
 
 	// Accounting
 	mb.updateAccounting(seq, ts, rl)
+	if mb.tsi != nil && seq&ebit == 0 {
+		mb.indexTime(seq, ts)
+	}
 
 	fch, werr := mb.fch, mb.werr
 
@@ -3438,11 +4353,39 @@ func (fs *fileStore) writeMsgRecord(seq uint64, ts int64, subj string, hdr, msg
 	}
 
 	// Ask msg block to store in write through cache.
-	err = mb.writeMsgRecord(rl, seq, subj, hdr, msg, ts, fs.fip)
+	err = mb.writeMsgRecord(rl, seq, subj, hdr, msg, ts, fs.fip || fs.fcfg.SyncAlways)
+	// Make sure this is on disk before we return if asked to.
+	if err == nil && fs.fcfg.SyncAlways {
+		err = mb.sync()
+	}
 
 	return rl, err
 }
 
+// Sync our message file to disk.
+func (mb *msgBlock) sync() error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.mfd == nil {
+		return nil
+	}
+	return mb.mfd.Sync()
+}
+
+// Applies the sync policy of the stream config over the one we were created with.
+// Returns true if the sync interval changed.
+// Lock should be held.
+func (fs *fileStore) applySyncPolicy(cfg *StreamConfig) bool {
+	si := fs.dsi
+	if cfg.SyncInterval > 0 {
+		si = cfg.SyncInterval
+	}
+	fs.fcfg.SyncAlways = fs.dsa || cfg.SyncAlways
+	changed := si != fs.fcfg.SyncInterval
+	fs.fcfg.SyncInterval = si
+	return changed
+}
+
 // Sync msg and index files as needed. This is called from a timer.
 func (fs *fileStore) syncBlocks() {
 	fs.mu.RLock()
@@ -3480,6 +4423,7 @@ func (fs *fileStore) syncBlocks() {
 	}
 
 	fs.mu.Lock()
+	fs.writeScheduleLocked()
 	fs.syncTmr = time.AfterFunc(fs.fcfg.SyncInterval, fs.syncBlocks)
 	fs.mu.Unlock()
 }
@@ -3754,7 +4698,7 @@ func (mb *msgBlock) cacheAlreadyLoaded() bool {
 	if mb.cache == nil || mb.cache.off != 0 || mb.cache.fseq == 0 || len(mb.cache.buf) == 0 {
 		return false
 	}
-	numEntries := mb.msgs + uint64(len(mb.dmap)) + (mb.first.seq - mb.cache.fseq)
+	numEntries := mb.msgs + uint64(mb.dmap.size()) + (mb.first.seq - mb.cache.fseq)
 	return numEntries == uint64(len(mb.cache.idx))
 }
 
@@ -3763,9 +4707,23 @@ func (mb *msgBlock) cacheNotLoaded() bool {
 	return !mb.cacheAlreadyLoaded()
 }
 
-// Used to load in the block contents.
+// Used to load in the block contents. Compressed blocks are returned
+// as they would be stored without compression.
 // Lock should be held and all conditionals satisfied prior.
 func (mb *msgBlock) loadBlock(buf []byte) ([]byte, error) {
+	buf, err := mb.loadBlockFile(buf)
+	if err != nil || !bytes.HasPrefix(buf, cmpMagic) {
+		return buf, err
+	}
+	return expandBlock(buf, mb.blockKeyGen())
+}
+
+// Loads the contents of our block file, or from the tier backend if moved to cold storage.
+// Lock should be held.
+func (mb *msgBlock) loadBlockFile(buf []byte) ([]byte, error) {
+	if mb.ckey != _EMPTY_ {
+		return mb.loadColdBlock(buf)
+	}
 	f, err := os.Open(mb.mfn)
 	if err != nil {
 		return nil, err
@@ -3935,10 +4893,8 @@ func (mb *msgBlock) cacheLookup(seq uint64, sm *StoreMsg) (*StoreMsg, error) {
 	}
 
 	// If we have a delete map check it.
-	if mb.dmap != nil {
-		if _, ok := mb.dmap[seq]; ok {
-			return nil, errDeletedMsg
-		}
+	if mb.dmap.exists(seq) {
+		return nil, errDeletedMsg
 	}
 	// Detect no cache loaded.
 	if mb.cache == nil || mb.cache.fseq == 0 || len(mb.cache.idx) == 0 || len(mb.cache.buf) == 0 {
@@ -4054,6 +5010,68 @@ func (fs *fileStore) msgForSeq(seq uint64, sm *StoreMsg) (*StoreMsg, error) {
 	return fsm, nil
 }
 
+// Will try to expire the caches of all our message blocks, say when our stream went idle.
+func (fs *fileStore) expireCaches() {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	for _, mb := range fs.blks {
+		mb.tryForceExpireCache()
+	}
+}
+
+// Prefetch will asynchronously load the message blocks holding the sequences
+// [start, start+n) into the block cache. This is a hint for sequential readers,
+// e.g. consumers replaying a large stream on a cold cache.
+func (fs *fileStore) Prefetch(start, n uint64) {
+	if n == 0 {
+		return
+	}
+
+	fs.mu.RLock()
+	if fs.closed || fs.state.Msgs == 0 {
+		fs.mu.RUnlock()
+		return
+	}
+	if start < fs.state.FirstSeq {
+		start = fs.state.FirstSeq
+	}
+	end := start + n - 1
+	if end < start || end > fs.state.LastSeq {
+		end = fs.state.LastSeq
+	}
+	var mbs []*msgBlock
+	for _, mb := range fs.blks {
+		mb.mu.RLock()
+		first, last := mb.first.seq, mb.last.seq
+		mb.mu.RUnlock()
+		if last < start {
+			continue
+		}
+		if first > end {
+			break
+		}
+		mbs = append(mbs, mb)
+	}
+	fs.mu.RUnlock()
+
+	if len(mbs) == 0 {
+		return
+	}
+
+	go func() {
+		for _, mb := range mbs {
+			if fs.isClosed() {
+				return
+			}
+			mb.mu.Lock()
+			if mb.cacheNotLoaded() {
+				mb.loadMsgsWithLock()
+			}
+			mb.mu.Unlock()
+		}
+	}()
+}
+
 // Internal function to return msg parts from a raw buffer.
 // Lock should be held.
 func (mb *msgBlock) msgFromBuf(buf []byte, sm *StoreMsg, hh hash.Hash64) (*StoreMsg, error) {
@@ -4296,41 +5314,176 @@ func (fs *fileStore) State() StreamState {
 	state.Deleted = nil // make sure.
 
 	if numDeleted := int((state.LastSeq - state.FirstSeq + 1) - state.Msgs); numDeleted > 0 {
-		state.Deleted = make([]uint64, 0, numDeleted)
-		cur := fs.state.FirstSeq
+		state.DeletedRanges = fs.deleteRanges(state.FirstSeq, state.LastSeq)
+	}
+	fs.mu.RUnlock()
 
-		for _, mb := range fs.blks {
-			mb.mu.Lock()
-			fseq := mb.first.seq
-			// Account for messages missing from the head.
-			if fseq > cur {
-				for seq := cur; seq < fseq; seq++ {
-					state.Deleted = append(state.Deleted, seq)
-				}
-			}
-			cur = mb.last.seq + 1 // Expected next first.
-			for seq := range mb.dmap {
-				if seq < fseq {
-					delete(mb.dmap, seq)
-				} else {
-					state.Deleted = append(state.Deleted, seq)
-				}
+	state.Lost = fs.lostData()
+
+	if len(state.DeletedRanges) > 0 {
+		state.NumDeleted = int(state.DeletedRanges.Num())
+	}
+	return state
+}
+
+// DeleteRanges will return the interior deletes between first and last inclusive.
+func (fs *fileStore) DeleteRanges(first, last uint64) DeleteRanges {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.deleteRanges(first, last)
+}
+
+// Will return the interior deletes between first and last inclusive.
+// Lock should be held.
+func (fs *fileStore) deleteRanges(first, last uint64) DeleteRanges {
+	if first < fs.state.FirstSeq {
+		first = fs.state.FirstSeq
+	}
+	if last > fs.state.LastSeq {
+		last = fs.state.LastSeq
+	}
+	if first > last || fs.state.Msgs == 0 || fs.state.Msgs >= fs.state.LastSeq-fs.state.FirstSeq+1 {
+		return nil
+	}
+
+	var drs DeleteRanges
+	cur := first
+
+	for _, mb := range fs.blks {
+		mb.mu.Lock()
+		fseq, lseq := mb.first.seq, mb.last.seq
+		if lseq < cur {
+			mb.mu.Unlock()
+			continue
+		}
+		// Account for messages missing from the head.
+		if fseq > cur {
+			end := fseq
+			if end > last+1 {
+				end = last + 1
 			}
+			drs.add(cur, end-cur)
+		}
+		if fseq > last {
 			mb.mu.Unlock()
+			break
+		}
+		// Anything below our first is stale.
+		from := cur
+		if from < fseq {
+			from = fseq
+		}
+		mb.dmap.appendRanges(&drs, from, last)
+		mb.mu.Unlock()
+
+		if cur = lseq + 1; cur > last {
+			break
 		}
 	}
-	fs.mu.RUnlock()
+	return drs
+}
 
-	state.Lost = fs.lostData()
+// StoreStats returns the rolling ingest and expiry rates for this store.
+func (fs *fileStore) StoreStats() StoreStats {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.rates.stats(time.Now().Unix())
+}
 
-	// Can not be guaranteed to be sorted.
-	if len(state.Deleted) > 0 {
-		sort.Slice(state.Deleted, func(i, j int) bool {
-			return state.Deleted[i] < state.Deleted[j]
-		})
-		state.NumDeleted = len(state.Deleted)
+// AgeSummary returns the distribution of message ages, or nil if we have no messages.
+// This only uses the message counts and timestamps we track per block, within a block
+// timestamps are interpolated.
+func (fs *fileStore) AgeSummary() *StreamAgeSummary {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	total := fs.state.Msgs
+	if total == 0 {
+		return nil
 	}
-	return state
+	// Ranks of the messages we want counted from the oldest one.
+	r90, r50 := total/10, total/2
+	var ts90, ts50 int64
+	var seen uint64
+	for _, mb := range fs.blks {
+		mb.mu.RLock()
+		msgs, fts, lts := mb.msgs, mb.first.ts, mb.last.ts
+		mb.mu.RUnlock()
+		if msgs == 0 {
+			continue
+		}
+		at := func(r uint64) int64 {
+			if msgs == 1 {
+				return fts
+			}
+			return fts + int64(float64(lts-fts)*float64(r-seen)/float64(msgs-1))
+		}
+		if ts90 == 0 && r90 < seen+msgs {
+			ts90 = at(r90)
+		}
+		if r50 < seen+msgs {
+			ts50 = at(r50)
+			break
+		}
+		seen += msgs
+	}
+	// Should not happen, but do not report the epoch if block counts are off.
+	if ts50 == 0 {
+		ts50 = fs.state.LastTime.UnixNano()
+	}
+	if ts90 == 0 {
+		ts90 = ts50
+	}
+	return &StreamAgeSummary{
+		Oldest: fs.state.FirstTime,
+		P50:    time.Unix(0, ts50).UTC(),
+		P90:    time.Unix(0, ts90).UTC(),
+	}
+}
+
+// AgeHistogram returns how many messages and bytes fall into each age bucket. Like
+// AgeSummary this only uses what we track per block, so does not load any messages.
+func (fs *fileStore) AgeHistogram(bounds []time.Duration) []*StreamAgeBucket {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	type span struct {
+		msgs     uint64
+		fts, lts int64
+	}
+	spans := make([]span, 0, len(fs.blks))
+	for _, mb := range fs.blks {
+		mb.mu.RLock()
+		if mb.msgs > 0 {
+			spans = append(spans, span{mb.msgs, mb.first.ts, mb.last.ts})
+		}
+		mb.mu.RUnlock()
+	}
+	return ageHistogram(bounds, fs.state.Msgs, fs.state.Bytes, func(ts int64) uint64 {
+		var n uint64
+		// Newest blocks first, we can stop at the first one stored entirely before ts.
+		for i := len(spans) - 1; i >= 0; i-- {
+			sp := spans[i]
+			if sp.lts <= ts {
+				break
+			}
+			n += msgsStoredAfter(ts, sp.fts, sp.lts, sp.msgs)
+		}
+		return n
+	})
+}
+
+// PrefixUsage returns the messages and bytes we hold for the subjects starting with
+// each prefix of depth tokens, from our per subject totals.
+func (fs *fileStore) PrefixUsage(depth int) map[string]*SubjectPrefixUsage {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	pu := make(map[string]*SubjectPrefixUsage)
+	for subj, info := range fs.psim {
+		addPrefixUsage(pu, subj, depth, info.total, info.bytes)
+	}
+	return pu
 }
 
 func (fs *fileStore) Utilization() (total, reported uint64, err error) {
@@ -4409,12 +5562,14 @@ func (mb *msgBlock) writeIndexInfoLocked() error {
 	n += binary.PutVarint(hdr[n:], mb.first.ts)
 	n += binary.PutUvarint(hdr[n:], mb.last.seq)
 	n += binary.PutVarint(hdr[n:], mb.last.ts)
-	n += binary.PutUvarint(hdr[n:], uint64(len(mb.dmap)))
+	// Lazy cleanup as the first sequence moves up.
+	mb.dmap.deleteBelow(mb.first.seq)
+	n += binary.PutUvarint(hdr[n:], uint64(mb.dmap.size()))
 	buf := append(hdr[:n], mb.lchk[:]...)
 
 	// Append a delete map if needed
-	if len(mb.dmap) > 0 {
-		buf = append(buf, mb.genDeleteMap()...)
+	if !mb.dmap.isEmpty() {
+		buf = append(buf, mb.dmap.encode(mb.first.seq)...)
 	}
 
 	// Open our FD if needed.
@@ -4471,8 +5626,16 @@ func (mb *msgBlock) readIndexInfo() error {
 		}
 	}
 
+	if err := mb.decodeIndexInfo(buf); err != nil {
+		os.Remove(mb.ifn)
+		return err
+	}
+	return nil
+}
+
+// Will decode our decrypted index file into our state.
+func (mb *msgBlock) decodeIndexInfo(buf []byte) error {
 	if err := checkHeader(buf); err != nil {
-		defer os.Remove(mb.ifn)
 		return fmt.Errorf("bad index file")
 	}
 
@@ -4514,13 +5677,11 @@ func (mb *msgBlock) readIndexInfo() error {
 
 	// Check if this is a short write index file.
 	if bi < 0 || bi+checksumSize > len(buf) {
-		os.Remove(mb.ifn)
 		return fmt.Errorf("short index file")
 	}
 
 	// Check for consistency if accounting. If something is off bail and we will rebuild.
 	if mb.msgs != (mb.last.seq-mb.first.seq+1)-dmapLen {
-		os.Remove(mb.ifn)
 		return fmt.Errorf("accounting inconsistent")
 	}
 
@@ -4529,38 +5690,14 @@ func (mb *msgBlock) readIndexInfo() error {
 	bi += checksumSize
 
 	// Now check for presence of a delete map
+	mb.dmap.empty()
 	if dmapLen > 0 {
-		mb.dmap = make(map[uint64]struct{}, dmapLen)
-		for i := 0; i < int(dmapLen); i++ {
-			seq := readSeq()
-			if seq == 0 {
-				break
-			}
-			mb.dmap[seq+mb.first.seq] = struct{}{}
-		}
+		mb.dmap.decode(buf[bi:], mb.first.seq, dmapLen)
 	}
 
 	return nil
 }
 
-func (mb *msgBlock) genDeleteMap() []byte {
-	if len(mb.dmap) == 0 {
-		return nil
-	}
-	buf := make([]byte, len(mb.dmap)*binary.MaxVarintLen64)
-	// We use first seq as an offset to cut down on size.
-	fseq, n := uint64(mb.first.seq), 0
-	for seq := range mb.dmap {
-		// This is for lazy cleanup as the first sequence moves up.
-		if seq < fseq {
-			delete(mb.dmap, seq)
-		} else {
-			n += binary.PutUvarint(buf[n:], seq-fseq)
-		}
-	}
-	return buf[:n]
-}
-
 func syncAndClose(mfd, ifd *os.File) {
 	if mfd != nil {
 		mfd.Sync()
@@ -4577,7 +5714,9 @@ func (fs *fileStore) cacheLoads() uint64 {
 	var tl uint64
 	fs.mu.RLock()
 	for _, mb := range fs.blks {
+		mb.mu.RLock()
 		tl += mb.cloads
+		mb.mu.RUnlock()
 	}
 	fs.mu.RUnlock()
 	return tl
@@ -4603,7 +5742,7 @@ func (fs *fileStore) dmapEntries() int {
 	var total int
 	fs.mu.RLock()
 	for _, mb := range fs.blks {
-		total += len(mb.dmap)
+		total += mb.dmap.size()
 	}
 	fs.mu.RUnlock()
 	return total
@@ -4628,12 +5767,213 @@ func compareFn(subject string) func(string, string) bool {
 	return subjectsEqual
 }
 
+// RemapSubjects will rewrite the subject of all messages up to and including last that match
+// filter, using dest as a subject mapping destination. Will return the number of remapped messages.
+func (fs *fileStore) RemapSubjects(filter, dest string, last uint64) (uint64, error) {
+	tr, err := newTransform(filter, dest)
+	if err != nil {
+		return 0, err
+	}
+
+	fs.mu.Lock()
+	if fs.closed {
+		fs.mu.Unlock()
+		return 0, ErrStoreClosed
+	}
+	if fs.frozen {
+		fs.mu.Unlock()
+		return 0, ErrStoreFrozen
+	}
+	if last > fs.state.LastSeq {
+		last = fs.state.LastSeq
+	}
+
+	// Find the blocks holding matching subjects.
+	fblk, lblk := uint32(math.MaxUint32), uint32(0)
+	for subj, info := range fs.psim {
+		if subjectIsSubsetMatch(subj, filter) {
+			if info.fblk < fblk {
+				fblk = info.fblk
+			}
+			if info.lblk > lblk {
+				lblk = info.lblk
+			}
+		}
+	}
+
+	var remapped uint64
+	var bytes int64
+	for _, mb := range fs.blks {
+		if mb.index < fblk || mb.index > lblk {
+			continue
+		}
+		mb.mu.Lock()
+		if mb.first.seq > last {
+			mb.mu.Unlock()
+			break
+		}
+		n, err := mb.remapSubjects(tr, last, func(subj, nsubj string, osz, nsz uint64) {
+			fs.removePerSubject(subj, 1, osz)
+			if info, ok := fs.psim[nsubj]; ok {
+				info.total++
+				info.bytes += nsz
+				if mb.index > info.lblk {
+					info.lblk = mb.index
+				}
+				if mb.index < info.fblk {
+					info.fblk = mb.index
+				}
+			} else {
+				fs.psim[nsubj] = &psi{total: 1, bytes: nsz, fblk: mb.index, lblk: mb.index}
+			}
+			fs.state.Bytes += nsz
+			fs.state.Bytes -= osz
+			bytes += int64(nsz) - int64(osz)
+		})
+		mb.mu.Unlock()
+		remapped += n
+		if err != nil {
+			fs.mu.Unlock()
+			return remapped, err
+		}
+	}
+	cb := fs.scb
+	fs.mu.Unlock()
+
+	if cb != nil && bytes != 0 {
+		cb(0, bytes, 0, _EMPTY_)
+	}
+	return remapped, nil
+}
+
+// Will rewrite the block with the subjects of matching messages up to and including last
+// transformed, calling cb for each one. Will return the number of remapped messages.
+// Lock should be held.
+func (mb *msgBlock) remapSubjects(tr *transform, last uint64, cb func(subj, nsubj string, osz, nsz uint64)) (uint64, error) {
+	if mb.ckey != _EMPTY_ {
+		return 0, errors.New("can not remap subjects of messages in cold storage")
+	}
+	// Make sure everything is on disk and loaded.
+	if _, err := mb.flushPendingMsgsLocked(); err != nil {
+		return 0, err
+	}
+	if !mb.cacheAlreadyLoaded() {
+		if err := mb.loadMsgsWithLock(); err != nil {
+			return 0, err
+		}
+	}
+
+	buf := mb.cache.buf
+	nbuf := make([]byte, 0, len(buf))
+
+	var le = binary.LittleEndian
+	var remapped uint64
+	var lens [4]byte
+
+	for index, lbuf := uint32(0), uint32(len(buf)); index < lbuf; {
+		if index+msgHdrSize > lbuf {
+			return 0, errBadMsg
+		}
+		hdr := buf[index : index+msgHdrSize]
+		rl, slen := le.Uint32(hdr[0:]), le.Uint16(hdr[20:])
+		hasHeaders := rl&hbit != 0
+		// Clear any headers bit that could be set.
+		rl &^= hbit
+		dlen := int(rl) - msgHdrSize
+		// Do some quick sanity checks here.
+		if dlen < 0 || int(slen) > dlen || dlen > int(rl) || rl > rlBadThresh || index+rl > lbuf {
+			return 0, errBadMsg
+		}
+		rec := buf[index : index+rl]
+		index += rl
+
+		seq := le.Uint64(hdr[4:])
+		var deleted bool
+		if seq == 0 || seq&ebit != 0 || seq < mb.first.seq || seq > last || slen == 0 {
+			deleted = true
+		} else {
+			deleted = mb.dmap.exists(seq)
+		}
+		if deleted {
+			nbuf = append(nbuf, rec...)
+			continue
+		}
+
+		data := rec[msgHdrSize:]
+		subj := string(data[:slen])
+		nsubj, err := tr.Match(subj)
+		if err != nil || nsubj == subj {
+			nbuf = append(nbuf, rec...)
+			continue
+		}
+		var mhdr, msg []byte
+		if hasHeaders {
+			hl := int(le.Uint32(data[slen:]))
+			if int(slen)+4+hl > dlen-checksumSize {
+				return 0, errBadMsg
+			}
+			mhdr = data[int(slen)+4 : int(slen)+4+hl]
+			msg = data[int(slen)+4+hl : dlen-checksumSize]
+		} else {
+			msg = data[slen : dlen-checksumSize]
+		}
+
+		// Write out the record with the new subject.
+		nrl := fileStoreMsgSize(nsubj, mhdr, msg)
+		l := uint32(nrl)
+		if hasHeaders {
+			l |= hbit
+		}
+		start := len(nbuf)
+		nbuf = le.AppendUint32(nbuf, l)
+		nbuf = append(nbuf, hdr[4:20]...)
+		nbuf = le.AppendUint16(nbuf, uint16(len(nsubj)))
+		nbuf = append(nbuf, nsubj...)
+		if hasHeaders {
+			le.PutUint32(lens[0:], uint32(len(mhdr)))
+			nbuf = append(nbuf, lens[:]...)
+			nbuf = append(nbuf, mhdr...)
+		}
+		nbuf = append(nbuf, msg...)
+
+		mb.hh.Reset()
+		mb.hh.Write(nbuf[start+4 : start+20])
+		mb.hh.Write([]byte(nsubj))
+		if hasHeaders {
+			mb.hh.Write(mhdr)
+		}
+		mb.hh.Write(msg)
+		nbuf = mb.hh.Sum(nbuf)
+
+		cb(subj, nsubj, uint64(rl), nrl)
+		remapped++
+	}
+
+	if remapped == 0 {
+		return 0, nil
+	}
+	if err := mb.replaceBlockFile(nbuf); err != nil {
+		return 0, err
+	}
+
+	// Rebuild our state, keeping our delete map, and make sure our index reflects it.
+	mb.clearCacheAndOffset()
+	mb.removePerSubjectInfoLocked()
+	if _, err := mb.rebuildStateLocked(); err != nil {
+		return remapped, err
+	}
+	return remapped, mb.writeIndexInfoLocked()
+}
+
 // PurgeEx will remove messages based on subject filters, sequence and number of messages to keep.
 // Will return the number of purged messages.
 func (fs *fileStore) PurgeEx(subject string, sequence, keep uint64) (purged uint64, err error) {
 	if sequence > 1 && keep > 0 {
 		return 0, ErrPurgeArgMismatch
 	}
+	if fs.IsFrozen() {
+		return 0, ErrStoreFrozen
+	}
 
 	if subject == _EMPTY_ || subject == fwcs {
 		if keep == 0 && (sequence == 0 || sequence == 1) {
@@ -4706,8 +6046,8 @@ func (fs *fileStore) PurgeEx(subject string, sequence, keep uint64) (purged uint
 					purged++
 				}
 				// FSS updates.
-				mb.removeSeqPerSubject(sm.subj, seq, &smv)
-				fs.removePerSubject(sm.subj)
+				mb.removeSeqPerSubject(sm.subj, seq, rl, &smv)
+				fs.removePerSubject(sm.subj, 1, rl)
 
 				// Check for first message.
 				if seq == mb.first.seq {
@@ -4722,10 +6062,7 @@ func (fs *fileStore) PurgeEx(subject string, sequence, keep uint64) (purged uint
 					}
 				} else {
 					// Out of order delete.
-					if mb.dmap == nil {
-						mb.dmap = make(map[uint64]struct{})
-					}
-					mb.dmap[seq] = struct{}{}
+					mb.dmap.insert(seq)
 				}
 
 				if maxp > 0 && purged >= maxp {
@@ -4767,6 +6104,10 @@ func (fs *fileStore) purge(fseq uint64) (uint64, error) {
 		fs.mu.Unlock()
 		return 0, ErrStoreClosed
 	}
+	if fs.frozen {
+		fs.mu.Unlock()
+		return 0, ErrStoreFrozen
+	}
 
 	purged := fs.state.Msgs
 	rbytes := int64(fs.state.Bytes)
@@ -4777,7 +6118,13 @@ func (fs *fileStore) purge(fseq uint64) (uint64, error) {
 	fs.state.Bytes = 0
 	fs.state.Msgs = 0
 
+	var ckeys []string
 	for _, mb := range fs.blks {
+		mb.mu.RLock()
+		if mb.ckey != _EMPTY_ {
+			ckeys = append(ckeys, mb.ckey)
+		}
+		mb.mu.RUnlock()
 		mb.dirtyClose()
 	}
 
@@ -4785,6 +6132,15 @@ func (fs *fileStore) purge(fseq uint64) (uint64, error) {
 	fs.lmb = nil
 	fs.bim = make(map[uint32]*msgBlock)
 
+	// Remove any blocks we moved to cold storage.
+	if tier := fs.fcfg.Tier; tier != nil && len(ckeys) > 0 {
+		go func() {
+			for _, key := range ckeys {
+				tier.Delete(key)
+			}
+		}()
+	}
+
 	// Move the msgs directory out of the way, will delete out of band.
 	// FIXME(dlc) - These can error and we need to change api above to propagate?
 	mdir := filepath.Join(fs.fcfg.StoreDir, msgDir)
@@ -4794,8 +6150,12 @@ func (fs *fileStore) purge(fseq uint64) (uint64, error) {
 	if _, err := os.Stat(pdir); err == nil {
 		os.RemoveAll(pdir)
 	}
-	os.Rename(mdir, pdir)
-	go os.RemoveAll(pdir)
+	if fs.scrubbing() {
+		fs.reclaim(mdir)
+	} else {
+		os.Rename(mdir, pdir)
+		go os.RemoveAll(pdir)
+	}
 	// Create new one.
 	os.MkdirAll(mdir, defaultDirPerms)
 
@@ -4818,6 +6178,7 @@ func (fs *fileStore) purge(fseq uint64) (uint64, error) {
 
 	// Clear any per subject tracking.
 	fs.psim = make(map[string]*psi)
+	fs.pruneScheduleLocked()
 
 	cb := fs.scb
 	fs.mu.Unlock()
@@ -4832,6 +6193,70 @@ func (fs *fileStore) purge(fseq uint64) (uint64, error) {
 // Compact will remove all messages from this store up to
 // but not including the seq parameter.
 // Will return the number of purged messages.
+// CompactAsync will compact up to, but not including, seq in the background.
+// Blocks are compacted one at a time so that progress can be tracked and the
+// compaction cancelled in between blocks.
+func (fs *fileStore) CompactAsync(seq uint64) *CompactHandle {
+	var blocks int
+	fs.mu.RLock()
+	for _, mb := range fs.blks {
+		mb.mu.RLock()
+		below := mb.first.seq < seq
+		mb.mu.RUnlock()
+		if !below {
+			break
+		}
+		blocks++
+	}
+	fs.mu.RUnlock()
+
+	h := newCompactHandle(seq, blocks)
+	go func() {
+		for {
+			select {
+			case <-h.quit:
+				h.finish(nil)
+				return
+			default:
+			}
+
+			// Compact up to the start of the next block, or to seq if that is in the first block.
+			step := seq
+			fs.mu.RLock()
+			if len(fs.blks) > 1 {
+				mb := fs.blks[1]
+				mb.mu.RLock()
+				if mb.first.seq < seq {
+					step = mb.first.seq
+				}
+				mb.mu.RUnlock()
+			}
+			bytes := fs.state.Bytes
+			fs.mu.RUnlock()
+
+			purged, err := fs.Compact(step)
+			if err != nil {
+				h.finish(err)
+				return
+			}
+			fs.mu.RLock()
+			if fs.state.Bytes < bytes {
+				bytes -= fs.state.Bytes
+			} else {
+				bytes = 0
+			}
+			fs.mu.RUnlock()
+			h.update(purged, bytes)
+
+			if step == seq {
+				h.finish(nil)
+				return
+			}
+		}
+	}()
+	return h
+}
+
 func (fs *fileStore) Compact(seq uint64) (uint64, error) {
 	if seq == 0 || seq > fs.lastSeq() {
 		return fs.purge(seq)
@@ -4841,6 +6266,10 @@ func (fs *fileStore) Compact(seq uint64) (uint64, error) {
 
 	// We have to delete interior messages.
 	fs.mu.Lock()
+	if fs.frozen {
+		fs.mu.Unlock()
+		return 0, ErrStoreFrozen
+	}
 	smb := fs.selectMsgBlock(seq)
 	if smb == nil {
 		fs.mu.Unlock()
@@ -4862,8 +6291,8 @@ func (fs *fileStore) Compact(seq uint64) (uint64, error) {
 		bytes += mb.bytes
 		// Make sure we do subject cleanup as well.
 		mb.ensurePerSubjectInfoLoaded()
-		for subj := range mb.fss {
-			fs.removePerSubject(subj)
+		for subj, ss := range mb.fss {
+			fs.removePerSubject(subj, ss.Msgs, ss.Bytes)
 		}
 		// Now close.
 		mb.dirtyCloseWithRemove(true)
@@ -4886,12 +6315,7 @@ func (fs *fileStore) Compact(seq uint64) (uint64, error) {
 		sm, err := smb.cacheLookup(mseq, &smv)
 		if err == errDeletedMsg {
 			// Update dmap.
-			if len(smb.dmap) > 0 {
-				delete(smb.dmap, seq)
-				if len(smb.dmap) == 0 {
-					smb.dmap = nil
-				}
-			}
+			smb.dmap.delete(seq)
 		} else if sm != nil {
 			sz := fileStoreMsgSize(sm.subj, sm.hdr, sm.msg)
 			if smb.msgs > 0 {
@@ -4901,8 +6325,8 @@ func (fs *fileStore) Compact(seq uint64) (uint64, error) {
 				purged++
 			}
 			// Update fss
-			smb.removeSeqPerSubject(sm.subj, mseq, &smv)
-			fs.removePerSubject(sm.subj)
+			smb.removeSeqPerSubject(sm.subj, mseq, sz, &smv)
+			fs.removePerSubject(sm.subj, 1, sz)
 		}
 	}
 
@@ -4923,7 +6347,7 @@ func (fs *fileStore) Compact(seq uint64) (uint64, error) {
 
 		// Check if we should reclaim the head space from this block.
 		// This will be optimistic only, so don't continue if we encounter any errors here.
-		if smb.bytes*2 < smb.rbytes {
+		if smb.bytes*2 < smb.rbytes && smb.ckey == _EMPTY_ {
 			var moff uint32
 			moff, _, _, err = smb.slotInfo(int(smb.first.seq - smb.cache.fseq))
 			if err != nil || moff >= uint32(len(smb.cache.buf)) {
@@ -4953,6 +6377,7 @@ func (fs *fileStore) Compact(seq uint64) (uint64, error) {
 			smb.removePerSubjectInfoLocked()
 			smb.clearCacheAndOffset()
 			smb.rbytes = uint64(len(nbuf))
+			smb.cmp = NoCompression
 		}
 	}
 
@@ -4983,6 +6408,7 @@ SKIP:
 	// Update top level accounting.
 	fs.state.Msgs -= purged
 	fs.state.Bytes -= bytes
+	fs.pruneScheduleLocked()
 
 	cb := fs.scb
 	fs.mu.Unlock()
@@ -5006,6 +6432,10 @@ func (fs *fileStore) reset() error {
 		fs.mu.Unlock()
 		return ErrStoreSnapshotInProgress
 	}
+	if fs.frozen {
+		fs.mu.Unlock()
+		return ErrStoreFrozen
+	}
 
 	var purged, bytes uint64
 	cb := fs.scb
@@ -5036,6 +6466,10 @@ func (fs *fileStore) reset() error {
 	fs.psim = make(map[string]*psi)
 	fs.bim = make(map[uint32]*msgBlock)
 
+	// Reset our schedule, write it out now since we went backwards.
+	fs.sched, fs.schedDirty = nil, true
+	fs.writeScheduleLocked()
+
 	fs.mu.Unlock()
 
 	if cb != nil {
@@ -5062,6 +6496,10 @@ func (fs *fileStore) Truncate(seq uint64) error {
 		fs.mu.Unlock()
 		return ErrStoreSnapshotInProgress
 	}
+	if fs.frozen {
+		fs.mu.Unlock()
+		return ErrStoreFrozen
+	}
 
 	nlmb := fs.selectMsgBlock(seq)
 	if nlmb == nil {
@@ -5112,6 +6550,11 @@ func (fs *fileStore) Truncate(seq uint64) error {
 	// Reset our subject lookup info.
 	fs.resetGlobalPerSubjectInfo()
 
+	// Write out our schedule now since we went backwards.
+	fs.pruneScheduleLocked()
+	fs.schedDirty = true
+	fs.writeScheduleLocked()
+
 	cb := fs.scb
 	fs.mu.Unlock()
 
@@ -5155,6 +6598,7 @@ func (mb *msgBlock) removeIndexFileLocked() {
 
 func (mb *msgBlock) removePerSubjectInfoLocked() {
 	if mb.sfn != _EMPTY_ {
+		mb.removeSubjectBloom()
 		os.Remove(mb.sfn)
 	}
 }
@@ -5196,7 +6640,15 @@ func (fs *fileStore) removeMsgBlock(mb *msgBlock) {
 // Lock should be held.
 func (mb *msgBlock) closeAndKeepIndex() {
 	// We will leave a 0 length blk marker.
-	if mb.mfd != nil {
+	if mb.fs.scrubbing() {
+		// Keep our data to be scrubbed and leave an empty file in its place.
+		if mb.mfd != nil {
+			mb.mfd.Close()
+			mb.mfd = nil
+		}
+		mb.fs.reclaim(mb.mfn)
+		os.WriteFile(mb.mfn, nil, defaultFilePerms)
+	} else if mb.mfd != nil {
 		mb.mfd.Truncate(0)
 	} else {
 		// We were closed, so just write out an empty file.
@@ -5260,15 +6712,17 @@ func (mb *msgBlock) dirtyCloseWithRemove(remove bool) {
 		mb.ifd = nil
 	}
 	if remove {
+		mb.removeColdLocked()
 		if mb.ifn != _EMPTY_ {
 			os.Remove(mb.ifn)
 			mb.ifn = _EMPTY_
 		}
 		if mb.mfn != _EMPTY_ {
-			os.Remove(mb.mfn)
+			mb.fs.reclaim(mb.mfn)
 			mb.mfn = _EMPTY_
 		}
 		if mb.sfn != _EMPTY_ {
+			mb.removeSubjectBloom()
 			os.Remove(mb.sfn)
 			mb.sfn = _EMPTY_
 		}
@@ -5278,9 +6732,9 @@ func (mb *msgBlock) dirtyCloseWithRemove(remove bool) {
 	}
 }
 
-// Remove a seq from the fss and select new first.
+// Remove a seq of size sz from the fss and select new first.
 // Lock should be held.
-func (mb *msgBlock) removeSeqPerSubject(subj string, seq uint64, smp *StoreMsg) {
+func (mb *msgBlock) removeSeqPerSubject(subj string, seq, sz uint64, smp *StoreMsg) {
 	mb.ensurePerSubjectInfoLoaded()
 	ss := mb.fss[subj]
 	if ss == nil {
@@ -5292,6 +6746,11 @@ func (mb *msgBlock) removeSeqPerSubject(subj string, seq uint64, smp *StoreMsg)
 	}
 
 	ss.Msgs--
+	if ss.Bytes >= sz {
+		ss.Bytes -= sz
+	} else {
+		ss.Bytes = 0
+	}
 	if seq != ss.First {
 		return
 	}
@@ -5370,11 +6829,13 @@ func (mb *msgBlock) generatePerSubjectInfo(hasLock bool) error {
 			return err
 		}
 		if sm != nil && len(sm.subj) > 0 {
+			sz := fileStoreMsgSize(sm.subj, sm.hdr, sm.msg)
 			if ss := mb.fss[sm.subj]; ss != nil {
 				ss.Msgs++
+				ss.Bytes += sz
 				ss.Last = seq
 			} else {
-				mb.fss[sm.subj] = &SimpleState{Msgs: 1, First: seq, Last: seq}
+				mb.fss[sm.subj] = &SimpleState{Msgs: 1, Bytes: sz, First: seq, Last: seq}
 			}
 		}
 	}
@@ -5398,9 +6859,13 @@ func (mb *msgBlock) loadPerSubjectInfo() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(buf) < minFileSize || checkHeader(buf) != nil {
+	if len(buf) < minFileSize || buf[0] != magic {
 		return nil, errors.New("short fss state")
 	}
+	// Older versions did not track bytes, so we regenerate.
+	if buf[1] != fssVersion {
+		return nil, errors.New("outdated fss version")
+	}
 
 	// Check that we did not have any bit flips.
 	mb.hh.Reset()
@@ -5432,39 +6897,56 @@ func (mb *msgBlock) ensurePerSubjectInfoLoaded() error {
 	return mb.readPerSubjectInfo(true)
 }
 
-// Called on recovery to populate the global psim state.
-// Lock should be held.
-func (fs *fileStore) populateGlobalPerSubjectInfo(mb *msgBlock) {
-	mb.mu.Lock()
-	defer mb.mu.Unlock()
-
-	if err := mb.readPerSubjectInfo(true); err != nil {
+// Called on recovery to load the per subject info for a block we will track in psim.
+// Only touches the block itself so can be called without the fs lock.
+func (fs *fileStore) loadPerSubjectInfoForRecovery(mb *msgBlock) {
+	if mb.msgs == 0 || mb.noTrack || fs.psim == nil {
 		return
 	}
 
-	// Quick sanity check.
-	// TODO(dlc) - This is here to auto-clear a bug.
-	fssMsgs := uint64(0)
-	for subj, ss := range mb.fss {
-		if len(subj) > 0 {
-			fssMsgs += ss.Msgs
+	mb.mu.Lock()
+	if err := mb.readPerSubjectInfo(true); err == nil {
+		// Quick sanity check.
+		// TODO(dlc) - This is here to auto-clear a bug.
+		fssMsgs := uint64(0)
+		for subj, ss := range mb.fss {
+			if len(subj) > 0 {
+				fssMsgs += ss.Msgs
+			}
 		}
+		// If we are off rebuild.
+		if fssMsgs != mb.msgs {
+			mb.generatePerSubjectInfo(true)
+		}
+		if mb.bloom && mb.fss != nil && mb.readSubjectBloom() != nil {
+			mb.sbf = newSubjectBloomFromFss(mb.fss)
+		}
+	} else {
+		mb.fss = nil
 	}
-	// If we are off rebuild.
-	if fssMsgs != mb.msgs {
-		mb.generatePerSubjectInfo(true)
-	}
+	mb.mu.Unlock()
+}
+
+// Called on recovery to populate the global psim state.
+// Lock should be held.
+func (fs *fileStore) populateGlobalPerSubjectInfo(mb *msgBlock) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
 
 	// Now populate psim.
 	for subj, ss := range mb.fss {
 		if len(subj) > 0 {
 			if info, ok := fs.psim[subj]; ok {
 				info.total += ss.Msgs
+				info.bytes += ss.Bytes
 				if mb.index > info.lblk {
 					info.lblk = mb.index
 				}
+				if mb.index < info.fblk {
+					info.fblk = mb.index
+				}
 			} else {
-				fs.psim[subj] = &psi{total: ss.Msgs, fblk: mb.index, lblk: mb.index}
+				fs.psim[subj] = &psi{total: ss.Msgs, bytes: ss.Bytes, fblk: mb.index, lblk: mb.index}
 			}
 		}
 	}
@@ -5482,6 +6964,34 @@ func (mb *msgBlock) readPerSubjectInfo(hasLock bool) error {
 		return mb.generatePerSubjectInfo(hasLock)
 	}
 
+	if !hasLock {
+		mb.mu.Lock()
+	}
+	fss, err := mb.decodePerSubjectInfo(buf)
+	if err != nil {
+		if !hasLock {
+			mb.mu.Unlock()
+		}
+		return mb.generatePerSubjectInfo(hasLock)
+	}
+	mb.fss = fss
+
+	// Make sure we run the cache expire timer.
+	if len(mb.fss) > 0 {
+		mb.llts = time.Now().UnixNano()
+		mb.startCacheExpireTimer()
+	}
+
+	if !hasLock {
+		mb.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Will decode the per subject info loaded by loadPerSubjectInfo.
+// Lock should be held.
+func (mb *msgBlock) decodePerSubjectInfo(buf []byte) (map[string]*SimpleState, error) {
 	bi := hdrLen
 	readU64 := func() uint64 {
 		if bi < 0 {
@@ -5498,31 +7008,21 @@ func (mb *msgBlock) readPerSubjectInfo(hasLock bool) error {
 
 	numEntries := readU64()
 	fss := make(map[string]*SimpleState, numEntries)
-
-	if !hasLock {
-		mb.mu.Lock()
-	}
 	for i := uint64(0); i < numEntries; i++ {
 		lsubj := readU64()
+		if bi < 0 || bi+int(lsubj) > len(buf) {
+			return nil, errors.New("corrupt fss state")
+		}
 		// Make a copy or use a configured subject (to avoid mem allocation)
 		subj := mb.subjString(buf[bi : bi+int(lsubj)])
 		bi += int(lsubj)
-		msgs, first, last := readU64(), readU64(), readU64()
-		fss[subj] = &SimpleState{Msgs: msgs, First: first, Last: last}
-	}
-	mb.fss = fss
-
-	// Make sure we run the cache expire timer.
-	if len(mb.fss) > 0 {
-		mb.llts = time.Now().UnixNano()
-		mb.startCacheExpireTimer()
+		msgs, bytes, first, last := readU64(), readU64(), readU64(), readU64()
+		fss[subj] = &SimpleState{Msgs: msgs, Bytes: bytes, First: first, Last: last}
 	}
-
-	if !hasLock {
-		mb.mu.Unlock()
+	if bi < 0 {
+		return nil, errors.New("corrupt fss state")
 	}
-
-	return nil
+	return fss, nil
 }
 
 // writePerSubjectInfo will write out per subject information if we are tracking per subject.
@@ -5532,18 +7032,24 @@ func (mb *msgBlock) writePerSubjectInfo() error {
 	if len(mb.fss) == 0 || len(mb.sfn) == 0 {
 		return nil
 	}
+	// Rebuild our bloom filter while we have all subjects, this also drops any that were removed.
+	if mb.bloom {
+		mb.sbf = newSubjectBloomFromFss(mb.fss)
+		mb.writeSubjectBloom()
+	}
 	var scratch [4 * binary.MaxVarintLen64]byte
 	var b bytes.Buffer
 	b.WriteByte(magic)
-	b.WriteByte(version)
+	b.WriteByte(fssVersion)
 	n := binary.PutUvarint(scratch[0:], uint64(len(mb.fss)))
 	b.Write(scratch[0:n])
 	for subj, ss := range mb.fss {
 		n := binary.PutUvarint(scratch[0:], uint64(len(subj)))
 		b.Write(scratch[0:n])
 		b.WriteString(subj)
-		// Encode all three parts of our simple state into same scratch buffer.
+		// Encode all four parts of our simple state into same scratch buffer.
 		n = binary.PutUvarint(scratch[0:], ss.Msgs)
+		n += binary.PutUvarint(scratch[n:], ss.Bytes)
 		n += binary.PutUvarint(scratch[n:], ss.First)
 		n += binary.PutUvarint(scratch[n:], ss.Last)
 		b.Write(scratch[0:n])
@@ -5621,6 +7127,10 @@ func (fs *fileStore) Delete() error {
 		os.RemoveAll(fs.fcfg.StoreDir)
 		return ErrStoreClosed
 	}
+	// Deleting is always allowed, so thaw to release our usage.
+	fs.mu.Lock()
+	fs.frozen = false
+	fs.mu.Unlock()
 	fs.Purge()
 
 	pdir := filepath.Join(fs.fcfg.StoreDir, purgeDir)
@@ -5630,10 +7140,15 @@ func (fs *fileStore) Delete() error {
 		os.RemoveAll(pdir)
 	}
 
+	scrub := fs.scrubbing()
 	if err := fs.Stop(); err != nil {
 		return err
 	}
 
+	// Nothing is left to scrub later, so do it all now.
+	if scrub {
+		scrubPath(fs.fcfg.StoreDir)
+	}
 	err := os.RemoveAll(fs.fcfg.StoreDir)
 	if err == nil {
 		return nil
@@ -5667,9 +7182,13 @@ func (fs *fileStore) Stop() error {
 
 	fs.checkAndFlushAllBlocks()
 	fs.closeAllMsgBlocks(false)
+	fs.writeScheduleLocked()
 
 	fs.cancelSyncTimer()
 	fs.cancelAgeChk()
+	fs.cancelTierTimer()
+	fs.cancelScrubTimer()
+	fs.cancelRecompressLocked()
 
 	var _cfs [256]ConsumerStore
 	cfs := append(_cfs[:0], fs.cfs...)
@@ -0,0 +1,196 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Used to store the subject bloom filter of a message block.
+	bloomScan = "%d.blm"
+	// Version of the bloom filter files.
+	bloomVersion = uint8(1)
+	// Bits per subject and number of hashes, for about a 1% false positive rate.
+	bloomBitsPerSubject = 10
+	bloomHashes         = 7
+)
+
+// subjectBloom is a Bloom filter of the subjects in a message block. It lets
+// lookups of a literal subject skip a block without loading its per subject info.
+// Subjects are never removed, so it may also match subjects no longer in the block.
+type subjectBloom struct {
+	bits []uint64
+}
+
+// Returns a filter sized for n subjects.
+func newSubjectBloom(n int) *subjectBloom {
+	words := (n*bloomBitsPerSubject + 63) / 64
+	if words == 0 {
+		words = 1
+	}
+	return &subjectBloom{bits: make([]uint64, words)}
+}
+
+// Returns a filter with all the subjects of the per subject info.
+func newSubjectBloomFromFss(fss map[string]*SimpleState) *subjectBloom {
+	bf := newSubjectBloom(len(fss))
+	for subj := range fss {
+		bf.add(subj)
+	}
+	return bf
+}
+
+// FNV-1a, split in two for double hashing.
+func bloomHash(subj string) (uint32, uint32) {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(subj); i++ {
+		h ^= uint64(subj[i])
+		h *= 1099511628211
+	}
+	return uint32(h), uint32(h >> 32)
+}
+
+func (bf *subjectBloom) add(subj string) {
+	h1, h2 := bloomHash(subj)
+	n := uint32(len(bf.bits) * 64)
+	for i := uint32(0); i < bloomHashes; i++ {
+		b := (h1 + i*h2) % n
+		bf.bits[b/64] |= 1 << (b % 64)
+	}
+}
+
+// Returns false if the subject was never added.
+func (bf *subjectBloom) has(subj string) bool {
+	h1, h2 := bloomHash(subj)
+	n := uint32(len(bf.bits) * 64)
+	for i := uint32(0); i < bloomHashes; i++ {
+		b := (h1 + i*h2) % n
+		if bf.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if we know the subject is not in this block without loading our
+// per subject info. Wildcards always need the per subject info.
+// Lock should be held.
+func (mb *msgBlock) bloomExcludes(subj string) bool {
+	if mb.fss != nil || mb.sbf == nil || subj == _EMPTY_ || subjectHasWildcard(subj) {
+		return false
+	}
+	return !mb.sbf.has(subj)
+}
+
+// Lock should be held.
+func (mb *msgBlock) bloomFileName() string {
+	if mb.sfn == _EMPTY_ {
+		return _EMPTY_
+	}
+	return filepath.Join(filepath.Dir(mb.sfn), fmt.Sprintf(bloomScan, mb.index))
+}
+
+// Writes out our bloom filter, tied to the last update of the block like our per subject info.
+// Lock should be held.
+func (mb *msgBlock) writeSubjectBloom() error {
+	fn := mb.bloomFileName()
+	if mb.sbf == nil || fn == _EMPTY_ {
+		return nil
+	}
+	var scratch [binary.MaxVarintLen64]byte
+	var b bytes.Buffer
+	b.WriteByte(magic)
+	b.WriteByte(bloomVersion)
+	n := binary.PutUvarint(scratch[0:], uint64(len(mb.sbf.bits)))
+	b.Write(scratch[0:n])
+	for _, w := range mb.sbf.bits {
+		binary.LittleEndian.PutUint64(scratch[0:], w)
+		b.Write(scratch[0:8])
+	}
+	mb.hh.Reset()
+	mb.hh.Write(b.Bytes())
+	b.Write(mb.hh.Sum(nil))
+	b.Write(mb.lchk[:])
+
+	<-dios
+	err := os.WriteFile(fn, b.Bytes(), defaultFilePerms)
+	dios <- struct{}{}
+
+	return err
+}
+
+// Reads our bloom filter back if it is in sync with the block.
+// Lock should be held.
+func (mb *msgBlock) readSubjectBloom() error {
+	const (
+		fileHashIndex = 16
+		mbHashIndex   = 8
+		minFileSize   = hdrLen + 1 + 8 + fileHashIndex
+	)
+	buf, err := os.ReadFile(mb.bloomFileName())
+	if err != nil {
+		return err
+	}
+	if len(buf) < minFileSize || buf[0] != magic || buf[1] != bloomVersion {
+		return errors.New("bad bloom filter state")
+	}
+	mb.hh.Reset()
+	mb.hh.Write(buf[0 : len(buf)-fileHashIndex])
+	fhash := buf[len(buf)-fileHashIndex : len(buf)-mbHashIndex]
+	if checksum := mb.hh.Sum(nil); !bytes.Equal(checksum, fhash) {
+		return errors.New("corrupt bloom filter state")
+	}
+	if !bytes.Equal(buf[len(buf)-mbHashIndex:], mb.lchk[:]) {
+		return errors.New("outdated bloom filter state")
+	}
+	words, n := binary.Uvarint(buf[hdrLen:])
+	bi := hdrLen + n
+	if n <= 0 || words == 0 || uint64(len(buf)-fileHashIndex-bi) != words*8 {
+		return errors.New("corrupt bloom filter state")
+	}
+	bf := &subjectBloom{bits: make([]uint64, words)}
+	for i := range bf.bits {
+		bf.bits[i] = binary.LittleEndian.Uint64(buf[bi:])
+		bi += 8
+	}
+	mb.sbf = bf
+	return nil
+}
+
+// Lock should be held.
+func (mb *msgBlock) removeSubjectBloom() {
+	if fn := mb.bloomFileName(); fn != _EMPTY_ {
+		os.Remove(fn)
+	}
+}
+
+// Turns our subject bloom filters on or off for all blocks.
+// Lock should be held.
+func (fs *fileStore) setSubjectBloom(enabled bool) {
+	for _, mb := range fs.blks {
+		mb.mu.Lock()
+		mb.bloom = enabled
+		if !enabled {
+			mb.sbf = nil
+			mb.removeSubjectBloom()
+		}
+		mb.mu.Unlock()
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// Compressed message blocks start with this header, followed by the compressed
+// raw block, which is encrypted if the block is.
+//
+//	magic[4] | algorithm[1] | flags[1] | raw size[8]
+//
+// Read as a record length the magic is far past rlBadThresh, so a raw block can
+// never be mistaken for a compressed one.
+const (
+	cmpHdrSize = 14
+	// The compressed data is encrypted with the block key.
+	cmpEncrypted = uint8(1)
+)
+
+var cmpMagic = []byte{'N', 'C', 'B', 'Z'}
+
+var errBadCompressedBlock = errors.New("malformed compressed message block")
+
+// How many bytes of message blocks per second we will rewrite in the background
+// when the compression of a stream changes.
+var recompressRate = int64(32 * 1024 * 1024)
+
+// RecompressProgress reports on rewriting the message blocks of a stream to its compression.
+type RecompressProgress struct {
+	Compression     StoreCompression `json:"compression"`
+	Started         time.Time        `json:"started"`
+	BlocksTotal     int              `json:"blocks_total"`
+	BlocksProcessed int              `json:"blocks_processed"`
+	// Size on disk of the processed blocks before and after they were rewritten.
+	BytesBefore uint64 `json:"bytes_before"`
+	BytesAfter  uint64 `json:"bytes_after"`
+	Done        bool   `json:"done"`
+	Error       string `json:"error,omitempty"`
+}
+
+func (alg StoreCompression) compress(buf []byte) ([]byte, error) {
+	switch alg {
+	case S2Compression:
+		return s2.Encode(nil, buf), nil
+	default:
+		return nil, fmt.Errorf("can not compress with %v", alg)
+	}
+}
+
+func (alg StoreCompression) decompress(buf []byte, rsz uint64) ([]byte, error) {
+	switch alg {
+	case S2Compression:
+		if n, err := s2.DecodedLen(buf); err != nil {
+			return nil, err
+		} else if uint64(n) != rsz {
+			return nil, errBadCompressedBlock
+		}
+		return s2.Decode(make([]byte, rsz), buf)
+	default:
+		return nil, fmt.Errorf("can not decompress %v", alg)
+	}
+}
+
+// Returns the compression, raw size and flags from the header of a compressed block.
+func parseCompressedHeader(buf []byte) (alg StoreCompression, rsz uint64, flags uint8, ok bool) {
+	if len(buf) < cmpHdrSize || !bytes.Equal(buf[:len(cmpMagic)], cmpMagic) {
+		return NoCompression, 0, 0, false
+	}
+	alg, flags = StoreCompression(buf[4]), buf[5]
+	if alg == NoCompression || alg > S2Compression {
+		return NoCompression, 0, 0, false
+	}
+	return alg, binary.LittleEndian.Uint64(buf[6:]), flags, true
+}
+
+// Reads the header of our block file, if compressed returns the compression and the raw size.
+func readCompressedHeader(f *os.File) (StoreCompression, uint64, bool) {
+	var hdr [cmpHdrSize]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		return NoCompression, 0, false
+	}
+	alg, rsz, _, ok := parseCompressedHeader(hdr[:])
+	return alg, rsz, ok
+}
+
+// Returns a function generating a fresh block encryption key for this block.
+func (mb *msgBlock) blockKeyGen() func() (cipher.Stream, error) {
+	return func() (cipher.Stream, error) {
+		if mb.seed == nil {
+			return nil, errors.New("compressed message block is encrypted but we have no key")
+		}
+		return genBlockEncryptionKey(mb.fs.fcfg.Cipher, mb.seed, mb.nonce)
+	}
+}
+
+// Returns the raw data of a compressed block as it would be stored without compression,
+// so encrypted if the compressed data was.
+func expandBlock(buf []byte, genKey func() (cipher.Stream, error)) ([]byte, error) {
+	alg, rsz, flags, ok := parseCompressedHeader(buf)
+	if !ok {
+		return nil, errBadCompressedBlock
+	}
+	body := buf[cmpHdrSize:]
+	encrypted := flags&cmpEncrypted != 0
+	if encrypted {
+		bek, err := genKey()
+		if err != nil {
+			return nil, err
+		}
+		bek.XORKeyStream(body, body)
+	}
+	raw, err := alg.decompress(body, rsz)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		bek, err := genKey()
+		if err != nil {
+			return nil, err
+		}
+		bek.XORKeyStream(raw, raw)
+	}
+	return raw, nil
+}
+
+// Rewrites our block file without compression so it can be written to in place.
+// Lock should be held.
+func (mb *msgBlock) decompressLocked() error {
+	if mb.cmp == NoCompression {
+		return nil
+	}
+	buf, err := mb.loadBlock(nil)
+	if err != nil {
+		return err
+	}
+	return mb.writeBlockFile(buf, NoCompression)
+}
+
+// Rewrites our block file with alg. Returns the size of the file before and after.
+// Blocks being written to, or moved to the tier backend, are skipped.
+// Lock should not be held.
+func (mb *msgBlock) recompress(alg StoreCompression) (uint64, uint64, error) {
+	if mb.pendingWriteSize() > 0 {
+		mb.flushPendingMsgs()
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.closed || mb.mfn == _EMPTY_ || mb.ckey != _EMPTY_ || mb.cmp == alg {
+		return 0, 0, nil
+	}
+	if buf, _ := mb.bytesPending(); len(buf) > 0 {
+		return 0, 0, nil
+	}
+	fi, err := os.Stat(mb.mfn)
+	if err != nil {
+		return 0, 0, err
+	}
+	buf, err := mb.loadBlock(nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer recycleMsgBlockBuf(buf)
+
+	nbuf := buf
+	if alg != NoCompression {
+		var flags uint8
+		if mb.bek != nil {
+			flags |= cmpEncrypted
+		}
+		genKey := mb.blockKeyGen()
+		if mb.bek != nil {
+			bek, err := genKey()
+			if err != nil {
+				return 0, 0, err
+			}
+			bek.XORKeyStream(buf, buf)
+		}
+		body, err := alg.compress(buf)
+		if err != nil {
+			return 0, 0, err
+		}
+		if mb.bek != nil {
+			bek, err := genKey()
+			if err != nil {
+				return 0, 0, err
+			}
+			bek.XORKeyStream(body, body)
+		}
+		nbuf = make([]byte, cmpHdrSize, cmpHdrSize+len(body))
+		copy(nbuf, cmpMagic)
+		nbuf[4], nbuf[5] = byte(alg), flags
+		binary.LittleEndian.PutUint64(nbuf[6:], uint64(len(buf)))
+		nbuf = append(nbuf, body...)
+	}
+	if err := mb.writeBlockFile(nbuf, alg); err != nil {
+		return 0, 0, err
+	}
+	return uint64(fi.Size()), uint64(len(nbuf)), nil
+}
+
+// Kicks off rewriting our sealed blocks to our compression in the background.
+// A rewrite already running will pick up any new blocks once done with its current ones.
+// Lock should be held.
+func (fs *fileStore) startRecompressLocked() {
+	if fs.closed || fs.rcmpQch != nil {
+		return
+	}
+	fs.rcmpQch = make(chan struct{})
+	go fs.recompressBlocks(fs.rcmpQch)
+}
+
+// Lock should be held.
+func (fs *fileStore) cancelRecompressLocked() {
+	if fs.rcmpQch != nil {
+		close(fs.rcmpQch)
+		fs.rcmpQch = nil
+	}
+}
+
+// Rewrites, one at a time and no faster than recompressRate, the sealed blocks
+// not yet stored with our compression. Blocks sealed while we run are picked up
+// by checking again until there are none left.
+func (fs *fileStore) recompressBlocks(qch chan struct{}) {
+	for {
+		fs.mu.Lock()
+		if fs.closed || fs.rcmpQch != qch {
+			fs.mu.Unlock()
+			return
+		}
+		alg := fs.cfg.Compression
+		var blks []*msgBlock
+		for _, mb := range fs.blks {
+			if mb == fs.lmb {
+				continue
+			}
+			mb.mu.RLock()
+			if mb.cmp != alg && mb.ckey == _EMPTY_ && mb.rbytes > 0 {
+				blks = append(blks, mb)
+			}
+			mb.mu.RUnlock()
+		}
+		if len(blks) == 0 {
+			if fs.rcmp != nil {
+				fs.rcmp.Done = true
+			}
+			fs.rcmpQch = nil
+			fs.mu.Unlock()
+			return
+		}
+		// Carry on with the progress we have unless our compression changed.
+		if p := fs.rcmp; p == nil || p.Done || p.Compression != alg {
+			fs.rcmp = &RecompressProgress{Compression: alg, Started: time.Now().UTC()}
+		}
+		fs.rcmp.BlocksTotal = fs.rcmp.BlocksProcessed + len(blks)
+		fs.mu.Unlock()
+
+		for _, mb := range blks {
+			start := time.Now()
+			before, after, err := mb.recompress(alg)
+
+			fs.mu.Lock()
+			if fs.rcmpQch != qch {
+				fs.mu.Unlock()
+				return
+			}
+			p := fs.rcmp
+			p.BlocksProcessed++
+			p.BytesBefore += before
+			p.BytesAfter += after
+			if err != nil {
+				// Stop here, we will try again the next time we are kicked.
+				p.Error, p.Done = err.Error(), true
+				fs.rcmpQch = nil
+				fs.mu.Unlock()
+				return
+			}
+			changed := fs.cfg.Compression != alg
+			fs.mu.Unlock()
+			if changed {
+				break
+			}
+
+			// Bound our rate by waiting out how long rewriting this block should have taken.
+			wait := time.Duration(float64(before)/float64(recompressRate)*float64(time.Second)) - time.Since(start)
+			if wait > 0 {
+				select {
+				case <-qch:
+					return
+				case <-time.After(wait):
+				}
+			}
+		}
+	}
+}
+
+// Recompression returns the progress of rewriting our blocks to our compression, if any.
+func (fs *fileStore) Recompression() *RecompressProgress {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if fs.rcmp == nil {
+		return nil
+	}
+	p := *fs.rcmp
+	return &p
+}
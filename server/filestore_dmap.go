@@ -0,0 +1,279 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// deleteMap holds the interior deletes of a message block. Single deletes are
+// kept in a map, a deleted range of sequences is kept as a single run so it
+// takes the same space, in memory and in the index file, no matter how many
+// messages it covers. The zero value is an empty map.
+type deleteMap struct {
+	seqs map[uint64]struct{}
+	// Sorted and non overlapping, never holding any of seqs.
+	runs DeleteRanges
+	// Number of sequences in runs.
+	nruns uint64
+}
+
+// Returns the number of deleted sequences.
+func (dm *deleteMap) size() int {
+	return len(dm.seqs) + int(dm.nruns)
+}
+
+func (dm *deleteMap) isEmpty() bool {
+	return len(dm.seqs) == 0 && len(dm.runs) == 0
+}
+
+// Removes all deleted sequences.
+func (dm *deleteMap) empty() {
+	dm.seqs, dm.runs, dm.nruns = nil, nil, 0
+}
+
+// Returns the index of the first run that ends at or after seq.
+func (dm *deleteMap) runAt(seq uint64) int {
+	return sort.Search(len(dm.runs), func(i int) bool { return dm.runs[i].Last() >= seq })
+}
+
+func (dm *deleteMap) exists(seq uint64) bool {
+	if _, ok := dm.seqs[seq]; ok {
+		return true
+	}
+	if len(dm.runs) == 0 {
+		return false
+	}
+	i := dm.runAt(seq)
+	return i < len(dm.runs) && dm.runs[i].First <= seq
+}
+
+// Adds a single deleted sequence.
+func (dm *deleteMap) insert(seq uint64) {
+	if dm.exists(seq) {
+		return
+	}
+	if dm.seqs == nil {
+		dm.seqs = make(map[uint64]struct{})
+	}
+	dm.seqs[seq] = struct{}{}
+}
+
+// Adds all sequences from first to last inclusive as deleted.
+func (dm *deleteMap) insertRange(first, last uint64) {
+	if first == 0 || last < first {
+		return
+	}
+	// Any single deletes are now part of the run.
+	if n := last - first + 1; uint64(len(dm.seqs)) <= n {
+		for seq := range dm.seqs {
+			if seq >= first && seq <= last {
+				delete(dm.seqs, seq)
+			}
+		}
+	} else {
+		for seq := first; seq <= last; seq++ {
+			delete(dm.seqs, seq)
+		}
+	}
+	if len(dm.seqs) == 0 {
+		dm.seqs = nil
+	}
+
+	// Merge with any runs we overlap or touch.
+	i := dm.runAt(first - 1)
+	j, nf, nl := i, first, last
+	for ; j < len(dm.runs) && dm.runs[j].First <= last+1; j++ {
+		if dm.runs[j].First < nf {
+			nf = dm.runs[j].First
+		}
+		if l := dm.runs[j].Last(); l > nl {
+			nl = l
+		}
+		dm.nruns -= dm.runs[j].Num
+	}
+	dr := DeleteRange{First: nf, Num: nl - nf + 1}
+	dm.nruns += dr.Num
+	if i == j {
+		dm.runs = append(dm.runs, DeleteRange{})
+		copy(dm.runs[i+1:], dm.runs[i:])
+		dm.runs[i] = dr
+		return
+	}
+	dm.runs[i] = dr
+	dm.runs = append(dm.runs[:i+1], dm.runs[j:]...)
+}
+
+// Removes seq from the deleted sequences, returning true if it was there.
+func (dm *deleteMap) delete(seq uint64) bool {
+	if _, ok := dm.seqs[seq]; ok {
+		delete(dm.seqs, seq)
+		if len(dm.seqs) == 0 {
+			dm.seqs = nil
+		}
+		return true
+	}
+	i := dm.runAt(seq)
+	if i >= len(dm.runs) || dm.runs[i].First > seq {
+		return false
+	}
+	dm.nruns--
+	dr := &dm.runs[i]
+	switch {
+	case dr.Num == 1:
+		dm.runs = append(dm.runs[:i], dm.runs[i+1:]...)
+		if len(dm.runs) == 0 {
+			dm.runs = nil
+		}
+	case seq == dr.First:
+		dr.First++
+		dr.Num--
+	case seq == dr.Last():
+		dr.Num--
+	default:
+		// Split the run in two.
+		tail := DeleteRange{First: seq + 1, Num: dr.Last() - seq}
+		dr.Num = seq - dr.First
+		dm.runs = append(dm.runs, DeleteRange{})
+		copy(dm.runs[i+2:], dm.runs[i+1:])
+		dm.runs[i+1] = tail
+	}
+	return true
+}
+
+// Removes all deleted sequences below seq.
+func (dm *deleteMap) deleteBelow(seq uint64) {
+	for dseq := range dm.seqs {
+		if dseq < seq {
+			delete(dm.seqs, dseq)
+		}
+	}
+	if len(dm.seqs) == 0 {
+		dm.seqs = nil
+	}
+	i := dm.runAt(seq)
+	for _, dr := range dm.runs[:i] {
+		dm.nruns -= dr.Num
+	}
+	if i < len(dm.runs) && dm.runs[i].First < seq {
+		n := seq - dm.runs[i].First
+		dm.runs[i].First, dm.runs[i].Num = seq, dm.runs[i].Num-n
+		dm.nruns -= n
+	}
+	if dm.runs = dm.runs[i:]; len(dm.runs) == 0 {
+		dm.runs = nil
+	}
+}
+
+// Returns the first sequence at or after seq that is not deleted, dropping the
+// deleted sequences we moved past.
+func (dm *deleteMap) advance(seq uint64) uint64 {
+	for {
+		if _, ok := dm.seqs[seq]; ok {
+			delete(dm.seqs, seq)
+			seq++
+			continue
+		}
+		if len(dm.runs) > 0 {
+			if i := dm.runAt(seq); i < len(dm.runs) && dm.runs[i].First <= seq {
+				seq = dm.runs[i].Last() + 1
+				for _, dr := range dm.runs[:i+1] {
+					dm.nruns -= dr.Num
+				}
+				if dm.runs = dm.runs[i+1:]; len(dm.runs) == 0 {
+					dm.runs = nil
+				}
+				continue
+			}
+		}
+		if len(dm.seqs) == 0 {
+			dm.seqs = nil
+		}
+		return seq
+	}
+}
+
+// Adds the deleted sequences from first to last inclusive to drs, in order.
+func (dm *deleteMap) appendRanges(drs *DeleteRanges, first, last uint64) {
+	var seqs []uint64
+	for seq := range dm.seqs {
+		if seq >= first && seq <= last {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	i := dm.runAt(first)
+	for _, seq := range seqs {
+		for ; i < len(dm.runs) && dm.runs[i].First < seq; i++ {
+			dm.appendRun(drs, dm.runs[i], first, last)
+		}
+		drs.add(seq, 1)
+	}
+	for ; i < len(dm.runs) && dm.runs[i].First <= last; i++ {
+		dm.appendRun(drs, dm.runs[i], first, last)
+	}
+}
+
+// Adds the part of dr from first to last inclusive to drs.
+func (dm *deleteMap) appendRun(drs *DeleteRanges, dr DeleteRange, first, last uint64) {
+	f, l := dr.First, dr.Last()
+	if f < first {
+		f = first
+	}
+	if l > last {
+		l = last
+	}
+	if f <= l {
+		drs.add(f, l-f+1)
+	}
+}
+
+// Encodes the deleted sequences relative to fseq, which all need to be past.
+// Single deletes are their offset, runs their offset with the ebit set and
+// followed by their length, so older index files decode the same.
+func (dm *deleteMap) encode(fseq uint64) []byte {
+	buf := make([]byte, 0, (len(dm.seqs)+2*len(dm.runs))*binary.MaxVarintLen64)
+	for seq := range dm.seqs {
+		buf = binary.AppendUvarint(buf, seq-fseq)
+	}
+	for _, dr := range dm.runs {
+		buf = binary.AppendUvarint(buf, (dr.First-fseq)|ebit)
+		buf = binary.AppendUvarint(buf, dr.Num)
+	}
+	return buf
+}
+
+// Decodes the n deleted sequences encoded relative to fseq in buf.
+func (dm *deleteMap) decode(buf []byte, fseq, n uint64) {
+	for bi := 0; uint64(dm.size()) < n && bi < len(buf); {
+		v, l := binary.Uvarint(buf[bi:])
+		if l <= 0 || v == 0 {
+			return
+		}
+		bi += l
+		if v&ebit == 0 {
+			dm.insert(fseq + v)
+			continue
+		}
+		num, l := binary.Uvarint(buf[bi:])
+		if l <= 0 {
+			return
+		}
+		bi += l
+		first := fseq + v&^ebit
+		dm.insertRange(first, first+num-1)
+	}
+}
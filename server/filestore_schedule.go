@@ -0,0 +1,194 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+)
+
+// File holding our index of scheduled messages. This holds the last sequence
+// it covers, and the sequence and due time of each scheduled message up to it.
+// Anything stored after is picked up by scanning the messages on recovery.
+const schedFile = "sched.idx"
+
+const schedFileMagic = uint8(22)
+
+// Track a message we just stored if it is scheduled for later delivery.
+// Lock should be held.
+func (fs *fileStore) trackScheduled(seq uint64, ts int64, hdr []byte) {
+	if len(hdr) == 0 {
+		return
+	}
+	if due, err := scheduledAt(hdr, ts); err == nil && due > ts {
+		if fs.sched == nil {
+			fs.sched = make(map[uint64]int64)
+		}
+		fs.sched[seq] = due
+		fs.schedDirty = true
+	}
+}
+
+// Drops any scheduled entries outside of our current range.
+// Lock should be held.
+func (fs *fileStore) pruneScheduleLocked() {
+	for seq := range fs.sched {
+		if seq < fs.state.FirstSeq || seq > fs.state.LastSeq {
+			delete(fs.sched, seq)
+			fs.schedDirty = true
+		}
+	}
+}
+
+// ScheduledMsgs returns the sequences of all messages withheld for later
+// delivery and when they are due in unix nanoseconds.
+func (fs *fileStore) ScheduledMsgs() map[uint64]int64 {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	sched := make(map[uint64]int64, len(fs.sched))
+	for seq, due := range fs.sched {
+		sched[seq] = due
+	}
+	return sched
+}
+
+// IsScheduled returns true if the message at seq is withheld for later delivery.
+func (fs *fileStore) IsScheduled(seq uint64) bool {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	_, ok := fs.sched[seq]
+	return ok
+}
+
+// UnscheduleMsg will release the message at seq to consumers.
+// Returns true if the message was scheduled.
+func (fs *fileStore) UnscheduleMsg(seq uint64) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.sched[seq]; !ok {
+		return false
+	}
+	delete(fs.sched, seq)
+	fs.schedDirty = true
+	return true
+}
+
+// Writes out our schedule index if it changed or we have stored more messages.
+// Lock should be held.
+func (fs *fileStore) writeScheduleLocked() error {
+	if !fs.schedDirty && fs.schedLseq == fs.state.LastSeq {
+		return nil
+	}
+	var b [binary.MaxVarintLen64]byte
+	buf := make([]byte, 0, 16+len(fs.sched)*2*binary.MaxVarintLen64)
+	buf = append(buf, schedFileMagic)
+	buf = append(buf, b[:binary.PutUvarint(b[:], fs.state.LastSeq)]...)
+	buf = append(buf, b[:binary.PutUvarint(b[:], uint64(len(fs.sched)))]...)
+	for seq, due := range fs.sched {
+		buf = append(buf, b[:binary.PutUvarint(b[:], seq)]...)
+		buf = append(buf, b[:binary.PutVarint(b[:], due)]...)
+	}
+	fs.hh.Reset()
+	fs.hh.Write(buf)
+	buf = fs.hh.Sum(buf)
+
+	fn := filepath.Join(fs.fcfg.StoreDir, schedFile)
+	tmp := fn + ".tmp"
+	if err := os.WriteFile(tmp, buf, defaultFilePerms); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		return err
+	}
+	fs.schedDirty, fs.schedLseq = false, fs.state.LastSeq
+	return nil
+}
+
+// Recovers our schedule index, picking up anything stored after it was last written.
+// Lock should be held.
+func (fs *fileStore) recoverSchedule() {
+	var lseq uint64
+	if buf, err := os.ReadFile(filepath.Join(fs.fcfg.StoreDir, schedFile)); err == nil {
+		lseq = fs.readSchedule(buf)
+	}
+	// Drop anything we no longer have.
+	fs.pruneScheduleLocked()
+
+	start := lseq + 1
+	if start < fs.state.FirstSeq {
+		start = fs.state.FirstSeq
+	}
+	var smv StoreMsg
+	for seq := start; seq <= fs.state.LastSeq; seq++ {
+		mb := fs.selectMsgBlock(seq)
+		if mb == nil {
+			continue
+		}
+		if sm, _, _ := mb.fetchMsg(seq, &smv); sm != nil {
+			fs.trackScheduled(seq, sm.ts, sm.hdr)
+		}
+	}
+	if fs.state.LastSeq != lseq {
+		fs.schedDirty = true
+	}
+}
+
+// Decodes a schedule index file, returning the last sequence it covers.
+// Returns 0 if the file is corrupt, in which case all messages will be scanned.
+// Lock should be held.
+func (fs *fileStore) readSchedule(buf []byte) uint64 {
+	if len(buf) < 1+8 || buf[0] != schedFileMagic {
+		return 0
+	}
+	buf, sum := buf[:len(buf)-8], buf[len(buf)-8:]
+	fs.hh.Reset()
+	fs.hh.Write(buf)
+	if !bytes.Equal(sum, fs.hh.Sum(nil)) {
+		return 0
+	}
+	bi := 1
+	readU := func() (uint64, bool) {
+		v, n := binary.Uvarint(buf[bi:])
+		if n <= 0 {
+			return 0, false
+		}
+		bi += n
+		return v, true
+	}
+	lseq, ok := readU()
+	if !ok {
+		return 0
+	}
+	num, ok := readU()
+	if !ok {
+		return 0
+	}
+	sched := make(map[uint64]int64, num)
+	for i := uint64(0); i < num; i++ {
+		seq, ok := readU()
+		if !ok {
+			return 0
+		}
+		due, n := binary.Varint(buf[bi:])
+		if n <= 0 {
+			return 0
+		}
+		bi += n
+		sched[seq] = due
+	}
+	fs.sched, fs.schedLseq = sched, lseq
+	return lseq
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Directory holding reclaimed message data waiting to be scrubbed.
+const scrubDir = "__scrub__"
+
+// What we overwrite reclaimed data with.
+var scrubZeros = make([]byte, 64*1024)
+
+// Returns if reclaimed message data needs to be scrubbed.
+func (fs *fileStore) scrubbing() bool {
+	return fs != nil && atomic.LoadInt32(&fs.scrub) != 0
+}
+
+// Applies the scrub interval of the stream config, starting or stopping the scrubber.
+// Lock should be held.
+func (fs *fileStore) applyScrubPolicy(cfg *StreamConfig) {
+	if cfg.ScrubInterval <= 0 {
+		atomic.StoreInt32(&fs.scrub, 0)
+		if fs.scrubTmr != nil {
+			fs.scrubTmr.Stop()
+			fs.scrubTmr = nil
+		}
+		return
+	}
+	os.MkdirAll(filepath.Join(fs.fcfg.StoreDir, scrubDir), defaultDirPerms)
+	atomic.StoreInt32(&fs.scrub, 1)
+	if fs.scrubTmr == nil {
+		fs.scrubTmr = time.AfterFunc(cfg.ScrubInterval, fs.scrubReclaimed)
+	} else {
+		fs.scrubTmr.Reset(cfg.ScrubInterval)
+	}
+}
+
+// Lock should be held.
+func (fs *fileStore) cancelScrubTimer() {
+	if fs.scrubTmr != nil {
+		fs.scrubTmr.Stop()
+		fs.scrubTmr = nil
+	}
+}
+
+// Will move a reclaimed file or directory out of the way to be scrubbed by the
+// scrubber, or just remove it if we are not scrubbing. If we can not move it we
+// scrub it in place.
+func (fs *fileStore) reclaim(name string) {
+	if !fs.scrubbing() {
+		os.RemoveAll(name)
+		return
+	}
+	if err := os.Rename(name, fs.scrubName(name)); err != nil {
+		scrubPath(name)
+	}
+}
+
+// Returns a unique name in our scrub directory for a reclaimed file or directory.
+func (fs *fileStore) scrubName(name string) string {
+	return filepath.Join(fs.fcfg.StoreDir, scrubDir, fmt.Sprintf("%s.%d", filepath.Base(name), time.Now().UnixNano()))
+}
+
+// Called from a timer to scrub all reclaimed message data.
+func (fs *fileStore) scrubReclaimed() {
+	fs.mu.RLock()
+	if fs.closed {
+		fs.mu.RUnlock()
+		return
+	}
+	sdir := filepath.Join(fs.fcfg.StoreDir, scrubDir)
+	fs.mu.RUnlock()
+
+	if fis, err := os.ReadDir(sdir); err == nil {
+		for _, fi := range fis {
+			scrubPath(filepath.Join(sdir, fi.Name()))
+		}
+	}
+
+	fs.mu.Lock()
+	if !fs.closed && fs.scrubTmr != nil {
+		fs.scrubTmr.Reset(fs.cfg.ScrubInterval)
+	}
+	fs.mu.Unlock()
+}
+
+// Will overwrite all files under name with zeros and remove them.
+func scrubPath(name string) error {
+	err := filepath.Walk(name, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		return scrubFile(path)
+	})
+	if rerr := os.RemoveAll(name); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// Will overwrite a file with zeros, making sure it reaches the disk.
+func scrubFile(name string) error {
+	fd, err := os.OpenFile(name, os.O_WRONLY, defaultFilePerms)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if err := zeroFileRange(fd, 0, fi.Size()); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
+
+// Will overwrite the region from start up to end with zeros.
+func zeroFileRange(fd *os.File, start, end int64) error {
+	for off := start; off < end; {
+		n := int64(len(scrubZeros))
+		if end-off < n {
+			n = end - off
+		}
+		if _, err := fd.WriteAt(scrubZeros[:n], off); err != nil {
+			return err
+		}
+		off += n
+	}
+	return nil
+}
+
+// Will overwrite the tail of a message block file we are about to truncate at eof.
+func (mb *msgBlock) scrubTail(fd *os.File, eof int64) {
+	if mb.fs == nil || !mb.fs.scrubbing() {
+		return
+	}
+	if fi, err := fd.Stat(); err == nil && fi.Size() > eof {
+		zeroFileRange(fd, eof, fi.Size())
+	}
+}
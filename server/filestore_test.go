@@ -31,6 +31,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -671,6 +672,206 @@ func TestFileStoreTimeStamps(t *testing.T) {
 	})
 }
 
+func TestFileStoreGetSeqFromTime(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 4 * 1024
+		fs, err := newFileStore(fcfg, StreamConfig{Name: "zzz", Storage: FileStorage})
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		subj, msg := "foo", []byte("Hello World")
+		for i := 0; i < 1000; i++ {
+			_, _, err := fs.StoreMsg(subj, nil, msg)
+			require_NoError(t, err)
+		}
+		// Remove some, including runs that span index entries.
+		for seq := uint64(100); seq < 300; seq++ {
+			if seq%3 != 0 {
+				_, err := fs.RemoveMsg(seq)
+				require_NoError(t, err)
+			}
+		}
+		for seq := uint64(500); seq < 600; seq++ {
+			_, err := fs.RemoveMsg(seq)
+			require_NoError(t, err)
+		}
+
+		checkSeqs := func() {
+			t.Helper()
+			var ts []int64
+			var seqs []uint64
+			var smv StoreMsg
+			for seq := uint64(1); seq <= 1000; seq++ {
+				if sm, err := fs.LoadMsg(seq, &smv); err == nil {
+					ts, seqs = append(ts, sm.ts), append(seqs, seq)
+				}
+			}
+			for i := range ts {
+				// First stored at or after, which can be an earlier sequence with the same timestamp.
+				expected := seqs[i]
+				for j := i - 1; j >= 0 && ts[j] == ts[i]; j-- {
+					expected = seqs[j]
+				}
+				if seq := fs.GetSeqFromTime(time.Unix(0, ts[i])); seq != expected {
+					t.Fatalf("Expected seq %d for ts of seq %d, got %d", expected, seqs[i], seq)
+				}
+				// Just after a message is the next one still present, or one past the block for removed runs.
+				if i+1 < len(ts) && ts[i+1] > ts[i] {
+					if seq := fs.GetSeqFromTime(time.Unix(0, ts[i]+1)); seq <= seqs[i] || seq > seqs[i+1] {
+						t.Fatalf("Expected seq in (%d, %d] after seq %d, got %d", seqs[i], seqs[i+1], seqs[i], seq)
+					}
+				}
+			}
+			require_True(t, fs.GetSeqFromTime(time.Unix(0, ts[0]-1)) == 1)
+			require_True(t, fs.GetSeqFromTime(time.Unix(0, ts[len(ts)-1]+1)) == 1001)
+		}
+		checkSeqs()
+
+		// The index is rebuilt when the blocks are loaded after a restart.
+		fs.Stop()
+		fs, err = newFileStore(fcfg, StreamConfig{Name: "zzz", Storage: FileStorage})
+		require_NoError(t, err)
+		defer fs.Stop()
+		checkSeqs()
+
+		// And kept up to date with new messages.
+		for i := 0; i < 100; i++ {
+			_, _, err := fs.StoreMsg(subj, nil, msg)
+			require_NoError(t, err)
+		}
+		var smv StoreMsg
+		sm, err := fs.LoadMsg(1050, &smv)
+		require_NoError(t, err)
+		seq := fs.GetSeqFromTime(time.Unix(0, sm.ts))
+		require_True(t, seq <= 1050 && seq > 1000)
+	})
+}
+
+func TestFileStoreSubjectBloom(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		fcfg.CacheExpire = 50 * time.Millisecond
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"*"}, Storage: FileStorage, SubjectBloom: true}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		// A sparse subject only in the first block.
+		_, _, err = fs.StoreMsg("bar", nil, []byte("ok"))
+		require_NoError(t, err)
+		msg := make([]byte, 64)
+		for i := 0; i < 50; i++ {
+			_, _, err := fs.StoreMsg(fmt.Sprintf("foo%d", i), nil, msg)
+			require_NoError(t, err)
+		}
+		require_True(t, fs.numMsgBlocks() > 5)
+
+		blks := func() []*msgBlock {
+			fs.mu.RLock()
+			defer fs.mu.RUnlock()
+			return append([]*msgBlock(nil), fs.blks...)
+		}
+		waitUnloaded := func() {
+			t.Helper()
+			checkFor(t, 2*time.Second, 20*time.Millisecond, func() error {
+				for _, mb := range blks() {
+					mb.mu.RLock()
+					loaded := mb.fss != nil
+					mb.mu.RUnlock()
+					if loaded {
+						return fmt.Errorf("per subject info still loaded for block %d", mb.index)
+					}
+				}
+				return nil
+			})
+		}
+		// Returns the blocks we loaded per subject info for.
+		loaded := func() (n int) {
+			for _, mb := range blks() {
+				mb.mu.RLock()
+				if mb.fss != nil {
+					n++
+				}
+				mb.mu.RUnlock()
+			}
+			return n
+		}
+		check := func() {
+			t.Helper()
+			waitUnloaded()
+			for _, mb := range blks() {
+				mb.mu.RLock()
+				hasBloom := mb.sbf != nil
+				mb.mu.RUnlock()
+				require_True(t, hasBloom)
+			}
+			sm, _, err := fs.LoadNextMsg("bar", false, 2, nil)
+			require_Error(t, err, ErrStoreEOF)
+			require_True(t, sm == nil)
+			// Only the first block can have it.
+			require_True(t, loaded() <= 1)
+
+			ss := fs.FilteredState(1, "bar")
+			require_True(t, ss.Msgs == 1 && ss.First == 1 && ss.Last == 1)
+			sm, _, err = fs.LoadNextMsg("bar", false, 1, nil)
+			require_NoError(t, err)
+			require_Equal(t, sm.subj, "bar")
+
+			// Wildcards can not use the filter.
+			ss = fs.FilteredState(1, "foo*")
+			require_True(t, ss.Msgs == 0)
+			ss = fs.FilteredState(1, "*")
+			require_True(t, ss.Msgs == 51)
+		}
+		check()
+
+		// The filters are recovered from disk.
+		fs.Stop()
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		for _, mb := range blks() {
+			mb.mu.RLock()
+			_, err := os.Stat(mb.bloomFileName())
+			mb.mu.RUnlock()
+			require_NoError(t, err)
+		}
+		check()
+
+		// Turning them off drops them.
+		cfg.SubjectBloom = false
+		require_NoError(t, fs.UpdateConfig(&cfg))
+		for _, mb := range blks() {
+			mb.mu.RLock()
+			hasBloom := mb.sbf != nil
+			_, err := os.Stat(mb.bloomFileName())
+			mb.mu.RUnlock()
+			require_False(t, hasBloom)
+			require_True(t, os.IsNotExist(err))
+		}
+		ss := fs.FilteredState(1, "bar")
+		require_True(t, ss.Msgs == 1)
+	})
+}
+
+func TestSubjectBloom(t *testing.T) {
+	bf := newSubjectBloom(1000)
+	for i := 0; i < 1000; i++ {
+		bf.add(fmt.Sprintf("foo.%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		require_True(t, bf.has(fmt.Sprintf("foo.%d", i)))
+	}
+	var fp int
+	for i := 0; i < 10000; i++ {
+		if bf.has(fmt.Sprintf("bar.%d", i)) {
+			fp++
+		}
+	}
+	// Should be about 1%.
+	require_True(t, fp < 300)
+}
+
 func TestFileStorePurge(t *testing.T) {
 	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
 		blkSize := uint64(64 * 1024)
@@ -1045,8 +1246,8 @@ func TestFileStoreStreamTruncate(t *testing.T) {
 			t.Fatalf("Expected %d msgs, got %d", tseq-2, state.Msgs)
 		}
 		expected := []uint64{10, 20}
-		if !reflect.DeepEqual(state.Deleted, expected) {
-			t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.Deleted)
+		if !reflect.DeepEqual(state.DeletedSeqs(), expected) {
+			t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.DeletedSeqs())
 		}
 
 		before := state
@@ -2823,7 +3024,7 @@ func TestFileStoreStreamStateDeleted(t *testing.T) {
 			}
 		}
 		state := fs.State()
-		if len(state.Deleted) != 0 {
+		if len(state.DeletedSeqs()) != 0 {
 			t.Fatalf("Expected deleted to be empty")
 		}
 		// Now remove some interior messages.
@@ -2833,23 +3034,23 @@ func TestFileStoreStreamStateDeleted(t *testing.T) {
 			expected = append(expected, seq)
 		}
 		state = fs.State()
-		if !reflect.DeepEqual(state.Deleted, expected) {
-			t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.Deleted)
+		if !reflect.DeepEqual(state.DeletedSeqs(), expected) {
+			t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.DeletedSeqs())
 		}
 		// Now fill the gap by deleting 1 and 3
 		fs.RemoveMsg(1)
 		fs.RemoveMsg(3)
 		expected = expected[2:]
 		state = fs.State()
-		if !reflect.DeepEqual(state.Deleted, expected) {
-			t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.Deleted)
+		if !reflect.DeepEqual(state.DeletedSeqs(), expected) {
+			t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.DeletedSeqs())
 		}
 		if state.FirstSeq != 5 {
 			t.Fatalf("Expected first seq to be 5, got %d", state.FirstSeq)
 		}
 		fs.Purge()
-		if state = fs.State(); len(state.Deleted) != 0 {
-			t.Fatalf("Expected no deleted after purge, got %+v\n", state.Deleted)
+		if state = fs.State(); len(state.DeletedSeqs()) != 0 {
+			t.Fatalf("Expected no deleted after purge, got %+v\n", state.DeletedSeqs())
 		}
 	})
 }
@@ -3956,7 +4157,7 @@ func TestFileStoreRebuildStateDmapAccountingBug(t *testing.T) {
 			t.Helper()
 			mb.mu.RLock()
 			defer mb.mu.RUnlock()
-			dmapLen := uint64(len(mb.dmap))
+			dmapLen := uint64(mb.dmap.size())
 			if mb.msgs != (mb.last.seq-mb.first.seq+1)-dmapLen {
 				t.Fatalf("Consistency check failed: %d != %d -> last %d first %d len(dmap) %d",
 					mb.msgs, (mb.last.seq-mb.first.seq+1)-dmapLen, mb.last.seq, mb.first.seq, dmapLen)
@@ -4292,7 +4493,7 @@ func TestFileStoreMaxMsgsPerSubject(t *testing.T) {
 			require_NoError(t, err)
 		}
 
-		if state := fs.State(); state.Msgs != 100 || state.FirstSeq != 101 || state.LastSeq != 200 || len(state.Deleted) != 0 {
+		if state := fs.State(); state.Msgs != 100 || state.FirstSeq != 101 || state.LastSeq != 200 || len(state.DeletedRanges) != 0 {
 			t.Fatalf("Bad state: %+v", state)
 		}
 
@@ -4341,7 +4542,7 @@ func TestFileStoreSubjectStateCacheExpiration(t *testing.T) {
 		if len(fss) != 1 {
 			t.Fatalf("Expected 1 entry but got %d", len(fss))
 		}
-		expected := SimpleState{Msgs: 1, First: 199, Last: 199}
+		expected := SimpleState{Msgs: 1, Bytes: fileStoreMsgSize("kv.bar.99", nil, []byte("value")), First: 199, Last: 199}
 		if ss := fss["kv.bar.99"]; ss != expected {
 			t.Fatalf("Bad subject state, expected %+v but got %+v", expected, ss)
 		}
@@ -4358,7 +4559,8 @@ func TestFileStoreSubjectStateCacheExpiration(t *testing.T) {
 		if len(fss) != 1 {
 			t.Fatalf("Expected 1 entry but got %d", len(fss))
 		}
-		expected = SimpleState{Msgs: 2, First: 1, Last: 201}
+		bytes := fileStoreMsgSize("kv.foo.1", nil, []byte("value")) + fileStoreMsgSize("kv.foo.1", nil, []byte("value22"))
+		expected = SimpleState{Msgs: 2, Bytes: bytes, First: 1, Last: 201}
 		if ss := fss["kv.foo.1"]; ss != expected {
 			t.Fatalf("Bad subject state, expected %+v but got %+v", expected, ss)
 		}
@@ -5227,3 +5429,1219 @@ func TestFileStoreStreamCompactMultiBlockSubjectInfo(t *testing.T) {
 		require_True(t, state.NumSubjects == 500)
 	})
 }
+
+func TestFileStorePrefetch(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 128
+
+		fs, err := newFileStore(fcfg, StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage})
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		for i := 0; i < 100; i++ {
+			_, _, err := fs.StoreMsg("foo", nil, []byte("Hello World"))
+			require_NoError(t, err)
+		}
+		require_True(t, fs.numMsgBlocks() == 50)
+		fs.Stop()
+
+		// Restart to get a cold cache.
+		fs, err = newFileStore(fcfg, StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage})
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		cl := fs.cacheLoads()
+
+		// Sequences 11-20 should span 5 blocks.
+		fs.Prefetch(11, 10)
+		checkFor(t, time.Second, 10*time.Millisecond, func() error {
+			if n := fs.cacheLoads() - cl; n != 5 {
+				return fmt.Errorf("Expected 5 cache loads, got %d", n)
+			}
+			return nil
+		})
+
+		// Loading these messages should not need to load any blocks.
+		for seq := uint64(11); seq <= 20; seq++ {
+			_, err := fs.LoadMsg(seq, nil)
+			require_NoError(t, err)
+		}
+		require_True(t, fs.cacheLoads()-cl == 5)
+
+		// Out of range and empty requests are no-ops.
+		fs.Prefetch(1000, 10)
+		fs.Prefetch(1, 0)
+		time.Sleep(50 * time.Millisecond)
+		require_True(t, fs.cacheLoads()-cl == 5)
+	})
+}
+
+func TestFileStoreStoreStats(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fs, err := newFileStore(fcfg, StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage, MaxAge: 100 * time.Millisecond})
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		msg := []byte("Hello World")
+		for i := 0; i < 100; i++ {
+			_, _, err := fs.StoreMsg("foo", nil, msg)
+			require_NoError(t, err)
+		}
+		msz := fileStoreMsgSize("foo", nil, msg)
+
+		ss := fs.StoreStats()
+		require_True(t, ss.InMsgsRate == 100.0/storeRateWindow)
+		require_True(t, ss.InBytesRate == float64(100*msz)/storeRateWindow)
+		require_True(t, ss.ExpiredMsgsRate == 0)
+
+		checkFor(t, time.Second, 20*time.Millisecond, func() error {
+			if state := fs.State(); state.Msgs != 0 {
+				return fmt.Errorf("Expected no msgs, got %d", state.Msgs)
+			}
+			return nil
+		})
+		ss = fs.StoreStats()
+		require_True(t, ss.ExpiredMsgsRate == 100.0/storeRateWindow)
+		require_True(t, ss.ExpiredBytesRate == float64(100*msz)/storeRateWindow)
+	})
+}
+
+func TestFileStoreAgeSummary(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		fs, err := newFileStore(fcfg, StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage})
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		require_True(t, fs.AgeSummary() == nil)
+
+		// One message a second, so ranks and seconds line up.
+		base := time.Now().Add(-time.Hour).Truncate(time.Second)
+		for i := 0; i < 100; i++ {
+			require_NoError(t, fs.StoreRawMsg("foo", nil, []byte("ok"), uint64(i+1), base.Add(time.Duration(i)*time.Second).UnixNano()))
+		}
+		require_True(t, fs.numMsgBlocks() > 1)
+
+		as := fs.AgeSummary()
+		require_True(t, as != nil)
+		require_True(t, as.Oldest.Equal(base))
+		require_True(t, as.P90.Equal(base.Add(10*time.Second)))
+		require_True(t, as.P50.Equal(base.Add(50*time.Second)))
+
+		// Removing the oldest messages moves everything up.
+		_, err = fs.Compact(21)
+		require_NoError(t, err)
+		as = fs.AgeSummary()
+		require_True(t, as.Oldest.Equal(base.Add(20*time.Second)))
+		require_True(t, as.P90.Equal(base.Add(28*time.Second)))
+		require_True(t, as.P50.Equal(base.Add(60*time.Second)))
+	})
+}
+
+func TestFileStoreExpiryBatch(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage, MaxAge: 100 * time.Millisecond, ExpiryBatch: 500 * time.Millisecond}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		start := time.Now()
+		_, _, err = fs.StoreMsg("foo", nil, []byte("Hello World"))
+		require_NoError(t, err)
+
+		// Should not have been expired before the batch window.
+		time.Sleep(250 * time.Millisecond)
+		require_True(t, fs.State().Msgs == 1)
+
+		checkFor(t, time.Second, 20*time.Millisecond, func() error {
+			if state := fs.State(); state.Msgs != 0 {
+				return fmt.Errorf("Expected no msgs, got %d", state.Msgs)
+			}
+			return nil
+		})
+		require_True(t, time.Since(start) >= 500*time.Millisecond)
+	})
+}
+
+func TestFileStoreSubjectsStateRange(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		fs, err := newFileStore(fcfg, StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: FileStorage})
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		// Two messages per subject spread across blocks.
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 20; j++ {
+				_, _, err := fs.StoreMsg(fmt.Sprintf("foo.%02d", j), nil, []byte("Hello World"))
+				require_NoError(t, err)
+			}
+		}
+
+		all := fs.SubjectsState("foo.*")
+		mss, total := fs.SubjectsStateRange("foo.*", _EMPTY_, 5)
+		require_True(t, total == 20)
+		require_True(t, len(mss) == 5)
+		for j := 0; j < 5; j++ {
+			subj := fmt.Sprintf("foo.%02d", j)
+			require_True(t, mss[subj] == all[subj])
+		}
+
+		// Page from a start subject.
+		mss, total = fs.SubjectsStateRange("foo.*", "foo.17", 5)
+		require_True(t, total == 20)
+		require_True(t, len(mss) == 2)
+		require_True(t, mss["foo.18"] == all["foo.18"])
+		require_True(t, mss["foo.19"] == all["foo.19"])
+
+		// No limit.
+		mss, total = fs.SubjectsStateRange(_EMPTY_, _EMPTY_, 0)
+		require_True(t, total == 20)
+		require_True(t, reflect.DeepEqual(mss, all))
+	})
+}
+
+func TestFileStoreSubjectsStateBytes(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: FileStorage}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		msg := []byte("Hello World")
+		hdr := []byte("NATS/1.0\r\nX: Y\r\n\r\n")
+		for i := 0; i < 10; i++ {
+			_, _, err := fs.StoreMsg("foo.a", nil, msg)
+			require_NoError(t, err)
+			_, _, err = fs.StoreMsg("foo.b", hdr, msg)
+			require_NoError(t, err)
+		}
+		asz, bsz := fileStoreMsgSize("foo.a", nil, msg), fileStoreMsgSize("foo.b", hdr, msg)
+
+		checkBytes := func(a, b uint64) {
+			t.Helper()
+			fss := fs.SubjectsState("foo.*")
+			require_True(t, fss["foo.a"].Bytes == a)
+			require_True(t, fss["foo.b"].Bytes == b)
+			mss, _ := fs.SubjectsStateRange("foo.*", _EMPTY_, 0)
+			require_True(t, reflect.DeepEqual(mss, fss))
+			require_True(t, a+b == fs.State().Bytes)
+		}
+		checkBytes(10*asz, 10*bsz)
+
+		// Remove from the middle and the head.
+		_, err = fs.RemoveMsg(5)
+		require_NoError(t, err)
+		_, err = fs.EraseMsg(2)
+		require_NoError(t, err)
+		checkBytes(9*asz, 9*bsz)
+
+		_, err = fs.PurgeEx("foo.a", 0, 0)
+		require_NoError(t, err)
+		checkBytes(0, 9*bsz)
+
+		// Make sure we recover the same after a restart.
+		fs.Stop()
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		checkBytes(0, 9*bsz)
+
+		_, err = fs.Compact(18)
+		require_NoError(t, err)
+		checkBytes(0, 2*bsz)
+	})
+}
+
+func TestFileStoreRecoveryProgress(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		msg := bytes.Repeat([]byte("Z"), 64)
+		for i := 0; i < 50; i++ {
+			_, _, err := fs.StoreMsg("foo", nil, msg)
+			require_NoError(t, err)
+		}
+		nblks := fs.numMsgBlocks()
+		require_True(t, nblks > 5)
+		fs.Stop()
+
+		orig := recoveryProgressInterval
+		recoveryProgressInterval = 0
+		defer func() { recoveryProgressInterval = orig }()
+
+		var reports []RecoveryProgress
+		fcfg.RecoveryHandler = func(p RecoveryProgress) { reports = append(reports, p) }
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		// One for the start, one per block and one when done.
+		require_True(t, len(reports) == nblks+2)
+		for i, p := range reports {
+			require_True(t, p.Blocks == nblks)
+			if i <= nblks {
+				require_True(t, p.Recovered == i)
+				require_False(t, p.Done)
+			}
+		}
+		require_True(t, reports[0].Percent == 0)
+		last := reports[len(reports)-1]
+		require_True(t, last.Done)
+		require_True(t, last.Recovered == nblks)
+		require_True(t, last.Percent == 100)
+		require_True(t, last.ETA == 0)
+	})
+}
+
+func TestFileStoreParallelRecovery(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: FileStorage}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		msg := bytes.Repeat([]byte("Z"), 64)
+		for i := 0; i < 200; i++ {
+			_, _, err := fs.StoreMsg(fmt.Sprintf("foo.%d", i%7), nil, msg)
+			require_NoError(t, err)
+		}
+		for seq := uint64(1); seq <= 200; seq += 11 {
+			_, err := fs.RemoveMsg(seq)
+			require_NoError(t, err)
+		}
+		require_True(t, fs.numMsgBlocks() > 20)
+		var state StreamState
+		fs.FastState(&state)
+		subjects := fs.SubjectsState("foo.*")
+		fs.Stop()
+
+		// Make some blocks rebuild their state.
+		for _, index := range []uint32{2, 9, 15} {
+			os.Remove(filepath.Join(fcfg.StoreDir, msgDir, fmt.Sprintf(indexScan, index)))
+		}
+
+		for _, workers := range []int{1, 4, 0} {
+			fcfg.RecoveryWorkers = workers
+			fs, err = newFileStore(fcfg, cfg)
+			require_NoError(t, err)
+
+			var rstate StreamState
+			fs.FastState(&rstate)
+			require_True(t, rstate.Msgs == state.Msgs)
+			require_True(t, rstate.Bytes == state.Bytes)
+			require_True(t, rstate.FirstSeq == state.FirstSeq)
+			require_True(t, rstate.LastSeq == state.LastSeq)
+			require_True(t, reflect.DeepEqual(fs.SubjectsState("foo.*"), subjects))
+
+			// Blocks are in order and the per subject state points to the right ones.
+			fs.mu.RLock()
+			for i, mb := range fs.blks {
+				require_True(t, fs.bim[mb.index] == mb)
+				if i > 0 {
+					require_True(t, fs.blks[i-1].index < mb.index)
+				}
+			}
+			require_True(t, fs.lmb == fs.blks[len(fs.blks)-1])
+			for subj, info := range fs.psim {
+				ss := subjects[subj]
+				require_True(t, info.total == ss.Msgs)
+				fblk, lblk := fs.selectMsgBlock(ss.First), fs.selectMsgBlock(ss.Last)
+				require_True(t, info.fblk == fblk.index)
+				require_True(t, info.lblk == lblk.index)
+			}
+			fs.mu.RUnlock()
+			fs.Stop()
+		}
+	})
+}
+
+func TestFileStoreMaxBytesPerSubject(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		msg := []byte("Hello World")
+		msz := fileStoreMsgSize("foo.a", nil, msg)
+
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: FileStorage, MaxBytesPer: int64(3 * msz)}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		var evicted []uint64
+		fs.RegisterEvictionHandler(func(m *EvictedMsg) bool {
+			require_True(t, m.Reason == EvictMaxBytesPer)
+			evicted = append(evicted, m.Sequence)
+			return true
+		})
+
+		for i := 0; i < 10; i++ {
+			_, _, err := fs.StoreMsg("foo.a", nil, msg)
+			require_NoError(t, err)
+		}
+		_, _, err = fs.StoreMsg("foo.b", nil, msg)
+		require_NoError(t, err)
+
+		ss := fs.SubjectsState("foo.a")["foo.a"]
+		require_True(t, ss.Msgs == 3)
+		require_True(t, ss.Bytes == 3*msz)
+		require_True(t, ss.First == 8)
+		require_True(t, len(evicted) == 7)
+		require_True(t, fs.State().Msgs == 4)
+
+		// A single message larger than the limit is kept.
+		big := bytes.Repeat([]byte("Z"), int(4*msz))
+		seq, _, err := fs.StoreMsg("foo.a", nil, big)
+		require_NoError(t, err)
+		ss = fs.SubjectsState("foo.a")["foo.a"]
+		require_True(t, ss.Msgs == 1)
+		require_True(t, ss.First == seq)
+
+		// Lowering the limit should be enforced on update and on restart.
+		for i := 0; i < 5; i++ {
+			_, _, err := fs.StoreMsg("foo.b", nil, msg)
+			require_NoError(t, err)
+		}
+		require_True(t, fs.SubjectsState("foo.b")["foo.b"].Msgs == 3)
+
+		fs.Stop()
+		cfg.MaxBytesPer = int64(2 * msz)
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		require_True(t, fs.SubjectsState("foo.b")["foo.b"].Msgs == 2)
+
+		cfg.MaxBytesPer = int64(msz)
+		require_NoError(t, fs.UpdateConfig(&cfg))
+		ss = fs.SubjectsState("foo.b")["foo.b"]
+		require_True(t, ss.Msgs == 1)
+		require_True(t, ss.Bytes == msz)
+	})
+}
+
+func TestFileStoreCompactAsync(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 512
+		fs, err := newFileStore(fcfg, StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage})
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		msg := bytes.Repeat([]byte("Z"), 64)
+		for i := 0; i < 100; i++ {
+			_, _, err := fs.StoreMsg("foo", nil, msg)
+			require_NoError(t, err)
+		}
+		before := fs.State()
+		require_True(t, fs.numMsgBlocks() > 5)
+
+		h := fs.CompactAsync(50)
+		select {
+		case <-h.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Compaction did not finish")
+		}
+		p := h.Progress()
+		require_True(t, p.Done)
+		require_False(t, p.Canceled)
+		require_True(t, p.Error == _EMPTY_)
+		require_True(t, p.Purged == 49)
+		require_True(t, p.BlocksProcessed == p.BlocksTotal)
+		require_True(t, p.BlocksTotal > 1)
+
+		state := fs.State()
+		require_True(t, state.FirstSeq == 50)
+		require_True(t, state.Msgs == 51)
+		require_True(t, p.BytesReclaimed == before.Bytes-state.Bytes)
+
+		// Cancel once done is a no-op.
+		h.Cancel()
+		require_False(t, h.Progress().Canceled)
+
+		// Cancel will stop in between blocks.
+		h = fs.CompactAsync(90)
+		h.Cancel()
+		<-h.Done()
+		p = h.Progress()
+		require_True(t, p.Done)
+		if p.Canceled {
+			require_True(t, fs.State().FirstSeq == 50+p.Purged)
+		} else {
+			require_True(t, fs.State().FirstSeq == 90)
+		}
+	})
+}
+
+func TestFileStoreEvictionHandler(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fs, err := newFileStore(fcfg, StreamConfig{Name: "zzz", Storage: FileStorage, MaxMsgs: 5})
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		var evicted []*EvictedMsg
+		veto := false
+		fs.RegisterEvictionHandler(func(m *EvictedMsg) bool {
+			if veto {
+				return false
+			}
+			evicted = append(evicted, &EvictedMsg{Reason: m.Reason, Subject: m.Subject, Sequence: m.Sequence, Data: copyBytes(m.Data)})
+			return true
+		})
+
+		for i := 0; i < 6; i++ {
+			_, _, err := fs.StoreMsg(fmt.Sprintf("foo.%d", i), nil, []byte("ok"))
+			require_NoError(t, err)
+		}
+		require_True(t, len(evicted) == 1)
+		require_True(t, evicted[0].Reason == EvictMaxMsgs)
+		require_True(t, evicted[0].Sequence == 1)
+		require_True(t, evicted[0].Subject == "foo.0")
+		require_True(t, string(evicted[0].Data) == "ok")
+
+		// Now veto, we should go over our limits.
+		veto = true
+		_, _, err = fs.StoreMsg("bar", nil, []byte("ok"))
+		require_NoError(t, err)
+		require_True(t, len(evicted) == 1)
+		state := fs.State()
+		require_True(t, state.Msgs == 6)
+		require_True(t, state.FirstSeq == 2)
+
+		// Once allowed again we will catch back up.
+		veto = false
+		_, _, err = fs.StoreMsg("bar", nil, []byte("ok"))
+		require_NoError(t, err)
+		require_True(t, len(evicted) == 3)
+		require_True(t, fs.State().Msgs == 5)
+	})
+}
+
+type testTierBackend struct {
+	sync.Mutex
+	objs map[string][]byte
+}
+
+func (tb *testTierBackend) Put(key string, data []byte) error {
+	tb.Lock()
+	defer tb.Unlock()
+	tb.objs[key] = copyBytes(data)
+	return nil
+}
+
+func (tb *testTierBackend) Get(key string) ([]byte, error) {
+	tb.Lock()
+	defer tb.Unlock()
+	data, ok := tb.objs[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return copyBytes(data), nil
+}
+
+func (tb *testTierBackend) Delete(key string) error {
+	tb.Lock()
+	defer tb.Unlock()
+	delete(tb.objs, key)
+	return nil
+}
+
+func (tb *testTierBackend) count() int {
+	tb.Lock()
+	defer tb.Unlock()
+	return len(tb.objs)
+}
+
+func TestFileStoreTiering(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		tb := &testTierBackend{objs: make(map[string][]byte)}
+		fcfg.BlockSize = 256
+		fcfg.Tier = tb
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: FileStorage, TierAge: time.Hour}
+
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		msg := bytes.Repeat([]byte("Z"), 64)
+		for i := 0; i < 20; i++ {
+			_, _, err := fs.StoreMsg(fmt.Sprintf("foo.%d", i), nil, msg)
+			require_NoError(t, err)
+		}
+		nblks := fs.numMsgBlocks()
+		require_True(t, nblks > 2)
+
+		// Nothing is old enough yet.
+		require_True(t, fs.tierBlocks() == 0)
+
+		cfg.TierAge = time.Millisecond
+		require_NoError(t, fs.UpdateConfig(&cfg))
+		time.Sleep(5 * time.Millisecond)
+		require_True(t, fs.tierBlocks() == nblks-1)
+		require_True(t, tb.count() == nblks-1)
+
+		mdir := filepath.Join(fcfg.StoreDir, msgDir)
+		blks, _ := filepath.Glob(filepath.Join(mdir, "*.blk"))
+		require_True(t, len(blks) == 1)
+		colds, _ := filepath.Glob(filepath.Join(mdir, "*.cold"))
+		require_True(t, len(colds) == nblks-1)
+
+		// Messages are loaded back transparently.
+		sm, err := fs.LoadMsg(1, nil)
+		require_NoError(t, err)
+		require_True(t, sm.subj == "foo.0")
+		require_True(t, bytes.Equal(sm.msg, msg))
+
+		// As well as removals.
+		removed, err := fs.RemoveMsg(2)
+		require_NoError(t, err)
+		require_True(t, removed)
+		state := fs.State()
+		require_True(t, state.Msgs == 19)
+
+		// Make sure we recover.
+		fs.Stop()
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		if !reflect.DeepEqual(state, fs.State()) {
+			t.Fatalf("State mismatch:\n%+v\n%+v", state, fs.State())
+		}
+		sm, err = fs.LoadMsg(3, nil)
+		require_NoError(t, err)
+		require_True(t, sm.subj == "foo.2")
+
+		// Without a backend we can not.
+		fs.Stop()
+		nfcfg := fcfg
+		nfcfg.Tier = nil
+		_, err = newFileStore(nfcfg, cfg)
+		require_Error(t, err, errNoTierBackend)
+
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		// Purging removes the cold blocks.
+		_, err = fs.Purge()
+		require_NoError(t, err)
+		checkFor(t, time.Second, 10*time.Millisecond, func() error {
+			if n := tb.count(); n > 0 {
+				return fmt.Errorf("Still have %d cold blocks", n)
+			}
+			return nil
+		})
+	})
+}
+
+func TestFileStoreRemoveRange(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: FileStorage}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		var removedMsgs int64
+		fs.RegisterStorageUpdates(func(md, bd int64, seq uint64, subj string) {
+			if md < 0 {
+				removedMsgs -= md
+			}
+		})
+
+		msg := bytes.Repeat([]byte("Z"), 64)
+		for i := 0; i < 100; i++ {
+			_, _, err := fs.StoreMsg(fmt.Sprintf("foo.%d", i%4), nil, msg)
+			require_NoError(t, err)
+		}
+		require_True(t, fs.numMsgBlocks() > 10)
+		_, err = fs.RemoveMsg(30)
+		require_NoError(t, err)
+		removedMsgs = 0
+
+		_, err = fs.RemoveRange(0, 10)
+		require_Error(t, err, ErrInvalidSequenceRange)
+
+		// Interior range spanning blocks, including a message that was already removed.
+		removed, err := fs.RemoveRange(21, 40)
+		require_NoError(t, err)
+		require_True(t, removed == 19)
+		require_True(t, removedMsgs == 19)
+		var state StreamState
+		fs.FastState(&state)
+		require_True(t, state.Msgs == 80)
+		require_True(t, state.FirstSeq == 1)
+		require_True(t, state.NumDeleted == 20)
+		require_True(t, fs.SubjectsState("foo.0")["foo.0"].Msgs == 20)
+		for seq := uint64(21); seq <= 40; seq++ {
+			_, err := fs.LoadMsg(seq, nil)
+			require_Error(t, err)
+		}
+		_, err = fs.LoadMsg(41, nil)
+		require_NoError(t, err)
+
+		// Range at the front moves our first sequence and drops emptied blocks.
+		nblks := fs.numMsgBlocks()
+		removed, err = fs.RemoveRange(1, 50)
+		require_NoError(t, err)
+		require_True(t, removed == 30)
+		require_True(t, fs.numMsgBlocks() < nblks)
+		fs.FastState(&state)
+		require_True(t, state.Msgs == 50)
+		require_True(t, state.FirstSeq == 51)
+		require_True(t, state.NumDeleted == 0)
+		expected := fs.State()
+		subjects := fs.SubjectsState("foo.*")
+
+		// Make sure we recover the same state.
+		fs.Stop()
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		state = fs.State()
+		require_True(t, state.Msgs == expected.Msgs)
+		require_True(t, state.Bytes == expected.Bytes)
+		require_True(t, state.FirstSeq == expected.FirstSeq)
+		require_True(t, state.LastSeq == expected.LastSeq)
+		require_True(t, reflect.DeepEqual(fs.SubjectsState("foo.*"), subjects))
+
+		// Past our last is fine and removes everything left.
+		removed, err = fs.RemoveRange(51, 1000)
+		require_NoError(t, err)
+		require_True(t, removed == 50)
+		fs.FastState(&state)
+		require_True(t, state.Msgs == 0)
+		require_True(t, state.Bytes == 0)
+		require_True(t, state.FirstSeq == 101)
+
+		// We can keep storing after.
+		seq, _, err := fs.StoreMsg("foo.1", nil, msg)
+		require_NoError(t, err)
+		require_True(t, seq == 101)
+	})
+}
+
+func TestFileStoreRemoveRangeKeepsSingleDeleteRecord(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = maxBlockSize
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: FileStorage}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		for i := 0; i < 100_000; i++ {
+			_, _, err := fs.StoreMsg(fmt.Sprintf("foo.%d", i%4), nil, []byte("ok"))
+			require_NoError(t, err)
+		}
+		require_True(t, fs.numMsgBlocks() == 1)
+
+		// A single interior delete inside the range should be folded into it.
+		_, err = fs.RemoveMsg(500)
+		require_NoError(t, err)
+		removed, err := fs.RemoveRange(2, 99_999)
+		require_NoError(t, err)
+		require_True(t, removed == 99_997)
+
+		mb := fs.getFirstBlock()
+		mb.mu.RLock()
+		require_True(t, mb.dmap.size() == 99_998)
+		require_True(t, len(mb.dmap.seqs) == 0)
+		require_True(t, len(mb.dmap.runs) == 1)
+		mb.mu.RUnlock()
+
+		var state StreamState
+		fs.FastState(&state)
+		require_True(t, state.Msgs == 2)
+		require_True(t, state.NumDeleted == 99_998)
+		require_True(t, fs.SubjectsState("foo.*")["foo.3"].Msgs == 1)
+
+		// The index should hold the range as a single record and recover it.
+		fs.Stop()
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		mb = fs.getFirstBlock()
+		mb.mu.RLock()
+		require_True(t, mb.dmap.size() == 99_998)
+		require_True(t, len(mb.dmap.runs) == 1)
+		mb.mu.RUnlock()
+		state = fs.State()
+		require_True(t, state.Msgs == 2)
+		require_True(t, state.FirstSeq == 1)
+		require_True(t, state.LastSeq == 100_000)
+		require_True(t, reflect.DeepEqual(state.DeletedRanges, DeleteRanges{{2, 99_998}}))
+		_, err = fs.LoadMsg(50_000, nil)
+		require_Error(t, err)
+
+		// Removing our first moves past the whole range and drops it.
+		_, err = fs.RemoveMsg(1)
+		require_NoError(t, err)
+		mb = fs.getFirstBlock()
+		mb.mu.RLock()
+		require_True(t, mb.dmap.isEmpty())
+		mb.mu.RUnlock()
+		state = StreamState{}
+		fs.FastState(&state)
+		require_True(t, state.Msgs == 1)
+		require_True(t, state.FirstSeq == 100_000)
+		require_True(t, state.NumDeleted == 0)
+	})
+}
+
+func TestFileStoreStoreMsgIfLastSubjSeq(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		fs, err := newFileStore(fcfg, StreamConfig{Name: "zzz", Subjects: []string{"kv.>"}, Storage: FileStorage})
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		seq, _, err := fs.StoreMsgIfLastSubjSeq("kv.a", nil, []byte("1"), 0)
+		require_NoError(t, err)
+		require_True(t, seq == 1)
+		_, _, err = fs.StoreMsgIfLastSubjSeq("kv.a", nil, []byte("2"), 0)
+		require_Error(t, err, ErrLastSubjSeqMismatch)
+
+		// Spread other subjects over a few blocks.
+		for i := 0; i < 20; i++ {
+			_, _, err = fs.StoreMsg("kv.b", nil, []byte("ok"))
+			require_NoError(t, err)
+		}
+		require_True(t, fs.numMsgBlocks() > 1)
+
+		seq, _, err = fs.StoreMsgIfLastSubjSeq("kv.a", nil, []byte("2"), 1)
+		require_NoError(t, err)
+		require_True(t, seq == 22)
+
+		// Removing the last message for a subject moves it back.
+		_, err = fs.RemoveMsg(22)
+		require_NoError(t, err)
+		_, _, err = fs.StoreMsgIfLastSubjSeq("kv.a", nil, []byte("3"), 22)
+		require_Error(t, err, ErrLastSubjSeqMismatch)
+		seq, _, err = fs.StoreMsgIfLastSubjSeq("kv.a", nil, []byte("3"), 1)
+		require_NoError(t, err)
+		require_True(t, seq == 23)
+
+		state := fs.State()
+		require_True(t, state.Msgs == 22)
+		require_True(t, state.LastSeq == 23)
+	})
+}
+
+func TestFileStoreDeleteRanges(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		fs, err := newFileStore(fcfg, StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage})
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		for i := 0; i < 100; i++ {
+			_, _, err = fs.StoreMsg("foo", nil, []byte("ok"))
+			require_NoError(t, err)
+		}
+		require_True(t, fs.State().DeletedRanges == nil)
+
+		for _, seq := range []uint64{10, 20, 21, 22, 23, 50} {
+			_, err = fs.RemoveMsg(seq)
+			require_NoError(t, err)
+		}
+		// Empty a whole block towards the end.
+		fs.mu.RLock()
+		mb := fs.blks[len(fs.blks)-2]
+		fs.mu.RUnlock()
+		mb.mu.RLock()
+		fseq, lseq := mb.first.seq, mb.last.seq
+		mb.mu.RUnlock()
+		require_True(t, fseq > 51)
+		for seq := fseq; seq <= lseq; seq++ {
+			_, err = fs.RemoveMsg(seq)
+			require_NoError(t, err)
+		}
+
+		state := fs.State()
+		expected := DeleteRanges{{10, 1}, {20, 4}, {50, 1}, {fseq, lseq - fseq + 1}}
+		if !reflect.DeepEqual(state.DeletedRanges, expected) {
+			t.Fatalf("Expected deleted ranges to be %+v, got %+v", expected, state.DeletedRanges)
+		}
+		require_True(t, state.NumDeleted == int(6+lseq-fseq+1))
+		require_True(t, len(state.DeletedSeqs()) == state.NumDeleted)
+
+		expected = DeleteRanges{{21, 3}}
+		if drs := fs.DeleteRanges(21, 49); !reflect.DeepEqual(drs, expected) {
+			t.Fatalf("Expected deleted ranges to be %+v, got %+v", expected, drs)
+		}
+		expected = DeleteRanges{{fseq + 1, 1}}
+		if drs := fs.DeleteRanges(fseq+1, fseq+1); !reflect.DeepEqual(drs, expected) {
+			t.Fatalf("Expected deleted ranges to be %+v, got %+v", expected, drs)
+		}
+		require_True(t, fs.DeleteRanges(lseq+1, 100) == nil)
+	})
+}
+
+func TestFileStoreScrubReclaimed(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage, ScrubInterval: time.Hour}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		sdir := filepath.Join(fcfg.StoreDir, scrubDir)
+		numScrub := func() int {
+			t.Helper()
+			fis, err := os.ReadDir(sdir)
+			require_NoError(t, err)
+			return len(fis)
+		}
+
+		for i := 0; i < 20; i++ {
+			_, _, err = fs.StoreMsg("foo", nil, []byte("top secret"))
+			require_NoError(t, err)
+		}
+		require_True(t, fs.numMsgBlocks() > 2)
+		require_True(t, numScrub() == 0)
+
+		// Dropping whole blocks keeps them around to be scrubbed.
+		_, err = fs.Compact(10)
+		require_NoError(t, err)
+		require_True(t, numScrub() > 0)
+
+		fs.scrubReclaimed()
+		require_True(t, numScrub() == 0)
+
+		// Same for purged messages.
+		_, err = fs.Purge()
+		require_NoError(t, err)
+		require_True(t, numScrub() == 1)
+		fs.scrubReclaimed()
+		require_True(t, numScrub() == 0)
+
+		// Without scrubbing purged messages are just removed.
+		cfg.ScrubInterval = 0
+		require_NoError(t, fs.UpdateConfig(&cfg))
+		_, _, err = fs.StoreMsg("foo", nil, []byte("top secret"))
+		require_NoError(t, err)
+		_, err = fs.Purge()
+		require_NoError(t, err)
+		require_True(t, numScrub() == 0)
+	})
+}
+
+func TestFileStoreScrubFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "blk")
+	data := bytes.Repeat([]byte("top secret"), 10_000)
+	require_NoError(t, os.WriteFile(fn, data, defaultFilePerms))
+
+	fd, err := os.OpenFile(fn, os.O_RDWR, defaultFilePerms)
+	require_NoError(t, err)
+	require_NoError(t, zeroFileRange(fd, 10, 20))
+	fd.Close()
+	buf, err := os.ReadFile(fn)
+	require_NoError(t, err)
+	require_True(t, bytes.Equal(buf[:10], data[:10]))
+	require_True(t, bytes.Equal(buf[10:20], make([]byte, 10)))
+	require_True(t, bytes.Equal(buf[20:], data[20:]))
+
+	require_NoError(t, scrubFile(fn))
+	buf, err = os.ReadFile(fn)
+	require_NoError(t, err)
+	require_True(t, len(buf) == len(data))
+	require_True(t, bytes.Equal(buf, make([]byte, len(data))))
+}
+
+func TestFileStoreRemapSubjects(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"orders.>"}, Storage: FileStorage}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		for i := 0; i < 30; i++ {
+			var hdr []byte
+			if i%2 == 0 {
+				hdr = []byte("hdr")
+			}
+			_, _, err := fs.StoreMsg(fmt.Sprintf("orders.old.%d", i%3), hdr, []byte("ok"))
+			require_NoError(t, err)
+		}
+		_, err = fs.RemoveMsg(2)
+		require_NoError(t, err)
+		before := fs.State()
+		require_True(t, fs.numMsgBlocks() > 1)
+
+		// Only remap up to and including sequence 25.
+		n, err := fs.RemapSubjects("orders.old.*", "orders.v2.$1", 25)
+		require_NoError(t, err)
+		require_True(t, n == 24)
+
+		checkState := func() {
+			t.Helper()
+			state := fs.State()
+			require_True(t, state.Msgs == before.Msgs)
+			require_True(t, state.FirstSeq == before.FirstSeq)
+			require_True(t, state.LastSeq == before.LastSeq)
+			require_True(t, state.NumDeleted == 1)
+			// New subjects are one byte shorter.
+			require_True(t, state.Bytes == before.Bytes-24)
+			require_True(t, fs.FilteredState(1, "orders.v2.>").Msgs == 24)
+			require_True(t, fs.FilteredState(1, "orders.old.>").Msgs == 5)
+			require_True(t, fs.SubjectsState("orders.v2.*")["orders.v2.0"].Msgs == 9)
+			require_True(t, fs.SubjectsState("orders.old.*")["orders.old.0"].Msgs == 1)
+
+			sm, err := fs.LoadMsg(5, nil)
+			require_NoError(t, err)
+			require_Equal(t, sm.subj, "orders.v2.1")
+			require_Equal(t, string(sm.hdr), "hdr")
+			require_Equal(t, string(sm.msg), "ok")
+			sm, err = fs.LoadLastMsg("orders.v2.2", nil)
+			require_NoError(t, err)
+			require_True(t, sm.seq == 24)
+			sm, err = fs.LoadMsg(26, nil)
+			require_NoError(t, err)
+			require_Equal(t, sm.subj, "orders.old.1")
+			_, err = fs.LoadMsg(2, nil)
+			require_Error(t, err)
+		}
+		checkState()
+
+		// Make sure this survives a restart.
+		fs.Stop()
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		checkState()
+	})
+}
+
+func TestFileStoreScheduledMsgs(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		fcfg.BlockSize = 256
+		cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		delay := []byte("NATS/1.0\r\nNats-Delay: 1h\r\n\r\n")
+		for i := 1; i <= 20; i++ {
+			var hdr []byte
+			if i%5 == 0 {
+				hdr = delay
+			}
+			_, _, err := fs.StoreMsg("foo", hdr, []byte("ok"))
+			require_NoError(t, err)
+		}
+		require_True(t, fs.numMsgBlocks() > 1)
+
+		_, err = fs.RemoveMsg(10)
+		require_NoError(t, err)
+		require_True(t, fs.UnscheduleMsg(15))
+
+		checkSchedule := func() {
+			t.Helper()
+			sched := fs.ScheduledMsgs()
+			require_True(t, len(sched) == 2)
+			require_True(t, fs.IsScheduled(5))
+			require_True(t, fs.IsScheduled(20))
+			sm, err := fs.LoadMsg(20, nil)
+			require_NoError(t, err)
+			require_True(t, sched[20] == sm.ts+int64(time.Hour))
+		}
+		checkSchedule()
+
+		// Make sure this survives a restart.
+		fs.Stop()
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		checkSchedule()
+
+		// Messages stored after the index was written are picked up on recovery.
+		_, _, err = fs.StoreMsg("foo", delay, []byte("ok"))
+		require_NoError(t, err)
+		fs.mu.Lock()
+		fs.schedDirty, fs.schedLseq = false, fs.state.LastSeq
+		fs.mu.Unlock()
+		fs.Stop()
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		require_True(t, fs.IsScheduled(21))
+
+		// Without an index all messages are scanned. Message 15 was released
+		// so will be scheduled again, it would be delivered when due.
+		fs.Stop()
+		require_NoError(t, os.Remove(filepath.Join(fcfg.StoreDir, schedFile)))
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		require_True(t, len(fs.ScheduledMsgs()) == 4)
+
+		// Truncating drops anything after.
+		require_NoError(t, fs.Truncate(12))
+		require_True(t, len(fs.ScheduledMsgs()) == 1)
+		fs.Stop()
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		require_True(t, len(fs.ScheduledMsgs()) == 1)
+		require_True(t, fs.IsScheduled(5))
+	})
+}
+
+func TestFileStoreSubjectRetention(t *testing.T) {
+	testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+		cfg := StreamConfig{
+			Name: "zzz", Subjects: []string{"audit.*", "metrics.*", "logs.*"}, Storage: FileStorage,
+			MaxAge: 500 * time.Millisecond,
+			SubjectRetention: []*SubjectRetention{
+				{Subject: "audit.*", MaxAge: 0},
+				{Subject: "metrics.*", MaxAge: 100 * time.Millisecond},
+			},
+		}
+		fs, err := newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+
+		for _, subj := range []string{"audit.a", "metrics.a", "logs.a", "metrics.b", "audit.b"} {
+			_, _, err = fs.StoreMsg(subj, nil, []byte("ok"))
+			require_NoError(t, err)
+		}
+
+		// Metrics go first.
+		checkFor(t, time.Second, 20*time.Millisecond, func() error {
+			if state := fs.State(); state.Msgs != 3 {
+				return fmt.Errorf("Expected 3 msgs, got %d", state.Msgs)
+			}
+			return nil
+		})
+		require_True(t, len(fs.SubjectsState("metrics.*")) == 0)
+		require_True(t, len(fs.SubjectsState("logs.*")) == 1)
+
+		// Then everything not covered by an override, audit stays.
+		checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+			if state := fs.State(); state.Msgs != 2 {
+				return fmt.Errorf("Expected 2 msgs, got %d", state.Msgs)
+			}
+			return nil
+		})
+		ss := fs.SubjectsState(">")
+		require_True(t, len(ss) == 2)
+		require_True(t, ss["audit.a"].Msgs == 1 && ss["audit.b"].Msgs == 1)
+
+		// Stays after a restart as well.
+		fs.Stop()
+		fs, err = newFileStore(fcfg, cfg)
+		require_NoError(t, err)
+		defer fs.Stop()
+		require_True(t, fs.State().Msgs == 2)
+	})
+}
+
+func TestFileStoreRecompressBlocks(t *testing.T) {
+	orate := recompressRate
+	recompressRate = 1024 * 1024 * 1024
+	defer func() { recompressRate = orate }()
+
+	prf := func(context []byte) ([]byte, error) {
+		h := hmac.New(sha256.New, []byte("dlc22"))
+		if _, err := h.Write(context); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+
+	for _, test := range []struct {
+		name string
+		prf  keyGen
+	}{
+		{"Plain", nil},
+		{"Encrypted", prf},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testFileStoreAllPermutations(t, func(t *testing.T, fcfg FileStoreConfig) {
+				fcfg.BlockSize = 4096
+				cfg := StreamConfig{Name: "zzz", Subjects: []string{"foo"}, Storage: FileStorage}
+				fs, err := newFileStoreWithCreated(fcfg, cfg, time.Now(), test.prf)
+				require_NoError(t, err)
+				defer fs.Stop()
+
+				msg := bytes.Repeat([]byte("Z"), 256)
+				for i := 0; i < 100; i++ {
+					_, _, err = fs.StoreMsg("foo", nil, msg)
+					require_NoError(t, err)
+				}
+				require_True(t, fs.numMsgBlocks() > 2)
+				require_True(t, fs.Recompression() == nil)
+
+				waitDone := func(alg StoreCompression) *RecompressProgress {
+					t.Helper()
+					var p *RecompressProgress
+					checkFor(t, 5*time.Second, 10*time.Millisecond, func() error {
+						if p = fs.Recompression(); p == nil || p.Compression != alg || !p.Done {
+							return fmt.Errorf("recompression not done: %+v", p)
+						}
+						return nil
+					})
+					require_True(t, p.Error == _EMPTY_)
+					return p
+				}
+				checkMsgs := func() {
+					t.Helper()
+					var state StreamState
+					fs.FastState(&state)
+					require_True(t, state.Msgs == 100)
+					for seq := uint64(1); seq <= 100; seq++ {
+						sm, err := fs.LoadMsg(seq, nil)
+						require_NoError(t, err)
+						require_True(t, bytes.Equal(sm.msg, msg))
+					}
+				}
+
+				cfg.Compression = S2Compression
+				require_NoError(t, fs.UpdateConfig(&cfg))
+				p := waitDone(S2Compression)
+				require_True(t, p.BlocksProcessed == p.BlocksTotal)
+				require_True(t, p.BlocksProcessed == fs.numMsgBlocks()-1)
+				require_True(t, p.BytesAfter < p.BytesBefore/2)
+				// Drop our caches so we read the compressed blocks back.
+				fs.mu.RLock()
+				for _, mb := range fs.blks {
+					mb.mu.Lock()
+					mb.clearCacheAndOffset()
+					mb.mu.Unlock()
+				}
+				fs.mu.RUnlock()
+				checkMsgs()
+
+				// Removing from a compressed block works and survives a restart.
+				_, err = fs.RemoveMsg(2)
+				require_NoError(t, err)
+				_, _, err = fs.StoreMsg("foo", nil, msg)
+				require_NoError(t, err)
+				fs.Stop()
+
+				fs, err = newFileStoreWithCreated(fcfg, cfg, time.Now(), test.prf)
+				require_NoError(t, err)
+				defer fs.Stop()
+				_, err = fs.LoadMsg(2, nil)
+				require_Error(t, err, errDeletedMsg)
+				sm, err := fs.LoadMsg(101, nil)
+				require_NoError(t, err)
+				require_True(t, bytes.Equal(sm.msg, msg))
+
+				// Turning compression off again rewrites the blocks uncompressed.
+				cfg.Compression = NoCompression
+				require_NoError(t, fs.UpdateConfig(&cfg))
+				p = waitDone(NoCompression)
+				require_True(t, p.BytesAfter > p.BytesBefore)
+				fs.mu.RLock()
+				for _, mb := range fs.blks {
+					mb.mu.RLock()
+					require_True(t, mb.cmp == NoCompression)
+					mb.mu.RUnlock()
+				}
+				fs.mu.RUnlock()
+			})
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+// TierBackend is an object store, e.g. S3 or GCS, that message blocks can be moved
+// to once they are older than a stream's TierAge. Blocks are stored as they are on
+// disk, so they remain encrypted if the stream is. Implementations must be safe for
+// concurrent use.
+type TierBackend interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+const (
+	// Marker for a message block that has been moved to the tier backend.
+	coldScan = "%d.cold"
+	// Bounds on how often we look for blocks to move.
+	minTierCheckInterval = time.Second
+	maxTierCheckInterval = time.Minute
+)
+
+var errNoTierBackend = errors.New("message block is in cold storage but no tier backend is configured")
+
+// Stored in the cold marker file so we can recover a block without its data.
+type coldBlockInfo struct {
+	Key   string `json:"key"`
+	Size  uint64 `json:"size"`
+	LChk  []byte `json:"lchk"`
+	Moved int64  `json:"moved"`
+}
+
+// Returns how often to check for blocks to move for the given age.
+func tierCheckInterval(age time.Duration) time.Duration {
+	if age <= 0 || age/4 > maxTierCheckInterval {
+		return maxTierCheckInterval
+	}
+	if age/4 < minTierCheckInterval {
+		return minTierCheckInterval
+	}
+	return age / 4
+}
+
+// Lock should be held.
+func (fs *fileStore) startTierTimer() {
+	if fs.fcfg.Tier == nil || fs.tierTmr != nil {
+		return
+	}
+	fs.tierTmr = time.AfterFunc(tierCheckInterval(fs.cfg.TierAge), fs.tierBlocksTimer)
+}
+
+// Lock should be held.
+func (fs *fileStore) cancelTierTimer() {
+	if fs.tierTmr != nil {
+		fs.tierTmr.Stop()
+		fs.tierTmr = nil
+	}
+}
+
+func (fs *fileStore) tierBlocksTimer() {
+	fs.tierBlocks()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed || fs.tierTmr == nil {
+		return
+	}
+	fs.tierTmr.Reset(tierCheckInterval(fs.cfg.TierAge))
+}
+
+// Moves any message blocks whose last message is older than our tier age to
+// the tier backend. Returns the number of blocks moved.
+func (fs *fileStore) tierBlocks() int {
+	fs.mu.RLock()
+	tier, age, closed := fs.fcfg.Tier, fs.cfg.TierAge, fs.closed
+	var blks []*msgBlock
+	if tier != nil && age > 0 && !closed && fs.sips == 0 {
+		cutoff := time.Now().Add(-age).UnixNano()
+		for _, mb := range fs.blks {
+			// We never move the block we are writing to.
+			if mb == fs.lmb {
+				continue
+			}
+			mb.mu.RLock()
+			move := mb.ckey == _EMPTY_ && mb.msgs > 0 && mb.last.ts <= cutoff
+			mb.mu.RUnlock()
+			if move {
+				blks = append(blks, mb)
+			}
+		}
+	}
+	fs.mu.RUnlock()
+
+	var moved int
+	for _, mb := range blks {
+		if err := mb.moveToTier(tier); err == nil {
+			moved++
+		}
+	}
+	return moved
+}
+
+// Moves this block's data to the tier backend, leaving the index and a cold marker behind.
+// Lock should not be held.
+func (mb *msgBlock) moveToTier(tier TierBackend) error {
+	if mb.pendingWriteSize() > 0 {
+		mb.flushPendingMsgs()
+	}
+
+	mb.mu.Lock()
+	if mb.ckey != _EMPTY_ || mb.mfn == _EMPTY_ || mb.closed {
+		mb.mu.Unlock()
+		return nil
+	}
+	// Always move the block uncompressed, it will be stored by the backend as it sees fit.
+	buf, err := mb.loadBlock(nil)
+	if err != nil {
+		mb.mu.Unlock()
+		return err
+	}
+	lchk, rbytes := mb.lchk, mb.rbytes
+	key := fmt.Sprintf("%s/%s.blk", mb.fs.cfg.Name, nuid.Next())
+	mb.mu.Unlock()
+
+	// Do not hold the lock while we upload.
+	if err := tier.Put(key, buf); err != nil {
+		return err
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	// If we changed underneath of the upload throw it away.
+	if mb.ckey != _EMPTY_ || mb.mfn == _EMPTY_ || mb.closed || mb.lchk != lchk || mb.rbytes != rbytes {
+		go tier.Delete(key)
+		return nil
+	}
+	// Make sure our index matches before we let go of the data.
+	if err := mb.writeIndexInfoLocked(); err != nil {
+		go tier.Delete(key)
+		return err
+	}
+	ci := &coldBlockInfo{Key: key, Size: rbytes, LChk: lchk[:], Moved: time.Now().UnixNano()}
+	if err := mb.writeColdInfo(ci); err != nil {
+		go tier.Delete(key)
+		return err
+	}
+	mb.closeFDsLockedNoCheck()
+	os.Remove(mb.mfn)
+	mb.clearCacheAndOffset()
+	mb.ckey, mb.cmp = key, NoCompression
+	return nil
+}
+
+// Returns the name of our cold marker file.
+func (mb *msgBlock) coldFileName() string {
+	return filepath.Join(mb.fs.fcfg.StoreDir, msgDir, fmt.Sprintf(coldScan, mb.index))
+}
+
+// Lock should be held.
+func (mb *msgBlock) writeColdInfo(ci *coldBlockInfo) error {
+	b, err := json.Marshal(ci)
+	if err != nil {
+		return err
+	}
+	cfn := mb.coldFileName()
+	tmp := cfn + ".tmp"
+	if err := os.WriteFile(tmp, b, defaultFilePerms); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cfn)
+}
+
+func (mb *msgBlock) readColdInfo() (*coldBlockInfo, error) {
+	b, err := os.ReadFile(mb.coldFileName())
+	if err != nil {
+		return nil, err
+	}
+	var ci coldBlockInfo
+	if err := json.Unmarshal(b, &ci); err != nil {
+		return nil, err
+	}
+	if ci.Key == _EMPTY_ {
+		return nil, errors.New("cold marker missing key")
+	}
+	return &ci, nil
+}
+
+// Loads our raw block data from the tier backend.
+// Lock should be held.
+func (mb *msgBlock) loadColdBlock(buf []byte) ([]byte, error) {
+	tier := mb.fs.fcfg.Tier
+	if tier == nil {
+		return nil, errNoTierBackend
+	}
+	data, err := tier.Get(mb.ckey)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != mb.rbytes {
+		return nil, fmt.Errorf("cold message block [%d] size mismatch", mb.index)
+	}
+	if buf != nil && cap(buf) >= len(data) {
+		return append(buf[:0], data...), nil
+	}
+	return data, nil
+}
+
+// Brings our data back from the tier backend so we can write to it again.
+// Lock should be held.
+func (mb *msgBlock) thawLocked() error {
+	if mb.ckey == _EMPTY_ {
+		return nil
+	}
+	buf, err := mb.loadColdBlock(nil)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(mb.mfn, buf, defaultFilePerms); err != nil {
+		return err
+	}
+	os.Remove(mb.coldFileName())
+	key := mb.ckey
+	mb.ckey = _EMPTY_
+	go mb.fs.fcfg.Tier.Delete(key)
+	return nil
+}
+
+// Removes our cold marker and the data from the tier backend.
+// Lock should be held.
+func (mb *msgBlock) removeColdLocked() {
+	if mb.ckey == _EMPTY_ {
+		return
+	}
+	os.Remove(mb.coldFileName())
+	if tier := mb.fs.fcfg.Tier; tier != nil {
+		go tier.Delete(mb.ckey)
+	}
+	mb.ckey = _EMPTY_
+}
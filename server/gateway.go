@@ -128,6 +128,7 @@ func GatewayDoNotForceInterestOnlyMode(doNotForce bool) {
 
 type srvGateway struct {
 	totalQSubs int64 //total number of queue subs in all remote gateways (used with atomic operations)
+	sqExceeded int64 // number of subscriptions rejected for the per connection subject quota (used with atomic operations)
 	sync.RWMutex
 	enabled  bool                   // Immutable, true if both a name and port are configured
 	name     string                 // Name of the Gateway on this server
@@ -168,6 +169,7 @@ type srvGateway struct {
 	resolver  netResolver   // Used to resolve host name before calling net.Dial()
 	sqbsz     int           // Max buffer size to send queue subs protocol. Used for testing.
 	recSubExp time.Duration // For how long do we check if there is a subscription match for a message with reply
+	maxcsubs  int           // Max number of subjects a single connection can propagate to remote gateways
 
 	// These are used for routing of mapped replies.
 	sIDHash        []byte   // Server ID hash (6 bytes)
@@ -285,8 +287,9 @@ func (r *RemoteGatewayOpts) clone() *RemoteGatewayOpts {
 		return nil
 	}
 	clone := &RemoteGatewayOpts{
-		Name: r.Name,
-		URLs: deepCopyURLs(r.URLs),
+		Name:           r.Name,
+		URLs:           deepCopyURLs(r.URLs),
+		TLSPinnedCerts: r.TLSPinnedCerts,
 	}
 	if r.TLSConfig != nil {
 		clone.TLSConfig = r.TLSConfig.Clone()
@@ -317,6 +320,11 @@ func validateGatewayOptions(o *Options) error {
 	if err := validatePinnedCerts(o.Gateway.TLSPinnedCerts); err != nil {
 		return fmt.Errorf("gateway %q: %v", o.Gateway.Name, err)
 	}
+	for _, g := range o.Gateway.Gateways {
+		if err := validatePinnedCerts(g.TLSPinnedCerts); err != nil {
+			return fmt.Errorf("gateway %q remote %q: %v", o.Gateway.Name, g.Name, err)
+		}
+	}
 	return nil
 }
 
@@ -406,6 +414,7 @@ func (s *Server) newGateway(opts *Options) error {
 		gateway.sqbsz = maxBufSize
 	}
 	gateway.recSubExp = defaultGatewayRecentSubExpiration
+	gateway.maxcsubs = opts.Gateway.MaxConnSubjects
 
 	gateway.enabled = opts.Gateway.Name != "" && opts.Gateway.Port != 0
 	s.gateway = gateway
@@ -762,6 +771,7 @@ func (s *Server) createGateway(cfg *gatewayCfg, url *url.URL, conn net.Conn) {
 	opts := s.getOpts()
 
 	now := time.Now().UTC()
+	conn = s.injectLatency(conn, GATEWAY)
 	c := &client{srv: s, nc: conn, start: now, last: now, kind: GATEWAY}
 
 	// Are we creating the gateway based on the configuration
@@ -806,12 +816,16 @@ func (s *Server) createGateway(cfg *gatewayCfg, url *url.URL, conn net.Conn) {
 		var tlsConfig *tls.Config
 		var tlsName string
 		var timeout float64
+		pinned := opts.Gateway.TLSPinnedCerts
 
 		if solicit {
 			cfg.RLock()
 			tlsName = cfg.tlsName
 			tlsConfig = cfg.TLSConfig.Clone()
 			timeout = cfg.TLSTimeout
+			if cfg.TLSPinnedCerts != nil {
+				pinned = cfg.TLSPinnedCerts
+			}
 			cfg.RUnlock()
 		} else {
 			tlsConfig = opts.Gateway.TLSConfig
@@ -819,7 +833,7 @@ func (s *Server) createGateway(cfg *gatewayCfg, url *url.URL, conn net.Conn) {
 		}
 
 		// Perform (either server or client side) TLS handshake.
-		if resetTLSName, err := c.doTLSHandshake("gateway", solicit, url, tlsConfig, tlsName, timeout, opts.Gateway.TLSPinnedCerts); err != nil {
+		if resetTLSName, err := c.doTLSHandshake("gateway", solicit, url, tlsConfig, tlsName, timeout, pinned); err != nil {
 			if resetTLSName {
 				cfg.Lock()
 				cfg.tlsName = _EMPTY_
@@ -938,6 +952,20 @@ func (c *client) processGatewayConnect(arg []byte) error {
 		return ErrWrongGateway
 	}
 
+	// The TLS handshake could only check the gateway's pinned certs, now that
+	// we know who is connecting, the remote's own pinned certs need to match too.
+	if cfg := s.getRemoteGateway(connect.Gateway); cfg != nil {
+		cfg.RLock()
+		pinned := cfg.TLSPinnedCerts
+		cfg.RUnlock()
+		if !c.matchesPinnedCert(pinned) {
+			c.Errorf("Rejecting connection from gateway %q: %v", connect.Gateway, ErrCertNotPinned)
+			c.sendErr(fmt.Sprintf("Connection to gateway %q rejected", s.getGatewayName()))
+			c.closeConnection(TLSHandshakeError)
+			return ErrCertNotPinned
+		}
+	}
+
 	c.mu.Lock()
 	c.gw.connected = true
 	// Set the Ping timer after sending connect and info.
@@ -2287,11 +2315,12 @@ func (s *Server) sendQueueSubOrUnsubToGateways(accName string, qsub *subscriptio
 // This is invoked when a subscription (plain or queue) is
 // added/removed locally or in our cluster. We use ref counting
 // to know when to update the inbound gateways.
+// Returns true if this subscription is the first interest on its subject.
 // <Invoked from client or route connection's readLoop or when such
 // connection is closed>
-func (s *Server) gatewayUpdateSubInterest(accName string, sub *subscription, change int32) {
+func (s *Server) gatewayUpdateSubInterest(accName string, sub *subscription, change int32) bool {
 	if sub.si {
-		return
+		return false
 	}
 
 	var (
@@ -2311,7 +2340,7 @@ func (s *Server) gatewayUpdateSubInterest(accName string, sub *subscription, cha
 	if st == nil {
 		// Ignore remove of something we don't have
 		if change < 0 {
-			return
+			return false
 		}
 		st = make(map[string]*sitally)
 		accMap[accName] = st
@@ -2331,7 +2360,7 @@ func (s *Server) gatewayUpdateSubInterest(accName string, sub *subscription, cha
 	if entry == nil {
 		// Ignore remove of something we don't have
 		if change < 0 {
-			return
+			return false
 		}
 		entry = &sitally{n: 1, q: sub.queue != nil}
 		st[string(key)] = entry
@@ -2377,6 +2406,7 @@ func (s *Server) gatewayUpdateSubInterest(accName string, sub *subscription, cha
 			s.maybeSendSubOrUnsubToGateways(accName, sub, first)
 		}
 	}
+	return first
 }
 
 // Returns true if the given subject is a GW routed reply subject,
@@ -0,0 +1,115 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// With a gateway max_connection_subjects a client connection can only be the
+// first to show interest in that many subjects (or queues), since each of those
+// is propagated to every remote gateway that is not in interest-only mode.
+// Subscriptions on subjects that already have interest do not count, so are
+// never rejected. Once at the quota, a new subscription is rejected with an
+// -ERR, but the connection is kept, and the first rejection is advertised on
+// the account's $SYS.ACCOUNT.<account>.GATEWAY.SUBJECT_QUOTA subject.
+
+// Returns true if this connection is at its gateway subject quota and the
+// subscription would add a new subject to our gateway interest.
+// Client lock must not be held since we need the gateway interest lock.
+func (c *client) gwSubjectQuotaReached(sub *subscription) bool {
+	s := c.srv
+	if c.kind != CLIENT || sub.si || s == nil || !s.gateway.enabled {
+		return false
+	}
+	quota := s.gateway.maxcsubs
+	if quota <= 0 || int(atomic.LoadInt32(&c.gwsubs)) < quota {
+		return false
+	}
+	c.mu.Lock()
+	acc := c.acc
+	c.mu.Unlock()
+	if acc == nil {
+		return false
+	}
+	return !s.gatewayHasSubInterest(acc.Name, sub)
+}
+
+// Returns true if we already propagated interest on this subscription's subject (and queue).
+func (s *Server) gatewayHasSubInterest(accName string, sub *subscription) bool {
+	key := string(sub.subject)
+	if sub.queue != nil {
+		key += " " + string(sub.queue)
+	}
+	s.gateway.pasi.Lock()
+	defer s.gateway.pasi.Unlock()
+	_, ok := s.gateway.pasi.m[accName][key]
+	return ok
+}
+
+// Counts this subscription against our gateway subject quota.
+// Invoked when the subscription was the first interest on its subject.
+func (c *client) trackGatewaySubject(sub *subscription) {
+	c.mu.Lock()
+	// Could have been removed already.
+	if _, ok := c.subs[string(sub.sid)]; ok && !sub.gwq {
+		sub.gwq = true
+		atomic.AddInt32(&c.gwsubs, 1)
+	}
+	c.mu.Unlock()
+}
+
+// Rejects a subscription for our gateway subject quota and, the first time,
+// sends an advisory for it.
+func (c *client) gwSubjectQuotaExceeded(sub *subscription) {
+	s := c.srv
+	atomic.AddInt64(&s.gateway.sqExceeded, 1)
+
+	c.mu.Lock()
+	reported := c.flags.isSet(gwSubjectQuotaReported)
+	c.flags.set(gwSubjectQuotaReported)
+	acc := c.acc
+	c.mu.Unlock()
+
+	c.sendErr(ErrGatewaySubjectQuota.Error())
+	if reported {
+		return
+	}
+	c.Warnf("%s for subject %q (quota of %d)", ErrGatewaySubjectQuota, sub.subject, s.gateway.maxcsubs)
+
+	s.mu.Lock()
+	if !s.eventsEnabled() || acc == nil || acc == s.gacc {
+		s.mu.Unlock()
+		return
+	}
+	eid := s.nextEventID()
+	s.mu.Unlock()
+
+	m := GatewaySubjectQuotaEventMsg{
+		TypedEvent: TypedEvent{
+			Type: GatewaySubjectQuotaEventMsgType,
+			ID:   eid,
+			Time: time.Now().UTC(),
+		},
+		Subject: string(sub.subject),
+		Queue:   string(sub.queue),
+		Quota:   s.gateway.maxcsubs,
+	}
+	if ci := c.getClientInfo(true); ci != nil {
+		m.Client = *ci
+	}
+	s.sendInternalMsgLocked(fmt.Sprintf(gwSubjectQuotaEventSubj, acc.Name), _EMPTY_, &m.Server, &m)
+}
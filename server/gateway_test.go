@@ -6831,3 +6831,110 @@ func TestGatewaySwitchToInterestOnlyModeImmediately(t *testing.T) {
 	natsFlush(t, nc)
 	checkCount(t, gwcb, 1)
 }
+
+func TestGatewayMaxConnectionSubjects(t *testing.T) {
+	ob := testDefaultOptionsForGateway("B")
+	ob.Accounts = []*Account{NewAccount("ACC"), NewAccount("SYS")}
+	ob.SystemAccount = "SYS"
+	ob.Users = []*User{
+		{Username: "user", Password: "pwd", Account: ob.Accounts[0]},
+		{Username: "sys", Password: "pwd", Account: ob.Accounts[1]},
+	}
+	ob.Gateway.MaxConnSubjects = 2
+	sb := runGatewayServer(ob)
+	defer sb.Shutdown()
+
+	oa := testGatewayOptionsFromToWithServers(t, "A", "B", sb)
+	oa.Accounts = []*Account{NewAccount("ACC")}
+	oa.Users = []*User{{Username: "user", Password: "pwd", Account: oa.Accounts[0]}}
+	sa := runGatewayServer(oa)
+	defer sa.Shutdown()
+
+	waitForOutboundGateways(t, sa, 1, 2*time.Second)
+	waitForOutboundGateways(t, sb, 1, 2*time.Second)
+
+	ncSys := natsConnect(t, fmt.Sprintf("nats://sys:pwd@%s:%d", ob.Host, ob.Port))
+	defer ncSys.Close()
+	advSub := natsSubSync(t, ncSys, fmt.Sprintf(gwSubjectQuotaEventSubj, "ACC"))
+	natsFlush(t, ncSys)
+
+	// Client libraries close the connection on unknown errors, so use a raw one.
+	conn, err := net.Dial("tcp", net.JoinHostPort(ob.Host, strconv.Itoa(ob.Port)))
+	if err != nil {
+		t.Fatalf("Error dialing server: %v", err)
+	}
+	defer conn.Close()
+	cr := bufio.NewReader(conn)
+	if _, err := cr.ReadString('\n'); err != nil {
+		t.Fatalf("Error reading INFO: %v", err)
+	}
+	sendAndCheck := func(proto string, expectErr bool) {
+		t.Helper()
+		if _, err := conn.Write([]byte(proto + "PING\r\n")); err != nil {
+			t.Fatalf("Error sending: %v", err)
+		}
+		l, err := cr.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading: %v", err)
+		}
+		if expectErr {
+			if !strings.HasPrefix(l, "-ERR") || !strings.Contains(l, ErrGatewaySubjectQuota.Error()) {
+				t.Fatalf("Expected quota error, got %q", l)
+			}
+			l, err = cr.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Error reading: %v", err)
+			}
+		}
+		if l != "PONG\r\n" {
+			t.Fatalf("Expected PONG, got %q", l)
+		}
+	}
+	sendAndCheck("CONNECT {\"verbose\":false,\"user\":\"user\",\"pass\":\"pwd\"}\r\nSUB foo 1\r\nSUB bar 2\r\n", false)
+
+	// A new subject is now over the quota.
+	sendAndCheck("SUB baz 3\r\n", true)
+	// The connection is kept and a subject that already has interest is fine.
+	sendAndCheck("SUB foo 4\r\n", false)
+
+	msg, err := advSub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not get the advisory: %v", err)
+	}
+	var adv GatewaySubjectQuotaEventMsg
+	if err := json.Unmarshal(msg.Data, &adv); err != nil {
+		t.Fatalf("Error unmarshaling advisory: %v", err)
+	}
+	if adv.Type != GatewaySubjectQuotaEventMsgType || adv.Subject != "baz" || adv.Quota != 2 || adv.Client.Account != "ACC" {
+		t.Fatalf("Unexpected advisory: %+v", adv)
+	}
+
+	// Another client has a quota of its own.
+	nc := natsConnect(t, fmt.Sprintf("nats://user:pwd@%s:%d", ob.Host, ob.Port))
+	defer nc.Close()
+	natsSubSync(t, nc, "baz")
+	natsFlush(t, nc)
+
+	connz, err := sb.Connz(&ConnzOptions{User: "user"})
+	if err != nil {
+		t.Fatalf("Error getting connz: %v", err)
+	}
+	if len(connz.Conns) != 2 || connz.Conns[0].GatewaySubs != 2 || connz.Conns[1].GatewaySubs != 1 {
+		t.Fatalf("Unexpected connz: %+v", connz.Conns)
+	}
+	gwz, err := sb.Gatewayz(nil)
+	if err != nil {
+		t.Fatalf("Error getting gatewayz: %v", err)
+	}
+	if gwz.SubjectQuota != 2 || gwz.QuotaExceeded != 1 {
+		t.Fatalf("Unexpected gatewayz quota %d and exceeded %d", gwz.SubjectQuota, gwz.QuotaExceeded)
+	}
+
+	// Removing interest makes room again.
+	sendAndCheck("UNSUB 2\r\nSUB bat 5\r\n", false)
+	// The advisory is sent only once per connection.
+	sendAndCheck("SUB other 6\r\n", true)
+	if _, err := advSub.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected no other advisory, got %v", err)
+	}
+}
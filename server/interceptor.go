@@ -0,0 +1,93 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+// InterceptedMsg describes a message passed to a MsgInterceptors callback.
+// The slices are only valid for the duration of the callback and must not be
+// retained or modified.
+type InterceptedMsg struct {
+	Account string
+	Subject string
+	Reply   string
+	Header  []byte
+	Data    []byte
+}
+
+// MsgInterceptors allows applications embedding the server to apply custom
+// policy, e.g. billing or filtering, at different stages of a message lifecycle.
+// All callbacks are optional and are invoked inline, so they need to be fast.
+// They are registered through Options.MsgInterceptors and can not be changed
+// once the server has been created.
+type MsgInterceptors struct {
+	// OnPublish is invoked for messages published by client connections after
+	// permissions have been checked. Returning false drops the message.
+	OnPublish func(c ClientAuthentication, m *InterceptedMsg) bool
+	// OnDeliver is invoked before a message is delivered to a client connection.
+	// Returning false skips delivery to that subscription.
+	OnDeliver func(c ClientAuthentication, m *InterceptedMsg) bool
+	// OnStore is invoked before a message is stored in a stream. Returning an
+	// error rejects the message. For replicated streams this is invoked on every
+	// replica so the decision needs to be deterministic. It is called without
+	// the stream lock held.
+	OnStore func(stream string, m *InterceptedMsg) error
+	// OnEvict is invoked before a stream removes a message due to its limits,
+	// e.g. to archive it first. Returning false vetoes the removal and leaves the
+	// stream over its limits until they are next enforced. This is called with
+	// store locks held, and on every replica of replicated streams.
+	OnEvict func(account, stream string, m *EvictedMsg) bool
+}
+
+// Splits a raw message with its trailing CR_LF into header and payload.
+func splitInterceptedMsg(hdrLen int, msg []byte) (hdr, data []byte) {
+	if len(msg) >= LEN_CR_LF {
+		msg = msg[:len(msg)-LEN_CR_LF]
+	}
+	if hdrLen > 0 && hdrLen <= len(msg) {
+		return msg[:hdrLen], msg[hdrLen:]
+	}
+	return nil, msg
+}
+
+// Returns true if the publish should proceed.
+func (c *client) interceptPublish(msg []byte) bool {
+	mi := c.srv.interceptors
+	if mi == nil || mi.OnPublish == nil {
+		return true
+	}
+	m := &InterceptedMsg{
+		Account: c.acc.Name,
+		Subject: string(c.pa.subject),
+		Reply:   string(c.pa.reply),
+	}
+	m.Header, m.Data = splitInterceptedMsg(c.pa.hdr, msg)
+	return mi.OnPublish(c, m)
+}
+
+// Returns true if the delivery to the client should proceed.
+// Lock should not be held.
+func (c *client) interceptDeliver(client *client, acc *Account, subject, reply, msg []byte) bool {
+	mi := client.srv.interceptors
+	if mi == nil || mi.OnDeliver == nil {
+		return true
+	}
+	m := &InterceptedMsg{
+		Subject: string(subject),
+		Reply:   string(reply),
+	}
+	if acc != nil {
+		m.Account = acc.Name
+	}
+	m.Header, m.Data = splitInterceptedMsg(c.pa.hdr, msg)
+	return mi.OnDeliver(client, m)
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestMsgInterceptors(t *testing.T) {
+	var published, delivered, stored int32
+	var s *Server
+
+	opts := DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	opts.MsgInterceptors = &MsgInterceptors{
+		OnPublish: func(c ClientAuthentication, m *InterceptedMsg) bool {
+			atomic.AddInt32(&published, 1)
+			return m.Subject != "drop"
+		},
+		OnDeliver: func(c ClientAuthentication, m *InterceptedMsg) bool {
+			atomic.AddInt32(&delivered, 1)
+			return string(m.Data) != "secret"
+		},
+		OnStore: func(stream string, m *InterceptedMsg) error {
+			if string(m.Data) == "bad" {
+				return errors.New("bad payload")
+			}
+			// The stream is not locked, so we can look at it from here.
+			mset, err := s.GlobalAccount().lookupStream(stream)
+			if err != nil {
+				return err
+			}
+			atomic.StoreInt32(&stored, int32(mset.state().Msgs))
+			return nil
+		},
+	}
+	s = RunServer(&opts)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("*")
+	require_NoError(t, err)
+	require_NoError(t, nc.Flush())
+
+	require_NoError(t, nc.Publish("drop", []byte("ok")))
+	require_NoError(t, nc.Publish("keep", []byte("secret")))
+	m := nats.NewMsg("keep")
+	m.Header.Set("X", "Y")
+	m.Data = []byte("ok")
+	require_NoError(t, nc.PublishMsg(m))
+	require_NoError(t, nc.Flush())
+
+	msg, err := sub.NextMsg(time.Second)
+	require_NoError(t, err)
+	require_True(t, msg.Subject == "keep")
+	require_True(t, string(msg.Data) == "ok")
+	require_True(t, msg.Header.Get("X") == "Y")
+	if _, err := sub.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected no more messages, got %v", err)
+	}
+	require_True(t, atomic.LoadInt32(&published) == 3)
+	require_True(t, atomic.LoadInt32(&delivered) == 2)
+	require_NoError(t, sub.Unsubscribe())
+
+	_, err = js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+
+	_, err = js.Publish("foo", []byte("good"))
+	require_NoError(t, err)
+	_, err = js.Publish("foo", []byte("bad"))
+	require_Error(t, err)
+	require_Contains(t, err.Error(), "bad payload")
+
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 1)
+	require_True(t, atomic.LoadInt32(&stored) == 0)
+
+	_, err = js.Publish("foo", []byte("good"))
+	require_NoError(t, err)
+	require_True(t, atomic.LoadInt32(&stored) == 1)
+}
+
+func TestMsgInterceptorsOnEvict(t *testing.T) {
+	var archived int32
+
+	opts := DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	opts.MsgInterceptors = &MsgInterceptors{
+		OnEvict: func(account, stream string, m *EvictedMsg) bool {
+			if stream != "TEST" || m.Reason != EvictMaxMsgs {
+				return true
+			}
+			// Keep the ones we can not archive.
+			if string(m.Data) == "keep" {
+				return false
+			}
+			atomic.AddInt32(&archived, 1)
+			return true
+		},
+	}
+	s := RunServer(&opts)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}, MaxMsgs: 2})
+	require_NoError(t, err)
+
+	for _, data := range []string{"a", "b", "c", "keep", "d", "e"} {
+		_, err = js.Publish("foo", []byte(data))
+		require_NoError(t, err)
+	}
+	// a, b and c were archived, keep is held so we are over our limit.
+	require_True(t, atomic.LoadInt32(&archived) == 3)
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 3)
+}
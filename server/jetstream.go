@@ -23,6 +23,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -107,9 +108,19 @@ type jetStream struct {
 	accounts      map[string]*jsAccount
 	apiSubs       *Sublist
 	started       time.Time
+	audit         *jsAPIAudit
+
+	// Streams with stores still being recovered, guarded by their own lock.
+	recMu      sync.Mutex
+	recovering map[string]*StreamRecovery
+
+	// Source tokens of streams by account and stream, guarded by their own lock.
+	stMu    sync.RWMutex
+	stokens map[string][]string
 
 	// System level request to purge a stream move
 	accountPurge   *subscription
+	accountBackup  *subscription
 	metaRecovering bool
 	standAlone     bool
 	disabled       bool
@@ -140,6 +151,12 @@ type jsAccount struct {
 	streams   map[string]*stream
 	templates map[string]*streamTemplate
 	store     TemplateStore
+	trashTmr  *time.Timer
+	unlocks   map[string]time.Time // protected streams unlocked for deletion, indexed by stream name
+
+	// Config history when not clustered, loaded on demand.
+	hmu  sync.Mutex
+	hist *jsaConfigHistory
 
 	// From server
 	sendq *ipQueue // of *pubMsg
@@ -406,6 +423,9 @@ func (s *Server) enableJetStream(cfg JetStreamConfig) error {
 
 	// If we are in clustered mode go ahead and start the meta controller.
 	if !standAlone || canExtend {
+		if err := validateTrashRetention(s.getOpts(), true); err != nil {
+			return err
+		}
 		if err := s.enableJetStreamClustering(); err != nil {
 			return err
 		}
@@ -414,6 +434,8 @@ func (s *Server) enableJetStream(cfg JetStreamConfig) error {
 	// Mark when we are up and running.
 	js.setStarted()
 
+	s.notifyJetStreamRecovered()
+
 	return nil
 }
 
@@ -535,6 +557,68 @@ func (s *Server) handleOutOfSpace(mset *stream) {
 	}
 }
 
+// Returns a handler that tracks the progress of recovering a stream's store.
+// Progress is reported by healthz and advertised if recovery takes a while.
+func (s *Server) streamRecoveryHandler(account, stream string) func(RecoveryProgress) {
+	js := s.getJetStream()
+	if js == nil {
+		return nil
+	}
+	key := account + " > " + stream
+	return func(p RecoveryProgress) {
+		js.recMu.Lock()
+		if p.Done {
+			delete(js.recovering, key)
+		} else {
+			if js.recovering == nil {
+				js.recovering = make(map[string]*StreamRecovery)
+			}
+			js.recovering[key] = &StreamRecovery{Account: account, Stream: stream, RecoveryProgress: p}
+		}
+		js.recMu.Unlock()
+
+		// Only advertise recoveries that are taking some time.
+		if p.Elapsed < recoveryProgressInterval {
+			return
+		}
+		adv := &JSStreamRecoveryAdvisory{
+			TypedEvent: TypedEvent{
+				Type: JSStreamRecoveryAdvisoryType,
+				ID:   nuid.Next(),
+				Time: time.Now().UTC(),
+			},
+			Server:           s.Name(),
+			ServerID:         s.ID(),
+			Account:          account,
+			Stream:           stream,
+			RecoveryProgress: p,
+			Domain:           s.getOpts().JetStreamDomain,
+		}
+		s.publishAdvisory(nil, JSAdvisoryServerStreamRecovery, adv)
+	}
+}
+
+// Returns the streams whose stores are still being recovered.
+func (js *jetStream) recoveringStreams() []*StreamRecovery {
+	js.recMu.Lock()
+	defer js.recMu.Unlock()
+	if len(js.recovering) == 0 {
+		return nil
+	}
+	recs := make([]*StreamRecovery, 0, len(js.recovering))
+	for _, r := range js.recovering {
+		rc := *r
+		recs = append(recs, &rc)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].Account != recs[j].Account {
+			return recs[i].Account < recs[j].Account
+		}
+		return recs[i].Stream < recs[j].Stream
+	})
+	return recs
+}
+
 // DisableJetStream will turn off JetStream and signals in clustered mode
 // to have the metacontroller remove us from the peer list.
 func (s *Server) DisableJetStream() error {
@@ -776,8 +860,14 @@ func (js *jetStream) setJetStreamStandAlone(isStandAlone bool) {
 
 	if isStandAlone {
 		js.accountPurge, _ = js.srv.systemSubscribe(JSApiAccountPurge, _EMPTY_, false, nil, js.srv.jsLeaderAccountPurgeRequest)
-	} else if js.accountPurge != nil {
-		js.srv.sysUnsubscribe(js.accountPurge)
+		js.accountBackup, _ = js.srv.systemSubscribe(JSApiAccountBackupPoint, _EMPTY_, false, nil, js.srv.jsLeaderAccountBackupPointRequest)
+	} else {
+		if js.accountPurge != nil {
+			js.srv.sysUnsubscribe(js.accountPurge)
+		}
+		if js.accountBackup != nil {
+			js.srv.sysUnsubscribe(js.accountBackup)
+		}
 	}
 }
 
@@ -881,13 +971,16 @@ func (s *Server) shutdownJetStream() {
 			accounts = append(accounts, a)
 		}
 	}
-	accPurgeSub := js.accountPurge
-	js.accountPurge = nil
+	accPurgeSub, accBackupSub := js.accountPurge, js.accountBackup
+	js.accountPurge, js.accountBackup = nil, nil
 	js.mu.Unlock()
 
 	if accPurgeSub != nil {
 		s.sysUnsubscribe(accPurgeSub)
 	}
+	if accBackupSub != nil {
+		s.sysUnsubscribe(accBackupSub)
+	}
 
 	for _, a := range accounts {
 		a.removeJetStream()
@@ -1128,6 +1221,10 @@ func (a *Account) EnableJetStream(limits map[string]JetStreamAccountLimits) erro
 				continue
 			}
 			cfg.Config.Name = _EMPTY_
+			// Subscriptions of templates with placeholders come from their patterns.
+			if cfg.StreamName != _EMPTY_ {
+				cfg.Config.Subjects = nil
+			}
 			if _, err := a.addStreamTemplate(&cfg); err != nil {
 				s.Warnf("  Error recreating StreamTemplate %q: %v", cfg.Name, err)
 				continue
@@ -1163,7 +1260,10 @@ func (a *Account) EnableJetStream(limits map[string]JetStreamAccountLimits) erro
 		metafile := filepath.Join(mdir, JetStreamMetaFile)
 		metasum := filepath.Join(mdir, JetStreamMetaFileSum)
 		if _, err := os.Stat(metafile); os.IsNotExist(err) {
-			s.Warnf("  Missing stream metafile for %q", metafile)
+			// Memory streams may only have their dedupe state here.
+			if _, err := os.Stat(filepath.Join(mdir, dedupeStateFile)); err != nil {
+				s.Warnf("  Missing stream metafile for %q", metafile)
+			}
 			continue
 		}
 		buf, err := os.ReadFile(metafile)
@@ -1378,6 +1478,9 @@ func (a *Account) EnableJetStream(limits map[string]JetStreamAccountLimits) erro
 	// Make sure to cleanup any old remaining snapshots.
 	os.RemoveAll(filepath.Join(jsa.storeDir, snapsDir))
 
+	// Drop anything that has expired from the trash while we were down.
+	jsa.purgeTrash()
+
 	// Check interest policy streams for auto cleanup.
 	for _, mset := range ipstreams {
 		mset.checkForOrphanMsgs()
@@ -1882,14 +1985,29 @@ func (js *jetStream) limitsExceeded(storeType StorageType) bool {
 	return js.wouldExceedLimits(storeType, 0)
 }
 
+// Streams that selected a named tier are accounted under it, otherwise the tier is based on replicas.
 func tierName(cfg *StreamConfig) string {
-	// TODO (mh) this is where we could select based off a placement tag as well "qos:tier"
+	if cfg.Tier != _EMPTY_ {
+		return cfg.Tier
+	}
 	return fmt.Sprintf("R%d", cfg.Replicas)
 }
 
 func isSameTier(cfgA, cfgB *StreamConfig) bool {
-	// TODO (mh) this is where we could select based off a placement tag as well "qos:tier"
-	return cfgA.Replicas == cfgB.Replicas
+	return tierName(cfgA) == tierName(cfgB)
+}
+
+// Returns the tags peers need to have to host the stream.
+// These are the placement tags along with the tags of the stream's tier, if any.
+func (s *Server) placementTags(cfg *StreamConfig) []string {
+	var tags []string
+	if cfg.Placement != nil {
+		tags = append(tags, cfg.Placement.Tags...)
+	}
+	if cfg.Tier != _EMPTY_ {
+		tags = append(tags, s.getOpts().JetStreamTiers[cfg.Tier]...)
+	}
+	return tags
 }
 
 func (jsa *jsAccount) jetStreamAndClustered() (*jetStream, bool) {
@@ -1970,6 +2088,28 @@ func (jsa *jsAccount) limitsExceeded(storeType StorageType, tierName string) (bo
 	return false, nil
 }
 
+// Returns whether adding bytes of the given storage type would put us over the account limits.
+func (jsa *jsAccount) storageWouldExceed(storeType StorageType, tierName string, bytes int64) bool {
+	jsa.usageMu.RLock()
+	defer jsa.usageMu.RUnlock()
+
+	selectedLimits, ok := jsa.limits[tierName]
+	if !ok {
+		return true
+	}
+	var inUse int64
+	max := selectedLimits.MaxStore
+	if storeType == MemoryStorage {
+		max = selectedLimits.MaxMemory
+	}
+	if u := jsa.usage[tierName]; u != nil {
+		if inUse = u.total.store; storeType == MemoryStorage {
+			inUse = u.total.mem
+		}
+	}
+	return max >= 0 && inUse+bytes > max
+}
+
 // Check account limits.
 // Read Lock should be held
 func (js *jetStream) checkAccountLimits(selected *JetStreamAccountLimits, config *StreamConfig, currentRes int64) error {
@@ -2056,6 +2196,10 @@ func (jsa *jsAccount) delete() {
 		jsa.updatesSub = nil
 	}
 	jsa.usageMu.Unlock()
+	if jsa.trashTmr != nil {
+		jsa.trashTmr.Stop()
+		jsa.trashTmr = nil
+	}
 
 	for _, ms := range jsa.streams {
 		streams = append(streams, ms)
@@ -2261,12 +2405,47 @@ func (a *Account) checkForJetStream() (*Server, *jsAccount, error) {
 	return s, jsa, nil
 }
 
+// How long a protected stream stays unlocked for a delete or purge.
+const streamUnlockWindow = time.Minute
+
+// unlockStream will allow the next delete or purge of a protected stream within streamUnlockWindow.
+// Returns when the unlock expires.
+func (jsa *jsAccount) unlockStream(name string) time.Time {
+	expires := time.Now().Add(streamUnlockWindow)
+	jsa.mu.Lock()
+	defer jsa.mu.Unlock()
+	if jsa.unlocks == nil {
+		jsa.unlocks = make(map[string]time.Time)
+	}
+	jsa.unlocks[name] = expires
+	return expires
+}
+
+// consumeStreamUnlock returns whether the protected stream was unlocked, and if so uses up the unlock.
+func (jsa *jsAccount) consumeStreamUnlock(name string) bool {
+	jsa.mu.Lock()
+	defer jsa.mu.Unlock()
+	expires, ok := jsa.unlocks[name]
+	delete(jsa.unlocks, name)
+	return ok && time.Now().Before(expires)
+}
+
 // StreamTemplateConfig allows a configuration to auto-create streams based on this template when a message
 // is received that matches. Each new stream will use the config as the template config to create them.
 type StreamTemplateConfig struct {
 	Name       string        `json:"name"`
 	Config     *StreamConfig `json:"config"`
 	MaxStreams uint32        `json:"max_streams"`
+
+	// Subject patterns with placeholders, e.g. `orders.{region}.>`, and the name of the
+	// streams they create, e.g. ORDERS_{region}. Without a stream name, a stream is
+	// created for each literal subject matching the config's subjects.
+	Subjects   []string `json:"subjects,omitempty"`
+	StreamName string   `json:"stream_name,omitempty"`
+	// Config overrides for streams created from this template, by stream name.
+	Overrides map[string]*StreamTemplateOverride `json:"overrides,omitempty"`
+	// Delete created streams that have been idle for this long.
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
 }
 
 // StreamTemplateInfo
@@ -2282,12 +2461,21 @@ type streamTemplate struct {
 	jsa *jsAccount
 	*StreamTemplateConfig
 	streams []string
+	idleTmr *time.Timer
 }
 
 func (t *StreamTemplateConfig) deepCopy() *StreamTemplateConfig {
 	copy := *t
 	cfg := *t.Config
 	copy.Config = &cfg
+	copy.Subjects = append([]string(nil), t.Subjects...)
+	if t.Overrides != nil {
+		copy.Overrides = make(map[string]*StreamTemplateOverride, len(t.Overrides))
+		for name, o := range t.Overrides {
+			oc := *o
+			copy.Overrides[name] = &oc
+		}
+	}
 	return &copy
 }
 
@@ -2304,9 +2492,17 @@ func (a *Account) addStreamTemplate(tc *StreamTemplateConfig) (*streamTemplate,
 		return nil, fmt.Errorf("template name is too long, maximum allowed is %d", JSMaxNameLen)
 	}
 
+	if err := checkStreamTemplateV2(tc); err != nil {
+		return nil, err
+	}
+
 	// FIXME(dlc) - Hacky
 	tcopy := tc.deepCopy()
 	tcopy.Config.Name = "_"
+	// Subscribe to the patterns of the template with wildcards for its placeholders.
+	for _, pattern := range tcopy.Subjects {
+		tcopy.Config.Subjects = append(tcopy.Config.Subjects, templateWildcard(pattern))
+	}
 	cfg, apiErr := s.checkStreamCfg(tcopy.Config, a)
 	if apiErr != nil {
 		return nil, apiErr
@@ -2345,6 +2541,9 @@ func (a *Account) addStreamTemplate(tc *StreamTemplateConfig) (*streamTemplate,
 		t.delete()
 		return nil, err
 	}
+	t.mu.Lock()
+	t.startIdleCheck()
+	t.mu.Unlock()
 	return t, nil
 }
 
@@ -2376,7 +2575,10 @@ func (t *streamTemplate) processInboundTemplateMsg(_ *subscription, pc *client,
 		return
 	}
 	jsa := t.jsa
-	cn := canonicalName(subject)
+	cn, subjects, ok := t.instanceFor(subject)
+	if !ok {
+		return
+	}
 
 	jsa.mu.Lock()
 	// If we already are registered then we can just return here.
@@ -2403,9 +2605,11 @@ func (t *streamTemplate) processInboundTemplateMsg(_ *subscription, pc *client,
 	}
 
 	// We need to create the stream here.
-	// Change the config from the template and only use literal subject.
+	// Change the config from the template and only use literal subject,
+	// or the rendered patterns of the template.
 	cfg.Name = cn
-	cfg.Subjects = []string{subject}
+	cfg.Subjects = subjects
+	t.applyOverride(&cfg)
 	mset, err := acc.addStream(&cfg)
 	if err != nil {
 		acc.validateStreams(t)
@@ -2457,6 +2661,7 @@ func (t *streamTemplate) delete() error {
 	jsa := t.jsa
 	c := t.tc
 	t.tc = nil
+	t.stopIdleCheck()
 	defer func() {
 		if c != nil {
 			c.closeConnection(ClientClosed)
@@ -2619,6 +2824,12 @@ func (s *Server) resourcesExeededError() {
 
 // For validating options.
 func validateJetStreamOptions(o *Options) error {
+	if err := validateBackupKeys(o); err != nil {
+		return err
+	}
+	if err := validateTrashRetention(o, o.JetStream && (o.Cluster.Port != 0 || o.Gateway.Port != 0)); err != nil {
+		return err
+	}
 	// in non operator mode, the account names need to be configured
 	if len(o.JsAccDefaultDomain) > 0 {
 		if len(o.TrustedOperators) == 0 {
@@ -2695,6 +2906,9 @@ func validateJetStreamOptions(o *Options) error {
 	if o.JetStreamMaxCatchup < 0 {
 		return fmt.Errorf("jetstream max catchup cannot be negative")
 	}
+	if o.JetStreamCatchupRate < 0 {
+		return fmt.Errorf("jetstream catchup rate cannot be negative")
+	}
 	return nil
 }
 
@@ -15,12 +15,15 @@ package server
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
@@ -90,11 +93,91 @@ const (
 	JSApiStreamDelete  = "$JS.API.STREAM.DELETE.*"
 	JSApiStreamDeleteT = "$JS.API.STREAM.DELETE.%s"
 
+	// JSApiStreamBulk is the endpoint to create, update or delete many streams at once.
+	// The last token is the operation, see JSStreamBulkCreate and friends.
+	// Will return JSON response.
+	JSApiStreamBulk  = "$JS.API.STREAM.BULK.*"
+	JSApiStreamBulkT = "$JS.API.STREAM.BULK.%s"
+
+	// JSApiStreamTrash is the endpoint to list deleted streams that can still be restored.
+	// Will return JSON response.
+	JSApiStreamTrash = "$JS.API.STREAM.TRASH"
+
+	// JSApiStreamUndelete is the endpoint to restore a deleted stream from the trash.
+	// Will return JSON response.
+	JSApiStreamUndelete  = "$JS.API.STREAM.UNDELETE.*"
+	JSApiStreamUndeleteT = "$JS.API.STREAM.UNDELETE.%s"
+
 	// JSApiStreamPurge is the endpoint to purge streams.
 	// Will return JSON response.
 	JSApiStreamPurge  = "$JS.API.STREAM.PURGE.*"
 	JSApiStreamPurgeT = "$JS.API.STREAM.PURGE.%s"
 
+	// JSApiStreamPurgeEx is the endpoint to purge the messages of a stream matching a subject.
+	// The subject, which can have wildcards, is part of the endpoint so purges can be permissioned per subject.
+	// Will return JSON response.
+	JSApiStreamPurgeEx  = "$JS.API.STREAM.PURGE.*.>"
+	JSApiStreamPurgeExT = "$JS.API.STREAM.PURGE.%s.%s"
+
+	// JSApiStreamRemap is the endpoint to rewrite the subjects of stored messages.
+	// Will return JSON response.
+	JSApiStreamRemap  = "$JS.API.STREAM.REMAP.*"
+	JSApiStreamRemapT = "$JS.API.STREAM.REMAP.%s"
+
+	// JSApiStreamUnlock is the endpoint to unlock a protected stream so it can be deleted or purged.
+	// This is separate from the delete and purge endpoints so it can be permissioned on its own.
+	// Will return JSON response.
+	JSApiStreamUnlock  = "$JS.API.STREAM.UNLOCK.*"
+	JSApiStreamUnlockT = "$JS.API.STREAM.UNLOCK.%s"
+
+	// JSApiStreamFreeze is the endpoint to freeze or thaw a stream.
+	// A frozen stream rejects new messages and removals but can still be read.
+	// Will return JSON response.
+	JSApiStreamFreeze  = "$JS.API.STREAM.FREEZE.*"
+	JSApiStreamFreezeT = "$JS.API.STREAM.FREEZE.%s"
+
+	// JSApiStreamHold is the endpoint to place, release or list holds on subjects of a stream.
+	// Messages on held subjects are kept from limits, max age and purges.
+	// Will return JSON response.
+	JSApiStreamHold  = "$JS.API.STREAM.HOLD.*"
+	JSApiStreamHoldT = "$JS.API.STREAM.HOLD.%s"
+
+	// JSApiStreamCompact is the endpoint to start, query or cancel an asynchronous compaction.
+	// Will return JSON response.
+	JSApiStreamCompact  = "$JS.API.STREAM.COMPACT.*"
+	JSApiStreamCompactT = "$JS.API.STREAM.COMPACT.%s"
+
+	// JSApiStreamGenerate is the endpoint to store synthetic messages directly into a stream.
+	// Only available when the data generator is enabled in the server configuration.
+	// Will return JSON response.
+	JSApiStreamGenerate  = "$JS.API.STREAM.GENERATE.*"
+	JSApiStreamGenerateT = "$JS.API.STREAM.GENERATE.%s"
+
+	// JSApiStreamUsage is the endpoint to get the age histogram and subject prefix breakdown of a stream.
+	// Will return JSON response.
+	JSApiStreamUsage  = "$JS.API.STREAM.USAGE.*"
+	JSApiStreamUsageT = "$JS.API.STREAM.USAGE.%s"
+
+	// JSApiStreamHistory is the endpoint to list the prior config revisions of a stream.
+	// Will return JSON response.
+	JSApiStreamHistory  = "$JS.API.STREAM.HISTORY.*"
+	JSApiStreamHistoryT = "$JS.API.STREAM.HISTORY.%s"
+
+	// JSApiStreamConfigHistory is the same as JSApiStreamHistory.
+	// Will return JSON response.
+	JSApiStreamConfigHistory  = "$JS.API.STREAM.CONFIG.HISTORY.*"
+	JSApiStreamConfigHistoryT = "$JS.API.STREAM.CONFIG.HISTORY.%s"
+
+	// JSApiStreamDiff is the endpoint to compare two config revisions of a stream.
+	// Will return JSON response.
+	JSApiStreamDiff  = "$JS.API.STREAM.DIFF.*"
+	JSApiStreamDiffT = "$JS.API.STREAM.DIFF.%s"
+
+	// JSApiStreamRollback is the endpoint to update a stream back to a prior config revision.
+	// Will return JSON response.
+	JSApiStreamRollback  = "$JS.API.STREAM.ROLLBACK.*"
+	JSApiStreamRollbackT = "$JS.API.STREAM.ROLLBACK.%s"
+
 	// JSApiStreamSnapshot is the endpoint to snapshot streams.
 	// Will return a stream of chunks with a nil chunk as EOF to
 	// the deliver subject. Caller should respond to each chunk
@@ -112,6 +195,19 @@ const (
 	JSApiMsgDelete  = "$JS.API.STREAM.MSG.DELETE.*"
 	JSApiMsgDeleteT = "$JS.API.STREAM.MSG.DELETE.%s"
 
+	// JSApiMsgErase is not an endpoint, but controls access to secure erase on message deletes.
+	// Denying publish permissions for it restricts the message delete endpoint to deletes without erase.
+	JSApiMsgErase  = "$JS.API.STREAM.MSG.ERASE.*"
+	JSApiMsgEraseT = "$JS.API.STREAM.MSG.ERASE.%s"
+
+	// jsStreamAPIPre
+	jsStreamAPIPre = "$JS.API.STREAM."
+
+	// JSApiMsgDeleteRange is the endpoint to delete a range of messages from a stream.
+	// Will return JSON response.
+	JSApiMsgDeleteRange  = "$JS.API.STREAM.MSG.DELETE.RANGE.*"
+	JSApiMsgDeleteRangeT = "$JS.API.STREAM.MSG.DELETE.RANGE.%s"
+
 	// JSApiMsgGet is the template for direct requests for a message by its stream sequence number.
 	// Will return JSON response.
 	JSApiMsgGet  = "$JS.API.STREAM.MSG.GET.*"
@@ -154,6 +250,9 @@ const (
 	JSApiConsumerList  = "$JS.API.CONSUMER.LIST.*"
 	JSApiConsumerListT = "$JS.API.CONSUMER.LIST.%s"
 
+	// jsConsumerListPre
+	jsConsumerListPre = "$JS.API.CONSUMER.LIST."
+
 	// JSApiConsumerInfo is for obtaining general information about a consumer.
 	// Will return JSON response.
 	JSApiConsumerInfo  = "$JS.API.CONSUMER.INFO.*.*"
@@ -164,6 +263,21 @@ const (
 	JSApiConsumerDelete  = "$JS.API.CONSUMER.DELETE.*.*"
 	JSApiConsumerDeleteT = "$JS.API.CONSUMER.DELETE.%s.%s"
 
+	// JSApiConsumerHistory is the endpoint to list the prior config revisions of a consumer.
+	// Will return JSON response.
+	JSApiConsumerHistory  = "$JS.API.CONSUMER.HISTORY.*.*"
+	JSApiConsumerHistoryT = "$JS.API.CONSUMER.HISTORY.%s.%s"
+
+	// JSApiConsumerDiff is the endpoint to compare two config revisions of a consumer.
+	// Will return JSON response.
+	JSApiConsumerDiff  = "$JS.API.CONSUMER.DIFF.*.*"
+	JSApiConsumerDiffT = "$JS.API.CONSUMER.DIFF.%s.%s"
+
+	// JSApiConsumerRollback is the endpoint to update a consumer back to a prior config revision.
+	// Will return JSON response.
+	JSApiConsumerRollback  = "$JS.API.CONSUMER.ROLLBACK.*.*"
+	JSApiConsumerRollbackT = "$JS.API.CONSUMER.ROLLBACK.%s.%s"
+
 	// JSApiRequestNextT is the prefix for the request next message(s) for a consumer in worker/pull mode.
 	JSApiRequestNextT = "$JS.API.CONSUMER.MSG.NEXT.%s.%s"
 
@@ -205,6 +319,18 @@ const (
 	JSApiAccountPurge  = "$JS.API.ACCOUNT.PURGE.*"
 	JSApiAccountPurgeT = "$JS.API.ACCOUNT.PURGE.%s"
 
+	// JSApiAccountBackupPoint is the endpoint to briefly fence the streams of an account
+	// and record where they are, so filesystem snapshots of all servers line up.
+	// Only works from system account.
+	// Will return JSON response.
+	JSApiAccountBackupPoint  = "$JS.API.ACCOUNT.BACKUP_POINT.*"
+	JSApiAccountBackupPointT = "$JS.API.ACCOUNT.BACKUP_POINT.%s"
+
+	// JSApiAccountDrift is the endpoint to compare the streams and consumers of an
+	// account to a declared state. Nothing is changed.
+	// Will return JSON response.
+	JSApiAccountDrift = "$JS.API.ACCOUNT.DRIFT"
+
 	// JSApiServerStreamMove is the endpoint to move streams off a server
 	// Only works from system account.
 	// Will return JSON response.
@@ -246,6 +372,12 @@ const (
 	// JSAdvisoryConsumerMsgTerminatedPre is a notification published when a message has been terminated.
 	JSAdvisoryConsumerMsgTerminatedPre = "$JS.EVENT.ADVISORY.CONSUMER.MSG_TERMINATED"
 
+	// JSAdvisoryConsumerSuppressedPre is a notification published when advisories for a consumer were rate limited.
+	JSAdvisoryConsumerSuppressedPre = "$JS.EVENT.ADVISORY.CONSUMER.SUPPRESSED"
+
+	// JSAdvisoryConsumerRebalancePre is a notification published when the members of the queue group a consumer delivers to changed.
+	JSAdvisoryConsumerRebalancePre = "$JS.EVENT.ADVISORY.CONSUMER.REBALANCE"
+
 	// JSAdvisoryStreamCreatedPre notification that a stream was created.
 	JSAdvisoryStreamCreatedPre = "$JS.EVENT.ADVISORY.STREAM.CREATED"
 
@@ -279,6 +411,12 @@ const (
 	// JSAdvisoryStreamQuorumLostPre notification that a stream and its consumers are stalled.
 	JSAdvisoryStreamQuorumLostPre = "$JS.EVENT.ADVISORY.STREAM.QUORUM_LOST"
 
+	// JSAdvisoryStreamScaledPre notification that the replicas of a stream were scaled.
+	JSAdvisoryStreamScaledPre = "$JS.EVENT.ADVISORY.STREAM.SCALED"
+
+	// JSAdvisoryStreamSoftLimitPre notification that a stream crossed one of its soft limits.
+	JSAdvisoryStreamSoftLimitPre = "$JS.EVENT.ADVISORY.STREAM.SOFT_LIMIT"
+
 	// JSAdvisoryConsumerLeaderElectedPre notification that a replicated consumer has elected a leader.
 	JSAdvisoryConsumerLeaderElectedPre = "$JS.EVENT.ADVISORY.CONSUMER.LEADER_ELECTED"
 
@@ -288,6 +426,9 @@ const (
 	// JSAdvisoryServerOutOfStorage notification that a server has no more storage.
 	JSAdvisoryServerOutOfStorage = "$JS.EVENT.ADVISORY.SERVER.OUT_OF_STORAGE"
 
+	// JSAdvisoryServerStreamRecovery notification on the progress of a server recovering a stream's store.
+	JSAdvisoryServerStreamRecovery = "$JS.EVENT.ADVISORY.SERVER.STREAM_RECOVERY"
+
 	// JSAdvisoryServerRemoved notification that a server has been removed from the system.
 	JSAdvisoryServerRemoved = "$JS.EVENT.ADVISORY.SERVER.REMOVED"
 
@@ -397,6 +538,110 @@ type JSApiStreamDeleteResponse struct {
 
 const JSApiStreamDeleteResponseType = "io.nats.jetstream.api.v1.stream_delete_response"
 
+// JSApiStreamUnlockResponse is the response to unlocking a protected stream.
+type JSApiStreamUnlockResponse struct {
+	ApiResponse
+	// Expires is when the stream will be protected again if not deleted or purged.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+const JSApiStreamUnlockResponseType = "io.nats.jetstream.api.v1.stream_unlock_response"
+
+// JSApiStreamFreezeRequest will freeze a stream when Frozen is set and thaw it otherwise.
+type JSApiStreamFreezeRequest struct {
+	Frozen bool `json:"frozen"`
+}
+
+// JSApiStreamFreezeResponse is the response to freezing or thawing a stream.
+type JSApiStreamFreezeResponse struct {
+	ApiResponse
+	Frozen bool `json:"frozen"`
+}
+
+const JSApiStreamFreezeResponseType = "io.nats.jetstream.api.v1.stream_freeze_response"
+
+// JSApiStreamHoldRequest places a hold on Subject, or releases it when Release is set.
+// An empty request lists the holds of the stream.
+type JSApiStreamHoldRequest struct {
+	Subject string `json:"subject"`
+	Reason  string `json:"reason,omitempty"`
+	Release bool   `json:"release,omitempty"`
+}
+
+// JSApiStreamHoldResponse is the response to placing, releasing or listing holds.
+type JSApiStreamHoldResponse struct {
+	ApiResponse
+	Holds []*SubjectHold `json:"holds"`
+}
+
+const JSApiStreamHoldResponseType = "io.nats.jetstream.api.v1.stream_hold_response"
+
+// JSApiConfigHistoryResponse lists the config revisions of a stream or consumer, oldest first.
+// The last one is the current config.
+type JSApiConfigHistoryResponse struct {
+	ApiResponse
+	Revisions []*ConfigRevision `json:"revisions"`
+}
+
+const JSApiConfigHistoryResponseType = "io.nats.jetstream.api.v1.config_history_response"
+
+// JSApiConfigDiffRequest compares two config revisions. A missing To is the current one.
+type JSApiConfigDiffRequest struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to,omitempty"`
+}
+
+// JSApiConfigDiffResponse is the list of fields that changed between two config revisions.
+type JSApiConfigDiffResponse struct {
+	ApiResponse
+	From    uint64          `json:"from"`
+	To      uint64          `json:"to"`
+	Changes []*ConfigChange `json:"changes"`
+}
+
+const JSApiConfigDiffResponseType = "io.nats.jetstream.api.v1.config_diff_response"
+
+// JSApiConfigRollbackRequest updates a stream or consumer back to the config of a prior revision.
+// The rollback is a new revision. Streams respond with JSApiStreamUpdateResponse
+// and consumers with JSApiConsumerCreateResponse.
+type JSApiConfigRollbackRequest struct {
+	Revision uint64 `json:"revision"`
+}
+
+// JSApiStreamBulkRequest holds the streams of a bulk request. Creates and updates
+// take the stream configs, deletes the stream names.
+type JSApiStreamBulkRequest struct {
+	Streams []*StreamConfig `json:"streams,omitempty"`
+	Names   []string        `json:"names,omitempty"`
+}
+
+// JSApiStreamBulkResponse is the response to a bulk request. On success it lists the
+// streams of the request. On error none of them were changed, and Failed names the
+// stream that did not pass, if any.
+type JSApiStreamBulkResponse struct {
+	ApiResponse
+	Streams []string `json:"streams"`
+	Failed  string   `json:"failed,omitempty"`
+}
+
+const JSApiStreamBulkResponseType = "io.nats.jetstream.api.v1.stream_bulk_response"
+
+// JSApiStreamTrashResponse lists the deleted streams that can still be restored.
+type JSApiStreamTrashResponse struct {
+	ApiResponse
+	Streams []*TrashedStream `json:"streams"`
+}
+
+const JSApiStreamTrashResponseType = "io.nats.jetstream.api.v1.stream_trash_response"
+
+// JSApiStreamUndeleteResponse is the response to restoring a stream from the trash.
+type JSApiStreamUndeleteResponse struct {
+	ApiResponse
+	*StreamInfo
+}
+
+const JSApiStreamUndeleteResponseType = "io.nats.jetstream.api.v1.stream_undelete_response"
+
 // JSMaxSubjectDetails The limit of the number of subject details we will send in a stream info response.
 const JSMaxSubjectDetails = 100_000
 
@@ -404,6 +649,12 @@ type JSApiStreamInfoRequest struct {
 	ApiPagedRequest
 	DeletedDetails bool   `json:"deleted_details,omitempty"`
 	SubjectsFilter string `json:"subjects_filter,omitempty"`
+	// SubjectBytes will also report the bytes for each subject selected by SubjectsFilter.
+	SubjectBytes bool `json:"subject_bytes,omitempty"`
+	// AgeSummary will also report how the ages of messages are distributed.
+	AgeSummary bool `json:"age_summary,omitempty"`
+	// DeletedRanges will report deleted details as ranges instead of listing every sequence.
+	DeletedRanges bool `json:"deleted_ranges,omitempty"`
 }
 
 type JSApiStreamInfoResponse struct {
@@ -439,10 +690,14 @@ type JSApiStreamListRequest struct {
 	ApiPagedRequest
 	// These are filters that can be applied to the list.
 	Subject string `json:"subject,omitempty"`
+	// Streaming sends all streams past the offset as separate JSApiStreamInfoResponse
+	// messages instead of a single page, followed by a JSApiStreamListResponse summary.
+	Streaming bool `json:"streaming,omitempty"`
 }
 
 // JSApiStreamListResponse list of detailed stream information.
 // A nil request is valid and means all streams.
+// When streaming, this is the summary sent after all streams and Streams will be empty.
 type JSApiStreamListResponse struct {
 	ApiResponse
 	ApiPaged
@@ -474,6 +729,85 @@ type JSApiStreamPurgeResponse struct {
 
 const JSApiStreamPurgeResponseType = "io.nats.jetstream.api.v1.stream_purge_response"
 
+// JSApiStreamRemapRequest will rewrite the subject of all stored messages matching Filter
+// using Destination, which follows the same rules as a subject mapping destination.
+// The remapped subjects need to stay within the subjects of the stream.
+type JSApiStreamRemapRequest struct {
+	Filter      string `json:"filter"`
+	Destination string `json:"destination"`
+}
+
+type JSApiStreamRemapResponse struct {
+	ApiResponse
+	Success  bool   `json:"success,omitempty"`
+	Remapped uint64 `json:"remapped"`
+}
+
+const JSApiStreamRemapResponseType = "io.nats.jetstream.api.v1.stream_remap_response"
+
+// JSApiStreamCompactRequest starts an asynchronous compaction up to but not including Sequence.
+// An empty request returns the progress of the last compaction, Cancel will stop it.
+type JSApiStreamCompactRequest struct {
+	Sequence uint64 `json:"seq,omitempty"`
+	Cancel   bool   `json:"cancel,omitempty"`
+}
+
+type JSApiStreamCompactResponse struct {
+	ApiResponse
+	Progress *CompactProgress `json:"progress,omitempty"`
+}
+
+const JSApiStreamCompactResponseType = "io.nats.jetstream.api.v1.stream_compact_response"
+
+// JSApiStreamGenerateRequest describes the synthetic messages to store into a stream.
+type JSApiStreamGenerateRequest struct {
+	// Number of messages to store.
+	Msgs int `json:"msgs"`
+	// Subject of the messages, wildcard tokens are replaced by a key.
+	// Defaults to the first subject of the stream.
+	Subject string `json:"subject,omitempty"`
+	// Number of distinct keys, and so subjects when Subject has wildcards.
+	Keys int `json:"keys,omitempty"`
+	// How keys are picked, one of sequential (the default), uniform or zipf.
+	Distribution string `json:"distribution,omitempty"`
+	// Size of the payloads, the smallest one if MaxPayloadSize is set.
+	PayloadSize int `json:"payload_size,omitempty"`
+	// Payload sizes are picked uniformly up to this size when set.
+	MaxPayloadSize int `json:"max_payload_size,omitempty"`
+	// Seed of the generator, so a run can be repeated.
+	Seed int64 `json:"seed,omitempty"`
+}
+
+type JSApiStreamGenerateResponse struct {
+	ApiResponse
+	Generated int           `json:"generated"`
+	FirstSeq  uint64        `json:"first_seq,omitempty"`
+	LastSeq   uint64        `json:"last_seq,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+const JSApiStreamGenerateResponseType = "io.nats.jetstream.api.v1.stream_generate_response"
+
+// JSApiStreamUsageRequest selects the age buckets and subject prefix depth of a usage request.
+// AgeBuckets are the ascending upper bounds of the buckets, PrefixDepth the number of subject tokens to group by.
+type JSApiStreamUsageRequest struct {
+	AgeBuckets  []time.Duration `json:"age_buckets,omitempty"`
+	PrefixDepth int             `json:"prefix_depth,omitempty"`
+}
+
+// JSApiStreamUsageResponse holds how old the messages of a stream are and which subjects hold its bytes.
+// Both are approximations from what the store tracks already, so they are cheap to ask for.
+type JSApiStreamUsageResponse struct {
+	ApiResponse
+	Ages     []*StreamAgeBucket    `json:"ages"`
+	Prefixes []*SubjectPrefixUsage `json:"prefixes"`
+}
+
+const JSApiStreamUsageResponseType = "io.nats.jetstream.api.v1.stream_usage_response"
+
+// Age buckets used when a usage request does not ask for any.
+var defaultStreamUsageAgeBuckets = []time.Duration{time.Minute, time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
 // JSApiStreamUpdateResponse for updating a stream.
 type JSApiStreamUpdateResponse struct {
 	ApiResponse
@@ -486,6 +820,9 @@ const JSApiStreamUpdateResponseType = "io.nats.jetstream.api.v1.stream_update_re
 type JSApiMsgDeleteRequest struct {
 	Seq     uint64 `json:"seq"`
 	NoErase bool   `json:"no_erase,omitempty"`
+	// Leave a tombstone record with the optional reason in place of the message.
+	Tombstone bool   `json:"tombstone,omitempty"`
+	Reason    string `json:"reason,omitempty"`
 }
 
 type JSApiMsgDeleteResponse struct {
@@ -495,6 +832,19 @@ type JSApiMsgDeleteResponse struct {
 
 const JSApiMsgDeleteResponseType = "io.nats.jetstream.api.v1.stream_msg_delete_response"
 
+// JSApiMsgDeleteRangeRequest deletes all messages from first to last inclusive.
+type JSApiMsgDeleteRangeRequest struct {
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+}
+
+type JSApiMsgDeleteRangeResponse struct {
+	ApiResponse
+	Removed uint64 `json:"removed"`
+}
+
+const JSApiMsgDeleteRangeResponseType = "io.nats.jetstream.api.v1.stream_msg_delete_range_response"
+
 type JSApiStreamSnapshotRequest struct {
 	// Subject to deliver the chunks to for the snapshot.
 	DeliverSubject string `json:"deliver_subject"`
@@ -505,6 +855,8 @@ type JSApiStreamSnapshotRequest struct {
 	ChunkSize int `json:"chunk_size,omitempty"`
 	// Check all message's checksums prior to snapshot.
 	CheckMsgs bool `json:"jsck,omitempty"`
+	// Encrypt the snapshot with a data key sealed to the given key.
+	Encryption *SnapshotEncryption `json:"encryption,omitempty"`
 }
 
 // JSApiStreamSnapshotResponse is the direct response to the snapshot request.
@@ -514,6 +866,8 @@ type JSApiStreamSnapshotResponse struct {
 	Config *StreamConfig `json:"config,omitempty"`
 	// Current State for the given stream.
 	State *StreamState `json:"state,omitempty"`
+	// Sealed data key and cipher if the snapshot is encrypted.
+	Encryption *SnapshotEncryption `json:"encryption,omitempty"`
 }
 
 const JSApiStreamSnapshotResponseType = "io.nats.jetstream.api.v1.stream_snapshot_response"
@@ -524,6 +878,10 @@ type JSApiStreamRestoreRequest struct {
 	Config StreamConfig `json:"config"`
 	// Current State for the given stream.
 	State StreamState `json:"state"`
+	// Required to restore an encrypted snapshot.
+	Encryption *SnapshotEncryption `json:"encryption,omitempty"`
+	// Optional manifest from the snapshot, checked before restoring.
+	Manifest *SnapshotManifest `json:"manifest,omitempty"`
 }
 
 // JSApiStreamRestoreResponse is the direct response to the restore request.
@@ -610,17 +968,52 @@ type JSApiMetaServerStreamMoveRequest struct {
 
 const JSApiAccountPurgeResponseType = "io.nats.jetstream.api.v1.account_purge_response"
 
+// JSApiAccountDriftRequest is the declared state of all the streams, and their consumers, of an account.
+type JSApiAccountDriftRequest struct {
+	Streams []*DesiredStream `json:"streams"`
+}
+
+// JSApiAccountDriftResponse lists how the account drifted from the declared state.
+type JSApiAccountDriftResponse struct {
+	ApiResponse
+	InSync bool          `json:"in_sync"`
+	Drift  []*AssetDrift `json:"drift"`
+}
+
+const JSApiAccountDriftResponseType = "io.nats.jetstream.api.v1.account_drift_response"
+
 // JSApiAccountPurgeResponse is the response to a purge request in the meta group.
 type JSApiAccountPurgeResponse struct {
 	ApiResponse
 	Initiated bool `json:"initiated,omitempty"`
 }
 
+// JSApiAccountBackupPointRequest is the request to take a backup point of an account.
+type JSApiAccountBackupPointRequest struct {
+	// Longest streams stay fenced, they are released by then even if the backup point failed.
+	Fence time.Duration `json:"fence,omitempty"`
+}
+
+// JSApiAccountBackupPointResponse lists where the streams of an account were while they were all fenced.
+type JSApiAccountBackupPointResponse struct {
+	ApiResponse
+	ID      string              `json:"id,omitempty"`
+	Account string              `json:"account,omitempty"`
+	Time    time.Time           `json:"time,omitempty"`
+	Streams []*BackupPointState `json:"streams,omitempty"`
+	// Streams that did not report before the fence expired, the backup point does not cover these.
+	Missing []string `json:"missing,omitempty"`
+}
+
+const JSApiAccountBackupPointResponseType = "io.nats.jetstream.api.v1.account_backup_point_response"
+
 // JSApiMsgGetRequest get a message request.
 type JSApiMsgGetRequest struct {
 	Seq     uint64 `json:"seq,omitempty"`
 	LastFor string `json:"last_by_subj,omitempty"`
 	NextFor string `json:"next_by_subj,omitempty"`
+	// StartTime gets the first message stored at or after it, on NextFor if set.
+	StartTime *time.Time `json:"start_time,omitempty"`
 }
 
 type JSApiMsgGetResponse struct {
@@ -656,6 +1049,10 @@ const JSApiConsumerInfoResponseType = "io.nats.jetstream.api.v1.consumer_info_re
 
 type JSApiConsumersRequest struct {
 	ApiPagedRequest
+	// Streaming sends all consumers past the offset as separate JSApiConsumerInfoResponse
+	// messages instead of a single page, followed by a JSApiConsumerListResponse summary.
+	// Only used when listing consumers.
+	Streaming bool `json:"streaming,omitempty"`
 }
 
 type JSApiConsumerNamesResponse struct {
@@ -666,6 +1063,7 @@ type JSApiConsumerNamesResponse struct {
 
 const JSApiConsumerNamesResponseType = "io.nats.jetstream.api.v1.consumer_names_response"
 
+// When streaming, this is the summary sent after all consumers and Consumers will be empty.
 type JSApiConsumerListResponse struct {
 	ApiResponse
 	ApiPaged
@@ -758,6 +1156,11 @@ func (js *jetStream) apiDispatch(sub *subscription, c *client, acc *Account, sub
 	}
 	jsub := rr.psubs[0]
 
+	// Track when we received this if we are auditing.
+	if js.audit != nil {
+		js.audit.trackRequest(reply)
+	}
+
 	// If this is directly from a client connection ok to do in place.
 	if c.kind != ROUTER && c.kind != GATEWAY && c.kind != LEAF {
 		start := time.Now()
@@ -772,31 +1175,70 @@ func (js *jetStream) apiDispatch(sub *subscription, c *client, acc *Account, sub
 	// We need to make sure not to block. We will send the request to a long-lived
 	// go routine.
 
+	// Requests of the system account get their own queue that is always processed
+	// first, so a flood of requests from other accounts can not delay them.
+	queue := s.jsAPIRoutedReqs
+	if s.isSystemAccountRequest(hdr) {
+		queue = s.jsAPIRoutedSysReqs
+	}
+
 	// Copy the state. Note the JSAPI only uses the hdr index to piece apart the
 	// header from the msg body. No other references are needed.
-	s.jsAPIRoutedReqs.push(&jsAPIRoutedReq{jsub, sub, acc, subject, reply, copyBytes(rmsg), c.pa})
+	queue.push(&jsAPIRoutedReq{jsub, sub, acc, subject, reply, copyBytes(rmsg), c.pa})
+}
+
+// Returns true if the JetStream API request with these headers was made by the system account.
+func (s *Server) isSystemAccountRequest(hdr []byte) bool {
+	sacc := s.SystemAccount()
+	if sacc == nil {
+		return false
+	}
+	var ci ClientInfo
+	if cis := getHeader(ClientInfoHdr, hdr); len(cis) > 0 {
+		if err := json.Unmarshal(cis, &ci); err != nil {
+			return false
+		}
+	}
+	// Without client info this is direct $SYS access.
+	return ci.serviceAccount() == _EMPTY_ || ci.serviceAccount() == sacc.Name
 }
 
 func (s *Server) processJSAPIRoutedRequests() {
 	defer s.grWG.Done()
 
 	s.mu.Lock()
-	queue := s.jsAPIRoutedReqs
+	queue, squeue := s.jsAPIRoutedReqs, s.jsAPIRoutedSysReqs
 	client := &client{srv: s, kind: JETSTREAM}
 	s.mu.Unlock()
 
+	process := func(r *jsAPIRoutedReq) {
+		client.pa = r.pa
+		start := time.Now()
+		r.jsub.icb(r.sub, client, r.acc, r.subject, r.reply, r.msg)
+		if dur := time.Since(start); dur >= readLoopReportThreshold {
+			s.Warnf("Internal subscription on %q took too long: %v", r.subject, dur)
+		}
+	}
+	processSys := func() {
+		reqs := squeue.pop()
+		for _, req := range reqs {
+			process(req.(*jsAPIRoutedReq))
+		}
+		squeue.recycle(&reqs)
+	}
+
 	for {
 		select {
+		case <-squeue.ch:
+			processSys()
 		case <-queue.ch:
 			reqs := queue.pop()
 			for _, req := range reqs {
-				r := req.(*jsAPIRoutedReq)
-				client.pa = r.pa
-				start := time.Now()
-				r.jsub.icb(r.sub, client, r.acc, r.subject, r.reply, r.msg)
-				if dur := time.Since(start); dur >= readLoopReportThreshold {
-					s.Warnf("Internal subscription on %q took too long: %v", r.subject, dur)
+				// Requests of the system account go ahead of any other pending ones.
+				if squeue.len() > 0 {
+					processSys()
 				}
+				process(req.(*jsAPIRoutedReq))
 			}
 			queue.recycle(&reqs)
 		case <-s.quitCh:
@@ -814,13 +1256,22 @@ func (s *Server) setJetStreamExportSubs() error {
 	// Start the go routine that will process API requests received by the
 	// subscription below when they are coming from routes, etc..
 	s.jsAPIRoutedReqs = s.newIPQueue("Routed JS API Requests")
+	s.jsAPIRoutedSysReqs = s.newIPQueue("Routed JS API System Requests")
 	s.startGoRoutine(s.processJSAPIRoutedRequests)
 
+	// Record API requests to per account audit streams if configured.
+	s.startJetStreamAPIAudit(js)
+
 	// This is the catch all now for all JetStream API calls.
 	if _, err := s.sysSubscribe(jsAllAPI, js.apiDispatch); err != nil {
 		return err
 	}
 
+	// Every server fences the streams it leads when taking a backup point.
+	if err := s.setJetStreamBackupSubs(); err != nil {
+		return err
+	}
+
 	if err := s.SystemAccount().AddServiceExport(jsAllAPI, nil); err != nil {
 		s.Warnf("Error setting up jetstream service exports: %v", err)
 		return err
@@ -832,6 +1283,7 @@ func (s *Server) setJetStreamExportSubs() error {
 		handler msgHandler
 	}{
 		{JSApiAccountInfo, s.jsAccountInfoRequest},
+		{JSApiAccountDrift, s.jsAccountDriftRequest},
 		{JSApiTemplateCreate, s.jsTemplateCreateRequest},
 		{JSApiTemplates, s.jsTemplateNamesRequest},
 		{JSApiTemplateInfo, s.jsTemplateInfoRequest},
@@ -842,13 +1294,29 @@ func (s *Server) setJetStreamExportSubs() error {
 		{JSApiStreamList, s.jsStreamListRequest},
 		{JSApiStreamInfo, s.jsStreamInfoRequest},
 		{JSApiStreamDelete, s.jsStreamDeleteRequest},
+		{JSApiStreamBulk, s.jsStreamBulkRequest},
+		{JSApiStreamTrash, s.jsStreamTrashRequest},
+		{JSApiStreamUndelete, s.jsStreamUndeleteRequest},
 		{JSApiStreamPurge, s.jsStreamPurgeRequest},
+		{JSApiStreamPurgeEx, s.jsStreamPurgeRequest},
+		{JSApiStreamRemap, s.jsStreamRemapRequest},
+		{JSApiStreamUnlock, s.jsStreamUnlockRequest},
+		{JSApiStreamFreeze, s.jsStreamFreezeRequest},
+		{JSApiStreamHold, s.jsStreamHoldRequest},
+		{JSApiStreamCompact, s.jsStreamCompactRequest},
+		{JSApiStreamGenerate, s.jsStreamGenerateRequest},
+		{JSApiStreamUsage, s.jsStreamUsageRequest},
+		{JSApiStreamHistory, s.jsConfigHistoryRequest},
+		{JSApiStreamConfigHistory, s.jsConfigHistoryRequest},
+		{JSApiStreamDiff, s.jsConfigDiffRequest},
+		{JSApiStreamRollback, s.jsConfigRollbackRequest},
 		{JSApiStreamSnapshot, s.jsStreamSnapshotRequest},
 		{JSApiStreamRestore, s.jsStreamRestoreRequest},
 		{JSApiStreamRemovePeer, s.jsStreamRemovePeerRequest},
 		{JSApiStreamLeaderStepDown, s.jsStreamLeaderStepDownRequest},
 		{JSApiConsumerLeaderStepDown, s.jsConsumerLeaderStepDownRequest},
 		{JSApiMsgDelete, s.jsMsgDeleteRequest},
+		{JSApiMsgDeleteRange, s.jsMsgDeleteRangeRequest},
 		{JSApiMsgGet, s.jsMsgGetRequest},
 		{JSApiConsumerCreateEx, s.jsConsumerCreateRequest},
 		{JSApiConsumerCreate, s.jsConsumerCreateRequest},
@@ -857,6 +1325,9 @@ func (s *Server) setJetStreamExportSubs() error {
 		{JSApiConsumerList, s.jsConsumerListRequest},
 		{JSApiConsumerInfo, s.jsConsumerInfoRequest},
 		{JSApiConsumerDelete, s.jsConsumerDeleteRequest},
+		{JSApiConsumerHistory, s.jsConfigHistoryRequest},
+		{JSApiConsumerDiff, s.jsConfigDiffRequest},
+		{JSApiConsumerRollback, s.jsConfigRollbackRequest},
 	}
 
 	js.mu.Lock()
@@ -878,6 +1349,7 @@ func (s *Server) sendAPIResponse(ci *ClientInfo, acc *Account, subject, reply, r
 		s.sendInternalAccountMsg(nil, reply, response)
 	}
 	s.sendJetStreamAPIAuditAdvisory(ci, acc, subject, request, response)
+	s.auditJetStreamAPI(ci, acc, subject, reply, response)
 }
 
 func (s *Server) sendAPIErrResponse(ci *ClientInfo, acc *Account, subject, reply, request, response string) {
@@ -886,6 +1358,7 @@ func (s *Server) sendAPIErrResponse(ci *ClientInfo, acc *Account, subject, reply
 		s.sendInternalAccountMsg(nil, reply, response)
 	}
 	s.sendJetStreamAPIAuditAdvisory(ci, acc, subject, request, response)
+	s.auditJetStreamAPI(ci, acc, subject, reply, response)
 }
 
 const errRespDelay = 500 * time.Millisecond
@@ -906,6 +1379,7 @@ func (s *Server) sendDelayedAPIErrResponse(ci *ClientInfo, acc *Account, subject
 				s.sendInternalAccountMsg(nil, reply, response)
 			}
 			s.sendJetStreamAPIAuditAdvisory(ci, acc, subject, request, response)
+			s.auditJetStreamAPI(ci, acc, subject, reply, response)
 		}
 	})
 }
@@ -1319,23 +1793,8 @@ func (s *Server) jsStreamCreateRequest(sub *subscription, c *client, _ *Account,
 		return
 	}
 
-	// Check for path like separators in the name.
-	if strings.ContainsAny(streamName, `\/`) {
-		resp.Error = NewJSStreamNameContainsPathSeparatorsError()
-		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
-		return
-	}
-
-	// Can't create a stream with a sealed state.
-	if cfg.Sealed {
-		resp.Error = NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration for create can not be sealed"))
-		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
-		return
-	}
-
-	// If we are told to do mirror direct but are not mirroring, error.
-	if cfg.MirrorDirect && cfg.Mirror == nil {
-		resp.Error = NewJSStreamInvalidConfigError(fmt.Errorf("stream has no mirror but does have mirror direct"))
+	if apiErr := s.checkStreamCreateCfg(&cfg); apiErr != nil {
+		resp.Error = apiErr
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
@@ -1352,6 +1811,7 @@ func (s *Server) jsStreamCreateRequest(sub *subscription, c *client, _ *Account,
 		return
 	}
 
+	_, lerr := acc.lookupStream(cfg.Name)
 	mset, err := acc.addStream(&cfg)
 	if err != nil {
 		if IsNatsErr(err, JSStreamStoreFailedF) {
@@ -1362,15 +1822,58 @@ func (s *Server) jsStreamCreateRequest(sub *subscription, c *client, _ *Account,
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
+	if cfg.Partitions > 0 {
+		if err := acc.addStreamPartitions(mset); err != nil {
+			mset.removePartitions(false)
+			mset.delete()
+			resp.Error = NewJSStreamCreateError(err, Unless(err))
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+	}
+	// Drop any history left from a prior stream with the same name.
+	if lerr != nil {
+		mset.jsa.resetStreamConfigHistory(cfg.Name)
+	}
 	resp.StreamInfo = &StreamInfo{
 		Created: mset.createdTime(),
 		State:   mset.state(),
 		Config:  mset.config(),
 	}
+	if cfg.Partitions > 0 {
+		resp.StreamInfo.State, resp.StreamInfo.Partitions = mset.partitionsState()
+	}
 	resp.DidCreate = true
 	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
 }
 
+// Checks done on create only, on top of checkStreamCfg.
+func (s *Server) checkStreamCreateCfg(cfg *StreamConfig) *ApiError {
+	// Check for path like separators in the name.
+	if strings.ContainsAny(cfg.Name, `\/`) {
+		return NewJSStreamNameContainsPathSeparatorsError()
+	}
+	// Can't create a stream with a sealed state.
+	if cfg.Sealed {
+		return NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration for create can not be sealed"))
+	}
+	// Partitions are created by their stream.
+	if cfg.PartitionOf != _EMPTY_ {
+		return NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration for create can not set partition of"))
+	}
+	// If we are told to do mirror direct but are not mirroring, error.
+	if cfg.MirrorDirect && cfg.Mirror == nil {
+		return NewJSStreamInvalidConfigError(fmt.Errorf("stream has no mirror but does have mirror direct"))
+	}
+	// If a tier was selected it needs to be one we know how to place.
+	if cfg.Tier != _EMPTY_ {
+		if _, ok := s.getOpts().JetStreamTiers[cfg.Tier]; !ok {
+			return NewJSStreamInvalidConfigError(fmt.Errorf("unknown tier %q", cfg.Tier))
+		}
+	}
+	return nil
+}
+
 // Request to update a stream.
 func (s *Server) jsStreamUpdateRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
 	if c == nil || !s.JetStreamEnabled() {
@@ -1437,20 +1940,35 @@ func (s *Server) jsStreamUpdateRequest(sub *subscription, c *client, _ *Account,
 		return
 	}
 
-	mset, err := acc.lookupStream(streamName)
-	if err != nil {
-		resp.Error = NewJSStreamNotFoundError(Unless(err))
+	if resp.StreamInfo, resp.Error = s.jsStreamUpdateLocal(acc, &cfg); resp.Error != nil {
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
 
-	if err := mset.update(&cfg); err != nil {
-		resp.Error = NewJSStreamUpdateError(err, Unless(err))
-		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
-		return
+// Updates a stream when not clustered, recording the config it replaced.
+func (s *Server) jsStreamUpdateLocal(acc *Account, cfg *StreamConfig) (*StreamInfo, *ApiError) {
+	mset, err := acc.lookupStream(cfg.Name)
+	if err != nil {
+		return nil, NewJSStreamNotFoundError(Unless(err))
 	}
 
-	resp.StreamInfo = &StreamInfo{
+	ocfg := mset.config()
+	if ocfg.PartitionOf != _EMPTY_ {
+		return nil, NewJSStreamUpdateError(errors.New("stream partitions are updated through their stream"))
+	}
+
+	if err := mset.update(cfg); err != nil {
+		return nil, NewJSStreamUpdateError(err, Unless(err))
+	}
+	if cfg.Partitions > 0 {
+		if err := mset.updatePartitions(); err != nil {
+			return nil, NewJSStreamUpdateError(err, Unless(err))
+		}
+	}
+
+	si := &StreamInfo{
 		Created: mset.createdTime(),
 		State:   mset.state(),
 		Config:  mset.config(),
@@ -1458,7 +1976,13 @@ func (s *Server) jsStreamUpdateRequest(sub *subscription, c *client, _ *Account,
 		Mirror:  mset.mirrorInfo(),
 		Sources: mset.sourcesInfo(),
 	}
-	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+	if !reflect.DeepEqual(ocfg, si.Config) {
+		mset.jsa.recordStreamConfig(cfg.Name, si.Created, &ocfg)
+	}
+	if cfg.Partitions > 0 {
+		si.State, si.Partitions = mset.partitionsState()
+	}
+	return si, nil
 }
 
 // Request for the list of all stream names.
@@ -1591,6 +2115,18 @@ func (s *Server) jsStreamNamesRequest(sub *subscription, c *client, _ *Account,
 
 // Request for the list of all detailed stream info.
 // TODO(dlc) - combine with above long term
+// Checks if this is a list request asking for its results to be streamed, in which case
+// the response service import needs to let more than one response through.
+func isStreamingListRequest(subject string, msg []byte) bool {
+	if subject != JSApiStreamList && !strings.HasPrefix(subject, jsConsumerListPre) {
+		return false
+	}
+	var req struct {
+		Streaming bool `json:"streaming"`
+	}
+	return json.Unmarshal(msg, &req) == nil && req.Streaming
+}
+
 func (s *Server) jsStreamListRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
 	if c == nil || !s.JetStreamEnabled() {
 		return
@@ -1633,6 +2169,7 @@ func (s *Server) jsStreamListRequest(sub *subscription, c *client, _ *Account, s
 
 	var offset int
 	var filter string
+	var streaming bool
 
 	if !isEmptyRequest(msg) {
 		var req JSApiStreamListRequest
@@ -1641,7 +2178,7 @@ func (s *Server) jsStreamListRequest(sub *subscription, c *client, _ *Account, s
 			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 			return
 		}
-		offset = req.Offset
+		offset, streaming = req.Offset, req.Streaming
 		if req.Subject != _EMPTY_ {
 			filter = req.Subject
 		}
@@ -1651,7 +2188,7 @@ func (s *Server) jsStreamListRequest(sub *subscription, c *client, _ *Account, s
 	if s.JetStreamIsClustered() {
 		// Need to copy these off before sending.. don't move this inside startGoRoutine!!!
 		msg = copyBytes(msg)
-		s.startGoRoutine(func() { s.jsClusteredStreamListRequest(acc, ci, filter, offset, subject, reply, msg) })
+		s.startGoRoutine(func() { s.jsClusteredStreamListRequest(acc, ci, filter, offset, streaming, subject, reply, msg) })
 		return
 	}
 
@@ -1675,20 +2212,33 @@ func (s *Server) jsStreamListRequest(sub *subscription, c *client, _ *Account, s
 
 	for _, mset := range msets[offset:] {
 		config := mset.config()
-		resp.Streams = append(resp.Streams, &StreamInfo{
+		si := &StreamInfo{
 			Created: mset.createdTime(),
 			State:   mset.state(),
 			Config:  config,
 			Domain:  s.getOpts().JetStreamDomain,
 			Mirror:  mset.mirrorInfo(),
 			Sources: mset.sourcesInfo(),
-		})
+			Frozen:  mset.isFrozen(),
+			Holds:   mset.subjectHolds(),
+			Idle:    mset.isIdle(),
+		}
+		if streaming {
+			s.sendInternalAccountMsg(nil, reply, s.jsonResponse(&JSApiStreamInfoResponse{
+				ApiResponse: ApiResponse{Type: JSApiStreamInfoResponseType},
+				StreamInfo:  si,
+			}))
+			continue
+		}
+		resp.Streams = append(resp.Streams, si)
 		if len(resp.Streams) >= JSApiListLimit {
 			break
 		}
 	}
 	resp.Total = scnt
-	resp.Limit = JSApiListLimit
+	if !streaming {
+		resp.Limit = JSApiListLimit
+	}
 	resp.Offset = offset
 	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
 }
@@ -1803,8 +2353,9 @@ func (s *Server) jsStreamInfoRequest(sub *subscription, c *client, a *Account, s
 		return
 	}
 
-	var details bool
+	var details, ranges bool
 	var subjects string
+	var subjectBytes, ages bool
 	var offset int
 	if !isEmptyRequest(msg) {
 		var req JSApiStreamInfoRequest
@@ -1813,8 +2364,8 @@ func (s *Server) jsStreamInfoRequest(sub *subscription, c *client, a *Account, s
 			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 			return
 		}
-		details, subjects = req.DeletedDetails, req.SubjectsFilter
-		offset = req.Offset
+		details, subjects, subjectBytes = req.DeletedDetails || req.DeletedRanges, req.SubjectsFilter, req.SubjectBytes
+		ages, offset, ranges = req.AgeSummary, req.Offset, req.DeletedRanges
 	}
 
 	mset, err := acc.lookupStream(streamName)
@@ -1827,25 +2378,43 @@ func (s *Server) jsStreamInfoRequest(sub *subscription, c *client, a *Account, s
 
 	js, _ := s.getJetStreamCluster()
 
+	state := mset.stateWithDetail(details)
+	// Unless asked for ranges, list every deleted sequence as before.
+	if details && !ranges {
+		state.Deleted, state.DeletedRanges = state.DeletedSeqs(), nil
+	}
+
 	resp.StreamInfo = &StreamInfo{
 		Created:    mset.createdTime(),
-		State:      mset.stateWithDetail(details),
+		State:      state,
 		Config:     config,
 		Domain:     s.getOpts().JetStreamDomain,
 		Cluster:    js.clusterInfo(mset.raftGroup()),
 		Mirror:     mset.mirrorInfo(),
 		Sources:    mset.sourcesInfo(),
 		Alternates: js.streamAlternates(ci, config.Name),
+		Stats:      mset.storeStats(),
+		Frozen:     mset.isFrozen(),
+		Holds:      mset.subjectHolds(),
+		Sink:       mset.sinkInfo(),
+		Recompress: mset.recompression(),
+		Idle:       mset.isIdle(),
 	}
 	if clusterWideConsCount > 0 {
 		resp.StreamInfo.State.Consumers = clusterWideConsCount
 	}
+	if config.Partitions > 0 {
+		resp.StreamInfo.State, resp.StreamInfo.Partitions = mset.partitionsState()
+	}
+	if ages {
+		resp.StreamInfo.State.Ages = mset.store.AgeSummary()
+	}
 
 	// Check if they have asked for subject details.
 	if subjects != _EMPTY_ {
-		if mss := mset.store.SubjectsState(subjects); len(mss) > 0 {
+		// Only select what we need for this page so we do not materialize all subjects.
+		if mss, total := mset.store.SubjectsStateRange(subjects, _EMPTY_, offset+JSMaxSubjectDetails); total > 0 {
 			// As go iterates over map in a non-consistent order, no choice but to buffer it a slice
-
 			buffer := make([]string, 0, len(mss))
 			for subj := range mss {
 				buffer = append(buffer, subj)
@@ -1858,25 +2427,25 @@ func (s *Server) jsStreamInfoRequest(sub *subscription, c *client, a *Account, s
 				offset = len(buffer)
 			}
 
-			end := offset + JSMaxSubjectDetails
-			if end > len(buffer) {
-				end = len(buffer)
-			}
-
-			actualSize := end - offset
-			var sd map[string]uint64
-
-			if actualSize > 0 {
+			var sd, sb map[string]uint64
+			if actualSize := len(buffer) - offset; actualSize > 0 {
 				sd = make(map[string]uint64, actualSize)
-				for _, ss := range buffer[offset:end] {
+				if subjectBytes {
+					sb = make(map[string]uint64, actualSize)
+				}
+				for _, ss := range buffer[offset:] {
 					sd[ss] = mss[ss].Msgs
+					if sb != nil {
+						sb[ss] = mss[ss].Bytes
+					}
 				}
 			}
 
 			resp.StreamInfo.State.Subjects = sd
+			resp.StreamInfo.State.SubjectBytes = sb
 			resp.Offset = offset
 			resp.Limit = JSMaxSubjectDetails
-			resp.Total = len(mss)
+			resp.Total = total
 		}
 
 	}
@@ -2710,6 +3279,64 @@ func (s *Server) jsLeaderStepDownRequest(sub *subscription, c *client, _ *Accoun
 	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
 }
 
+// jsAPIRequestDenied checks requests to the JetStream API endpoints that take
+// actions which can be permissioned on their own, returning the subject that
+// was denied if any. Purges of a subject need publish permissions for the
+// extended purge endpoint of that subject, wildcards included, and deletes that
+// erase the message need them for the erase subject. Only subjects a client is
+// explicitly denied are enforced for the endpoints that predate these. Requests
+// to those we can not parse are denied.
+func (c *client) jsAPIRequestDenied(subject string, msg []byte) string {
+	if c.kind != CLIENT || !strings.HasPrefix(subject, jsStreamAPIPre) {
+		return _EMPTY_
+	}
+	// Strip headers and trailing CRLF.
+	if c.pa.hdr > 0 && c.pa.hdr <= len(msg) {
+		msg = msg[c.pa.hdr:]
+	}
+	if len(msg) >= LEN_CR_LF {
+		msg = msg[:len(msg)-LEN_CR_LF]
+	}
+
+	tokens := strings.Split(subject, tsep)
+	switch {
+	case len(tokens) > 5 && tokens[3] == "PURGE":
+		filter := strings.Join(tokens[5:], tsep)
+		if psubj := fmt.Sprintf(JSApiStreamPurgeExT, tokens[4], filter); !c.pubAllowedAll(psubj, true) {
+			return psubj
+		}
+	case len(tokens) == 5 && tokens[3] == "PURGE":
+		var req JSApiStreamPurgeRequest
+		if isEmptyRequest(msg) {
+			return _EMPTY_
+		}
+		if json.Unmarshal(msg, &req) != nil {
+			return subject
+		}
+		if req.Subject == _EMPTY_ {
+			return _EMPTY_
+		}
+		if psubj := fmt.Sprintf(JSApiStreamPurgeExT, tokens[4], req.Subject); !c.pubAllowedAll(psubj, false) {
+			return psubj
+		}
+	case len(tokens) == 6 && tokens[3] == "MSG" && tokens[4] == "DELETE":
+		var req JSApiMsgDeleteRequest
+		if isEmptyRequest(msg) {
+			return _EMPTY_
+		}
+		if json.Unmarshal(msg, &req) != nil {
+			return subject
+		}
+		if req.NoErase {
+			return _EMPTY_
+		}
+		if esubj := fmt.Sprintf(JSApiMsgEraseT, tokens[5]); !c.pubAllowedAll(esubj, false) {
+			return esubj
+		}
+	}
+	return _EMPTY_
+}
+
 func isEmptyRequest(req []byte) bool {
 	if len(req) == 0 {
 		return true
@@ -2786,9 +3413,34 @@ func (s *Server) jsStreamDeleteRequest(sub *subscription, c *client, _ *Account,
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
-
-	if err := mset.delete(); err != nil {
-		resp.Error = NewJSStreamDeleteError(err, Unless(err))
+	if apiErr := acc.checkStreamUnlocked(&mset.cfg); apiErr != nil {
+		resp.Error = apiErr
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	if mset.config().PartitionOf != _EMPTY_ {
+		resp.Error = NewJSStreamDeleteError(errors.New("stream partitions are deleted with their stream"))
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	// Accounts with a trash retention keep deleted file based streams around so they can be restored.
+	// Memory streams are only here if created before the account had a trash retention.
+	retain := acc.trashRetention() > 0
+	trash := retain && mset.config().Storage == FileStorage
+	if retain && !trash {
+		s.Warnf("Memory stream '%s > %s' deleted without keeping it in the trash", acc.Name, stream)
+	}
+	if err = mset.removePartitions(trash); err == nil {
+		if trash {
+			err = mset.trash()
+		} else {
+			err = mset.delete()
+		}
+	}
+	if err != nil {
+		resp.Error = NewJSStreamDeleteError(err, Unless(err))
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
@@ -2796,6 +3448,172 @@ func (s *Server) jsStreamDeleteRequest(sub *subscription, c *client, _ *Account,
 	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
 }
 
+// Request to create, update or delete many streams at once.
+func (s *Server) jsStreamBulkRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	var resp = JSApiStreamBulkResponse{ApiResponse: ApiResponse{Type: JSApiStreamBulkResponseType}}
+
+	// Determine if we should proceed here when we are in clustered mode.
+	if s.JetStreamIsClustered() {
+		js, cc := s.getJetStreamCluster()
+		if js == nil || cc == nil {
+			return
+		}
+		if js.isLeaderless() {
+			resp.Error = NewJSClusterNotAvailError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		// Make sure we are meta leader.
+		if !s.JetStreamIsLeader() {
+			return
+		}
+	}
+
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+
+	var req JSApiStreamBulkRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		resp.Error = NewJSInvalidJSONError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	op := tokenAt(subject, 5)
+	if resp.Failed, resp.Error = checkStreamBulkRequest(op, &req); resp.Error != nil {
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	// Handle clustered version here.
+	if s.JetStreamIsClustered() {
+		// Always do in separate Go routine, since we wait for the streams to be assigned.
+		go s.jsClusteredStreamBulkRequest(ci, acc, op, subject, reply, copyBytes(rmsg), &req)
+		return
+	}
+
+	if resp.Failed, resp.Error = s.jsStreamBulkLocal(acc, op, &req); resp.Error != nil {
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	resp.Streams = req.streamNames()
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Request to list the deleted streams that are still in the trash.
+func (s *Server) jsStreamTrashRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	var resp = JSApiStreamTrashResponse{ApiResponse: ApiResponse{Type: JSApiStreamTrashResponseType}}
+
+	// The trash is local to each server, so not available in clustered mode.
+	if s.JetStreamIsClustered() {
+		if !s.JetStreamIsLeader() {
+			return
+		}
+		resp.Error = NewJSClusterUnSupportFeatureError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+
+	if !isEmptyRequest(msg) {
+		resp.Error = NewJSNotEmptyRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	_, jsa, err := acc.checkForJetStream()
+	if err != nil {
+		resp.Error = NewJSNotEnabledForAccountError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	resp.Streams = jsa.trashedStreams()
+	if resp.Streams == nil {
+		resp.Streams = []*TrashedStream{}
+	}
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Request to restore a deleted stream from the trash.
+func (s *Server) jsStreamUndeleteRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	var resp = JSApiStreamUndeleteResponse{ApiResponse: ApiResponse{Type: JSApiStreamUndeleteResponseType}}
+
+	// The trash is local to each server, so not available in clustered mode.
+	if s.JetStreamIsClustered() {
+		if !s.JetStreamIsLeader() {
+			return
+		}
+		resp.Error = NewJSClusterUnSupportFeatureError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+
+	if !isEmptyRequest(msg) {
+		resp.Error = NewJSNotEmptyRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	mset, err := acc.undeleteStream(streamNameFromSubject(subject))
+	if err != nil {
+		resp.Error = NewJSStreamRestoreError(err, Unless(err))
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	resp.StreamInfo = &StreamInfo{
+		Created: mset.createdTime(),
+		State:   mset.state(),
+		Config:  mset.config(),
+	}
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
 // Request to delete a message.
 // This expects a stream sequence number as the msg body.
 func (s *Server) jsMsgDeleteRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
@@ -2896,12 +3714,19 @@ func (s *Server) jsMsgDeleteRequest(sub *subscription, c *client, _ *Account, su
 	}
 
 	if s.JetStreamIsClustered() {
+		if req.Tombstone {
+			resp.Error = NewJSStreamMsgDeleteFailedError(errTombstoneClustered)
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
 		s.jsClusteredMsgDeleteRequest(ci, acc, mset, stream, subject, reply, &req, rmsg)
 		return
 	}
 
 	var removed bool
-	if req.NoErase {
+	if req.Tombstone {
+		removed, err = mset.removeMsgWithTombstone(req.Seq, !req.NoErase, req.Reason)
+	} else if req.NoErase {
 		removed, err = mset.removeMsg(req.Seq)
 	} else {
 		removed, err = mset.eraseMsg(req.Seq)
@@ -2916,8 +3741,8 @@ func (s *Server) jsMsgDeleteRequest(sub *subscription, c *client, _ *Account, su
 	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
 }
 
-// Request to get a raw stream message.
-func (s *Server) jsMsgGetRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+// Request to delete a range of messages.
+func (s *Server) jsMsgDeleteRangeRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
 	if c == nil || !s.JetStreamEnabled() {
 		return
 	}
@@ -2927,9 +3752,9 @@ func (s *Server) jsMsgGetRequest(sub *subscription, c *client, _ *Account, subje
 		return
 	}
 
-	stream := tokenAt(subject, 6)
+	stream := tokenAt(subject, 7)
 
-	var resp = JSApiMsgGetResponse{ApiResponse: ApiResponse{Type: JSApiMsgGetResponseType}}
+	var resp = JSApiMsgDeleteRangeResponse{ApiResponse: ApiResponse{Type: JSApiMsgDeleteRangeResponseType}}
 
 	// If we are in clustered mode we need to be the stream leader to proceed.
 	if s.JetStreamIsClustered() {
@@ -2990,62 +3815,43 @@ func (s *Server) jsMsgGetRequest(sub *subscription, c *client, _ *Account, subje
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
-	var req JSApiMsgGetRequest
+	var req JSApiMsgDeleteRangeRequest
 	if err := json.Unmarshal(msg, &req); err != nil {
 		resp.Error = NewJSInvalidJSONError()
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
 
-	// Check that we do not have both options set.
-	if req.Seq > 0 && req.LastFor != _EMPTY_ || req.Seq == 0 && req.LastFor == _EMPTY_ && req.NextFor == _EMPTY_ {
-		resp.Error = NewJSBadRequestError()
+	mset, err := acc.lookupStream(stream)
+	if err != nil {
+		resp.Error = NewJSStreamNotFoundError(Unless(err))
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
-	// Check that both last and next not both set.
-	if req.LastFor != _EMPTY_ && req.NextFor != _EMPTY_ {
-		resp.Error = NewJSBadRequestError()
+	if mset.cfg.Sealed {
+		resp.Error = NewJSStreamSealedError()
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
-
-	mset, err := acc.lookupStream(stream)
-	if err != nil {
-		resp.Error = NewJSStreamNotFoundError()
+	if mset.cfg.DenyDelete {
+		resp.Error = NewJSStreamMsgDeleteFailedError(errors.New("message delete not permitted"))
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
 
-	var svp StoreMsg
-	var sm *StoreMsg
-
-	if req.Seq > 0 && req.NextFor == _EMPTY_ {
-		sm, err = mset.store.LoadMsg(req.Seq, &svp)
-	} else if req.NextFor != _EMPTY_ {
-		sm, _, err = mset.store.LoadNextMsg(req.NextFor, subjectHasWildcard(req.NextFor), req.Seq, &svp)
-	} else {
-		sm, err = mset.store.LoadLastMsg(req.LastFor, &svp)
-	}
-	if err != nil {
-		resp.Error = NewJSNoMessageFoundError()
-		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+	if s.JetStreamIsClustered() {
+		s.jsClusteredMsgDeleteRangeRequest(ci, acc, mset, stream, subject, reply, &req, rmsg)
 		return
 	}
-	resp.Message = &StoredMsg{
-		Subject:  sm.subj,
-		Sequence: sm.seq,
-		Header:   sm.hdr,
-		Data:     sm.msg,
-		Time:     time.Unix(0, sm.ts).UTC(),
-	}
 
-	// Don't send response through API layer for this call.
-	s.sendInternalAccountMsg(nil, reply, s.jsonResponse(resp))
+	if resp.Removed, err = mset.removeRange(req.First, req.Last); err != nil {
+		resp.Error = NewJSStreamMsgDeleteFailedError(err, Unless(err))
+	}
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
 }
 
-// Request to purge a stream.
-func (s *Server) jsStreamPurgeRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+// Request to get a raw stream message.
+func (s *Server) jsMsgGetRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
 	if c == nil || !s.JetStreamEnabled() {
 		return
 	}
@@ -3055,9 +3861,9 @@ func (s *Server) jsStreamPurgeRequest(sub *subscription, c *client, _ *Account,
 		return
 	}
 
-	stream := streamNameFromSubject(subject)
+	stream := tokenAt(subject, 6)
 
-	var resp = JSApiStreamPurgeResponse{ApiResponse: ApiResponse{Type: JSApiStreamPurgeResponseType}}
+	var resp = JSApiMsgGetResponse{ApiResponse: ApiResponse{Type: JSApiMsgGetResponseType}}
 
 	// If we are in clustered mode we need to be the stream leader to proceed.
 	if s.JetStreamIsClustered() {
@@ -3066,6 +3872,799 @@ func (s *Server) jsStreamPurgeRequest(sub *subscription, c *client, _ *Account,
 		if js == nil || cc == nil {
 			return
 		}
+		if js.isLeaderless() {
+			resp.Error = NewJSClusterNotAvailError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+
+		js.mu.RLock()
+		isLeader, sa := cc.isLeader(), js.streamAssignment(acc.Name, stream)
+		js.mu.RUnlock()
+
+		if isLeader && sa == nil {
+			// We can't find the stream, so mimic what would be the errors below.
+			if hasJS, doErr := acc.checkJetStream(); !hasJS {
+				if doErr {
+					resp.Error = NewJSNotEnabledForAccountError()
+					s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+				}
+				return
+			}
+			// No stream present.
+			resp.Error = NewJSStreamNotFoundError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		} else if sa == nil {
+			return
+		}
+
+		// Check to see if we are a member of the group and if the group has no leader.
+		if js.isGroupLeaderless(sa.Group) {
+			resp.Error = NewJSClusterNotAvailError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+
+		// We have the stream assigned and a leader, so only the stream leader should answer.
+		if !acc.JetStreamIsStreamLeader(stream) {
+			return
+		}
+	}
+
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+	if isEmptyRequest(msg) {
+		resp.Error = NewJSBadRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	var req JSApiMsgGetRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		resp.Error = NewJSInvalidJSONError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	// Check that we do not have both options set.
+	if req.Seq > 0 && req.LastFor != _EMPTY_ || req.Seq == 0 && req.LastFor == _EMPTY_ && req.NextFor == _EMPTY_ && req.StartTime == nil {
+		resp.Error = NewJSBadRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	// Check that both last and next not both set.
+	if req.LastFor != _EMPTY_ && req.NextFor != _EMPTY_ {
+		resp.Error = NewJSBadRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	// A start time replaces the sequence and can not be used with last.
+	if req.StartTime != nil && (req.Seq > 0 || req.LastFor != _EMPTY_) {
+		resp.Error = NewJSBadRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	mset, err := acc.lookupStream(stream)
+	if err != nil {
+		resp.Error = NewJSStreamNotFoundError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	var svp StoreMsg
+	var sm *StoreMsg
+
+	if req.StartTime != nil {
+		sm, err = loadNextMsgFromTime(mset.store, *req.StartTime, req.NextFor, &svp)
+	} else if req.Seq > 0 && req.NextFor == _EMPTY_ {
+		sm, err = mset.store.LoadMsg(req.Seq, &svp)
+	} else if req.NextFor != _EMPTY_ {
+		sm, _, err = mset.store.LoadNextMsg(req.NextFor, subjectHasWildcard(req.NextFor), req.Seq, &svp)
+	} else {
+		sm, err = mset.store.LoadLastMsg(req.LastFor, &svp)
+	}
+	if err != nil {
+		resp.Error = NewJSNoMessageFoundError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	resp.Message = &StoredMsg{
+		Subject:  sm.subj,
+		Sequence: sm.seq,
+		Header:   sm.hdr,
+		Data:     sm.msg,
+		Time:     time.Unix(0, sm.ts).UTC(),
+	}
+
+	// Don't send response through API layer for this call.
+	s.sendInternalAccountMsg(nil, reply, s.jsonResponse(resp))
+}
+
+// Request to purge a stream.
+// Request to start, query or cancel an asynchronous stream compaction.
+func (s *Server) jsStreamCompactRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	stream := streamNameFromSubject(subject)
+
+	var resp = JSApiStreamCompactResponse{ApiResponse: ApiResponse{Type: JSApiStreamCompactResponseType}}
+
+	// If we are in clustered mode only the stream leader will answer.
+	if s.JetStreamIsClustered() && !acc.JetStreamIsStreamLeader(stream) {
+		return
+	}
+
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+
+	var req JSApiStreamCompactRequest
+	if !isEmptyRequest(msg) {
+		if err := json.Unmarshal(msg, &req); err != nil {
+			resp.Error = NewJSInvalidJSONError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+	}
+
+	mset, err := acc.lookupStream(stream)
+	if err != nil {
+		resp.Error = NewJSStreamNotFoundError(Unless(err))
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	h := mset.compaction()
+	if req.Sequence > 0 {
+		if mset.cfg.Sealed {
+			resp.Error = NewJSStreamSealedError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		if mset.cfg.DenyPurge {
+			resp.Error = NewJSStreamPurgeFailedError(errors.New("stream purge not permitted"))
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		if h, err = mset.compactAsync(req.Sequence); err != nil {
+			resp.Error = NewJSStreamCompactNotSupportedError(Unless(err))
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+	} else if h == nil {
+		resp.Error = NewJSStreamCompactNotSupportedError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	} else if req.Cancel {
+		h.Cancel()
+	}
+
+	progress := h.Progress()
+	resp.Progress = &progress
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Request to store synthetic messages directly into a stream, to seed test environments.
+// Disabled unless configured.
+func (s *Server) jsStreamGenerateRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	stream := streamNameFromSubject(subject)
+
+	var resp = JSApiStreamGenerateResponse{ApiResponse: ApiResponse{Type: JSApiStreamGenerateResponseType}}
+
+	// If we are in clustered mode only the stream leader will answer.
+	if s.JetStreamIsClustered() && !acc.JetStreamIsStreamLeader(stream) {
+		return
+	}
+
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+
+	if !s.getOpts().JetStreamDataGenerator {
+		resp.Error = NewJSStreamGeneratorDisabledError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	var req JSApiStreamGenerateRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		resp.Error = NewJSInvalidJSONError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	mset, err := acc.lookupStream(stream)
+	if err != nil {
+		resp.Error = NewJSStreamNotFoundError(Unless(err))
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	gen, err := mset.newMsgGenerator(&req)
+	if err != nil {
+		resp.Error = NewJSStreamGeneralError(err, Unless(err))
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	// This can take a while, so do not hold up the API.
+	request := string(msg)
+	s.startGoRoutine(func() {
+		defer s.grWG.Done()
+		start := time.Now()
+		resp.Generated, resp.FirstSeq, resp.LastSeq, err = mset.generateMsgs(gen)
+		resp.Duration = time.Since(start)
+		if err != nil {
+			resp.Error = NewJSStreamGeneralError(err, Unless(err))
+			s.sendAPIErrResponse(ci, acc, subject, reply, request, s.jsonResponse(&resp))
+			return
+		}
+		s.sendAPIResponse(ci, acc, subject, reply, request, s.jsonResponse(resp))
+	})
+}
+
+// Request to get the age histogram and subject prefix breakdown of a stream.
+func (s *Server) jsStreamUsageRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	stream := streamNameFromSubject(subject)
+
+	var resp = JSApiStreamUsageResponse{ApiResponse: ApiResponse{Type: JSApiStreamUsageResponseType}}
+
+	// If we are in clustered mode only the stream leader will answer.
+	if s.JetStreamIsClustered() && !acc.JetStreamIsStreamLeader(stream) {
+		return
+	}
+
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+
+	var req JSApiStreamUsageRequest
+	if !isEmptyRequest(msg) {
+		if err := json.Unmarshal(msg, &req); err != nil {
+			resp.Error = NewJSInvalidJSONError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+	}
+	if len(req.AgeBuckets) == 0 {
+		req.AgeBuckets = defaultStreamUsageAgeBuckets
+	}
+	for i, bound := range req.AgeBuckets {
+		if bound <= 0 || i > 0 && bound <= req.AgeBuckets[i-1] {
+			resp.Error = NewJSBadRequestError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+	}
+	if req.PrefixDepth < 0 {
+		resp.Error = NewJSBadRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	} else if req.PrefixDepth == 0 {
+		req.PrefixDepth = 1
+	}
+
+	mset, err := acc.lookupStream(stream)
+	if err != nil {
+		resp.Error = NewJSStreamNotFoundError(Unless(err))
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	resp.Ages = mset.store.AgeHistogram(req.AgeBuckets)
+	pu := mset.store.PrefixUsage(req.PrefixDepth)
+	resp.Prefixes = make([]*SubjectPrefixUsage, 0, len(pu))
+	for _, u := range pu {
+		resp.Prefixes = append(resp.Prefixes, u)
+	}
+	// Largest first, so the subjects worth tuning retention for are at the top.
+	sort.Slice(resp.Prefixes, func(i, j int) bool {
+		if resp.Prefixes[i].Bytes != resp.Prefixes[j].Bytes {
+			return resp.Prefixes[i].Bytes > resp.Prefixes[j].Bytes
+		}
+		return resp.Prefixes[i].Prefix < resp.Prefixes[j].Prefix
+	})
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+func (s *Server) jsStreamPurgeRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	stream := streamNameFromSubject(subject)
+
+	// The extended endpoint carries the subject to purge.
+	var filter string
+	if numTokens(subject) > 5 {
+		filter = strings.Join(strings.Split(subject, tsep)[5:], tsep)
+	}
+
+	var resp = JSApiStreamPurgeResponse{ApiResponse: ApiResponse{Type: JSApiStreamPurgeResponseType}}
+
+	// If we are in clustered mode we need to be the stream leader to proceed.
+	if s.JetStreamIsClustered() {
+		// Check to make sure the stream is assigned.
+		js, cc := s.getJetStreamCluster()
+		if js == nil || cc == nil {
+			return
+		}
+
+		js.mu.RLock()
+		isLeader, sa := cc.isLeader(), js.streamAssignment(acc.Name, stream)
+		js.mu.RUnlock()
+
+		if isLeader && sa == nil {
+			// We can't find the stream, so mimic what would be the errors below.
+			if hasJS, doErr := acc.checkJetStream(); !hasJS {
+				if doErr {
+					resp.Error = NewJSNotEnabledForAccountError()
+					s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+				}
+				return
+			}
+			// No stream present.
+			resp.Error = NewJSStreamNotFoundError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		} else if sa == nil {
+			if js.isLeaderless() {
+				resp.Error = NewJSClusterNotAvailError()
+				s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			}
+			return
+		}
+
+		// Check to see if we are a member of the group and if the group has no leader.
+		if js.isGroupLeaderless(sa.Group) {
+			resp.Error = NewJSClusterNotAvailError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+
+		// We have the stream assigned and a leader, so only the stream leader should answer.
+		if !acc.JetStreamIsStreamLeader(stream) {
+			if js.isLeaderless() {
+				resp.Error = NewJSClusterNotAvailError()
+				s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			}
+			return
+		}
+	}
+
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+
+	var purgeRequest *JSApiStreamPurgeRequest
+	if !isEmptyRequest(msg) {
+		var req JSApiStreamPurgeRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			resp.Error = NewJSInvalidJSONError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		if req.Sequence > 0 && req.Keep > 0 {
+			resp.Error = NewJSBadRequestError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		purgeRequest = &req
+	}
+	if filter != _EMPTY_ {
+		if purgeRequest == nil {
+			purgeRequest = &JSApiStreamPurgeRequest{}
+		}
+		if purgeRequest.Subject != _EMPTY_ && purgeRequest.Subject != filter {
+			resp.Error = NewJSStreamPurgeFailedError(errors.New("purge filter does not match subject"))
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		purgeRequest.Subject = filter
+	}
+
+	mset, err := acc.lookupStream(stream)
+	if err != nil {
+		resp.Error = NewJSStreamNotFoundError(Unless(err))
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if mset.cfg.Sealed {
+		resp.Error = NewJSStreamSealedError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if mset.cfg.DenyPurge {
+		resp.Error = NewJSStreamPurgeFailedError(errors.New("stream purge not permitted"))
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if apiErr := acc.checkStreamUnlocked(&mset.cfg); apiErr != nil {
+		resp.Error = apiErr
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	if s.JetStreamIsClustered() {
+		s.jsClusteredStreamPurgeRequest(ci, acc, mset, stream, subject, reply, rmsg, purgeRequest)
+		return
+	}
+
+	purged, err := mset.purge(purgeRequest)
+	if err != nil {
+		resp.Error = NewJSStreamGeneralError(err, Unless(err))
+	} else {
+		resp.Purged = purged
+		resp.Success = true
+	}
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Request to rewrite the subjects of messages stored in a stream.
+func (s *Server) jsStreamRemapRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	stream := streamNameFromSubject(subject)
+
+	var resp = JSApiStreamRemapResponse{ApiResponse: ApiResponse{Type: JSApiStreamRemapResponseType}}
+
+	// If we are in clustered mode we need to be the stream leader to proceed.
+	if s.JetStreamIsClustered() {
+		// Check to make sure the stream is assigned.
+		js, cc := s.getJetStreamCluster()
+		if js == nil || cc == nil {
+			return
+		}
+
+		js.mu.RLock()
+		isLeader, sa := cc.isLeader(), js.streamAssignment(acc.Name, stream)
+		js.mu.RUnlock()
+
+		if isLeader && sa == nil {
+			// We can't find the stream, so mimic what would be the errors below.
+			if hasJS, doErr := acc.checkJetStream(); !hasJS {
+				if doErr {
+					resp.Error = NewJSNotEnabledForAccountError()
+					s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+				}
+				return
+			}
+			// No stream present.
+			resp.Error = NewJSStreamNotFoundError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		} else if sa == nil {
+			if js.isLeaderless() {
+				resp.Error = NewJSClusterNotAvailError()
+				s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			}
+			return
+		}
+
+		// Check to see if we are a member of the group and if the group has no leader.
+		if js.isGroupLeaderless(sa.Group) {
+			resp.Error = NewJSClusterNotAvailError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+
+		// We have the stream assigned and a leader, so only the stream leader should answer.
+		if !acc.JetStreamIsStreamLeader(stream) {
+			if js.isLeaderless() {
+				resp.Error = NewJSClusterNotAvailError()
+				s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			}
+			return
+		}
+	}
+
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+
+	if isEmptyRequest(msg) {
+		resp.Error = NewJSBadRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	var req JSApiStreamRemapRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		resp.Error = NewJSInvalidJSONError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	mset, err := acc.lookupStream(stream)
+	if err != nil {
+		resp.Error = NewJSStreamNotFoundError(Unless(err))
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if mset.cfg.Sealed {
+		resp.Error = NewJSStreamSealedError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if err := mset.checkRemapRequest(&req); err != nil {
+		resp.Error = NewJSStreamSubjectRemapInvalidError(err)
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	if s.JetStreamIsClustered() {
+		s.jsClusteredStreamRemapRequest(ci, acc, mset, stream, subject, reply, rmsg, &req)
+		return
+	}
+
+	remapped, err := mset.remapSubjects(req.Filter, req.Destination, mset.lastSeq())
+	if err != nil {
+		resp.Error = NewJSStreamGeneralError(err, Unless(err))
+	} else {
+		resp.Remapped = remapped
+		resp.Success = true
+	}
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Request to unlock a protected stream for the next delete or purge.
+func (s *Server) jsStreamUnlockRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	stream := streamNameFromSubject(subject)
+
+	var resp = JSApiStreamUnlockResponse{ApiResponse: ApiResponse{Type: JSApiStreamUnlockResponseType}}
+
+	// In clustered mode deletes are handled by the meta leader and purges by the stream leader,
+	// so every server will record the unlock but only the meta leader will answer.
+	isLeader := true
+	if s.JetStreamIsClustered() {
+		js, cc := s.getJetStreamCluster()
+		if js == nil || cc == nil {
+			return
+		}
+		if js.isLeaderless() {
+			resp.Error = NewJSClusterNotAvailError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		js.mu.RLock()
+		isLeader = cc.isLeader()
+		sa := js.streamAssignment(acc.Name, stream)
+		js.mu.RUnlock()
+		if sa == nil {
+			if isLeader {
+				resp.Error = NewJSStreamNotFoundError()
+				s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			}
+			return
+		}
+	}
+
+	_, jsa, err := acc.checkForJetStream()
+	if err != nil {
+		if isLeader {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+
+	if !isEmptyRequest(msg) {
+		if isLeader {
+			resp.Error = NewJSNotEmptyRequestError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+
+	if !s.JetStreamIsClustered() {
+		if _, err := acc.lookupStream(stream); err != nil {
+			resp.Error = NewJSStreamNotFoundError(Unless(err))
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+	}
+
+	resp.Expires = jsa.unlockStream(stream)
+	if isLeader {
+		s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+	}
+}
+
+// Request to freeze or thaw a stream.
+func (s *Server) jsStreamFreezeRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	stream := streamNameFromSubject(subject)
+
+	var resp = JSApiStreamFreezeResponse{ApiResponse: ApiResponse{Type: JSApiStreamFreezeResponseType}}
+
+	// If we are in clustered mode we need to be the stream leader to proceed.
+	if s.JetStreamIsClustered() {
+		// Check to make sure the stream is assigned.
+		js, cc := s.getJetStreamCluster()
+		if js == nil || cc == nil {
+			return
+		}
+		if js.isLeaderless() {
+			resp.Error = NewJSClusterNotAvailError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+
+		js.mu.RLock()
+		isLeader, sa := cc.isLeader(), js.streamAssignment(acc.Name, stream)
+		js.mu.RUnlock()
+
+		if isLeader && sa == nil {
+			// We can't find the stream, so mimic what would be the errors below.
+			if hasJS, doErr := acc.checkJetStream(); !hasJS {
+				if doErr {
+					resp.Error = NewJSNotEnabledForAccountError()
+					s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+				}
+				return
+			}
+			// No stream present.
+			resp.Error = NewJSStreamNotFoundError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		} else if sa == nil {
+			return
+		}
+
+		// Check to see if we are a member of the group and if the group has no leader.
+		if js.isGroupLeaderless(sa.Group) {
+			resp.Error = NewJSClusterNotAvailError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+
+		// We have the stream assigned and a leader, so only the stream leader should answer.
+		if !acc.JetStreamIsStreamLeader(stream) {
+			return
+		}
+	}
+
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		}
+		return
+	}
+	if isEmptyRequest(msg) {
+		resp.Error = NewJSBadRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	var req JSApiStreamFreezeRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		resp.Error = NewJSInvalidJSONError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	mset, err := acc.lookupStream(stream)
+	if err != nil {
+		resp.Error = NewJSStreamNotFoundError(Unless(err))
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	if s.JetStreamIsClustered() {
+		s.jsClusteredStreamFreezeRequest(ci, acc, mset, stream, subject, reply, &req, rmsg)
+		return
+	}
+
+	mset.setFrozen(req.Frozen)
+	resp.Frozen = mset.isFrozen()
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+func (s *Server) jsStreamHoldRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	stream := streamNameFromSubject(subject)
+
+	var resp = JSApiStreamHoldResponse{ApiResponse: ApiResponse{Type: JSApiStreamHoldResponseType}}
+
+	// If we are in clustered mode we need to be the stream leader to proceed.
+	if s.JetStreamIsClustered() {
+		// Check to make sure the stream is assigned.
+		js, cc := s.getJetStreamCluster()
+		if js == nil || cc == nil {
+			return
+		}
+		if js.isLeaderless() {
+			resp.Error = NewJSClusterNotAvailError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
 
 		js.mu.RLock()
 		isLeader, sa := cc.isLeader(), js.streamAssignment(acc.Name, stream)
@@ -3085,10 +4684,6 @@ func (s *Server) jsStreamPurgeRequest(sub *subscription, c *client, _ *Account,
 			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 			return
 		} else if sa == nil {
-			if js.isLeaderless() {
-				resp.Error = NewJSClusterNotAvailError()
-				s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
-			}
 			return
 		}
 
@@ -3101,10 +4696,6 @@ func (s *Server) jsStreamPurgeRequest(sub *subscription, c *client, _ *Account,
 
 		// We have the stream assigned and a leader, so only the stream leader should answer.
 		if !acc.JetStreamIsStreamLeader(stream) {
-			if js.isLeaderless() {
-				resp.Error = NewJSClusterNotAvailError()
-				s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
-			}
 			return
 		}
 	}
@@ -3116,21 +4707,13 @@ func (s *Server) jsStreamPurgeRequest(sub *subscription, c *client, _ *Account,
 		}
 		return
 	}
-
-	var purgeRequest *JSApiStreamPurgeRequest
+	var req JSApiStreamHoldRequest
 	if !isEmptyRequest(msg) {
-		var req JSApiStreamPurgeRequest
 		if err := json.Unmarshal(msg, &req); err != nil {
 			resp.Error = NewJSInvalidJSONError()
 			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 			return
 		}
-		if req.Sequence > 0 && req.Keep > 0 {
-			resp.Error = NewJSBadRequestError()
-			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
-			return
-		}
-		purgeRequest = &req
 	}
 
 	mset, err := acc.lookupStream(stream)
@@ -3139,32 +4722,305 @@ func (s *Server) jsStreamPurgeRequest(sub *subscription, c *client, _ *Account,
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
-	if mset.cfg.Sealed {
-		resp.Error = NewJSStreamSealedError()
+
+	// Without a subject we just list the holds.
+	if req.Subject == _EMPTY_ {
+		if req.Release {
+			resp.Error = NewJSStreamHoldInvalidError(errors.New("subject is required"))
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		resp.Holds = mset.subjectHolds()
+		s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+		return
+	}
+	if !IsValidSubject(req.Subject) {
+		resp.Error = NewJSStreamHoldInvalidError(fmt.Errorf("invalid subject %q", req.Subject))
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
-	if mset.cfg.DenyPurge {
-		resp.Error = NewJSStreamPurgeFailedError(errors.New("stream purge not permitted"))
+
+	if s.JetStreamIsClustered() {
+		s.jsClusteredStreamHoldRequest(ci, acc, mset, stream, subject, reply, &req, rmsg)
+		return
+	}
+
+	holds, err := mset.setSubjectHold(req.Subject, req.Reason, req.Release, time.Now().UTC())
+	if err != nil {
+		if apiErr, ok := err.(*ApiError); ok {
+			resp.Error = apiErr
+		} else {
+			resp.Error = NewJSStreamHoldInvalidError(err)
+		}
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
+	resp.Holds = holds
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Returns the stream and, for consumer endpoints, the consumer a config history request is for.
+func configHistoryTarget(subject string) (string, string) {
+	if tokenAt(subject, 3) == "CONSUMER" {
+		return streamNameFromSubject(subject), consumerNameFromSubject(subject)
+	}
+	// $JS.API.STREAM.CONFIG.HISTORY.<stream>
+	if tokenAt(subject, 4) == "CONFIG" {
+		return tokenAt(subject, 6), _EMPTY_
+	}
+	return streamNameFromSubject(subject), _EMPTY_
+}
 
+// Checks a request answered from the stream and consumer assignments can be handled here.
+// Returns false if it should be dropped. When clustered only the meta leader responds.
+func (s *Server) checkMetaStateRequest(ci *ClientInfo, acc *Account, subject, reply, msg string, resp *ApiResponse) bool {
 	if s.JetStreamIsClustered() {
-		s.jsClusteredStreamPurgeRequest(ci, acc, mset, stream, subject, reply, rmsg, purgeRequest)
+		js, cc := s.getJetStreamCluster()
+		if js == nil || cc == nil {
+			return false
+		}
+		if js.isLeaderless() {
+			resp.Error = NewJSClusterNotAvailError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, msg, s.jsonResponse(resp))
+			return false
+		}
+		if !s.JetStreamIsLeader() {
+			return false
+		}
+	}
+	if hasJS, doErr := acc.checkJetStream(); !hasJS {
+		if doErr {
+			resp.Error = NewJSNotEnabledForAccountError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, msg, s.jsonResponse(resp))
+		}
+		return false
+	}
+	return true
+}
+
+// Request to compare the streams and consumers of an account to a declared state.
+func (s *Server) jsAccountDriftRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
 		return
 	}
 
-	purged, err := mset.purge(purgeRequest)
+	var resp = JSApiAccountDriftResponse{ApiResponse: ApiResponse{Type: JSApiAccountDriftResponseType}}
+	if !s.checkMetaStateRequest(ci, acc, subject, reply, string(msg), &resp.ApiResponse) {
+		return
+	}
+	var req JSApiAccountDriftRequest
+	if !isEmptyRequest(msg) {
+		if err := json.Unmarshal(msg, &req); err != nil {
+			resp.Error = NewJSInvalidJSONError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+	}
+
+	if resp.Drift, resp.Error = s.accountDrift(acc, req.Streams); resp.Error != nil {
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	resp.InSync = len(resp.Drift) == 0
+	if resp.Drift == nil {
+		resp.Drift = []*AssetDrift{}
+	}
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Request for the config revisions of a stream or consumer.
+func (s *Server) jsConfigHistoryRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
 	if err != nil {
-		resp.Error = NewJSStreamGeneralError(err, Unless(err))
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	var resp = JSApiConfigHistoryResponse{ApiResponse: ApiResponse{Type: JSApiConfigHistoryResponseType}}
+	if !s.checkMetaStateRequest(ci, acc, subject, reply, string(msg), &resp.ApiResponse) {
+		return
+	}
+
+	stream, consumer := configHistoryTarget(subject)
+	if resp.Revisions, resp.Error = s.configRevisions(acc, stream, consumer); resp.Error != nil {
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Request to compare two config revisions of a stream or consumer.
+func (s *Server) jsConfigDiffRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	var resp = JSApiConfigDiffResponse{ApiResponse: ApiResponse{Type: JSApiConfigDiffResponseType}}
+	if !s.checkMetaStateRequest(ci, acc, subject, reply, string(msg), &resp.ApiResponse) {
+		return
+	}
+	if isEmptyRequest(msg) {
+		resp.Error = NewJSBadRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	var req JSApiConfigDiffRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		resp.Error = NewJSInvalidJSONError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	stream, consumer := configHistoryTarget(subject)
+	revs, apiErr := s.configRevisions(acc, stream, consumer)
+	if apiErr != nil {
+		resp.Error = apiErr
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	from, to := findConfigRevision(revs, req.From), findConfigRevision(revs, req.To)
+	if req.From == 0 || from == nil || to == nil {
+		resp.Error = NewJSConfigRevisionNotFoundError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	resp.From, resp.To = from.Revision, to.Revision
+	resp.Changes = diffConfigs(from.Config, to.Config)
+	if resp.Changes == nil {
+		resp.Changes = []*ConfigChange{}
+	}
+	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+}
+
+// Request to update a stream or consumer back to a prior config revision.
+func (s *Server) jsConfigRollbackRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	stream, consumer := configHistoryTarget(subject)
+
+	var resp interface{}
+	var apiResp *ApiResponse
+	if consumer == _EMPTY_ {
+		r := &JSApiStreamUpdateResponse{ApiResponse: ApiResponse{Type: JSApiStreamUpdateResponseType}}
+		resp, apiResp = r, &r.ApiResponse
 	} else {
-		resp.Purged = purged
-		resp.Success = true
+		r := &JSApiConsumerCreateResponse{ApiResponse: ApiResponse{Type: JSApiConsumerCreateResponseType}}
+		resp, apiResp = r, &r.ApiResponse
+	}
+	sendErr := func(e *ApiError) {
+		apiResp.Error = e
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+	}
+
+	if !s.checkMetaStateRequest(ci, acc, subject, reply, string(msg), apiResp) {
+		return
+	}
+	if isEmptyRequest(msg) {
+		sendErr(NewJSBadRequestError())
+		return
+	}
+	var req JSApiConfigRollbackRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		sendErr(NewJSInvalidJSONError())
+		return
+	}
+
+	revs, apiErr := s.configRevisions(acc, stream, consumer)
+	if apiErr != nil {
+		sendErr(apiErr)
+		return
+	}
+	rev := findConfigRevision(revs, req.Revision)
+	if req.Revision == 0 || rev == nil {
+		sendErr(NewJSConfigRevisionNotFoundError())
+		return
+	}
+
+	isClustered := s.JetStreamIsClustered()
+
+	if consumer == _EMPTY_ {
+		var ncfg StreamConfig
+		if err := json.Unmarshal(rev.Config, &ncfg); err != nil {
+			sendErr(NewJSInvalidJSONError())
+			return
+		}
+		cfg, apiErr := s.checkStreamCfg(&ncfg, acc)
+		if apiErr != nil {
+			sendErr(apiErr)
+			return
+		}
+		if isClustered {
+			go s.jsClusteredStreamUpdateRequest(ci, acc, subject, reply, copyBytes(rmsg), &cfg, nil)
+			return
+		}
+		r := resp.(*JSApiStreamUpdateResponse)
+		if r.StreamInfo, r.Error = s.jsStreamUpdateLocal(acc, &cfg); r.Error != nil {
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+			return
+		}
+		s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+		return
 	}
+
+	var cfg ConsumerConfig
+	if err := json.Unmarshal(rev.Config, &cfg); err != nil {
+		sendErr(NewJSInvalidJSONError())
+		return
+	}
+	if isClustered {
+		go s.jsClusteredConsumerRequest(ci, acc, subject, reply, copyBytes(rmsg), stream, &cfg)
+		return
+	}
+	mset, err := acc.lookupStream(stream)
+	if err != nil {
+		sendErr(NewJSStreamNotFoundError(Unless(err)))
+		return
+	}
+	o, err := mset.addConsumerWithHistory(&cfg)
+	if err != nil {
+		sendErr(NewJSConsumerCreateError(err, Unless(err)))
+		return
+	}
+	r := resp.(*JSApiConsumerCreateResponse)
+	r.ConsumerInfo = o.initialInfo()
 	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
 }
 
+// Returns an error if the stream is protected and was not unlocked, otherwise uses up the unlock.
+func (acc *Account) checkStreamUnlocked(cfg *StreamConfig) *ApiError {
+	if cfg == nil || !cfg.DeletionProtection {
+		return nil
+	}
+	_, jsa, err := acc.checkForJetStream()
+	if err != nil {
+		return NewJSNotEnabledForAccountError()
+	}
+	if !jsa.consumeStreamUnlock(cfg.Name) {
+		return NewJSStreamDeletionProtectedError()
+	}
+	return nil
+}
+
 func (acc *Account) jsNonClusteredStreamLimitsCheck(cfg *StreamConfig) *ApiError {
 	selectedLimits, tier, jsa, apiErr := acc.selectLimits(cfg)
 	if apiErr != nil {
@@ -3226,6 +5082,15 @@ func (s *Server) jsStreamRestoreRequest(sub *subscription, c *client, _ *Account
 		return
 	}
 
+	// Private keys are not allowed in clustered mode since the request is replicated.
+	if req.Encryption != nil {
+		if err := checkSnapshotOpener(req.Encryption, !s.JetStreamIsClustered()); err != nil {
+			resp.Error = NewJSSnapshotEncryptionInvalidError(err)
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+	}
+
 	if s.JetStreamIsClustered() {
 		s.jsClusteredStreamRestoreRequest(ci, acc, &req, stream, subject, reply, rmsg)
 		return
@@ -3251,14 +5116,26 @@ func (s *Server) jsStreamRestoreRequest(sub *subscription, c *client, _ *Account
 		return
 	}
 
-	s.processStreamRestore(ci, acc, &req.Config, subject, reply, string(msg))
+	s.processStreamRestore(ci, acc, &req.Config, req.Encryption, req.Manifest, subject, reply, string(msg))
 }
 
-func (s *Server) processStreamRestore(ci *ClientInfo, acc *Account, cfg *StreamConfig, subject, reply, msg string) <-chan error {
+// Encryption and manifest are optional and describe an encrypted snapshot and
+// what is expected to be received.
+func (s *Server) processStreamRestore(ci *ClientInfo, acc *Account, cfg *StreamConfig, se *SnapshotEncryption, manifest *SnapshotManifest, subject, reply, msg string) <-chan error {
 	js := s.getJetStream()
 
 	var resp = JSApiStreamRestoreResponse{ApiResponse: ApiResponse{Type: JSApiStreamRestoreResponseType}}
 
+	var aead cipher.AEAD
+	if se != nil {
+		var err error
+		if aead, err = s.openSnapshotKey(se); err != nil {
+			resp.Error = NewJSSnapshotEncryptionInvalidError(err)
+			s.sendAPIErrResponse(ci, acc, subject, reply, msg, s.jsonResponse(&resp))
+			return nil
+		}
+	}
+
 	snapDir := filepath.Join(js.config.StoreDir, snapStagingDir)
 	if _, err := os.Stat(snapDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(snapDir, defaultDirPerms); err != nil {
@@ -3304,6 +5181,7 @@ func (s *Server) processStreamRestore(ci *ClientInfo, acc *Account, cfg *StreamC
 	activeQ := s.newIPQueue(fmt.Sprintf("[ACC:%s] stream '%s' restore", acc.Name, streamName)) // of int
 
 	var total int
+	sd := newSnapshotDigest()
 
 	// FIXM(dlc) - Probably take out of network path eventually due to disk I/O?
 	processChunk := func(sub *subscription, c *client, _ *Account, subject, reply string, msg []byte) {
@@ -3331,6 +5209,10 @@ func (s *Server) processStreamRestore(ci *ClientInfo, acc *Account, cfg *StreamC
 		// This means we are complete with our transfer from the client.
 		if len(msg) == 0 {
 			s.Debugf("Finished staging restore for stream '%s > %s'", acc.Name, streamName)
+			var err error
+			if manifest != nil {
+				err = sd.verify(manifest)
+			}
 			resultCh <- result{err, reply}
 			return
 		}
@@ -3353,6 +5235,7 @@ func (s *Server) processStreamRestore(ci *ClientInfo, acc *Account, cfg *StreamC
 			return
 		}
 
+		sd.add(msg)
 		activeQ.push(len(msg))
 
 		s.sendInternalAccountMsg(acc, reply, nil)
@@ -3398,7 +5281,17 @@ func (s *Server) processStreamRestore(ci *ClientInfo, acc *Account, cfg *StreamC
 				if err == nil {
 					s.Debugf("Finalizing restore for stream '%s > %s'", acc.Name, streamName)
 					tfile.Seek(0, 0)
-					mset, err = acc.RestoreStream(cfg, tfile)
+					var r io.Reader = tfile
+					if aead != nil {
+						// Authenticate all of it before we restore anything.
+						if _, err = io.Copy(io.Discard, newSnapshotOpener(tfile, aead)); err == nil {
+							tfile.Seek(0, 0)
+							r = newSnapshotOpener(tfile, aead)
+						}
+					}
+					if err == nil {
+						mset, err = acc.RestoreStream(cfg, r)
+					}
 				} else {
 					errStr := err.Error()
 					tmp := []rune(errStr)
@@ -3513,6 +5406,17 @@ func (s *Server) jsStreamSnapshotRequest(sub *subscription, c *client, _ *Accoun
 		return
 	}
 
+	// If asked to encrypt, generate and seal the data key now.
+	var aead cipher.AEAD
+	if req.Encryption != nil {
+		resp.Encryption, aead, err = s.newSnapshotSealer(req.Encryption)
+		if err != nil {
+			resp.Error = NewJSSnapshotEncryptionInvalidError(err)
+			s.sendAPIErrResponse(ci, acc, subject, reply, smsg, s.jsonResponse(&resp))
+			return
+		}
+	}
+
 	// We will do the snapshot in a go routine as well since check msgs may
 	// stall this go routine.
 	go func() {
@@ -3551,7 +5455,7 @@ func (s *Server) jsStreamSnapshotRequest(sub *subscription, c *client, _ *Accoun
 		})
 
 		// Now do the real streaming.
-		s.streamSnapshot(ci, acc, mset, sr, &req)
+		s.streamSnapshot(ci, acc, mset, sr, &req, aead)
 
 		end := time.Now().UTC()
 
@@ -3581,15 +5485,21 @@ const defaultSnapshotChunkSize = 128 * 1024
 const defaultSnapshotWindowSize = 8 * 1024 * 1024 // 8MB
 
 // streamSnapshot will stream out our snapshot to the reply subject.
-func (s *Server) streamSnapshot(ci *ClientInfo, acc *Account, mset *stream, sr *SnapshotResult, req *JSApiStreamSnapshotRequest) {
+// If aead is not nil the snapshot is encrypted with it.
+func (s *Server) streamSnapshot(ci *ClientInfo, acc *Account, mset *stream, sr *SnapshotResult, req *JSApiStreamSnapshotRequest, aead cipher.AEAD) {
 	chunkSize := req.ChunkSize
 	if chunkSize == 0 {
 		chunkSize = defaultSnapshotChunkSize
 	}
 	// Setup for the chunk stream.
 	reply := req.DeliverSubject
-	r := sr.Reader
-	defer r.Close()
+	defer sr.Reader.Close()
+	var r io.Reader = sr.Reader
+	if aead != nil {
+		r = newSnapshotSealer(r, aead)
+	}
+	// Tracks what we send for the manifest on the last chunk.
+	sd := newSnapshotDigest()
 
 	// Check interest for the snapshot deliver subject.
 	inch := make(chan bool, 1)
@@ -3635,6 +5545,7 @@ func (s *Server) streamSnapshot(ci *ClientInfo, acc *Account, mset *stream, sr *
 		chunk = chunk[:n]
 		if err != nil {
 			if n > 0 {
+				sd.add(chunk)
 				mset.outq.send(newJSPubMsg(reply, _EMPTY_, _EMPTY_, nil, chunk, nil, 0))
 			}
 			break
@@ -3651,13 +5562,15 @@ func (s *Server) streamSnapshot(ci *ClientInfo, acc *Account, mset *stream, sr *
 			}
 		}
 		ackReply := fmt.Sprintf("%s.%d.%d", ackSubj, len(chunk), index)
+		sd.add(chunk)
 		mset.outq.send(newJSPubMsg(reply, _EMPTY_, ackReply, nil, chunk, nil, 0))
 		atomic.AddInt32(&out, int32(len(chunk)))
 	}
 done:
-	// Send last EOF
-	// TODO(dlc) - place hash in header
-	mset.outq.send(newJSPubMsg(reply, _EMPTY_, _EMPTY_, nil, nil, nil, 0))
+	// Send last EOF with the manifest in the header.
+	mb, _ := json.Marshal(sd.manifest(aead != nil))
+	hdr := genHeader(nil, JSSnapshotManifest, string(mb))
+	mset.outq.send(newJSPubMsg(reply, _EMPTY_, _EMPTY_, hdr, nil, nil, 0))
 }
 
 // For determining consumer request type.
@@ -3677,7 +5590,7 @@ func (s *Server) jsConsumerCreateRequest(sub *subscription, c *client, a *Accoun
 		return
 	}
 
-	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	ci, acc, hdr, msg, err := s.getRequestInfo(c, rmsg)
 	if err != nil {
 		s.Warnf(badAPIRequestT, msg)
 		return
@@ -3820,6 +5733,14 @@ func (s *Server) jsConsumerCreateRequest(sub *subscription, c *client, a *Accoun
 		return
 	}
 
+	// Requests to source a stream from another account carry a token and are always direct.
+	token := getHeader(JSSourceToken, hdr)
+	if len(token) > 0 && !req.Config.Direct {
+		resp.Error = NewJSStreamSourceNotAuthorizedError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
 	if isClustered && !req.Config.Direct {
 		// If we are inline with client, we still may need to do a callout for consumer info
 		// during this call, so place in Go routine to not block client.
@@ -3844,8 +5765,25 @@ func (s *Server) jsConsumerCreateRequest(sub *subscription, c *client, a *Accoun
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 		return
 	}
+	if len(token) > 0 && !stream.sourceTokenAllowed(string(token)) {
+		resp.Error = NewJSStreamSourceNotAuthorizedError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	if stream.isPartitioned() {
+		info, err := stream.addPartitionsConsumer(&req.Config)
+		if err != nil {
+			resp.Error = NewJSConsumerCreateError(err, Unless(err))
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		resp.ConsumerInfo = info
+		s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+		return
+	}
 
-	o, err := stream.addConsumer(&req.Config)
+	o, err := stream.addConsumerWithHistory(&req.Config)
 
 	if err != nil {
 		if IsNatsErr(err, JSConsumerStoreFailedErrF) {
@@ -4027,6 +5965,7 @@ func (s *Server) jsConsumerListRequest(sub *subscription, c *client, _ *Account,
 	}
 
 	var offset int
+	var streaming bool
 	if !isEmptyRequest(msg) {
 		var req JSApiConsumersRequest
 		if err := json.Unmarshal(msg, &req); err != nil {
@@ -4034,7 +5973,7 @@ func (s *Server) jsConsumerListRequest(sub *subscription, c *client, _ *Account,
 			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
 			return
 		}
-		offset = req.Offset
+		offset, streaming = req.Offset, req.Streaming
 	}
 
 	streamName := streamNameFromSubject(subject)
@@ -4044,7 +5983,7 @@ func (s *Server) jsConsumerListRequest(sub *subscription, c *client, _ *Account,
 		// Need to copy these off before sending.. don't move this inside startGoRoutine!!!
 		msg = copyBytes(msg)
 		s.startGoRoutine(func() {
-			s.jsClusteredConsumerListRequest(acc, ci, offset, streamName, subject, reply, msg)
+			s.jsClusteredConsumerListRequest(acc, ci, offset, streaming, streamName, subject, reply, msg)
 		})
 		return
 	}
@@ -4067,13 +6006,22 @@ func (s *Server) jsConsumerListRequest(sub *subscription, c *client, _ *Account,
 	}
 
 	for _, o := range obs[offset:] {
+		if streaming {
+			s.sendInternalAccountMsg(nil, reply, s.jsonResponse(&JSApiConsumerInfoResponse{
+				ApiResponse:  ApiResponse{Type: JSApiConsumerInfoResponseType},
+				ConsumerInfo: o.info(),
+			}))
+			continue
+		}
 		resp.Consumers = append(resp.Consumers, o.info())
 		if len(resp.Consumers) >= JSApiListLimit {
 			break
 		}
 	}
 	resp.Total = ocnt
-	resp.Limit = JSApiListLimit
+	if !streaming {
+		resp.Limit = JSApiListLimit
+	}
 	resp.Offset = offset
 	s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
 }
@@ -4216,6 +6164,16 @@ func (s *Server) jsConsumerInfoRequest(sub *subscription, c *client, _ *Account,
 		return
 	}
 
+	if mset.isPartitioned() {
+		if resp.ConsumerInfo = mset.partitionsConsumerInfo(consumerName); resp.ConsumerInfo == nil {
+			resp.Error = NewJSConsumerNotFoundError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+		return
+	}
+
 	obs := mset.lookupConsumer(consumerName)
 	if obs == nil {
 		resp.Error = NewJSConsumerNotFoundError()
@@ -4283,6 +6241,23 @@ func (s *Server) jsConsumerDeleteRequest(sub *subscription, c *client, _ *Accoun
 		return
 	}
 
+	if mset.isPartitioned() {
+		found, err := mset.deletePartitionsConsumer(consumer)
+		if !found {
+			resp.Error = NewJSConsumerNotFoundError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		if err != nil {
+			resp.Error = NewJSStreamGeneralError(err, Unless(err))
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+		resp.Success = true
+		s.sendAPIResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(resp))
+		return
+	}
+
 	obs := mset.lookupConsumer(consumer)
 	if obs == nil {
 		resp.Error = NewJSConsumerNotFoundError()
@@ -0,0 +1,254 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+const (
+	// JSAPIAuditStream is the name of the per account stream holding API audit records.
+	JSAPIAuditStream = "$JS_API_AUDIT"
+	// JSAPIAuditSubjPre is the subject prefix for API audit records, followed by the verb.
+	JSAPIAuditSubjPre = "$JS.AUDIT.API"
+	jsAPIAuditSubjAll = JSAPIAuditSubjPre + ".>"
+
+	// JSAPIAuditRecordType is the type of a stored API audit record.
+	JSAPIAuditRecordType = "io.nats.jetstream.audit.v1.api_request"
+
+	// Defaults for the audit stream limits.
+	JSAPIAuditDefaultMaxMsgs = 10_000
+	JSAPIAuditDefaultMaxAge  = 24 * time.Hour
+
+	// How long we will hold on to a request start time waiting for a response.
+	// Responses may come from another server in clustered mode.
+	jsAPIAuditStartTTL = time.Minute
+)
+
+// JSAPIAuditRecord is stored in the account's audit stream for every JetStream API request.
+type JSAPIAuditRecord struct {
+	TypedEvent
+	Server  string        `json:"server"`
+	Client  *ClientInfo   `json:"client,omitempty"`
+	Verb    string        `json:"verb"`
+	Asset   string        `json:"asset,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Code    int           `json:"code"`
+	ErrCode uint16        `json:"err_code,omitempty"`
+}
+
+// jsAPIAudit tracks request start times and pending records.
+type jsAPIAudit struct {
+	mu     sync.Mutex
+	starts map[string]time.Time // keyed by reply subject
+	lprune time.Time
+	recs   *ipQueue
+}
+
+type jsAPIAuditReq struct {
+	acc *Account
+	rec *JSAPIAuditRecord
+}
+
+// Returns the verb and asset for an API subject, e.g. STREAM.INFO and ORDERS.
+// The subject of the API handler it was dispatched to is used as the pattern,
+// literal tokens form the verb and single wildcard tokens the asset. Consumer
+// assets are reported as stream > consumer.
+func (js *jetStream) apiAuditVerbAndAsset(subject string) (string, string) {
+	var pattern string
+	if r := js.apiSubs.Match(subject); r != nil {
+		// Use the most specific handler subject should more than one match.
+		var lits int
+		for _, sub := range r.psubs {
+			ps := string(sub.subject)
+			var n int
+			for _, t := range strings.Split(ps, tsep) {
+				if t != pwcs && t != fwcs {
+					n++
+				}
+			}
+			if pattern == _EMPTY_ || n > lits {
+				pattern, lits = ps, n
+			}
+		}
+	}
+	if pattern == _EMPTY_ {
+		return strings.TrimPrefix(subject, JSApiPrefix+tsep), _EMPTY_
+	}
+	tokens := strings.Split(subject, tsep)
+	var verb, asset []string
+	for i, pt := range strings.Split(pattern, tsep)[2:] {
+		switch pt {
+		case pwcs:
+			asset = append(asset, tokens[i+2])
+		case fwcs:
+		default:
+			verb = append(verb, pt)
+		}
+	}
+	return strings.Join(verb, tsep), strings.Join(asset, " > ")
+}
+
+// Starts API auditing if configured.
+// Lock should not be held.
+func (s *Server) startJetStreamAPIAudit(js *jetStream) {
+	if !s.getOpts().JetStreamAPIAudit.Enabled {
+		return
+	}
+	audit := &jsAPIAudit{
+		starts: make(map[string]time.Time),
+		recs:   s.newIPQueue("JS API Audit Records"),
+	}
+	js.mu.Lock()
+	js.audit = audit
+	js.mu.Unlock()
+	s.startGoRoutine(func() { s.processJetStreamAPIAudit(audit) })
+}
+
+// Remember when a request arrived so we can report latency with the response.
+func (a *jsAPIAudit) trackRequest(reply string) {
+	if reply == _EMPTY_ {
+		return
+	}
+	now := time.Now()
+	a.mu.Lock()
+	a.starts[reply] = now
+	// Prune any we never answered, we may not be the responder.
+	if now.Sub(a.lprune) > jsAPIAuditStartTTL {
+		for r, start := range a.starts {
+			if now.Sub(start) > jsAPIAuditStartTTL {
+				delete(a.starts, r)
+			}
+		}
+		a.lprune = now
+	}
+	a.mu.Unlock()
+}
+
+// Returns the latency for the request associated with reply.
+func (a *jsAPIAudit) latency(reply string) time.Duration {
+	if reply == _EMPTY_ {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	start, ok := a.starts[reply]
+	if !ok {
+		return 0
+	}
+	delete(a.starts, reply)
+	return time.Since(start)
+}
+
+// Queue an audit record for an API response.
+func (s *Server) auditJetStreamAPI(ci *ClientInfo, acc *Account, subject, reply, response string) {
+	js := s.getJetStream()
+	if js == nil || acc == nil {
+		return
+	}
+	// The audit is set before the API subscription is made and never changes,
+	// so no need for the lock here, which callers may already be holding.
+	audit := js.audit
+	if audit == nil {
+		return
+	}
+
+	verb, asset := js.apiAuditVerbAndAsset(subject)
+	rec := &JSAPIAuditRecord{
+		TypedEvent: TypedEvent{
+			Type: JSAPIAuditRecordType,
+			ID:   nuid.Next(),
+			Time: time.Now().UTC(),
+		},
+		Server:  s.Name(),
+		Client:  ci,
+		Verb:    verb,
+		Asset:   asset,
+		Latency: audit.latency(reply),
+		Code:    200,
+	}
+	var resp ApiResponse
+	if err := json.Unmarshal([]byte(response), &resp); err == nil && resp.Error != nil {
+		rec.Code, rec.ErrCode = resp.Error.Code, resp.Error.ErrCode
+	}
+	audit.recs.push(&jsAPIAuditReq{acc, rec})
+}
+
+// Stores audit records, creating the account audit streams as needed.
+// This is done in its own go routine since responses can be sent with locks held.
+func (s *Server) processJetStreamAPIAudit(audit *jsAPIAudit) {
+	defer s.grWG.Done()
+
+	for {
+		select {
+		case <-audit.recs.ch:
+			reqs := audit.recs.pop()
+			for _, req := range reqs {
+				r := req.(*jsAPIAuditReq)
+				if !s.checkJetStreamAPIAuditStream(r.acc) {
+					continue
+				}
+				if b, err := json.Marshal(r.rec); err == nil {
+					s.sendInternalAccountMsg(r.acc, JSAPIAuditSubjPre+tsep+r.rec.Verb, b)
+				}
+			}
+			audit.recs.recycle(&reqs)
+		case <-s.quitCh:
+			return
+		}
+	}
+}
+
+// Make sure the account has its audit stream.
+// In clustered mode the stream needs to be created by the operator or tenant
+// with the subjects of JSAPIAuditSubjPre, we will only publish the records.
+func (s *Server) checkJetStreamAPIAuditStream(acc *Account) bool {
+	if !acc.JetStreamEnabled() {
+		return false
+	}
+	if s.JetStreamIsClustered() {
+		return true
+	}
+	if _, err := acc.lookupStream(JSAPIAuditStream); err == nil {
+		return true
+	}
+	aopts := s.getOpts().JetStreamAPIAudit
+	cfg := &StreamConfig{
+		Name:        JSAPIAuditStream,
+		Description: "JetStream API audit records",
+		Subjects:    []string{jsAPIAuditSubjAll},
+		Storage:     FileStorage,
+		Retention:   LimitsPolicy,
+		Discard:     DiscardOld,
+		MaxMsgs:     aopts.MaxMsgs,
+		MaxAge:      aopts.MaxAge,
+		Replicas:    1,
+	}
+	if cfg.MaxMsgs <= 0 {
+		cfg.MaxMsgs = JSAPIAuditDefaultMaxMsgs
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = JSAPIAuditDefaultMaxAge
+	}
+	if _, err := acc.addStream(cfg); err != nil {
+		s.Warnf("Could not create JetStream API audit stream for account %q: %v", acc.Name, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,389 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+const (
+	// Default for how long streams stay fenced while taking a backup point.
+	defaultJSBackupFence = 2 * time.Second
+	// How often we check if a fenced stream stored everything it accepted before the fence.
+	jsBackupSettleInterval = 5 * time.Millisecond
+
+	// Every server fences the streams it leads for an account on the first, and lifts
+	// the fence on the second. The last token is the account.
+	jsBackupFenceSubj    = "$SYS.JSC.BACKUP.FENCE.*"
+	jsBackupFenceSubjT   = "$SYS.JSC.BACKUP.FENCE.%s"
+	jsBackupReleaseSubj  = "$SYS.JSC.BACKUP.RELEASE.*"
+	jsBackupReleaseSubjT = "$SYS.JSC.BACKUP.RELEASE.%s"
+)
+
+// BackupPointState is where a stream and its consumers were while fenced for a backup point.
+type BackupPointState struct {
+	Stream    string                 `json:"stream"`
+	Server    string                 `json:"server"`
+	FirstSeq  uint64                 `json:"first_seq"`
+	LastSeq   uint64                 `json:"last_seq"`
+	Msgs      uint64                 `json:"messages"`
+	Bytes     uint64                 `json:"bytes"`
+	Consumers []*BackupPointConsumer `json:"consumers,omitempty"`
+	// Set when the stream did not settle in time, its sequences may then be off.
+	Error string `json:"error,omitempty"`
+}
+
+// BackupPointConsumer is where a consumer was while its stream was fenced for a backup point.
+type BackupPointConsumer struct {
+	Name      string       `json:"name"`
+	Delivered SequencePair `json:"delivered"`
+	AckFloor  SequencePair `json:"ack_floor"`
+}
+
+// Sent to all servers to fence, or release, the streams of an account.
+type jsBackupFenceRequest struct {
+	ID    string        `json:"id"`
+	Fence time.Duration `json:"fence"`
+}
+
+// Sent back by servers that fenced streams.
+type jsBackupFenceResponse struct {
+	Streams []*BackupPointState `json:"streams"`
+}
+
+func (s *Server) setJetStreamBackupSubs() error {
+	if _, err := s.sysSubscribe(jsBackupFenceSubj, s.jsBackupFenceRequest); err != nil {
+		return err
+	}
+	_, err := s.sysSubscribe(jsBackupReleaseSubj, s.jsBackupReleaseRequest)
+	return err
+}
+
+// Request to take a backup point of an account. Only from the system account,
+// and answered by the meta leader when clustered.
+func (s *Server) jsLeaderAccountBackupPointRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || !s.JetStreamEnabled() {
+		return
+	}
+
+	ci, acc, _, msg, err := s.getRequestInfo(c, rmsg)
+	if err != nil {
+		s.Warnf(badAPIRequestT, msg)
+		return
+	}
+
+	js, cc := s.getJetStreamCluster()
+	if js == nil {
+		return
+	}
+	if cc != nil && (cc.meta == nil || !cc.isLeader()) {
+		return
+	}
+
+	var resp = JSApiAccountBackupPointResponse{ApiResponse: ApiResponse{Type: JSApiAccountBackupPointResponseType}}
+
+	if cc != nil && js.isMetaRecovering() {
+		// While in recovery mode, the data structures are not fully initialized
+		resp.Error = NewJSClusterNotAvailError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+
+	var req JSApiAccountBackupPointRequest
+	if !isEmptyRequest(msg) {
+		if err := json.Unmarshal(msg, &req); err != nil {
+			resp.Error = NewJSInvalidJSONError()
+			s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+			return
+		}
+	}
+	if req.Fence < 0 {
+		resp.Error = NewJSBadRequestError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(msg), s.jsonResponse(&resp))
+		return
+	}
+	if req.Fence == 0 {
+		req.Fence = defaultJSBackupFence
+	}
+
+	accName, request := tokenAt(subject, 5), string(msg)
+
+	// We wait on other servers, so can not do this inline.
+	s.startGoRoutine(func() {
+		defer s.grWG.Done()
+		if err := s.takeBackupPoint(accName, req.Fence, &resp); err != nil {
+			resp.Error = NewJSStreamGeneralError(err)
+			s.sendAPIErrResponse(ci, acc, subject, reply, request, s.jsonResponse(&resp))
+			return
+		}
+		s.Noticef("Backup point %s for account %s (streams: %d, missing: %d)",
+			resp.ID, accName, len(resp.Streams), len(resp.Missing))
+		s.sendAPIResponse(ci, acc, subject, reply, request, s.jsonResponse(&resp))
+	})
+}
+
+// Fences the streams of an account on all servers, waits for them all to report where
+// they are and lifts the fences again. Since all streams are fenced at the same time
+// before any is released, what they reported lines up to a single logical point.
+func (s *Server) takeBackupPoint(accName string, fence time.Duration, resp *JSApiAccountBackupPointResponse) error {
+	// The streams we expect to hear about.
+	expected := make(map[string]struct{})
+	if js, cc := s.getJetStreamCluster(); cc != nil {
+		js.mu.RLock()
+		for name := range cc.streams[accName] {
+			expected[name] = struct{}{}
+		}
+		js.mu.RUnlock()
+	} else if acc, err := s.lookupAccount(accName); err == nil {
+		for _, mset := range acc.streams() {
+			expected[mset.name()] = struct{}{}
+		}
+	}
+
+	var mu sync.Mutex
+	var reported []*BackupPointState
+	rch := make(chan struct{}, 1)
+
+	states := make(map[string]*BackupPointState)
+	collect := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, st := range reported {
+			states[st.Stream] = st
+			delete(expected, st.Stream)
+		}
+		reported = nil
+	}
+
+	s.mu.Lock()
+	if s.sys == nil || s.sys.replies == nil {
+		s.mu.Unlock()
+		return ErrNoSysAccount
+	}
+	inbox := s.newRespInbox()
+	s.sys.replies[inbox] = func(_ *subscription, _ *client, _ *Account, _, _ string, msg []byte) {
+		var fr jsBackupFenceResponse
+		if err := json.Unmarshal(msg, &fr); err != nil {
+			return
+		}
+		mu.Lock()
+		reported = append(reported, fr.Streams...)
+		mu.Unlock()
+		select {
+		case rch <- struct{}{}:
+		default:
+		}
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.sys != nil && s.sys.replies != nil {
+			delete(s.sys.replies, inbox)
+		}
+		s.mu.Unlock()
+	}()
+
+	// Fences lift on their own when they expire, for instance should we go away.
+	// Our deadline starts before any of them, so we are done before the first one does.
+	freq := &jsBackupFenceRequest{ID: nuid.Next(), Fence: fence}
+	deadline := time.NewTimer(fence)
+	defer deadline.Stop()
+
+	// We do not receive our own requests, so take care of our streams directly.
+	s.sendInternalMsgLocked(fmt.Sprintf(jsBackupFenceSubjT, accName), inbox, nil, freq)
+	fenced := s.fenceBackupStreams(accName, freq)
+	defer func() {
+		s.sendInternalMsgLocked(fmt.Sprintf(jsBackupReleaseSubjT, accName), _EMPTY_, nil, freq)
+		s.releaseBackupStreams(accName, freq.ID)
+	}()
+	for _, st := range backupPointStates(fenced, fence) {
+		states[st.Stream] = st
+		delete(expected, st.Stream)
+	}
+
+	for len(expected) > 0 {
+		select {
+		case <-rch:
+			collect()
+			continue
+		case <-deadline.C:
+			collect()
+		case <-s.quitCh:
+			return ErrServerNotRunning
+		}
+		break
+	}
+
+	resp.ID, resp.Account, resp.Time = freq.ID, accName, time.Now().UTC()
+	for _, st := range states {
+		resp.Streams = append(resp.Streams, st)
+	}
+	sort.Slice(resp.Streams, func(i, j int) bool { return resp.Streams[i].Stream < resp.Streams[j].Stream })
+	for name := range expected {
+		resp.Missing = append(resp.Missing, name)
+	}
+	sort.Strings(resp.Missing)
+	return nil
+}
+
+// Fences the streams we lead for an account and reports where they are once settled.
+func (s *Server) jsBackupFenceRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil || reply == _EMPTY_ {
+		return
+	}
+	_, msg := c.msgParts(rmsg)
+	var req jsBackupFenceRequest
+	if err := json.Unmarshal(msg, &req); err != nil || req.ID == _EMPTY_ || req.Fence <= 0 {
+		return
+	}
+	fenced := s.fenceBackupStreams(tokenAt(subject, 5), &req)
+	if len(fenced) == 0 {
+		return
+	}
+	// Waiting for our streams to settle can not be done inline.
+	s.startGoRoutine(func() {
+		defer s.grWG.Done()
+		resp := &jsBackupFenceResponse{Streams: backupPointStates(fenced, req.Fence)}
+		s.sendInternalMsgLocked(reply, _EMPTY_, nil, resp)
+	})
+}
+
+// Lifts the fences we put up for a backup point.
+func (s *Server) jsBackupReleaseRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if c == nil {
+		return
+	}
+	_, msg := c.msgParts(rmsg)
+	var req jsBackupFenceRequest
+	if err := json.Unmarshal(msg, &req); err != nil || req.ID == _EMPTY_ {
+		return
+	}
+	s.releaseBackupStreams(tokenAt(subject, 5), req.ID)
+}
+
+// Fences the streams of an account we lead and returns them.
+func (s *Server) fenceBackupStreams(accName string, req *jsBackupFenceRequest) []*stream {
+	acc, err := s.lookupAccount(accName)
+	if err != nil {
+		return nil
+	}
+	var fenced []*stream
+	for _, mset := range acc.streams() {
+		if mset.fenceForBackup(req.ID, req.Fence) {
+			fenced = append(fenced, mset)
+		}
+	}
+	return fenced
+}
+
+func (s *Server) releaseBackupStreams(accName, id string) {
+	acc, err := s.lookupAccount(accName)
+	if err != nil {
+		return
+	}
+	for _, mset := range acc.streams() {
+		mset.releaseFence(id)
+	}
+}
+
+// Returns where fenced streams are once settled. Leaves time for others
+// to report as well before the fences expire.
+func backupPointStates(fenced []*stream, fence time.Duration) []*BackupPointState {
+	deadline := time.Now().Add(fence / 2)
+	states := make([]*BackupPointState, 0, len(fenced))
+	for _, mset := range fenced {
+		states = append(states, mset.backupPointState(deadline))
+	}
+	return states
+}
+
+// Holds off writes for the backup point with the given id, for no longer than fence.
+// Returns false if we are not the leader, in which case we leave it to the one that is.
+func (mset *stream) fenceForBackup(id string, fence time.Duration) bool {
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+	if !mset.isLeader() {
+		return false
+	}
+	if mset.fenceT != nil {
+		mset.fenceT.Stop()
+	}
+	mset.fence = id
+	mset.fenceT = time.AfterFunc(fence, func() { mset.releaseFence(id) })
+	return true
+}
+
+func (mset *stream) releaseFence(id string) {
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+	if mset.fence != id {
+		return
+	}
+	if mset.fenceT != nil {
+		mset.fenceT.Stop()
+		mset.fenceT = nil
+	}
+	mset.fence = _EMPTY_
+}
+
+// Returns true once everything accepted before we were fenced is stored,
+// which when clustered means it was applied as well.
+func (mset *stream) settled() bool {
+	if msgs := mset.msgs; msgs != nil && (msgs.len() > 0 || msgs.inProgress() > 0) {
+		return false
+	}
+	mset.clMu.Lock()
+	clseq := mset.clseq
+	mset.clMu.Unlock()
+
+	mset.mu.RLock()
+	applied := mset.lseq + mset.clfs
+	mset.mu.RUnlock()
+	return clseq == 0 || clseq <= applied
+}
+
+// Waits for a fenced stream to settle, until deadline, and returns where it and its consumers are.
+func (mset *stream) backupPointState(deadline time.Time) *BackupPointState {
+	bps := &BackupPointState{Stream: mset.name(), Server: mset.srv.Name()}
+	for !mset.settled() {
+		if time.Now().After(deadline) {
+			bps.Error = "stream did not settle before the fence expired"
+			break
+		}
+		time.Sleep(jsBackupSettleInterval)
+	}
+
+	state := mset.state()
+	bps.FirstSeq, bps.LastSeq, bps.Msgs, bps.Bytes = state.FirstSeq, state.LastSeq, state.Msgs, state.Bytes
+
+	for _, o := range mset.getPublicConsumers() {
+		o.mu.RLock()
+		name, store := o.name, o.store
+		o.mu.RUnlock()
+		if store == nil {
+			continue
+		}
+		if cs, err := store.State(); err == nil && cs != nil {
+			bps.Consumers = append(bps.Consumers, &BackupPointConsumer{Name: name, Delivered: cs.Delivered, AckFloor: cs.AckFloor})
+		}
+	}
+	sort.Slice(bps.Consumers, func(i, j int) bool { return bps.Consumers[i].Name < bps.Consumers[j].Name })
+	return bps
+}
@@ -66,6 +66,12 @@ type jetStreamCluster struct {
 	peerStreamCancelMove *subscription
 	// To pop out the monitorCluster before the raft layer.
 	qch chan struct{}
+	// Moving our assets off this server, if requested.
+	drain *jsDrain
+
+	// Replica counts the meta leader wants to scale streams to, and if it is scaling.
+	scalePending map[*streamAssignment]int
+	scaling      bool
 }
 
 // Used to guide placement of streams and meta controllers in clustered JetStream.
@@ -102,6 +108,14 @@ const (
 	removePendingRequest
 	// For sending compressed streams, either through RAFT or catchup.
 	compressedStreamMsgOp
+	// Remap stream subjects.
+	remapSubjectsOp
+	// Delete a range of messages.
+	deleteRangeOp
+	// Freeze or thaw a stream.
+	freezeStreamOp
+	// Place or release a subject hold.
+	holdStreamOp
 )
 
 // raftGroups are controlled by the metagroup controller.
@@ -126,6 +140,10 @@ type streamAssignment struct {
 	Subject string        `json:"subject"`
 	Reply   string        `json:"reply"`
 	Restore *StreamState  `json:"restore_state,omitempty"`
+	// Optional for encrypted or verified restores.
+	RestoreEncryption *SnapshotEncryption `json:"restore_encryption,omitempty"`
+	RestoreManifest   *SnapshotManifest   `json:"restore_manifest,omitempty"`
+	ConfigHistory     *configHistory      `json:"config_history,omitempty"`
 	// Internal
 	consumers map[string]*consumerAssignment
 	responded bool
@@ -143,6 +161,8 @@ type consumerAssignment struct {
 	Subject string          `json:"subject"`
 	Reply   string          `json:"reply"`
 	State   *ConsumerState  `json:"state,omitempty"`
+	// Prior configs, kept across updates.
+	ConfigHistory *configHistory `json:"config_history,omitempty"`
 	// Internal
 	responded bool
 	deleted   bool
@@ -160,6 +180,16 @@ type streamPurge struct {
 	Request *JSApiStreamPurgeRequest `json:"request,omitempty"`
 }
 
+// streamRemap is what the stream leader will replicate when remapping subjects.
+type streamRemap struct {
+	Client  *ClientInfo              `json:"client,omitempty"`
+	Stream  string                   `json:"stream"`
+	LastSeq uint64                   `json:"last_seq"`
+	Subject string                   `json:"subject"`
+	Reply   string                   `json:"reply"`
+	Request *JSApiStreamRemapRequest `json:"request"`
+}
+
 // streamMsgDelete is what the stream leader will replicate when deleting a message.
 type streamMsgDelete struct {
 	Client  *ClientInfo `json:"client,omitempty"`
@@ -170,6 +200,37 @@ type streamMsgDelete struct {
 	Reply   string      `json:"reply"`
 }
 
+// streamMsgDeleteRange is what the stream leader will replicate when deleting a range of messages.
+type streamMsgDeleteRange struct {
+	Client  *ClientInfo `json:"client,omitempty"`
+	Stream  string      `json:"stream"`
+	First   uint64      `json:"first"`
+	Last    uint64      `json:"last"`
+	Subject string      `json:"subject"`
+	Reply   string      `json:"reply"`
+}
+
+// streamFreeze is what the stream leader will replicate when freezing or thawing a stream.
+type streamFreeze struct {
+	Client  *ClientInfo `json:"client,omitempty"`
+	Stream  string      `json:"stream"`
+	Frozen  bool        `json:"frozen"`
+	Subject string      `json:"subject"`
+	Reply   string      `json:"reply"`
+}
+
+// streamHold is what the stream leader will replicate when placing or releasing a subject hold.
+type streamHold struct {
+	Client  *ClientInfo `json:"client,omitempty"`
+	Stream  string      `json:"stream"`
+	Hold    string      `json:"hold"`
+	Reason  string      `json:"reason,omitempty"`
+	Release bool        `json:"release,omitempty"`
+	Created time.Time   `json:"created"`
+	Subject string      `json:"subject"`
+	Reply   string      `json:"reply"`
+}
+
 const (
 	defaultStoreDirName  = "_js_"
 	defaultMetaGroupName = "_meta_"
@@ -619,7 +680,7 @@ func (js *jetStream) setupMetaGroup() error {
 	storeDir := filepath.Join(js.config.StoreDir, sysAcc.Name, defaultStoreDirName, defaultMetaGroupName)
 
 	fs, err := newFileStoreWithCreated(
-		FileStoreConfig{StoreDir: storeDir, BlockSize: defaultMetaFSBlkSize, AsyncFlush: false},
+		FileStoreConfig{StoreDir: storeDir, BlockSize: defaultMetaFSBlkSize, AsyncFlush: false, FlushCPUs: s.getOpts().CPUAffinity.Filestore},
 		StreamConfig{Name: defaultMetaGroupName, Storage: FileStorage},
 		time.Now().UTC(),
 		s.jsKeyGen(defaultMetaGroupName),
@@ -969,6 +1030,9 @@ func (js *jetStream) monitorCluster() {
 
 	defer s.grWG.Done()
 
+	release := s.pinToCPUs(cpuPoolRaft, s.getOpts().CPUAffinity.Raft)
+	defer release()
+
 	s.Debugf("Starting metadata monitor")
 	defer s.Debugf("Exiting metadata monitor")
 
@@ -984,6 +1048,10 @@ func (js *jetStream) monitorCluster() {
 	lt := time.NewTicker(leaderCheckInterval)
 	defer lt.Stop()
 
+	// Used by the leader to scale the replicas of streams.
+	st := time.NewTicker(replicaScalingInterval)
+	defer st.Stop()
+
 	var (
 		isLeader     bool
 		lastSnap     []byte
@@ -1092,6 +1160,10 @@ func (js *jetStream) monitorCluster() {
 			if n.Leader() {
 				js.checkClusterSize()
 			}
+		case <-st.C:
+			if n.Leader() {
+				js.checkReplicaScaling()
+			}
 		case <-lt.C:
 			s.Debugf("Checking JetStream cluster state")
 			// If we have a current leader or had one in the past we can cancel this here since the metaleader
@@ -1165,6 +1237,7 @@ type writeableStreamAssignment struct {
 	Group     *raftGroup    `json:"group"`
 	Sync      string        `json:"sync"`
 	Consumers []*consumerAssignment
+	History   *configHistory `json:"config_history,omitempty"`
 }
 
 func (js *jetStream) clusterStreamConfig(accName, streamName string) (StreamConfig, bool) {
@@ -1189,6 +1262,7 @@ func (js *jetStream) metaSnapshot() []byte {
 				Config:  sa.Config,
 				Group:   sa.Group,
 				Sync:    sa.Sync,
+				History: sa.ConfigHistory,
 			}
 			for _, ca := range sa.consumers {
 				wsa.Consumers = append(wsa.Consumers, ca)
@@ -1229,7 +1303,7 @@ func (js *jetStream) applyMetaSnapshot(buf []byte) error {
 			as = make(map[string]*streamAssignment)
 			streams[wsa.Client.serviceAccount()] = as
 		}
-		sa := &streamAssignment{Client: wsa.Client, Created: wsa.Created, Config: wsa.Config, Group: wsa.Group, Sync: wsa.Sync}
+		sa := &streamAssignment{Client: wsa.Client, Created: wsa.Created, Config: wsa.Config, Group: wsa.Group, Sync: wsa.Sync, ConfigHistory: wsa.History}
 		if len(wsa.Consumers) > 0 {
 			sa.consumers = make(map[string]*consumerAssignment)
 			for _, ca := range wsa.Consumers {
@@ -1337,7 +1411,7 @@ func (js *jetStream) setStreamAssignmentRecovering(sa *streamAssignment) {
 	js.mu.Lock()
 	defer js.mu.Unlock()
 	sa.responded = true
-	sa.Restore = nil
+	sa.Restore, sa.RestoreEncryption, sa.RestoreManifest = nil, nil, nil
 	if sa.Group != nil {
 		sa.Group.Preferred = _EMPTY_
 	}
@@ -1720,7 +1794,7 @@ func (js *jetStream) createRaftGroup(accName string, rg *raftGroup, storage Stor
 	var store StreamStore
 	if storage == FileStorage {
 		fs, err := newFileStoreWithCreated(
-			FileStoreConfig{StoreDir: storeDir, BlockSize: defaultMediumBlockSize, AsyncFlush: false, SyncInterval: 5 * time.Minute},
+			FileStoreConfig{StoreDir: storeDir, BlockSize: defaultMediumBlockSize, AsyncFlush: false, SyncInterval: 5 * time.Minute, FlushCPUs: s.getOpts().CPUAffinity.Filestore},
 			StreamConfig{Name: rg.Name, Storage: FileStorage},
 			time.Now().UTC(),
 			s.jsKeyGen(rg.Name),
@@ -1812,6 +1886,8 @@ func genPeerInfo(peers []string, split int) (newPeers, oldPeers []string, newPee
 func (js *jetStream) monitorStream(mset *stream, sa *streamAssignment, sendSnapshot bool) {
 	s, cc := js.server(), js.cluster
 	defer s.grWG.Done()
+	release := s.pinToCPUs(cpuPoolRaft, s.getOpts().CPUAffinity.Raft)
+	defer release()
 	if mset != nil {
 		defer mset.monitorWg.Done()
 	}
@@ -2002,7 +2078,7 @@ func (js *jetStream) monitorStream(mset *stream, sa *streamAssignment, sendSnaps
 				}
 				if isRestore {
 					acc, _ := s.LookupAccount(sa.Client.serviceAccount())
-					restoreDoneCh = s.processStreamRestore(sa.Client, acc, sa.Config, _EMPTY_, sa.Reply, _EMPTY_)
+					restoreDoneCh = s.processStreamRestore(sa.Client, acc, sa.Config, sa.RestoreEncryption, sa.RestoreManifest, _EMPTY_, sa.Reply, _EMPTY_)
 					continue
 				} else if n.NeedSnapshot() {
 					doSnapshot()
@@ -2184,7 +2260,7 @@ func (js *jetStream) monitorStream(mset *stream, sa *streamAssignment, sendSnaps
 				s.Debugf("Stream restore failed: %v", err)
 			}
 			isRestore = false
-			sa.Restore = nil
+			sa.Restore, sa.RestoreEncryption, sa.RestoreManifest = nil, nil, nil
 			// If we were successful lookup up our stream now.
 			if err == nil {
 				if mset, err = acc.lookupStream(sa.Config.Name); mset != nil {
@@ -2490,6 +2566,96 @@ func (js *jetStream) applyStreamEntries(mset *stream, ce *CommittedEntry, isReco
 						s.sendAPIResponse(md.Client, mset.account(), md.Subject, md.Reply, _EMPTY_, s.jsonResponse(resp))
 					}
 				}
+			case deleteRangeOp:
+				mr, err := decodeMsgDeleteRange(buf[1:])
+				if err != nil {
+					if node := mset.raftNode(); node != nil {
+						s := js.srv
+						s.Errorf("JetStream cluster could not decode delete range msg for '%s > %s' [%s]",
+							mset.account(), mset.name(), node.Group())
+					}
+					panic(err.Error())
+				}
+				// Messages already gone are skipped, so replays are safe.
+				s, cc := js.server(), js.cluster
+				removed, err := mset.removeRange(mr.First, mr.Last)
+				if err != nil && !isRecovering {
+					s.Debugf("JetStream cluster failed to delete stream msgs %d-%d from '%s > %s': %v",
+						mr.First, mr.Last, mr.Client.serviceAccount(), mr.Stream, err)
+				}
+
+				js.mu.RLock()
+				isLeader := cc.isStreamLeader(mr.Client.serviceAccount(), mr.Stream)
+				js.mu.RUnlock()
+
+				if isLeader && !isRecovering {
+					var resp = JSApiMsgDeleteRangeResponse{ApiResponse: ApiResponse{Type: JSApiMsgDeleteRangeResponseType}}
+					if err != nil {
+						resp.Error = NewJSStreamMsgDeleteFailedError(err, Unless(err))
+						s.sendAPIErrResponse(mr.Client, mset.account(), mr.Subject, mr.Reply, _EMPTY_, s.jsonResponse(resp))
+					} else {
+						resp.Removed = removed
+						s.sendAPIResponse(mr.Client, mset.account(), mr.Subject, mr.Reply, _EMPTY_, s.jsonResponse(resp))
+					}
+				}
+			case freezeStreamOp:
+				sf, err := decodeStreamFreeze(buf[1:])
+				if err != nil {
+					if node := mset.raftNode(); node != nil {
+						s := js.srv
+						s.Errorf("JetStream cluster could not decode freeze msg for '%s > %s' [%s]",
+							mset.account(), mset.name(), node.Group())
+					}
+					panic(err.Error())
+				}
+				// Messages are rejected in log order on all replicas, so this is applied when recovering as well.
+				mset.setFrozen(sf.Frozen)
+
+				s, cc := js.server(), js.cluster
+				js.mu.RLock()
+				isLeader := cc.isStreamLeader(sf.Client.serviceAccount(), sf.Stream)
+				js.mu.RUnlock()
+
+				if isLeader && !isRecovering {
+					var resp = JSApiStreamFreezeResponse{ApiResponse: ApiResponse{Type: JSApiStreamFreezeResponseType}}
+					resp.Frozen = mset.isFrozen()
+					s.sendAPIResponse(sf.Client, mset.account(), sf.Subject, sf.Reply, _EMPTY_, s.jsonResponse(resp))
+				}
+			case holdStreamOp:
+				sh, err := decodeStreamHold(buf[1:])
+				if err != nil {
+					if node := mset.raftNode(); node != nil {
+						s := js.srv
+						s.Errorf("JetStream cluster could not decode hold msg for '%s > %s' [%s]",
+							mset.account(), mset.name(), node.Group())
+					}
+					panic(err.Error())
+				}
+				// Placing a hold we have or releasing one we do not is a no-op, so replays are safe.
+				s, cc := js.server(), js.cluster
+				holds, err := mset.setSubjectHold(sh.Hold, sh.Reason, sh.Release, sh.Created)
+				if err != nil && !isRecovering && !IsNatsErr(err, JSStreamHoldNotFoundErr) {
+					s.Warnf("JetStream cluster failed to update holds of stream %q for account %q: %v", sh.Stream, sh.Client.serviceAccount(), err)
+				}
+
+				js.mu.RLock()
+				isLeader := cc.isStreamLeader(sh.Client.serviceAccount(), sh.Stream)
+				js.mu.RUnlock()
+
+				if isLeader && !isRecovering {
+					var resp = JSApiStreamHoldResponse{ApiResponse: ApiResponse{Type: JSApiStreamHoldResponseType}}
+					if err != nil {
+						if apiErr, ok := err.(*ApiError); ok {
+							resp.Error = apiErr
+						} else {
+							resp.Error = NewJSStreamHoldInvalidError(err)
+						}
+						s.sendAPIErrResponse(sh.Client, mset.account(), sh.Subject, sh.Reply, _EMPTY_, s.jsonResponse(resp))
+					} else {
+						resp.Holds = holds
+						s.sendAPIResponse(sh.Client, mset.account(), sh.Subject, sh.Reply, _EMPTY_, s.jsonResponse(resp))
+					}
+				}
 			case purgeStreamOp:
 				sp, err := decodeStreamPurge(buf[1:])
 				if err != nil {
@@ -2530,6 +2696,40 @@ func (js *jetStream) applyStreamEntries(mset *stream, ce *CommittedEntry, isReco
 						s.sendAPIResponse(sp.Client, mset.account(), sp.Subject, sp.Reply, _EMPTY_, s.jsonResponse(resp))
 					}
 				}
+			case remapSubjectsOp:
+				sr, err := decodeStreamRemap(buf[1:])
+				if err != nil || sr.Request == nil {
+					if node := mset.raftNode(); node != nil {
+						s := js.srv
+						s.Errorf("JetStream cluster could not decode remap msg for '%s > %s' [%s]",
+							mset.account(), mset.name(), node.Group())
+					}
+					panic("could not decode remap msg")
+				}
+
+				// Remaps are bounded by the last sequence at the time of the request and
+				// remapped subjects never match the filter again, so replays are safe.
+				s := js.server()
+				remapped, err := mset.remapSubjects(sr.Request.Filter, sr.Request.Destination, sr.LastSeq)
+				if err != nil {
+					s.Warnf("JetStream cluster failed to remap subjects of stream %q for account %q: %v", sr.Stream, sr.Client.serviceAccount(), err)
+				}
+
+				js.mu.RLock()
+				isLeader := js.cluster.isStreamLeader(sr.Client.serviceAccount(), sr.Stream)
+				js.mu.RUnlock()
+
+				if isLeader && !isRecovering {
+					var resp = JSApiStreamRemapResponse{ApiResponse: ApiResponse{Type: JSApiStreamRemapResponseType}}
+					if err != nil {
+						resp.Error = NewJSStreamGeneralError(err, Unless(err))
+						s.sendAPIErrResponse(sr.Client, mset.account(), sr.Subject, sr.Reply, _EMPTY_, s.jsonResponse(resp))
+					} else {
+						resp.Remapped = remapped
+						resp.Success = true
+						s.sendAPIResponse(sr.Client, mset.account(), sr.Subject, sr.Reply, _EMPTY_, s.jsonResponse(resp))
+					}
+				}
 			default:
 				panic("JetStream Cluster Unknown group entry op type!")
 			}
@@ -2540,9 +2740,18 @@ func (js *jetStream) applyStreamEntries(mset *stream, ce *CommittedEntry, isReco
 					return err
 				}
 				if !mset.IsLeader() {
+					// A frozen store would reject the catchup, so we take the freeze from the snapshot once caught up.
+					if mset.isFrozen() {
+						mset.setFrozen(false)
+					}
+					// Holds go first so messages we catch up on are kept from our limits.
+					if err := mset.replaceSubjectHolds(snap.Holds); err != nil {
+						return err
+					}
 					if err := mset.processSnapshot(&snap); err != nil {
 						return err
 					}
+					mset.setFrozen(snap.Frozen)
 				}
 			} else if isRecovering && mset != nil {
 				// On recovery, reset CLFS/FAILED.
@@ -2554,6 +2763,9 @@ func (js *jetStream) applyStreamEntries(mset *stream, ce *CommittedEntry, isReco
 				mset.mu.Lock()
 				mset.clfs = snap.Failed
 				mset.mu.Unlock()
+
+				// Freezing is not kept by the store itself.
+				mset.setFrozen(snap.Frozen)
 			}
 		} else if e.Type == EntryRemovePeer {
 			js.mu.RLock()
@@ -2810,6 +3022,7 @@ func (js *jetStream) processStreamAssignment(sa *streamAssignment) bool {
 	accStreams[stream] = sa
 	cc.streams[accName] = accStreams
 	hasResponded := sa.responded
+	js.setSourceTokens(accName, stream, sa.Config.SourceTokens)
 	js.mu.Unlock()
 
 	acc, err := s.LookupAccount(accName)
@@ -2903,6 +3116,7 @@ func (js *jetStream) processUpdateStreamAssignment(sa *streamAssignment) {
 	// Update our state.
 	accStreams[stream] = sa
 	cc.streams[accName] = accStreams
+	js.setSourceTokens(accName, stream, sa.Config.SourceTokens)
 
 	// Make sure we respond if we are a member.
 	if isMember {
@@ -3090,7 +3304,7 @@ func (js *jetStream) processClusterCreateStream(acc *Account, sa *streamAssignme
 		if len(rg.Peers) == 1 || rg.node != nil && rg.node.ID() == rg.Preferred {
 			shouldCreate = false
 		} else {
-			sa.Restore = nil
+			sa.Restore, sa.RestoreEncryption, sa.RestoreManifest = nil, nil, nil
 		}
 	}
 
@@ -3207,7 +3421,7 @@ func (js *jetStream) processClusterCreateStream(acc *Account, sa *streamAssignme
 		// If we are restoring, process that first.
 		if sa.Restore != nil {
 			// We are restoring a stream here.
-			restoreDoneCh := s.processStreamRestore(sa.Client, acc, sa.Config, _EMPTY_, sa.Reply, _EMPTY_)
+			restoreDoneCh := s.processStreamRestore(sa.Client, acc, sa.Config, sa.RestoreEncryption, sa.RestoreManifest, _EMPTY_, sa.Reply, _EMPTY_)
 			s.startGoRoutine(func() {
 				defer s.grWG.Done()
 				select {
@@ -3316,6 +3530,7 @@ func (js *jetStream) processStreamRemoval(sa *streamAssignment) {
 		if len(accStreams) == 0 {
 			delete(cc.streams, sa.Client.serviceAccount())
 		}
+		js.setSourceTokens(sa.Client.serviceAccount(), stream, nil)
 	}
 	js.mu.Unlock()
 
@@ -3964,6 +4179,8 @@ func (o *consumer) raftNode() RaftNode {
 func (js *jetStream) monitorConsumer(o *consumer, ca *consumerAssignment) {
 	s, n, cc := js.server(), o.raftNode(), js.cluster
 	defer s.grWG.Done()
+	release := s.pinToCPUs(cpuPoolRaft, s.getOpts().CPUAffinity.Raft)
+	defer release()
 
 	if n == nil {
 		s.Warnf("No RAFT group for '%s > %s > %s'", o.acc.Name, ca.Stream, ca.Name)
@@ -4307,6 +4524,7 @@ func (o *consumer) processReplicatedAck(dseq, sseq uint64) {
 			sagap = sseq - state.AckFloor.Stream
 		}
 	}
+	chunks := o.chunkSeqs(sseq)
 	o.mu.Unlock()
 
 	if sagap > 1 {
@@ -4317,6 +4535,9 @@ func (o *consumer) processReplicatedAck(dseq, sseq uint64) {
 	} else {
 		mset.ackMsg(o, sseq)
 	}
+	for _, seq := range chunks {
+		mset.ackMsg(o, seq)
+	}
 }
 
 var errBadAckUpdate = errors.New("jetstream cluster bad replicated ack update")
@@ -4646,6 +4867,9 @@ func (js *jetStream) startUpdatesSub() {
 	if js.accountPurge == nil {
 		js.accountPurge, _ = s.systemSubscribe(JSApiAccountPurge, _EMPTY_, false, c, s.jsLeaderAccountPurgeRequest)
 	}
+	if js.accountBackup == nil {
+		js.accountBackup, _ = s.systemSubscribe(JSApiAccountBackupPoint, _EMPTY_, false, c, s.jsLeaderAccountBackupPointRequest)
+	}
 }
 
 // Lock should be held.
@@ -4679,6 +4903,10 @@ func (js *jetStream) stopUpdatesSub() {
 		cc.s.sysUnsubscribe(js.accountPurge)
 		js.accountPurge = nil
 	}
+	if js.accountBackup != nil {
+		cc.s.sysUnsubscribe(js.accountBackup)
+		js.accountBackup = nil
+	}
 }
 
 func (js *jetStream) processLeaderChange(isLeader bool) {
@@ -4843,11 +5071,8 @@ func (cc *jetStreamCluster) selectPeerGroup(r int, cluster string, cfg *StreamCo
 		maxBytes = uint64(cfg.MaxBytes)
 	}
 
-	// Check for tags.
-	var tags []string
-	if cfg.Placement != nil && len(cfg.Placement.Tags) > 0 {
-		tags = cfg.Placement.Tags
-	}
+	// Check for tags, including those required by the stream's tier.
+	tags := cc.s.placementTags(cfg)
 
 	// Used for weighted sorting based on availability.
 	type wn struct {
@@ -5628,7 +5853,10 @@ func (s *Server) jsClusteredStreamUpdateRequest(ci *ClientInfo, acc *Account, su
 		rg.Preferred = _EMPTY_
 	}
 
-	sa := &streamAssignment{Group: rg, Sync: osa.Sync, Created: osa.Created, Config: newCfg, Subject: subject, Reply: reply, Client: ci}
+	sa := &streamAssignment{Group: rg, Sync: osa.Sync, Created: osa.Created, Config: newCfg, Subject: subject, Reply: reply, Client: ci, ConfigHistory: osa.ConfigHistory}
+	if !reflect.DeepEqual(osa.Config, newCfg) {
+		sa.ConfigHistory = osa.ConfigHistory.record(osa.Created, osa.Config)
+	}
 	cc.meta.Propose(encodeUpdateStreamAssignment(sa))
 
 	// Process any staged consumers.
@@ -5654,6 +5882,12 @@ func (s *Server) jsClusteredStreamDeleteRequest(ci *ClientInfo, acc *Account, st
 		s.sendAPIErrResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(&resp))
 		return
 	}
+	if apiErr := acc.checkStreamUnlocked(osa.Config); apiErr != nil {
+		var resp = JSApiStreamDeleteResponse{ApiResponse: ApiResponse{Type: JSApiStreamDeleteResponseType}}
+		resp.Error = apiErr
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(&resp))
+		return
+	}
 
 	sa := &streamAssignment{Group: osa.Group, Config: osa.Config, Subject: subject, Reply: reply, Client: ci}
 	cc.meta.Propose(encodeDeleteStreamAssignment(sa))
@@ -5706,6 +5940,34 @@ func (s *Server) jsClusteredStreamPurgeRequest(
 	s.sendAPIResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(resp))
 }
 
+func (s *Server) jsClusteredStreamRemapRequest(
+	ci *ClientInfo,
+	acc *Account,
+	mset *stream,
+	stream, subject, reply string,
+	rmsg []byte,
+	req *JSApiStreamRemapRequest,
+) {
+	js, cc := s.getJetStreamCluster()
+	if js == nil || cc == nil {
+		return
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	sa := js.streamAssignment(acc.Name, stream)
+	if sa == nil || sa.Group.node == nil {
+		resp := JSApiStreamRemapResponse{ApiResponse: ApiResponse{Type: JSApiStreamRemapResponseType}}
+		resp.Error = NewJSStreamNotFoundError()
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(&resp))
+		return
+	}
+
+	sr := &streamRemap{Stream: stream, LastSeq: mset.lastSeq(), Subject: subject, Reply: reply, Client: ci, Request: req}
+	sa.Group.node.Propose(encodeStreamRemap(sr))
+}
+
 func (s *Server) jsClusteredStreamRestoreRequest(
 	ci *ClientInfo,
 	acc *Account,
@@ -5747,6 +6009,7 @@ func (s *Server) jsClusteredStreamRestoreRequest(
 	sa := &streamAssignment{Group: rg, Sync: syncSubjForStream(), Config: cfg, Subject: subject, Reply: reply, Client: ci, Created: time.Now().UTC()}
 	// Now add in our restore state and pre-select a peer to handle the actual receipt of the snapshot.
 	sa.Restore = &req.State
+	sa.RestoreEncryption, sa.RestoreManifest = req.Encryption, req.Manifest
 	cc.meta.Propose(encodeAddStreamAssignment(sa))
 }
 
@@ -5768,7 +6031,7 @@ func (s *Server) allPeersOffline(rg *raftGroup) bool {
 
 // This will do a scatter and gather operation for all streams for this account. This is only called from metadata leader.
 // This will be running in a separate Go routine.
-func (s *Server) jsClusteredStreamListRequest(acc *Account, ci *ClientInfo, filter string, offset int, subject, reply string, rmsg []byte) {
+func (s *Server) jsClusteredStreamListRequest(acc *Account, ci *ClientInfo, filter string, offset int, streaming bool, subject, reply string, rmsg []byte) {
 	defer s.grWG.Done()
 
 	js, cc := s.getJetStreamCluster()
@@ -5817,6 +6080,60 @@ func (s *Server) jsClusteredStreamListRequest(acc *Account, ci *ClientInfo, filt
 	if offset > 0 {
 		streams = streams[offset:]
 	}
+
+	if streaming {
+		var missingNames []string
+		var offline []*StreamInfo
+		var reqs []listInfoRequest
+		consCounts := make(map[string]int, len(streams))
+		for _, sa := range streams {
+			if s.allPeersOffline(sa.Group) {
+				offline = append(offline, &StreamInfo{Config: *sa.Config, Created: sa.Created, Cluster: js.offlineClusterInfo(sa.Group)})
+				missingNames = append(missingNames, sa.Config.Name)
+			} else {
+				reqs = append(reqs, listInfoRequest{sa.Config.Name, fmt.Sprintf(clusterStreamInfoT, sa.Client.serviceAccount(), sa.Config.Name)})
+				consCounts[sa.Config.Name] = len(sa.consumers)
+			}
+		}
+		js.mu.RUnlock()
+
+		sendInfo := func(si *StreamInfo) {
+			s.sendInternalAccountMsg(nil, reply, s.jsonResponse(&JSApiStreamInfoResponse{
+				ApiResponse: ApiResponse{Type: JSApiStreamInfoResponseType},
+				StreamInfo:  si,
+			}))
+		}
+		for _, si := range offline {
+			sendInfo(si)
+		}
+		missing, ok := s.jsClusteredListGather(reqs, func(msg []byte) string {
+			var si StreamInfo
+			if err := json.Unmarshal(msg, &si); err != nil {
+				s.Warnf("Error unmarshaling clustered stream info response:%v", err)
+				return _EMPTY_
+			}
+			if consCount := consCounts[si.Config.Name]; consCount > 0 {
+				si.State.Consumers = consCount
+			}
+			sendInfo(&si)
+			return si.Config.Name
+		})
+		if !ok {
+			return
+		}
+		if len(missing) > 0 {
+			s.Warnf("Did not receive all stream info results for %q", acc)
+		}
+		resp := JSApiStreamListResponse{
+			ApiResponse: ApiResponse{Type: JSApiStreamListResponseType},
+			ApiPaged:    ApiPaged{Total: scnt, Offset: offset},
+			Streams:     []*StreamInfo{},
+			Missing:     append(missingNames, missing...),
+		}
+		s.sendAPIResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(resp))
+		return
+	}
+
 	if len(streams) > JSApiListLimit {
 		streams = streams[:JSApiListLimit]
 	}
@@ -5929,7 +6246,7 @@ LOOP:
 
 // This will do a scatter and gather operation for all consumers for this stream and account.
 // This will be running in a separate Go routine.
-func (s *Server) jsClusteredConsumerListRequest(acc *Account, ci *ClientInfo, offset int, stream, subject, reply string, rmsg []byte) {
+func (s *Server) jsClusteredConsumerListRequest(acc *Account, ci *ClientInfo, offset int, streaming bool, stream, subject, reply string, rmsg []byte) {
 	defer s.grWG.Done()
 
 	js, cc := s.getJetStreamCluster()
@@ -5962,6 +6279,55 @@ func (s *Server) jsClusteredConsumerListRequest(acc *Account, ci *ClientInfo, of
 	if offset > 0 {
 		consumers = consumers[offset:]
 	}
+
+	if streaming {
+		var missingNames []string
+		var offline []*ConsumerInfo
+		var reqs []listInfoRequest
+		for _, ca := range consumers {
+			if s.allPeersOffline(ca.Group) {
+				offline = append(offline, &ConsumerInfo{Config: ca.Config, Created: ca.Created, Cluster: js.offlineClusterInfo(ca.Group)})
+				missingNames = append(missingNames, ca.Name)
+			} else {
+				reqs = append(reqs, listInfoRequest{ca.Name, fmt.Sprintf(clusterConsumerInfoT, ca.Client.serviceAccount(), stream, ca.Name)})
+			}
+		}
+		js.mu.RUnlock()
+
+		sendInfo := func(ci *ConsumerInfo) {
+			s.sendInternalAccountMsg(nil, reply, s.jsonResponse(&JSApiConsumerInfoResponse{
+				ApiResponse:  ApiResponse{Type: JSApiConsumerInfoResponseType},
+				ConsumerInfo: ci,
+			}))
+		}
+		for _, ci := range offline {
+			sendInfo(ci)
+		}
+		missing, ok := s.jsClusteredListGather(reqs, func(msg []byte) string {
+			var ci ConsumerInfo
+			if err := json.Unmarshal(msg, &ci); err != nil {
+				s.Warnf("Error unmarshaling clustered consumer info response:%v", err)
+				return _EMPTY_
+			}
+			sendInfo(&ci)
+			return ci.Name
+		})
+		if !ok {
+			return
+		}
+		if len(missing) > 0 {
+			s.Warnf("Did not receive all consumer info results for '%s > %s'", acc, stream)
+		}
+		resp := JSApiConsumerListResponse{
+			ApiResponse: ApiResponse{Type: JSApiConsumerListResponseType},
+			ApiPaged:    ApiPaged{Total: ocnt, Offset: offset},
+			Consumers:   []*ConsumerInfo{},
+			Missing:     append(missingNames, missing...),
+		}
+		s.sendAPIResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(resp))
+		return
+	}
+
 	if len(consumers) > JSApiListLimit {
 		consumers = consumers[:JSApiListLimit]
 	}
@@ -6069,6 +6435,89 @@ LOOP:
 	s.sendAPIResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(resp))
 }
 
+// listInfoRequest is the info request for a single result of a streamed list.
+type listInfoRequest struct {
+	name string
+	subj string
+}
+
+// This will scatter the info requests for a streamed list while keeping at most a page of them in flight,
+// so listing a large number of assets does not flood the cluster. Responses are handed to cb as they come
+// in, which returns the name of the asset the response was for. Returns the names we did not hear back
+// about, and false if we are shutting down.
+func (s *Server) jsClusteredListGather(reqs []listInfoRequest, cb func(msg []byte) string) ([]string, bool) {
+	if len(reqs) == 0 {
+		return nil, true
+	}
+
+	s.mu.Lock()
+	inbox := s.newRespInbox()
+	rc := make(chan []byte, JSApiListLimit)
+
+	// Store our handler.
+	s.sys.replies[inbox] = func(sub *subscription, _ *client, _ *Account, subject, _ string, msg []byte) {
+		select {
+		case rc <- copyBytes(msg):
+		default:
+			s.Warnf("Failed placing list info result on internal channel")
+		}
+	}
+	s.mu.Unlock()
+
+	// Cleanup after.
+	defer func() {
+		s.mu.Lock()
+		if s.sys != nil && s.sys.replies != nil {
+			delete(s.sys.replies, inbox)
+		}
+		s.mu.Unlock()
+	}()
+
+	inflight := make(map[string]struct{}, JSApiListLimit)
+	sendMore := func() {
+		for len(inflight) < JSApiListLimit && len(reqs) > 0 {
+			req := reqs[0]
+			reqs = reqs[1:]
+			s.sendInternalMsgLocked(req.subj, inbox, nil, nil)
+			inflight[req.name] = struct{}{}
+		}
+	}
+	sendMore()
+
+	// We give up on what is in flight when nothing came back for this long.
+	const timeout = 4 * time.Second
+	notActive := time.NewTimer(timeout)
+	defer notActive.Stop()
+
+	var missingNames []string
+	for len(inflight) > 0 {
+		select {
+		case <-s.quitCh:
+			return nil, false
+		case <-notActive.C:
+			for name := range inflight {
+				missingNames = append(missingNames, name)
+				delete(inflight, name)
+			}
+		case msg := <-rc:
+			name := cb(msg)
+			if _, ok := inflight[name]; !ok {
+				continue
+			}
+			delete(inflight, name)
+			if !notActive.Stop() {
+				select {
+				case <-notActive.C:
+				default:
+				}
+			}
+		}
+		sendMore()
+		notActive.Reset(timeout)
+	}
+	return missingNames, true
+}
+
 func encodeStreamPurge(sp *streamPurge) []byte {
 	var bb bytes.Buffer
 	bb.WriteByte(byte(purgeStreamOp))
@@ -6082,6 +6531,19 @@ func decodeStreamPurge(buf []byte) (*streamPurge, error) {
 	return &sp, err
 }
 
+func encodeStreamRemap(sr *streamRemap) []byte {
+	var bb bytes.Buffer
+	bb.WriteByte(byte(remapSubjectsOp))
+	json.NewEncoder(&bb).Encode(sr)
+	return bb.Bytes()
+}
+
+func decodeStreamRemap(buf []byte) (*streamRemap, error) {
+	var sr streamRemap
+	err := json.Unmarshal(buf, &sr)
+	return &sr, err
+}
+
 func (s *Server) jsClusteredConsumerDeleteRequest(ci *ClientInfo, acc *Account, stream, consumer, subject, reply string, rmsg []byte) {
 	js, cc := s.getJetStreamCluster()
 	if js == nil || cc == nil {
@@ -6174,6 +6636,158 @@ func (s *Server) jsClusteredMsgDeleteRequest(ci *ClientInfo, acc *Account, mset
 	s.sendAPIResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(resp))
 }
 
+func encodeMsgDeleteRange(mr *streamMsgDeleteRange) []byte {
+	var bb bytes.Buffer
+	bb.WriteByte(byte(deleteRangeOp))
+	json.NewEncoder(&bb).Encode(mr)
+	return bb.Bytes()
+}
+
+func decodeMsgDeleteRange(buf []byte) (*streamMsgDeleteRange, error) {
+	var mr streamMsgDeleteRange
+	err := json.Unmarshal(buf, &mr)
+	return &mr, err
+}
+
+func (s *Server) jsClusteredMsgDeleteRangeRequest(ci *ClientInfo, acc *Account, mset *stream, stream, subject, reply string, req *JSApiMsgDeleteRangeRequest, rmsg []byte) {
+	js, cc := s.getJetStreamCluster()
+	if js == nil || cc == nil {
+		return
+	}
+
+	js.mu.Lock()
+	sa := js.streamAssignment(acc.Name, stream)
+	if sa == nil {
+		s.Debugf("Message range delete failed, could not locate stream '%s > %s'", acc.Name, stream)
+		js.mu.Unlock()
+		return
+	}
+
+	// Check for single replica items.
+	if n := sa.Group.node; n != nil {
+		mr := streamMsgDeleteRange{First: req.First, Last: req.Last, Stream: stream, Subject: subject, Reply: reply, Client: ci}
+		n.Propose(encodeMsgDeleteRange(&mr))
+		js.mu.Unlock()
+		return
+	}
+	js.mu.Unlock()
+
+	if mset == nil {
+		return
+	}
+
+	var err error
+	var resp = JSApiMsgDeleteRangeResponse{ApiResponse: ApiResponse{Type: JSApiMsgDeleteRangeResponseType}}
+	if resp.Removed, err = mset.removeRange(req.First, req.Last); err != nil {
+		resp.Error = NewJSStreamMsgDeleteFailedError(err, Unless(err))
+	}
+	s.sendAPIResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(resp))
+}
+
+func encodeStreamFreeze(sf *streamFreeze) []byte {
+	var bb bytes.Buffer
+	bb.WriteByte(byte(freezeStreamOp))
+	json.NewEncoder(&bb).Encode(sf)
+	return bb.Bytes()
+}
+
+func decodeStreamFreeze(buf []byte) (*streamFreeze, error) {
+	var sf streamFreeze
+	err := json.Unmarshal(buf, &sf)
+	return &sf, err
+}
+
+func (s *Server) jsClusteredStreamFreezeRequest(ci *ClientInfo, acc *Account, mset *stream, stream, subject, reply string, req *JSApiStreamFreezeRequest, rmsg []byte) {
+	js, cc := s.getJetStreamCluster()
+	if js == nil || cc == nil {
+		return
+	}
+
+	js.mu.Lock()
+	sa := js.streamAssignment(acc.Name, stream)
+	if sa == nil {
+		s.Debugf("Stream freeze failed, could not locate stream '%s > %s'", acc.Name, stream)
+		js.mu.Unlock()
+		return
+	}
+
+	// Check for single replica items.
+	if n := sa.Group.node; n != nil {
+		sf := streamFreeze{Frozen: req.Frozen, Stream: stream, Subject: subject, Reply: reply, Client: ci}
+		n.Propose(encodeStreamFreeze(&sf))
+		js.mu.Unlock()
+		return
+	}
+	js.mu.Unlock()
+
+	if mset == nil {
+		return
+	}
+
+	mset.setFrozen(req.Frozen)
+	var resp = JSApiStreamFreezeResponse{ApiResponse: ApiResponse{Type: JSApiStreamFreezeResponseType}}
+	resp.Frozen = mset.isFrozen()
+	s.sendAPIResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(resp))
+}
+
+func encodeStreamHold(sh *streamHold) []byte {
+	var bb bytes.Buffer
+	bb.WriteByte(byte(holdStreamOp))
+	json.NewEncoder(&bb).Encode(sh)
+	return bb.Bytes()
+}
+
+func decodeStreamHold(buf []byte) (*streamHold, error) {
+	var sh streamHold
+	err := json.Unmarshal(buf, &sh)
+	return &sh, err
+}
+
+func (s *Server) jsClusteredStreamHoldRequest(ci *ClientInfo, acc *Account, mset *stream, stream, subject, reply string, req *JSApiStreamHoldRequest, rmsg []byte) {
+	js, cc := s.getJetStreamCluster()
+	if js == nil || cc == nil {
+		return
+	}
+
+	js.mu.Lock()
+	sa := js.streamAssignment(acc.Name, stream)
+	if sa == nil {
+		s.Debugf("Stream hold failed, could not locate stream '%s > %s'", acc.Name, stream)
+		js.mu.Unlock()
+		return
+	}
+
+	// The leader picks the creation time so all replicas agree on it.
+	created := time.Now().UTC()
+
+	// Check for single replica items.
+	if n := sa.Group.node; n != nil {
+		sh := streamHold{Hold: req.Subject, Reason: req.Reason, Release: req.Release, Created: created, Stream: stream, Subject: subject, Reply: reply, Client: ci}
+		n.Propose(encodeStreamHold(&sh))
+		js.mu.Unlock()
+		return
+	}
+	js.mu.Unlock()
+
+	if mset == nil {
+		return
+	}
+
+	var resp = JSApiStreamHoldResponse{ApiResponse: ApiResponse{Type: JSApiStreamHoldResponseType}}
+	holds, err := mset.setSubjectHold(req.Subject, req.Reason, req.Release, created)
+	if err != nil {
+		if apiErr, ok := err.(*ApiError); ok {
+			resp.Error = apiErr
+		} else {
+			resp.Error = NewJSStreamHoldInvalidError(err)
+		}
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(&resp))
+		return
+	}
+	resp.Holds = holds
+	s.sendAPIResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(resp))
+}
+
 func encodeAddStreamAssignment(sa *streamAssignment) []byte {
 	var bb bytes.Buffer
 	bb.WriteByte(byte(assignStreamOp))
@@ -6479,6 +7093,10 @@ func (s *Server) jsClusteredConsumerRequest(ci *ClientInfo, acc *Account, subjec
 			nca.Group.Peers = newPeerSet
 		}
 
+		// Keep the config we are replacing.
+		if !reflect.DeepEqual(ca.Config, cfg) {
+			nca.ConfigHistory = ca.ConfigHistory.record(ca.Created, ca.Config)
+		}
 		// Update config and client info on copy of existing.
 		nca.Config = cfg
 		nca.Client = ci
@@ -6665,12 +7283,14 @@ func encodeStreamMsgAllowCompress(subject, reply string, hdr, msg []byte, lseq u
 
 // StreamSnapshot is used for snapshotting and out of band catch up in clustered mode.
 type streamSnapshot struct {
-	Msgs     uint64   `json:"messages"`
-	Bytes    uint64   `json:"bytes"`
-	FirstSeq uint64   `json:"first_seq"`
-	LastSeq  uint64   `json:"last_seq"`
-	Failed   uint64   `json:"clfs"`
-	Deleted  []uint64 `json:"deleted,omitempty"`
+	Msgs     uint64         `json:"messages"`
+	Bytes    uint64         `json:"bytes"`
+	FirstSeq uint64         `json:"first_seq"`
+	LastSeq  uint64         `json:"last_seq"`
+	Failed   uint64         `json:"clfs"`
+	Deleted  []uint64       `json:"deleted,omitempty"`
+	Frozen   bool           `json:"frozen,omitempty"`
+	Holds    []*SubjectHold `json:"holds,omitempty"`
 }
 
 // Grab a snapshot of a stream for clustered mode.
@@ -6690,7 +7310,9 @@ func (mset *stream) stateSnapshotLocked() []byte {
 		FirstSeq: state.FirstSeq,
 		LastSeq:  state.LastSeq,
 		Failed:   mset.clfs,
-		Deleted:  state.Deleted,
+		Deleted:  state.DeletedSeqs(),
+		Frozen:   mset.store.IsFrozen(),
+		Holds:    mset.holds,
 	}
 	b, _ := json.Marshal(snap)
 	return b
@@ -7037,6 +7659,7 @@ var (
 	errCatchupStreamStopped   = errors.New("stream has been stopped") // when a catchup is terminated due to the stream going away.
 	errCatchupBadMsg          = errors.New("bad catchup msg")
 	errCatchupWrongSeqForSkip = errors.New("wrong sequence for skipped msg")
+	errCatchupPeerNotCurrent  = errors.New("peer can not serve catchup") // sent back by a peer we asked directly.
 )
 
 // Process a stream snapshot.
@@ -7118,6 +7741,11 @@ func (mset *stream) processSnapshot(snap *streamSnapshot) (e error) {
 	// On exit, we will release our semaphore if we acquired it.
 	defer releaseSyncOutSem()
 
+	// Replica we are catching up from, empty if the leader.
+	// Once catching up from a peer failed we will only use the leader.
+	var source string
+	var leaderOnly bool
+
 	// Check our final state when we exit cleanly.
 	// If this snapshot was for messages no longer held by the leader we want to make sure
 	// we are synched for the next message sequence properly.
@@ -7151,6 +7779,10 @@ RETRY:
 	// the semaphore.
 	releaseSyncOutSem()
 
+	if source != _EMPTY_ {
+		source, leaderOnly = _EMPTY_, true
+	}
+
 	if n.GroupLeader() == _EMPTY_ {
 		return fmt.Errorf("catchup for stream '%s > %s' aborted, no leader", mset.account(), mset.name())
 	}
@@ -7220,9 +7852,15 @@ RETRY:
 		s.Errorf("Could not subscribe to stream catchup: %v", err)
 		goto RETRY
 	}
-	// Send our sync request.
+	// Send our sync request, to a nearby replica if we have one.
+	syncSubj := subject
+	if !leaderOnly {
+		if source = mset.selectCatchupPeer(); source != _EMPTY_ {
+			syncSubj = syncPeerSubject(subject, source)
+		}
+	}
 	b, _ := json.Marshal(sreq)
-	s.sendInternalMsgLocked(subject, reply, nil, b)
+	s.sendInternalMsgLocked(syncSubj, reply, nil, b)
 	// Remember when we sent this out to avoimd loop spins on errors below.
 	reqSendTime := time.Now()
 
@@ -7248,6 +7886,13 @@ RETRY:
 					checkFinalState()
 					return nil
 				}
+				// Check if the peer we asked can not serve us.
+				if source != _EMPTY_ && string(msg) == errCatchupPeerNotCurrent.Error() {
+					s.Debugf("Catchup for stream '%s > %s' not served by peer %q, will use the leader",
+						mset.account(), mset.name(), source)
+					msgsQ.recycle(&mrecs)
+					goto RETRY
+				}
 				if lseq, err := mset.processCatchupMsg(msg); err == nil {
 					if mrec.reply != _EMPTY_ {
 						s.sendInternalMsgLocked(mrec.reply, _EMPTY_, nil, nil)
@@ -7386,6 +8031,86 @@ func (mset *stream) handleClusterSyncRequest(sub *subscription, c *client, _ *Ac
 	mset.srv.startGoRoutine(func() { mset.runCatchup(reply, &sreq) })
 }
 
+// Handles sync requests sent to this replica directly. We only serve them if we are
+// current and have all that is asked for, otherwise we tell the peer to use the leader.
+func (mset *stream) handlePeerSyncRequest(sub *subscription, c *client, _ *Account, subject, reply string, msg []byte) {
+	var sreq streamSyncRequest
+	if err := json.Unmarshal(msg, &sreq); err != nil {
+		// Log error.
+		return
+	}
+	mset.mu.RLock()
+	ok := mset.isCurrent()
+	if ok {
+		var state StreamState
+		mset.store.FastState(&state)
+		ok = state.FirstSeq <= sreq.FirstSeq && state.LastSeq >= sreq.LastSeq
+	}
+	mset.mu.RUnlock()
+
+	if !ok {
+		mset.srv.sendInternalMsgLocked(reply, _EMPTY_, nil, errCatchupPeerNotCurrent.Error())
+		return
+	}
+	mset.srv.startGoRoutine(func() { mset.runCatchup(reply, &sreq) })
+}
+
+// Will select a healthy replica that shares our catchup tag to catch up from, so that
+// recoveries stay within the same zone and off of the leader when possible.
+// Returns empty if we should catch up from the leader.
+func (mset *stream) selectCatchupPeer() string {
+	s := mset.srv
+	opts := s.getOpts()
+	if opts.JetStreamCatchupTag == _EMPTY_ {
+		return _EMPTY_
+	}
+	var tag string
+	for _, t := range opts.Tags {
+		if strings.HasPrefix(t, opts.JetStreamCatchupTag) {
+			tag = t
+			break
+		}
+	}
+	if tag == _EMPTY_ {
+		return _EMPTY_
+	}
+
+	mset.mu.RLock()
+	js, sa, n := mset.js, mset.sa, mset.node
+	mset.mu.RUnlock()
+	if sa == nil || n == nil {
+		return _EMPTY_
+	}
+	js.mu.RLock()
+	peers := copyStrings(sa.Group.Peers)
+	js.mu.RUnlock()
+
+	ourID, leader := n.ID(), n.GroupLeader()
+	var candidates []string
+	for _, peer := range peers {
+		if peer == ourID {
+			continue
+		}
+		sir, ok := s.nodeToInfo.Load(peer)
+		if !ok || sir == nil {
+			continue
+		}
+		si := sir.(nodeInfo)
+		if si.offline || !si.tags.Contains(tag) {
+			continue
+		}
+		// Nothing to gain if the leader is nearby.
+		if peer == leader {
+			return _EMPTY_
+		}
+		candidates = append(candidates, peer)
+	}
+	if len(candidates) == 0 {
+		return _EMPTY_
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
 // Lock should be held.
 func (js *jetStream) offlineClusterInfo(rg *raftGroup) *ClusterInfo {
 	s := js.srv
@@ -7557,12 +8282,15 @@ func (mset *stream) handleClusterStreamInfoRequest(sub *subscription, c *client,
 	}
 
 	si := &StreamInfo{
-		Created: mset.createdTime(),
-		State:   mset.state(),
-		Config:  config,
-		Cluster: js.clusterInfo(mset.raftGroup()),
-		Sources: mset.sourcesInfo(),
-		Mirror:  mset.mirrorInfo(),
+		Created:    mset.createdTime(),
+		State:      mset.state(),
+		Config:     config,
+		Cluster:    js.clusterInfo(mset.raftGroup()),
+		Sources:    mset.sourcesInfo(),
+		Mirror:     mset.mirrorInfo(),
+		Stats:      mset.storeStats(),
+		Sink:       mset.sinkInfo(),
+		Recompress: mset.recompression(),
 	}
 
 	// Check for out of band catchups.
@@ -7649,7 +8377,6 @@ func (mset *stream) runCatchup(sendSubject string, sreq *streamSyncRequest) {
 	defer s.grWG.Done()
 
 	const maxOutBytes = int64(8 * 1024 * 1024) // 8MB for now, these are all internal, from server to server
-	const maxOutMsgs = int32(32 * 1024)
 	outb := int64(0)
 	outm := int32(0)
 
@@ -7751,6 +8478,10 @@ func (mset *stream) runCatchup(sendSubject string, sreq *streamSyncRequest) {
 			spb = 0
 		}
 
+		// Limits can be updated while we run so grab them for each batch.
+		srl, grl, maxPending := mset.catchupLimits()
+		maxOutMsgs := int32(maxPending)
+
 		var smv StoreMsg
 
 		for ; seq <= last && atomic.LoadInt64(&outb) <= maxOutBytes && atomic.LoadInt32(&outm) <= maxOutMsgs && s.gcbBelowMax(); seq++ {
@@ -7788,6 +8519,20 @@ func (mset *stream) runCatchup(sendSubject string, sreq *streamSyncRequest) {
 
 			// Place size in reply subject for flow control.
 			l := int64(len(em))
+
+			// Hold off if we are over our catchup rate.
+			if d := catchupDelay(int(l), srl, grl); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-s.quitCh:
+					return false
+				case <-qch:
+					return false
+				case <-remoteQuitCh:
+					return false
+				}
+				notActive.Reset(activityInterval)
+			}
 			reply := fmt.Sprintf(ackReplyT, l)
 			s.gcbAdd(&outb, l)
 			atomic.AddInt32(&outm, 1)
@@ -7849,6 +8594,11 @@ func (mset *stream) runCatchup(sendSubject string, sreq *streamSyncRequest) {
 
 const jscAllSubj = "$JSC.>"
 
+// Subject a replica listens on for sync requests sent to it directly.
+func syncPeerSubject(sync, peer string) string {
+	return sync + tsep + peer
+}
+
 func syncSubjForStream() string {
 	return syncSubject("$JSC.SYNC")
 }
@@ -7246,6 +7246,114 @@ func TestJetStreamClusterMaxOutstandingCatchup(t *testing.T) {
 	require_Error(t, err, fmt.Errorf("config reload not supported for JetStreamMaxCatchup: old=1024, new=1048576"))
 }
 
+func TestJetStreamClusterStreamCatchupLimits(t *testing.T) {
+	tmpl := `
+		listen: 127.0.0.1:-1
+		server_name: %s
+		jetstream: { catchup_rate: 1MB, catchup_max_pending: 16, max_mem_store: 256MB, max_file_store: 2GB, store_dir: '%s'}
+		cluster {
+			name: %s
+			listen: 127.0.0.1:%d
+			routes = [%s]
+		}
+	`
+	c := createJetStreamClusterWithTemplate(t, tmpl, "CL", 3)
+	defer c.shutdown()
+
+	for _, s := range c.servers {
+		opts := s.getOpts()
+		require_True(t, opts.JetStreamCatchupRate == 1024*1024)
+		require_True(t, opts.JetStreamCatchupMsgs == 16)
+	}
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	// Check validation.
+	for _, cl := range []*StreamCatchup{{Bytes: -1}, {MaxPending: -1}} {
+		req, _ := json.Marshal(&StreamConfig{Name: "BAD", Storage: FileStorage, Replicas: 3, Catchup: cl})
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, "BAD"), req, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+	}
+
+	cfg := &StreamConfig{
+		Name:     "TEST",
+		Subjects: []string{"foo"},
+		Storage:  FileStorage,
+		Replicas: 3,
+		Catchup:  &StreamCatchup{Bytes: 100 * 1024},
+	}
+	addStream(t, nc, cfg)
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+
+	follower := c.randomNonStreamLeader(globalAccountName, "TEST")
+	follower.Shutdown()
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+
+	nc.Close()
+	nc, js = jsClientConnect(t, c.streamLeader(globalAccountName, "TEST"))
+	defer nc.Close()
+
+	payload := make([]byte, 1024)
+	for i := 0; i < 300; i++ {
+		_, err := js.Publish("foo", payload)
+		require_NoError(t, err)
+	}
+
+	// Cause snapshot on leader so the follower needs to catch up.
+	mset, err := c.streamLeader(globalAccountName, "TEST").GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	require_NoError(t, mset.raftNode().InstallSnapshot(mset.stateSnapshot()))
+
+	srl, grl, maxPending := mset.catchupLimits()
+	require_True(t, srl != nil && srl.Limit() == 100*1024)
+	require_True(t, grl != nil && grl.Limit() == 1024*1024)
+	require_True(t, maxPending == 16)
+
+	// About 300KB at 100KB/s with a burst of 100KB.
+	start := time.Now()
+	follower = c.restartServer(follower)
+	checkFor(t, 20*time.Second, 100*time.Millisecond, func() error {
+		mset, err := follower.GlobalAccount().lookupStream("TEST")
+		if err != nil {
+			return err
+		}
+		if state := mset.state(); state.Msgs != 300 {
+			return fmt.Errorf("expected 300 msgs, got %d", state.Msgs)
+		}
+		return nil
+	})
+	require_True(t, time.Since(start) >= 1500*time.Millisecond)
+
+	// Limits can be changed at runtime.
+	cfg.Catchup = &StreamCatchup{MaxPending: 8}
+	req, _ := json.Marshal(cfg)
+	rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamUpdateT, "TEST"), req, time.Second)
+	require_NoError(t, err)
+	var resp JSApiStreamUpdateResponse
+	require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+	require_True(t, resp.Error == nil)
+	checkFor(t, 2*time.Second, 100*time.Millisecond, func() error {
+		srl, _, maxPending := mset.catchupLimits()
+		if srl != nil || maxPending != 8 {
+			return fmt.Errorf("catchup limits not updated")
+		}
+		return nil
+	})
+
+	s := c.servers[0]
+	cfile := s.getOpts().ConfigFile
+	content, err := os.ReadFile(cfile)
+	require_NoError(t, err)
+	conf := strings.ReplaceAll(string(content), "catchup_rate: 1MB", "catchup_rate: 2MB")
+	require_NoError(t, os.WriteFile(cfile, []byte(conf), 0644))
+	require_NoError(t, s.Reload())
+	require_True(t, s.getOpts().JetStreamCatchupRate == 2*1024*1024)
+}
+
 func TestJetStreamClusterCompressedStreamMessages(t *testing.T) {
 	c := createJetStreamClusterExplicit(t, "R3F", 3)
 	defer c.shutdown()
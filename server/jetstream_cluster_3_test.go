@@ -25,6 +25,7 @@ import (
 	"net"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -2620,3 +2621,1111 @@ func TestJetStreamClusterActiveActiveSourcedStreams(t *testing.T) {
 	})
 	require_NoError(t, err)
 }
+
+func TestJetStreamClusterStreamTierPlacement(t *testing.T) {
+	c := createJetStreamClusterWithTemplateAndModHook(t, jsClusterTempl, "C", 3,
+		func(serverName, clusterName, storeDir, conf string) string {
+			conf = strings.Replace(conf, "jetstream: {", "jetstream: {tiers: {fast: [ssd], bulk: hdd}, ", 1)
+			if serverName == "S-3" {
+				return fmt.Sprintf("%s\nserver_tags: [hdd]", conf)
+			}
+			return fmt.Sprintf("%s\nserver_tags: [ssd]", conf)
+		})
+	defer c.shutdown()
+
+	nc, _ := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	checkPeers := func(si *StreamInfo, expected ...string) {
+		t.Helper()
+		peers := []string{si.Cluster.Leader}
+		for _, r := range si.Cluster.Replicas {
+			peers = append(peers, r.Name)
+		}
+		sort.Strings(peers)
+		require_True(t, reflect.DeepEqual(peers, expected))
+	}
+
+	si := addStream(t, nc, &StreamConfig{Name: "FAST", Subjects: []string{"fast"}, Replicas: 2, Tier: "fast", Storage: FileStorage})
+	checkPeers(si, "S-1", "S-2")
+	require_True(t, si.Config.Tier == "fast")
+
+	si = addStream(t, nc, &StreamConfig{Name: "BULK", Subjects: []string{"bulk"}, Replicas: 1, Tier: "bulk", Storage: FileStorage})
+	checkPeers(si, "S-3")
+
+	// Not enough servers in the tier.
+	_, apiErr := addStreamWithError(t, nc, &StreamConfig{Name: "FAST3", Subjects: []string{"fast3"}, Replicas: 3, Tier: "fast", Storage: FileStorage})
+	require_True(t, apiErr != nil)
+	require_Contains(t, apiErr.Error(), "no suitable peers for placement")
+
+	// Unknown tier.
+	_, apiErr = addStreamWithError(t, nc, &StreamConfig{Name: "SLOW", Subjects: []string{"slow"}, Tier: "slow", Storage: FileStorage})
+	require_True(t, apiErr != nil)
+	require_Contains(t, apiErr.Error(), "unknown tier")
+
+	// The tier can not be changed.
+	req, err := json.Marshal(&StreamConfig{Name: "FAST", Subjects: []string{"fast"}, Replicas: 2, Tier: "bulk", Storage: FileStorage})
+	require_NoError(t, err)
+	rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamUpdateT, "FAST"), req, time.Second)
+	require_NoError(t, err)
+	var resp JSApiStreamUpdateResponse
+	require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+	require_True(t, resp.Error != nil)
+	require_Contains(t, resp.Error.Error(), "can not change tier")
+}
+
+func TestJetStreamClusterStreamDeletionProtection(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: FileStorage, Replicas: 3, DeletionProtection: true})
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+	for i := 0; i < 10; i++ {
+		_, err := js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+
+	request := func(subj string) *ApiResponse {
+		t.Helper()
+		resp, err := nc.Request(fmt.Sprintf(subj, "TEST"), nil, time.Second)
+		require_NoError(t, err)
+		var apiResp ApiResponse
+		require_NoError(t, json.Unmarshal(resp.Data, &apiResp))
+		return &apiResp
+	}
+	// Deletes are checked by the meta leader and purges by the stream leader, so all servers record the unlock.
+	unlock := func() {
+		t.Helper()
+		require_True(t, request(JSApiStreamUnlockT).Error == nil)
+		checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+			for _, s := range c.servers {
+				_, jsa, err := s.GlobalAccount().checkForJetStream()
+				require_NoError(t, err)
+				jsa.mu.RLock()
+				_, ok := jsa.unlocks["TEST"]
+				jsa.mu.RUnlock()
+				if !ok {
+					return fmt.Errorf("stream not unlocked on %s", s)
+				}
+			}
+			return nil
+		})
+	}
+
+	resp := request(JSApiStreamDeleteT)
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamDeletionProtectedErr))
+	resp = request(JSApiStreamPurgeT)
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamDeletionProtectedErr))
+
+	unlock()
+	require_True(t, request(JSApiStreamPurgeT).Error == nil)
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 0)
+
+	unlock()
+	require_True(t, request(JSApiStreamDeleteT).Error == nil)
+	_, err = js.StreamInfo("TEST")
+	require_Error(t, err, nats.ErrStreamNotFound)
+}
+
+func TestJetStreamClusterServerDrain(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R5S", 5)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("TEST%d", i)
+		_, err := js.AddStream(&nats.StreamConfig{Name: name, Subjects: []string{fmt.Sprintf("foo.%d", i)}, Replicas: 3})
+		require_NoError(t, err)
+		_, err = js.AddConsumer(name, &nats.ConsumerConfig{Durable: "DUR", AckPolicy: nats.AckExplicitPolicy})
+		require_NoError(t, err)
+		for j := 0; j < 10; j++ {
+			_, err = js.Publish(fmt.Sprintf("foo.%d", i), []byte("ok"))
+			require_NoError(t, err)
+		}
+	}
+	c.waitOnStreamLeader(globalAccountName, "TEST0")
+	// Drain the leader of the first stream so we hand off leadership as well as replicas.
+	sl := c.streamLeader(globalAccountName, "TEST0")
+
+	ncsys, err := nats.Connect(sl.ClientURL(), nats.UserInfo("admin", "s3cr3t!"))
+	require_NoError(t, err)
+	defer ncsys.Close()
+
+	drain := func(opts *JSDrainOptions) *JSDrainz {
+		t.Helper()
+		req, err := json.Marshal(opts)
+		require_NoError(t, err)
+		rmsg, err := ncsys.Request(fmt.Sprintf(serverDirectReqSubj, sl.ID(), "JS.DRAIN"), req, 2*time.Second)
+		require_NoError(t, err)
+		var resp struct {
+			Data  *JSDrainz `json:"data"`
+			Error *ApiError `json:"error"`
+		}
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		require_True(t, resp.Error == nil)
+		return resp.Data
+	}
+
+	dz := drain(&JSDrainOptions{Status: true})
+	require_False(t, dz.Draining)
+	require_True(t, dz.Phase == _EMPTY_)
+
+	dz = drain(&JSDrainOptions{Interval: 10 * time.Millisecond})
+	require_True(t, dz.Draining)
+
+	checkFor(t, 60*time.Second, 250*time.Millisecond, func() error {
+		if dz = drain(&JSDrainOptions{Status: true}); dz.Phase != JSDrainPhaseDone {
+			return fmt.Errorf("drain still in phase %q: %+v", dz.Phase, dz)
+		}
+		return nil
+	})
+	require_False(t, dz.Draining)
+	require_True(t, dz.Leaders >= 1)
+	require_True(t, dz.Moved == dz.Streams)
+	require_True(t, len(dz.Failed) == 0)
+
+	checkFor(t, 20*time.Second, 250*time.Millisecond, func() error {
+		jsz, err := sl.Jsz(nil)
+		if err != nil {
+			return err
+		}
+		if jsz.Streams != 0 || jsz.Consumers != 0 {
+			return fmt.Errorf("drained server still has %d streams and %d consumers", jsz.Streams, jsz.Consumers)
+		}
+		return nil
+	})
+
+	// Core NATS keeps working and streams are still available elsewhere.
+	ncsl, jssl := jsClientConnect(t, sl)
+	defer ncsl.Close()
+	for i := 0; i < 3; i++ {
+		si, err := jssl.StreamInfo(fmt.Sprintf("TEST%d", i))
+		require_NoError(t, err)
+		require_True(t, si.State.Msgs == 10)
+		require_True(t, si.Cluster.Leader != sl.Name())
+	}
+}
+
+func TestJetStreamClusterStreamingListRequests(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	// More than fit in a single page, so requests go out in several rounds.
+	numConsumers := JSApiListLimit + 10
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Replicas: 3})
+	require_NoError(t, err)
+	for i := 0; i < numConsumers; i++ {
+		_, err = js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: fmt.Sprintf("C%d", i), AckPolicy: nats.AckExplicitPolicy, Replicas: 1})
+		require_NoError(t, err)
+	}
+	for i := 0; i < 5; i++ {
+		_, err = js.AddStream(&nats.StreamConfig{Name: fmt.Sprintf("S%d", i), Subjects: []string{fmt.Sprintf("bar.%d", i)}})
+		require_NoError(t, err)
+	}
+
+	list := func(subj string, req any, summaryType string) (map[string]struct{}, []byte) {
+		t.Helper()
+		sub, err := nc.SubscribeSync(nats.NewInbox())
+		require_NoError(t, err)
+		defer sub.Unsubscribe()
+		b, err := json.Marshal(req)
+		require_NoError(t, err)
+		require_NoError(t, nc.PublishRequest(subj, sub.Subject, b))
+		names := make(map[string]struct{})
+		for {
+			m, err := sub.NextMsg(5 * time.Second)
+			require_NoError(t, err)
+			var resp struct {
+				ApiResponse
+				Name   string        `json:"name"`
+				Config *StreamConfig `json:"config"`
+			}
+			require_NoError(t, json.Unmarshal(m.Data, &resp))
+			switch resp.Type {
+			case summaryType:
+				return names, m.Data
+			case JSApiConsumerInfoResponseType:
+				names[resp.Name] = struct{}{}
+			case JSApiStreamInfoResponseType:
+				names[resp.Config.Name] = struct{}{}
+			default:
+				t.Fatalf("Unexpected response type %q", resp.Type)
+			}
+		}
+	}
+
+	names, summary := list(fmt.Sprintf(JSApiConsumerListT, "TEST"), &JSApiConsumersRequest{Streaming: true}, JSApiConsumerListResponseType)
+	require_True(t, len(names) == numConsumers)
+	var clist JSApiConsumerListResponse
+	require_NoError(t, json.Unmarshal(summary, &clist))
+	require_True(t, clist.Total == numConsumers)
+	require_True(t, len(clist.Missing) == 0)
+
+	names, summary = list(JSApiStreamList, &JSApiStreamListRequest{Streaming: true}, JSApiStreamListResponseType)
+	require_True(t, len(names) == 6)
+	var slist JSApiStreamListResponse
+	require_NoError(t, json.Unmarshal(summary, &slist))
+	require_True(t, slist.Total == 6)
+	require_True(t, len(slist.Missing) == 0)
+}
+
+func TestJetStreamClusterCatchupFromNearbyPeer(t *testing.T) {
+	c := createJetStreamClusterWithTemplateAndModHook(t, jsClusterTempl, "R3S", 3,
+		func(serverName, clusterName, storeDir, conf string) string {
+			tag := "az:2"
+			if strings.HasSuffix(serverName, "-1") {
+				tag = "az:1"
+			}
+			conf = strings.Replace(conf, "store_dir:", "catchup_tag: az, store_dir:", 1)
+			return fmt.Sprintf("%s\nserver_tags: [%q]\n", conf, tag)
+		})
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Replicas: 3})
+	require_NoError(t, err)
+
+	// Have the leader be on its own.
+	checkFor(t, 10*time.Second, 250*time.Millisecond, func() error {
+		c.waitOnStreamLeader(globalAccountName, "TEST")
+		sl := c.streamLeader(globalAccountName, "TEST")
+		if strings.HasSuffix(sl.Name(), "-1") {
+			return nil
+		}
+		_, err := nc.Request(fmt.Sprintf(JSApiStreamLeaderStepDownT, "TEST"), nil, time.Second)
+		require_NoError(t, err)
+		return fmt.Errorf("leader is %q", sl.Name())
+	})
+	sl := c.streamLeader(globalAccountName, "TEST")
+
+	var followers []*Server
+	for _, s := range c.servers {
+		if s != sl {
+			followers = append(followers, s)
+		}
+	}
+	sf, peer := followers[0], followers[1]
+
+	// Make sure the tags are known.
+	pmset, err := peer.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	peerID := pmset.raftNode().ID()
+	checkFor(t, 10*time.Second, 100*time.Millisecond, func() error {
+		sir, ok := sf.nodeToInfo.Load(peerID)
+		if !ok {
+			return fmt.Errorf("peer not known yet")
+		}
+		if si := sir.(nodeInfo); !si.tags.Contains("az:2") {
+			return fmt.Errorf("tags not known yet")
+		}
+		return nil
+	})
+
+	nc.Close()
+	nc, js = jsClientConnect(t, sl)
+	defer nc.Close()
+
+	sf.Shutdown()
+	for i := 0; i < 100; i++ {
+		_, err = js.Publish("foo", []byte("OK"))
+		require_NoError(t, err)
+	}
+	mset, err := sl.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	require_NoError(t, mset.raftNode().InstallSnapshot(mset.stateSnapshot()))
+
+	ncs, _ := jsClientConnect(t, sl, nats.UserInfo("admin", "s3cr3t!"))
+	defer ncs.Close()
+	sub, err := ncs.SubscribeSync("$JSC.SYNC.>")
+	require_NoError(t, err)
+	require_NoError(t, ncs.Flush())
+
+	sf = c.restartServer(sf)
+	c.waitOnStreamCurrent(sf, globalAccountName, "TEST")
+
+	// Should have asked the nearby replica and not the leader.
+	m, err := sub.NextMsg(5 * time.Second)
+	require_NoError(t, err)
+	if !strings.HasSuffix(m.Subject, tsep+peerID) {
+		t.Fatalf("Expected catchup request to go to peer %q, got %q", peerID, m.Subject)
+	}
+	_, err = sub.NextMsg(250 * time.Millisecond)
+	require_Error(t, err, nats.ErrTimeout)
+
+	mset, err = sf.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	require_True(t, mset.state().Msgs == 100)
+}
+
+func TestJetStreamClusterStreamRemapSubjects(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"orders.>"}, Storage: FileStorage, Replicas: 3})
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+	for i := 0; i < 10; i++ {
+		_, err := js.Publish(fmt.Sprintf("orders.old.%d", i%2), []byte("ok"))
+		require_NoError(t, err)
+	}
+
+	req, _ := json.Marshal(&JSApiStreamRemapRequest{Filter: "orders.old.*", Destination: "orders.new.$1"})
+	rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamRemapT, "TEST"), req, time.Second)
+	require_NoError(t, err)
+	var resp JSApiStreamRemapResponse
+	require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+	require_True(t, resp.Error == nil)
+	require_True(t, resp.Remapped == 10)
+
+	// All replicas should have remapped their messages.
+	checkRemapped := func() {
+		t.Helper()
+		checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+			for _, s := range c.servers {
+				mset, err := s.GlobalAccount().lookupStream("TEST")
+				if err != nil {
+					return err
+				}
+				if n := mset.store.FilteredState(1, "orders.new.*").Msgs; n != 10 {
+					return fmt.Errorf("expected 10 remapped messages on %s, got %d", s, n)
+				}
+			}
+			return nil
+		})
+	}
+	checkRemapped()
+
+	// Replaying the log on restart should not change anything.
+	sl := c.streamLeader(globalAccountName, "TEST")
+	sl.Shutdown()
+	c.restartServer(sl)
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+	checkRemapped()
+}
+
+func TestJetStreamClusterMsgDeleteRange(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: FileStorage, Replicas: 3})
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+	for i := 0; i < 100; i++ {
+		_, err := js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+
+	req, _ := json.Marshal(&JSApiMsgDeleteRangeRequest{First: 11, Last: 60})
+	rmsg, err := nc.Request(fmt.Sprintf(JSApiMsgDeleteRangeT, "TEST"), req, time.Second)
+	require_NoError(t, err)
+	var resp JSApiMsgDeleteRangeResponse
+	require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+	require_True(t, resp.Error == nil)
+	require_True(t, resp.Removed == 50)
+
+	// All replicas should have removed the range.
+	checkRemoved := func() {
+		t.Helper()
+		checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+			for _, s := range c.servers {
+				mset, err := s.GlobalAccount().lookupStream("TEST")
+				if err != nil {
+					return err
+				}
+				var state StreamState
+				mset.store.FastState(&state)
+				if state.Msgs != 50 || state.NumDeleted != 50 {
+					return fmt.Errorf("expected 50 msgs and 50 deleted on %s, got %d and %d", s, state.Msgs, state.NumDeleted)
+				}
+			}
+			return nil
+		})
+	}
+	checkRemoved()
+
+	// Replaying the log on restart should not change anything.
+	sl := c.streamLeader(globalAccountName, "TEST")
+	sl.Shutdown()
+	c.restartServer(sl)
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+	checkRemoved()
+}
+
+func TestJetStreamClusterStreamFreeze(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: FileStorage, Replicas: 3})
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+	for i := 0; i < 5; i++ {
+		_, err := js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+
+	freeze := func(frozen bool) *JSApiStreamFreezeResponse {
+		t.Helper()
+		req, err := json.Marshal(&JSApiStreamFreezeRequest{Frozen: frozen})
+		require_NoError(t, err)
+		resp, err := nc.Request(fmt.Sprintf(JSApiStreamFreezeT, "TEST"), req, time.Second)
+		require_NoError(t, err)
+		var fresp JSApiStreamFreezeResponse
+		require_NoError(t, json.Unmarshal(resp.Data, &fresp))
+		return &fresp
+	}
+	// All replicas should agree on being frozen and on the stream state.
+	checkReplicas := func(frozen bool, msgs, lseq uint64) {
+		t.Helper()
+		checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+			for _, s := range c.servers {
+				mset, err := s.GlobalAccount().lookupStream("TEST")
+				if err != nil {
+					return err
+				}
+				if mset.isFrozen() != frozen {
+					return fmt.Errorf("expected frozen to be %v on %s", frozen, s)
+				}
+				var state StreamState
+				mset.store.FastState(&state)
+				if state.Msgs != msgs || state.LastSeq != lseq {
+					return fmt.Errorf("expected %d msgs and last of %d on %s, got %d and %d", msgs, lseq, s, state.Msgs, state.LastSeq)
+				}
+			}
+			return nil
+		})
+	}
+
+	fresp := freeze(true)
+	require_True(t, fresp.Error == nil)
+	require_True(t, fresp.Frozen)
+	checkReplicas(true, 5, 5)
+
+	// New messages and removals are rejected by all replicas.
+	_, err := js.Publish("foo", []byte("no"))
+	require_Error(t, err)
+	require_Contains(t, err.Error(), "frozen")
+	require_Error(t, js.DeleteMsg("TEST", 1))
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 5)
+
+	// Stay frozen when restarting from a snapshot, and after a leader change.
+	sl := c.streamLeader(globalAccountName, "TEST")
+	for _, s := range c.servers {
+		mset, err := s.GlobalAccount().lookupStream("TEST")
+		require_NoError(t, err)
+		require_NoError(t, mset.raftNode().InstallSnapshot(mset.stateSnapshot()))
+	}
+	sl.Shutdown()
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+	c.restartServer(sl)
+	c.waitOnServerCurrent(sl)
+	checkReplicas(true, 5, 5)
+
+	fresp = freeze(false)
+	require_True(t, fresp.Error == nil)
+	require_False(t, fresp.Frozen)
+	pa, err := js.Publish("foo", []byte("ok"))
+	require_NoError(t, err)
+	require_True(t, pa.Sequence == 6)
+	checkReplicas(false, 6, 6)
+}
+
+func TestJetStreamClusterStreamSubjectHolds(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo.>"}, Storage: FileStorage, Replicas: 3, MaxMsgs: 5})
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+	// Stay connected to the stream leader since we will shut down a replica.
+	sl := c.streamLeader(globalAccountName, "TEST")
+	nc.Close()
+	nc, js = jsClientConnect(t, sl)
+	defer nc.Close()
+
+	hold := func(req *JSApiStreamHoldRequest) *JSApiStreamHoldResponse {
+		t.Helper()
+		b, err := json.Marshal(req)
+		require_NoError(t, err)
+		resp, err := nc.Request(fmt.Sprintf(JSApiStreamHoldT, "TEST"), b, time.Second)
+		require_NoError(t, err)
+		var hresp JSApiStreamHoldResponse
+		require_NoError(t, json.Unmarshal(resp.Data, &hresp))
+		return &hresp
+	}
+	// All replicas should have the same holds and keep the held messages.
+	checkReplicas := func(subjects ...string) {
+		t.Helper()
+		checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+			var expected []*SubjectHold
+			for _, s := range c.servers {
+				mset, err := s.GlobalAccount().lookupStream("TEST")
+				if err != nil {
+					return err
+				}
+				holds := mset.subjectHolds()
+				if len(holds) != len(subjects) {
+					return fmt.Errorf("expected %d holds on %s, got %d", len(subjects), s, len(holds))
+				}
+				for i, h := range holds {
+					if h.Subject != subjects[i] {
+						return fmt.Errorf("expected hold on %q on %s, got %q", subjects[i], s, h.Subject)
+					}
+				}
+				if expected == nil {
+					expected = holds
+				} else if !reflect.DeepEqual(holds, expected) {
+					return fmt.Errorf("holds on %s differ", s)
+				}
+				if n := mset.store.FilteredState(1, "foo.held").Msgs; n != 3 {
+					return fmt.Errorf("expected 3 held msgs on %s, got %d", s, n)
+				}
+			}
+			return nil
+		})
+	}
+
+	hresp := hold(&JSApiStreamHoldRequest{Subject: "foo.held", Reason: "litigation"})
+	require_True(t, hresp.Error == nil)
+	require_True(t, len(hresp.Holds) == 1)
+	hresp = hold(&JSApiStreamHoldRequest{Subject: "foo.none", Release: true})
+	require_True(t, hresp.Error != nil)
+	require_True(t, hresp.Error.ErrCode == uint16(JSStreamHoldNotFoundErr))
+
+	for i := 0; i < 3; i++ {
+		_, err := js.Publish("foo.held", []byte("held"))
+		require_NoError(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := js.Publish("foo.other", []byte("ok"))
+		require_NoError(t, err)
+	}
+	checkReplicas("foo.held")
+
+	// A replica that missed a hold catches up on it from the snapshot.
+	rs := c.randomNonStreamLeader(globalAccountName, "TEST")
+	rs.Shutdown()
+	hresp = hold(&JSApiStreamHoldRequest{Subject: "foo.more"})
+	require_True(t, hresp.Error == nil)
+	require_True(t, len(hresp.Holds) == 2)
+	// Make sure the hold is applied and compacted away before the replica comes back.
+	_, err := js.Publish("foo.other", []byte("ok"))
+	require_NoError(t, err)
+	mset, err := sl.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	require_NoError(t, mset.raftNode().InstallSnapshot(mset.stateSnapshot()))
+	rs = c.restartServer(rs)
+	c.waitOnServerCurrent(rs)
+	checkReplicas("foo.held", "foo.more")
+
+	// Releasing is replicated as well.
+	hresp = hold(&JSApiStreamHoldRequest{Subject: "foo.more", Release: true})
+	require_True(t, hresp.Error == nil)
+	require_True(t, len(hresp.Holds) == 1)
+	checkReplicas("foo.held")
+}
+
+func TestJetStreamClusterPartitionedStreamNotSupported(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, _ := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	request := func(subj string, cfg *StreamConfig) *ApiError {
+		t.Helper()
+		req, _ := json.Marshal(cfg)
+		rmsg, err := nc.Request(fmt.Sprintf(subj, cfg.Name), req, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		return resp.Error
+	}
+
+	cfg := &StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}, Storage: FileStorage, Partitions: 4}
+	apiErr := request(JSApiStreamCreateT, cfg)
+	require_True(t, apiErr != nil)
+	require_True(t, apiErr.ErrCode == uint16(JSStreamInvalidConfigF))
+	require_Contains(t, apiErr.Description, errStreamPartitionsClustered.Error())
+
+	// Nor can a stream be updated to be partitioned.
+	cfg.Partitions, cfg.Replicas = 0, 3
+	require_True(t, request(JSApiStreamCreateT, cfg) == nil)
+	cfg.Partitions = 4
+	apiErr = request(JSApiStreamUpdateT, cfg)
+	require_True(t, apiErr != nil)
+	require_Contains(t, apiErr.Description, errStreamPartitionsClustered.Error())
+}
+
+func TestJetStreamClusterMessageSchedule(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: FileStorage, Replicas: 3})
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+
+	m := nats.NewMsg("foo")
+	m.Header.Set(JSDelay, "500ms")
+	pa, err := js.PublishMsg(m)
+	require_NoError(t, err)
+
+	// All replicas should track the schedule.
+	checkFor(t, time.Second, 20*time.Millisecond, func() error {
+		for _, s := range c.servers {
+			mset, err := s.GlobalAccount().lookupStream("TEST")
+			if err != nil {
+				return err
+			}
+			if !mset.isScheduled(pa.Sequence) {
+				return fmt.Errorf("message not scheduled on %s", s)
+			}
+		}
+		return nil
+	})
+
+	// Once due the leader stores a copy and all replicas drop the original.
+	checkFor(t, 3*time.Second, 50*time.Millisecond, func() error {
+		for _, s := range c.servers {
+			mset, err := s.GlobalAccount().lookupStream("TEST")
+			if err != nil {
+				return err
+			}
+			var state StreamState
+			mset.store.FastState(&state)
+			if state.Msgs != 1 || state.FirstSeq != 2 {
+				return fmt.Errorf("unexpected state on %s: %+v", s, state)
+			}
+		}
+		return nil
+	})
+
+	rsm, err := js.GetMsg("TEST", 2)
+	require_NoError(t, err)
+	require_Equal(t, rsm.Header.Get(JSScheduledSequence), "1")
+}
+
+func TestJetStreamClusterConfigHistory(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	history := func(subj string) []*ConfigRevision {
+		t.Helper()
+		m, err := nc.Request(subj, nil, time.Second)
+		require_NoError(t, err)
+		var hresp JSApiConfigHistoryResponse
+		require_NoError(t, json.Unmarshal(m.Data, &hresp))
+		require_True(t, hresp.Error == nil)
+		return hresp.Revisions
+	}
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Replicas: 3})
+	require_NoError(t, err)
+	_, err = js.UpdateStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"bar"}, Replicas: 3})
+	require_NoError(t, err)
+	_, err = js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy, MaxDeliver: 5})
+	require_NoError(t, err)
+	_, err = js.UpdateConsumer("TEST", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy, MaxDeliver: 10})
+	require_NoError(t, err)
+	require_True(t, len(history(fmt.Sprintf(JSApiStreamHistoryT, "TEST"))) == 2)
+	require_True(t, len(history(fmt.Sprintf(JSApiConsumerHistoryT, "TEST", "dlc"))) == 2)
+
+	req, err := json.Marshal(&JSApiConfigRollbackRequest{Revision: 1})
+	require_NoError(t, err)
+	m, err := nc.Request(fmt.Sprintf(JSApiStreamRollbackT, "TEST"), req, time.Second)
+	require_NoError(t, err)
+	var uresp JSApiStreamUpdateResponse
+	require_NoError(t, json.Unmarshal(m.Data, &uresp))
+	require_True(t, uresp.Error == nil)
+	require_Equal(t, uresp.Config.Subjects[0], "foo")
+
+	m, err = nc.Request(fmt.Sprintf(JSApiConsumerRollbackT, "TEST", "dlc"), req, time.Second)
+	require_NoError(t, err)
+	var cresp JSApiConsumerCreateResponse
+	require_NoError(t, json.Unmarshal(m.Data, &cresp))
+	require_True(t, cresp.Error == nil)
+	require_True(t, cresp.Config.MaxDeliver == 5)
+
+	// The history is part of the meta state so it survives a snapshot and a new leader.
+	ml := c.leader()
+	require_NoError(t, ml.JetStreamSnapshotMeta())
+	require_NoError(t, ml.getJetStream().getMetaGroup().StepDown())
+	c.waitOnLeader()
+	require_True(t, c.leader() != ml)
+
+	revs := history(fmt.Sprintf(JSApiStreamHistoryT, "TEST"))
+	require_True(t, len(revs) == 3)
+	require_True(t, revs[2].Revision == 3)
+	require_True(t, len(history(fmt.Sprintf(JSApiConsumerHistoryT, "TEST", "dlc"))) == 3)
+}
+
+func TestJetStreamClusterAccountDrift(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Replicas: 3})
+	require_NoError(t, err)
+	_, err = js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy})
+	require_NoError(t, err)
+
+	desired := &DesiredStream{
+		Config:    StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: FileStorage, Replicas: 3},
+		Consumers: []*ConsumerConfig{{Durable: "dlc", AckPolicy: AckExplicit}},
+	}
+	drift := func() *JSApiAccountDriftResponse {
+		t.Helper()
+		b, err := json.Marshal(&JSApiAccountDriftRequest{Streams: []*DesiredStream{desired}})
+		require_NoError(t, err)
+		m, err := nc.Request(JSApiAccountDrift, b, time.Second)
+		require_NoError(t, err)
+		var resp JSApiAccountDriftResponse
+		require_NoError(t, json.Unmarshal(m.Data, &resp))
+		require_True(t, resp.Error == nil)
+		return &resp
+	}
+	require_True(t, drift().InSync)
+
+	desired.Config.Replicas = 1
+	resp := drift()
+	require_True(t, len(resp.Drift) == 1)
+	require_Equal(t, resp.Drift[0].Kind, DriftDiffers)
+	require_Equal(t, resp.Drift[0].Changes[0].Field, "num_replicas")
+}
+
+func TestJetStreamClusterStreamReplicaScaling(t *testing.T) {
+	old := replicaScalingInterval
+	replicaScalingInterval = 250 * time.Millisecond
+	defer func() { replicaScalingInterval = old }()
+
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	create := func(cfg *StreamConfig) *JSApiStreamCreateResponse {
+		t.Helper()
+		req, err := json.Marshal(cfg)
+		require_NoError(t, err)
+		msg, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, cfg.Name), req, 5*time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(msg.Data, &resp))
+		return &resp
+	}
+
+	// Replicas need to be within min and max.
+	resp := create(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, Storage: FileStorage, Replicas: 3, ReplicaScaling: &ReplicaScaling{Min: 1, Max: 2}})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+	resp = create(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, Storage: FileStorage, Replicas: 1, ReplicaScaling: &ReplicaScaling{Min: 2, Max: 1}})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+
+	sub := natsSubSync(t, nc, JSAdvisoryStreamScaledPre+".TEST")
+	natsFlush(t, nc)
+
+	resp = create(&StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: FileStorage, Replicas: 3, ReplicaScaling: &ReplicaScaling{Min: 1, Max: 3}})
+	require_True(t, resp.Error == nil)
+	c.waitOnStreamLeader(globalAccountName, "TEST")
+
+	checkScaled := func(from, to int) {
+		t.Helper()
+		msg, err := sub.NextMsg(10 * time.Second)
+		require_NoError(t, err)
+		var adv JSStreamReplicasScaledAdvisory
+		require_NoError(t, json.Unmarshal(msg.Data, &adv))
+		require_Equal(t, adv.Type, JSStreamReplicasScaledAdvisoryType)
+		require_True(t, adv.From == from && adv.To == to)
+		checkFor(t, 10*time.Second, 100*time.Millisecond, func() error {
+			si, err := js.StreamInfo("TEST")
+			if err != nil {
+				return err
+			}
+			if si.Config.Replicas != to {
+				return fmt.Errorf("Expected %d replicas, got %d", to, si.Config.Replicas)
+			}
+			return nil
+		})
+	}
+
+	// Losing a server scales us down, its return scales us back up.
+	s := c.randomNonLeader()
+	s.Shutdown()
+	checkScaled(3, 2)
+
+	s = c.restartServer(s)
+	c.waitOnServerCurrent(s)
+	checkScaled(2, 3)
+}
+
+func TestJetStreamClusterStreamBulk(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	bulk := func(op string, req *JSApiStreamBulkRequest) *JSApiStreamBulkResponse {
+		t.Helper()
+		b, err := json.Marshal(req)
+		require_NoError(t, err)
+		msg, err := nc.Request(fmt.Sprintf(JSApiStreamBulkT, op), b, 10*time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamBulkResponse
+		require_NoError(t, json.Unmarshal(msg.Data, &resp))
+		return &resp
+	}
+	streams := func() string {
+		t.Helper()
+		var names []string
+		for name := range js.StreamNames() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	var cfgs []*StreamConfig
+	for i := 0; i < 10; i++ {
+		cfgs = append(cfgs, &StreamConfig{Name: fmt.Sprintf("S%d", i), Subjects: []string{fmt.Sprintf("s.%d", i)}, Storage: FileStorage, Replicas: i%3 + 1})
+	}
+
+	// Subjects overlapping within the request.
+	bad := append([]*StreamConfig{}, cfgs...)
+	bad = append(bad, &StreamConfig{Name: "BAD", Subjects: []string{"s.*"}, Storage: FileStorage})
+	resp := bulk(JSStreamBulkCreate, &JSApiStreamBulkRequest{Streams: bad})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamSubjectOverlapErr))
+	require_Equal(t, resp.Failed, "BAD")
+	require_Equal(t, streams(), _EMPTY_)
+
+	resp = bulk(JSStreamBulkCreate, &JSApiStreamBulkRequest{Streams: cfgs})
+	require_True(t, resp.Error == nil)
+	require_True(t, len(resp.Streams) == 10)
+	for _, cfg := range cfgs {
+		c.waitOnStreamLeader(globalAccountName, cfg.Name)
+		si, err := js.StreamInfo(cfg.Name)
+		require_NoError(t, err)
+		require_True(t, si.Config.Replicas == cfg.Replicas)
+	}
+	_, err := js.Publish("s.3", []byte("ok"))
+	require_NoError(t, err)
+
+	// Creating the same streams again is fine.
+	resp = bulk(JSStreamBulkCreate, &JSApiStreamBulkRequest{Streams: cfgs[:2]})
+	require_True(t, resp.Error == nil)
+
+	// Replicas are not updated in bulk.
+	upd := []*StreamConfig{
+		{Name: "S0", Subjects: []string{"s.0"}, Storage: FileStorage, Replicas: 1, MaxMsgs: 5},
+		{Name: "S1", Subjects: []string{"s.1"}, Storage: FileStorage, Replicas: 3},
+	}
+	resp = bulk(JSStreamBulkUpdate, &JSApiStreamBulkRequest{Streams: upd})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamBulkInvalidErrF))
+	require_Equal(t, resp.Failed, "S1")
+	upd[1].Replicas, upd[1].MaxMsgs = 2, 5
+	resp = bulk(JSStreamBulkUpdate, &JSApiStreamBulkRequest{Streams: upd})
+	require_True(t, resp.Error == nil)
+	checkFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+		for _, name := range []string{"S0", "S1"} {
+			si, err := js.StreamInfo(name)
+			if err != nil {
+				return err
+			}
+			if si.Config.MaxMsgs != 5 {
+				return fmt.Errorf("Expected max msgs of %s to be updated", name)
+			}
+		}
+		return nil
+	})
+
+	resp = bulk(JSStreamBulkDelete, &JSApiStreamBulkRequest{Names: []string{"S0", "S1", "NONE"}})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamNotFoundErr))
+	require_Equal(t, resp.Failed, "NONE")
+
+	var names []string
+	for _, cfg := range cfgs {
+		names = append(names, cfg.Name)
+	}
+	resp = bulk(JSStreamBulkDelete, &JSApiStreamBulkRequest{Names: names})
+	require_True(t, resp.Error == nil)
+	checkFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+		if s := streams(); s != _EMPTY_ {
+			return fmt.Errorf("Unexpected streams %q", s)
+		}
+		return nil
+	})
+}
+
+func TestJetStreamClusterAccountBackupPoint(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "A", Subjects: []string{"a"}, Replicas: 3})
+	require_NoError(t, err)
+	_, err = js.AddStream(&nats.StreamConfig{Name: "B", Subjects: []string{"b"}, Replicas: 1})
+	require_NoError(t, err)
+	_, err = js.AddConsumer("A", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy})
+	require_NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err = js.Publish("a", []byte("ok"))
+		require_NoError(t, err)
+	}
+	for i := 0; i < 5; i++ {
+		_, err = js.Publish("b", []byte("ok"))
+		require_NoError(t, err)
+	}
+	sub, err := js.PullSubscribe("a", "dlc")
+	require_NoError(t, err)
+	for _, m := range fetchMsgs(t, sub, 3, time.Second) {
+		m.AckSync()
+	}
+
+	ncsys, err := nats.Connect(c.randomServer().ClientURL(), nats.UserInfo("admin", "s3cr3t!"))
+	require_NoError(t, err)
+	defer ncsys.Close()
+
+	backup := func(req *JSApiAccountBackupPointRequest) *JSApiAccountBackupPointResponse {
+		t.Helper()
+		b, err := json.Marshal(req)
+		require_NoError(t, err)
+		m, err := ncsys.Request(fmt.Sprintf(JSApiAccountBackupPointT, globalAccountName), b, 5*time.Second)
+		require_NoError(t, err)
+		var resp JSApiAccountBackupPointResponse
+		require_NoError(t, json.Unmarshal(m.Data, &resp))
+		return &resp
+	}
+	resp := backup(&JSApiAccountBackupPointRequest{Fence: -1})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSBadRequestErr))
+
+	resp = backup(&JSApiAccountBackupPointRequest{})
+	require_True(t, resp.Error == nil)
+	require_Equal(t, resp.Account, globalAccountName)
+	require_True(t, resp.ID != _EMPTY_ && len(resp.Missing) == 0)
+	require_True(t, len(resp.Streams) == 2)
+
+	a, b := resp.Streams[0], resp.Streams[1]
+	require_Equal(t, a.Stream, "A")
+	require_Equal(t, a.Server, c.streamLeader(globalAccountName, "A").Name())
+	require_True(t, a.Error == _EMPTY_ && a.LastSeq == 10 && a.Msgs == 10)
+	require_True(t, len(a.Consumers) == 1)
+	require_Equal(t, a.Consumers[0].Name, "dlc")
+	require_True(t, a.Consumers[0].Delivered.Stream == 3 && a.Consumers[0].AckFloor.Stream == 3)
+	require_Equal(t, b.Stream, "B")
+	require_True(t, b.LastSeq == 5 && len(b.Consumers) == 0)
+
+	// Fences are lifted once done.
+	_, err = js.Publish("a", []byte("ok"))
+	require_NoError(t, err)
+
+	// While fenced writes are rejected.
+	mset, err := c.streamLeader(globalAccountName, "B").GlobalAccount().lookupStream("B")
+	require_NoError(t, err)
+	require_True(t, mset.fenceForBackup("test", time.Minute))
+	_, err = js.Publish("b", []byte("ok"))
+	require_Error(t, err)
+	require_True(t, strings.Contains(err.Error(), "fenced"))
+	mset.releaseFence("test")
+	_, err = js.Publish("b", []byte("ok"))
+	require_NoError(t, err)
+}
+
+func TestJetStreamClusterKafkaSourceLeaderChange(t *testing.T) {
+	c := createJetStreamClusterExplicit(t, "R3S", 3)
+	defer c.shutdown()
+
+	kt := &testKafkaTopic{recs: make(map[int32][]*KafkaRecord), committed: make(map[int32]int64)}
+	for i := 0; i < 5; i++ {
+		kt.add(0, "k", fmt.Sprintf("p0-%d", i))
+		kt.add(1, _EMPTY_, fmt.Sprintf("p1-%d", i))
+	}
+	for _, s := range c.servers {
+		s.optsMu.Lock()
+		s.opts.JetStreamKafkaDialer = kt
+		s.optsMu.Unlock()
+	}
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	// A short duplicate window, so only our offsets keep us from storing records again.
+	addStream(t, nc, &StreamConfig{Name: "K", Subjects: []string{"orders"}, Storage: FileStorage, Replicas: 3,
+		Duplicates: 100 * time.Millisecond, Kafka: &KafkaSource{Brokers: []string{"localhost:9092"}, Topic: "orders", Group: "nats"}})
+
+	checkCount := func(n uint64) {
+		t.Helper()
+		checkFor(t, 5*time.Second, 20*time.Millisecond, func() error {
+			si, err := js.StreamInfo("K")
+			if err != nil {
+				return err
+			}
+			if si.State.Msgs != n {
+				return fmt.Errorf("Expected %d msgs, got %d", n, si.State.Msgs)
+			}
+			return nil
+		})
+	}
+	checkCount(10)
+
+	m, err := js.GetLastMsg("K", "orders")
+	require_NoError(t, err)
+	require_Equal(t, m.Header.Get(JSKafkaOffsets), "0:5,1:5")
+
+	// Others can publish on our subject too.
+	_, err = js.Publish("orders", []byte("other"))
+	require_NoError(t, err)
+	checkCount(11)
+
+	// The group loses its commits, the new leader resumes from the stream.
+	kt.mu.Lock()
+	kt.committed = make(map[int32]int64)
+	kt.mu.Unlock()
+	time.Sleep(200 * time.Millisecond)
+
+	ol := c.streamLeader(globalAccountName, "K")
+	_, err = nc.Request(fmt.Sprintf(JSApiStreamLeaderStepDownT, "K"), nil, time.Second)
+	require_NoError(t, err)
+	c.waitOnStreamLeader(globalAccountName, "K")
+	require_True(t, c.streamLeader(globalAccountName, "K") != ol)
+
+	kt.add(1, _EMPTY_, "p1-5")
+	checkCount(12)
+	time.Sleep(200 * time.Millisecond)
+	checkCount(12)
+
+	m, err = js.GetLastMsg("K", "orders")
+	require_NoError(t, err)
+	require_Equal(t, string(m.Data), "p1-5")
+	require_Equal(t, m.Header.Get(JSKafkaOffsets), "0:5,1:6")
+}
@@ -0,0 +1,326 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// Default pause between moving assets off a draining server.
+	defaultJSDrainInterval = time.Second
+	// Default time we wait for a single stream to move off a draining server.
+	defaultJSDrainTimeout = 10 * time.Minute
+	// How often we check if a stream moved off a draining server.
+	jsDrainCheckInterval = 250 * time.Millisecond
+	// How long we wait for a requested move to start before asking again.
+	jsDrainRetryInterval = 5 * time.Second
+)
+
+var (
+	errJSDrainStopped = errors.New("drain stopped")
+	errJSDrainTimeout = errors.New("timeout waiting for stream to move")
+)
+
+// Phases of a JetStream drain.
+const (
+	JSDrainPhaseLeaders  = "leaders"
+	JSDrainPhaseReplicas = "replicas"
+	JSDrainPhaseDone     = "done"
+	JSDrainPhaseCanceled = "canceled"
+)
+
+// JSDrainOptions are options passed to drain the JetStream assets off a server.
+type JSDrainOptions struct {
+	// Pause between moving assets, to limit the load put on the cluster.
+	Interval time.Duration `json:"interval,omitempty"`
+	// How long to wait for a single stream to move before giving up on it.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Only report progress, do not start a drain.
+	Status bool `json:"status,omitempty"`
+	// Stop a drain in progress. Assets already moved stay where they are.
+	Cancel bool `json:"cancel,omitempty"`
+}
+
+// JSDrainz reports the progress of draining the JetStream assets off a server.
+type JSDrainz struct {
+	ID       string    `json:"server_id"`
+	Now      time.Time `json:"now"`
+	Draining bool      `json:"draining"`
+	Phase    string    `json:"phase,omitempty"`
+	Start    time.Time `json:"start,omitempty"`
+	// Raft groups we handed leadership off for.
+	Leaders int `json:"leaders"`
+	// Streams we had a replica of when moving replicas started, and how many of those moved.
+	Streams int `json:"streams"`
+	Moved   int `json:"moved"`
+	// Streams we could not move, with the reason why.
+	Failed []string `json:"failed,omitempty"`
+}
+
+// Tracks a drain in progress, guarded by the jetStream lock.
+type jsDrain struct {
+	opts   JSDrainOptions
+	status JSDrainz
+	quitCh chan struct{}
+}
+
+// JetStreamDrain starts moving all JetStream assets off this server, leaders first and then
+// replicas, while it keeps serving core NATS. When a drain is already in progress this only
+// reports its progress.
+func (s *Server) JetStreamDrain(opts *JSDrainOptions) (*JSDrainz, error) {
+	js, cc := s.getJetStreamCluster()
+	if js == nil {
+		return nil, NewJSNotEnabledError()
+	}
+	if cc == nil {
+		return nil, NewJSClusterNotActiveError()
+	}
+	if opts == nil {
+		opts = &JSDrainOptions{}
+	}
+	id := s.ID()
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	d := cc.drain
+	running := d != nil && d.status.Draining
+	switch {
+	case opts.Cancel:
+		if running {
+			close(d.quitCh)
+			d.status.Draining, d.status.Phase = false, JSDrainPhaseCanceled
+			s.Noticef("JetStream drain canceled")
+		}
+	case opts.Status || running:
+	default:
+		d = &jsDrain{
+			opts:   *opts,
+			status: JSDrainz{Draining: true, Phase: JSDrainPhaseLeaders, Start: time.Now().UTC()},
+			quitCh: make(chan struct{}),
+		}
+		if d.opts.Interval <= 0 {
+			d.opts.Interval = defaultJSDrainInterval
+		}
+		if d.opts.Timeout <= 0 {
+			d.opts.Timeout = defaultJSDrainTimeout
+		}
+		cc.drain = d
+		s.Noticef("JetStream drain started")
+		s.startGoRoutine(func() {
+			defer s.grWG.Done()
+			js.drainAssets(d)
+		})
+	}
+
+	var dz JSDrainz
+	if d != nil {
+		dz = d.status
+		dz.Failed = append([]string(nil), d.status.Failed...)
+	}
+	dz.ID, dz.Now = id, time.Now().UTC()
+	return &dz, nil
+}
+
+// Moves our assets off this server. Leaders are handed off first so that clients see as little
+// disruption as possible, then the meta leader moves our replicas to other servers one stream at
+// a time. Consumers follow the stream they belong to.
+func (js *jetStream) drainAssets(d *jsDrain) {
+	s := js.srv
+
+	// Returns false when we should stop.
+	pause := func(dur time.Duration) bool {
+		select {
+		case <-d.quitCh:
+			return false
+		case <-s.quitCh:
+			return false
+		case <-time.After(dur):
+			return true
+		}
+	}
+
+	js.mu.RLock()
+	cc := js.cluster
+	var nodes []RaftNode
+	if cc.meta != nil && cc.meta.Leader() {
+		nodes = append(nodes, cc.meta)
+	}
+	for _, asa := range cc.streams {
+		for _, sa := range asa {
+			if rg := sa.Group; rg != nil && rg.node != nil && rg.node.Leader() {
+				nodes = append(nodes, rg.node)
+			}
+			for _, ca := range sa.consumers {
+				if rg := ca.Group; rg != nil && rg.node != nil && rg.node.Leader() {
+					nodes = append(nodes, rg.node)
+				}
+			}
+		}
+	}
+	js.mu.RUnlock()
+
+	for i, n := range nodes {
+		if i > 0 && !pause(d.opts.Interval) {
+			return
+		}
+		if !n.Leader() {
+			continue
+		}
+		if err := n.StepDown(); err != nil {
+			s.Warnf("JetStream drain could not step down as leader for group '%s': %v", n.Group(), err)
+			continue
+		}
+		js.mu.Lock()
+		d.status.Leaders++
+		js.mu.Unlock()
+	}
+
+	js.mu.Lock()
+	ourID := cc.meta.ID()
+	var refs [][2]string
+	for acc, asa := range cc.streams {
+		for name, sa := range asa {
+			if sa.Group.isMember(ourID) {
+				refs = append(refs, [2]string{acc, name})
+			}
+		}
+	}
+	d.status.Phase, d.status.Streams = JSDrainPhaseReplicas, len(refs)
+	leaders := d.status.Leaders
+	js.mu.Unlock()
+	s.Noticef("JetStream drain handed off %d leaders, moving %d streams", leaders, len(refs))
+
+	for i, ref := range refs {
+		if i > 0 && !pause(d.opts.Interval) {
+			return
+		}
+		acc, name := ref[0], ref[1]
+		err := js.drainStream(d, acc, name)
+		if err == errJSDrainStopped {
+			return
+		}
+		js.mu.Lock()
+		if err != nil {
+			d.status.Failed = append(d.status.Failed, fmt.Sprintf("%s > %s: %v", acc, name, err))
+		} else {
+			d.status.Moved++
+		}
+		js.mu.Unlock()
+		if err != nil {
+			s.Warnf("JetStream drain could not move stream '%s > %s': %v", acc, name, err)
+		}
+	}
+
+	js.mu.Lock()
+	if d.status.Draining {
+		d.status.Draining, d.status.Phase = false, JSDrainPhaseDone
+	}
+	moved, failed := d.status.Moved, len(d.status.Failed)
+	js.mu.Unlock()
+	s.Noticef("JetStream drain finished, moved %d streams, %d failed", moved, failed)
+}
+
+// Asks the meta leader to move a stream off this server and waits for it to be gone.
+func (js *jetStream) drainStream(d *jsDrain, accName, streamName string) error {
+	s := js.srv
+
+	js.mu.RLock()
+	cc := js.cluster
+	ourID := cc.meta.ID()
+	js.mu.RUnlock()
+
+	// Returns whether the stream is still placed on us and if it is already being moved.
+	placed := func() (bool, bool) {
+		js.mu.RLock()
+		defer js.mu.RUnlock()
+		sa := cc.streams[accName][streamName]
+		if sa == nil || !sa.Group.isMember(ourID) {
+			return false, false
+		}
+		return true, len(sa.Group.Peers) > sa.Config.Replicas
+	}
+
+	if onUs, _ := placed(); !onUs {
+		return nil
+	}
+
+	// Only errors are of interest, a move that started is tracked through the assignment.
+	errCh := make(chan error, 1)
+	s.mu.Lock()
+	if s.sys == nil || s.sys.replies == nil {
+		s.mu.Unlock()
+		return ErrNoSysAccount
+	}
+	inbox := s.newRespInbox()
+	s.sys.replies[inbox] = func(_ *subscription, _ *client, _ *Account, _, _ string, msg []byte) {
+		var resp JSApiStreamUpdateResponse
+		if err := json.Unmarshal(msg, &resp); err != nil || resp.Error == nil {
+			return
+		}
+		select {
+		case errCh <- resp.Error:
+		default:
+		}
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.sys != nil && s.sys.replies != nil {
+			delete(s.sys.replies, inbox)
+		}
+		s.mu.Unlock()
+	}()
+
+	subj := fmt.Sprintf(JSApiServerStreamMoveT, accName, streamName)
+	req := &JSApiMetaServerStreamMoveRequest{
+		Server:  s.Name(),
+		Cluster: s.cachedClusterName(),
+		Domain:  s.getOpts().JetStreamDomain,
+	}
+	var lastReq time.Time
+
+	deadline := time.NewTimer(d.opts.Timeout)
+	defer deadline.Stop()
+	t := time.NewTicker(jsDrainCheckInterval)
+	defer t.Stop()
+
+	for {
+		onUs, moving := placed()
+		if !onUs {
+			return nil
+		}
+		// Requests are lost while there is no meta leader, for instance right after we stepped
+		// down, so keep asking until the move shows up in the assignment.
+		if !moving && time.Since(lastReq) >= jsDrainRetryInterval {
+			s.sendInternalMsgLocked(subj, inbox, nil, req)
+			lastReq = time.Now()
+		}
+		select {
+		case <-d.quitCh:
+			return errJSDrainStopped
+		case <-s.quitCh:
+			return errJSDrainStopped
+		case err := <-errCh:
+			return err
+		case <-deadline.C:
+			return errJSDrainTimeout
+		case <-t.C:
+		}
+	}
+}
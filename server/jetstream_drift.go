@@ -0,0 +1,193 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// DesiredStream is the declared state of a stream and its consumers.
+type DesiredStream struct {
+	Config    StreamConfig      `json:"config"`
+	Consumers []*ConsumerConfig `json:"consumers,omitempty"`
+}
+
+// Kinds of drift between the declared and the actual state of an account.
+const (
+	// DriftMissing is a declared stream or consumer that does not exist.
+	DriftMissing = "missing"
+	// DriftExtra is a stream or durable consumer that exists but is not declared.
+	DriftExtra = "extra"
+	// DriftDiffers is a stream or consumer with a config other than the declared one.
+	DriftDiffers = "differs"
+)
+
+// AssetDrift is a stream, or a consumer when Consumer is set, that drifted from the declared state.
+// Changes go from the declared config to the actual one.
+type AssetDrift struct {
+	Stream   string          `json:"stream"`
+	Consumer string          `json:"consumer,omitempty"`
+	Kind     string          `json:"kind"`
+	Changes  []*ConfigChange `json:"changes,omitempty"`
+}
+
+// Current config of a stream and its consumers.
+type streamAssets struct {
+	cfg       *StreamConfig
+	consumers map[string]*ConsumerConfig
+}
+
+// Returns the streams of the account and their consumers.
+// When clustered this is from the assignments, so should be called on the meta leader.
+func (s *Server) accountAssets(acc *Account) map[string]*streamAssets {
+	assets := make(map[string]*streamAssets)
+
+	if s.JetStreamIsClustered() {
+		js := s.getJetStream()
+		js.mu.RLock()
+		defer js.mu.RUnlock()
+		for name, sa := range js.cluster.streams[acc.Name] {
+			sas := &streamAssets{cfg: sa.Config, consumers: make(map[string]*ConsumerConfig)}
+			for cname, ca := range sa.consumers {
+				if !ca.deleted && ca.Config != nil {
+					sas.consumers[cname] = ca.Config
+				}
+			}
+			assets[name] = sas
+		}
+		return assets
+	}
+
+	for _, mset := range acc.streams() {
+		cfg := mset.config()
+		sas := &streamAssets{cfg: &cfg, consumers: make(map[string]*ConsumerConfig)}
+		for _, o := range mset.getPublicConsumers() {
+			ocfg := o.config()
+			sas.consumers[o.String()] = &ocfg
+		}
+		assets[cfg.Name] = sas
+	}
+	return assets
+}
+
+// Returns how the streams and consumers of the account differ from the declared ones.
+// Declared configs get the same defaults as when they are created so only actual
+// differences are reported. Nothing is changed.
+func (s *Server) accountDrift(acc *Account, desired []*DesiredStream) ([]*AssetDrift, *ApiError) {
+	srvLim := &s.getOpts().JetStreamLimits
+
+	// Normalize and check what was declared first.
+	streams := make(map[string]*streamAssets, len(desired))
+	for _, ds := range desired {
+		if ds == nil {
+			continue
+		}
+		cfg, apiErr := s.checkStreamCfg(&ds.Config, acc)
+		if apiErr != nil {
+			return nil, NewJSDriftManifestInvalidError(fmt.Errorf("stream %q: %s", ds.Config.Name, apiErr.Description))
+		}
+		if streams[cfg.Name] != nil {
+			return nil, NewJSDriftManifestInvalidError(fmt.Errorf("duplicate stream %q", cfg.Name))
+		}
+		selectedLimits, _, _, apiErr := acc.selectLimits(&cfg)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		dsa := &streamAssets{cfg: &cfg, consumers: make(map[string]*ConsumerConfig)}
+		for _, dc := range ds.Consumers {
+			if dc == nil {
+				continue
+			}
+			ccfg := *dc
+			name := ccfg.Durable
+			if name == _EMPTY_ {
+				name = ccfg.Name
+			}
+			if name == _EMPTY_ {
+				return nil, NewJSDriftManifestInvalidError(fmt.Errorf("consumer of stream %q needs a name", cfg.Name))
+			}
+			if dsa.consumers[name] != nil {
+				return nil, NewJSDriftManifestInvalidError(fmt.Errorf("duplicate consumer %q of stream %q", name, cfg.Name))
+			}
+			ccfg.Name = name
+			setConsumerConfigDefaults(&ccfg, srvLim, selectedLimits)
+			if apiErr := checkConsumerCfg(&ccfg, srvLim, &cfg, acc, selectedLimits, false); apiErr != nil {
+				return nil, NewJSDriftManifestInvalidError(fmt.Errorf("consumer %q of stream %q: %s", name, cfg.Name, apiErr.Description))
+			}
+			dsa.consumers[name] = &ccfg
+		}
+		streams[cfg.Name] = dsa
+	}
+
+	actual := s.accountAssets(acc)
+
+	var drift []*AssetDrift
+	for name, dsa := range streams {
+		asa := actual[name]
+		if asa == nil {
+			drift = append(drift, &AssetDrift{Stream: name, Kind: DriftMissing})
+			for cname := range dsa.consumers {
+				drift = append(drift, &AssetDrift{Stream: name, Consumer: cname, Kind: DriftMissing})
+			}
+			continue
+		}
+		if changes := diffAssetConfigs(dsa.cfg, asa.cfg); len(changes) > 0 {
+			drift = append(drift, &AssetDrift{Stream: name, Kind: DriftDiffers, Changes: changes})
+		}
+		for cname, dcfg := range dsa.consumers {
+			acfg := asa.consumers[cname]
+			if acfg == nil {
+				drift = append(drift, &AssetDrift{Stream: name, Consumer: cname, Kind: DriftMissing})
+				continue
+			}
+			// Durables can be created with or without the name set.
+			ccfg := *acfg
+			if ccfg.Name == _EMPTY_ {
+				ccfg.Name = ccfg.Durable
+			}
+			if changes := diffAssetConfigs(dcfg, &ccfg); len(changes) > 0 {
+				drift = append(drift, &AssetDrift{Stream: name, Consumer: cname, Kind: DriftDiffers, Changes: changes})
+			}
+		}
+		// Only durable consumers are expected to be declared.
+		for cname, acfg := range asa.consumers {
+			if dsa.consumers[cname] == nil && isDurableConsumer(acfg) {
+				drift = append(drift, &AssetDrift{Stream: name, Consumer: cname, Kind: DriftExtra})
+			}
+		}
+	}
+	for name, asa := range actual {
+		// Partitions belong to their partitioned stream.
+		if streams[name] == nil && asa.cfg.PartitionOf == _EMPTY_ {
+			drift = append(drift, &AssetDrift{Stream: name, Kind: DriftExtra})
+		}
+	}
+
+	sort.Slice(drift, func(i, j int) bool {
+		if drift[i].Stream != drift[j].Stream {
+			return drift[i].Stream < drift[j].Stream
+		}
+		return drift[i].Consumer < drift[j].Consumer
+	})
+	return drift, nil
+}
+
+// Returns the fields that differ between two configs.
+func diffAssetConfigs(from, to interface{}) []*ConfigChange {
+	fb, _ := json.Marshal(from)
+	tb, _ := json.Marshal(to)
+	return diffConfigs(fb, tb)
+}
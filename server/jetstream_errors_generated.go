@@ -44,12 +44,18 @@ const (
 	// JSClusterUnSupportFeatureErr not currently supported in clustered mode
 	JSClusterUnSupportFeatureErr ErrorIdentifier = 10036
 
+	// JSConfigRevisionNotFoundErr config revision not found
+	JSConfigRevisionNotFoundErr ErrorIdentifier = 10147
+
 	// JSConsumerBadDurableNameErr durable name can not contain '.', '*', '>'
 	JSConsumerBadDurableNameErr ErrorIdentifier = 10103
 
 	// JSConsumerConfigRequiredErr consumer config required
 	JSConsumerConfigRequiredErr ErrorIdentifier = 10078
 
+	// JSConsumerConsumptionGroupInvalidErrF consumer consumption group is invalid: {err}
+	JSConsumerConsumptionGroupInvalidErrF ErrorIdentifier = 10155
+
 	// JSConsumerCreateDurableAndNameMismatch Consumer Durable and Name have to be equal if both are provided
 	JSConsumerCreateDurableAndNameMismatch ErrorIdentifier = 10132
 
@@ -59,6 +65,9 @@ const (
 	// JSConsumerCreateFilterSubjectMismatchErr Consumer create request did not match filtered subject from create subject
 	JSConsumerCreateFilterSubjectMismatchErr ErrorIdentifier = 10131
 
+	// JSConsumerDeadLetterSubjectInvalidErrF consumer dead letter subject is invalid: {err}
+	JSConsumerDeadLetterSubjectInvalidErrF ErrorIdentifier = 10142
+
 	// JSConsumerDeliverCycleErr consumer deliver subject forms a cycle
 	JSConsumerDeliverCycleErr ErrorIdentifier = 10081
 
@@ -191,6 +200,9 @@ const (
 	// JSConsumerWithFlowControlNeedsHeartbeats consumer with flow control also needs heartbeats
 	JSConsumerWithFlowControlNeedsHeartbeats ErrorIdentifier = 10108
 
+	// JSDriftManifestInvalidErrF invalid manifest: {err}
+	JSDriftManifestInvalidErrF ErrorIdentifier = 10148
+
 	// JSInsufficientResourcesErr insufficient resources
 	JSInsufficientResourcesErr ErrorIdentifier = 10023
 
@@ -206,6 +218,9 @@ const (
 	// JSMemoryResourcesExceededErr insufficient memory resources available
 	JSMemoryResourcesExceededErr ErrorIdentifier = 10028
 
+	// JSMessageScheduleInvalidErrF invalid message schedule: {err}
+	JSMessageScheduleInvalidErrF ErrorIdentifier = 10141
+
 	// JSMirrorConsumerSetupFailedErrF generic mirror consumer setup failure string ({err})
 	JSMirrorConsumerSetupFailedErrF ErrorIdentifier = 10029
 
@@ -260,6 +275,9 @@ const (
 	// JSSnapshotDeliverSubjectInvalidErr deliver subject not valid
 	JSSnapshotDeliverSubjectInvalidErr ErrorIdentifier = 10015
 
+	// JSSnapshotEncryptionInvalidErrF snapshot encryption invalid: {err}
+	JSSnapshotEncryptionInvalidErrF ErrorIdentifier = 10145
+
 	// JSSourceConsumerSetupFailedErrF General source consumer setup failure string ({err})
 	JSSourceConsumerSetupFailedErrF ErrorIdentifier = 10045
 
@@ -272,24 +290,54 @@ const (
 	// JSStreamAssignmentErrF Generic stream assignment error string ({err})
 	JSStreamAssignmentErrF ErrorIdentifier = 10048
 
+	// JSStreamBulkErrF stream bulk request failed: {err}
+	JSStreamBulkErrF ErrorIdentifier = 10150
+
+	// JSStreamBulkInvalidErrF invalid stream bulk request: {err}
+	JSStreamBulkInvalidErrF ErrorIdentifier = 10149
+
+	// JSStreamCompactInProgressErr stream compaction already in progress
+	JSStreamCompactInProgressErr ErrorIdentifier = 10136
+
+	// JSStreamCompactNotSupportedErr clustered and memory based streams can not be compacted asynchronously (stream does not support async compaction)
+	JSStreamCompactNotSupportedErr ErrorIdentifier = 10137
+
 	// JSStreamCreateErrF Generic stream creation error string ({err})
 	JSStreamCreateErrF ErrorIdentifier = 10049
 
 	// JSStreamDeleteErrF General stream deletion error string ({err})
 	JSStreamDeleteErrF ErrorIdentifier = 10050
 
+	// JSStreamDeletionProtectedErr stream is protected, unlock it before deleting or purging
+	JSStreamDeletionProtectedErr ErrorIdentifier = 10138
+
 	// JSStreamExternalApiOverlapErrF stream external api prefix {prefix} must not overlap with {subject}
 	JSStreamExternalApiOverlapErrF ErrorIdentifier = 10021
 
 	// JSStreamExternalDelPrefixOverlapsErrF stream external delivery prefix {prefix} overlaps with stream subject {subject}
 	JSStreamExternalDelPrefixOverlapsErrF ErrorIdentifier = 10022
 
+	// JSStreamFencedErr stream is fenced for a backup point
+	JSStreamFencedErr ErrorIdentifier = 10154
+
+	// JSStreamFrozenErr invalid operation on frozen stream
+	JSStreamFrozenErr ErrorIdentifier = 10139
+
 	// JSStreamGeneralErrorF General stream failure string ({err})
 	JSStreamGeneralErrorF ErrorIdentifier = 10051
 
+	// JSStreamGeneratorDisabledErr stream data generator is not enabled
+	JSStreamGeneratorDisabledErr ErrorIdentifier = 10156
+
 	// JSStreamHeaderExceedsMaximumErr header size exceeds maximum allowed of 64k
 	JSStreamHeaderExceedsMaximumErr ErrorIdentifier = 10097
 
+	// JSStreamHoldInvalidErrF invalid subject hold: {err}
+	JSStreamHoldInvalidErrF ErrorIdentifier = 10153
+
+	// JSStreamHoldNotFoundErr subject hold not found
+	JSStreamHoldNotFoundErr ErrorIdentifier = 10152
+
 	// JSStreamInfoMaxSubjectsErr subject details would exceed maximum allowed
 	JSStreamInfoMaxSubjectsErr ErrorIdentifier = 10117
 
@@ -314,6 +362,9 @@ const (
 	// JSStreamMessageExceedsMaximumErr message size exceeds maximum allowed
 	JSStreamMessageExceedsMaximumErr ErrorIdentifier = 10054
 
+	// JSStreamMessageRejectedErrF if the message was rejected by an interceptor ({err})
+	JSStreamMessageRejectedErrF ErrorIdentifier = 10135
+
 	// JSStreamMirrorNotUpdatableErr stream mirror configuration can not be updated
 	JSStreamMirrorNotUpdatableErr ErrorIdentifier = 10055
 
@@ -353,6 +404,9 @@ const (
 	// JSStreamPurgeFailedF Generic stream purge failure error string ({err})
 	JSStreamPurgeFailedF ErrorIdentifier = 10110
 
+	// JSStreamRateLimitExceededErr stream rate limit exceeded
+	JSStreamRateLimitExceededErr ErrorIdentifier = 10143
+
 	// JSStreamReplicasNotSupportedErr replicas > 1 not supported in non-clustered mode
 	JSStreamReplicasNotSupportedErr ErrorIdentifier = 10074
 
@@ -365,6 +419,9 @@ const (
 	// JSStreamRollupFailedF Generic stream rollup failure error string ({err})
 	JSStreamRollupFailedF ErrorIdentifier = 10111
 
+	// JSStreamSchemaValidationErrF message failed schema validation: {err}
+	JSStreamSchemaValidationErrF ErrorIdentifier = 10146
+
 	// JSStreamSealedErr invalid operation on sealed stream
 	JSStreamSealedErr ErrorIdentifier = 10109
 
@@ -374,12 +431,21 @@ const (
 	// JSStreamSnapshotErrF snapshot failed: {err}
 	JSStreamSnapshotErrF ErrorIdentifier = 10064
 
+	// JSStreamSourceNotAuthorizedErr not authorized to source stream
+	JSStreamSourceNotAuthorizedErr ErrorIdentifier = 10144
+
 	// JSStreamStoreFailedF Generic error when storing a message failed ({err})
 	JSStreamStoreFailedF ErrorIdentifier = 10077
 
 	// JSStreamSubjectOverlapErr subjects overlap with an existing stream
 	JSStreamSubjectOverlapErr ErrorIdentifier = 10065
 
+	// JSStreamSubjectRemapInvalidErrF {err}
+	JSStreamSubjectRemapInvalidErrF ErrorIdentifier = 10140
+
+	// JSStreamSubjectsOnHoldErr stream has subjects on hold
+	JSStreamSubjectsOnHoldErr ErrorIdentifier = 10151
+
 	// JSStreamTemplateCreateErrF Generic template creation failed string ({err})
 	JSStreamTemplateCreateErrF ErrorIdentifier = 10066
 
@@ -420,11 +486,14 @@ var (
 		JSClusterServerNotMemberErr:                {Code: 400, ErrCode: 10044, Description: "server is not a member of the cluster"},
 		JSClusterTagsErr:                           {Code: 400, ErrCode: 10011, Description: "tags placement not supported for operation"},
 		JSClusterUnSupportFeatureErr:               {Code: 503, ErrCode: 10036, Description: "not currently supported in clustered mode"},
+		JSConfigRevisionNotFoundErr:                {Code: 404, ErrCode: 10147, Description: "config revision not found"},
 		JSConsumerBadDurableNameErr:                {Code: 400, ErrCode: 10103, Description: "durable name can not contain '.', '*', '>'"},
 		JSConsumerConfigRequiredErr:                {Code: 400, ErrCode: 10078, Description: "consumer config required"},
+		JSConsumerConsumptionGroupInvalidErrF:      {Code: 400, ErrCode: 10155, Description: "consumer consumption group is invalid: {err}"},
 		JSConsumerCreateDurableAndNameMismatch:     {Code: 400, ErrCode: 10132, Description: "Consumer Durable and Name have to be equal if both are provided"},
 		JSConsumerCreateErrF:                       {Code: 500, ErrCode: 10012, Description: "{err}"},
 		JSConsumerCreateFilterSubjectMismatchErr:   {Code: 400, ErrCode: 10131, Description: "Consumer create request did not match filtered subject from create subject"},
+		JSConsumerDeadLetterSubjectInvalidErrF:     {Code: 400, ErrCode: 10142, Description: "consumer dead letter subject is invalid: {err}"},
 		JSConsumerDeliverCycleErr:                  {Code: 400, ErrCode: 10081, Description: "consumer deliver subject forms a cycle"},
 		JSConsumerDeliverToWildcardsErr:            {Code: 400, ErrCode: 10079, Description: "consumer deliver subject has wildcards"},
 		JSConsumerDescriptionTooLongErrF:           {Code: 400, ErrCode: 10107, Description: "consumer description is too long, maximum allowed is {max}"},
@@ -469,11 +538,13 @@ var (
 		JSConsumerWQMultipleUnfilteredErr:          {Code: 400, ErrCode: 10099, Description: "multiple non-filtered consumers not allowed on workqueue stream"},
 		JSConsumerWQRequiresExplicitAckErr:         {Code: 400, ErrCode: 10098, Description: "workqueue stream requires explicit ack"},
 		JSConsumerWithFlowControlNeedsHeartbeats:   {Code: 400, ErrCode: 10108, Description: "consumer with flow control also needs heartbeats"},
+		JSDriftManifestInvalidErrF:                 {Code: 400, ErrCode: 10148, Description: "invalid manifest: {err}"},
 		JSInsufficientResourcesErr:                 {Code: 503, ErrCode: 10023, Description: "insufficient resources"},
 		JSInvalidJSONErr:                           {Code: 400, ErrCode: 10025, Description: "invalid JSON"},
 		JSMaximumConsumersLimitErr:                 {Code: 400, ErrCode: 10026, Description: "maximum consumers limit reached"},
 		JSMaximumStreamsLimitErr:                   {Code: 400, ErrCode: 10027, Description: "maximum number of streams reached"},
 		JSMemoryResourcesExceededErr:               {Code: 500, ErrCode: 10028, Description: "insufficient memory resources available"},
+		JSMessageScheduleInvalidErrF:               {Code: 400, ErrCode: 10141, Description: "invalid message schedule: {err}"},
 		JSMirrorConsumerSetupFailedErrF:            {Code: 500, ErrCode: 10029, Description: "{err}"},
 		JSMirrorMaxMessageSizeTooBigErr:            {Code: 400, ErrCode: 10030, Description: "stream mirror must have max message size >= source"},
 		JSMirrorWithSourcesErr:                     {Code: 400, ErrCode: 10031, Description: "stream mirrors can not also contain other sources"},
@@ -492,16 +563,27 @@ var (
 		JSRestoreSubscribeFailedErrF:               {Code: 500, ErrCode: 10042, Description: "JetStream unable to subscribe to restore snapshot {subject}: {err}"},
 		JSSequenceNotFoundErrF:                     {Code: 400, ErrCode: 10043, Description: "sequence {seq} not found"},
 		JSSnapshotDeliverSubjectInvalidErr:         {Code: 400, ErrCode: 10015, Description: "deliver subject not valid"},
+		JSSnapshotEncryptionInvalidErrF:            {Code: 400, ErrCode: 10145, Description: "snapshot encryption invalid: {err}"},
 		JSSourceConsumerSetupFailedErrF:            {Code: 500, ErrCode: 10045, Description: "{err}"},
 		JSSourceMaxMessageSizeTooBigErr:            {Code: 400, ErrCode: 10046, Description: "stream source must have max message size >= target"},
 		JSStorageResourcesExceededErr:              {Code: 500, ErrCode: 10047, Description: "insufficient storage resources available"},
 		JSStreamAssignmentErrF:                     {Code: 500, ErrCode: 10048, Description: "{err}"},
+		JSStreamBulkErrF:                           {Code: 500, ErrCode: 10150, Description: "stream bulk request failed: {err}"},
+		JSStreamBulkInvalidErrF:                    {Code: 400, ErrCode: 10149, Description: "invalid stream bulk request: {err}"},
+		JSStreamCompactInProgressErr:               {Code: 409, ErrCode: 10136, Description: "stream compaction already in progress"},
+		JSStreamCompactNotSupportedErr:             {Code: 400, ErrCode: 10137, Description: "stream does not support async compaction"},
 		JSStreamCreateErrF:                         {Code: 500, ErrCode: 10049, Description: "{err}"},
 		JSStreamDeleteErrF:                         {Code: 500, ErrCode: 10050, Description: "{err}"},
+		JSStreamDeletionProtectedErr:               {Code: 400, ErrCode: 10138, Description: "stream is protected, unlock it before deleting or purging"},
 		JSStreamExternalApiOverlapErrF:             {Code: 400, ErrCode: 10021, Description: "stream external api prefix {prefix} must not overlap with {subject}"},
 		JSStreamExternalDelPrefixOverlapsErrF:      {Code: 400, ErrCode: 10022, Description: "stream external delivery prefix {prefix} overlaps with stream subject {subject}"},
+		JSStreamFencedErr:                          {Code: 503, ErrCode: 10154, Description: "stream is fenced for a backup point"},
+		JSStreamFrozenErr:                          {Code: 400, ErrCode: 10139, Description: "invalid operation on frozen stream"},
 		JSStreamGeneralErrorF:                      {Code: 500, ErrCode: 10051, Description: "{err}"},
+		JSStreamGeneratorDisabledErr:               {Code: 503, ErrCode: 10156, Description: "stream data generator is not enabled"},
 		JSStreamHeaderExceedsMaximumErr:            {Code: 400, ErrCode: 10097, Description: "header size exceeds maximum allowed of 64k"},
+		JSStreamHoldInvalidErrF:                    {Code: 400, ErrCode: 10153, Description: "invalid subject hold: {err}"},
+		JSStreamHoldNotFoundErr:                    {Code: 404, ErrCode: 10152, Description: "subject hold not found"},
 		JSStreamInfoMaxSubjectsErr:                 {Code: 500, ErrCode: 10117, Description: "subject details would exceed maximum allowed"},
 		JSStreamInvalidConfigF:                     {Code: 500, ErrCode: 10052, Description: "{err}"},
 		JSStreamInvalidErr:                         {Code: 500, ErrCode: 10096, Description: "stream not valid"},
@@ -510,6 +592,7 @@ var (
 		JSStreamMaxBytesRequired:                   {Code: 400, ErrCode: 10113, Description: "account requires a stream config to have max bytes set"},
 		JSStreamMaxStreamBytesExceeded:             {Code: 400, ErrCode: 10122, Description: "stream max bytes exceeds account limit max stream bytes"},
 		JSStreamMessageExceedsMaximumErr:           {Code: 400, ErrCode: 10054, Description: "message size exceeds maximum allowed"},
+		JSStreamMessageRejectedErrF:                {Code: 400, ErrCode: 10135, Description: "{err}"},
 		JSStreamMirrorNotUpdatableErr:              {Code: 400, ErrCode: 10055, Description: "stream mirror configuration can not be updated"},
 		JSStreamMismatchErr:                        {Code: 400, ErrCode: 10056, Description: "stream name in subject does not match request"},
 		JSStreamMoveAndScaleErr:                    {Code: 400, ErrCode: 10123, Description: "can not move and scale a stream in a single update"},
@@ -523,15 +606,20 @@ var (
 		JSStreamNotMatchErr:                        {Code: 400, ErrCode: 10060, Description: "expected stream does not match"},
 		JSStreamOfflineErr:                         {Code: 500, ErrCode: 10118, Description: "stream is offline"},
 		JSStreamPurgeFailedF:                       {Code: 500, ErrCode: 10110, Description: "{err}"},
+		JSStreamRateLimitExceededErr:               {Code: 429, ErrCode: 10143, Description: "stream rate limit exceeded"},
 		JSStreamReplicasNotSupportedErr:            {Code: 500, ErrCode: 10074, Description: "replicas > 1 not supported in non-clustered mode"},
 		JSStreamReplicasNotUpdatableErr:            {Code: 400, ErrCode: 10061, Description: "Replicas configuration can not be updated"},
 		JSStreamRestoreErrF:                        {Code: 500, ErrCode: 10062, Description: "restore failed: {err}"},
 		JSStreamRollupFailedF:                      {Code: 500, ErrCode: 10111, Description: "{err}"},
+		JSStreamSchemaValidationErrF:               {Code: 400, ErrCode: 10146, Description: "message failed schema validation: {err}"},
 		JSStreamSealedErr:                          {Code: 400, ErrCode: 10109, Description: "invalid operation on sealed stream"},
 		JSStreamSequenceNotMatchErr:                {Code: 503, ErrCode: 10063, Description: "expected stream sequence does not match"},
 		JSStreamSnapshotErrF:                       {Code: 500, ErrCode: 10064, Description: "snapshot failed: {err}"},
+		JSStreamSourceNotAuthorizedErr:             {Code: 403, ErrCode: 10144, Description: "not authorized to source stream"},
 		JSStreamStoreFailedF:                       {Code: 503, ErrCode: 10077, Description: "{err}"},
 		JSStreamSubjectOverlapErr:                  {Code: 400, ErrCode: 10065, Description: "subjects overlap with an existing stream"},
+		JSStreamSubjectRemapInvalidErrF:            {Code: 400, ErrCode: 10140, Description: "{err}"},
+		JSStreamSubjectsOnHoldErr:                  {Code: 400, ErrCode: 10151, Description: "stream has subjects on hold"},
 		JSStreamTemplateCreateErrF:                 {Code: 500, ErrCode: 10066, Description: "{err}"},
 		JSStreamTemplateDeleteErrF:                 {Code: 500, ErrCode: 10067, Description: "{err}"},
 		JSStreamTemplateNotFoundErr:                {Code: 404, ErrCode: 10068, Description: "template not found"},
@@ -701,6 +789,16 @@ func NewJSClusterUnSupportFeatureError(opts ...ErrorOption) *ApiError {
 	return ApiErrors[JSClusterUnSupportFeatureErr]
 }
 
+// NewJSConfigRevisionNotFoundError creates a new JSConfigRevisionNotFoundErr error: "config revision not found"
+func NewJSConfigRevisionNotFoundError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSConfigRevisionNotFoundErr]
+}
+
 // NewJSConsumerBadDurableNameError creates a new JSConsumerBadDurableNameErr error: "durable name can not contain '.', '*', '>'"
 func NewJSConsumerBadDurableNameError(opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -721,6 +819,22 @@ func NewJSConsumerConfigRequiredError(opts ...ErrorOption) *ApiError {
 	return ApiErrors[JSConsumerConfigRequiredErr]
 }
 
+// NewJSConsumerConsumptionGroupInvalidError creates a new JSConsumerConsumptionGroupInvalidErrF error: "consumer consumption group is invalid: {err}"
+func NewJSConsumerConsumptionGroupInvalidError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSConsumerConsumptionGroupInvalidErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
 // NewJSConsumerCreateDurableAndNameMismatchError creates a new JSConsumerCreateDurableAndNameMismatch error: "Consumer Durable and Name have to be equal if both are provided"
 func NewJSConsumerCreateDurableAndNameMismatchError(opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -757,6 +871,22 @@ func NewJSConsumerCreateFilterSubjectMismatchError(opts ...ErrorOption) *ApiErro
 	return ApiErrors[JSConsumerCreateFilterSubjectMismatchErr]
 }
 
+// NewJSConsumerDeadLetterSubjectInvalidError creates a new JSConsumerDeadLetterSubjectInvalidErrF error: "consumer dead letter subject is invalid: {err}"
+func NewJSConsumerDeadLetterSubjectInvalidError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSConsumerDeadLetterSubjectInvalidErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
 // NewJSConsumerDeliverCycleError creates a new JSConsumerDeliverCycleErr error: "consumer deliver subject forms a cycle"
 func NewJSConsumerDeliverCycleError(opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1239,6 +1369,22 @@ func NewJSConsumerWithFlowControlNeedsHeartbeatsError(opts ...ErrorOption) *ApiE
 	return ApiErrors[JSConsumerWithFlowControlNeedsHeartbeats]
 }
 
+// NewJSDriftManifestInvalidError creates a new JSDriftManifestInvalidErrF error: "invalid manifest: {err}"
+func NewJSDriftManifestInvalidError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSDriftManifestInvalidErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
 // NewJSInsufficientResourcesError creates a new JSInsufficientResourcesErr error: "insufficient resources"
 func NewJSInsufficientResourcesError(opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1289,6 +1435,22 @@ func NewJSMemoryResourcesExceededError(opts ...ErrorOption) *ApiError {
 	return ApiErrors[JSMemoryResourcesExceededErr]
 }
 
+// NewJSMessageScheduleInvalidError creates a new JSMessageScheduleInvalidErrF error: "invalid message schedule: {err}"
+func NewJSMessageScheduleInvalidError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSMessageScheduleInvalidErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
 // NewJSMirrorConsumerSetupFailedError creates a new JSMirrorConsumerSetupFailedErrF error: "{err}"
 func NewJSMirrorConsumerSetupFailedError(err error, opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1493,6 +1655,22 @@ func NewJSSnapshotDeliverSubjectInvalidError(opts ...ErrorOption) *ApiError {
 	return ApiErrors[JSSnapshotDeliverSubjectInvalidErr]
 }
 
+// NewJSSnapshotEncryptionInvalidError creates a new JSSnapshotEncryptionInvalidErrF error: "snapshot encryption invalid: {err}"
+func NewJSSnapshotEncryptionInvalidError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSSnapshotEncryptionInvalidErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
 // NewJSSourceConsumerSetupFailedError creates a new JSSourceConsumerSetupFailedErrF error: "{err}"
 func NewJSSourceConsumerSetupFailedError(err error, opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1545,6 +1723,58 @@ func NewJSStreamAssignmentError(err error, opts ...ErrorOption) *ApiError {
 	}
 }
 
+// NewJSStreamBulkError creates a new JSStreamBulkErrF error: "stream bulk request failed: {err}"
+func NewJSStreamBulkError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSStreamBulkErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
+// NewJSStreamBulkInvalidError creates a new JSStreamBulkInvalidErrF error: "invalid stream bulk request: {err}"
+func NewJSStreamBulkInvalidError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSStreamBulkInvalidErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
+// NewJSStreamCompactInProgressError creates a new JSStreamCompactInProgressErr error: "stream compaction already in progress"
+func NewJSStreamCompactInProgressError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSStreamCompactInProgressErr]
+}
+
+// NewJSStreamCompactNotSupportedError creates a new JSStreamCompactNotSupportedErr error: "stream does not support async compaction"
+func NewJSStreamCompactNotSupportedError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSStreamCompactNotSupportedErr]
+}
+
 // NewJSStreamCreateError creates a new JSStreamCreateErrF error: "{err}"
 func NewJSStreamCreateError(err error, opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1577,6 +1807,16 @@ func NewJSStreamDeleteError(err error, opts ...ErrorOption) *ApiError {
 	}
 }
 
+// NewJSStreamDeletionProtectedError creates a new JSStreamDeletionProtectedErr error: "stream is protected, unlock it before deleting or purging"
+func NewJSStreamDeletionProtectedError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSStreamDeletionProtectedErr]
+}
+
 // NewJSStreamExternalApiOverlapError creates a new JSStreamExternalApiOverlapErrF error: "stream external api prefix {prefix} must not overlap with {subject}"
 func NewJSStreamExternalApiOverlapError(prefix interface{}, subject interface{}, opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1609,6 +1849,26 @@ func NewJSStreamExternalDelPrefixOverlapsError(prefix interface{}, subject inter
 	}
 }
 
+// NewJSStreamFencedError creates a new JSStreamFencedErr error: "stream is fenced for a backup point"
+func NewJSStreamFencedError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSStreamFencedErr]
+}
+
+// NewJSStreamFrozenError creates a new JSStreamFrozenErr error: "invalid operation on frozen stream"
+func NewJSStreamFrozenError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSStreamFrozenErr]
+}
+
 // NewJSStreamGeneralError creates a new JSStreamGeneralErrorF error: "{err}"
 func NewJSStreamGeneralError(err error, opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1625,6 +1885,16 @@ func NewJSStreamGeneralError(err error, opts ...ErrorOption) *ApiError {
 	}
 }
 
+// NewJSStreamGeneratorDisabledError creates a new JSStreamGeneratorDisabledErr error: "stream data generator is not enabled"
+func NewJSStreamGeneratorDisabledError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSStreamGeneratorDisabledErr]
+}
+
 // NewJSStreamHeaderExceedsMaximumError creates a new JSStreamHeaderExceedsMaximumErr error: "header size exceeds maximum allowed of 64k"
 func NewJSStreamHeaderExceedsMaximumError(opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1635,6 +1905,32 @@ func NewJSStreamHeaderExceedsMaximumError(opts ...ErrorOption) *ApiError {
 	return ApiErrors[JSStreamHeaderExceedsMaximumErr]
 }
 
+// NewJSStreamHoldInvalidError creates a new JSStreamHoldInvalidErrF error: "invalid subject hold: {err}"
+func NewJSStreamHoldInvalidError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSStreamHoldInvalidErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
+// NewJSStreamHoldNotFoundError creates a new JSStreamHoldNotFoundErr error: "subject hold not found"
+func NewJSStreamHoldNotFoundError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSStreamHoldNotFoundErr]
+}
+
 // NewJSStreamInfoMaxSubjectsError creates a new JSStreamInfoMaxSubjectsErr error: "subject details would exceed maximum allowed"
 func NewJSStreamInfoMaxSubjectsError(opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1733,6 +2029,22 @@ func NewJSStreamMessageExceedsMaximumError(opts ...ErrorOption) *ApiError {
 	return ApiErrors[JSStreamMessageExceedsMaximumErr]
 }
 
+// NewJSStreamMessageRejectedError creates a new JSStreamMessageRejectedErrF error: "{err}"
+func NewJSStreamMessageRejectedError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSStreamMessageRejectedErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
 // NewJSStreamMirrorNotUpdatableError creates a new JSStreamMirrorNotUpdatableErr error: "stream mirror configuration can not be updated"
 func NewJSStreamMirrorNotUpdatableError(opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1881,6 +2193,16 @@ func NewJSStreamPurgeFailedError(err error, opts ...ErrorOption) *ApiError {
 	}
 }
 
+// NewJSStreamRateLimitExceededError creates a new JSStreamRateLimitExceededErr error: "stream rate limit exceeded"
+func NewJSStreamRateLimitExceededError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSStreamRateLimitExceededErr]
+}
+
 // NewJSStreamReplicasNotSupportedError creates a new JSStreamReplicasNotSupportedErr error: "replicas > 1 not supported in non-clustered mode"
 func NewJSStreamReplicasNotSupportedError(opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1933,6 +2255,22 @@ func NewJSStreamRollupFailedError(err error, opts ...ErrorOption) *ApiError {
 	}
 }
 
+// NewJSStreamSchemaValidationError creates a new JSStreamSchemaValidationErrF error: "message failed schema validation: {err}"
+func NewJSStreamSchemaValidationError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSStreamSchemaValidationErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
 // NewJSStreamSealedError creates a new JSStreamSealedErr error: "invalid operation on sealed stream"
 func NewJSStreamSealedError(opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1969,6 +2307,16 @@ func NewJSStreamSnapshotError(err error, opts ...ErrorOption) *ApiError {
 	}
 }
 
+// NewJSStreamSourceNotAuthorizedError creates a new JSStreamSourceNotAuthorizedErr error: "not authorized to source stream"
+func NewJSStreamSourceNotAuthorizedError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSStreamSourceNotAuthorizedErr]
+}
+
 // NewJSStreamStoreFailedError creates a new JSStreamStoreFailedF error: "{err}"
 func NewJSStreamStoreFailedError(err error, opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -1995,6 +2343,32 @@ func NewJSStreamSubjectOverlapError(opts ...ErrorOption) *ApiError {
 	return ApiErrors[JSStreamSubjectOverlapErr]
 }
 
+// NewJSStreamSubjectRemapInvalidError creates a new JSStreamSubjectRemapInvalidErrF error: "{err}"
+func NewJSStreamSubjectRemapInvalidError(err error, opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	e := ApiErrors[JSStreamSubjectRemapInvalidErrF]
+	args := e.toReplacerArgs([]interface{}{"{err}", err})
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+	}
+}
+
+// NewJSStreamSubjectsOnHoldError creates a new JSStreamSubjectsOnHoldErr error: "stream has subjects on hold"
+func NewJSStreamSubjectsOnHoldError(opts ...ErrorOption) *ApiError {
+	eopts := parseOpts(opts)
+	if ae, ok := eopts.err.(*ApiError); ok {
+		return ae
+	}
+
+	return ApiErrors[JSStreamSubjectsOnHoldErr]
+}
+
 // NewJSStreamTemplateCreateError creates a new JSStreamTemplateCreateErrF error: "{err}"
 func NewJSStreamTemplateCreateError(err error, opts ...ErrorOption) *ApiError {
 	eopts := parseOpts(opts)
@@ -140,6 +140,47 @@ type JSConsumerDeliveryTerminatedAdvisory struct {
 // JSConsumerDeliveryTerminatedAdvisoryType is the schema type for JSConsumerDeliveryTerminatedAdvisory
 const JSConsumerDeliveryTerminatedAdvisoryType = "io.nats.jetstream.advisory.v1.terminated"
 
+// JSConsumerGroupRebalanceAdvisory is an advisory informing that members joined or left
+// the queue group a push consumer delivers to, so messages will be spread differently.
+type JSConsumerGroupRebalanceAdvisory struct {
+	TypedEvent
+	Stream   string                `json:"stream"`
+	Consumer string                `json:"consumer"`
+	Group    string                `json:"deliver_group"`
+	Members  []ConsumerGroupMember `json:"members"`
+	Joined   []ConsumerGroupMember `json:"joined,omitempty"`
+	Left     []ConsumerGroupMember `json:"left,omitempty"`
+	Domain   string                `json:"domain,omitempty"`
+}
+
+// JSConsumerGroupRebalanceAdvisoryType is the schema type for JSConsumerGroupRebalanceAdvisory
+const JSConsumerGroupRebalanceAdvisoryType = "io.nats.jetstream.advisory.v1.consumer_group_rebalance"
+
+// ConsumerGroupMember is a connection with members in the queue group of a push consumer.
+// Members on other servers are seen through the route, gateway or leafnode they are behind.
+type ConsumerGroupMember struct {
+	Server   string `json:"server"`
+	Kind     string `json:"kind"`
+	ClientID uint64 `json:"client_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Count    int32  `json:"count"`
+}
+
+// JSConsumerAdvisoriesSuppressedAdvisory is an advisory informing that advisories of
+// a given kind were suppressed for a consumer due to the configured advisory rate limit.
+type JSConsumerAdvisoriesSuppressedAdvisory struct {
+	TypedEvent
+	Stream     string        `json:"stream"`
+	Consumer   string        `json:"consumer"`
+	Subject    string        `json:"subject"`
+	Suppressed uint64        `json:"suppressed"`
+	Window     time.Duration `json:"window"`
+	Domain     string        `json:"domain,omitempty"`
+}
+
+// JSConsumerAdvisoriesSuppressedAdvisoryType is the schema type for JSConsumerAdvisoriesSuppressedAdvisory
+const JSConsumerAdvisoriesSuppressedAdvisoryType = "io.nats.jetstream.advisory.v1.advisories_suppressed"
+
 // JSSnapshotCreateAdvisory is an advisory sent after a snapshot is successfully started
 type JSSnapshotCreateAdvisory struct {
 	TypedEvent
@@ -259,6 +300,20 @@ type JSServerOutOfSpaceAdvisory struct {
 	Domain   string `json:"domain,omitempty"`
 }
 
+// JSStreamRecoveryAdvisoryType is sent periodically while a server is recovering a stream's store.
+const JSStreamRecoveryAdvisoryType = "io.nats.jetstream.advisory.v1.stream_recovery"
+
+// JSStreamRecoveryAdvisory indicates how far a server is in recovering a stream's store.
+type JSStreamRecoveryAdvisory struct {
+	TypedEvent
+	Server   string `json:"server"`
+	ServerID string `json:"server_id"`
+	Account  string `json:"account"`
+	Stream   string `json:"stream"`
+	RecoveryProgress
+	Domain string `json:"domain,omitempty"`
+}
+
 // JSServerRemovedAdvisoryType is sent when the server has been removed and JS disabled.
 const JSServerRemovedAdvisoryType = "io.nats.jetstream.advisory.v1.server_removed"
 
@@ -0,0 +1,329 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	// Max number of prior revisions kept for the config of a stream or consumer.
+	maxConfigRevisions = 10
+	// Where accounts keep their config history when not clustered.
+	configHistoryFile = "config_history.json"
+)
+
+// ConfigRevision is a revision of the config of a stream or consumer.
+type ConfigRevision struct {
+	Revision uint64          `json:"revision"`
+	Created  time.Time       `json:"created"`
+	Config   json.RawMessage `json:"config"`
+}
+
+// ConfigChange is a field that differs between two config revisions. The field
+// is the path of JSON names, separated by dots, and a missing value is omitted.
+type ConfigChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from,omitempty"`
+	To    json.RawMessage `json:"to,omitempty"`
+}
+
+// configHistory is the current revision of a config and the revisions it replaced.
+// It is not modified once created so copies of assignments can share it.
+// A nil history means the config is at its first revision.
+type configHistory struct {
+	Revision uint64            `json:"revision"`
+	Updated  time.Time         `json:"updated"`
+	Prior    []*ConfigRevision `json:"prior,omitempty"`
+}
+
+// Returns the history after cfg, current since created, was replaced.
+func (h *configHistory) record(created time.Time, cfg interface{}) *configHistory {
+	b, _ := json.Marshal(cfg)
+	nh := &configHistory{Revision: 2, Updated: time.Now().UTC()}
+	prior := &ConfigRevision{Revision: 1, Created: created, Config: b}
+	if h != nil {
+		nh.Revision = h.Revision + 1
+		prior.Revision, prior.Created = h.Revision, h.Updated
+		nh.Prior = append(nh.Prior, h.Prior...)
+	}
+	nh.Prior = append(nh.Prior, prior)
+	if n := len(nh.Prior); n > maxConfigRevisions {
+		nh.Prior = nh.Prior[n-maxConfigRevisions:]
+	}
+	return nh
+}
+
+// Returns all revisions, oldest first, with cfg as the current one.
+func (h *configHistory) revisions(created time.Time, cfg interface{}) []*ConfigRevision {
+	b, _ := json.Marshal(cfg)
+	cur := &ConfigRevision{Revision: 1, Created: created, Config: b}
+	if h == nil {
+		return []*ConfigRevision{cur}
+	}
+	cur.Revision, cur.Created = h.Revision, h.Updated
+	revs := make([]*ConfigRevision, 0, len(h.Prior)+1)
+	return append(append(revs, h.Prior...), cur)
+}
+
+// Returns the given revision, 0 being the current one.
+func findConfigRevision(revs []*ConfigRevision, rev uint64) *ConfigRevision {
+	if rev == 0 && len(revs) > 0 {
+		return revs[len(revs)-1]
+	}
+	for _, r := range revs {
+		if r.Revision == rev {
+			return r
+		}
+	}
+	return nil
+}
+
+// Returns the fields that changed between two configs.
+func diffConfigs(from, to json.RawMessage) []*ConfigChange {
+	var a, b interface{}
+	json.Unmarshal(from, &a)
+	json.Unmarshal(to, &b)
+	var changes []*ConfigChange
+	diffConfigValues(_EMPTY_, a, b, &changes)
+	return changes
+}
+
+func diffConfigValues(path string, a, b interface{}, changes *[]*ConfigChange) {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if aok && bok {
+		keys := make([]string, 0, len(am)+len(bm))
+		for k := range am {
+			keys = append(keys, k)
+		}
+		for k := range bm {
+			if _, ok := am[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			field := k
+			if path != _EMPTY_ {
+				field = path + "." + k
+			}
+			diffConfigValues(field, am[k], bm[k], changes)
+		}
+		return
+	}
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+	c := &ConfigChange{Field: path}
+	if a != nil {
+		c.From, _ = json.Marshal(a)
+	}
+	if b != nil {
+		c.To, _ = json.Marshal(b)
+	}
+	*changes = append(*changes, c)
+}
+
+// Config history of the streams, and their consumers, of an account when not
+// clustered. When clustered it is kept in the stream and consumer assignments.
+type jsaConfigHistory struct {
+	Streams map[string]*streamConfigHistory `json:"streams,omitempty"`
+}
+
+type streamConfigHistory struct {
+	Stream    *configHistory            `json:"stream,omitempty"`
+	Consumers map[string]*configHistory `json:"consumers,omitempty"`
+}
+
+// Returns the config history of the account, loading it if needed.
+// Lock should be held.
+func (jsa *jsAccount) configHistoryLocked() *jsaConfigHistory {
+	if jsa.hist != nil {
+		return jsa.hist
+	}
+	jsa.hist = &jsaConfigHistory{}
+	if b, err := os.ReadFile(filepath.Join(jsa.storeDir, configHistoryFile)); err == nil {
+		json.Unmarshal(b, jsa.hist)
+	}
+	if jsa.hist.Streams == nil {
+		jsa.hist.Streams = make(map[string]*streamConfigHistory)
+	}
+	return jsa.hist
+}
+
+// Stores the config history, dropping streams and consumers that are gone.
+// Accounts with encryption only keep it in memory.
+// Lock should be held.
+func (jsa *jsAccount) storeConfigHistoryLocked() {
+	acc := jsa.acc()
+	for name, sh := range jsa.hist.Streams {
+		mset, err := acc.lookupStream(name)
+		if err != nil {
+			delete(jsa.hist.Streams, name)
+			continue
+		}
+		for cn := range sh.Consumers {
+			if mset.lookupConsumer(cn) == nil {
+				delete(sh.Consumers, cn)
+			}
+		}
+		if sh.Stream == nil && len(sh.Consumers) == 0 {
+			delete(jsa.hist.Streams, name)
+		}
+	}
+	if s := acc.srv; s == nil || s.jsKeyGen(acc.Name) != nil {
+		return
+	}
+	b, err := json.Marshal(jsa.hist)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(jsa.storeDir, defaultDirPerms); err == nil {
+		os.WriteFile(filepath.Join(jsa.storeDir, configHistoryFile), b, defaultFilePerms)
+	}
+}
+
+// Returns the history of a stream's config, creating it if asked to.
+// Lock should be held.
+func (jsa *jsAccount) streamConfigHistoryLocked(stream string, create bool) *streamConfigHistory {
+	hist := jsa.configHistoryLocked()
+	sh := hist.Streams[stream]
+	if sh == nil && create {
+		sh = &streamConfigHistory{}
+		hist.Streams[stream] = sh
+	}
+	return sh
+}
+
+// Records the config a stream had before it was updated.
+func (jsa *jsAccount) recordStreamConfig(stream string, created time.Time, ocfg *StreamConfig) {
+	jsa.hmu.Lock()
+	defer jsa.hmu.Unlock()
+	sh := jsa.streamConfigHistoryLocked(stream, true)
+	sh.Stream = sh.Stream.record(created, ocfg)
+	jsa.storeConfigHistoryLocked()
+}
+
+// Drops any history left from a prior stream with this name.
+func (jsa *jsAccount) resetStreamConfigHistory(stream string) {
+	jsa.hmu.Lock()
+	defer jsa.hmu.Unlock()
+	if jsa.streamConfigHistoryLocked(stream, false) != nil {
+		delete(jsa.hist.Streams, stream)
+		jsa.storeConfigHistoryLocked()
+	}
+}
+
+// Records the config a consumer had before it was updated.
+func (jsa *jsAccount) recordConsumerConfig(stream, consumer string, created time.Time, ocfg *ConsumerConfig) {
+	jsa.hmu.Lock()
+	defer jsa.hmu.Unlock()
+	sh := jsa.streamConfigHistoryLocked(stream, true)
+	if sh.Consumers == nil {
+		sh.Consumers = make(map[string]*configHistory)
+	}
+	sh.Consumers[consumer] = sh.Consumers[consumer].record(created, ocfg)
+	jsa.storeConfigHistoryLocked()
+}
+
+// Drops any history left from a prior consumer with this name.
+func (jsa *jsAccount) resetConsumerConfigHistory(stream, consumer string) {
+	jsa.hmu.Lock()
+	defer jsa.hmu.Unlock()
+	if sh := jsa.streamConfigHistoryLocked(stream, false); sh != nil && sh.Consumers[consumer] != nil {
+		delete(sh.Consumers, consumer)
+		jsa.storeConfigHistoryLocked()
+	}
+}
+
+// Returns the config history of a stream or, if consumer is set, one of its consumers.
+func (jsa *jsAccount) configHistory(stream, consumer string) *configHistory {
+	jsa.hmu.Lock()
+	defer jsa.hmu.Unlock()
+	sh := jsa.streamConfigHistoryLocked(stream, false)
+	if sh == nil {
+		return nil
+	}
+	if consumer != _EMPTY_ {
+		return sh.Consumers[consumer]
+	}
+	return sh.Stream
+}
+
+// Adds or updates a consumer when not clustered, recording the config an update replaced.
+func (mset *stream) addConsumerWithHistory(cfg *ConsumerConfig) (*consumer, error) {
+	name := cfg.Durable
+	if name == _EMPTY_ {
+		name = cfg.Name
+	}
+	var ocfg *ConsumerConfig
+	var created time.Time
+	if name != _EMPTY_ {
+		if o := mset.lookupConsumer(name); o != nil {
+			c := o.config()
+			ocfg, created = &c, o.createdTime()
+		}
+	}
+	o, err := mset.addConsumer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if ocfg == nil {
+		mset.jsa.resetConsumerConfigHistory(mset.name(), o.String())
+	} else if ncfg := o.config(); !reflect.DeepEqual(*ocfg, ncfg) {
+		mset.jsa.recordConsumerConfig(mset.name(), o.String(), created, ocfg)
+	}
+	return o, nil
+}
+
+// Returns the config revisions of a stream or one of its consumers, current one last.
+func (s *Server) configRevisions(acc *Account, stream, consumer string) ([]*ConfigRevision, *ApiError) {
+	if s.JetStreamIsClustered() {
+		js := s.getJetStream()
+		js.mu.RLock()
+		defer js.mu.RUnlock()
+		sa := js.streamAssignment(acc.Name, stream)
+		if sa == nil {
+			return nil, NewJSStreamNotFoundError()
+		}
+		if consumer == _EMPTY_ {
+			return sa.ConfigHistory.revisions(sa.Created, sa.Config), nil
+		}
+		ca := sa.consumers[consumer]
+		if ca == nil || ca.deleted {
+			return nil, NewJSConsumerNotFoundError()
+		}
+		return ca.ConfigHistory.revisions(ca.Created, ca.Config), nil
+	}
+
+	mset, err := acc.lookupStream(stream)
+	if err != nil {
+		return nil, NewJSStreamNotFoundError(Unless(err))
+	}
+	h := mset.jsa.configHistory(stream, consumer)
+	if consumer == _EMPTY_ {
+		return h.revisions(mset.createdTime(), mset.config()), nil
+	}
+	o := mset.lookupConsumer(consumer)
+	if o == nil {
+		return nil, NewJSConsumerNotFoundError()
+	}
+	return h.revisions(o.createdTime(), o.config()), nil
+}
@@ -17,16 +17,21 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	crand "crypto/rand"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -41,11 +46,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/s2"
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nats-server/v2/server/sysmem"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nkeys"
 	"github.com/nats-io/nuid"
+	"golang.org/x/crypto/nacl/box"
 )
 
 func TestJetStreamBasicNilConfig(t *testing.T) {
@@ -3507,6 +3514,132 @@ func TestJetStreamPullConsumerRemoveInterest(t *testing.T) {
 	}
 }
 
+func TestJetStreamConsumerReadAhead(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	// Small MaxBytes gives us small blocks.
+	addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: FileStorage, MaxBytes: 100_000})
+	msg := bytes.Repeat([]byte("Z"), 4000)
+	for i := 0; i < 20; i++ {
+		_, err := js.Publish("foo", msg)
+		require_NoError(t, err)
+	}
+
+	// Restart to get a cold cache.
+	sd := s.JetStreamConfig().StoreDir
+	nc.Close()
+	s.Shutdown()
+	s = RunJetStreamServerOnPort(-1, sd)
+	defer s.Shutdown()
+	nc, js = jsClientConnect(t, s)
+	defer nc.Close()
+
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	fs := mset.store.(*fileStore)
+	nblks := fs.numMsgBlocks()
+	require_True(t, nblks > 1)
+	cl := fs.cacheLoads()
+
+	// Reading the first message should have the store load the blocks after it.
+	sub, err := js.PullSubscribe("foo", "dlc")
+	require_NoError(t, err)
+	msgs, err := sub.Fetch(1)
+	require_NoError(t, err)
+	require_True(t, len(msgs) == 1)
+	checkFor(t, time.Second, 10*time.Millisecond, func() error {
+		if n := fs.cacheLoads() - cl; n != uint64(nblks) {
+			return fmt.Errorf("expected %d cache loads, got %d", nblks, n)
+		}
+		return nil
+	})
+}
+
+func TestJetStreamConsumerAdaptivePrefetch(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	mset, err := s.GlobalAccount().addStream(&StreamConfig{Name: "TEST", Storage: MemoryStorage})
+	require_NoError(t, err)
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	for i := 0; i < 500; i++ {
+		nc.Publish("TEST", []byte("ok"))
+	}
+	nc.Flush()
+
+	// Needs push and explicit acks.
+	_, err = mset.addConsumer(&ConsumerConfig{Durable: "pull", AckPolicy: AckExplicit, AdaptivePrefetch: true})
+	require_Error(t, err)
+	_, err = mset.addConsumer(&ConsumerConfig{Durable: "none", DeliverSubject: "none", AckPolicy: AckNone, AdaptivePrefetch: true})
+	require_Error(t, err)
+
+	// A fast client grows the window.
+	fast, err := mset.addConsumer(&ConsumerConfig{
+		Durable:          "fast",
+		DeliverSubject:   "fast",
+		AckPolicy:        AckExplicit,
+		AckWait:          5 * time.Second,
+		MaxAckPending:    1000,
+		AdaptivePrefetch: true,
+	})
+	require_NoError(t, err)
+	defer fast.delete()
+	require_True(t, fast.info().PrefetchWindow == adaptivePrefetchStart)
+
+	sub, err := nc.Subscribe("fast", func(m *nats.Msg) { m.Respond(nil) })
+	require_NoError(t, err)
+	defer sub.Unsubscribe()
+	checkFor(t, 5*time.Second, 50*time.Millisecond, func() error {
+		if info := fast.info(); info.AckFloor.Stream != 500 {
+			return fmt.Errorf("expected all acked, got %+v", info.AckFloor)
+		}
+		return nil
+	})
+	require_True(t, fast.info().PrefetchWindow > adaptivePrefetchStart)
+
+	// A slow client is held to the window, which shrinks as messages are not acked in time.
+	slow, err := mset.addConsumer(&ConsumerConfig{
+		Durable:          "slow",
+		DeliverSubject:   "slow",
+		AckPolicy:        AckExplicit,
+		AckWait:          250 * time.Millisecond,
+		MaxAckPending:    1000,
+		AdaptivePrefetch: true,
+	})
+	require_NoError(t, err)
+	defer slow.delete()
+
+	ssub, err := nc.SubscribeSync("slow")
+	require_NoError(t, err)
+	defer ssub.Unsubscribe()
+	checkFor(t, 2*time.Second, 20*time.Millisecond, func() error {
+		if n, _, _ := ssub.Pending(); n != adaptivePrefetchStart {
+			return fmt.Errorf("expected %d delivered, got %d", adaptivePrefetchStart, n)
+		}
+		return nil
+	})
+	require_True(t, slow.info().NumAckPending == adaptivePrefetchStart)
+	checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+		if w := slow.info().PrefetchWindow; w >= adaptivePrefetchStart {
+			return fmt.Errorf("expected window to shrink, got %d", w)
+		}
+		return nil
+	})
+
+	// Turning it off goes back to MaxAckPending.
+	cfg := slow.config()
+	cfg.AdaptivePrefetch = false
+	require_NoError(t, slow.updateConfig(&cfg))
+	require_True(t, slow.info().PrefetchWindow == 0)
+}
+
 func TestJetStreamConsumerRateLimit(t *testing.T) {
 	s := RunBasicJetStreamServer(t)
 	defer s.Shutdown()
@@ -4504,6 +4637,170 @@ func TestJetStreamPubPerfWithFullStream(t *testing.T) {
 	fmt.Printf("%.0f msgs/sec\n", float64(toSend)/tt.Seconds())
 }
 
+func TestJetStreamSnapshotsAPIEncrypted(t *testing.T) {
+	cpub, cpriv, err := box.GenerateKey(crand.Reader)
+	require_NoError(t, err)
+	_, opriv, err := box.GenerateKey(crand.Reader)
+	require_NoError(t, err)
+	b64 := func(k *[32]byte) string { return base64.StdEncoding.EncodeToString(k[:]) }
+
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: 127.0.0.1:-1
+		jetstream {
+			store_dir: %q
+			backup_keys { offsite: %q }
+		}
+	`, t.TempDir(), b64(opriv))))
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	cfg := &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: FileStorage}
+	addStream(t, nc, cfg)
+	for i := 0; i < 500; i++ {
+		_, err := js.Publish("foo", []byte(fmt.Sprintf("Hello World %d", i)))
+		require_NoError(t, err)
+	}
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	state := mset.state()
+
+	snapshot := func(se *SnapshotEncryption) (*JSApiStreamSnapshotResponse, []byte, *SnapshotManifest) {
+		t.Helper()
+		sreq := &JSApiStreamSnapshotRequest{DeliverSubject: nats.NewInbox(), ChunkSize: 1024, Encryption: se}
+		sub := natsSubSync(t, nc, sreq.DeliverSubject)
+		defer sub.Unsubscribe()
+		req, _ := json.Marshal(sreq)
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamSnapshotT, "TEST"), req, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamSnapshotResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		if resp.Error != nil {
+			return &resp, nil, nil
+		}
+		var data []byte
+		for {
+			m := natsNexMsg(t, sub, 5*time.Second)
+			if len(m.Data) == 0 {
+				var manifest SnapshotManifest
+				require_NoError(t, json.Unmarshal([]byte(m.Header.Get(JSSnapshotManifest)), &manifest))
+				return &resp, data, &manifest
+			}
+			data = append(data, m.Data...)
+			m.Respond(nil)
+		}
+	}
+
+	restore := func(data []byte, se *SnapshotEncryption, manifest *SnapshotManifest) *ApiError {
+		t.Helper()
+		req, _ := json.Marshal(&JSApiStreamRestoreRequest{Config: *cfg, State: state, Encryption: se, Manifest: manifest})
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamRestoreT, "TEST"), req, time.Second)
+		require_NoError(t, err)
+		var rresp JSApiStreamRestoreResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &rresp))
+		if rresp.Error != nil {
+			return rresp.Error
+		}
+		for r := bytes.NewReader(data); ; {
+			var chunk [512]byte
+			n, err := r.Read(chunk[:])
+			if err != nil {
+				break
+			}
+			_, err = nc.Request(rresp.DeliverSubject, chunk[:n], time.Second)
+			require_NoError(t, err)
+		}
+		rmsg, err = nc.Request(rresp.DeliverSubject, nil, 5*time.Second)
+		require_NoError(t, err)
+		var cresp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &cresp))
+		return cresp.Error
+	}
+
+	// Bad requests.
+	for _, se := range []*SnapshotEncryption{
+		{},
+		{Key: "unknown"},
+		{Key: "offsite", PublicKey: b64(cpub)},
+		{PublicKey: "bad"},
+		{PublicKey: b64(cpub), Cipher: "rot13"},
+	} {
+		resp, _, _ := snapshot(se)
+		if !IsNatsErr(resp.Error, JSSnapshotEncryptionInvalidErrF) {
+			t.Fatalf("Expected encryption error for %+v, got %+v", se, resp.Error)
+		}
+	}
+
+	// Plain snapshots carry a manifest too.
+	_, plain, pm := snapshot(nil)
+	require_True(t, !pm.Encrypted && pm.Bytes == uint64(len(plain)) && pm.Chunks > 0)
+
+	// Sealed to a configured key.
+	resp, sealed, sm := snapshot(&SnapshotEncryption{Key: "offsite"})
+	require_True(t, resp.Encryption != nil)
+	require_Equal(t, resp.Encryption.Cipher, snapshotCipherChaCha)
+	require_True(t, resp.Encryption.SealedKey != _EMPTY_)
+	require_True(t, sm.Encrypted && sm.Bytes == uint64(len(sealed)))
+	if bytes.Contains(sealed, plain[:64]) {
+		t.Fatalf("Expected snapshot to be encrypted")
+	}
+
+	// Sealed to a public key only the client has the private key for.
+	cresp, csealed, cm := snapshot(&SnapshotEncryption{PublicKey: b64(cpub)})
+	require_Equal(t, cresp.Encryption.PublicKey, b64(cpub))
+
+	mset.delete()
+
+	// Wrong private key can't open the data key.
+	oe := &SnapshotEncryption{PrivateKey: b64(cpriv), SealedKey: resp.Encryption.SealedKey}
+	if err := restore(sealed, oe, nil); !IsNatsErr(err, JSSnapshotEncryptionInvalidErrF) {
+		t.Fatalf("Expected encryption error, got %+v", err)
+	}
+	// Manifest mismatch.
+	bad := *sm
+	bad.Digest = pm.Digest
+	oe = &SnapshotEncryption{Key: "offsite", SealedKey: resp.Encryption.SealedKey}
+	if err := restore(sealed, oe, &bad); err == nil || !strings.Contains(err.Description, "digest mismatch") {
+		t.Fatalf("Expected manifest error, got %+v", err)
+	}
+	// Tampered data.
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)/2] ^= 0xff
+	if err := restore(tampered, oe, nil); err == nil || !strings.Contains(err.Description, "unable to decrypt") {
+		t.Fatalf("Expected decrypt error, got %+v", err)
+	}
+	// Truncated data.
+	if err := restore(sealed[:len(sealed)-100], oe, nil); err == nil {
+		t.Fatalf("Expected error for truncated snapshot")
+	}
+	_, err = s.GlobalAccount().lookupStream("TEST")
+	require_Error(t, err)
+
+	// Restore with the configured key.
+	if err := restore(sealed, oe, sm); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	mset, err = s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	if !reflect.DeepEqual(mset.state(), state) {
+		t.Fatalf("Did not match states, %+v vs %+v", mset.state(), state)
+	}
+	mset.delete()
+
+	// Restore with the client's private key.
+	ce := &SnapshotEncryption{PrivateKey: b64(cpriv), SealedKey: cresp.Encryption.SealedKey}
+	if err := restore(csealed, ce, cm); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	mset, err = s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	if !reflect.DeepEqual(mset.state(), state) {
+		t.Fatalf("Did not match states, %+v vs %+v", mset.state(), state)
+	}
+}
+
 func TestJetStreamSnapshotsAPIPerf(t *testing.T) {
 	// Comment out to run, holding place for now.
 	t.SkipNow()
@@ -8206,15 +8503,19 @@ func TestJetStreamUpdateStream(t *testing.T) {
 			if err := mset.update(&cfg); err == nil || !strings.Contains(err.Error(), "can not change") {
 				t.Fatalf("Expected error trying to change MaxConsumers")
 			}
-			// Can't change storage types.
+			// Can change storage types, and back again.
 			cfg = *c.mconfig
 			if cfg.Storage == FileStorage {
 				cfg.Storage = MemoryStorage
 			} else {
 				cfg.Storage = FileStorage
 			}
-			if err := mset.update(&cfg); err == nil || !strings.Contains(err.Error(), "can not change") {
-				t.Fatalf("Expected error trying to change Storage")
+			if err := mset.update(&cfg); err != nil {
+				t.Fatalf("Unexpected error trying to change Storage: %v", err)
+			}
+			cfg = *c.mconfig
+			if err := mset.update(&cfg); err != nil {
+				t.Fatalf("Unexpected error trying to change Storage back: %v", err)
 			}
 			// Can't change replicas > 1 for now.
 			cfg = *c.mconfig
@@ -8841,6 +9142,97 @@ func TestJetStreamTemplateBasics(t *testing.T) {
 	}
 }
 
+func TestJetStreamTemplateSubjectPlaceholders(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	acc := s.GlobalAccount()
+
+	template := &StreamTemplateConfig{
+		Name: "orders",
+		Config: &StreamConfig{
+			Retention: LimitsPolicy,
+			MaxMsgs:   100,
+			Storage:   MemoryStorage,
+			Replicas:  1,
+		},
+		MaxStreams:  4,
+		Subjects:    []string{"orders.{region}.>", "returns.{region}"},
+		StreamName:  "ORDERS_{region}",
+		Overrides:   map[string]*StreamTemplateOverride{"ORDERS_eu": {MaxMsgs: 2}},
+		IdleTimeout: time.Second,
+	}
+
+	// Bad templates.
+	for _, bad := range []func(tc *StreamTemplateConfig){
+		func(tc *StreamTemplateConfig) { tc.StreamName = "ORDERS" },
+		func(tc *StreamTemplateConfig) { tc.Subjects = []string{"orders.>"} },
+		func(tc *StreamTemplateConfig) { tc.Subjects = []string{"orders.{region}.>", "returns.{country}"} },
+		func(tc *StreamTemplateConfig) { tc.Config.Subjects = []string{"orders.>"} },
+		func(tc *StreamTemplateConfig) { tc.StreamName = _EMPTY_ },
+	} {
+		tc := template.deepCopy()
+		bad(tc)
+		if _, err := acc.addStreamTemplate(tc); err == nil {
+			t.Fatalf("Expected an error for %+v", tc)
+		}
+	}
+
+	if _, err := acc.addStreamTemplate(template); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	nc := clientConnectToServer(t, s)
+	defer nc.Close()
+
+	for i := 0; i < 3; i++ {
+		sendStreamMsg(t, nc, "orders.eu.new", "ok")
+		sendStreamMsg(t, nc, "returns.us", "ok")
+	}
+	sendStreamMsg(t, nc, "orders.us.new", "ok")
+
+	if nms := acc.numStreams(); nms != 2 {
+		t.Fatalf("Expected 2 auto-created streams, got %d", nms)
+	}
+	mset, err := acc.lookupStream("ORDERS_eu")
+	require_NoError(t, err)
+	cfg := mset.config()
+	require_Equal(t, strings.Join(cfg.Subjects, ","), "orders.eu.>,returns.eu")
+	require_True(t, cfg.MaxMsgs == 2)
+	require_True(t, mset.state().Msgs == 2)
+
+	mset, err = acc.lookupStream("ORDERS_us")
+	require_NoError(t, err)
+	require_True(t, mset.config().MaxMsgs == 100)
+	require_True(t, mset.state().Msgs == 4)
+
+	// Idle streams go away, and come back on the next message.
+	tmpl, err := acc.lookupStreamTemplate(template.Name)
+	require_NoError(t, err)
+	checkFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+		if nms := acc.numStreams(); nms != 0 {
+			return fmt.Errorf("Expected idle streams to be removed, got %d", nms)
+		}
+		tmpl.mu.Lock()
+		n := len(tmpl.streams)
+		tmpl.mu.Unlock()
+		if n != 0 {
+			return fmt.Errorf("Expected template to track no streams, got %d", n)
+		}
+		return nil
+	})
+	sendStreamMsg(t, nc, "orders.eu.new", "ok")
+	_, err = acc.lookupStream("ORDERS_eu")
+	require_NoError(t, err)
+
+	if err := acc.deleteStreamTemplate(template.Name); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if nms := acc.numStreams(); nms != 0 {
+		t.Fatalf("Expected no streams, got %d", nms)
+	}
+}
+
 func TestJetStreamTemplateFileStoreRecovery(t *testing.T) {
 	s := RunBasicJetStreamServer(t)
 	defer s.Shutdown()
@@ -11027,6 +11419,43 @@ func TestJetStreamMaxMsgsPerSubject(t *testing.T) {
 	}
 }
 
+func TestJetStreamMaxBytesPerSubject(t *testing.T) {
+	for _, st := range []StorageType{FileStorage, MemoryStorage} {
+		t.Run(st.String(), func(t *testing.T) {
+			s := RunBasicJetStreamServer(t)
+			defer s.Shutdown()
+
+			msg := bytes.Repeat([]byte("Z"), 100)
+			mset, err := s.GlobalAccount().addStream(&StreamConfig{
+				Name:        "TEST",
+				Subjects:    []string{"foo", "bar"},
+				Storage:     st,
+				MaxBytesPer: 1024,
+			})
+			require_NoError(t, err)
+
+			nc, js := jsClientConnect(t, s)
+			defer nc.Close()
+
+			for i := 0; i < 50; i++ {
+				_, err := js.Publish("foo", msg)
+				require_NoError(t, err)
+			}
+			_, err = js.Publish("bar", msg)
+			require_NoError(t, err)
+
+			ss := mset.store.SubjectsState("foo")["foo"]
+			require_True(t, ss.Bytes <= 1024)
+			require_True(t, ss.Msgs > 1 && ss.Msgs < 50)
+			require_True(t, ss.Last == 50)
+
+			si, err := js.StreamInfo("TEST")
+			require_NoError(t, err)
+			require_True(t, si.State.Msgs == ss.Msgs+1)
+		})
+	}
+}
+
 func TestJetStreamGetLastMsgBySubject(t *testing.T) {
 	for _, st := range []StorageType{FileStorage, MemoryStorage} {
 		t.Run(st.String(), func(t *testing.T) {
@@ -11189,6 +11618,82 @@ func TestJetStreamGetLastMsgBySubjectAfterUpdate(t *testing.T) {
 	basicCheck("bar", 4)
 }
 
+func TestJetStreamMsgGetByStartTime(t *testing.T) {
+	for _, st := range []StorageType{FileStorage, MemoryStorage} {
+		t.Run(st.String(), func(t *testing.T) {
+			s := RunBasicJetStreamServer(t)
+			defer s.Shutdown()
+
+			nc, js := jsClientConnect(t, s)
+			defer nc.Close()
+
+			addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo.*"}, Storage: st, AllowDirect: true})
+
+			var times []time.Time
+			for i := 0; i < 200; i++ {
+				subj := "foo.a"
+				if i%2 == 1 {
+					subj = "foo.b"
+				}
+				_, err := js.Publish(subj, []byte("ok"))
+				require_NoError(t, err)
+				m, err := js.GetMsg("TEST", uint64(i+1))
+				require_NoError(t, err)
+				times = append(times, m.Time)
+			}
+
+			getMsg := func(req *JSApiMsgGetRequest) *JSApiMsgGetResponse {
+				t.Helper()
+				b, err := json.Marshal(req)
+				require_NoError(t, err)
+				m, err := nc.Request(fmt.Sprintf(JSApiMsgGetT, "TEST"), b, time.Second)
+				require_NoError(t, err)
+				var resp JSApiMsgGetResponse
+				require_NoError(t, json.Unmarshal(m.Data, &resp))
+				return &resp
+			}
+
+			start := times[100].Add(time.Nanosecond)
+			resp := getMsg(&JSApiMsgGetRequest{StartTime: &start})
+			require_True(t, resp.Error == nil)
+			require_True(t, resp.Message.Sequence == 102)
+			require_Equal(t, resp.Message.Subject, "foo.b")
+
+			// With a subject.
+			resp = getMsg(&JSApiMsgGetRequest{StartTime: &start, NextFor: "foo.a"})
+			require_True(t, resp.Error == nil)
+			require_True(t, resp.Message.Sequence == 103)
+			require_Equal(t, resp.Message.Subject, "foo.a")
+
+			// Before the first and after the last.
+			first := times[0].Add(-time.Hour)
+			resp = getMsg(&JSApiMsgGetRequest{StartTime: &first})
+			require_True(t, resp.Error == nil)
+			require_True(t, resp.Message.Sequence == 1)
+			after := times[199].Add(time.Nanosecond)
+			resp = getMsg(&JSApiMsgGetRequest{StartTime: &after})
+			require_True(t, resp.Error != nil)
+			require_True(t, resp.Error.ErrCode == uint16(JSNoMessageFoundErr))
+
+			// Can not be combined with a sequence or last.
+			resp = getMsg(&JSApiMsgGetRequest{StartTime: &start, Seq: 10})
+			require_True(t, resp.Error != nil)
+			require_True(t, resp.Error.ErrCode == uint16(JSBadRequestErr))
+			resp = getMsg(&JSApiMsgGetRequest{StartTime: &start, LastFor: "foo.a"})
+			require_True(t, resp.Error != nil)
+			require_True(t, resp.Error.ErrCode == uint16(JSBadRequestErr))
+
+			// Direct gets too.
+			b, err := json.Marshal(&JSApiMsgGetRequest{StartTime: &start, NextFor: "foo.a"})
+			require_NoError(t, err)
+			m, err := nc.Request(fmt.Sprintf(JSDirectMsgGetT, "TEST"), b, time.Second)
+			require_NoError(t, err)
+			require_Equal(t, m.Header.Get(JSSequence), "103")
+			require_Equal(t, m.Header.Get(JSSubject), "foo.a")
+		})
+	}
+}
+
 func TestJetStreamLastSequenceBySubject(t *testing.T) {
 	for _, st := range []StorageType{FileStorage, MemoryStorage} {
 		t.Run(st.String(), func(t *testing.T) {
@@ -11555,38 +12060,117 @@ func TestJetStreamMirrorBasics(t *testing.T) {
 	})
 }
 
-func TestJetStreamMirrorUpdatePreventsSubjects(t *testing.T) {
+func TestJetStreamMirrorResumable(t *testing.T) {
 	s := RunBasicJetStreamServer(t)
 	defer s.Shutdown()
 
-	// Client for API requests.
 	nc, js := jsClientConnect(t, s)
 	defer nc.Close()
 
-	_, err := js.AddStream(&nats.StreamConfig{Name: "ORIGINAL"})
+	// Only mirrors can be resumable.
+	req, _ := json.Marshal(&StreamConfig{Name: "BAD", Storage: MemoryStorage, Sources: []*StreamSource{{Name: "O", Resumable: true}}})
+	rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, "BAD"), req, time.Second)
 	require_NoError(t, err)
+	var resp JSApiStreamCreateResponse
+	require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
 
-	_, err = js.AddStream(&nats.StreamConfig{Name: "MIRROR", Mirror: &nats.StreamSource{Name: "ORIGINAL"}})
-	require_NoError(t, err)
+	addStream(t, nc, &StreamConfig{Name: "O", Subjects: []string{"foo"}, Storage: FileStorage})
+	addStream(t, nc, &StreamConfig{Name: "M", Storage: MemoryStorage, Mirror: &StreamSource{Name: "O", Resumable: true}})
+	addStream(t, nc, &StreamConfig{Name: "N", Storage: MemoryStorage, Mirror: &StreamSource{Name: "O"}})
 
-	_, err = js.UpdateStream(&nats.StreamConfig{Name: "MIRROR", Mirror: &nats.StreamSource{Name: "ORIGINAL"}, Subjects: []string{"x"}})
-	if err == nil || err.Error() != "nats: stream mirrors can not contain subjects" {
-		t.Fatalf("Expected to not be able to put subjects on a stream, got: %+v", err)
+	for i := 0; i < 3000; i++ {
+		js.PublishAsync("foo", []byte("ok"))
+	}
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive completion signal")
 	}
-}
-
-func TestJetStreamSourceBasics(t *testing.T) {
-	s := RunBasicJetStreamServer(t)
-	defer s.Shutdown()
-
-	// Client for API requests.
-	nc, js := jsClientConnect(t, s)
-	defer nc.Close()
 
-	createStream := func(cfg *StreamConfig) {
+	checkMirror := func(name string, msgs, lseq uint64) {
 		t.Helper()
-		req, err := json.Marshal(cfg)
-		if err != nil {
+		checkFor(t, 5*time.Second, 50*time.Millisecond, func() error {
+			si, err := js.StreamInfo(name)
+			if err != nil {
+				return err
+			}
+			if si.State.Msgs != msgs || si.State.LastSeq != lseq {
+				return fmt.Errorf("expected %d msgs with last seq %d, got %+v", msgs, lseq, si.State)
+			}
+			return nil
+		})
+	}
+	checkMirror("M", 3000, 3000)
+	checkMirror("N", 3000, 3000)
+
+	// Checkpointed in chunks while catching up.
+	mset, err := s.GlobalAccount().lookupStream("M")
+	require_NoError(t, err)
+	mset.mu.RLock()
+	ckseq := mset.mckseq
+	mset.mu.RUnlock()
+	require_True(t, ckseq >= mirrorCheckpointChunk && ckseq <= 3000)
+
+	// Memory mirrors lose their messages on restart and need to be recreated.
+	sd := s.JetStreamConfig().StoreDir
+	nc.Close()
+	s.Shutdown()
+	s = RunJetStreamServerOnPort(-1, sd)
+	defer s.Shutdown()
+
+	nc, js = jsClientConnect(t, s)
+	defer nc.Close()
+
+	addStream(t, nc, &StreamConfig{Name: "M", Storage: MemoryStorage, Mirror: &StreamSource{Name: "O", Resumable: true}})
+	addStream(t, nc, &StreamConfig{Name: "N", Storage: MemoryStorage, Mirror: &StreamSource{Name: "O"}})
+
+	for i := 0; i < 10; i++ {
+		_, err := js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+	// The resumable mirror picks up from its checkpoint, the other catches up from the start.
+	checkMirror("M", 10, 3010)
+	checkMirror("N", 3010, 3010)
+
+	// Checkpoint is removed with the stream.
+	require_NoError(t, js.DeleteStream("M"))
+	_, err = os.Stat(filepath.Join(sd, globalAccountName, streamsDir, "M", mirrorCheckpointFile))
+	require_True(t, os.IsNotExist(err))
+}
+
+func TestJetStreamMirrorUpdatePreventsSubjects(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	// Client for API requests.
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "ORIGINAL"})
+	require_NoError(t, err)
+
+	_, err = js.AddStream(&nats.StreamConfig{Name: "MIRROR", Mirror: &nats.StreamSource{Name: "ORIGINAL"}})
+	require_NoError(t, err)
+
+	_, err = js.UpdateStream(&nats.StreamConfig{Name: "MIRROR", Mirror: &nats.StreamSource{Name: "ORIGINAL"}, Subjects: []string{"x"}})
+	if err == nil || err.Error() != "nats: stream mirrors can not contain subjects" {
+		t.Fatalf("Expected to not be able to put subjects on a stream, got: %+v", err)
+	}
+}
+
+func TestJetStreamSourceBasics(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	// Client for API requests.
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	createStream := func(cfg *StreamConfig) {
+		t.Helper()
+		req, err := json.Marshal(cfg)
+		if err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
 		rm, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, cfg.Name), req, time.Second)
@@ -14712,6 +15296,214 @@ func TestJetStreamStreamInfoSubjectsDetails(t *testing.T) {
 	t.Run("FileStore", func(t *testing.T) { testSubjects(t, nats.FileStorage) })
 }
 
+func TestJetStreamStreamInfoSubjectBytes(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	getInfo := func(t *testing.T, subjectBytes bool) *StreamInfo {
+		t.Helper()
+		req, err := json.Marshal(&JSApiStreamInfoRequest{SubjectsFilter: ">", SubjectBytes: subjectBytes})
+		require_NoError(t, err)
+		resp, err := nc.Request(fmt.Sprintf(JSApiStreamInfoT, "TEST"), req, time.Second)
+		require_NoError(t, err)
+		var si StreamInfo
+		require_NoError(t, json.Unmarshal(resp.Data, &si))
+		return &si
+	}
+
+	testSubjectBytes := func(t *testing.T, st nats.StorageType, msgSize func(subj string, hdr, msg []byte) uint64) {
+		_, err := js.AddStream(&nats.StreamConfig{
+			Name:     "TEST",
+			Subjects: []string{"*"},
+			Storage:  st,
+		})
+		require_NoError(t, err)
+		defer js.DeleteStream("TEST")
+
+		for _, subj := range []string{"foo", "bar", "bar"} {
+			_, err = js.Publish(subj, []byte("ok"))
+			require_NoError(t, err)
+		}
+		_, err = js.Publish("baz", bytes.Repeat([]byte("Z"), 100))
+		require_NoError(t, err)
+
+		// Only reported when asked for.
+		si := getInfo(t, false)
+		require_True(t, len(si.State.Subjects) == 3)
+		require_True(t, si.State.SubjectBytes == nil)
+
+		expected := map[string]uint64{
+			"foo": msgSize("foo", nil, []byte("ok")),
+			"bar": 2 * msgSize("bar", nil, []byte("ok")),
+			"baz": msgSize("baz", nil, bytes.Repeat([]byte("Z"), 100)),
+		}
+		si = getInfo(t, true)
+		if !reflect.DeepEqual(si.State.SubjectBytes, expected) {
+			t.Fatalf("Expected subject bytes of %+v, but got %+v", expected, si.State.SubjectBytes)
+		}
+		require_True(t, expected["foo"]+expected["bar"]+expected["baz"] == si.State.Bytes)
+	}
+
+	t.Run("MemoryStore", func(t *testing.T) { testSubjectBytes(t, nats.MemoryStorage, memStoreMsgSize) })
+	t.Run("FileStore", func(t *testing.T) { testSubjectBytes(t, nats.FileStorage, fileStoreMsgSize) })
+}
+
+func TestJetStreamStreamInfoDeletedRanges(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	for i := 0; i < 20; i++ {
+		_, err = js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+	for seq := uint64(5); seq <= 14; seq++ {
+		require_NoError(t, js.DeleteMsg("TEST", seq))
+	}
+
+	getState := func(t *testing.T, req *JSApiStreamInfoRequest) StreamState {
+		t.Helper()
+		b, err := json.Marshal(req)
+		require_NoError(t, err)
+		resp, err := nc.Request(fmt.Sprintf(JSApiStreamInfoT, "TEST"), b, time.Second)
+		require_NoError(t, err)
+		var si StreamInfo
+		require_NoError(t, json.Unmarshal(resp.Data, &si))
+		return si.State
+	}
+
+	// Details still list every deleted sequence.
+	state := getState(t, &JSApiStreamInfoRequest{DeletedDetails: true})
+	require_True(t, state.NumDeleted == 10)
+	require_True(t, len(state.Deleted) == 10)
+	require_True(t, state.DeletedRanges == nil)
+
+	state = getState(t, &JSApiStreamInfoRequest{DeletedRanges: true})
+	require_True(t, state.NumDeleted == 10)
+	require_True(t, state.Deleted == nil)
+	if expected := (DeleteRanges{{5, 10}}); !reflect.DeepEqual(state.DeletedRanges, expected) {
+		t.Fatalf("Expected deleted ranges to be %+v, got %+v", expected, state.DeletedRanges)
+	}
+	require_True(t, len(state.DeletedSeqs()) == 10)
+}
+
+func TestJetStreamStreamInfoAgeSummary(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	getInfo := func(t *testing.T, ages bool) *StreamInfo {
+		t.Helper()
+		req, err := json.Marshal(&JSApiStreamInfoRequest{AgeSummary: ages})
+		require_NoError(t, err)
+		resp, err := nc.Request(fmt.Sprintf(JSApiStreamInfoT, "TEST"), req, time.Second)
+		require_NoError(t, err)
+		var si StreamInfo
+		require_NoError(t, json.Unmarshal(resp.Data, &si))
+		return &si
+	}
+
+	testAges := func(t *testing.T, st nats.StorageType) {
+		_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: st})
+		require_NoError(t, err)
+		defer js.DeleteStream("TEST")
+
+		require_True(t, getInfo(t, true).State.Ages == nil)
+
+		for i := 0; i < 10; i++ {
+			_, err = js.Publish("foo", []byte("ok"))
+			require_NoError(t, err)
+		}
+
+		// Only reported when asked for.
+		require_True(t, getInfo(t, false).State.Ages == nil)
+
+		si := getInfo(t, true)
+		as := si.State.Ages
+		require_True(t, as != nil)
+		require_True(t, as.Oldest.Equal(si.State.FirstTime))
+		require_False(t, as.P90.Before(as.Oldest))
+		require_False(t, as.P50.Before(as.P90))
+		require_False(t, si.State.LastTime.Before(as.P50))
+	}
+
+	t.Run("MemoryStore", func(t *testing.T) { testAges(t, nats.MemoryStorage) })
+	t.Run("FileStore", func(t *testing.T) { testAges(t, nats.FileStorage) })
+}
+
+func TestJetStreamStreamUsageAPI(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	getUsage := func(t *testing.T, req *JSApiStreamUsageRequest) *JSApiStreamUsageResponse {
+		t.Helper()
+		b, err := json.Marshal(req)
+		require_NoError(t, err)
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamUsageT, "TEST"), b, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamUsageResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		return &resp
+	}
+
+	testUsage := func(t *testing.T, st nats.StorageType) {
+		_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"orders.>", "logs.>"}, Storage: st})
+		require_NoError(t, err)
+		defer js.DeleteStream("TEST")
+
+		for i := 0; i < 10; i++ {
+			_, err = js.Publish(fmt.Sprintf("orders.%d.new", i%2), []byte("ok"))
+			require_NoError(t, err)
+		}
+		_, err = js.Publish("logs.app", bytes.Repeat([]byte("Z"), 1024))
+		require_NoError(t, err)
+
+		si, err := js.StreamInfo("TEST")
+		require_NoError(t, err)
+
+		// Everything was just stored so all of it is in the youngest bucket.
+		resp := getUsage(t, &JSApiStreamUsageRequest{})
+		require_True(t, resp.Error == nil)
+		require_Len(t, len(resp.Ages), len(defaultStreamUsageAgeBuckets)+1)
+		require_True(t, resp.Ages[0].MaxAge == time.Minute)
+		require_True(t, resp.Ages[0].Msgs == uint64(11))
+		require_True(t, resp.Ages[0].Bytes == si.State.Bytes)
+
+		// Largest prefix first.
+		require_Len(t, len(resp.Prefixes), 2)
+		require_Equal(t, resp.Prefixes[0].Prefix, "logs")
+
+		require_True(t, resp.Prefixes[0].Msgs == uint64(1))
+		require_Equal(t, resp.Prefixes[1].Prefix, "orders")
+
+		require_True(t, resp.Prefixes[1].Subjects == 2)
+		require_True(t, resp.Prefixes[1].Msgs == uint64(10))
+		require_True(t, resp.Prefixes[0].Bytes+resp.Prefixes[1].Bytes == si.State.Bytes)
+
+		resp = getUsage(t, &JSApiStreamUsageRequest{PrefixDepth: 2})
+		require_Len(t, len(resp.Prefixes), 3)
+
+		// Buckets need to be ascending.
+		resp = getUsage(t, &JSApiStreamUsageRequest{AgeBuckets: []time.Duration{time.Hour, time.Minute}})
+		require_True(t, resp.Error != nil)
+	}
+
+	t.Run("MemoryStore", func(t *testing.T) { testUsage(t, nats.MemoryStorage) })
+	t.Run("FileStore", func(t *testing.T) { testUsage(t, nats.FileStorage) })
+}
+
 func TestJetStreamStreamInfoSubjectsDetailsWithDeleteAndPurge(t *testing.T) {
 	s := RunBasicJetStreamServer(t)
 	defer s.Shutdown()
@@ -16729,6 +17521,68 @@ func TestJetStreamWorkQueueSourceNamingRestart(t *testing.T) {
 	}
 }
 
+func TestJetStreamStreamRecoveryProgress(t *testing.T) {
+	storeDir := t.TempDir()
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: 127.0.0.1:-1
+		jetstream: {store_dir: %q}
+		accounts {
+			A { jetstream: enabled, users: [ {user: a, password: pwd} ] }
+			$SYS { users: [ {user: admin, password: s3cr3t!} ] }
+		}
+	`, storeDir)))
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	orig := recoveryProgressInterval
+	recoveryProgressInterval = 0
+	defer func() { recoveryProgressInterval = orig }()
+
+	ncSys := natsConnect(t, s.ClientURL(), nats.UserInfo("admin", "s3cr3t!"))
+	defer ncSys.Close()
+	sub := natsSubSync(t, ncSys, JSAdvisoryServerStreamRecovery)
+	natsFlush(t, ncSys)
+
+	nc, js := jsClientConnect(t, s, nats.UserInfo("a", "pwd"))
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+
+	// Creating the store recovers it as well, so we should have been told.
+	checkAdvisory := func(stream string, done bool) *JSStreamRecoveryAdvisory {
+		t.Helper()
+		msg := natsNexMsg(t, sub, time.Second)
+		var adv JSStreamRecoveryAdvisory
+		require_NoError(t, json.Unmarshal(msg.Data, &adv))
+		require_True(t, adv.Type == JSStreamRecoveryAdvisoryType)
+		require_True(t, adv.Account == "A")
+		require_True(t, adv.Stream == stream)
+		require_True(t, adv.Done == done)
+		return &adv
+	}
+	checkAdvisory("TEST", false)
+	adv := checkAdvisory("TEST", true)
+	require_True(t, adv.Percent == 100)
+
+	// Now pretend we are in the middle of recovering a stream that is not there yet.
+	require_NoError(t, os.MkdirAll(filepath.Join(storeDir, JetStreamStoreDir, "A", streamsDir, "SLOW"), defaultDirPerms))
+	h := s.streamRecoveryHandler("A", "SLOW")
+	h(RecoveryProgress{Blocks: 10, Recovered: 4, Percent: 40, ETA: time.Minute})
+	adv = checkAdvisory("SLOW", false)
+	require_True(t, adv.Recovered == 4 && adv.ETA == time.Minute)
+
+	hs := s.healthz(nil)
+	require_True(t, hs.Status != "ok")
+	require_True(t, len(hs.Recovering) == 1)
+	require_True(t, hs.Recovering[0].Stream == "SLOW")
+	require_True(t, hs.Recovering[0].Percent == 40)
+
+	h(RecoveryProgress{Blocks: 10, Recovered: 10, Percent: 100, Done: true})
+	hs = s.healthz(nil)
+	require_True(t, len(hs.Recovering) == 0)
+}
+
 func TestJetStreamDisabledHealthz(t *testing.T) {
 	s := RunBasicJetStreamServer(t)
 	defer s.Shutdown()
@@ -17157,6 +18011,74 @@ func TestJetStreamStreamRepublishHeadersOnly(t *testing.T) {
 	}
 }
 
+func TestJetStreamStreamRepublishHeaderFilterAndHeaders(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	// Check validation.
+	for _, rp := range []*RePublish{
+		{Destination: "RP.>", HeaderFilter: map[string]string{"": "x"}},
+		{Destination: "RP.>", Headers: map[string]string{"": "x"}},
+		{Destination: "RP.>", Headers: map[string]string{JSSequence: "1"}},
+		{Destination: "RP.>", Headers: map[string]string{"nats-stream": "X"}},
+	} {
+		req, _ := json.Marshal(&StreamConfig{Name: "BAD", Subjects: []string{"foo"}, Storage: MemoryStorage, RePublish: rp})
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, "BAD"), req, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+	}
+
+	addStream(t, nc, &StreamConfig{
+		Name:     "RPC",
+		Storage:  MemoryStorage,
+		Subjects: []string{"foo", "bar"},
+		RePublish: &RePublish{
+			Destination:  "RP.>",
+			HeaderFilter: map[string]string{"X-Region": "eu", "X-Tenant": _EMPTY_},
+			Headers:      map[string]string{"X-Enriched": "yes", "X-Region": "europe"},
+		},
+	})
+
+	sub := natsSubSync(t, nc, "RP.>")
+
+	publish := func(subj string, hdrs map[string]string) {
+		t.Helper()
+		m := nats.NewMsg(subj)
+		for k, v := range hdrs {
+			m.Header.Set(k, v)
+		}
+		m.Data = []byte("ok")
+		_, err := js.PublishMsg(m)
+		require_NoError(t, err)
+	}
+	publish("foo", nil)
+	publish("foo", map[string]string{"X-Region": "eu"})
+	publish("bar", map[string]string{"X-Region": "us", "X-Tenant": "acme"})
+	publish("bar", map[string]string{"X-Region": "eu", "X-Tenant": "acme"})
+
+	// Only the last one matches the filter.
+	m := natsNexMsg(t, sub, time.Second)
+	require_Equal(t, m.Subject, "RP.bar")
+	require_Equal(t, m.Header.Get(JSSequence), "4")
+	require_Equal(t, m.Header.Get("X-Tenant"), "acme")
+	require_Equal(t, m.Header.Get("X-Enriched"), "yes")
+	require_Equal(t, m.Header.Get("X-Region"), "europe")
+	require_Equal(t, string(m.Data), "ok")
+	_, err := sub.NextMsg(100 * time.Millisecond)
+	require_Error(t, err)
+
+	// The stored message is not changed.
+	rsm, err := js.GetMsg("RPC", 4)
+	require_NoError(t, err)
+	require_Equal(t, rsm.Header.Get("X-Region"), "eu")
+	require_Equal(t, rsm.Header.Get("X-Enriched"), _EMPTY_)
+}
+
 func TestJetStreamConsumerDeliverNewNotConsumingBeforeRestart(t *testing.T) {
 	s := RunBasicJetStreamServer(t)
 	defer s.Shutdown()
@@ -19425,3 +20347,3670 @@ func TestJetStreamConsumerFilterUpdate(t *testing.T) {
 	// and expect that numFilter reports correctly.
 	checkNumFilter(0)
 }
+
+func TestJetStreamConsumerAdvisoryRateLimit(t *testing.T) {
+	opts := DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	opts.JetStreamLimits.MaxAdvisories = 2
+	opts.JetStreamLimits.AdvisoryWindow = 250 * time.Millisecond
+	s := RunServer(&opts)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+
+	_, err = js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy})
+	require_NoError(t, err)
+
+	nakSub, err := nc.SubscribeSync(JSAdvisoryConsumerMsgNakPre + ".TEST.dlc")
+	require_NoError(t, err)
+	supSub, err := nc.SubscribeSync(JSAdvisoryConsumerSuppressedPre + ".TEST.dlc")
+	require_NoError(t, err)
+	require_NoError(t, nc.Flush())
+
+	for i := 0; i < 10; i++ {
+		sendStreamMsg(t, nc, "foo", "OK")
+	}
+
+	sub, err := js.PullSubscribe("foo", "dlc")
+	require_NoError(t, err)
+	msgs, err := sub.Fetch(10, nats.MaxWait(2*time.Second))
+	require_NoError(t, err)
+	require_Len(t, len(msgs), 10)
+	for _, m := range msgs {
+		require_NoError(t, m.Nak())
+	}
+
+	// Only the first two advisories should make it through.
+	checkSubsPending(t, nakSub, 2)
+
+	m, err := supSub.NextMsg(2 * time.Second)
+	require_NoError(t, err)
+	var adv JSConsumerAdvisoriesSuppressedAdvisory
+	require_NoError(t, json.Unmarshal(m.Data, &adv))
+	require_True(t, adv.Type == JSConsumerAdvisoriesSuppressedAdvisoryType)
+	require_True(t, adv.Subject == JSAdvisoryConsumerMsgNakPre+".TEST.dlc")
+	require_True(t, adv.Suppressed == 8)
+	require_True(t, adv.Window == 250*time.Millisecond)
+
+	// Make sure the nak advisory count did not change.
+	n, _, _ := nakSub.Pending()
+	require_True(t, n == 2)
+}
+
+func TestJetStreamStreamExpiryBatchDefault(t *testing.T) {
+	opts := DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	opts.JetStreamLimits.ExpiryBatch = 250 * time.Millisecond
+	s := RunServer(&opts)
+	defer s.Shutdown()
+
+	acc := s.GlobalAccount()
+
+	// Server default applies only to streams with a max age.
+	cfg, apiErr := s.checkStreamCfg(&StreamConfig{Name: "A", MaxAge: time.Second}, acc)
+	require_True(t, apiErr == nil)
+	require_True(t, cfg.ExpiryBatch == 250*time.Millisecond)
+
+	cfg, apiErr = s.checkStreamCfg(&StreamConfig{Name: "B"}, acc)
+	require_True(t, apiErr == nil)
+	require_True(t, cfg.ExpiryBatch == 0)
+
+	// Per stream override.
+	cfg, apiErr = s.checkStreamCfg(&StreamConfig{Name: "C", MaxAge: time.Second, ExpiryBatch: time.Second}, acc)
+	require_True(t, apiErr == nil)
+	require_True(t, cfg.ExpiryBatch == time.Second)
+
+	_, apiErr = s.checkStreamCfg(&StreamConfig{Name: "D", ExpiryBatch: -1}, acc)
+	require_True(t, apiErr != nil)
+}
+
+func TestJetStreamMemoryStreamWALRestart(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	cfg := &StreamConfig{
+		Name:      "TEST",
+		Subjects:  []string{"foo"},
+		Storage:   MemoryStorage,
+		MemoryWAL: &MemoryWAL{FlushInterval: 10 * time.Millisecond},
+	}
+	_, err := s.GlobalAccount().addStream(cfg)
+	require_NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		sendStreamMsg(t, nc, "foo", "OK")
+	}
+	require_NoError(t, js.DeleteMsg("TEST", 2))
+
+	// File based streams can not have a WAL.
+	_, err = s.GlobalAccount().addStream(&StreamConfig{Name: "FS", Storage: FileStorage, MemoryWAL: &MemoryWAL{}})
+	require_Error(t, err)
+
+	// Give the flusher a chance to write out.
+	time.Sleep(100 * time.Millisecond)
+
+	sd := s.JetStreamConfig().StoreDir
+	nc.Close()
+	s.Shutdown()
+
+	s = RunJetStreamServerOnPort(-1, sd)
+	defer s.Shutdown()
+
+	nc, js = jsClientConnect(t, s)
+	defer nc.Close()
+
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.Config.Storage == nats.MemoryStorage)
+	require_True(t, si.State.Msgs == 19)
+	require_True(t, si.State.LastSeq == 20)
+
+	// Deleting the stream should not bring it back on restart.
+	require_NoError(t, js.DeleteStream("TEST"))
+	nc.Close()
+	s.Shutdown()
+
+	s = RunJetStreamServerOnPort(-1, sd)
+	defer s.Shutdown()
+	_, err = s.GlobalAccount().lookupStream("TEST")
+	require_Error(t, err)
+}
+
+func TestJetStreamMemoryStreamDedupeRestart(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	cfg := &StreamConfig{
+		Name:       "TEST",
+		Subjects:   []string{"foo"},
+		Storage:    MemoryStorage,
+		Duplicates: time.Minute,
+	}
+	mset, err := s.GlobalAccount().addStream(cfg)
+	require_NoError(t, err)
+
+	for _, id := range []string{"A", "B", "C"} {
+		_, err := js.Publish("foo", []byte("OK"), nats.MsgId(id))
+		require_NoError(t, err)
+	}
+
+	// Stopping the stream will write out the window, as will a restart of the server.
+	dfile := filepath.Join(s.JetStreamConfig().StoreDir, globalAccountName, streamsDir, "TEST", dedupeStateFile)
+	require_NoError(t, mset.stop(false, false))
+	_, err = os.Stat(dfile)
+	require_NoError(t, err)
+
+	// Recreate the stream, this is what happens for R1 memory streams after a restart.
+	// The messages are gone but the window should still be in place.
+	_, err = s.GlobalAccount().addStream(cfg)
+	require_NoError(t, err)
+
+	pa, err := js.Publish("foo", []byte("OK"), nats.MsgId("B"))
+	require_NoError(t, err)
+	require_True(t, pa.Duplicate)
+	require_True(t, pa.Sequence == 2)
+
+	pa, err = js.Publish("foo", []byte("OK"), nats.MsgId("D"))
+	require_NoError(t, err)
+	require_False(t, pa.Duplicate)
+
+	// New ids are written out in the background.
+	checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+		b, err := os.ReadFile(dfile)
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(b, []byte(`"D"`)) {
+			return fmt.Errorf("dedupe state not updated: %s", b)
+		}
+		return nil
+	})
+
+	// Deleting the stream removes the state.
+	require_NoError(t, js.DeleteStream("TEST"))
+	_, err = os.Stat(dfile)
+	require_True(t, os.IsNotExist(err))
+
+	_, err = s.GlobalAccount().addStream(cfg)
+	require_NoError(t, err)
+	pa, err = js.Publish("foo", []byte("OK"), nats.MsgId("B"))
+	require_NoError(t, err)
+	require_False(t, pa.Duplicate)
+}
+
+func TestJetStreamMsgDeleteTombstone(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	mset, err := s.GlobalAccount().addStream(&StreamConfig{Name: "TEST", Subjects: []string{"foo.*"}, Storage: FileStorage})
+	require_NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		sendStreamMsg(t, nc, fmt.Sprintf("foo.%d", i), "OK")
+	}
+
+	deleteMsg := func(req *JSApiMsgDeleteRequest) *JSApiMsgDeleteResponse {
+		t.Helper()
+		b, _ := json.Marshal(req)
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiMsgDeleteT, "TEST"), b, time.Second)
+		require_NoError(t, err)
+		var resp JSApiMsgDeleteResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		return &resp
+	}
+	resp := deleteMsg(&JSApiMsgDeleteRequest{Seq: 2, Tombstone: true, Reason: "gdpr"})
+	require_True(t, resp.Success)
+	// Not found should not leave a tombstone.
+	resp = deleteMsg(&JSApiMsgDeleteRequest{Seq: 22, Tombstone: true})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSSequenceNotFoundErrF))
+
+	state := mset.state()
+	require_True(t, state.Msgs == 3)
+	require_True(t, state.LastSeq == 4)
+
+	// The tombstone is stored on the subject of the removed message.
+	sm, err := mset.getMsg(4)
+	require_NoError(t, err)
+	require_True(t, sm.Subject == "foo.2")
+	require_True(t, len(sm.Data) == 0)
+	require_True(t, string(getHeader(JSTombstoneSequence, sm.Header)) == "2")
+	require_True(t, string(getHeader(JSTombstoneReason, sm.Header)) == "gdpr")
+
+	fetch := func(durable string, expected int) []*nats.Msg {
+		t.Helper()
+		sub, err := js.PullSubscribe(_EMPTY_, durable, nats.Bind("TEST", durable))
+		require_NoError(t, err)
+		defer sub.Unsubscribe()
+		msgs, err := sub.Fetch(10, nats.MaxWait(250*time.Millisecond))
+		if err != nil && err != nats.ErrTimeout {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		require_True(t, len(msgs) == expected)
+		return msgs
+	}
+
+	// Tombstones are skipped by default.
+	_, err = mset.addConsumer(&ConsumerConfig{Durable: "A", AckPolicy: AckExplicit})
+	require_NoError(t, err)
+	for i, m := range fetch("A", 2) {
+		require_True(t, m.Header.Get(JSTombstoneSequence) == _EMPTY_)
+		require_True(t, m.Subject == fmt.Sprintf("foo.%d", 2*i+1))
+	}
+
+	_, err = mset.addConsumer(&ConsumerConfig{Durable: "B", AckPolicy: AckExplicit, DeliverTombstones: true})
+	require_NoError(t, err)
+	msgs := fetch("B", 3)
+	require_True(t, msgs[2].Subject == "foo.2")
+	require_True(t, msgs[2].Header.Get(JSTombstoneSequence) == "2")
+	require_True(t, msgs[2].Header.Get(JSTombstoneReason) == "gdpr")
+}
+
+func TestJetStreamAPIAudit(t *testing.T) {
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: 127.0.0.1:-1
+		jetstream: {store_dir: %q, api_audit: {max_msgs: 100}}
+	`, t.TempDir())))
+	s, opts := RunServerWithConfig(conf)
+	defer s.Shutdown()
+	require_True(t, opts.JetStreamAPIAudit.Enabled)
+
+	// Verbs and assets come from the subjects of the API handlers, so any new API is covered.
+	sjs := s.getJetStream()
+	for _, test := range []struct {
+		subject, verb, asset string
+	}{
+		{JSApiAccountInfo, "INFO", _EMPTY_},
+		{fmt.Sprintf(JSApiStreamCreateT, "ORDERS"), "STREAM.CREATE", "ORDERS"},
+		{fmt.Sprintf(JSApiConsumerCreateExT, "ORDERS", "C", "orders.new"), "CONSUMER.CREATE", "ORDERS"},
+		{fmt.Sprintf(JSApiDurableCreateT, "ORDERS", "D"), "CONSUMER.DURABLE.CREATE", "ORDERS > D"},
+		{fmt.Sprintf(JSApiConsumerInfoT, "ORDERS", "D"), "CONSUMER.INFO", "ORDERS > D"},
+		{fmt.Sprintf(JSApiStreamBulkT, "ORDERS"), "STREAM.BULK", "ORDERS"},
+		{fmt.Sprintf(JSApiStreamGenerateT, "ORDERS"), "STREAM.GENERATE", "ORDERS"},
+	} {
+		verb, asset := sjs.apiAuditVerbAndAsset(test.subject)
+		require_Equal(t, verb, test.verb)
+		require_Equal(t, asset, test.asset)
+	}
+	require_True(t, opts.JetStreamAPIAudit.MaxMsgs == 100)
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	_, err = js.StreamInfo("MISSING")
+	require_Error(t, err)
+
+	sub, err := js.SubscribeSync(JSAPIAuditSubjPre+".>", nats.BindStream(JSAPIAuditStream))
+	require_NoError(t, err)
+	defer sub.Unsubscribe()
+
+	var recs []JSAPIAuditRecord
+	for len(recs) < 2 {
+		m, err := sub.NextMsg(2 * time.Second)
+		require_NoError(t, err)
+		var rec JSAPIAuditRecord
+		require_NoError(t, json.Unmarshal(m.Data, &rec))
+		require_True(t, m.Subject == JSAPIAuditSubjPre+"."+rec.Verb)
+		recs = append(recs, rec)
+	}
+	require_True(t, recs[0].Verb == "STREAM.CREATE")
+	require_True(t, recs[0].Asset == "TEST")
+	require_True(t, recs[0].Code == 200)
+	require_True(t, recs[0].Latency > 0)
+	require_True(t, recs[0].Client != nil)
+	require_True(t, recs[1].Verb == "STREAM.INFO")
+	require_True(t, recs[1].Asset == "MISSING")
+	require_True(t, recs[1].Code == 404)
+	require_True(t, recs[1].ErrCode == uint16(JSStreamNotFoundErr))
+
+	si, err := js.StreamInfo(JSAPIAuditStream)
+	require_NoError(t, err)
+	require_True(t, si.Config.MaxMsgs == 100)
+	require_True(t, si.Config.MaxAge == JSAPIAuditDefaultMaxAge)
+}
+
+func TestJetStreamStreamOverflow(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	acc := s.GlobalAccount()
+
+	// Config checks.
+	_, err := acc.addStream(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, Overflow: &StreamOverflow{Stream: "BIG"}})
+	require_Error(t, err)
+	_, err = acc.addStream(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, MaxMsgSize: 100, Overflow: &StreamOverflow{Stream: "BIG"}})
+	require_Error(t, err)
+	_, err = acc.addStream(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, MaxMsgSize: 1024, Overflow: &StreamOverflow{}})
+	require_Error(t, err)
+	_, err = acc.addStream(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, MaxMsgSize: 1024, Overflow: &StreamOverflow{Stream: "BAD"}})
+	require_Error(t, err)
+
+	_, err = js.AddStream(&nats.StreamConfig{Name: "BIG", Subjects: []string{"big"}})
+	require_NoError(t, err)
+	obs, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "LARGE"})
+	require_NoError(t, err)
+
+	_, err = acc.addStream(&StreamConfig{Name: "S", Subjects: []string{"s"}, MaxMsgSize: 1024, Overflow: &StreamOverflow{Stream: "BIG"}})
+	require_NoError(t, err)
+	_, err = acc.addStream(&StreamConfig{Name: "O", Subjects: []string{"o"}, MaxMsgSize: 1024, Overflow: &StreamOverflow{ObjectStore: "LARGE"}})
+	require_NoError(t, err)
+
+	payload := bytes.Repeat([]byte("Z"), 200*1024)
+
+	// Small messages are stored as is.
+	_, err = js.Publish("s", []byte("small"))
+	require_NoError(t, err)
+
+	// Diverted to the overflow stream.
+	m := nats.NewMsg("s")
+	m.Header.Set("X-Test", "1")
+	m.Header.Set(JSMsgId, "big-1")
+	m.Data = payload
+	pa, err := js.PublishMsg(m)
+	require_NoError(t, err)
+	require_True(t, pa.Sequence == 2)
+
+	rm, err := js.GetMsg("S", 2)
+	require_NoError(t, err)
+	require_True(t, len(rm.Data) == 0)
+	require_True(t, rm.Header.Get(JSOverflowStream) == "BIG")
+	require_True(t, rm.Header.Get(JSOverflowSize) != _EMPTY_)
+	require_True(t, rm.Header.Get(JSMsgId) == "big-1")
+	oseq, err := strconv.ParseUint(rm.Header.Get(JSOverflowSequence), 10, 64)
+	require_NoError(t, err)
+
+	rm, err = js.GetMsg("BIG", oseq)
+	require_NoError(t, err)
+	require_True(t, rm.Subject == "s")
+	require_True(t, rm.Header.Get("X-Test") == "1")
+	require_True(t, bytes.Equal(rm.Data, payload))
+
+	// Dedupe still applies to the pointer.
+	pa, err = js.PublishMsg(m)
+	require_NoError(t, err)
+	require_True(t, pa.Duplicate)
+
+	// Diverted to the object store.
+	m = nats.NewMsg("o")
+	m.Header.Set("X-Test", "2")
+	m.Data = payload
+	_, err = js.PublishMsg(m)
+	require_NoError(t, err)
+
+	rm, err = js.GetMsg("O", 1)
+	require_NoError(t, err)
+	require_True(t, rm.Header.Get(JSOverflowBucket) == "LARGE")
+	key := rm.Header.Get(JSOverflowKey)
+
+	data, err := obs.GetBytes(key)
+	require_NoError(t, err)
+	require_True(t, bytes.Equal(data, payload))
+	info, err := obs.GetInfo(key)
+	require_NoError(t, err)
+	require_True(t, info.Headers.Get("X-Test") == "2")
+
+	// Without a valid target the message is rejected as before.
+	_, err = acc.addStream(&StreamConfig{Name: "M", Subjects: []string{"m"}, MaxMsgSize: 1024, Overflow: &StreamOverflow{Stream: "MISSING"}})
+	require_NoError(t, err)
+	_, err = js.Publish("m", payload)
+	require_Error(t, err)
+}
+
+func TestJetStreamStreamCompactAsyncAPI(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	_, err = js.AddStream(&nats.StreamConfig{Name: "MEM", Subjects: []string{"bar"}, Storage: nats.MemoryStorage})
+	require_NoError(t, err)
+
+	_, err = js.Subscribe("foo", func(_ *nats.Msg) {}, nats.Durable("dlc"), nats.ManualAck())
+	require_NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		sendStreamMsg(t, nc, "foo", "Hello World")
+	}
+
+	compact := func(stream string, req *JSApiStreamCompactRequest) *JSApiStreamCompactResponse {
+		t.Helper()
+		var b []byte
+		if req != nil {
+			b, _ = json.Marshal(req)
+		}
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamCompactT, stream), b, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamCompactResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		return &resp
+	}
+
+	// Nothing to report yet.
+	resp := compact("TEST", nil)
+	require_True(t, resp.Error != nil)
+
+	resp = compact("TEST", &JSApiStreamCompactRequest{Sequence: 50})
+	require_True(t, resp.Error == nil)
+	require_True(t, resp.Progress != nil)
+	require_True(t, resp.Progress.Seq == 50)
+
+	checkFor(t, 2*time.Second, 20*time.Millisecond, func() error {
+		resp := compact("TEST", nil)
+		if resp.Error != nil {
+			return fmt.Errorf("Unexpected error: %v", resp.Error)
+		}
+		if !resp.Progress.Done {
+			return fmt.Errorf("Compaction not done")
+		}
+		return nil
+	})
+
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.State.FirstSeq == 50)
+
+	// Consumer should have been moved forward.
+	checkFor(t, time.Second, 20*time.Millisecond, func() error {
+		ci, err := js.ConsumerInfo("TEST", "dlc")
+		if err != nil {
+			return err
+		}
+		if ci.AckFloor.Stream < 49 {
+			return fmt.Errorf("Ack floor not updated: %d", ci.AckFloor.Stream)
+		}
+		return nil
+	})
+
+	// Memory streams are not supported.
+	resp = compact("MEM", &JSApiStreamCompactRequest{Sequence: 1})
+	require_True(t, resp.Error != nil)
+	require_True(t, resp.Error.ErrCode == uint16(JSStreamCompactNotSupportedErr))
+}
+
+func TestJetStreamStreamPayloadOffload(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	acc := s.GlobalAccount()
+
+	// Config checks.
+	_, err := acc.addStream(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, Offload: &StreamOffload{Threshold: 1024}})
+	require_Error(t, err)
+	_, err = acc.addStream(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, Offload: &StreamOffload{ObjectStore: "BLOBS"}})
+	require_Error(t, err)
+
+	obs, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "BLOBS"})
+	require_NoError(t, err)
+
+	_, err = acc.addStream(&StreamConfig{
+		Name:        "TEST",
+		Subjects:    []string{"foo"},
+		Storage:     FileStorage,
+		AllowDirect: true,
+		Offload:     &StreamOffload{ObjectStore: "BLOBS", Threshold: 1024},
+	})
+	require_NoError(t, err)
+
+	payload := bytes.Repeat([]byte("Z"), 300*1024)
+	m := nats.NewMsg("foo")
+	m.Header.Set("X-Name", "big")
+	m.Data = payload
+	_, err = js.PublishMsg(m)
+	require_NoError(t, err)
+	// Small ones are stored as is.
+	_, err = js.Publish("foo", []byte("small"))
+	require_NoError(t, err)
+
+	// Stored as a pointer.
+	rsm, err := js.GetMsg("TEST", 1)
+	require_NoError(t, err)
+	require_True(t, len(rsm.Data) == 0)
+	require_True(t, rsm.Header.Get("X-Name") == "big")
+	require_True(t, rsm.Header.Get(JSOffloadBucket) == "BLOBS")
+	require_True(t, rsm.Header.Get(JSOffloadSize) == strconv.Itoa(len(payload)))
+
+	// Available from the object store.
+	data, err := obs.GetBytes(rsm.Header.Get(JSOffloadKey))
+	require_NoError(t, err)
+	require_True(t, bytes.Equal(data, payload))
+
+	// Direct gets put the payload back.
+	rsm, err = js.GetMsg("TEST", 1, nats.DirectGet())
+	require_NoError(t, err)
+	require_True(t, bytes.Equal(rsm.Data, payload))
+	require_True(t, rsm.Header.Get("X-Name") == "big")
+	require_True(t, rsm.Header.Get(JSOffloadBucket) == _EMPTY_)
+
+	// So do consumers.
+	sub, err := js.SubscribeSync("foo")
+	require_NoError(t, err)
+	msg, err := sub.NextMsg(time.Second)
+	require_NoError(t, err)
+	require_True(t, bytes.Equal(msg.Data, payload))
+	require_True(t, msg.Header.Get("X-Name") == "big")
+	require_True(t, msg.Header.Get(JSOffloadKey) == _EMPTY_)
+	msg, err = sub.NextMsg(time.Second)
+	require_NoError(t, err)
+	require_True(t, string(msg.Data) == "small")
+}
+
+func TestJetStreamStreamTrash(t *testing.T) {
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: 127.0.0.1:-1
+		jetstream: {max_mem_store: 64MB, max_file_store: 64MB, store_dir: %q}
+		accounts: {
+			A: {
+				jetstream: {trash_retention: "1h"}
+				users: [ {user: ua, password: pwd} ]
+			},
+			B: {
+				jetstream: {trash_retention: "250ms", max_streams: 10}
+				users: [ {user: ub, password: pwd} ]
+			},
+		}
+	`, t.TempDir())))
+
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s, nats.UserInfo("ua", "pwd"))
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+	sub, err := js.PullSubscribe("foo", "dlc")
+	require_NoError(t, err)
+	msgs, err := sub.Fetch(5)
+	require_NoError(t, err)
+	for _, m := range msgs {
+		m.AckSync()
+	}
+
+	trash := func() []*TrashedStream {
+		t.Helper()
+		resp, err := nc.Request(JSApiStreamTrash, nil, time.Second)
+		require_NoError(t, err)
+		var tresp JSApiStreamTrashResponse
+		require_NoError(t, json.Unmarshal(resp.Data, &tresp))
+		require_True(t, tresp.Error == nil)
+		return tresp.Streams
+	}
+	undelete := func(name string) *JSApiStreamUndeleteResponse {
+		t.Helper()
+		resp, err := nc.Request(fmt.Sprintf(JSApiStreamUndeleteT, name), nil, time.Second)
+		require_NoError(t, err)
+		var uresp JSApiStreamUndeleteResponse
+		require_NoError(t, json.Unmarshal(resp.Data, &uresp))
+		return &uresp
+	}
+
+	require_True(t, len(trash()) == 0)
+	require_NoError(t, js.DeleteStream("TEST"))
+	_, err = js.StreamInfo("TEST")
+	require_Error(t, err, nats.ErrStreamNotFound)
+
+	ts := trash()
+	require_True(t, len(ts) == 1)
+	require_True(t, ts[0].Name == "TEST")
+	require_True(t, ts[0].Expires.Sub(ts[0].Deleted) == time.Hour)
+
+	// Restored with its messages and consumer state.
+	uresp := undelete("TEST")
+	require_True(t, uresp.Error == nil)
+	require_True(t, uresp.State.Msgs == 10)
+	require_True(t, len(trash()) == 0)
+	ci, err := js.ConsumerInfo("TEST", "dlc")
+	require_NoError(t, err)
+	require_True(t, ci.AckFloor.Stream == 5)
+	require_True(t, ci.NumPending == 5)
+
+	// Can not restore over an existing stream.
+	require_NoError(t, js.DeleteStream("TEST"))
+	_, err = js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"bar"}})
+	require_NoError(t, err)
+	uresp = undelete("TEST")
+	require_True(t, uresp.Error != nil)
+	require_True(t, uresp.Error.ErrCode == uint16(JSStreamNameExistErr))
+
+	// Restores the most recent one.
+	require_NoError(t, js.DeleteStream("TEST"))
+	require_True(t, len(trash()) == 2)
+	uresp = undelete("TEST")
+	require_True(t, uresp.Error == nil)
+	require_True(t, uresp.Config.Subjects[0] == "bar")
+	require_True(t, len(trash()) == 1)
+
+	uresp = undelete("NOPE")
+	require_True(t, uresp.Error != nil)
+	require_True(t, uresp.Error.ErrCode == uint16(JSStreamNotFoundErr))
+
+	// Streams in the trash are purged once the retention expires.
+	ncb, jsb := jsClientConnect(t, s, nats.UserInfo("ub", "pwd"))
+	defer ncb.Close()
+
+	_, err = jsb.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	require_NoError(t, jsb.DeleteStream("TEST"))
+
+	acc, err := s.LookupAccount("B")
+	require_NoError(t, err)
+	_, jsa, err := acc.checkForJetStream()
+	require_NoError(t, err)
+	require_True(t, len(jsa.trashedStreams()) == 1)
+	checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+		if _, err := os.Stat(filepath.Join(jsa.storeDir, trashDir)); !os.IsNotExist(err) {
+			return fmt.Errorf("trash still present")
+		}
+		return nil
+	})
+
+	// Memory streams have no storage to keep in the trash.
+	_, err = jsb.AddStream(&nats.StreamConfig{Name: "MEM", Subjects: []string{"mem"}, Storage: nats.MemoryStorage})
+	require_Error(t, err)
+	require_Contains(t, err.Error(), "trash retention")
+}
+
+func TestJetStreamStreamTrashNotClustered(t *testing.T) {
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: 127.0.0.1:-1
+		server_name: S1
+		jetstream: {store_dir: %q}
+		cluster: {name: C, listen: 127.0.0.1:-1}
+		accounts: {
+			A: {
+				jetstream: {trash_retention: "1h"}
+				users: [ {user: ua, password: pwd} ]
+			},
+		}
+	`, t.TempDir())))
+	opts, err := ProcessConfigFile(conf)
+	require_NoError(t, err)
+	_, err = NewServer(opts)
+	require_Error(t, err)
+	require_Contains(t, err.Error(), "not supported in clustered mode")
+}
+
+func TestJetStreamStreamDeletionProtection(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: FileStorage, DeletionProtection: true})
+	for i := 0; i < 10; i++ {
+		_, err := js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+
+	request := func(subj string) *ApiResponse {
+		t.Helper()
+		resp, err := nc.Request(fmt.Sprintf(subj, "TEST"), nil, time.Second)
+		require_NoError(t, err)
+		var apiResp ApiResponse
+		require_NoError(t, json.Unmarshal(resp.Data, &apiResp))
+		return &apiResp
+	}
+	unlock := func() {
+		t.Helper()
+		resp, err := nc.Request(fmt.Sprintf(JSApiStreamUnlockT, "TEST"), nil, time.Second)
+		require_NoError(t, err)
+		var uresp JSApiStreamUnlockResponse
+		require_NoError(t, json.Unmarshal(resp.Data, &uresp))
+		require_True(t, uresp.Error == nil)
+		require_True(t, uresp.Expires.After(time.Now()))
+	}
+	requireProtected := func(resp *ApiResponse) {
+		t.Helper()
+		require_True(t, resp.Error != nil)
+		require_True(t, resp.Error.ErrCode == uint16(JSStreamDeletionProtectedErr))
+	}
+
+	requireProtected(request(JSApiStreamDeleteT))
+	requireProtected(request(JSApiStreamPurgeT))
+
+	// An unlock is good for one delete or purge.
+	unlock()
+	require_True(t, request(JSApiStreamPurgeT).Error == nil)
+	requireProtected(request(JSApiStreamPurgeT))
+	requireProtected(request(JSApiStreamDeleteT))
+
+	// Protection can not be removed.
+	req, err := json.Marshal(&StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: FileStorage})
+	require_NoError(t, err)
+	resp, err := nc.Request(fmt.Sprintf(JSApiStreamUpdateT, "TEST"), req, time.Second)
+	require_NoError(t, err)
+	var uresp JSApiStreamUpdateResponse
+	require_NoError(t, json.Unmarshal(resp.Data, &uresp))
+	require_True(t, uresp.Error != nil)
+	require_Contains(t, uresp.Error.Description, "can not cancel deletion protection")
+
+	unlock()
+	require_True(t, request(JSApiStreamDeleteT).Error == nil)
+	_, err = js.StreamInfo("TEST")
+	require_Error(t, err, nats.ErrStreamNotFound)
+
+	// Unlocking needs an existing stream.
+	resp, err = nc.Request(fmt.Sprintf(JSApiStreamUnlockT, "TEST"), nil, time.Second)
+	require_NoError(t, err)
+	var unresp JSApiStreamUnlockResponse
+	require_NoError(t, json.Unmarshal(resp.Data, &unresp))
+	require_True(t, unresp.Error != nil)
+	require_True(t, unresp.Error.ErrCode == uint16(JSStreamNotFoundErr))
+}
+
+func TestJetStreamStreamStorageMigration(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	cfg := &StreamConfig{Name: "TEST", Subjects: []string{"foo.*"}, Storage: MemoryStorage}
+	addStream(t, nc, cfg)
+	for i := 0; i < 100; i++ {
+		_, err := js.Publish(fmt.Sprintf("foo.%d", i%5), []byte("ok"))
+		require_NoError(t, err)
+	}
+	// Leave a hole at the front and some interior deletes.
+	require_NoError(t, js.PurgeStream("TEST", &nats.StreamPurgeRequest{Sequence: 11}))
+	require_NoError(t, js.DeleteMsg("TEST", 22))
+	require_NoError(t, js.DeleteMsg("TEST", 50))
+
+	_, err := js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy})
+	require_NoError(t, err)
+	sub, err := js.PullSubscribe("foo.*", "dlc")
+	require_NoError(t, err)
+	msgs, err := sub.Fetch(10)
+	require_NoError(t, err)
+	for _, m := range msgs {
+		require_NoError(t, m.AckSync())
+	}
+
+	checkState := func(storage nats.StorageType) {
+		t.Helper()
+		si, err := js.StreamInfo("TEST")
+		require_NoError(t, err)
+		require_True(t, si.Config.Storage == storage)
+		require_True(t, si.State.Msgs == 88)
+		require_True(t, si.State.FirstSeq == 11)
+		require_True(t, si.State.LastSeq == 100)
+		require_True(t, si.State.NumDeleted == 2)
+		_, err = js.GetMsg("TEST", 22)
+		require_Error(t, err, nats.ErrMsgNotFound)
+		m, err := js.GetMsg("TEST", 23)
+		require_NoError(t, err)
+		require_True(t, m.Subject == "foo.2")
+
+		ci, err := js.ConsumerInfo("TEST", "dlc")
+		require_NoError(t, err)
+		require_True(t, ci.AckFloor.Stream == 20)
+		require_True(t, ci.NumPending == 78)
+
+		ji, err := js.AccountInfo()
+		require_NoError(t, err)
+		if storage == nats.FileStorage {
+			require_True(t, ji.Store > 0 && ji.Memory == 0)
+		} else {
+			require_True(t, ji.Memory > 0 && ji.Store == 0)
+		}
+	}
+	checkState(nats.MemoryStorage)
+
+	cfg.Storage = FileStorage
+	updateStream(t, nc, cfg)
+	checkState(nats.FileStorage)
+
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	sdir := filepath.Join(s.JetStreamConfig().StoreDir, globalAccountName, streamsDir, "TEST")
+	_, err = os.Stat(filepath.Join(sdir, msgDir))
+	require_NoError(t, err)
+
+	// A file backed stream should survive a restart.
+	sd := s.JetStreamConfig().StoreDir
+	nc.Close()
+	s.Shutdown()
+	s = RunJetStreamServerOnPort(-1, sd)
+	defer s.Shutdown()
+	nc, js = jsClientConnect(t, s)
+	defer nc.Close()
+	checkState(nats.FileStorage)
+
+	// And back again.
+	cfg.Storage = MemoryStorage
+	updateStream(t, nc, cfg)
+	checkState(nats.MemoryStorage)
+	_, err = os.Stat(filepath.Join(sdir, msgDir))
+	require_True(t, os.IsNotExist(err))
+
+	// New messages go into the new store.
+	_, err = js.Publish("foo.1", []byte("ok"))
+	require_NoError(t, err)
+	mset, err = s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	require_True(t, mset.state().LastSeq == 101)
+	_, ok := mset.store.(*memStore)
+	require_True(t, ok)
+}
+
+func TestJetStreamStreamStorageMigrationWhilePublishing(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	cfg := &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: MemoryStorage}
+	addStream(t, nc, cfg)
+	for i := 0; i < 20_000; i++ {
+		_, err := js.PublishAsync("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive completion signal")
+	}
+
+	// Keep publishing while we migrate, none of these should be lost.
+	done := make(chan int)
+	go func() {
+		var n int
+		for ; n < 500; n++ {
+			if _, err := js.Publish("foo", []byte("ok")); err != nil {
+				break
+			}
+		}
+		done <- n
+	}()
+	cfg.Storage = FileStorage
+	updateStream(t, nc, cfg)
+	n := <-done
+	require_True(t, n == 500)
+
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.Config.Storage == nats.FileStorage)
+	require_True(t, si.State.Msgs == 20_500)
+	require_True(t, si.State.LastSeq == 20_500)
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	require_True(t, mset.state().Msgs == 20_500)
+}
+
+func TestJetStreamStreamStorageMigrationWhileDeleting(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	cfg := &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: MemoryStorage}
+	addStream(t, nc, cfg)
+	const total = 50_000
+	for i := 0; i < total; i++ {
+		_, err := js.PublishAsync("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive completion signal")
+	}
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+
+	// Keep deleting while we migrate, none of these should come back.
+	stop := make(chan struct{})
+	done := make(chan []uint64)
+	go func() {
+		var deleted []uint64
+		for seq := uint64(2); seq <= total; seq += 2 {
+			select {
+			case <-stop:
+				done <- deleted
+				return
+			default:
+			}
+			if removed, _ := mset.removeMsg(seq); removed {
+				deleted = append(deleted, seq)
+			}
+			// Spread the deletes over the whole migration.
+			time.Sleep(50 * time.Microsecond)
+		}
+		done <- deleted
+	}()
+	cfg.Storage = FileStorage
+	updateStream(t, nc, cfg)
+	close(stop)
+	deleted := <-done
+	require_True(t, len(deleted) > 0)
+
+	mset, err = s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	require_True(t, mset.state().Msgs == uint64(total-len(deleted)))
+	var smv StoreMsg
+	for _, seq := range deleted {
+		if _, err := mset.store.LoadMsg(seq, &smv); err == nil {
+			t.Fatalf("Deleted message %d is back after migration", seq)
+		}
+	}
+}
+
+func TestJetStreamMsgDeleteRange(t *testing.T) {
+	for _, st := range []StorageType{FileStorage, MemoryStorage} {
+		t.Run(st.String(), func(t *testing.T) {
+			s := RunBasicJetStreamServer(t)
+			defer s.Shutdown()
+
+			nc, js := jsClientConnect(t, s)
+			defer nc.Close()
+
+			addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: st})
+			for i := 0; i < 100; i++ {
+				_, err := js.Publish("foo", []byte("ok"))
+				require_NoError(t, err)
+			}
+
+			// Have some messages pending for a consumer in the range.
+			_, err := js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy})
+			require_NoError(t, err)
+			sub, err := js.PullSubscribe("foo", "dlc")
+			require_NoError(t, err)
+			msgs, err := sub.Fetch(20)
+			require_NoError(t, err)
+			require_True(t, len(msgs) == 20)
+
+			deleteRange := func(first, last uint64) *JSApiMsgDeleteRangeResponse {
+				t.Helper()
+				req, err := json.Marshal(&JSApiMsgDeleteRangeRequest{First: first, Last: last})
+				require_NoError(t, err)
+				resp, err := nc.Request(fmt.Sprintf(JSApiMsgDeleteRangeT, "TEST"), req, time.Second)
+				require_NoError(t, err)
+				var dresp JSApiMsgDeleteRangeResponse
+				require_NoError(t, json.Unmarshal(resp.Data, &dresp))
+				return &dresp
+			}
+
+			dresp := deleteRange(11, 60)
+			require_True(t, dresp.Error == nil)
+			require_True(t, dresp.Removed == 50)
+
+			si, err := js.StreamInfo("TEST")
+			require_NoError(t, err)
+			require_True(t, si.State.Msgs == 50)
+			require_True(t, si.State.NumDeleted == 50)
+
+			// Pending messages in the range are no longer pending and only what is left counts.
+			checkFor(t, time.Second, 50*time.Millisecond, func() error {
+				ci, err := js.ConsumerInfo("TEST", "dlc")
+				if err != nil {
+					return err
+				}
+				if ci.NumAckPending != 10 || ci.NumPending != 40 {
+					return fmt.Errorf("Unexpected consumer state: %d ack pending, %d pending", ci.NumAckPending, ci.NumPending)
+				}
+				return nil
+			})
+
+			// Nothing left in the range.
+			dresp = deleteRange(11, 60)
+			require_True(t, dresp.Error == nil)
+			require_True(t, dresp.Removed == 0)
+
+			dresp = deleteRange(0, 10)
+			require_True(t, dresp.Error != nil)
+			require_True(t, dresp.Error.ErrCode == uint16(JSStreamMsgDeleteFailedF))
+
+			// Honor deny delete.
+			updateStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: st, DenyDelete: true})
+			dresp = deleteRange(1, 10)
+			require_True(t, dresp.Error != nil)
+			si, err = js.StreamInfo("TEST")
+			require_NoError(t, err)
+			require_True(t, si.State.Msgs == 50)
+		})
+	}
+}
+
+func TestJetStreamMemStoreArena(t *testing.T) {
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: 127.0.0.1:-1
+		jetstream {
+			store_dir: %q
+			memstore { arena_slab_size: 64KB }
+		}
+	`, t.TempDir())))
+	s, o := RunServerWithConfig(conf)
+	defer s.Shutdown()
+	require_True(t, o.JetStreamMemStoreArena == 64*1024)
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Storage: nats.MemoryStorage})
+	require_NoError(t, err)
+	for i := 0; i < 100; i++ {
+		_, err = js.Publish("TEST", []byte(fmt.Sprintf("msg-%d", i)))
+		require_NoError(t, err)
+	}
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	ms := mset.store.(*memStore)
+	require_True(t, ms.arena != nil && ms.arena.slabSize == 64*1024)
+
+	m, err := js.GetMsg("TEST", 42)
+	require_NoError(t, err)
+	require_True(t, string(m.Data) == "msg-41")
+
+	// Disabled explicitly.
+	opts := &Options{}
+	require_NoError(t, opts.ProcessConfigFile(createConfFile(t, []byte(`
+		jetstream { memstore { arena: false, arena_slab_size: 64KB } }
+	`))))
+	require_True(t, opts.JetStreamMemStoreArena == 0)
+	opts = &Options{}
+	require_NoError(t, opts.ProcessConfigFile(createConfFile(t, []byte(`
+		jetstream { memstore { arena: true } }
+	`))))
+	require_True(t, opts.JetStreamMemStoreArena == defaultMemArenaSlabSize)
+}
+
+func TestJetStreamStreamFreeze(t *testing.T) {
+	for _, st := range []StorageType{FileStorage, MemoryStorage} {
+		t.Run(st.String(), func(t *testing.T) {
+			s := RunBasicJetStreamServer(t)
+			defer s.Shutdown()
+
+			nc, js := jsClientConnect(t, s)
+			defer nc.Close()
+
+			addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: st, MaxAge: 250 * time.Millisecond})
+			for i := 0; i < 10; i++ {
+				_, err := js.Publish("foo", []byte("ok"))
+				require_NoError(t, err)
+			}
+
+			freeze := func(frozen bool) *JSApiStreamFreezeResponse {
+				t.Helper()
+				req, err := json.Marshal(&JSApiStreamFreezeRequest{Frozen: frozen})
+				require_NoError(t, err)
+				resp, err := nc.Request(fmt.Sprintf(JSApiStreamFreezeT, "TEST"), req, time.Second)
+				require_NoError(t, err)
+				var fresp JSApiStreamFreezeResponse
+				require_NoError(t, json.Unmarshal(resp.Data, &fresp))
+				return &fresp
+			}
+			streamInfo := func() *StreamInfo {
+				t.Helper()
+				resp, err := nc.Request(fmt.Sprintf(JSApiStreamInfoT, "TEST"), nil, time.Second)
+				require_NoError(t, err)
+				var iresp JSApiStreamInfoResponse
+				require_NoError(t, json.Unmarshal(resp.Data, &iresp))
+				require_True(t, iresp.Error == nil)
+				return iresp.StreamInfo
+			}
+
+			fresp := freeze(true)
+			require_True(t, fresp.Error == nil)
+			require_True(t, fresp.Frozen)
+			require_True(t, streamInfo().Frozen)
+
+			// New messages are rejected.
+			_, err := js.Publish("foo", []byte("no"))
+			require_Error(t, err)
+			require_Contains(t, err.Error(), "frozen")
+
+			// So are removals.
+			require_Error(t, js.DeleteMsg("TEST", 1))
+			require_Error(t, js.PurgeStream("TEST"))
+
+			// Reads still work.
+			m, err := js.GetMsg("TEST", 5)
+			require_NoError(t, err)
+			require_True(t, string(m.Data) == "ok")
+			sub, err := js.SubscribeSync("foo")
+			require_NoError(t, err)
+			for i := 0; i < 10; i++ {
+				_, err := sub.NextMsg(time.Second)
+				require_NoError(t, err)
+			}
+			require_NoError(t, sub.Unsubscribe())
+
+			// Nothing expires while frozen.
+			time.Sleep(500 * time.Millisecond)
+			si := streamInfo()
+			require_True(t, si.State.Msgs == 10)
+			require_True(t, si.State.FirstSeq == 1)
+
+			// Thawing expires what is now too old and accepts messages again.
+			fresp = freeze(false)
+			require_True(t, fresp.Error == nil)
+			require_False(t, fresp.Frozen)
+			si = streamInfo()
+			require_False(t, si.Frozen)
+			require_True(t, si.State.Msgs == 0)
+			pa, err := js.Publish("foo", []byte("ok"))
+			require_NoError(t, err)
+			require_True(t, pa.Sequence == 11)
+
+			// Deleting a frozen stream is allowed.
+			require_True(t, freeze(true).Error == nil)
+			require_NoError(t, js.DeleteStream("TEST"))
+
+			fresp = freeze(true)
+			require_True(t, fresp.Error != nil)
+			require_True(t, fresp.Error.ErrCode == uint16(JSStreamNotFoundErr))
+		})
+	}
+}
+
+func TestJetStreamStreamConfigHistory(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	request := func(subj string, req interface{}, resp interface{}) {
+		t.Helper()
+		var data []byte
+		if req != nil {
+			var err error
+			data, err = json.Marshal(req)
+			require_NoError(t, err)
+		}
+		m, err := nc.Request(subj, data, time.Second)
+		require_NoError(t, err)
+		require_NoError(t, json.Unmarshal(m.Data, resp))
+	}
+	history := func(subj string) []*ConfigRevision {
+		t.Helper()
+		var hresp JSApiConfigHistoryResponse
+		request(subj, nil, &hresp)
+		require_True(t, hresp.Error == nil)
+		return hresp.Revisions
+	}
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	require_True(t, len(history(fmt.Sprintf(JSApiStreamHistoryT, "TEST"))) == 1)
+
+	_, err = js.UpdateStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}, MaxMsgs: 100})
+	require_NoError(t, err)
+	// The wrong subject.
+	_, err = js.UpdateStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"bar"}, MaxMsgs: 100})
+	require_NoError(t, err)
+	// Updates that change nothing are not a new revision.
+	_, err = js.UpdateStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"bar"}, MaxMsgs: 100})
+	require_NoError(t, err)
+
+	revs := history(fmt.Sprintf(JSApiStreamHistoryT, "TEST"))
+	require_True(t, len(revs) == 3)
+	require_True(t, len(history(fmt.Sprintf(JSApiStreamConfigHistoryT, "TEST"))) == 3)
+	for i, rev := range revs {
+		require_True(t, rev.Revision == uint64(i+1))
+	}
+	var cfg StreamConfig
+	require_NoError(t, json.Unmarshal(revs[2].Config, &cfg))
+	require_Equal(t, cfg.Subjects[0], "bar")
+
+	var dresp JSApiConfigDiffResponse
+	request(fmt.Sprintf(JSApiStreamDiffT, "TEST"), &JSApiConfigDiffRequest{From: 2}, &dresp)
+	require_True(t, dresp.Error == nil)
+	require_True(t, dresp.From == 2 && dresp.To == 3)
+	require_True(t, len(dresp.Changes) == 1)
+	require_Equal(t, dresp.Changes[0].Field, "subjects")
+	require_Equal(t, string(dresp.Changes[0].From), `["foo"]`)
+	require_Equal(t, string(dresp.Changes[0].To), `["bar"]`)
+
+	dresp = JSApiConfigDiffResponse{}
+	request(fmt.Sprintf(JSApiStreamDiffT, "TEST"), &JSApiConfigDiffRequest{From: 1, To: 3}, &dresp)
+	require_True(t, dresp.Error == nil)
+	require_True(t, len(dresp.Changes) == 2)
+	require_Equal(t, dresp.Changes[0].Field, "max_msgs")
+	require_Equal(t, dresp.Changes[1].Field, "subjects")
+
+	dresp = JSApiConfigDiffResponse{}
+	request(fmt.Sprintf(JSApiStreamDiffT, "TEST"), &JSApiConfigDiffRequest{From: 22}, &dresp)
+	require_True(t, dresp.Error != nil)
+	require_True(t, dresp.Error.ErrCode == uint16(JSConfigRevisionNotFoundErr))
+
+	// Roll back to before the wrong subject, which is a new revision.
+	var uresp JSApiStreamUpdateResponse
+	request(fmt.Sprintf(JSApiStreamRollbackT, "TEST"), &JSApiConfigRollbackRequest{Revision: 2}, &uresp)
+	require_True(t, uresp.Error == nil)
+	require_Equal(t, uresp.Config.Subjects[0], "foo")
+	require_True(t, uresp.Config.MaxMsgs == 100)
+	revs = history(fmt.Sprintf(JSApiStreamHistoryT, "TEST"))
+	require_True(t, len(revs) == 4)
+	require_True(t, revs[3].Revision == 4)
+
+	uresp = JSApiStreamUpdateResponse{}
+	request(fmt.Sprintf(JSApiStreamRollbackT, "TEST"), &JSApiConfigRollbackRequest{Revision: 22}, &uresp)
+	require_True(t, uresp.Error != nil)
+	require_True(t, uresp.Error.Code == 404)
+
+	// Consumers.
+	_, err = js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy, MaxDeliver: 5})
+	require_NoError(t, err)
+	_, err = js.UpdateConsumer("TEST", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy, MaxDeliver: 10})
+	require_NoError(t, err)
+	require_True(t, len(history(fmt.Sprintf(JSApiConsumerHistoryT, "TEST", "dlc"))) == 2)
+
+	dresp = JSApiConfigDiffResponse{}
+	request(fmt.Sprintf(JSApiConsumerDiffT, "TEST", "dlc"), &JSApiConfigDiffRequest{From: 1}, &dresp)
+	require_True(t, dresp.Error == nil)
+	require_True(t, len(dresp.Changes) == 1)
+	require_Equal(t, dresp.Changes[0].Field, "max_deliver")
+
+	var cresp JSApiConsumerCreateResponse
+	request(fmt.Sprintf(JSApiConsumerRollbackT, "TEST", "dlc"), &JSApiConfigRollbackRequest{Revision: 1}, &cresp)
+	require_True(t, cresp.Error == nil)
+	require_True(t, cresp.Config.MaxDeliver == 5)
+	require_True(t, len(history(fmt.Sprintf(JSApiConsumerHistoryT, "TEST", "dlc"))) == 3)
+
+	// History survives a restart.
+	sd := s.JetStreamConfig().StoreDir
+	nc.Close()
+	s.Shutdown()
+	s = RunJetStreamServerOnPort(-1, sd)
+	defer s.Shutdown()
+	nc, js = jsClientConnect(t, s)
+	defer nc.Close()
+	require_True(t, len(history(fmt.Sprintf(JSApiStreamHistoryT, "TEST"))) == 4)
+	require_True(t, len(history(fmt.Sprintf(JSApiConsumerHistoryT, "TEST", "dlc"))) == 3)
+
+	// A new stream with the same name starts over.
+	require_NoError(t, js.DeleteStream("TEST"))
+	_, err = js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	require_True(t, len(history(fmt.Sprintf(JSApiStreamHistoryT, "TEST"))) == 1)
+}
+
+func TestJetStreamAccountDrift(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	drift := func(req *JSApiAccountDriftRequest) *JSApiAccountDriftResponse {
+		t.Helper()
+		b, err := json.Marshal(req)
+		require_NoError(t, err)
+		m, err := nc.Request(JSApiAccountDrift, b, time.Second)
+		require_NoError(t, err)
+		var resp JSApiAccountDriftResponse
+		require_NoError(t, json.Unmarshal(m.Data, &resp))
+		return &resp
+	}
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}})
+	require_NoError(t, err)
+	_, err = js.AddConsumer("ORDERS", &nats.ConsumerConfig{Durable: "proc", AckPolicy: nats.AckExplicitPolicy, MaxDeliver: 10})
+	require_NoError(t, err)
+	_, err = js.AddStream(&nats.StreamConfig{Name: "EXTRA", Subjects: []string{"extra"}})
+	require_NoError(t, err)
+	// Ephemerals are not expected to be declared.
+	_, err = js.SubscribeSync("orders.new")
+	require_NoError(t, err)
+
+	orders := &DesiredStream{
+		Config:    StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}, Storage: FileStorage},
+		Consumers: []*ConsumerConfig{{Durable: "proc", AckPolicy: AckExplicit, MaxDeliver: 5}},
+	}
+	events := &DesiredStream{
+		Config:    StreamConfig{Name: "EVENTS", Subjects: []string{"events.>"}, Storage: FileStorage},
+		Consumers: []*ConsumerConfig{{Durable: "audit", AckPolicy: AckExplicit}},
+	}
+	resp := drift(&JSApiAccountDriftRequest{Streams: []*DesiredStream{orders, events}})
+	require_True(t, resp.Error == nil)
+	require_False(t, resp.InSync)
+	require_True(t, len(resp.Drift) == 4)
+
+	require_Equal(t, resp.Drift[0].Stream, "EVENTS")
+	require_Equal(t, resp.Drift[0].Kind, DriftMissing)
+	require_Equal(t, resp.Drift[1].Consumer, "audit")
+	require_Equal(t, resp.Drift[1].Kind, DriftMissing)
+	require_Equal(t, resp.Drift[2].Stream, "EXTRA")
+	require_Equal(t, resp.Drift[2].Kind, DriftExtra)
+	require_Equal(t, resp.Drift[3].Stream, "ORDERS")
+	require_Equal(t, resp.Drift[3].Consumer, "proc")
+	require_Equal(t, resp.Drift[3].Kind, DriftDiffers)
+	require_True(t, len(resp.Drift[3].Changes) == 1)
+	require_Equal(t, resp.Drift[3].Changes[0].Field, "max_deliver")
+	require_Equal(t, string(resp.Drift[3].Changes[0].From), "5")
+	require_Equal(t, string(resp.Drift[3].Changes[0].To), "10")
+
+	// Nothing was changed.
+	ci, err := js.ConsumerInfo("ORDERS", "proc")
+	require_NoError(t, err)
+	require_True(t, ci.Config.MaxDeliver == 10)
+	_, err = js.StreamInfo("EVENTS")
+	require_Error(t, err, nats.ErrStreamNotFound)
+
+	// Matching the actual state.
+	orders.Consumers[0].MaxDeliver = 10
+	extra := &DesiredStream{Config: StreamConfig{Name: "EXTRA", Subjects: []string{"extra"}, Storage: FileStorage}}
+	resp = drift(&JSApiAccountDriftRequest{Streams: []*DesiredStream{orders, extra}})
+	require_True(t, resp.Error == nil)
+	require_True(t, resp.InSync)
+	require_True(t, len(resp.Drift) == 0)
+
+	// A stream config that differs.
+	extra.Config.MaxMsgs = 100
+	resp = drift(&JSApiAccountDriftRequest{Streams: []*DesiredStream{orders, extra}})
+	require_True(t, resp.Error == nil)
+	require_True(t, len(resp.Drift) == 1)
+	require_Equal(t, resp.Drift[0].Kind, DriftDiffers)
+	require_Equal(t, resp.Drift[0].Changes[0].Field, "max_msgs")
+
+	// Invalid manifests.
+	resp = drift(&JSApiAccountDriftRequest{Streams: []*DesiredStream{orders, orders}})
+	require_True(t, resp.Error != nil)
+	require_True(t, resp.Error.ErrCode == uint16(JSDriftManifestInvalidErrF))
+	resp = drift(&JSApiAccountDriftRequest{Streams: []*DesiredStream{{Config: StreamConfig{Name: "X", Storage: FileStorage}, Consumers: []*ConsumerConfig{{AckPolicy: AckExplicit}}}}})
+	require_True(t, resp.Error != nil)
+	require_Contains(t, resp.Error.Description, "needs a name")
+}
+
+func TestJetStreamStreamSyncPolicy(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	syncPolicy := func(name string) (time.Duration, bool) {
+		t.Helper()
+		mset, err := s.GlobalAccount().lookupStream(name)
+		require_NoError(t, err)
+		fs := mset.store.(*fileStore)
+		fs.mu.RLock()
+		defer fs.mu.RUnlock()
+		return fs.fcfg.SyncInterval, fs.fcfg.SyncAlways
+	}
+
+	addStream(t, nc, &StreamConfig{Name: "BULK", Subjects: []string{"bulk"}, Storage: FileStorage})
+	si, sa := syncPolicy("BULK")
+	require_True(t, si == 2*time.Minute)
+	require_False(t, sa)
+
+	addStream(t, nc, &StreamConfig{Name: "FAST", Subjects: []string{"fast"}, Storage: FileStorage, SyncAlways: true, SyncInterval: 10 * time.Second})
+	si, sa = syncPolicy("FAST")
+	require_True(t, si == 10*time.Second)
+	require_True(t, sa)
+	for i := 0; i < 10; i++ {
+		_, err := js.Publish("fast", []byte("ok"))
+		require_NoError(t, err)
+	}
+	m, err := js.GetMsg("FAST", 10)
+	require_NoError(t, err)
+	require_True(t, string(m.Data) == "ok")
+
+	// Can be changed and reverts to the default when cleared.
+	updateStream(t, nc, &StreamConfig{Name: "FAST", Subjects: []string{"fast"}, Storage: FileStorage, SyncInterval: time.Second})
+	si, sa = syncPolicy("FAST")
+	require_True(t, si == time.Second)
+	require_False(t, sa)
+	updateStream(t, nc, &StreamConfig{Name: "FAST", Subjects: []string{"fast"}, Storage: FileStorage})
+	si, _ = syncPolicy("FAST")
+	require_True(t, si == 2*time.Minute)
+
+	// Only for file storage and not negative.
+	for _, cfg := range []*StreamConfig{
+		{Name: "MEM", Storage: MemoryStorage, SyncAlways: true},
+		{Name: "NEG", Storage: FileStorage, SyncInterval: -time.Second},
+	} {
+		req, err := json.Marshal(cfg)
+		require_NoError(t, err)
+		resp, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, cfg.Name), req, time.Second)
+		require_NoError(t, err)
+		var scResp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(resp.Data, &scResp))
+		require_True(t, scResp.Error != nil)
+		require_True(t, scResp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+	}
+}
+
+func TestJetStreamStreamingListRequests(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	// More than fit in a single page.
+	numConsumers := JSApiListLimit + 10
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	for i := 0; i < numConsumers; i++ {
+		_, err = js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: fmt.Sprintf("C%d", i), AckPolicy: nats.AckExplicitPolicy})
+		require_NoError(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = js.AddStream(&nats.StreamConfig{Name: fmt.Sprintf("S%d", i), Subjects: []string{fmt.Sprintf("bar.%d", i)}})
+		require_NoError(t, err)
+	}
+
+	// Collects all messages up to and including the summary of the given type.
+	list := func(t *testing.T, subj string, req any, summaryType string) ([]string, []byte) {
+		t.Helper()
+		sub, err := nc.SubscribeSync(nats.NewInbox())
+		require_NoError(t, err)
+		defer sub.Unsubscribe()
+		b, err := json.Marshal(req)
+		require_NoError(t, err)
+		require_NoError(t, nc.PublishRequest(subj, sub.Subject, b))
+		var types []string
+		for {
+			m, err := sub.NextMsg(2 * time.Second)
+			require_NoError(t, err)
+			var resp ApiResponse
+			require_NoError(t, json.Unmarshal(m.Data, &resp))
+			if resp.Type == summaryType {
+				return types, m.Data
+			}
+			types = append(types, resp.Type)
+		}
+	}
+
+	types, summary := list(t, fmt.Sprintf(JSApiConsumerListT, "TEST"), &JSApiConsumersRequest{Streaming: true}, JSApiConsumerListResponseType)
+	require_True(t, len(types) == numConsumers)
+	for _, typ := range types {
+		require_True(t, typ == JSApiConsumerInfoResponseType)
+	}
+	var clist JSApiConsumerListResponse
+	require_NoError(t, json.Unmarshal(summary, &clist))
+	require_True(t, clist.Total == numConsumers)
+	require_True(t, len(clist.Consumers) == 0)
+
+	// Offsets still apply.
+	types, _ = list(t, fmt.Sprintf(JSApiConsumerListT, "TEST"), &JSApiConsumersRequest{ApiPagedRequest: ApiPagedRequest{Offset: 10}, Streaming: true}, JSApiConsumerListResponseType)
+	require_True(t, len(types) == numConsumers-10)
+
+	types, summary = list(t, JSApiStreamList, &JSApiStreamListRequest{Subject: "bar.*", Streaming: true}, JSApiStreamListResponseType)
+	require_True(t, len(types) == 3)
+	var slist JSApiStreamListResponse
+	require_NoError(t, json.Unmarshal(summary, &slist))
+	require_True(t, slist.Total == 3)
+	require_True(t, len(slist.Streams) == 0)
+}
+
+func TestJetStreamConsumerGroupRebalanceAdvisory(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+
+	asub, err := nc.SubscribeSync(JSAdvisoryConsumerRebalancePre + ".TEST.DUR")
+	require_NoError(t, err)
+	require_NoError(t, nc.Flush())
+
+	_, err = js.AddConsumer("TEST", &nats.ConsumerConfig{
+		Durable:        "DUR",
+		DeliverSubject: "deliver",
+		DeliverGroup:   "G",
+		AckPolicy:      nats.AckExplicitPolicy,
+	})
+	require_NoError(t, err)
+
+	nextAdvisory := func() *JSConsumerGroupRebalanceAdvisory {
+		t.Helper()
+		m, err := asub.NextMsg(3 * consumerGroupCheckInterval)
+		require_NoError(t, err)
+		var adv JSConsumerGroupRebalanceAdvisory
+		require_NoError(t, json.Unmarshal(m.Data, &adv))
+		require_True(t, adv.Type == JSConsumerGroupRebalanceAdvisoryType)
+		require_True(t, adv.Stream == "TEST" && adv.Consumer == "DUR" && adv.Group == "G")
+		return &adv
+	}
+
+	nc2, err := nats.Connect(s.ClientURL(), nats.Name("member-2"))
+	require_NoError(t, err)
+	defer nc2.Close()
+
+	_, err = nc.QueueSubscribeSync("deliver", "G")
+	require_NoError(t, err)
+	qsub2, err := nc2.QueueSubscribeSync("deliver", "G")
+	require_NoError(t, err)
+	require_NoError(t, nc.Flush())
+	require_NoError(t, nc2.Flush())
+
+	// Both members may be picked up at once or one after the other.
+	checkFor(t, 5*time.Second, 0, func() error {
+		if adv := nextAdvisory(); len(adv.Members) != 2 {
+			return fmt.Errorf("expected 2 members, got %+v", adv.Members)
+		}
+		return nil
+	})
+
+	require_NoError(t, qsub2.Unsubscribe())
+	require_NoError(t, nc2.Flush())
+
+	adv := nextAdvisory()
+	require_True(t, len(adv.Members) == 1)
+	require_True(t, len(adv.Joined) == 0)
+	require_True(t, len(adv.Left) == 1)
+	require_True(t, adv.Left[0].Name == "member-2")
+	require_True(t, adv.Left[0].Kind == "Client")
+	require_True(t, adv.Left[0].Server == s.Name())
+	require_True(t, adv.Left[0].Count == 1)
+
+	// Nothing else changed.
+	_, err = asub.NextMsg(2 * consumerGroupCheckInterval)
+	require_Error(t, err, nats.ErrTimeout)
+}
+
+func TestJetStreamVerifyStore(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo.*"}})
+	require_NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = js.Publish(fmt.Sprintf("foo.%d", i%3), []byte("ok"))
+		require_NoError(t, err)
+	}
+	require_NoError(t, js.DeleteMsg("TEST", 5))
+
+	sub, err := js.PullSubscribe("foo.*", "dlc")
+	require_NoError(t, err)
+	msgs, err := sub.Fetch(4)
+	require_NoError(t, err)
+	for _, m := range msgs[:2] {
+		require_NoError(t, m.AckSync())
+	}
+	nc.Close()
+
+	sd := s.JetStreamConfig().StoreDir
+	s.Shutdown()
+
+	r, err := VerifyStore(&Options{StoreDir: sd})
+	require_NoError(t, err)
+	if !r.OK || len(r.Streams) != 1 {
+		t.Fatalf("Expected a clean report, got %+v", r)
+	}
+	sr := r.Streams[0]
+	require_Equal(t, sr.Stream, "TEST")
+	require_True(t, sr.Msgs == 9)
+	require_True(t, sr.FirstSeq == 1)
+	require_True(t, sr.LastSeq == 10)
+	require_True(t, len(sr.Consumers) == 1)
+	cr := sr.Consumers[0]
+	require_Equal(t, cr.Name, "dlc")
+	require_True(t, cr.Delivered.Stream == 4)
+	require_True(t, cr.AckFloor.Stream == 2)
+	require_True(t, cr.Pending == 2)
+
+	// Flip a bit in the payload of the last message.
+	mfn := filepath.Join(sd, globalAccountName, streamsDir, "TEST", msgDir, fmt.Sprintf(blkScan, 1))
+	buf, err := os.ReadFile(mfn)
+	require_NoError(t, err)
+	buf[len(buf)-10] ^= 0xff
+	require_NoError(t, os.WriteFile(mfn, buf, defaultFilePerms))
+
+	r, err = VerifyStore(&Options{StoreDir: filepath.Dir(sd)})
+	require_NoError(t, err)
+	require_False(t, r.OK)
+	if errs := r.Streams[0].Errors; len(errs) != 1 || !strings.Contains(errs[0], "checksum mismatch for sequence 10") {
+		t.Fatalf("Expected a checksum error, got %q", errs)
+	}
+}
+
+func TestJetStreamStreamRemapSubjects(t *testing.T) {
+	for _, st := range []nats.StorageType{nats.FileStorage, nats.MemoryStorage} {
+		t.Run(st.String(), func(t *testing.T) {
+			s := RunBasicJetStreamServer(t)
+			defer s.Shutdown()
+
+			nc, js := jsClientConnect(t, s)
+			defer nc.Close()
+
+			_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"orders.>"}, Storage: st})
+			require_NoError(t, err)
+			for i := 0; i < 10; i++ {
+				_, err := js.Publish(fmt.Sprintf("orders.old.%d", i%2), []byte("ok"))
+				require_NoError(t, err)
+			}
+			sub, err := js.PullSubscribe("orders.new.*", "dlc")
+			require_NoError(t, err)
+
+			remap := func(filter, dest string) *JSApiStreamRemapResponse {
+				t.Helper()
+				req, _ := json.Marshal(&JSApiStreamRemapRequest{Filter: filter, Destination: dest})
+				resp, err := nc.Request(fmt.Sprintf(JSApiStreamRemapT, "TEST"), req, time.Second)
+				require_NoError(t, err)
+				var rresp JSApiStreamRemapResponse
+				require_NoError(t, json.Unmarshal(resp.Data, &rresp))
+				return &rresp
+			}
+
+			// Remapped subjects need to stay in the stream and not match the filter again.
+			for _, dest := range []string{"foo.$1", "orders.$1.old", "orders.old.x"} {
+				resp := remap("orders.old.*", dest)
+				require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamSubjectRemapInvalidErrF))
+			}
+			resp := remap("orders.old.*", "orders.new.$1")
+			require_True(t, resp.Error == nil)
+			require_True(t, resp.Remapped == 10)
+
+			msgs, err := sub.Fetch(10)
+			require_NoError(t, err)
+			require_True(t, len(msgs) == 10)
+			require_Equal(t, msgs[1].Subject, "orders.new.1")
+
+			si, err := js.StreamInfo("TEST", &nats.StreamInfoRequest{SubjectsFilter: ">"})
+			require_NoError(t, err)
+			require_True(t, si.State.Msgs == 10)
+			require_True(t, len(si.State.Subjects) == 2)
+			require_True(t, si.State.Subjects["orders.new.0"] == 5)
+		})
+	}
+}
+
+func TestJetStreamMessageSchedule(t *testing.T) {
+	for _, st := range []nats.StorageType{nats.FileStorage, nats.MemoryStorage} {
+		t.Run(st.String(), func(t *testing.T) {
+			s := RunBasicJetStreamServer(t)
+			defer s.Shutdown()
+
+			nc, js := jsClientConnect(t, s)
+			defer nc.Close()
+
+			_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: st})
+			require_NoError(t, err)
+			sub, err := js.PullSubscribe("foo", "dlc")
+			require_NoError(t, err)
+
+			// Invalid schedules are rejected.
+			for _, hv := range [][2]string{{JSDelay, "soon"}, {JSDelay, "-1s"}, {JSDeliverAt, "tomorrow"}} {
+				m := nats.NewMsg("foo")
+				m.Header.Set(hv[0], hv[1])
+				_, err := js.PublishMsg(m)
+				require_Error(t, err)
+				require_Contains(t, err.Error(), "invalid message schedule")
+			}
+
+			m := nats.NewMsg("foo")
+			m.Header.Set(JSDelay, "250ms")
+			m.Header.Set("X-Custom", "ok")
+			m.Data = []byte("later")
+			pa, err := js.PublishMsg(m)
+			require_NoError(t, err)
+			_, err = js.Publish("foo", []byte("now"))
+			require_NoError(t, err)
+
+			// Only the unscheduled message is delivered right away.
+			msgs, err := sub.Fetch(2, nats.MaxWait(100*time.Millisecond))
+			require_NoError(t, err)
+			require_True(t, len(msgs) == 1)
+			require_Equal(t, string(msgs[0].Data), "now")
+			msgs[0].AckSync()
+
+			msgs, err = sub.Fetch(1, nats.MaxWait(2*time.Second))
+			require_NoError(t, err)
+			require_True(t, len(msgs) == 1)
+			msg := msgs[0]
+			require_Equal(t, string(msg.Data), "later")
+			require_Equal(t, msg.Header.Get("X-Custom"), "ok")
+			require_Equal(t, msg.Header.Get(JSDelay), _EMPTY_)
+			require_Equal(t, msg.Header.Get(JSScheduledSequence), strconv.FormatUint(pa.Sequence, 10))
+			meta, err := msg.Metadata()
+			require_NoError(t, err)
+			require_True(t, meta.Sequence.Stream == 3)
+
+			// The original is removed once delivered.
+			checkFor(t, time.Second, 10*time.Millisecond, func() error {
+				if _, err := js.GetMsg("TEST", pa.Sequence); err == nil {
+					return fmt.Errorf("Original message still present")
+				}
+				return nil
+			})
+			si, err := js.StreamInfo("TEST")
+			require_NoError(t, err)
+			require_True(t, si.State.Msgs == 2)
+		})
+	}
+}
+
+func TestJetStreamConsumerDeadLetterSubject(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	_, err = js.AddStream(&nats.StreamConfig{Name: "DLQ", Subjects: []string{"dlq.>"}})
+	require_NoError(t, err)
+
+	// Check validation.
+	for _, cfg := range []ConsumerConfig{
+		{Durable: "X", AckPolicy: AckExplicit, MaxDeliver: 2, DeadLetterSubject: "dlq.*"},
+		{Durable: "X", AckPolicy: AckExplicit, DeadLetterSubject: "dlq.TEST"},
+		{Durable: "X", AckPolicy: AckExplicit, MaxDeliver: 2, DeadLetterSubject: "dlq.TEST", DeliverSubject: "dlq.TEST"},
+	} {
+		req, _ := json.Marshal(&CreateConsumerRequest{Stream: "TEST", Config: cfg})
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiDurableCreateT, "TEST", "X"), req, time.Second)
+		require_NoError(t, err)
+		var resp JSApiConsumerCreateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSConsumerDeadLetterSubjectInvalidErrF))
+	}
+
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	_, err = mset.addConsumer(&ConsumerConfig{
+		Durable:           "dlc",
+		AckPolicy:         AckExplicit,
+		AckWait:           50 * time.Millisecond,
+		MaxDeliver:        2,
+		DeadLetterSubject: "dlq.TEST",
+	})
+	require_NoError(t, err)
+
+	m := nats.NewMsg("foo")
+	m.Header.Set("X-Custom", "ok")
+	m.Header.Set(JSMsgId, "1")
+	m.Data = []byte("poison")
+	_, err = js.PublishMsg(m)
+	require_NoError(t, err)
+
+	sub, err := js.PullSubscribe(_EMPTY_, "dlc", nats.Bind("TEST", "dlc"))
+	require_NoError(t, err)
+	for i := 0; i < 2; i++ {
+		msgs, err := sub.Fetch(1, nats.MaxWait(time.Second))
+		require_NoError(t, err)
+		require_True(t, len(msgs) == 1)
+	}
+	// Nothing acked so this will hit max deliveries and land in the dead letter stream.
+	_, err = sub.Fetch(1, nats.MaxWait(250*time.Millisecond))
+	require_Error(t, err)
+
+	var rsm *nats.RawStreamMsg
+	checkFor(t, time.Second, 20*time.Millisecond, func() error {
+		rsm, err = js.GetMsg("DLQ", 1)
+		return err
+	})
+	require_Equal(t, rsm.Subject, "dlq.TEST")
+	require_Equal(t, string(rsm.Data), "poison")
+	require_Equal(t, rsm.Header.Get("X-Custom"), "ok")
+	require_Equal(t, rsm.Header.Get(JSMsgId), _EMPTY_)
+	require_Equal(t, rsm.Header.Get(JSStream), "TEST")
+	require_Equal(t, rsm.Header.Get(JSConsumer), "dlc")
+	require_Equal(t, rsm.Header.Get(JSSubject), "foo")
+	require_Equal(t, rsm.Header.Get(JSSequence), "1")
+	require_Equal(t, rsm.Header.Get(JSNumDelivered), "2")
+
+	si, err := js.StreamInfo("DLQ")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 1)
+}
+
+func TestJetStreamStreamRateLimit(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	// Check validation.
+	for _, rl := range []*StreamRateLimit{{}, {Msgs: -1}, {Bytes: 100, MaxWait: -time.Second}} {
+		req, _ := json.Marshal(&StreamConfig{Name: "BAD", Storage: MemoryStorage, RateLimit: rl})
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, "BAD"), req, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+	}
+
+	addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: MemoryStorage, RateLimit: &StreamRateLimit{Msgs: 5}})
+
+	var ok, rejected int
+	for i := 0; i < 10; i++ {
+		if _, err := js.Publish("foo", []byte("ok")); err == nil {
+			ok++
+		} else {
+			require_Contains(t, err.Error(), "stream rate limit exceeded")
+			rejected++
+		}
+	}
+	// Allow for a token to have been added back in while publishing.
+	require_True(t, ok >= 5 && ok <= 6)
+	require_True(t, rejected == 10-ok)
+
+	// With backpressure publishers are slowed down instead.
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	cfg := mset.config()
+	cfg.RateLimit = &StreamRateLimit{Msgs: 20, Backpressure: true}
+	require_NoError(t, mset.update(&cfg))
+
+	start := time.Now()
+	for i := 0; i < 40; i++ {
+		_, err := js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+	require_True(t, time.Since(start) >= 900*time.Millisecond)
+
+	// Held back messages do not stall the connection they came in on.
+	for i := 0; i < 10; i++ {
+		_, err := js.PublishAsync("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+	start = time.Now()
+	require_NoError(t, nc.Flush())
+	require_True(t, time.Since(start) < 250*time.Millisecond)
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive completion signal")
+	}
+
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == uint64(50+ok))
+}
+
+func TestJetStreamStreamSoftLimits(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, _ := jsClientConnect(t, s)
+	defer nc.Close()
+
+	// Check validation.
+	for _, sl := range []*SoftLimits{{MaxMsgsPct: 101}, {MaxBytesPct: -1}, {MaxBytesPct: 80}} {
+		req, _ := json.Marshal(&StreamConfig{Name: "BAD", Storage: MemoryStorage, MaxMsgs: 10, SoftLimits: sl})
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, "BAD"), req, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+	}
+
+	addStream(t, nc, &StreamConfig{
+		Name:       "TEST",
+		Subjects:   []string{"foo"},
+		Storage:    MemoryStorage,
+		MaxMsgs:    10,
+		SoftLimits: &SoftLimits{MaxMsgsPct: 80, WarnHeader: true},
+	})
+
+	sub := natsSubSync(t, nc, JSAdvisoryStreamSoftLimitPre+".TEST")
+
+	publish := func() *nats.Msg {
+		t.Helper()
+		m, err := nc.Request("foo", []byte("ok"), time.Second)
+		require_NoError(t, err)
+		var resp JSPubAckResponse
+		require_NoError(t, json.Unmarshal(m.Data, &resp))
+		require_NoError(t, resp.ToError())
+		return m
+	}
+
+	for i := 0; i < 7; i++ {
+		require_Equal(t, publish().Header.Get(JSSoftLimit), _EMPTY_)
+	}
+	// The 8th message crosses the threshold, but is still stored.
+	require_Equal(t, publish().Header.Get(JSSoftLimit), softLimitMaxMsgs)
+
+	var adv JSStreamSoftLimitAdvisory
+	require_NoError(t, json.Unmarshal(natsNexMsg(t, sub, time.Second).Data, &adv))
+	require_Equal(t, adv.Type, JSStreamSoftLimitAdvisoryType)
+	require_Equal(t, adv.Limit, softLimitMaxMsgs)
+	require_True(t, adv.Exceeded)
+	require_True(t, adv.Current == 8 && adv.Max == 10 && adv.Threshold == 80)
+
+	// Only sent when crossing.
+	require_Equal(t, publish().Header.Get(JSSoftLimit), softLimitMaxMsgs)
+	_, err := sub.NextMsg(100 * time.Millisecond)
+	require_Error(t, err, nats.ErrTimeout)
+
+	// Going back under is reported with the next store.
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	_, err = mset.purge(&JSApiStreamPurgeRequest{})
+	require_NoError(t, err)
+	require_Equal(t, publish().Header.Get(JSSoftLimit), _EMPTY_)
+	adv = JSStreamSoftLimitAdvisory{}
+	require_NoError(t, json.Unmarshal(natsNexMsg(t, sub, time.Second).Data, &adv))
+	require_False(t, adv.Exceeded)
+}
+
+func TestJetStreamStreamMsgCompression(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, apiErr := addStreamWithError(t, nc, &StreamConfig{Name: "BAD", Storage: MemoryStorage, CompressMsgsOver: -1})
+	require_True(t, apiErr != nil && apiErr.ErrCode == uint16(JSStreamInvalidConfigF))
+
+	addStream(t, nc, &StreamConfig{
+		Name:             "TEST",
+		Subjects:         []string{"foo"},
+		Storage:          FileStorage,
+		CompressMsgsOver: 128,
+	})
+
+	large := bytes.Repeat([]byte("compress me "), 100)
+	small := []byte("too small")
+	_, err := js.Publish("foo", large)
+	require_NoError(t, err)
+	_, err = js.Publish("foo", small)
+	require_NoError(t, err)
+
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+	sm, err := mset.getMsg(1)
+	require_NoError(t, err)
+	require_Equal(t, string(getHeader(JSCompressed, sm.Header)), "s2")
+	require_True(t, len(sm.Data) < len(large))
+	sm, err = mset.getMsg(2)
+	require_NoError(t, err)
+	require_True(t, len(sm.Header) == 0)
+	require_True(t, bytes.Equal(sm.Data, small))
+
+	// Consumers get the original payload back, unless they ask for it compressed.
+	for _, compressed := range []bool{false, true} {
+		dsubj := nats.NewInbox()
+		sub := natsSubSync(t, nc, dsubj)
+		_, err = mset.addConsumer(&ConsumerConfig{DeliverSubject: dsubj, AckPolicy: AckNone, DeliverCompressed: compressed})
+		require_NoError(t, err)
+
+		m := natsNexMsg(t, sub, time.Second)
+		if compressed {
+			require_Equal(t, m.Header.Get(JSCompressed), "s2")
+			require_True(t, len(m.Data) < len(large))
+		} else {
+			require_Equal(t, m.Header.Get(JSCompressed), _EMPTY_)
+			require_True(t, bytes.Equal(m.Data, large))
+		}
+		m = natsNexMsg(t, sub, time.Second)
+		require_True(t, bytes.Equal(m.Data, small))
+	}
+}
+
+func TestJetStreamWorkQueueConsumptionGroups(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, apiErr := addStreamWithError(t, nc, &StreamConfig{Name: "BAD", Storage: MemoryStorage, ConsumptionGroups: []string{"billing"}})
+	require_True(t, apiErr != nil && apiErr.ErrCode == uint16(JSStreamInvalidConfigF))
+	_, apiErr = addStreamWithError(t, nc, &StreamConfig{Name: "BAD", Storage: MemoryStorage, Retention: WorkQueuePolicy, ConsumptionGroups: []string{"a", "a"}})
+	require_True(t, apiErr != nil && apiErr.ErrCode == uint16(JSStreamInvalidConfigF))
+
+	addStream(t, nc, &StreamConfig{
+		Name:              "TEST",
+		Subjects:          []string{"jobs.>"},
+		Storage:           MemoryStorage,
+		Retention:         WorkQueuePolicy,
+		ConsumptionGroups: []string{"billing", "audit"},
+	})
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+
+	for _, cfg := range []*ConsumerConfig{
+		{Durable: "none", AckPolicy: AckExplicit},
+		{Durable: "other", AckPolicy: AckExplicit, ConsumptionGroup: "other"},
+	} {
+		_, err = mset.addConsumer(cfg)
+		require_Error(t, err)
+		require_True(t, IsNatsErr(err, JSConsumerConsumptionGroupInvalidErrF))
+	}
+
+	_, err = mset.addConsumer(&ConsumerConfig{Durable: "billing", AckPolicy: AckExplicit, ConsumptionGroup: "billing"})
+	require_NoError(t, err)
+	// Consumers need to be unique within their group only.
+	_, err = mset.addConsumer(&ConsumerConfig{Durable: "billing2", AckPolicy: AckExplicit, ConsumptionGroup: "billing"})
+	require_True(t, IsNatsErr(err, JSConsumerWQMultipleUnfilteredErr))
+
+	for i := 0; i < 3; i++ {
+		_, err = js.Publish("jobs.new", []byte("work"))
+		require_NoError(t, err)
+	}
+
+	consume := func(durable string, n int) {
+		t.Helper()
+		sub, err := js.PullSubscribe("jobs.>", durable, nats.Bind("TEST", durable))
+		require_NoError(t, err)
+		defer sub.Unsubscribe()
+		for _, m := range fetchMsgs(t, sub, n, time.Second) {
+			require_NoError(t, m.AckSync())
+		}
+	}
+	numMsgs := func() uint64 {
+		t.Helper()
+		si, err := js.StreamInfo("TEST")
+		require_NoError(t, err)
+		return si.State.Msgs
+	}
+
+	// Messages are kept until the audit group, which has no consumer yet, acknowledged them as well.
+	consume("billing", 3)
+	require_True(t, numMsgs() == 3)
+
+	_, err = mset.addConsumer(&ConsumerConfig{Durable: "audit", AckPolicy: AckExplicit, ConsumptionGroup: "audit"})
+	require_NoError(t, err)
+	consume("audit", 2)
+	require_True(t, numMsgs() == 1)
+	consume("audit", 1)
+	require_True(t, numMsgs() == 0)
+
+	// Groups can be added, but not removed.
+	cfg := mset.config()
+	cfg.ConsumptionGroups = []string{"billing"}
+	require_Error(t, mset.update(&cfg))
+	cfg.ConsumptionGroups = []string{"billing", "audit", "search"}
+	updateStream(t, nc, &cfg)
+}
+
+func TestJetStreamInterestHold(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, apiErr := addStreamWithError(t, nc, &StreamConfig{Name: "BAD", Storage: MemoryStorage, InterestHold: time.Second})
+	require_True(t, apiErr != nil && apiErr.ErrCode == uint16(JSStreamInvalidConfigF))
+
+	hold := 250 * time.Millisecond
+	addStream(t, nc, &StreamConfig{
+		Name:         "TEST",
+		Subjects:     []string{"foo"},
+		Storage:      MemoryStorage,
+		Retention:    InterestPolicy,
+		InterestHold: hold,
+	})
+
+	consume := func(durable string, n int) {
+		t.Helper()
+		sub, err := js.PullSubscribe("foo", durable)
+		require_NoError(t, err)
+		for _, m := range fetchMsgs(t, sub, n, time.Second) {
+			require_NoError(t, m.AckSync())
+		}
+	}
+	numMsgs := func() uint64 {
+		t.Helper()
+		si, err := js.StreamInfo("TEST")
+		require_NoError(t, err)
+		return si.State.Msgs
+	}
+	checkMsgs := func(n uint64) {
+		t.Helper()
+		checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+			if msgs := numMsgs(); msgs != n {
+				return fmt.Errorf("expected %d messages, got %d", n, msgs)
+			}
+			return nil
+		})
+	}
+
+	_, err := js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: "C1", AckPolicy: nats.AckExplicitPolicy})
+	require_NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+
+	// Acknowledged messages stick around for the hold.
+	consume("C1", 3)
+	require_True(t, numMsgs() == 3)
+	checkMsgs(0)
+
+	// A consumer created during the hold can still read them.
+	for i := 0; i < 2; i++ {
+		_, err = js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+	consume("C1", 2)
+	_, err = js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: "C2", AckPolicy: nats.AckExplicitPolicy})
+	require_NoError(t, err)
+	time.Sleep(2 * hold)
+	require_True(t, numMsgs() == 2)
+	consume("C2", 2)
+	checkMsgs(0)
+}
+
+func TestJetStreamStreamTransforms(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	// Check validation.
+	for _, xfs := range [][]*StreamTransform{
+		{{}},
+		{{Filter: "orders.*", Destination: "orders.new.$1", MaxPayload: 10}},
+		{{Destination: "orders.new"}},
+		{{Filter: "orders.*", Destination: "other.$1"}},
+		{{Headers: map[string]string{"": "x"}}},
+		{{MaxPayload: -1}},
+	} {
+		req, _ := json.Marshal(&StreamConfig{Name: "BAD", Subjects: []string{"orders.>"}, Storage: MemoryStorage, Transforms: xfs})
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, "BAD"), req, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+	}
+
+	addStream(t, nc, &StreamConfig{
+		Name:     "TEST",
+		Subjects: []string{"orders.>"},
+		Storage:  MemoryStorage,
+		Transforms: []*StreamTransform{
+			{Filter: "orders.old.*", Destination: "orders.new.$1"},
+			{Filter: "orders.new.*", Headers: map[string]string{"X-Source": "legacy"}},
+			{MaxPayload: 10},
+		},
+	})
+
+	m := nats.NewMsg("orders.old.1")
+	m.Header.Set("X-Source", "client")
+	m.Data = []byte("ok")
+	pa, err := js.PublishMsg(m)
+	require_NoError(t, err)
+	_, err = js.Publish("orders.other", []byte("ok"))
+	require_NoError(t, err)
+
+	// Payload guard rejects large messages.
+	_, err = js.Publish("orders.other", bytes.Repeat([]byte("Z"), 20))
+	require_Error(t, err)
+	require_Contains(t, err.Error(), "message size exceeds maximum allowed")
+
+	rsm, err := js.GetMsg("TEST", pa.Sequence)
+	require_NoError(t, err)
+	require_Equal(t, rsm.Subject, "orders.new.1")
+	require_Equal(t, rsm.Header.Get("X-Source"), "legacy")
+	require_True(t, len(rsm.Header.Values("X-Source")) == 1)
+
+	rsm, err = js.GetMsg("TEST", 2)
+	require_NoError(t, err)
+	require_Equal(t, rsm.Subject, "orders.other")
+	require_Equal(t, rsm.Header.Get("X-Source"), _EMPTY_)
+
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 2)
+}
+
+func TestJetStreamStreamSchemaValidation(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	// Minimal protobuf encoding to build a descriptor set and payloads.
+	pbTag := func(b []byte, num, wt uint64) []byte { return binary.AppendUvarint(b, num<<3|wt) }
+	pbVarint := func(b []byte, num, v uint64) []byte { return binary.AppendUvarint(pbTag(b, num, 0), v) }
+	pbBytes := func(b []byte, num uint64, v []byte) []byte {
+		return append(binary.AppendUvarint(pbTag(b, num, 2), uint64(len(v))), v...)
+	}
+	pbField := func(name string, num, label, typ uint64, typeName string) []byte {
+		f := pbBytes(nil, 1, []byte(name))
+		f = pbVarint(f, 3, num)
+		f = pbVarint(f, 4, label)
+		f = pbVarint(f, 5, typ)
+		if typeName != _EMPTY_ {
+			f = pbBytes(f, 6, []byte(typeName))
+		}
+		return f
+	}
+	order := pbBytes(nil, 1, []byte("Order"))
+	order = pbBytes(order, 2, pbField("id", 1, 1, 3, _EMPTY_))
+	order = pbBytes(order, 2, pbField("name", 2, 2, 9, _EMPTY_))
+	order = pbBytes(order, 2, pbField("items", 3, 3, 11, ".shop.Item"))
+	order = pbBytes(order, 2, pbField("qty", 4, 3, 5, _EMPTY_))
+	item := pbBytes(nil, 1, []byte("Item"))
+	item = pbBytes(item, 2, pbField("sku", 1, 1, 9, _EMPTY_))
+	file := pbBytes(nil, 1, []byte("shop.proto"))
+	file = pbBytes(file, 2, []byte("shop"))
+	file = pbBytes(file, 4, order)
+	file = pbBytes(file, 4, item)
+	fds := base64.StdEncoding.EncodeToString(pbBytes(nil, 1, file))
+
+	jsonDef := `{
+		"type": "object",
+		"required": ["id", "amount"],
+		"properties": {
+			"id": {"type": "string", "pattern": "^ord-[0-9]+$"},
+			"amount": {"type": "number", "minimum": 0},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+		},
+		"additionalProperties": false
+	}`
+
+	// Check validation.
+	for _, scs := range [][]*StreamSchema{
+		{{}},
+		{{Type: "xml", Definition: "<x/>"}},
+		{{Type: "json", Definition: "{"}},
+		{{Type: "json", Definition: `{"$ref": "#/defs/x"}`}},
+		{{Type: "json", Definition: `{"type": "bogus"}`}},
+		{{Type: "json", Definition: `{}`, Filter: "orders.."}},
+		{{Type: "protobuf", Definition: fds}},
+		{{Type: "protobuf", Definition: fds, Message: "shop.Missing"}},
+		{{Type: "protobuf", Definition: "not base64", Message: "shop.Order"}},
+	} {
+		req, _ := json.Marshal(&StreamConfig{Name: "BAD", Subjects: []string{"orders.>"}, Storage: MemoryStorage, Schemas: scs})
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, "BAD"), req, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+	}
+
+	addStream(t, nc, &StreamConfig{
+		Name:     "TEST",
+		Subjects: []string{"orders.>"},
+		Storage:  MemoryStorage,
+		Schemas: []*StreamSchema{
+			{Filter: "orders.json.*", Type: "json", Definition: jsonDef},
+			{Filter: "orders.pb", Type: "protobuf", Definition: fds, Message: "shop.Order"},
+		},
+	})
+
+	expectRejected := func(subj string, msg []byte, reason string) {
+		t.Helper()
+		_, err := js.Publish(subj, msg)
+		require_Error(t, err)
+		require_Contains(t, err.Error(), "message failed schema validation", reason)
+	}
+
+	// JSON.
+	_, err := js.Publish("orders.json.1", []byte(`{"id": "ord-1", "amount": 10.5, "tags": ["a"]}`))
+	require_NoError(t, err)
+	expectRejected("orders.json.1", []byte(`{"id": "ord-1"`), "not valid JSON")
+	expectRejected("orders.json.1", []byte(`{"id": "ord-1"}`), `missing required property "amount"`)
+	expectRejected("orders.json.2", []byte(`{"id": "x", "amount": 1}`), `"/id" does not match pattern`)
+	expectRejected("orders.json.2", []byte(`{"id": "ord-2", "amount": -1}`), `"/amount" is less than 0`)
+	expectRejected("orders.json.2", []byte(`{"id": "ord-2", "amount": 1, "tags": [1]}`), `"/tags/0" expected string, got integer`)
+	expectRejected("orders.json.2", []byte(`{"id": "ord-2", "amount": 1, "extra": true}`), `"/extra" is not allowed`)
+
+	// Protobuf.
+	sku := pbBytes(nil, 1, []byte("sku-1"))
+	valid := pbVarint(nil, 1, 22)
+	valid = pbBytes(valid, 2, []byte("widget"))
+	valid = pbBytes(valid, 3, sku)
+	valid = pbBytes(valid, 4, []byte{1, 2, 3})
+	_, err = js.Publish("orders.pb", valid)
+	require_NoError(t, err)
+	// Unknown fields are fine.
+	_, err = js.Publish("orders.pb", pbVarint(pbBytes(nil, 2, []byte("w")), 99, 1))
+	require_NoError(t, err)
+	expectRejected("orders.pb", pbVarint(nil, 1, 22), `shop.Order: missing required field "name"`)
+	expectRejected("orders.pb", pbBytes(pbBytes(nil, 2, []byte("w")), 1, []byte("x")), "shop.Order.id: wrong wire type 2")
+	expectRejected("orders.pb", pbBytes(pbBytes(nil, 2, []byte("w")), 3, pbVarint(nil, 1, 1)), "shop.Item.sku: wrong wire type 0")
+	expectRejected("orders.pb", valid[:len(valid)-2], "truncated")
+
+	// Other subjects are not checked.
+	_, err = js.Publish("orders.other", []byte("anything"))
+	require_NoError(t, err)
+
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 4)
+
+	// Schemas can be updated.
+	_, err = js.UpdateStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"orders.>"}, Storage: nats.MemoryStorage})
+	require_NoError(t, err)
+	_, err = js.Publish("orders.json.1", []byte("not json"))
+	require_NoError(t, err)
+}
+
+func TestJetStreamPurgeAndDeletePermissions(t *testing.T) {
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: 127.0.0.1:-1
+		jetstream: {max_mem_store: 64MB, max_file_store: 64MB, store_dir: %q}
+		accounts: {
+			A: {
+				jetstream: enabled
+				users: [
+					{user: admin, password: pwd}
+					{user: ops, password: pwd, permissions: {
+						publish: {
+							allow: ["$JS.API.STREAM.PURGE.ORDERS", "$JS.API.STREAM.PURGE.ORDERS.orders.*", "$JS.API.STREAM.MSG.DELETE.ORDERS"]
+							deny: ["$JS.API.STREAM.PURGE.ORDERS.orders.us", "$JS.API.STREAM.MSG.ERASE.>"]
+						}
+					}}
+				]
+			}
+		}
+	`, t.TempDir())))
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s, nats.UserInfo("admin", "pwd"))
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}})
+	require_NoError(t, err)
+	for _, subj := range []string{"orders.eu", "orders.eu", "orders.us", "orders.ap"} {
+		_, err = js.Publish(subj, nil)
+		require_NoError(t, err)
+	}
+
+	errCh := make(chan error, 10)
+	onc, err := nats.Connect(s.ClientURL(), nats.UserInfo("ops", "pwd"),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			errCh <- err
+		}))
+	require_NoError(t, err)
+	defer onc.Close()
+
+	requireDenied := func(subj string, req any, denied string) {
+		t.Helper()
+		b, _ := json.Marshal(req)
+		_, err := onc.Request(subj, b, 250*time.Millisecond)
+		require_Error(t, err)
+		select {
+		case err := <-errCh:
+			require_Contains(t, err.Error(), fmt.Sprintf("Permissions Violation for Publish to %q", denied))
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a permissions violation for %q", denied)
+		}
+	}
+
+	// Purges of subjects not fully allowed are denied, even when matched literally.
+	requireDenied("$JS.API.STREAM.PURGE.ORDERS.orders.>", nil, "$JS.API.STREAM.PURGE.ORDERS.orders.>")
+	requireDenied("$JS.API.STREAM.PURGE.ORDERS.orders.*", nil, "$JS.API.STREAM.PURGE.ORDERS.orders.*")
+	requireDenied("$JS.API.STREAM.PURGE.ORDERS.orders.us", nil, "$JS.API.STREAM.PURGE.ORDERS.orders.us")
+	requireDenied("$JS.API.STREAM.DELETE.ORDERS", nil, "$JS.API.STREAM.DELETE.ORDERS")
+
+	rmsg, err := onc.Request("$JS.API.STREAM.PURGE.ORDERS.orders.eu", nil, time.Second)
+	require_NoError(t, err)
+	var presp JSApiStreamPurgeResponse
+	require_NoError(t, json.Unmarshal(rmsg.Data, &presp))
+	require_True(t, presp.Error == nil && presp.Purged == 2)
+
+	// The filter in the request needs to match the one in the subject.
+	b, _ := json.Marshal(&JSApiStreamPurgeRequest{Subject: "orders.us"})
+	rmsg, err = onc.Request("$JS.API.STREAM.PURGE.ORDERS.orders.ap", b, time.Second)
+	require_NoError(t, err)
+	presp = JSApiStreamPurgeResponse{}
+	require_NoError(t, json.Unmarshal(rmsg.Data, &presp))
+	require_True(t, presp.Error != nil && presp.Error.ErrCode == uint16(JSStreamPurgeFailedF))
+
+	// Explicit denies are honored for filtered purges on the stream endpoint.
+	requireDenied("$JS.API.STREAM.PURGE.ORDERS", &JSApiStreamPurgeRequest{Subject: "orders.*"}, "$JS.API.STREAM.PURGE.ORDERS.orders.*")
+	b, _ = json.Marshal(&JSApiStreamPurgeRequest{Subject: "orders.ap"})
+	rmsg, err = onc.Request("$JS.API.STREAM.PURGE.ORDERS", b, time.Second)
+	require_NoError(t, err)
+	presp = JSApiStreamPurgeResponse{}
+	require_NoError(t, json.Unmarshal(rmsg.Data, &presp))
+	require_True(t, presp.Error == nil && presp.Purged == 1)
+
+	_, err = js.Publish("orders.eu", nil)
+	require_NoError(t, err)
+
+	// Secure erase is denied, deletes without it are not.
+	requireDenied("$JS.API.STREAM.MSG.DELETE.ORDERS", &JSApiMsgDeleteRequest{Seq: 5}, "$JS.API.STREAM.MSG.ERASE.ORDERS")
+	b, _ = json.Marshal(&JSApiMsgDeleteRequest{Seq: 5, NoErase: true})
+	rmsg, err = onc.Request("$JS.API.STREAM.MSG.DELETE.ORDERS", b, time.Second)
+	require_NoError(t, err)
+	var dresp JSApiMsgDeleteResponse
+	require_NoError(t, json.Unmarshal(rmsg.Data, &dresp))
+	require_True(t, dresp.Success)
+
+	si, err := js.StreamInfo("ORDERS")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 1)
+
+	// Compressed requests are checked once decompressed, and the ones we can not parse are denied.
+	_, err = js.Publish("orders.eu", nil)
+	require_NoError(t, err)
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require_NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	_, err = br.ReadString('\n')
+	require_NoError(t, err)
+	_, err = conn.Write([]byte("CONNECT {\"verbose\":false,\"headers\":true,\"compression\":\"s2\",\"user\":\"ops\",\"pass\":\"pwd\"}\r\nPING\r\n"))
+	require_NoError(t, err)
+	l, err := br.ReadString('\n')
+	require_NoError(t, err)
+	require_Equal(t, l, "PONG\r\n")
+
+	hdr := fmt.Sprintf("NATS/1.0\r\n%s: %s\r\n\r\n", CompressionHdr, CompressionS2)
+	for _, body := range [][]byte{
+		s2.Encode(nil, []byte(`{"seq":6}`)),
+		s2.Encode(nil, []byte(`{"seq":6,`)),
+	} {
+		_, err = conn.Write([]byte(fmt.Sprintf("HPUB $JS.API.STREAM.MSG.DELETE.ORDERS _INBOX.erase %d %d\r\n%s%s\r\nPING\r\n", len(hdr), len(hdr)+len(body), hdr, body)))
+		require_NoError(t, err)
+		l, err = br.ReadString('\n')
+		require_NoError(t, err)
+		require_Contains(t, l, "Permissions Violation for Publish to \"$JS.API.STREAM.MSG.")
+		l, err = br.ReadString('\n')
+		require_NoError(t, err)
+		require_Equal(t, l, "PONG\r\n")
+	}
+
+	si, err = js.StreamInfo("ORDERS")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 2)
+}
+
+func TestJetStreamSourceFromAccountWithToken(t *testing.T) {
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: 127.0.0.1:-1
+		jetstream: {max_mem_store: 64MB, max_file_store: 64MB, store_dir: %q}
+		accounts: {
+			A: { jetstream: enabled, users: [ {user: a, password: pwd} ] }
+			B: { jetstream: enabled, users: [ {user: b, password: pwd} ] }
+		}
+	`, t.TempDir())))
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	nca, jsa := jsClientConnect(t, s, nats.UserInfo("a", "pwd"))
+	defer nca.Close()
+	ncb, jsb := jsClientConnect(t, s, nats.UserInfo("b", "pwd"))
+	defer ncb.Close()
+
+	addStream(t, nca, &StreamConfig{Name: "ORIGIN", Subjects: []string{"foo.>"}, Storage: MemoryStorage, SourceTokens: []string{"s3cr3t"}})
+	for i := 0; i < 10; i++ {
+		_, err := jsa.Publish(fmt.Sprintf("foo.%d", i), []byte("OK"))
+		require_NoError(t, err)
+	}
+
+	// An account needs a token.
+	req, _ := json.Marshal(&StreamConfig{
+		Name:    "BAD",
+		Storage: MemoryStorage,
+		Sources: []*StreamSource{{Name: "ORIGIN", External: &ExternalStream{Account: "A"}}},
+	})
+	rmsg, err := ncb.Request(fmt.Sprintf(JSApiStreamCreateT, "BAD"), req, time.Second)
+	require_NoError(t, err)
+	var scResp JSApiStreamCreateResponse
+	require_NoError(t, json.Unmarshal(rmsg.Data, &scResp))
+	require_True(t, scResp.Error != nil && scResp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+
+	addStream(t, ncb, &StreamConfig{
+		Name:    "AGG",
+		Storage: MemoryStorage,
+		Sources: []*StreamSource{{Name: "ORIGIN", FilterSubject: "foo.>", External: &ExternalStream{Account: "A", Token: "s3cr3t"}}},
+	})
+	addStream(t, ncb, &StreamConfig{
+		Name:    "MIRROR",
+		Storage: MemoryStorage,
+		Mirror:  &StreamSource{Name: "ORIGIN", External: &ExternalStream{Account: "A", Token: "s3cr3t"}},
+	})
+
+	checkFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+		for _, stream := range []string{"AGG", "MIRROR"} {
+			si, err := jsb.StreamInfo(stream)
+			if err != nil {
+				return err
+			}
+			if si.State.Msgs != 10 {
+				return fmt.Errorf("expected 10 msgs in %q, got %d", stream, si.State.Msgs)
+			}
+		}
+		return nil
+	})
+
+	rsm, err := jsb.GetMsg("AGG", 1)
+	require_NoError(t, err)
+	require_Equal(t, rsm.Subject, "foo.0")
+	require_Equal(t, string(rsm.Data), "OK")
+
+	// Keeps flowing, enough for flow control to kick in.
+	msg := bytes.Repeat([]byte("Z"), 64*1024)
+	for i := 0; i < 200; i++ {
+		_, err := jsa.PublishAsync("foo.bar", msg)
+		require_NoError(t, err)
+	}
+	select {
+	case <-jsa.PublishAsyncComplete():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Did not receive completion signal")
+	}
+	checkFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+		si, err := jsb.StreamInfo("AGG")
+		if err != nil {
+			return err
+		}
+		if si.State.Msgs != 210 {
+			return fmt.Errorf("expected 210 msgs, got %d", si.State.Msgs)
+		}
+		return nil
+	})
+
+	// Only JetStream API prefixes for another account.
+	req, _ = json.Marshal(&StreamConfig{
+		Name:    "BAD",
+		Storage: MemoryStorage,
+		Sources: []*StreamSource{{Name: "ORIGIN", External: &ExternalStream{Account: "A", Token: "s3cr3t", ApiPrefix: "foo.bar"}}},
+	})
+	rmsg, err = ncb.Request(fmt.Sprintf(JSApiStreamCreateT, "BAD"), req, time.Second)
+	require_NoError(t, err)
+	scResp = JSApiStreamCreateResponse{}
+	require_NoError(t, json.Unmarshal(rmsg.Data, &scResp))
+	require_True(t, scResp.Error != nil && scResp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+
+	// A wrong token is refused before we subscribe in the other account.
+	acc, err := s.LookupAccount("A")
+	require_NoError(t, err)
+	nsubs := acc.sl.Count()
+	addStream(t, ncb, &StreamConfig{
+		Name:    "DENIED",
+		Storage: MemoryStorage,
+		Sources: []*StreamSource{{Name: "ORIGIN", External: &ExternalStream{Account: "A", Token: "wrong"}}},
+	})
+
+	// The stream with the wrong token gets nothing and reports why.
+	checkFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+		rmsg, err := ncb.Request(fmt.Sprintf(JSApiStreamInfoT, "DENIED"), nil, time.Second)
+		if err != nil {
+			return err
+		}
+		var resp JSApiStreamInfoResponse
+		if err := json.Unmarshal(rmsg.Data, &resp); err != nil {
+			return err
+		}
+		if len(resp.Sources) != 1 || resp.Sources[0].Error == nil {
+			return fmt.Errorf("expected a source error")
+		}
+		if resp.Sources[0].Error.ErrCode != uint16(JSStreamSourceNotAuthorizedErr) {
+			return fmt.Errorf("unexpected source error: %+v", resp.Sources[0].Error)
+		}
+		if resp.Sources[0].External == nil || resp.Sources[0].External.Account != "A" || resp.Sources[0].External.Token != _EMPTY_ {
+			return fmt.Errorf("unexpected external info: %+v", resp.Sources[0].External)
+		}
+		require_True(t, resp.State.Msgs == 0)
+		return nil
+	})
+	require_True(t, acc.sl.Count() == nsubs)
+}
+
+func TestJetStreamPartitionedStream(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	request := func(subj string, v interface{}, resp interface{}) {
+		t.Helper()
+		var req []byte
+		if v != nil {
+			req, _ = json.Marshal(v)
+		}
+		rmsg, err := nc.Request(subj, req, time.Second)
+		require_NoError(t, err)
+		require_NoError(t, json.Unmarshal(rmsg.Data, resp))
+	}
+
+	// Check validation.
+	for _, cfg := range []*StreamConfig{
+		{Name: "BAD", Storage: MemoryStorage, Subjects: []string{"bad"}, Partitions: 1},
+		{Name: "BAD", Storage: MemoryStorage, Subjects: []string{"bad"}, Partitions: maxStreamPartitions + 1},
+		{Name: "BAD", Storage: MemoryStorage, Partitions: 2, Mirror: &StreamSource{Name: "OTHER"}},
+		{Name: "BAD", Storage: MemoryStorage, Subjects: []string{"bad"}, PartitionOf: "OTHER"},
+	} {
+		var resp JSApiStreamCreateResponse
+		request(fmt.Sprintf(JSApiStreamCreateT, cfg.Name), cfg, &resp)
+		require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+	}
+
+	cfg := &StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}, Storage: FileStorage, Partitions: 4}
+	var cresp JSApiStreamCreateResponse
+	request(fmt.Sprintf(JSApiStreamCreateT, cfg.Name), cfg, &cresp)
+	require_True(t, cresp.Error == nil)
+	require_True(t, len(cresp.Partitions) == 4)
+
+	// A consumer is created on all partitions, and needs a deliver subject.
+	var ccresp JSApiConsumerCreateResponse
+	request(fmt.Sprintf(JSApiDurableCreateT, "ORDERS", "PULL"),
+		&CreateConsumerRequest{Stream: "ORDERS", Config: ConsumerConfig{Durable: "PULL", AckPolicy: AckExplicit}}, &ccresp)
+	require_Error(t, ccresp.ToError())
+	sub := natsSubSync(t, nc, "deliver")
+	ccresp = JSApiConsumerCreateResponse{}
+	request(fmt.Sprintf(JSApiDurableCreateT, "ORDERS", "C"),
+		&CreateConsumerRequest{Stream: "ORDERS", Config: ConsumerConfig{Durable: "C", DeliverSubject: "deliver", AckPolicy: AckExplicit}}, &ccresp)
+	require_NoError(t, ccresp.ToError())
+
+	const toSend = 100
+	for i := 0; i < toSend; i++ {
+		subj := fmt.Sprintf("orders.%d", i%10)
+		pa, err := js.Publish(subj, []byte("ok"))
+		require_NoError(t, err)
+		require_Equal(t, pa.Stream, streamPartitionName("ORDERS", streamPartitionIndex(subj, 4)))
+	}
+
+	checkInfo := func(msgs uint64) {
+		t.Helper()
+		var resp JSApiStreamInfoResponse
+		request(fmt.Sprintf(JSApiStreamInfoT, "ORDERS"), nil, &resp)
+		require_NoError(t, resp.ToError())
+		require_True(t, resp.State.Msgs == msgs)
+		require_True(t, len(resp.Partitions) == 4)
+		var total uint64
+		for i, pi := range resp.Partitions {
+			require_Equal(t, pi.Name, streamPartitionName("ORDERS", i))
+			total += pi.State.Msgs
+		}
+		require_True(t, total == msgs)
+	}
+	checkInfo(toSend)
+
+	// All messages of a subject are in the same partition.
+	for i := 0; i < 4; i++ {
+		si, err := js.StreamInfo(streamPartitionName("ORDERS", i), &nats.StreamInfoRequest{SubjectsFilter: ">"})
+		require_NoError(t, err)
+		for subj, n := range si.State.Subjects {
+			require_True(t, streamPartitionIndex(subj, 4) == i)
+			require_True(t, n == toSend/10)
+		}
+	}
+
+	for i := 0; i < toSend; i++ {
+		m := natsNexMsg(t, sub, time.Second)
+		m.Ack()
+	}
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		var resp JSApiConsumerInfoResponse
+		request(fmt.Sprintf(JSApiConsumerInfoT, "ORDERS", "C"), nil, &resp)
+		if err := resp.ToError(); err != nil {
+			return err
+		}
+		if resp.Stream != "ORDERS" || resp.Delivered.Consumer != toSend || resp.AckFloor.Consumer != toSend || resp.NumAckPending != 0 {
+			return fmt.Errorf("unexpected consumer info: %+v", resp.ConsumerInfo)
+		}
+		return nil
+	})
+
+	// Partitions are managed through their stream.
+	pcfg := *cfg
+	pcfg.Name = streamPartitionName("ORDERS", 0)
+	var uresp JSApiStreamUpdateResponse
+	request(fmt.Sprintf(JSApiStreamUpdateT, pcfg.Name), &pcfg, &uresp)
+	require_Error(t, uresp.ToError())
+	var dresp JSApiStreamDeleteResponse
+	request(fmt.Sprintf(JSApiStreamDeleteT, pcfg.Name), nil, &dresp)
+	require_Error(t, dresp.ToError())
+
+	// Limits apply to each partition.
+	cfg.MaxMsgs = 10
+	uresp = JSApiStreamUpdateResponse{}
+	request(fmt.Sprintf(JSApiStreamUpdateT, "ORDERS"), cfg, &uresp)
+	require_NoError(t, uresp.ToError())
+	checkInfo(40)
+
+	// Partitions survive a restart.
+	u, _ := url.Parse(s.ClientURL())
+	port, _ := strconv.Atoi(u.Port())
+	sd := s.JetStreamConfig().StoreDir
+	nc.Close()
+	s.Shutdown()
+	s = RunJetStreamServerOnPort(port, sd)
+	defer s.Shutdown()
+	nc, js = jsClientConnect(t, s)
+	defer nc.Close()
+	checkInfo(40)
+	_, err := js.Publish("orders.new", []byte("ok"))
+	require_NoError(t, err)
+	checkInfo(40)
+
+	var cdresp JSApiConsumerDeleteResponse
+	request(fmt.Sprintf(JSApiConsumerDeleteT, "ORDERS", "C"), nil, &cdresp)
+	require_NoError(t, cdresp.ToError())
+
+	dresp = JSApiStreamDeleteResponse{}
+	request(fmt.Sprintf(JSApiStreamDeleteT, "ORDERS"), nil, &dresp)
+	require_NoError(t, dresp.ToError())
+	for i := 0; i < 4; i++ {
+		_, err := js.StreamInfo(streamPartitionName("ORDERS", i))
+		require_Error(t, err, nats.ErrStreamNotFound)
+	}
+}
+
+type testKafkaTopic struct {
+	mu        sync.Mutex
+	recs      map[int32][]*KafkaRecord
+	committed map[int32]int64
+	dials     int
+}
+
+func (kt *testKafkaTopic) Dial(src *KafkaSource) (KafkaClient, error) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	kt.dials++
+	return kt, nil
+}
+
+func (kt *testKafkaTopic) Fetch(offsets map[int32]int64, maxWait time.Duration) ([]*KafkaRecord, error) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	var recs []*KafkaRecord
+	for p, prs := range kt.recs {
+		off, ok := offsets[p]
+		if !ok {
+			off = kt.committed[p]
+		}
+		for _, r := range prs {
+			if r.Offset >= off {
+				recs = append(recs, r)
+			}
+		}
+	}
+	if len(recs) == 0 {
+		kt.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		kt.mu.Lock()
+	}
+	return recs, nil
+}
+
+func (kt *testKafkaTopic) Commit(offsets map[int32]int64) error {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	for p, off := range offsets {
+		kt.committed[p] = off
+	}
+	return nil
+}
+
+func (kt *testKafkaTopic) Close() error { return nil }
+
+func (kt *testKafkaTopic) add(p int32, key, value string) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	kt.recs[p] = append(kt.recs[p], &KafkaRecord{Partition: p, Offset: int64(len(kt.recs[p])), Key: []byte(key), Value: []byte(value)})
+}
+
+func TestJetStreamKafkaSource(t *testing.T) {
+	kt := &testKafkaTopic{recs: make(map[int32][]*KafkaRecord), committed: make(map[int32]int64)}
+	for i := 0; i < 5; i++ {
+		kt.add(0, "k", fmt.Sprintf("p0-%d", i))
+		kt.add(1, _EMPTY_, fmt.Sprintf("p1-%d", i))
+	}
+
+	opts := DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	s := RunServer(&opts)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	// Requires a dialer.
+	cfg := &StreamConfig{Name: "K", Subjects: []string{"orders"}, Storage: FileStorage,
+		Kafka: &KafkaSource{Brokers: []string{"localhost:9092"}, Topic: "orders", Group: "nats"}}
+	req, _ := json.Marshal(cfg)
+	rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, "K"), req, time.Second)
+	require_NoError(t, err)
+	var resp JSApiStreamCreateResponse
+	require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+
+	s.Shutdown()
+	opts.Port = -1
+	opts.JetStreamKafkaDialer = kt
+	s = RunServer(&opts)
+	defer s.Shutdown()
+
+	nc, js = jsClientConnect(t, s)
+	defer nc.Close()
+	addStream(t, nc, cfg)
+
+	checkCount := func(n uint64) {
+		t.Helper()
+		checkFor(t, 2*time.Second, 20*time.Millisecond, func() error {
+			si, err := js.StreamInfo("K")
+			if err != nil {
+				return err
+			}
+			if si.State.Msgs != n {
+				return fmt.Errorf("Expected %d msgs, got %d", n, si.State.Msgs)
+			}
+			return nil
+		})
+	}
+	checkCount(10)
+
+	m, err := js.GetLastMsg("K", "orders")
+	require_NoError(t, err)
+	require_Equal(t, m.Header.Get(JSKafkaTopic), "orders")
+	require_Equal(t, m.Header.Get(JSKafkaOffset), "4")
+	require_Equal(t, m.Header.Get(JSMsgId), fmt.Sprintf("orders:%s:4", m.Header.Get(JSKafkaPartition)))
+
+	kt.mu.Lock()
+	require_True(t, kt.committed[0] == 5 && kt.committed[1] == 5)
+	kt.mu.Unlock()
+
+	// New records keep coming in.
+	kt.add(0, "k", "p0-5")
+	checkCount(11)
+
+	// After a restart we resume from our offsets, even if the group lost its commits.
+	s.Shutdown()
+	kt.mu.Lock()
+	kt.committed = make(map[int32]int64)
+	kt.mu.Unlock()
+	kt.add(1, _EMPTY_, "p1-5")
+
+	s = RunServer(&opts)
+	defer s.Shutdown()
+	nc, js = jsClientConnect(t, s)
+	defer nc.Close()
+	checkCount(12)
+	time.Sleep(100 * time.Millisecond)
+	checkCount(12)
+}
+
+func TestJetStreamStreamSink(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	fail := true
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Fail the first push, it should be retried.
+		if fail {
+			fail = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		user, pass, _ := r.BasicAuth()
+		posted = append(posted, fmt.Sprintf("%s:%s:%s:%s:%s", r.Header.Get("Nats-Subject"), r.Header.Get(JSSinkSequence), body, user, pass))
+	}))
+	defer hs.Close()
+	hu, err := url.Parse(hs.URL)
+	require_NoError(t, err)
+
+	opts := DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	s := RunServer(&opts)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	checkBadReq := func(req []byte) {
+		t.Helper()
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamCreateT, "BAD"), req, time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamCreateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamInvalidConfigF))
+	}
+	checkBad := func(cfg *StreamConfig) {
+		t.Helper()
+		req, _ := json.Marshal(cfg)
+		checkBadReq(req)
+	}
+	// Sinks need to be enabled.
+	checkBad(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, Storage: FileStorage, Sink: &StreamSink{URL: hs.URL}})
+
+	nc.Close()
+	s.Shutdown()
+	opts.Port = -1
+	opts.JetStreamSinks = JSSinkOpts{
+		Enabled:      true,
+		AllowedHosts: []string{hu.Host},
+		Credentials:  map[string]string{"hook": "user:pwd"},
+	}
+	s = RunServer(&opts)
+	defer s.Shutdown()
+	nc, js = jsClientConnect(t, s)
+	defer nc.Close()
+
+	for _, sk := range []*StreamSink{
+		// Only allowed hosts.
+		{URL: fmt.Sprintf("http://127.0.0.2:%s/hook", hu.Port())},
+		{URL: "http://localhost/hook"},
+		// Only credentials the server has.
+		{URL: hs.URL, Credentials: "other"},
+		// Only webhooks and kafka.
+		{URL: fmt.Sprintf("nats://%s", hu.Host)},
+	} {
+		checkBad(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, Storage: FileStorage, Sink: sk})
+	}
+	// Only limits retention.
+	checkBad(&StreamConfig{Name: "BAD", Subjects: []string{"bad"}, Storage: FileStorage, Retention: WorkQueuePolicy, Sink: &StreamSink{URL: hs.URL}})
+	// No credentials in the url, we would not marshal those.
+	checkBadReq([]byte(fmt.Sprintf(`{"name":"BAD","subjects":["bad"],"storage":"file","sink":{"url":"http://user:pwd@%s/hook"}}`, hu.Host)))
+
+	addStream(t, nc, &StreamConfig{Name: "H", Subjects: []string{"h.*"}, Storage: FileStorage,
+		Sink: &StreamSink{URL: hs.URL, Credentials: "hook", FilterSubject: "h.a"}})
+
+	for i := 0; i < 3; i++ {
+		_, err = js.Publish("h.a", []byte(strconv.Itoa(i)))
+		require_NoError(t, err)
+		_, err = js.Publish("h.b", []byte("skip"))
+		require_NoError(t, err)
+	}
+
+	checkPosted := func(n int) {
+		t.Helper()
+		checkFor(t, 5*time.Second, 50*time.Millisecond, func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(posted) != n {
+				return fmt.Errorf("Expected %d posts, got %d", n, len(posted))
+			}
+			return nil
+		})
+	}
+	checkPosted(3)
+	mu.Lock()
+	require_Equal(t, strings.Join(posted, ","), "h.a:1:0:user:pwd,h.a:3:1:user:pwd,h.a:5:2:user:pwd")
+	mu.Unlock()
+
+	mset, err := s.GlobalAccount().lookupStream("H")
+	require_NoError(t, err)
+	checkFor(t, time.Second, 50*time.Millisecond, func() error {
+		if si := mset.sinkInfo(); si == nil || si.Delivered != 6 || si.Error != _EMPTY_ {
+			return fmt.Errorf("Unexpected sink info %+v", si)
+		}
+		return nil
+	})
+
+	// After a restart we resume from our checkpoint, nothing is pushed twice.
+	nc.Close()
+	s.Shutdown()
+	s = RunServer(&opts)
+	defer s.Shutdown()
+	nc, js = jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err = js.Publish("h.a", []byte("3"))
+	require_NoError(t, err)
+	checkPosted(4)
+	time.Sleep(300 * time.Millisecond)
+	mu.Lock()
+	require_True(t, len(posted) == 4)
+	mu.Unlock()
+
+	// Any userinfo in a sink url is never echoed back.
+	b, err := json.Marshal(&StreamConfig{Name: "H", Storage: FileStorage, Sink: &StreamSink{URL: fmt.Sprintf("https://user:pwd@%s/hook", hu.Host)}})
+	require_NoError(t, err)
+	require_False(t, bytes.Contains(b, []byte("pwd")))
+	require_True(t, bytes.Contains(b, []byte(fmt.Sprintf(`"url":"https://%s/hook"`, hu.Host))))
+}
+
+func TestJetStreamStreamSinkConfig(t *testing.T) {
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: 127.0.0.1:-1
+		jetstream: {
+			store_dir: %q
+			sinks: {allowed_hosts: ["hooks.example.com", "10.0.0.1:9092"], credentials: {hook: "s3cr3t"}}
+		}
+	`, t.TempDir())))
+	s, opts := RunServerWithConfig(conf)
+	defer s.Shutdown()
+	require_True(t, opts.JetStreamSinks.Enabled)
+	require_True(t, len(opts.JetStreamSinks.AllowedHosts) == 2)
+	require_Equal(t, opts.JetStreamSinks.Credentials["hook"], "s3cr3t")
+
+	// Credentials are never marshalled.
+	b, err := json.Marshal(opts.JetStreamSinks)
+	require_NoError(t, err)
+	require_False(t, bytes.Contains(b, []byte("s3cr3t")))
+
+	// Enabling sinks without any allowed host is an error.
+	conf = createConfFile(t, []byte(fmt.Sprintf(`
+		jetstream: {store_dir: %q, sinks: {enabled: true}}
+	`, t.TempDir())))
+	_, err = ProcessConfigFile(conf)
+	require_Error(t, err)
+}
+
+func TestJetStreamStreamBulk(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	bulk := func(op string, req *JSApiStreamBulkRequest) *JSApiStreamBulkResponse {
+		t.Helper()
+		b, err := json.Marshal(req)
+		require_NoError(t, err)
+		msg, err := nc.Request(fmt.Sprintf(JSApiStreamBulkT, op), b, 5*time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamBulkResponse
+		require_NoError(t, json.Unmarshal(msg.Data, &resp))
+		return &resp
+	}
+	streams := func() string {
+		t.Helper()
+		var names []string
+		for name := range js.StreamNames() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "X", Subjects: []string{"x"}})
+	require_NoError(t, err)
+
+	// Bad requests.
+	resp := bulk("FOO", &JSApiStreamBulkRequest{Names: []string{"X"}})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamBulkInvalidErrF))
+	resp = bulk(JSStreamBulkCreate, &JSApiStreamBulkRequest{})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamBulkInvalidErrF))
+	resp = bulk(JSStreamBulkDelete, &JSApiStreamBulkRequest{Names: []string{"X", "X"}})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamBulkInvalidErrF))
+	require_Equal(t, resp.Failed, "X")
+
+	// Nothing is created when one of the streams overlaps.
+	resp = bulk(JSStreamBulkCreate, &JSApiStreamBulkRequest{Streams: []*StreamConfig{
+		{Name: "A", Subjects: []string{"a"}, Storage: MemoryStorage},
+		{Name: "B", Subjects: []string{"x"}, Storage: MemoryStorage},
+	}})
+	require_True(t, resp.Error != nil)
+	require_Equal(t, resp.Failed, "B")
+	require_Equal(t, streams(), "X")
+
+	resp = bulk(JSStreamBulkCreate, &JSApiStreamBulkRequest{Streams: []*StreamConfig{
+		{Name: "A", Subjects: []string{"a"}, Storage: MemoryStorage},
+		{Name: "B", Subjects: []string{"b"}, Storage: MemoryStorage},
+		{Name: "C", Subjects: []string{"c"}, Storage: FileStorage},
+	}})
+	require_True(t, resp.Error == nil)
+	require_Equal(t, strings.Join(resp.Streams, ","), "A,B,C")
+	require_Equal(t, streams(), "A,B,C,X")
+
+	// Nothing is updated when one of the updates is not allowed.
+	resp = bulk(JSStreamBulkUpdate, &JSApiStreamBulkRequest{Streams: []*StreamConfig{
+		{Name: "A", Subjects: []string{"a"}, Storage: MemoryStorage, MaxMsgs: 10},
+		{Name: "C", Subjects: []string{"c"}, Storage: FileStorage, MaxConsumers: 5},
+	}})
+	require_True(t, resp.Error != nil)
+	require_Equal(t, resp.Failed, "C")
+	si, err := js.StreamInfo("A")
+	require_NoError(t, err)
+	require_True(t, si.Config.MaxMsgs == -1)
+
+	resp = bulk(JSStreamBulkUpdate, &JSApiStreamBulkRequest{Streams: []*StreamConfig{
+		{Name: "A", Subjects: []string{"a"}, Storage: MemoryStorage, MaxMsgs: 10},
+		{Name: "B", Subjects: []string{"b", "bb"}, Storage: MemoryStorage},
+	}})
+	require_True(t, resp.Error == nil)
+	si, err = js.StreamInfo("A")
+	require_NoError(t, err)
+	require_True(t, si.Config.MaxMsgs == 10)
+	si, err = js.StreamInfo("B")
+	require_NoError(t, err)
+	require_Equal(t, strings.Join(si.Config.Subjects, ","), "b,bb")
+
+	// Nothing is deleted when one of the streams does not exist.
+	resp = bulk(JSStreamBulkDelete, &JSApiStreamBulkRequest{Names: []string{"A", "Z"}})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamNotFoundErr))
+	require_Equal(t, resp.Failed, "Z")
+	require_Equal(t, streams(), "A,B,C,X")
+
+	resp = bulk(JSStreamBulkDelete, &JSApiStreamBulkRequest{Names: []string{"A", "B", "C"}})
+	require_True(t, resp.Error == nil)
+	require_Equal(t, streams(), "X")
+}
+
+func TestJetStreamStreamSubjectHolds(t *testing.T) {
+	for _, st := range []StorageType{FileStorage, MemoryStorage} {
+		t.Run(st.String(), func(t *testing.T) {
+			s := RunBasicJetStreamServer(t)
+			defer s.Shutdown()
+
+			nc, js := jsClientConnect(t, s)
+			defer nc.Close()
+
+			addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo.>"}, Storage: st, MaxMsgs: 5, MaxAge: 250 * time.Millisecond})
+
+			hold := func(req *JSApiStreamHoldRequest) *JSApiStreamHoldResponse {
+				t.Helper()
+				var b []byte
+				if req != nil {
+					var err error
+					b, err = json.Marshal(req)
+					require_NoError(t, err)
+				}
+				resp, err := nc.Request(fmt.Sprintf(JSApiStreamHoldT, "TEST"), b, time.Second)
+				require_NoError(t, err)
+				var hresp JSApiStreamHoldResponse
+				require_NoError(t, json.Unmarshal(resp.Data, &hresp))
+				return &hresp
+			}
+			streamInfo := func() *StreamInfo {
+				t.Helper()
+				resp, err := nc.Request(fmt.Sprintf(JSApiStreamInfoT, "TEST"), nil, time.Second)
+				require_NoError(t, err)
+				var iresp JSApiStreamInfoResponse
+				require_NoError(t, json.Unmarshal(resp.Data, &iresp))
+				require_True(t, iresp.Error == nil)
+				return iresp.StreamInfo
+			}
+
+			// Invalid subjects and releasing unknown holds are rejected.
+			hresp := hold(&JSApiStreamHoldRequest{Subject: "foo.*.>.bar"})
+			require_True(t, hresp.Error != nil)
+			require_True(t, hresp.Error.ErrCode == uint16(JSStreamHoldInvalidErrF))
+			hresp = hold(&JSApiStreamHoldRequest{Subject: "foo.held", Release: true})
+			require_True(t, hresp.Error != nil)
+			require_True(t, hresp.Error.ErrCode == uint16(JSStreamHoldNotFoundErr))
+
+			hresp = hold(&JSApiStreamHoldRequest{Subject: "foo.held", Reason: "litigation"})
+			require_True(t, hresp.Error == nil)
+			require_True(t, len(hresp.Holds) == 1)
+			require_Equal(t, hresp.Holds[0].Subject, "foo.held")
+			require_Equal(t, hresp.Holds[0].Reason, "litigation")
+
+			for i := 0; i < 3; i++ {
+				_, err := js.Publish("foo.held", []byte("held"))
+				require_NoError(t, err)
+			}
+			for i := 0; i < 10; i++ {
+				_, err := js.Publish("foo.other", []byte("ok"))
+				require_NoError(t, err)
+			}
+
+			// The limits removed the oldest messages not on hold.
+			si := streamInfo()
+			require_True(t, si.State.Msgs == 5)
+			require_True(t, si.State.FirstSeq == 1)
+			require_True(t, len(si.Holds) == 1)
+			require_Equal(t, si.Holds[0].Subject, "foo.held")
+			for seq := uint64(1); seq <= 3; seq++ {
+				m, err := js.GetMsg("TEST", seq)
+				require_NoError(t, err)
+				require_Equal(t, m.Subject, "foo.held")
+			}
+
+			// Purges skip held subjects.
+			require_NoError(t, js.PurgeStream("TEST"))
+			si = streamInfo()
+			require_True(t, si.State.Msgs == 3)
+			require_True(t, si.State.NumSubjects == 1)
+
+			// So does max age.
+			time.Sleep(500 * time.Millisecond)
+			si = streamInfo()
+			require_True(t, si.State.Msgs == 3)
+
+			// An empty request lists the holds.
+			hresp = hold(nil)
+			require_True(t, hresp.Error == nil)
+			require_True(t, len(hresp.Holds) == 1)
+
+			// Holds survive a restart of file based streams.
+			if st == FileStorage {
+				sd := s.JetStreamConfig().StoreDir
+				nc.Close()
+				s.Shutdown()
+				s = RunJetStreamServerOnPort(-1, sd)
+				defer s.Shutdown()
+				nc, js = jsClientConnect(t, s)
+				defer nc.Close()
+
+				si = streamInfo()
+				require_True(t, si.State.Msgs == 3)
+				require_True(t, len(si.Holds) == 1)
+				require_Equal(t, si.Holds[0].Reason, "litigation")
+			}
+
+			// Releasing the hold applies max age to its messages right away.
+			hresp = hold(&JSApiStreamHoldRequest{Subject: "foo.held", Release: true})
+			require_True(t, hresp.Error == nil)
+			require_True(t, len(hresp.Holds) == 0)
+			si = streamInfo()
+			require_True(t, si.State.Msgs == 0)
+			require_True(t, len(si.Holds) == 0)
+			_, err := js.Publish("foo.held", []byte("ok"))
+			require_NoError(t, err)
+		})
+	}
+}
+
+func TestJetStreamAPIRoutedSystemRequestsPriority(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	js := s.getJetStream()
+	sacc := s.SystemAccount()
+	require_True(t, sacc != nil)
+
+	// Swap in queues nobody processes so we can see where requests go.
+	s.mu.Lock()
+	queue, squeue := s.newIPQueue("test tenant"), s.newIPQueue("test system")
+	s.jsAPIRoutedReqs, s.jsAPIRoutedSysReqs = queue, squeue
+	s.mu.Unlock()
+
+	dispatch := func(account string) {
+		t.Helper()
+		ci, err := json.Marshal(&ClientInfo{Account: account})
+		require_NoError(t, err)
+		hdr := genHeader(nil, ClientInfoHdr, string(ci))
+		rc := &client{srv: s, kind: ROUTER}
+		rc.pa.hdr = len(hdr)
+		js.apiDispatch(nil, rc, sacc, JSApiAccountInfo, "reply", append(hdr, "{}\r\n"...))
+	}
+
+	dispatch(globalAccountName)
+	dispatch(globalAccountName)
+	dispatch(sacc.Name)
+
+	require_True(t, queue.len() == 2)
+	require_True(t, squeue.len() == 1)
+}
+
+func TestJetStreamConsumerMaxBytesPending(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	addStream(t, nc, &StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Storage: MemoryStorage})
+	mset, err := s.GlobalAccount().lookupStream("TEST")
+	require_NoError(t, err)
+
+	_, err = mset.addConsumer(&ConsumerConfig{Durable: "bad", AckPolicy: AckExplicit, MaxBytesPending: -1})
+	require_Error(t, err)
+	_, err = mset.addConsumer(&ConsumerConfig{DeliverSubject: "d", AckPolicy: AckNone, MaxBytesPending: 100})
+	require_True(t, IsNatsErr(err, JSConsumerMaxPendingAckPolicyRequiredErr))
+
+	payload := make([]byte, 100)
+	for i := 0; i < 10; i++ {
+		_, err = js.Publish("foo", payload)
+		require_NoError(t, err)
+	}
+
+	o, err := mset.addConsumer(&ConsumerConfig{Durable: "dlc", AckPolicy: AckExplicit, MaxBytesPending: 250})
+	require_NoError(t, err)
+
+	sub, err := js.PullSubscribe("foo", "dlc", nats.Bind("TEST", "dlc"))
+	require_NoError(t, err)
+	defer sub.Unsubscribe()
+
+	fetch := func(expected int) []*nats.Msg {
+		t.Helper()
+		msgs, err := sub.Fetch(10, nats.MaxWait(250*time.Millisecond))
+		if expected == 0 {
+			require_True(t, err == nats.ErrTimeout)
+		} else {
+			require_NoError(t, err)
+		}
+		require_True(t, len(msgs) == expected)
+		return msgs
+	}
+
+	// The last message delivered takes us over the limit.
+	msgs := fetch(3)
+	require_True(t, o.info().NumAckPendingBytes == 300)
+	fetch(0)
+
+	// Acking frees up room for another one.
+	require_NoError(t, msgs[0].AckSync())
+	require_True(t, o.info().NumAckPendingBytes == 200)
+	msgs = append(msgs[1:], fetch(1)...)
+	for _, m := range msgs {
+		require_NoError(t, m.AckSync())
+	}
+
+	// Raising the limit lets more through.
+	cfg := o.config()
+	cfg.MaxBytesPending = 1000
+	require_NoError(t, o.updateConfig(&cfg))
+	fetch(6)
+	require_True(t, o.info().NumAckPendingBytes == 600)
+}
+
+func TestJetStreamDeleteMarkerTTL(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, apiErr := addStreamWithError(t, nc, &StreamConfig{Name: "BAD", Storage: MemoryStorage, MaxMsgsPer: 5, DeleteMarkerTTL: time.Second})
+	require_True(t, apiErr != nil && apiErr.ErrCode == uint16(JSStreamInvalidConfigF))
+
+	addStream(t, nc, &StreamConfig{Name: "KV", Subjects: []string{"kv.>"}, Storage: FileStorage, MaxMsgsPer: 1})
+
+	putMarker := func(key, op string) {
+		t.Helper()
+		m := nats.NewMsg("kv." + key)
+		m.Header.Set(kvOperationHdr, op)
+		_, err := js.PublishMsg(m)
+		require_NoError(t, err)
+	}
+	numMsgs := func() uint64 {
+		t.Helper()
+		si, err := js.StreamInfo("KV")
+		require_NoError(t, err)
+		return si.State.Msgs
+	}
+
+	_, err := js.Publish("kv.a", []byte("1"))
+	require_NoError(t, err)
+	putMarker("b", kvOpDel)
+
+	// Markers stored before the TTL was set are picked up as well.
+	mset, err := s.GlobalAccount().lookupStream("KV")
+	require_NoError(t, err)
+	cfg := mset.config()
+	cfg.DeleteMarkerTTL = 250 * time.Millisecond
+	updateStream(t, nc, &cfg)
+
+	putMarker("c", kvOpPurge)
+	// A marker replaced by a new value is gone already.
+	putMarker("d", kvOpDel)
+	_, err = js.Publish("kv.d", []byte("2"))
+	require_NoError(t, err)
+	require_True(t, numMsgs() == 4)
+
+	checkFor(t, 2*time.Second, 50*time.Millisecond, func() error {
+		if n := numMsgs(); n != 2 {
+			return fmt.Errorf("expected 2 msgs, got %d", n)
+		}
+		return nil
+	})
+	for _, subj := range []string{"kv.a", "kv.d"} {
+		_, err = js.GetLastMsg("KV", subj)
+		require_NoError(t, err)
+	}
+}
+
+func TestJetStreamStreamChunking(t *testing.T) {
+	opts := DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	opts.MaxPayload = 4096
+	s := RunServer(&opts)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, apiErr := addStreamWithError(t, nc, &StreamConfig{Name: "BAD", Storage: MemoryStorage, ChunkSize: 100})
+	require_True(t, apiErr != nil && apiErr.ErrCode == uint16(JSStreamInvalidConfigF))
+
+	addStream(t, nc, &StreamConfig{Name: "CHUNK", Subjects: []string{"chunk.>"}, Storage: FileStorage, ChunkSize: 1024, MaxMsgSize: 16 * 1024})
+	mset, err := s.GlobalAccount().lookupStream("CHUNK")
+	require_NoError(t, err)
+
+	small := bytes.Repeat([]byte("a"), 3000)
+	m := nats.NewMsg("chunk.small")
+	m.Header.Set("X-Test", "1")
+	m.Data = small
+	pa, err := js.PublishMsg(m)
+	require_NoError(t, err)
+	// Acknowledged with the last chunk.
+	require_True(t, pa.Sequence == 3)
+
+	// Over our max payload, which only this stream allows.
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require_NoError(t, err)
+	defer conn.Close()
+	cr := bufio.NewReader(conn)
+	_, err = cr.ReadString('\n')
+	require_NoError(t, err)
+	large := bytes.Repeat([]byte("b"), 10000)
+	_, err = conn.Write([]byte(fmt.Sprintf("CONNECT {\"verbose\":false}\r\nPUB chunk.large %d\r\n%s\r\nPING\r\n", len(large), large)))
+	require_NoError(t, err)
+	l, err := cr.ReadString('\n')
+	require_NoError(t, err)
+	require_Equal(t, l, "PONG\r\n")
+
+	checkFor(t, time.Second, 50*time.Millisecond, func() error {
+		if state := mset.state(); state.Msgs != 13 {
+			return fmt.Errorf("expected 13 chunks, got %d", state.Msgs)
+		}
+		return nil
+	})
+
+	_, err = conn.Write([]byte(fmt.Sprintf("PUB other %d\r\n%s\r\n", len(large), large)))
+	require_NoError(t, err)
+	l, err = cr.ReadString('\n')
+	require_NoError(t, err)
+	require_True(t, strings.HasPrefix(l, "-ERR 'Maximum Payload Violation'"))
+
+	_, err = mset.addConsumer(&ConsumerConfig{Durable: "dlc", AckPolicy: AckExplicit, ReassembleChunks: true})
+	require_NoError(t, err)
+	sub, err := js.PullSubscribe("chunk.>", "dlc", nats.Bind("CHUNK", "dlc"))
+	require_NoError(t, err)
+	defer sub.Unsubscribe()
+
+	msgs, err := sub.Fetch(10, nats.MaxWait(250*time.Millisecond))
+	require_NoError(t, err)
+	require_True(t, len(msgs) == 2)
+	require_True(t, bytes.Equal(msgs[0].Data, small))
+	require_Equal(t, msgs[0].Header.Get("X-Test"), "1")
+	require_Equal(t, msgs[0].Header.Get(JSChunkId), _EMPTY_)
+	require_True(t, bytes.Equal(msgs[1].Data, large))
+
+	// Without reassembly the chunks are delivered as stored.
+	_, err = mset.addConsumer(&ConsumerConfig{Durable: "raw", AckPolicy: AckNone})
+	require_NoError(t, err)
+	rsub, err := js.PullSubscribe("chunk.>", "raw", nats.Bind("CHUNK", "raw"))
+	require_NoError(t, err)
+	defer rsub.Unsubscribe()
+	msgs, err = rsub.Fetch(20, nats.MaxWait(250*time.Millisecond))
+	require_NoError(t, err)
+	require_True(t, len(msgs) == 13)
+	require_Equal(t, msgs[0].Header.Get(JSChunkSize), "3000")
+	require_Equal(t, msgs[2].Header.Get(JSChunkSeq), "3")
+	require_Equal(t, msgs[2].Header.Get(JSChunkTotal), "3")
+
+	// With interest retention acking the message removes all of its chunks.
+	addStream(t, nc, &StreamConfig{Name: "INTEREST", Subjects: []string{"interest"}, Storage: MemoryStorage, Retention: InterestPolicy, ChunkSize: 1024})
+	imset, err := s.GlobalAccount().lookupStream("INTEREST")
+	require_NoError(t, err)
+	_, err = imset.addConsumer(&ConsumerConfig{Durable: "dlc", AckPolicy: AckExplicit, ReassembleChunks: true})
+	require_NoError(t, err)
+	isub, err := js.PullSubscribe("interest", "dlc", nats.Bind("INTEREST", "dlc"))
+	require_NoError(t, err)
+	defer isub.Unsubscribe()
+
+	_, err = js.Publish("interest", small)
+	require_NoError(t, err)
+	require_True(t, imset.state().Msgs == 3)
+	msgs, err = isub.Fetch(1)
+	require_NoError(t, err)
+	require_True(t, bytes.Equal(msgs[0].Data, small))
+	require_NoError(t, msgs[0].AckSync())
+	checkFor(t, time.Second, 50*time.Millisecond, func() error {
+		if state := imset.state(); state.Msgs != 0 {
+			return fmt.Errorf("expected no chunks left, got %d", state.Msgs)
+		}
+		return nil
+	})
+}
+
+func TestJetStreamStreamGenerate(t *testing.T) {
+	opts := DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	s := RunServer(&opts)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	addStream(t, nc, &StreamConfig{Name: "GEN", Subjects: []string{"gen.>"}, Storage: FileStorage})
+
+	generate := func(req *JSApiStreamGenerateRequest) *JSApiStreamGenerateResponse {
+		t.Helper()
+		b, _ := json.Marshal(req)
+		rmsg, err := nc.Request(fmt.Sprintf(JSApiStreamGenerateT, "GEN"), b, 5*time.Second)
+		require_NoError(t, err)
+		var resp JSApiStreamGenerateResponse
+		require_NoError(t, json.Unmarshal(rmsg.Data, &resp))
+		return &resp
+	}
+
+	// Disabled by default.
+	resp := generate(&JSApiStreamGenerateRequest{Msgs: 10})
+	require_True(t, resp.Error != nil && resp.Error.ErrCode == uint16(JSStreamGeneratorDisabledErr))
+
+	s.optsMu.Lock()
+	s.opts.JetStreamDataGenerator = true
+	s.optsMu.Unlock()
+
+	resp = generate(&JSApiStreamGenerateRequest{Msgs: 10, Subject: "other.*"})
+	require_True(t, resp.Error != nil)
+	resp = generate(&JSApiStreamGenerateRequest{Msgs: 10, Distribution: "bogus"})
+	require_True(t, resp.Error != nil)
+
+	sub, err := js.SubscribeSync("gen.>")
+	require_NoError(t, err)
+	defer sub.Unsubscribe()
+
+	resp = generate(&JSApiStreamGenerateRequest{Msgs: 10000, Subject: "gen.seq.*", Keys: 10, PayloadSize: 16, MaxPayloadSize: 64})
+	require_True(t, resp.Error == nil)
+	require_True(t, resp.Generated == 10000 && resp.FirstSeq == 1 && resp.LastSeq == 10000)
+
+	resp = generate(&JSApiStreamGenerateRequest{Msgs: 5000, Subject: "gen.zipf.*", Keys: 100, Distribution: "zipf", PayloadSize: 32, Seed: 22})
+	require_True(t, resp.Error == nil)
+	require_True(t, resp.Generated == 5000 && resp.FirstSeq == 10001 && resp.LastSeq == 15000)
+
+	si, err := js.StreamInfo("GEN", &nats.StreamInfoRequest{SubjectsFilter: "gen.seq.>"})
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 15000)
+	require_True(t, len(si.State.Subjects) == 10)
+	for _, n := range si.State.Subjects {
+		require_True(t, n == 1000)
+	}
+
+	// Consumers pick up the generated messages.
+	m, err := sub.NextMsg(time.Second)
+	require_NoError(t, err)
+	require_Equal(t, m.Subject, "gen.seq.0")
+	require_True(t, len(m.Data) >= 16 && len(m.Data) <= 64)
+	checkFor(t, 5*time.Second, 50*time.Millisecond, func() error {
+		if n, _, _ := sub.Pending(); n < 15000-1 {
+			return fmt.Errorf("expected all messages pending, got %d", n)
+		}
+		return nil
+	})
+}
+
+func TestJetStreamStreamIdlePause(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, apiErr := addStreamWithError(t, nc, &StreamConfig{Name: "BAD", Subjects: []string{"bad"}, Storage: FileStorage, IdlePause: 100 * time.Millisecond})
+	require_True(t, apiErr != nil)
+
+	addStream(t, nc, &StreamConfig{Name: "IDLE", Subjects: []string{"idle.*"}, Storage: FileStorage, IdlePause: time.Second})
+
+	sub, err := js.PullSubscribe("idle.*", "dlc", nats.AckWait(250*time.Millisecond))
+	require_NoError(t, err)
+	defer sub.Unsubscribe()
+
+	_, err = js.Publish("idle.1", []byte("hello"))
+	require_NoError(t, err)
+
+	mset, err := s.GlobalAccount().lookupStream("IDLE")
+	require_NoError(t, err)
+	o := mset.lookupConsumer("dlc")
+	require_True(t, o != nil)
+
+	// Keep the message pending so we have a redelivery timer to pause.
+	msgs, err := sub.Fetch(1, nats.MaxWait(time.Second))
+	require_NoError(t, err)
+	require_True(t, len(msgs) == 1)
+
+	checkFor(t, 3*time.Second, 100*time.Millisecond, func() error {
+		if !mset.isIdle() {
+			return fmt.Errorf("stream not idle")
+		}
+		return nil
+	})
+	require_True(t, o.info().Idle)
+	o.mu.RLock()
+	ptmr := o.ptmr
+	o.mu.RUnlock()
+	require_True(t, ptmr == nil)
+
+	si, err := js.StreamInfo("IDLE")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 1)
+
+	// A fetch resumes us, and the pending message gets redelivered.
+	msgs, err = sub.Fetch(1, nats.MaxWait(2*time.Second))
+	require_NoError(t, err)
+	require_True(t, len(msgs) == 1)
+	require_Equal(t, msgs[0].Subject, "idle.1")
+	require_NoError(t, msgs[0].Ack())
+	require_True(t, !mset.isIdle())
+	require_True(t, !o.info().Idle)
+
+	// Goes idle again, and a publish resumes us.
+	checkFor(t, 3*time.Second, 100*time.Millisecond, func() error {
+		if !mset.isIdle() {
+			return fmt.Errorf("stream not idle")
+		}
+		return nil
+	})
+	_, err = js.Publish("idle.2", []byte("world"))
+	require_NoError(t, err)
+	require_True(t, !mset.isIdle())
+	require_True(t, !o.info().Idle)
+}
@@ -0,0 +1,300 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/highwayhash"
+)
+
+// This is where we keep deleted streams for accounts with a trash retention.
+const trashDir = "__trash__"
+
+// TrashedStream is a deleted stream that can still be restored.
+type TrashedStream struct {
+	Name    string    `json:"name"`
+	Deleted time.Time `json:"deleted"`
+	Expires time.Time `json:"expires"`
+}
+
+// Returns how long deleted streams are kept in the trash, 0 if they are deleted right away.
+func (a *Account) trashRetention() time.Duration {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.jsTrash
+}
+
+// Deleted streams are kept in the trash of the server they were deleted on,
+// which can not be honored for replicated streams. So a trash retention is
+// only supported for servers in standalone mode.
+func validateTrashRetention(o *Options, clustered bool) error {
+	if !clustered {
+		return nil
+	}
+	for _, acc := range o.Accounts {
+		if acc.jsTrash > 0 {
+			return fmt.Errorf("JetStream trash retention for account %q is not supported in clustered mode", acc.Name)
+		}
+	}
+	return nil
+}
+
+// Trashed stream directories are named after the stream and when it was deleted.
+func trashEntryName(stream string, deleted time.Time) string {
+	return fmt.Sprintf("%s.%d", stream, deleted.UnixNano())
+}
+
+func parseTrashEntryName(name string) (string, time.Time, bool) {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 {
+		return _EMPTY_, time.Time{}, false
+	}
+	ts, err := strconv.ParseInt(name[i+1:], 10, 64)
+	if err != nil {
+		return _EMPTY_, time.Time{}, false
+	}
+	return name[:i], time.Unix(0, ts).UTC(), true
+}
+
+// trash will stop the stream and move its storage to the account's trash.
+// It can be restored from there until the account's trash retention expires.
+func (mset *stream) trash() error {
+	mset.mu.RLock()
+	js, jsa, cfg, tier := mset.js, mset.jsa, mset.cfg, mset.tier
+	mset.mu.RUnlock()
+
+	if jsa == nil {
+		return NewJSNotEnabledForAccountError()
+	}
+
+	var state StreamState
+	mset.store.FastState(&state)
+
+	// To everyone else the stream is gone.
+	mset.mu.Lock()
+	mset.sendDeleteAdvisoryLocked()
+	mset.mu.Unlock()
+
+	if err := mset.stop(false, false); err != nil {
+		return err
+	}
+	// Streams in the trash do not count against the account.
+	jsa.updateUsage(tier, cfg.Storage, -int64(state.Bytes))
+	js.releaseStreamResources(&cfg)
+
+	tdir := filepath.Join(jsa.storeDir, trashDir)
+	if err := os.MkdirAll(tdir, defaultDirPerms); err != nil {
+		return fmt.Errorf("could not create trash directory - %v", err)
+	}
+	sdir := filepath.Join(jsa.storeDir, streamsDir, cfg.Name)
+	if err := os.Rename(sdir, filepath.Join(tdir, trashEntryName(cfg.Name, time.Now()))); err != nil {
+		return err
+	}
+	jsa.purgeTrash()
+	return nil
+}
+
+// Returns the streams in the trash, oldest first.
+func (jsa *jsAccount) trashedStreams() []*TrashedStream {
+	retention := jsa.acc().trashRetention()
+	fis, _ := os.ReadDir(filepath.Join(jsa.storeDir, trashDir))
+	var trashed []*TrashedStream
+	for _, fi := range fis {
+		if name, deleted, ok := parseTrashEntryName(fi.Name()); ok {
+			trashed = append(trashed, &TrashedStream{Name: name, Deleted: deleted, Expires: deleted.Add(retention)})
+		}
+	}
+	sort.Slice(trashed, func(i, j int) bool { return trashed[i].Deleted.Before(trashed[j].Deleted) })
+	return trashed
+}
+
+// Removes any expired streams from the trash and schedules the next check.
+func (jsa *jsAccount) purgeTrash() {
+	retention := jsa.acc().trashRetention()
+	tdir := filepath.Join(jsa.storeDir, trashDir)
+
+	var next time.Duration
+	for _, ts := range jsa.trashedStreams() {
+		if left := time.Until(ts.Expires); left > 0 && retention > 0 {
+			if next == 0 || left < next {
+				next = left
+			}
+			continue
+		}
+		os.RemoveAll(filepath.Join(tdir, trashEntryName(ts.Name, ts.Deleted)))
+	}
+	// No op if not empty.
+	os.Remove(tdir)
+
+	jsa.mu.Lock()
+	defer jsa.mu.Unlock()
+	if jsa.trashTmr != nil {
+		jsa.trashTmr.Stop()
+		jsa.trashTmr = nil
+	}
+	if next > 0 {
+		jsa.trashTmr = time.AfterFunc(next, jsa.purgeTrash)
+	}
+}
+
+// undeleteStream will restore the most recently deleted stream with this name from the trash.
+func (a *Account) undeleteStream(name string) (*stream, error) {
+	s, jsa, err := a.checkForJetStream()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.lookupStream(name); err == nil {
+		return nil, NewJSStreamNameExistError()
+	}
+
+	var ts *TrashedStream
+	for _, t := range jsa.trashedStreams() {
+		if t.Name == name {
+			ts = t
+		}
+	}
+	if ts == nil {
+		return nil, NewJSStreamNotFoundError()
+	}
+
+	tdir := filepath.Join(jsa.storeDir, trashDir, trashEntryName(ts.Name, ts.Deleted))
+	cfg, err := s.readStreamMeta(a, tdir, name)
+	if err != nil {
+		return nil, err
+	}
+	if apiErr := a.jsNonClusteredStreamLimitsCheck(&cfg.StreamConfig); apiErr != nil {
+		return nil, apiErr
+	}
+
+	sdir := filepath.Join(jsa.storeDir, streamsDir, name)
+	if err := os.MkdirAll(filepath.Dir(sdir), defaultDirPerms); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tdir, sdir); err != nil {
+		return nil, err
+	}
+	mset, err := a.addStream(&cfg.StreamConfig)
+	if err != nil {
+		// Put it back so it can be tried again.
+		os.Rename(sdir, tdir)
+		return nil, err
+	}
+	jsa.purgeTrash()
+	if !cfg.Created.IsZero() {
+		mset.setCreatedTime(cfg.Created)
+	}
+
+	// Now do the consumers.
+	odir := filepath.Join(sdir, consumerDir)
+	ofis, _ := os.ReadDir(odir)
+	for _, ofi := range ofis {
+		ocfg, err := s.readConsumerMeta(a, filepath.Join(odir, ofi.Name()), name+tsep+ofi.Name())
+		if err != nil {
+			s.Warnf("Error restoring consumer %q for stream '%s > %s': %v", ofi.Name(), a.Name, name, err)
+			continue
+		}
+		isEphemeral := !isDurableConsumer(&ocfg.ConsumerConfig)
+		if isEphemeral {
+			ocfg.ConsumerConfig.Durable = ofi.Name()
+		}
+		o, err := mset.addConsumerWithAssignment(&ocfg.ConsumerConfig, _EMPTY_, nil, true)
+		if err != nil {
+			s.Warnf("Error restoring consumer %q for stream '%s > %s': %v", ofi.Name(), a.Name, name, err)
+			continue
+		}
+		if isEphemeral {
+			o.switchToEphemeral()
+		}
+		if !ocfg.Created.IsZero() {
+			o.setCreatedTime(ocfg.Created)
+		}
+		o.mu.Lock()
+		err = o.readStoredState(mset.lastSeq())
+		o.mu.Unlock()
+		if err != nil {
+			s.Warnf("Error restoring consumer %q state for stream '%s > %s': %v", ofi.Name(), a.Name, name, err)
+		}
+	}
+	return mset, nil
+}
+
+// Reads the stream metafile in dir, checking its checksum and decrypting it if needed.
+func (s *Server) readStreamMeta(a *Account, dir, name string) (*FileStreamInfo, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, JetStreamMetaFile))
+	if err != nil {
+		return nil, err
+	}
+	sum, err := os.ReadFile(filepath.Join(dir, JetStreamMetaFileSum))
+	if err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256([]byte(name))
+	hh, err := highwayhash.New64(key[:])
+	if err != nil {
+		return nil, err
+	}
+	hh.Write(buf)
+	if checksum := hex.EncodeToString(hh.Sum(nil)); checksum != string(sum) {
+		return nil, fmt.Errorf("stream metafile checksums do not match %q vs %q", sum, checksum)
+	}
+	if buf, err = s.decryptMetaFile(a, dir, buf, name); err != nil {
+		return nil, err
+	}
+	var cfg FileStreamInfo
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Name != name {
+		return nil, fmt.Errorf("stream names do not match")
+	}
+	return &cfg, nil
+}
+
+// Reads the consumer metafile in dir, decrypting it if needed.
+func (s *Server) readConsumerMeta(a *Account, dir, context string) (*FileConsumerInfo, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, JetStreamMetaFile))
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = s.decryptMetaFile(a, dir, buf, context); err != nil {
+		return nil, err
+	}
+	var cfg FileConsumerInfo
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (s *Server) decryptMetaFile(a *Account, dir string, buf []byte, context string) ([]byte, error) {
+	key, err := os.ReadFile(filepath.Join(dir, JetStreamMetaFileKey))
+	if err != nil {
+		// Not encrypted.
+		return buf, nil
+	}
+	if len(key) < minMetaKeySize {
+		return nil, fmt.Errorf("bad encryption key length of %d", len(key))
+	}
+	return s.decryptMeta(s.getOpts().JetStreamCipher, key, buf, a.Name, context)
+}
@@ -0,0 +1,562 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/highwayhash"
+)
+
+// StoreVerifyReport is the result of verifying a JetStream store directory.
+type StoreVerifyReport struct {
+	StoreDir string                `json:"store_dir"`
+	Start    time.Time             `json:"start"`
+	Duration time.Duration         `json:"duration"`
+	Streams  []*StreamVerifyReport `json:"streams,omitempty"`
+	Errors   []string              `json:"errors,omitempty"`
+	OK       bool                  `json:"ok"`
+}
+
+// StreamVerifyReport is the result of verifying a single file based stream.
+type StreamVerifyReport struct {
+	Account   string                  `json:"account"`
+	Stream    string                  `json:"stream"`
+	Blocks    int                     `json:"blocks"`
+	Msgs      uint64                  `json:"messages"`
+	Bytes     uint64                  `json:"bytes"`
+	FirstSeq  uint64                  `json:"first_seq"`
+	LastSeq   uint64                  `json:"last_seq"`
+	Errors    []string                `json:"errors,omitempty"`
+	Consumers []*ConsumerVerifyReport `json:"consumers,omitempty"`
+}
+
+// ConsumerVerifyReport is the result of verifying the state of a durable consumer.
+type ConsumerVerifyReport struct {
+	Name      string       `json:"name"`
+	Delivered SequenceInfo `json:"delivered"`
+	AckFloor  SequenceInfo `json:"ack_floor"`
+	Pending   int          `json:"num_pending"`
+	Errors    []string     `json:"errors,omitempty"`
+}
+
+// VerifyStore will check all message checksums, per subject indexes and consumer states
+// of the file based streams in the store directory of opts, without modifying anything.
+// The returned error is only set if the store directory itself could not be read.
+func VerifyStore(opts *Options) (*StoreVerifyReport, error) {
+	if opts.StoreDir == _EMPTY_ {
+		return nil, fmt.Errorf("no store directory configured")
+	}
+	// Allow pointing at either the configured store directory or the jetstream directory under it.
+	sdir := opts.StoreDir
+	if filepath.Base(sdir) != JetStreamStoreDir {
+		if fi, err := os.Stat(filepath.Join(sdir, JetStreamStoreDir)); err == nil && fi.IsDir() {
+			sdir = filepath.Join(sdir, JetStreamStoreDir)
+		}
+	}
+	fis, err := os.ReadDir(sdir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{opts: opts}
+	r := &StoreVerifyReport{StoreDir: sdir, Start: time.Now().UTC()}
+	for _, fi := range fis {
+		if !fi.IsDir() || fi.Name() == snapStagingDir {
+			continue
+		}
+		accName := fi.Name()
+		mdir := filepath.Join(sdir, accName, streamsDir)
+		sfis, err := os.ReadDir(mdir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				r.Errors = append(r.Errors, fmt.Sprintf("account %q: %v", accName, err))
+			}
+			continue
+		}
+		for _, sfi := range sfis {
+			if !sfi.IsDir() {
+				continue
+			}
+			if sr := s.verifyStream(accName, filepath.Join(mdir, sfi.Name())); sr != nil {
+				r.Streams = append(r.Streams, sr)
+			}
+		}
+	}
+
+	r.OK = len(r.Errors) == 0
+	for _, sr := range r.Streams {
+		if len(sr.Errors) > 0 {
+			r.OK = false
+		}
+		for _, cr := range sr.Consumers {
+			if len(cr.Errors) > 0 {
+				r.OK = false
+			}
+		}
+	}
+	r.Duration = time.Since(r.Start)
+	return r, nil
+}
+
+// Will verify a stream directory. Returns nil for memory based streams.
+func (s *Server) verifyStream(accName, mdir string) *StreamVerifyReport {
+	sr := &StreamVerifyReport{Account: accName, Stream: filepath.Base(mdir)}
+	errorf := func(format string, args ...interface{}) {
+		sr.Errors = append(sr.Errors, fmt.Sprintf(format, args...))
+	}
+
+	buf, err := os.ReadFile(filepath.Join(mdir, JetStreamMetaFile))
+	if err != nil {
+		// Memory streams may only have their dedupe state here.
+		if _, derr := os.Stat(filepath.Join(mdir, dedupeStateFile)); derr == nil {
+			return nil
+		}
+		errorf("could not read stream metafile: %v", err)
+		return sr
+	}
+	if err := checkMetaSum(mdir, sr.Stream, buf); err != nil {
+		errorf("stream metafile: %v", err)
+		return sr
+	}
+
+	// Check if we are encrypted, and with what.
+	sc := s.getOpts().JetStreamCipher
+	if key, err := os.ReadFile(filepath.Join(mdir, JetStreamMetaFileKey)); err == nil {
+		nbuf, err := s.decryptMeta(sc, key, buf, accName, sr.Stream)
+		if err != nil && err != errNoEncryption && err != errBadKeySize {
+			osc := AES
+			if sc == AES {
+				osc = ChaCha
+			}
+			if nbuf, err = s.decryptMeta(osc, key, buf, accName, sr.Stream); err == nil {
+				sc = osc
+			}
+		}
+		if err != nil {
+			errorf("could not decrypt stream metafile: %v", err)
+			return sr
+		}
+		buf = nbuf
+	}
+
+	var cfg FileStreamInfo
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		errorf("could not unmarshal stream metafile: %v", err)
+		return sr
+	}
+	if cfg.Storage != FileStorage {
+		return nil
+	}
+	sr.Stream = cfg.Name
+
+	fs := &fileStore{
+		fcfg: FileStoreConfig{StoreDir: mdir, Cipher: sc},
+		cfg:  cfg,
+		prf:  s.jsKeyGen(accName),
+	}
+	fs.verifyMsgs(sr)
+	fs.verifyConsumers(sr)
+	return sr
+}
+
+// Will check the checksum file for a metafile.
+func checkMetaSum(dir, name string, buf []byte) error {
+	sum, err := os.ReadFile(filepath.Join(dir, JetStreamMetaFileSum))
+	if err != nil {
+		return err
+	}
+	key := sha256.Sum256([]byte(name))
+	hh, err := highwayhash.New64(key[:])
+	if err != nil {
+		return err
+	}
+	hh.Write(buf)
+	if checksum := hex.EncodeToString(hh.Sum(nil)); checksum != string(sum) {
+		return fmt.Errorf("checksums do not match %q vs %q", sum, checksum)
+	}
+	return nil
+}
+
+// Will verify all message blocks of the stream.
+func (fs *fileStore) verifyMsgs(sr *StreamVerifyReport) {
+	mdir := filepath.Join(fs.fcfg.StoreDir, msgDir)
+	fis, err := os.ReadDir(mdir)
+	if err != nil {
+		sr.Errors = append(sr.Errors, fmt.Sprintf("could not read message directory: %v", err))
+		return
+	}
+	var indexes []uint32
+	for _, fi := range fis {
+		var index uint32
+		if n, err := fmt.Sscanf(fi.Name(), blkScan, &index); err == nil && n == 1 {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	var prev *msgBlock
+	for _, index := range indexes {
+		mb, errs := fs.verifyMsgBlock(index)
+		for _, err := range errs {
+			sr.Errors = append(sr.Errors, fmt.Sprintf("block %d: %s", index, err))
+		}
+		if mb == nil {
+			continue
+		}
+		sr.Blocks++
+		if mb.msgs > 0 {
+			if prev != nil && mb.first.seq <= prev.last.seq {
+				sr.Errors = append(sr.Errors, fmt.Sprintf("block %d: first sequence %d overlaps previous block ending at %d", index, mb.first.seq, prev.last.seq))
+			}
+			if sr.FirstSeq == 0 {
+				sr.FirstSeq = mb.first.seq
+			}
+			sr.Msgs += mb.msgs
+			sr.Bytes += mb.bytes
+		}
+		if mb.last.seq > sr.LastSeq {
+			sr.LastSeq = mb.last.seq
+		}
+		prev = mb
+	}
+}
+
+// Will verify the message checksums of a message block against its contents, and its index
+// and per subject info files if they claim to be current. Nothing is written or removed.
+func (fs *fileStore) verifyMsgBlock(index uint32) (*msgBlock, []string) {
+	mdir := filepath.Join(fs.fcfg.StoreDir, msgDir)
+	mb := &msgBlock{fs: fs, index: index}
+	mb.mfn = filepath.Join(mdir, fmt.Sprintf(blkScan, index))
+	mb.ifn = filepath.Join(mdir, fmt.Sprintf(indexScan, index))
+	mb.sfn = filepath.Join(mdir, fmt.Sprintf(fssScan, index))
+	key := sha256.Sum256(fs.hashKeyForBlock(index))
+	mb.hh, _ = highwayhash.New64(key[:])
+
+	if err := fs.loadVerifyBlockKeys(mb); err != nil {
+		return nil, []string{err.Error()}
+	}
+	buf, err := mb.loadBlock(nil)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("could not read message block: %v", err)}
+	}
+	if mb.bek != nil {
+		mb.bek.XORKeyStream(buf, buf)
+	}
+
+	// Our index file holds the first sequence and the delete map, use it if we can.
+	idx := &msgBlock{fs: fs, aek: mb.aek, nonce: mb.nonce}
+	hasIndex := false
+	if ibuf, err := os.ReadFile(mb.ifn); err == nil {
+		if idx.aek != nil {
+			ibuf, err = idx.aek.Open(ibuf[:0], idx.nonce, ibuf, nil)
+		}
+		hasIndex = err == nil && idx.decodeIndexInfo(ibuf) == nil
+	}
+	if hasIndex {
+		mb.first.seq, mb.dmap = idx.first.seq, idx.dmap
+	}
+
+	var errs []string
+	if err := mb.verifyRecords(buf); err != nil {
+		errs = append(errs, err.Error())
+	}
+	recycleMsgBlockBuf(buf)
+
+	// Only a current index file needs to match, a stale one will be rebuilt on recovery.
+	if hasIndex && (mb.msgs > 0 || idx.msgs > 0) && idx.lchk == mb.lchk {
+		if idx.msgs != mb.msgs || idx.bytes != mb.bytes || idx.first.seq != mb.first.seq || idx.last.seq != mb.last.seq {
+			errs = append(errs, fmt.Sprintf("index file does not match message data: %d msgs %d bytes [%d-%d] vs %d msgs %d bytes [%d-%d]",
+				idx.msgs, idx.bytes, idx.first.seq, idx.last.seq, mb.msgs, mb.bytes, mb.first.seq, mb.last.seq))
+		}
+	}
+	// An empty trailing block holds on to the last sequence through its index file.
+	if hasIndex && mb.msgs == 0 && idx.last.seq > mb.last.seq {
+		mb.last = idx.last
+	}
+
+	// Same for the per subject info.
+	if mb.msgs > 0 {
+		if sbuf, err := mb.loadPerSubjectInfo(); err == nil {
+			fss, err := mb.decodePerSubjectInfo(sbuf)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("subject index: %v", err))
+			} else if err := compareSubjectIndex(fss, mb.fss); err != nil {
+				errs = append(errs, fmt.Sprintf("subject index does not match message data: %v", err))
+			}
+		}
+	}
+	return mb, errs
+}
+
+// Will load the encryption keys for a message block being verified.
+func (fs *fileStore) loadVerifyBlockKeys(mb *msgBlock) error {
+	ekey, err := os.ReadFile(filepath.Join(fs.fcfg.StoreDir, msgDir, fmt.Sprintf(keyScan, mb.index)))
+	if err != nil {
+		// Could be plaintext that will be converted on recovery.
+		return nil
+	}
+	if fs.prf == nil {
+		return fmt.Errorf("message block is encrypted: %v", errNoEncryption)
+	}
+	if len(ekey) < minBlkKeySize {
+		return errBadKeySize
+	}
+	rb, err := fs.prf([]byte(fmt.Sprintf("%s:%d", fs.cfg.Name, mb.index)))
+	if err != nil {
+		return err
+	}
+	kek, err := genEncryptionKey(fs.fcfg.Cipher, rb)
+	if err != nil {
+		return err
+	}
+	ns := kek.NonceSize()
+	seed, err := kek.Open(nil, ekey[:ns], ekey[ns:], nil)
+	if err != nil {
+		return fmt.Errorf("could not decrypt message block key: %v", err)
+	}
+	mb.seed, mb.nonce = seed, ekey[:ns]
+	if mb.aek, err = genEncryptionKey(fs.fcfg.Cipher, mb.seed); err != nil {
+		return err
+	}
+	mb.bek, err = genBlockEncryptionKey(fs.fcfg.Cipher, mb.seed, mb.nonce)
+	return err
+}
+
+// Will walk the decrypted records of a message block checking them, tracking our
+// state and per subject info the same way rebuildState does.
+// Returns an error on the first bad record.
+func (mb *msgBlock) verifyRecords(buf []byte) error {
+	var le = binary.LittleEndian
+	firstNeedsSet := true
+	mb.fss = make(map[string]*SimpleState)
+
+	for index, lbuf := uint32(0), uint32(len(buf)); index < lbuf; {
+		if index+msgHdrSize > lbuf {
+			return fmt.Errorf("short message record at offset %d", index)
+		}
+		hdr := buf[index : index+msgHdrSize]
+		rl, slen := le.Uint32(hdr[0:]), le.Uint16(hdr[20:])
+
+		hasHeaders := rl&hbit != 0
+		rl &^= hbit
+		dlen := int(rl) - msgHdrSize
+		if dlen < 0 || int(slen) > dlen || dlen > int(rl) || rl > rlBadThresh || index+rl > lbuf {
+			return fmt.Errorf("bad message record at offset %d", index)
+		}
+
+		seq := le.Uint64(hdr[4:])
+		ts := int64(le.Uint64(hdr[12:]))
+
+		if seq == 0 || seq&ebit != 0 || seq < mb.first.seq {
+			mb.last.seq, mb.last.ts = seq&^ebit, ts
+			index += rl
+			continue
+		}
+
+		if firstNeedsSet && seq > mb.first.seq {
+			firstNeedsSet, mb.first.seq, mb.first.ts = false, seq, ts
+		}
+		mb.last.seq, mb.last.ts = seq, ts
+
+		if !mb.dmap.exists(seq) {
+			data := buf[index+msgHdrSize : index+rl]
+			mb.hh.Reset()
+			mb.hh.Write(hdr[4:20])
+			mb.hh.Write(data[:slen])
+			if hasHeaders {
+				mb.hh.Write(data[slen+4 : dlen-8])
+			} else {
+				mb.hh.Write(data[slen : dlen-8])
+			}
+			checksum := mb.hh.Sum(nil)
+			if !bytes.Equal(checksum, data[len(data)-8:]) {
+				return fmt.Errorf("checksum mismatch for sequence %d at offset %d", seq, index)
+			}
+			copy(mb.lchk[0:], checksum)
+
+			if firstNeedsSet {
+				firstNeedsSet, mb.first.seq, mb.first.ts = false, seq, ts
+			}
+			mb.msgs++
+			mb.bytes += uint64(rl)
+
+			if slen > 0 {
+				subj := string(data[:slen])
+				if ss := mb.fss[subj]; ss != nil {
+					ss.Msgs++
+					ss.Bytes += uint64(rl)
+					ss.Last = seq
+				} else {
+					mb.fss[subj] = &SimpleState{Msgs: 1, Bytes: uint64(rl), First: seq, Last: seq}
+				}
+			}
+		}
+		index += rl
+	}
+	return nil
+}
+
+// Will compare a stored per subject info with the one derived from the message data.
+func compareSubjectIndex(stored, actual map[string]*SimpleState) error {
+	var diffs []string
+	for subj, ss := range actual {
+		if sss := stored[subj]; sss == nil {
+			diffs = append(diffs, fmt.Sprintf("subject %q missing", subj))
+		} else if sss.Msgs != ss.Msgs || sss.First != ss.First || sss.Last != ss.Last {
+			diffs = append(diffs, fmt.Sprintf("subject %q has %d msgs [%d-%d] vs %d msgs [%d-%d]",
+				subj, sss.Msgs, sss.First, sss.Last, ss.Msgs, ss.First, ss.Last))
+		}
+	}
+	for subj := range stored {
+		if actual[subj] == nil {
+			diffs = append(diffs, fmt.Sprintf("subject %q has no messages", subj))
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	sort.Strings(diffs)
+	return fmt.Errorf("%s", strings.Join(diffs, ", "))
+}
+
+// Will verify the state of all durable consumers of the stream.
+func (fs *fileStore) verifyConsumers(sr *StreamVerifyReport) {
+	odir := filepath.Join(fs.fcfg.StoreDir, consumerDir)
+	fis, err := os.ReadDir(odir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			sr.Errors = append(sr.Errors, fmt.Sprintf("could not read consumer directory: %v", err))
+		}
+		return
+	}
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		cr := &ConsumerVerifyReport{Name: fi.Name()}
+		if state, err := fs.loadVerifyConsumerState(filepath.Join(odir, fi.Name()), cr.Name); err != nil {
+			cr.Errors = append(cr.Errors, err.Error())
+		} else {
+			cr.checkState(state, sr)
+		}
+		sr.Consumers = append(sr.Consumers, cr)
+	}
+}
+
+// Will load and decode the stored state of a consumer.
+func (fs *fileStore) loadVerifyConsumerState(odir, name string) (*ConsumerState, error) {
+	meta, err := os.ReadFile(filepath.Join(odir, JetStreamMetaFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not read consumer metafile: %v", err)
+	}
+	if err := checkMetaSum(odir, fs.cfg.Name+"/"+name, meta); err != nil {
+		return nil, fmt.Errorf("consumer metafile: %v", err)
+	}
+
+	buf, err := os.ReadFile(filepath.Join(odir, consumerState))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read consumer state: %v", err)
+	}
+	if len(buf) == 0 {
+		return &ConsumerState{}, nil
+	}
+
+	if ekey, err := os.ReadFile(filepath.Join(odir, JetStreamMetaFileKey)); err == nil {
+		if fs.prf == nil {
+			return nil, fmt.Errorf("consumer state is encrypted: %v", errNoEncryption)
+		}
+		if len(ekey) < minBlkKeySize {
+			return nil, errBadKeySize
+		}
+		rb, err := fs.prf([]byte(fs.cfg.Name + tsep + name))
+		if err != nil {
+			return nil, err
+		}
+		kek, err := genEncryptionKey(fs.fcfg.Cipher, rb)
+		if err != nil {
+			return nil, err
+		}
+		ns := kek.NonceSize()
+		seed, err := kek.Open(nil, ekey[:ns], ekey[ns:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt consumer key: %v", err)
+		}
+		aek, err := genEncryptionKey(fs.fcfg.Cipher, seed)
+		if err != nil {
+			return nil, err
+		}
+		ns = aek.NonceSize()
+		if len(buf) < ns {
+			return nil, errCorruptState
+		}
+		if buf, err = aek.Open(nil, buf[:ns], buf[ns:], nil); err != nil {
+			return nil, fmt.Errorf("could not decrypt consumer state: %v", err)
+		}
+	}
+
+	state, err := decodeConsumerState(buf)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode consumer state: %v", err)
+	}
+	return state, nil
+}
+
+// Will check a consumer state for consistency, with itself and with the stream.
+func (cr *ConsumerVerifyReport) checkState(state *ConsumerState, sr *StreamVerifyReport) {
+	cr.Delivered = SequenceInfo{Consumer: state.Delivered.Consumer, Stream: state.Delivered.Stream}
+	cr.AckFloor = SequenceInfo{Consumer: state.AckFloor.Consumer, Stream: state.AckFloor.Stream}
+	cr.Pending = len(state.Pending)
+
+	errorf := func(format string, args ...interface{}) {
+		cr.Errors = append(cr.Errors, fmt.Sprintf(format, args...))
+	}
+	if state.AckFloor.Stream > state.Delivered.Stream {
+		errorf("ack floor stream sequence %d is past delivered %d", state.AckFloor.Stream, state.Delivered.Stream)
+	}
+	if state.AckFloor.Consumer > state.Delivered.Consumer {
+		errorf("ack floor consumer sequence %d is past delivered %d", state.AckFloor.Consumer, state.Delivered.Consumer)
+	}
+	if state.Delivered.Stream > sr.LastSeq {
+		errorf("delivered stream sequence %d is past the stream's last sequence %d", state.Delivered.Stream, sr.LastSeq)
+	}
+	var bad int
+	for seq := range state.Pending {
+		if seq <= state.AckFloor.Stream || seq > state.Delivered.Stream {
+			bad++
+		}
+	}
+	if bad > 0 {
+		errorf("%d pending sequences outside of ack floor %d and delivered %d", bad, state.AckFloor.Stream, state.Delivered.Stream)
+	}
+	bad = 0
+	for seq := range state.Redelivered {
+		if seq > state.Delivered.Stream {
+			bad++
+		}
+	}
+	if bad > 0 {
+		errorf("%d redelivered sequences past delivered %d", bad, state.Delivered.Stream)
+	}
+}
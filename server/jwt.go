@@ -73,6 +73,9 @@ func validateTrustedOperators(o *Options) error {
 	if o.AccountResolver == nil {
 		return fmt.Errorf("operators require an account resolver to be configured")
 	}
+	if dr := dirAccResolver(o.AccountResolver); dr != nil && dr.encrypt && o.JetStreamKey == _EMPTY_ {
+		return fmt.Errorf("resolver encryption requires a JetStream encryption key to be configured")
+	}
 	if len(o.Accounts) > 0 {
 		return fmt.Errorf("operators do not allow Accounts to be configured directly")
 	}
@@ -15,6 +15,7 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -4459,6 +4460,74 @@ func TestJWTNoOperatorMode(t *testing.T) {
 	}
 }
 
+func TestJWTAccountResolverEncryptAtRest(t *testing.T) {
+	sysKp, syspub := createKey(t)
+	sysJwt := encodeClaim(t, jwt.NewAccountClaims(syspub), syspub)
+	sysCreds := newUser(t, sysKp)
+
+	aKp, apub := createKey(t)
+	aJwt := encodeClaim(t, jwt.NewAccountClaims(apub), apub)
+	aCreds := newUser(t, aKp)
+
+	for _, typ := range []string{"full", "cache"} {
+		t.Run(typ, func(t *testing.T) {
+			dirSrv := t.TempDir()
+			// A jwt stored in the clear before encryption was enabled.
+			require_NoError(t, os.WriteFile(filepath.Join(dirSrv, apub+fileExtension), []byte(aJwt), defaultFilePerms))
+
+			tmpl := `
+				listen: 127.0.0.1:-1
+				operator: %s
+				system_account: %s
+				jetstream: {%s store_dir: '%s'}
+				resolver: {
+					type: %s
+					dir: '%s'
+					encrypt: true
+				}
+			`
+			conf := createConfFile(t, []byte(fmt.Sprintf(tmpl, ojwt, syspub, "key: s3cr3t,", t.TempDir(), typ, dirSrv)))
+			srv, _ := RunServerWithConfig(conf)
+			defer srv.Shutdown()
+
+			checkEncrypted := func(pub, claim string) {
+				t.Helper()
+				data, err := os.ReadFile(filepath.Join(dirSrv, pub+fileExtension))
+				require_NoError(t, err)
+				require_True(t, bytes.HasPrefix(data, encryptedJWTHdr))
+				require_False(t, bytes.Contains(data, []byte(claim)))
+			}
+			checkEncrypted(apub, aJwt)
+
+			// The migrated jwt is usable and newly stored ones are encrypted.
+			nc := natsConnect(t, srv.ClientURL(), nats.UserCredentials(aCreds))
+			nc.Close()
+			if typ == "full" {
+				updateJwt(t, srv.ClientURL(), sysCreds, sysJwt, 1)
+				checkEncrypted(syspub, sysJwt)
+			}
+
+			// Make sure we can read them back after a restart.
+			srv.Shutdown()
+			srv, _ = RunServerWithConfig(conf)
+			defer srv.Shutdown()
+			nc = natsConnect(t, srv.ClientURL(), nats.UserCredentials(aCreds))
+			nc.Close()
+			ar := dirAccResolver(srv.AccountResolver())
+			theJwt, err := ar.LoadAcc(apub)
+			require_NoError(t, err)
+			require_True(t, theJwt == aJwt)
+
+			// Encryption requires a key.
+			conf = createConfFile(t, []byte(fmt.Sprintf(tmpl, ojwt, syspub, _EMPTY_, t.TempDir(), typ, t.TempDir())))
+			opts, err := ProcessConfigFile(conf)
+			require_NoError(t, err)
+			_, err = NewServer(opts)
+			require_Error(t, err)
+		})
+	}
+}
+
 func TestJWTUserRevocation(t *testing.T) {
 	test := func(all bool) {
 		createAccountAndUser := func(done chan struct{}, pubKey, jwt1, jwt2, creds1, creds2 *string) {
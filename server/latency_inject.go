@@ -0,0 +1,120 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Latency injection delays what the server writes to its routes, gateways or
+// leafnodes, to rehearse WAN conditions on a LAN based staging cluster. Every
+// write is held for the configured latency, plus a random jitter. With loss,
+// that fraction of writes is held for a retransmission timeout on top, which
+// is how a lost packet shows up to a TCP connection. Only outbound data is
+// delayed, so servers on both ends need it to delay both directions.
+//
+// It is only available in servers built with the staging tag, others refuse
+// to start with it configured. Reloaded settings apply to new connections.
+
+// Set by staging builds.
+var latencyInjectionAllowed bool
+
+// Smallest retransmission timeout for injected loss, same as TCP on Linux.
+const minInjectedRTO = 200 * time.Millisecond
+
+func (fo *NetFaultOpts) enabled() bool {
+	return fo.Latency > 0 || fo.Jitter > 0 || fo.Loss > 0
+}
+
+func validateLatencyInjectionOptions(o *Options) error {
+	li := &o.LatencyInjection
+	for _, e := range []struct {
+		name string
+		fo   *NetFaultOpts
+	}{{"routes", &li.Routes}, {"gateways", &li.Gateways}, {"leafnodes", &li.LeafNodes}} {
+		if !e.fo.enabled() {
+			continue
+		}
+		if !latencyInjectionAllowed {
+			return errors.New("latency injection requires a server built with the staging tag")
+		}
+		if e.fo.Latency < 0 || e.fo.Jitter < 0 {
+			return fmt.Errorf("latency injection for %s: latency and jitter can not be negative", e.name)
+		}
+		if e.fo.Loss < 0 || e.fo.Loss >= 1 {
+			return fmt.Errorf("latency injection for %s: loss must be at least 0 and less than 1", e.name)
+		}
+	}
+	return nil
+}
+
+// Returns the connection to use for a connection of the given kind, wrapped
+// to delay its writes if we inject latency for that kind.
+func (s *Server) injectLatency(conn net.Conn, kind int) net.Conn {
+	if !latencyInjectionAllowed || conn == nil {
+		return conn
+	}
+	li := &s.getOpts().LatencyInjection
+	var fo NetFaultOpts
+	switch kind {
+	case ROUTER:
+		fo = li.Routes
+	case GATEWAY:
+		fo = li.Gateways
+	case LEAF:
+		fo = li.LeafNodes
+	}
+	if !fo.enabled() {
+		return conn
+	}
+	return &faultConn{Conn: conn, fo: fo, rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// faultConn delays the writes to the connection it wraps.
+type faultConn struct {
+	net.Conn
+	fo  NetFaultOpts
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func (fc *faultConn) Write(b []byte) (int, error) {
+	if d := fc.delay(); d > 0 {
+		time.Sleep(d)
+	}
+	return fc.Conn.Write(b)
+}
+
+// Returns how long to hold the next write.
+func (fc *faultConn) delay() time.Duration {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	d := fc.fo.Latency
+	if fc.fo.Jitter > 0 {
+		d += time.Duration(fc.rng.Int63n(int64(fc.fo.Jitter) + 1))
+	}
+	if fc.fo.Loss > 0 && fc.rng.Float64() < fc.fo.Loss {
+		rto := 2 * d
+		if rto < minInjectedRTO {
+			rto = minInjectedRTO
+		}
+		d += rto
+	}
+	return d
+}
@@ -282,6 +282,11 @@ func validateLeafNode(o *Options) error {
 	if err := validatePinnedCerts(o.LeafNode.TLSPinnedCerts); err != nil {
 		return fmt.Errorf("leafnode: %v", err)
 	}
+	for _, r := range o.LeafNode.Remotes {
+		if err := validatePinnedCerts(r.TLSPinnedCerts); err != nil {
+			return fmt.Errorf("leafnode remote: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -888,6 +893,7 @@ func (s *Server) createLeafNode(conn net.Conn, rURL *url.URL, remote *leafNodeCf
 	}
 	now := time.Now().UTC()
 
+	conn = s.injectLatency(conn, LEAF)
 	c := &client{srv: s, nc: conn, kind: LEAF, opts: defaultOpts, mpay: maxPay, msubs: maxSubs, start: now, last: now}
 	// Do not update the smap here, we need to do it in initLeafNodeSmapAndSendSubs
 	c.leaf = &leaf{}
@@ -2469,6 +2475,16 @@ func (c *client) leafNodeGetTLSConfigForSolicit(remote *leafNodeCfg, needsLock b
 	return tlsRequired, tlsConfig, tlsName, tlsTimeout
 }
 
+// Returns the pinned certs for connections to this remote, which are
+// the remote's own if configured, otherwise the leafnode ones.
+// Read lock should be held.
+func (cfg *leafNodeCfg) pinnedCerts(opts *Options) PinnedCertSet {
+	if cfg.TLSPinnedCerts != nil {
+		return cfg.TLSPinnedCerts
+	}
+	return opts.LeafNode.TLSPinnedCerts
+}
+
 // Initiates the LeafNode Websocket connection by:
 // - doing the TLS handshake if needed
 // - sending the HTTP request
@@ -2486,11 +2502,12 @@ func (c *client) leafNodeSolicitWSConnection(opts *Options, rURL *url.URL, remot
 	// By default the server will mask outbound frames, but it can be disabled with this option.
 	noMasking := remote.Websocket.NoMasking
 	tlsRequired, tlsConfig, tlsName, tlsTimeout := c.leafNodeGetTLSConfigForSolicit(remote, false)
+	pinned := remote.pinnedCerts(opts)
 	remote.RUnlock()
 	// Do TLS here as needed.
 	if tlsRequired {
 		// Perform the client-side TLS handshake.
-		if resetTLSName, err := c.doTLSClientHandshake("leafnode", rURL, tlsConfig, tlsName, tlsTimeout, opts.LeafNode.TLSPinnedCerts); err != nil {
+		if resetTLSName, err := c.doTLSClientHandshake("leafnode", rURL, tlsConfig, tlsName, tlsTimeout, pinned); err != nil {
 			// Check if we need to reset the remote's TLS name.
 			if resetTLSName {
 				remote.Lock()
@@ -2628,9 +2645,12 @@ func (s *Server) leafNodeResumeConnectProcess(c *client) {
 		if tlsRequired {
 			// Get the URL that was used to connect to the remote server.
 			rURL := remote.getCurrentURL()
+			remote.RLock()
+			pinned := remote.pinnedCerts(c.srv.getOpts())
+			remote.RUnlock()
 
 			// Perform the client-side TLS handshake.
-			if resetTLSName, err := c.doTLSClientHandshake("leafnode", rURL, tlsConfig, tlsName, tlsTimeout, c.srv.getOpts().LeafNode.TLSPinnedCerts); err != nil {
+			if resetTLSName, err := c.doTLSClientHandshake("leafnode", rURL, tlsConfig, tlsName, tlsTimeout, pinned); err != nil {
 				// Check if we need to reset the remote's TLS name.
 				if resetTLSName {
 					remote.Lock()
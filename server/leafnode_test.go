@@ -1453,11 +1453,12 @@ func TestLeafNodePubAllowedPruning(t *testing.T) {
 	}
 
 	wg.Wait()
-	if n := int(atomic.LoadInt32(&c.perms.pcsz)); n > maxPermCacheSize {
+	dc := c.perms.decisions()
+	if n := int(atomic.LoadInt32(&dc.pub.sz)); n > maxPermCacheSize {
 		t.Fatalf("Expected size to be less than %v, got %v", maxPermCacheSize, n)
 	}
-	if n := atomic.LoadInt32(&c.perms.prun); n != 0 {
-		t.Fatalf("c.perms.prun should be 0, was %v", n)
+	if n := atomic.LoadInt32(&dc.pub.prun); n != 0 {
+		t.Fatalf("dc.pub.prun should be 0, was %v", n)
 	}
 }
 
@@ -0,0 +1,192 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+)
+
+var (
+	errListenerNameRequired = errors.New("listener name is required")
+	errListenerPortRequired = errors.New("listener port is required")
+)
+
+// clientListener is a client listener in addition to the main client port.
+type clientListener struct {
+	opts *ListenerOpts
+	accs map[string]struct{}
+	l    net.Listener
+	err  error
+	// Number of clients connected through this listener.
+	// Protected by the server lock.
+	nc int
+}
+
+func validateListenerOptions(o *Options) error {
+	names := make(map[string]struct{}, len(o.Listeners))
+	for _, lo := range o.Listeners {
+		if lo.Name == _EMPTY_ {
+			return errListenerNameRequired
+		}
+		if _, ok := names[lo.Name]; ok {
+			return fmt.Errorf("listener %q: duplicate name", lo.Name)
+		}
+		names[lo.Name] = struct{}{}
+		if lo.Port == 0 {
+			return fmt.Errorf("listener %q: %v", lo.Name, errListenerPortRequired)
+		}
+		if lo.Port > 0 && lo.Port == o.Port {
+			return fmt.Errorf("listener %q: port %d already used for client connections", lo.Name, lo.Port)
+		}
+		if lo.MaxConn < 0 {
+			return fmt.Errorf("listener %q: max connections can not be negative", lo.Name)
+		}
+		if err := validatePinnedCerts(lo.TLSPinnedCerts); err != nil {
+			return fmt.Errorf("listener %q: %v", lo.Name, err)
+		}
+	}
+	return nil
+}
+
+// Starts accepting client connections on the additional listeners.
+func (s *Server) startClientListeners() {
+	opts := s.getOpts()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return
+	}
+	for _, lo := range opts.Listeners {
+		cl := &clientListener{opts: lo}
+		if len(lo.AllowedAccounts) > 0 {
+			cl.accs = make(map[string]struct{}, len(lo.AllowedAccounts))
+			for _, acc := range lo.AllowedAccounts {
+				cl.accs[acc] = struct{}{}
+			}
+		}
+		s.listeners = append(s.listeners, cl)
+
+		port := lo.Port
+		if port == RANDOM_PORT {
+			port = 0
+		}
+		hp := net.JoinHostPort(lo.Host, strconv.Itoa(port))
+		l, e := natsListen("tcp", hp)
+		cl.err = e
+		if e != nil {
+			s.Fatalf("Error listening on port: %s for listener %q, %q", hp, lo.Name, e)
+			return
+		}
+		// Write resolved port back to options for RANDOM_PORT.
+		if lo.Port == RANDOM_PORT {
+			lo.Port = l.Addr().(*net.TCPAddr).Port
+		}
+		s.Noticef("Listening for client connections on %s (listener %q)",
+			net.JoinHostPort(lo.Host, strconv.Itoa(l.Addr().(*net.TCPAddr).Port)), lo.Name)
+		if lo.TLSConfig != nil {
+			s.Noticef("TLS required for client connections on listener %q", lo.Name)
+		}
+		cl.l = l
+
+		go s.acceptConnections(l, fmt.Sprintf("Client (%s)", lo.Name),
+			func(conn net.Conn) { s.createClientEx(conn, nil, cl) },
+			func(_ error) bool {
+				if s.isLameDuckMode() {
+					// Signal that we are not accepting new clients
+					s.ldmCh <- true
+					// Now wait for the Shutdown...
+					<-s.quitCh
+					return true
+				}
+				return false
+			})
+	}
+}
+
+// Closes the additional listeners and returns how many were closed.
+// Server lock should be held.
+func (s *Server) closeClientListeners() int {
+	var n int
+	for _, cl := range s.listeners {
+		if cl.l != nil {
+			cl.l.Close()
+			cl.l = nil
+			n++
+		}
+	}
+	return n
+}
+
+// Returns the TLS settings for clients connecting through this listener.
+func (cl *clientListener) tlsSettings() (*tls.Config, float64, PinnedCertSet) {
+	return cl.opts.TLSConfig, cl.opts.TLSTimeout, cl.opts.TLSPinnedCerts
+}
+
+// Adjusts the INFO sent to clients connecting through this listener.
+func (cl *clientListener) updateInfo(info *Info) {
+	tc := cl.opts.TLSConfig
+	info.TLSRequired = tc != nil
+	info.TLSVerify = tc != nil && tc.ClientAuth == tls.RequireAndVerifyClientCert
+	info.TLSAvailable = false
+}
+
+// Returns true if users of this account can connect through this listener.
+func (cl *clientListener) allowsAccount(acc *Account) bool {
+	if cl.accs == nil {
+		return true
+	}
+	if acc == nil {
+		return false
+	}
+	_, ok := cl.accs[acc.Name]
+	return ok
+}
+
+// Returns true if both lists hold the same listeners, ignoring TLS configs
+// that can't be compared and ports of listeners started with a random port.
+func listenerOptsEqual(old, new []*ListenerOpts) bool {
+	if len(old) != len(new) {
+		return false
+	}
+	for i := range old {
+		o, n := *old[i], *new[i]
+		o.TLSConfig, n.TLSConfig = nil, nil
+		if n.Port == RANDOM_PORT {
+			n.Port = o.Port
+		}
+		if !reflect.DeepEqual(o, n) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListenerAddr will return the net.Addr object for the additional
+// client listener with the given name.
+func (s *Server) ListenerAddr(name string) net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, cl := range s.listeners {
+		if cl.opts.Name == name && cl.l != nil {
+			return cl.l.Addr()
+		}
+	}
+	return nil
+}
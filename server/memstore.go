@@ -30,8 +30,15 @@ type memStore struct {
 	fss       map[string]*SimpleState
 	maxp      int64
 	scb       StorageUpdateHandler
+	ecb       EvictionHandler
 	ageChk    *time.Timer
 	consumers int
+	rates     storeRates
+	wal       *memWAL
+	arena     *memArena
+	frozen    bool
+	holds     []string
+	sched     map[uint64]int64
 }
 
 func newMemStore(cfg *StreamConfig) (*memStore, error) {
@@ -61,14 +68,16 @@ func (ms *memStore) UpdateConfig(cfg *StreamConfig) error {
 
 	ms.mu.Lock()
 	ms.cfg = *cfg
-	// Limits checks and enforcement.
-	ms.enforceMsgLimit()
-	ms.enforceBytesLimit()
+	// Limits checks and enforcement, while frozen these are applied once thawed.
+	if !ms.frozen {
+		ms.enforceMsgLimit()
+		ms.enforceBytesLimit()
+	}
 	// Do age timers.
-	if ms.ageChk == nil && ms.cfg.MaxAge != 0 {
+	if ms.ageChk == nil && ms.cfg.expiryAge() != 0 {
 		ms.startAgeChk()
 	}
-	if ms.ageChk != nil && ms.cfg.MaxAge == 0 {
+	if ms.ageChk != nil && ms.cfg.expiryAge() == 0 {
 		ms.ageChk.Stop()
 		ms.ageChk = nil
 	}
@@ -76,7 +85,7 @@ func (ms *memStore) UpdateConfig(cfg *StreamConfig) error {
 	maxp := ms.maxp
 	ms.maxp = cfg.MaxMsgsPer
 	// If the value is smaller we need to enforce that.
-	if ms.maxp != 0 && ms.maxp < maxp {
+	if !ms.frozen && ms.maxp != 0 && ms.maxp < maxp {
 		lm := uint64(ms.maxp)
 		for _, ss := range ms.fss {
 			if ss.Msgs > lm {
@@ -84,9 +93,24 @@ func (ms *memStore) UpdateConfig(cfg *StreamConfig) error {
 			}
 		}
 	}
+	// Also enforce max bytes per subject if set.
+	if !ms.frozen && ms.cfg.MaxBytesPer > 0 {
+		lb := uint64(ms.cfg.MaxBytesPer)
+		for _, ss := range ms.fss {
+			if ss.Bytes > lb {
+				ms.enforcePerSubjectBytesLimit(ss)
+			}
+		}
+	}
+	w := ms.wal
 	ms.mu.Unlock()
 
-	if cfg.MaxAge != 0 {
+	if w != nil {
+		if err := ms.writeWALMeta(w.dir, w.ctm); err != nil {
+			return err
+		}
+	}
+	if cfg.expiryAge() != 0 {
 		ms.expireMsgs()
 	}
 	return nil
@@ -98,6 +122,9 @@ func (ms *memStore) storeRawMsg(subj string, hdr, msg []byte, seq uint64, ts int
 	if ms.msgs == nil {
 		return ErrStoreClosed
 	}
+	if ms.frozen {
+		return ErrStoreFrozen
+	}
 
 	// Tracking by subject.
 	var ss *SimpleState
@@ -145,17 +172,15 @@ func (ms *memStore) storeRawMsg(subj string, hdr, msg []byte, seq uint64, ts int
 		ms.state.FirstTime = now
 	}
 
-	// Make copies
-	// TODO(dlc) - Maybe be smarter here.
-	if len(msg) > 0 {
-		msg = copyBytes(msg)
-	}
-	if len(hdr) > 0 {
-		hdr = copyBytes(hdr)
+	// Copy into our own buffer, from the arena if we have one.
+	var buf []byte
+	var slab *memSlab
+	if ms.arena != nil {
+		buf, slab = ms.arena.alloc(len(hdr) + len(msg))
+	} else {
+		buf = make([]byte, 0, len(hdr)+len(msg))
 	}
-
-	// FIXME(dlc) - Could pool at this level?
-	sm := &StoreMsg{subj, nil, nil, make([]byte, 0, len(hdr)+len(msg)), seq, ts}
+	sm := &StoreMsg{subj, nil, nil, buf, seq, ts, slab}
 	sm.buf = append(sm.buf, hdr...)
 	sm.buf = append(sm.buf, msg...)
 	if len(hdr) > 0 {
@@ -164,21 +189,38 @@ func (ms *memStore) storeRawMsg(subj string, hdr, msg []byte, seq uint64, ts int
 	sm.msg = sm.buf[len(hdr):]
 	ms.msgs[seq] = sm
 	ms.state.Msgs++
-	ms.state.Bytes += memStoreMsgSize(subj, hdr, msg)
+	msz := memStoreMsgSize(subj, hdr, msg)
+	ms.state.Bytes += msz
 	ms.state.LastSeq = seq
+	ms.rates.in.record(time.Now().Unix(), 1, msz)
 	ms.state.LastTime = now
+	ms.walAppend(memWALStore, seq, ts, subj, hdr, msg)
+
+	// Track if scheduled for later delivery.
+	if len(hdr) > 0 {
+		if due, err := scheduledAt(hdr, ts); err == nil && due > ts {
+			if ms.sched == nil {
+				ms.sched = make(map[uint64]int64)
+			}
+			ms.sched[seq] = due
+		}
+	}
 
 	// Track per subject.
 	if len(subj) > 0 {
 		if ss != nil {
 			ss.Msgs++
+			ss.Bytes += msz
 			ss.Last = seq
 			// Check per subject limits.
 			if ms.maxp > 0 && ss.Msgs > uint64(ms.maxp) {
 				ms.enforcePerSubjectLimit(ss)
 			}
+			if ms.cfg.MaxBytesPer > 0 && ss.Bytes > uint64(ms.cfg.MaxBytesPer) {
+				ms.enforcePerSubjectBytesLimit(ss)
+			}
 		} else {
-			ms.fss[subj] = &SimpleState{Msgs: 1, First: seq, Last: seq}
+			ms.fss[subj] = &SimpleState{Msgs: 1, Bytes: msz, First: seq, Last: seq}
 		}
 	}
 
@@ -187,7 +229,7 @@ func (ms *memStore) storeRawMsg(subj string, hdr, msg []byte, seq uint64, ts int
 	ms.enforceBytesLimit()
 
 	// Check if we have and need the age expiration timer running.
-	if ms.ageChk == nil && ms.cfg.MaxAge != 0 {
+	if ms.ageChk == nil && ms.cfg.expiryAge() != 0 {
 		ms.startAgeChk()
 	}
 
@@ -225,6 +267,45 @@ func (ms *memStore) StoreMsg(subj string, hdr, msg []byte) (uint64, int64, error
 	return seq, ts, err
 }
 
+// StoreMsgIfLastSubjSeq stores a message only if the last sequence for its subject is lseq,
+// with 0 meaning we should have no messages for the subject.
+func (ms *memStore) StoreMsgIfLastSubjSeq(subj string, hdr, msg []byte, lseq uint64) (uint64, int64, error) {
+	ms.mu.Lock()
+	var seq uint64
+	var ts int64
+	var err error
+	if ms.msgs == nil {
+		err = ErrStoreClosed
+	} else if ms.lastSeqForSubj(subj) != lseq {
+		err = ErrLastSubjSeqMismatch
+	} else {
+		seq, ts = ms.state.LastSeq+1, time.Now().UnixNano()
+		err = ms.storeRawMsg(subj, hdr, msg, seq, ts)
+	}
+	cb := ms.scb
+	ms.mu.Unlock()
+
+	if err != nil {
+		seq, ts = 0, 0
+	} else if cb != nil {
+		cb(1, int64(memStoreMsgSize(subj, hdr, msg)), seq, subj)
+	}
+
+	return seq, ts, err
+}
+
+// Will return the last sequence for a literal subject, or 0 if we have none.
+// Lock should be held.
+func (ms *memStore) lastSeqForSubj(subj string) uint64 {
+	if ss := ms.fss[subj]; ss != nil {
+		return ss.Last
+	}
+	return 0
+}
+
+// Prefetch is a no-op for a memory store, all messages are loaded.
+func (ms *memStore) Prefetch(start, n uint64) {}
+
 // SkipMsg will use the next sequence number but not store anything.
 func (ms *memStore) SkipMsg() uint64 {
 	// Grab time.
@@ -239,6 +320,7 @@ func (ms *memStore) SkipMsg() uint64 {
 		ms.state.FirstTime = now
 	}
 	ms.updateFirstSeq(seq)
+	ms.walAppend(memWALSkip, seq, 0, _EMPTY_, nil, nil)
 	ms.mu.Unlock()
 	return seq
 }
@@ -249,12 +331,23 @@ func (ms *memStore) SkipMsg() uint64 {
 func (ms *memStore) RegisterStorageUpdates(cb StorageUpdateHandler) {
 	ms.mu.Lock()
 	ms.scb = cb
+	bsz := ms.state.Bytes
+	ms.mu.Unlock()
+	// We may have been repopulated from our WAL.
+	if cb != nil && bsz > 0 {
+		cb(0, int64(bsz), 0, _EMPTY_)
+	}
+}
+
+// RegisterEvictionHandler registers a callback consulted before messages are removed due to limits.
+func (ms *memStore) RegisterEvictionHandler(cb EvictionHandler) {
+	ms.mu.Lock()
+	ms.ecb = cb
 	ms.mu.Unlock()
 }
 
 // GetSeqFromTime looks for the first sequence number that has the message
 // with >= timestamp.
-// FIXME(dlc) - inefficient.
 func (ms *memStore) GetSeqFromTime(t time.Time) uint64 {
 	ts := t.UnixNano()
 	ms.mu.RLock()
@@ -272,10 +365,22 @@ func (ms *memStore) GetSeqFromTime(t time.Time) uint64 {
 	if ts > last {
 		return ms.state.LastSeq + 1
 	}
-	index := sort.Search(len(ms.msgs), func(i int) bool {
-		return ms.msgs[uint64(i)+ms.state.FirstSeq].ts >= ts
+	// Messages are indexed by sequence, so search the sequence range, using the
+	// next message still present for any that were removed.
+	n := int(ms.state.LastSeq - ms.state.FirstSeq + 1)
+	index := sort.Search(n, func(i int) bool {
+		for seq := uint64(i) + ms.state.FirstSeq; seq <= ms.state.LastSeq; seq++ {
+			if sm := ms.msgs[seq]; sm != nil {
+				return sm.ts >= ts
+			}
+		}
+		return true
 	})
-	return uint64(index) + ms.state.FirstSeq
+	seq := uint64(index) + ms.state.FirstSeq
+	for seq <= ms.state.LastSeq && ms.msgs[seq] == nil {
+		seq++
+	}
+	return seq
 }
 
 // FilteredState will return the SimpleState associated with the filtered subject and a proposed starting sequence.
@@ -360,7 +465,7 @@ func (ms *memStore) SubjectsState(subject string) map[string]SimpleState {
 				fss[subj] = *ss
 			} else {
 				// Merge here.
-				oss.Last, oss.Msgs = ss.Last, oss.Msgs+ss.Msgs
+				oss.Last, oss.Msgs, oss.Bytes = ss.Last, oss.Msgs+ss.Msgs, oss.Bytes+ss.Bytes
 				fss[subj] = oss
 			}
 		}
@@ -368,14 +473,53 @@ func (ms *memStore) SubjectsState(subject string) map[string]SimpleState {
 	return fss
 }
 
+// SubjectsStateRange returns SimpleState for at most limit matching subjects that sort after
+// startSubject, along with the total number of matching subjects.
+// A limit of 0 means no limit.
+func (ms *memStore) SubjectsStateRange(subject, startSubject string, limit int) (map[string]SimpleState, int) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if len(ms.fss) == 0 {
+		return nil, 0
+	}
+
+	sr := newSubjectRange(startSubject, limit)
+	for subj := range ms.fss {
+		if subject == _EMPTY_ || subject == fwcs || subjectIsSubsetMatch(subj, subject) {
+			sr.add(subj)
+		}
+	}
+	subjs := sr.subjects()
+	fss := make(map[string]SimpleState, len(subjs))
+	for _, subj := range subjs {
+		fss[subj] = *ms.fss[subj]
+	}
+	return fss, sr.total
+}
+
 // Will check the msg limit for this tracked subject.
 // Lock should be held.
 func (ms *memStore) enforcePerSubjectLimit(ss *SimpleState) {
-	if ms.maxp <= 0 {
+	if ms.maxp <= 0 || ms.isHeldState(ss) {
 		return
 	}
 	for nmsgs := ss.Msgs; nmsgs > uint64(ms.maxp); nmsgs = ss.Msgs {
-		if !ms.removeMsg(ss.First, false) {
+		if !allowEviction(ms.ecb, EvictMaxMsgsPer, ms.msgs[ss.First]) || !ms.removeMsg(ss.First, false) {
+			break
+		}
+	}
+}
+
+// Will check the bytes limit for this tracked subject.
+// We always keep the last message for the subject, even if it alone exceeds the limit.
+// Lock should be held.
+func (ms *memStore) enforcePerSubjectBytesLimit(ss *SimpleState) {
+	if ms.cfg.MaxBytesPer <= 0 || ms.isHeldState(ss) {
+		return
+	}
+	for ss.Msgs > 1 && ss.Bytes > uint64(ms.cfg.MaxBytesPer) {
+		if !allowEviction(ms.ecb, EvictMaxBytesPer, ms.msgs[ss.First]) || !ms.removeMsg(ss.First, false) {
 			break
 		}
 	}
@@ -388,7 +532,9 @@ func (ms *memStore) enforceMsgLimit() {
 		return
 	}
 	for nmsgs := ms.state.Msgs; nmsgs > uint64(ms.cfg.MaxMsgs); nmsgs = ms.state.Msgs {
-		ms.deleteFirstMsgOrPanic()
+		if !ms.evictFirstUnheld(EvictMaxMsgs) {
+			return
+		}
 	}
 }
 
@@ -399,15 +545,116 @@ func (ms *memStore) enforceBytesLimit() {
 		return
 	}
 	for bs := ms.state.Bytes; bs > uint64(ms.cfg.MaxBytes); bs = ms.state.Bytes {
+		if !ms.evictFirstUnheld(EvictMaxBytes) {
+			return
+		}
+	}
+}
+
+// Removes the first message not on hold due to limits. Returns false if there was none,
+// its eviction was vetoed or it could not be removed.
+// Lock should be held.
+func (ms *memStore) evictFirstUnheld(reason EvictionReason) bool {
+	if len(ms.holds) == 0 {
+		if !allowEviction(ms.ecb, reason, ms.msgs[ms.state.FirstSeq]) {
+			return false
+		}
 		ms.deleteFirstMsgOrPanic()
+		return true
+	}
+	for seq := ms.state.FirstSeq; seq <= ms.state.LastSeq; seq++ {
+		if sm := ms.msgs[seq]; sm != nil && !isHeldSubject(ms.holds, sm.subj) {
+			return allowEviction(ms.ecb, reason, sm) && ms.removeMsg(seq, false)
+		}
+	}
+	return false
+}
+
+// Returns true if the subject tracked by ss is on hold.
+// Lock should be held.
+func (ms *memStore) isHeldState(ss *SimpleState) bool {
+	if len(ms.holds) == 0 {
+		return false
 	}
+	sm := ms.msgs[ss.First]
+	return sm != nil && isHeldSubject(ms.holds, sm.subj)
 }
 
 // Will start the age check timer.
 // Lock should be held.
 func (ms *memStore) startAgeChk() {
-	if ms.ageChk == nil && ms.cfg.MaxAge != 0 {
-		ms.ageChk = time.AfterFunc(ms.cfg.MaxAge, ms.expireMsgs)
+	if maxAge := ms.cfg.expiryAge(); ms.ageChk == nil && maxAge != 0 {
+		ms.ageChk = time.AfterFunc(expiryFireIn(maxAge, ms.cfg.ExpiryBatch), ms.expireMsgs)
+	}
+}
+
+// With subject retention overrides or holds messages expire per subject, so we walk the
+// first message of each subject instead of only the first messages of the stream.
+// Lock should be held.
+func (ms *memStore) expireMsgsPerSubjectLocked() {
+	now := time.Now().UnixNano()
+	var next time.Duration
+	var vetoed bool
+
+	subjs := make([]string, 0, len(ms.fss))
+	for subj := range ms.fss {
+		if len(ms.holds) == 0 || !isHeldSubject(ms.holds, subj) {
+			subjs = append(subjs, subj)
+		}
+	}
+	for _, subj := range subjs {
+		maxAge := ms.cfg.maxAgeFor(subj)
+		if maxAge == 0 {
+			continue
+		}
+		minAge := now - int64(maxAge)
+		for !vetoed {
+			ss := ms.fss[subj]
+			if ss == nil {
+				break
+			}
+			sm := ms.msgs[ss.First]
+			if sm == nil {
+				break
+			}
+			if sm.ts > minAge {
+				if delta := time.Duration(sm.ts - minAge); next == 0 || delta < next {
+					next = delta
+				}
+				break
+			}
+			if !allowEviction(ms.ecb, EvictMaxAge, sm) {
+				vetoed = true
+				break
+			}
+			msz := memStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+			if !ms.removeMsg(sm.seq, false) {
+				break
+			}
+			ms.rates.expired.record(now/int64(time.Second), 1, msz)
+		}
+	}
+
+	var fireIn time.Duration
+	switch {
+	case ms.state.Msgs == 0:
+		if ms.ageChk != nil {
+			ms.ageChk.Stop()
+			ms.ageChk = nil
+		}
+		return
+	case vetoed:
+		fireIn = evictionVetoRetry
+	case next > 0:
+		fireIn = next
+	default:
+		fireIn = ms.cfg.expiryAge()
+	}
+	fireIn = expiryFireIn(fireIn, ms.cfg.ExpiryBatch)
+	if ms.ageChk != nil {
+		ms.ageChk.Reset(fireIn)
+	} else {
+		ms.ageChk = time.AfterFunc(fireIn, ms.expireMsgs)
 	}
 }
 
@@ -416,11 +663,36 @@ func (ms *memStore) expireMsgs() {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
+	// Nothing expires while frozen, we will check again once thawed.
+	if ms.frozen {
+		if ms.ageChk != nil {
+			ms.ageChk.Stop()
+			ms.ageChk = nil
+		}
+		return
+	}
+	if len(ms.cfg.SubjectRetention) > 0 || len(ms.holds) > 0 {
+		ms.expireMsgsPerSubjectLocked()
+		return
+	}
+
 	now := time.Now().UnixNano()
 	minAge := now - int64(ms.cfg.MaxAge)
 	for {
-		if sm, ok := ms.msgs[ms.state.FirstSeq]; ok && sm.ts <= minAge {
+		sm, ok := ms.msgs[ms.state.FirstSeq]
+		if ok && sm.ts <= minAge && !allowEviction(ms.ecb, EvictMaxAge, sm) {
+			// Vetoed, try again later.
+			fireIn := expiryFireIn(evictionVetoRetry, ms.cfg.ExpiryBatch)
+			if ms.ageChk != nil {
+				ms.ageChk.Reset(fireIn)
+			} else {
+				ms.ageChk = time.AfterFunc(fireIn, ms.expireMsgs)
+			}
+			return
+		}
+		if ok && sm.ts <= minAge {
 			ms.deleteFirstMsgOrPanic()
+			ms.rates.expired.record(now/int64(time.Second), 1, memStoreMsgSize(sm.subj, sm.hdr, sm.msg))
 		} else {
 			if !ok {
 				if ms.ageChk != nil {
@@ -428,7 +700,7 @@ func (ms *memStore) expireMsgs() {
 					ms.ageChk = nil
 				}
 			} else {
-				fireIn := time.Duration(sm.ts-now) + ms.cfg.MaxAge
+				fireIn := expiryFireIn(time.Duration(sm.ts-now)+ms.cfg.MaxAge, ms.cfg.ExpiryBatch)
 				if ms.ageChk != nil {
 					ms.ageChk.Reset(fireIn)
 				} else {
@@ -440,12 +712,97 @@ func (ms *memStore) expireMsgs() {
 	}
 }
 
+// RemapSubjects will rewrite the subject of all messages up to and including last that match
+// filter, using dest as a subject mapping destination. Will return the number of remapped messages.
+func (ms *memStore) RemapSubjects(filter, dest string, last uint64) (uint64, error) {
+	tr, err := newTransform(filter, dest)
+	if err != nil {
+		return 0, err
+	}
+
+	ms.mu.Lock()
+	if ms.frozen {
+		ms.mu.Unlock()
+		return 0, ErrStoreFrozen
+	}
+	remapped, bytes := ms.remapSubjects(tr, last)
+	if remapped > 0 {
+		ms.walAppend(memWALRemap, last, 0, filter, nil, []byte(dest))
+	}
+	cb := ms.scb
+	ms.mu.Unlock()
+
+	if cb != nil && bytes != 0 {
+		cb(0, bytes, 0, _EMPTY_)
+	}
+	return remapped, nil
+}
+
+// Returns the number of remapped messages and the change in bytes.
+// Lock should be held.
+func (ms *memStore) remapSubjects(tr *transform, last uint64) (uint64, int64) {
+	if last > ms.state.LastSeq {
+		last = ms.state.LastSeq
+	}
+	// Grab the matching subjects first since we will be changing our subject state.
+	var subjs []string
+	for subj, ss := range ms.fss {
+		if ss.First <= last && subjectIsSubsetMatch(subj, tr.src) {
+			subjs = append(subjs, subj)
+		}
+	}
+
+	var remapped uint64
+	var bytes int64
+	for _, subj := range subjs {
+		nsubj, err := tr.Match(subj)
+		if err != nil || nsubj == subj {
+			continue
+		}
+		ss := ms.fss[subj]
+		first, lseq := ss.First, ss.Last
+		if lseq > last {
+			lseq = last
+		}
+		for seq := first; seq <= lseq; seq++ {
+			sm := ms.msgs[seq]
+			if sm == nil || sm.subj != subj {
+				continue
+			}
+			osz := memStoreMsgSize(subj, sm.hdr, sm.msg)
+			nsz := memStoreMsgSize(nsubj, sm.hdr, sm.msg)
+			sm.subj = nsubj
+			ms.removeSeqPerSubject(subj, seq, osz)
+			if nss := ms.fss[nsubj]; nss != nil {
+				nss.Msgs++
+				nss.Bytes += nsz
+				if seq < nss.First {
+					nss.First = seq
+				}
+				if seq > nss.Last {
+					nss.Last = seq
+				}
+			} else {
+				ms.fss[nsubj] = &SimpleState{Msgs: 1, Bytes: nsz, First: seq, Last: seq}
+			}
+			ms.state.Bytes += nsz
+			ms.state.Bytes -= osz
+			bytes += int64(nsz) - int64(osz)
+			remapped++
+		}
+	}
+	return remapped, bytes
+}
+
 // PurgeEx will remove messages based on subject filters, sequence and number of messages to keep.
 // Will return the number of purged messages.
 func (ms *memStore) PurgeEx(subject string, sequence, keep uint64) (purged uint64, err error) {
 	if sequence > 1 && keep > 0 {
 		return 0, ErrPurgeArgMismatch
 	}
+	if ms.IsFrozen() {
+		return 0, ErrStoreFrozen
+	}
 
 	if subject == _EMPTY_ || subject == fwcs {
 		if keep == 0 && (sequence == 0 || sequence == 1) {
@@ -497,6 +854,10 @@ func (ms *memStore) PurgeEx(subject string, sequence, keep uint64) (purged uint6
 // Will return the number of purged messages.
 func (ms *memStore) Purge() (uint64, error) {
 	ms.mu.Lock()
+	if ms.frozen {
+		ms.mu.Unlock()
+		return 0, ErrStoreFrozen
+	}
 	purged := uint64(len(ms.msgs))
 	cb := ms.scb
 	bytes := int64(ms.state.Bytes)
@@ -506,6 +867,9 @@ func (ms *memStore) Purge() (uint64, error) {
 	ms.state.Msgs = 0
 	ms.msgs = make(map[uint64]*StoreMsg)
 	ms.fss = make(map[string]*SimpleState)
+	ms.sched = nil
+	ms.dropArena()
+	ms.walAppend(memWALPurge, 0, 0, _EMPTY_, nil, nil)
 	ms.mu.Unlock()
 
 	if cb != nil {
@@ -526,6 +890,10 @@ func (ms *memStore) Compact(seq uint64) (uint64, error) {
 	var purged, bytes uint64
 
 	ms.mu.Lock()
+	if ms.frozen {
+		ms.mu.Unlock()
+		return 0, ErrStoreFrozen
+	}
 	cb := ms.scb
 	if seq <= ms.state.LastSeq {
 		sm, ok := ms.msgs[seq]
@@ -538,10 +906,12 @@ func (ms *memStore) Compact(seq uint64) (uint64, error) {
 
 		for seq := seq - 1; seq > 0; seq-- {
 			if sm := ms.msgs[seq]; sm != nil {
-				bytes += memStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+				sz := memStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+				bytes += sz
 				purged++
 				delete(ms.msgs, seq)
-				ms.removeSeqPerSubject(sm.subj, seq)
+				ms.removeSeqPerSubject(sm.subj, seq, sz)
+				ms.releaseMsg(sm)
 			}
 		}
 		ms.state.Msgs -= purged
@@ -557,7 +927,10 @@ func (ms *memStore) Compact(seq uint64) (uint64, error) {
 		ms.state.FirstTime = time.Time{}
 		ms.state.LastSeq = seq - 1
 		ms.msgs = make(map[uint64]*StoreMsg)
+		ms.dropArena()
 	}
+	ms.pruneScheduleLocked()
+	ms.walAppend(memWALCompact, seq, 0, _EMPTY_, nil, nil)
 	ms.mu.Unlock()
 
 	if cb != nil {
@@ -571,6 +944,10 @@ func (ms *memStore) Compact(seq uint64) (uint64, error) {
 func (ms *memStore) reset() error {
 
 	ms.mu.Lock()
+	if ms.frozen {
+		ms.mu.Unlock()
+		return ErrStoreFrozen
+	}
 	var purged, bytes uint64
 	cb := ms.scb
 	if cb != nil {
@@ -591,6 +968,9 @@ func (ms *memStore) reset() error {
 	// Reset msgs and fss.
 	ms.msgs = make(map[uint64]*StoreMsg)
 	ms.fss = make(map[string]*SimpleState)
+	ms.sched = nil
+	ms.dropArena()
+	ms.walAppend(memWALTruncate, 0, 0, _EMPTY_, nil, nil)
 
 	ms.mu.Unlock()
 
@@ -611,6 +991,10 @@ func (ms *memStore) Truncate(seq uint64) error {
 	var purged, bytes uint64
 
 	ms.mu.Lock()
+	if ms.frozen {
+		ms.mu.Unlock()
+		return ErrStoreFrozen
+	}
 	lsm, ok := ms.msgs[seq]
 	if !ok {
 		ms.mu.Unlock()
@@ -620,9 +1004,11 @@ func (ms *memStore) Truncate(seq uint64) error {
 	for i := ms.state.LastSeq; i > seq; i-- {
 		if sm := ms.msgs[i]; sm != nil {
 			purged++
-			bytes += memStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+			sz := memStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+			bytes += sz
 			delete(ms.msgs, i)
-			ms.removeSeqPerSubject(sm.subj, i)
+			ms.removeSeqPerSubject(sm.subj, i, sz)
+			ms.releaseMsg(sm)
 		}
 	}
 	// Reset last.
@@ -631,6 +1017,8 @@ func (ms *memStore) Truncate(seq uint64) error {
 	// Update msgs and bytes.
 	ms.state.Msgs -= purged
 	ms.state.Bytes -= bytes
+	ms.pruneScheduleLocked()
+	ms.walAppend(memWALTruncate, seq, 0, _EMPTY_, nil, nil)
 
 	cb := ms.scb
 	ms.mu.Unlock()
@@ -656,7 +1044,11 @@ func (ms *memStore) deleteFirstMsg() bool {
 func (ms *memStore) LoadMsg(seq uint64, smp *StoreMsg) (*StoreMsg, error) {
 	ms.mu.RLock()
 	sm, ok := ms.msgs[seq]
-	last := ms.state.LastSeq
+	last, arena := ms.state.LastSeq, ms.arena
+	// We copy outside of the lock, so make sure the slab is not reused underneath us.
+	if ok && sm != nil {
+		sm.slab.acquire()
+	}
 	ms.mu.RUnlock()
 
 	if !ok || sm == nil {
@@ -671,6 +1063,9 @@ func (ms *memStore) LoadMsg(seq uint64, smp *StoreMsg) (*StoreMsg, error) {
 		smp = new(StoreMsg)
 	}
 	sm.copy(smp)
+	if arena != nil {
+		arena.release(sm.slab)
+	}
 	return smp, nil
 }
 
@@ -773,6 +1168,10 @@ func (ms *memStore) LoadNextMsg(filter string, wc bool, start uint64, smp *Store
 // Will return the number of bytes removed.
 func (ms *memStore) RemoveMsg(seq uint64) (bool, error) {
 	ms.mu.Lock()
+	if ms.frozen {
+		ms.mu.Unlock()
+		return false, ErrStoreFrozen
+	}
 	removed := ms.removeMsg(seq, false)
 	ms.mu.Unlock()
 	return removed, nil
@@ -781,11 +1180,90 @@ func (ms *memStore) RemoveMsg(seq uint64) (bool, error) {
 // EraseMsg will remove the message and rewrite its contents.
 func (ms *memStore) EraseMsg(seq uint64) (bool, error) {
 	ms.mu.Lock()
+	if ms.frozen {
+		ms.mu.Unlock()
+		return false, ErrStoreFrozen
+	}
 	removed := ms.removeMsg(seq, true)
 	ms.mu.Unlock()
 	return removed, nil
 }
 
+// RemoveRange will remove all messages from first to last inclusive.
+// Will return the number of messages removed.
+func (ms *memStore) RemoveRange(first, last uint64) (uint64, error) {
+	if first == 0 || last < first {
+		return 0, ErrInvalidSequenceRange
+	}
+
+	ms.mu.Lock()
+	if ms.frozen {
+		ms.mu.Unlock()
+		return 0, ErrStoreFrozen
+	}
+	if first < ms.state.FirstSeq {
+		first = ms.state.FirstSeq
+	}
+	if last > ms.state.LastSeq {
+		last = ms.state.LastSeq
+	}
+
+	var removed, bytes uint64
+	remove := func(seq uint64, sm *StoreMsg) {
+		sz := memStoreMsgSize(sm.subj, sm.hdr, sm.msg)
+		delete(ms.msgs, seq)
+		ms.removeSeqPerSubject(sm.subj, seq, sz)
+		ms.releaseMsg(sm)
+		removed++
+		bytes += sz
+	}
+	if last >= first {
+		// Walk whichever is smaller, the range or our messages.
+		if last-first+1 > uint64(len(ms.msgs)) {
+			for seq, sm := range ms.msgs {
+				if seq >= first && seq <= last {
+					remove(seq, sm)
+				}
+			}
+		} else {
+			for seq := first; seq <= last; seq++ {
+				if sm, ok := ms.msgs[seq]; ok {
+					remove(seq, sm)
+				}
+			}
+		}
+	}
+	if removed > 0 {
+		ms.state.Msgs -= removed
+		ms.state.Bytes -= bytes
+		ms.updateFirstSeq(first)
+		ms.walAppend(memWALRemoveRange, first, int64(last), _EMPTY_, nil, nil)
+	}
+	cb := ms.scb
+	ms.mu.Unlock()
+
+	if cb != nil && removed > 0 {
+		cb(-int64(removed), -int64(bytes), 0, _EMPTY_)
+	}
+	return removed, nil
+}
+
+// Releases the buffer of a removed message back to the arena.
+// Lock should be held.
+func (ms *memStore) releaseMsg(sm *StoreMsg) {
+	if ms.arena != nil {
+		ms.arena.release(sm.slab)
+	}
+}
+
+// Lets go of the arena's current slab when all messages were thrown away at once.
+// Lock should be held.
+func (ms *memStore) dropArena() {
+	if ms.arena != nil {
+		ms.arena.drop()
+	}
+}
+
 // Performs logic to update first sequence number.
 // Lock should be held.
 func (ms *memStore) updateFirstSeq(seq uint64) {
@@ -810,9 +1288,9 @@ func (ms *memStore) updateFirstSeq(seq uint64) {
 	}
 }
 
-// Remove a seq from the fss and select new first.
+// Remove a seq of size sz from the fss and select new first.
 // Lock should be held.
-func (ms *memStore) removeSeqPerSubject(subj string, seq uint64) {
+func (ms *memStore) removeSeqPerSubject(subj string, seq, sz uint64) {
 	ss := ms.fss[subj]
 	if ss == nil {
 		return
@@ -822,6 +1300,20 @@ func (ms *memStore) removeSeqPerSubject(subj string, seq uint64) {
 		return
 	}
 	ss.Msgs--
+	if ss.Bytes >= sz {
+		ss.Bytes -= sz
+	} else {
+		ss.Bytes = 0
+	}
+	// Move last back if we removed it.
+	if seq == ss.Last {
+		for tseq := seq - 1; tseq >= ss.First; tseq-- {
+			if sm := ms.msgs[tseq]; sm != nil && sm.subj == subj {
+				ss.Last = tseq
+				break
+			}
+		}
+	}
 	if seq != ss.First {
 		return
 	}
@@ -851,9 +1343,12 @@ func (ms *memStore) removeMsg(seq uint64, secure bool) bool {
 	ss = memStoreMsgSize(sm.subj, sm.hdr, sm.msg)
 
 	delete(ms.msgs, seq)
+	ms.releaseMsg(sm)
 	ms.state.Msgs--
 	ms.state.Bytes -= ss
 	ms.updateFirstSeq(seq)
+	delete(ms.sched, seq)
+	ms.walAppend(memWALRemove, seq, 0, _EMPTY_, nil, nil)
 
 	if secure {
 		if len(sm.hdr) > 0 {
@@ -868,7 +1363,7 @@ func (ms *memStore) removeMsg(seq uint64, secure bool) bool {
 	}
 
 	// Remove any per subject tracking.
-	ms.removeSeqPerSubject(sm.subj, seq)
+	ms.removeSeqPerSubject(sm.subj, seq, ss)
 
 	if ms.scb != nil {
 		// We do not want to hold any locks here.
@@ -918,19 +1413,177 @@ func (ms *memStore) State() StreamState {
 
 	// Calculate interior delete details.
 	if numDeleted := int((state.LastSeq - state.FirstSeq + 1) - state.Msgs); numDeleted > 0 {
-		state.Deleted = make([]uint64, 0, state.NumDeleted)
-		// TODO(dlc) - Too Simplistic, once state is updated to allow runs etc redo.
-		for seq := state.FirstSeq + 1; seq < ms.state.LastSeq; seq++ {
+		state.DeletedRanges = ms.deleteRanges(state.FirstSeq, state.LastSeq)
+	}
+	if len(state.DeletedRanges) > 0 {
+		state.NumDeleted = int(state.DeletedRanges.Num())
+	}
+
+	return state
+}
+
+// ScheduledMsgs returns the sequences of all messages withheld for later
+// delivery and when they are due in unix nanoseconds.
+func (ms *memStore) ScheduledMsgs() map[uint64]int64 {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	sched := make(map[uint64]int64, len(ms.sched))
+	for seq, due := range ms.sched {
+		sched[seq] = due
+	}
+	return sched
+}
+
+// IsScheduled returns true if the message at seq is withheld for later delivery.
+func (ms *memStore) IsScheduled(seq uint64) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	_, ok := ms.sched[seq]
+	return ok
+}
+
+// UnscheduleMsg will release the message at seq to consumers.
+// Returns true if the message was scheduled.
+func (ms *memStore) UnscheduleMsg(seq uint64) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	_, ok := ms.sched[seq]
+	delete(ms.sched, seq)
+	return ok
+}
+
+// Drops any scheduled entries for messages we no longer have.
+// Lock should be held.
+func (ms *memStore) pruneScheduleLocked() {
+	for seq := range ms.sched {
+		if _, ok := ms.msgs[seq]; !ok {
+			delete(ms.sched, seq)
+		}
+	}
+}
+
+// DeleteRanges will return the interior deletes between first and last inclusive.
+func (ms *memStore) DeleteRanges(first, last uint64) DeleteRanges {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.deleteRanges(first, last)
+}
+
+// Will return the interior deletes between first and last inclusive.
+// Lock should be held.
+func (ms *memStore) deleteRanges(first, last uint64) DeleteRanges {
+	if first < ms.state.FirstSeq {
+		first = ms.state.FirstSeq
+	}
+	if last > ms.state.LastSeq {
+		last = ms.state.LastSeq
+	}
+	if first > last || ms.state.Msgs == 0 || ms.state.Msgs >= ms.state.LastSeq-ms.state.FirstSeq+1 {
+		return nil
+	}
+	var drs DeleteRanges
+	// Walking the range is bounded by the number of messages we hold, otherwise
+	// we derive the gaps from the sorted sequences of our messages in the range.
+	if last-first+1 <= 2*ms.state.Msgs {
+		for seq := first; seq <= last; seq++ {
 			if _, ok := ms.msgs[seq]; !ok {
-				state.Deleted = append(state.Deleted, seq)
+				drs.add(seq, 1)
 			}
 		}
+		return drs
+	}
+	seqs := make([]uint64, 0, ms.state.Msgs)
+	for seq := range ms.msgs {
+		if seq >= first && seq <= last {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	next := first
+	for _, seq := range seqs {
+		if seq > next {
+			drs.add(next, seq-next)
+		}
+		next = seq + 1
 	}
-	if len(state.Deleted) > 0 {
-		state.NumDeleted = len(state.Deleted)
+	if next <= last {
+		drs.add(next, last-next+1)
 	}
+	return drs
+}
 
-	return state
+// StoreStats returns the rolling ingest and expiry rates for this store.
+func (ms *memStore) StoreStats() StoreStats {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.rates.stats(time.Now().Unix())
+}
+
+// AgeSummary returns the distribution of message ages, or nil if we have no messages.
+// Interior deletes are assumed to be spread evenly, so ranks map onto sequences.
+func (ms *memStore) AgeSummary() *StreamAgeSummary {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if ms.state.Msgs == 0 {
+		return nil
+	}
+	first, last := ms.state.FirstSeq, ms.state.LastSeq
+	at := func(pct uint64) time.Time {
+		for seq := first + (last-first)*pct/100; seq <= last; seq++ {
+			if sm := ms.msgs[seq]; sm != nil {
+				return time.Unix(0, sm.ts).UTC()
+			}
+		}
+		return ms.state.LastTime
+	}
+	return &StreamAgeSummary{Oldest: ms.state.FirstTime, P50: at(50), P90: at(10)}
+}
+
+// AgeHistogram returns how many messages and bytes fall into each age bucket.
+// Interior deletes are assumed to be spread evenly, so sequences map onto counts.
+func (ms *memStore) AgeHistogram(bounds []time.Duration) []*StreamAgeBucket {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	first, last := ms.state.FirstSeq, ms.state.LastSeq
+	return ageHistogram(bounds, ms.state.Msgs, ms.state.Bytes, func(ts int64) uint64 {
+		seq := ms.firstSeqAfter(ts)
+		return uint64(float64(ms.state.Msgs) * float64(last+1-seq) / float64(last-first+1))
+	})
+}
+
+// Returns the first sequence of a message stored after ts, or LastSeq+1 if there is none.
+// Lock should be held.
+func (ms *memStore) firstSeqAfter(ts int64) uint64 {
+	lo, hi := ms.state.FirstSeq, ms.state.LastSeq+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		seq, sm := mid, ms.msgs[mid]
+		for sm == nil && seq+1 < hi {
+			seq++
+			sm = ms.msgs[seq]
+		}
+		if sm == nil || sm.ts > ts {
+			hi = mid
+		} else {
+			lo = seq + 1
+		}
+	}
+	return lo
+}
+
+// PrefixUsage returns the messages and bytes we hold for the subjects starting with
+// each prefix of depth tokens.
+func (ms *memStore) PrefixUsage(depth int) map[string]*SubjectPrefixUsage {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	pu := make(map[string]*SubjectPrefixUsage)
+	for subj, ss := range ms.fss {
+		addPrefixUsage(pu, subj, depth, ss.Msgs, ss.Bytes)
+	}
+	return pu
 }
 
 func (ms *memStore) Utilization() (total, reported uint64, err error) {
@@ -943,13 +1596,19 @@ func memStoreMsgSize(subj string, hdr, msg []byte) uint64 {
 	return uint64(len(subj) + len(hdr) + len(msg) + 16) // 8*2 for seq + age
 }
 
-// Delete is same as Stop for memory store.
+// Delete is same as Stop for memory store, but will also remove any WAL.
 func (ms *memStore) Delete() error {
+	// Deleting is always allowed, so thaw to release our usage.
+	ms.mu.Lock()
+	ms.frozen = false
+	ms.mu.Unlock()
 	ms.Purge()
+	ms.stopWAL(true)
 	return ms.Stop()
 }
 
 func (ms *memStore) Stop() error {
+	ms.stopWAL(false)
 	ms.mu.Lock()
 	if ms.ageChk != nil {
 		ms.ageChk.Stop()
@@ -966,6 +1625,65 @@ func (ms *memStore) isClosed() bool {
 	return ms.msgs == nil
 }
 
+// SetFrozen will have the store reject new messages and any removals, including
+// limits and age based ones, while still serving reads. Limits are applied again once thawed.
+func (ms *memStore) SetFrozen(frozen bool) {
+	ms.mu.Lock()
+	thawed := ms.frozen && !frozen
+	ms.frozen = frozen
+	if thawed {
+		ms.enforceMsgLimit()
+		ms.enforceBytesLimit()
+		for _, ss := range ms.fss {
+			if ms.maxp > 0 && ss.Msgs > uint64(ms.maxp) {
+				ms.enforcePerSubjectLimit(ss)
+			}
+			if ms.cfg.MaxBytesPer > 0 && ss.Bytes > uint64(ms.cfg.MaxBytesPer) {
+				ms.enforcePerSubjectBytesLimit(ss)
+			}
+		}
+	}
+	maxAge := ms.cfg.expiryAge()
+	ms.mu.Unlock()
+
+	if thawed && maxAge != 0 {
+		ms.expireMsgs()
+	}
+}
+
+// IsFrozen returns if the store is frozen.
+func (ms *memStore) IsFrozen() bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.frozen
+}
+
+// SetHolds places the subjects matching any of subjects on hold, replacing the prior holds.
+// Messages on hold are skipped by limits and max age. Limits are applied again right away,
+// so messages of released subjects are removed if the stream is over its limits.
+func (ms *memStore) SetHolds(subjects []string) {
+	ms.mu.Lock()
+	ms.holds = copyStrings(subjects)
+	if !ms.frozen {
+		ms.enforceMsgLimit()
+		ms.enforceBytesLimit()
+		for _, ss := range ms.fss {
+			if ms.maxp > 0 && ss.Msgs > uint64(ms.maxp) {
+				ms.enforcePerSubjectLimit(ss)
+			}
+			if ms.cfg.MaxBytesPer > 0 && ss.Bytes > uint64(ms.cfg.MaxBytesPer) {
+				ms.enforcePerSubjectBytesLimit(ss)
+			}
+		}
+	}
+	maxAge, frozen := ms.cfg.expiryAge(), ms.frozen
+	ms.mu.Unlock()
+
+	if !frozen && maxAge != 0 {
+		ms.expireMsgs()
+	}
+}
+
 type consumerMemStore struct {
 	mu     sync.Mutex
 	ms     StreamStore
@@ -0,0 +1,133 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Default size of the slabs message payloads are carved from.
+	defaultMemArenaSlabSize = 1024 * 1024
+	// How many empty slabs an arena holds on to for reuse.
+	memArenaMaxFree = 4
+)
+
+// memArena carves message payloads for a memory store out of large slabs,
+// so a stream holds a handful of large allocations instead of one per message.
+// A slab is reused once every message in it was removed and nothing is
+// copying out of it anymore, which is tracked with a reference count.
+type memArena struct {
+	mu       sync.Mutex
+	slabSize int
+	cur      *memSlab
+	free     []*memSlab
+}
+
+type memSlab struct {
+	buf []byte
+	// Live messages, plus loads in flight, plus one for the arena while current.
+	refs int32
+}
+
+func newMemArena(slabSize int) *memArena {
+	if slabSize <= 0 {
+		slabSize = defaultMemArenaSlabSize
+	}
+	return &memArena{slabSize: slabSize}
+}
+
+// alloc returns an empty buffer with capacity n and the slab it belongs to, if any.
+// The caller holds a reference to the slab that needs to be released.
+func (a *memArena) alloc(n int) ([]byte, *memSlab) {
+	// Large messages are not worth packing.
+	if n > a.slabSize/4 {
+		return make([]byte, 0, n), nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cur == nil || len(a.cur.buf)+n > cap(a.cur.buf) {
+		if a.cur != nil {
+			a.releaseLocked(a.cur)
+		}
+		if nf := len(a.free); nf > 0 {
+			a.cur = a.free[nf-1]
+			a.free[nf-1] = nil
+			a.free = a.free[:nf-1]
+		} else {
+			a.cur = &memSlab{buf: make([]byte, 0, a.slabSize)}
+		}
+		atomic.StoreInt32(&a.cur.refs, 1)
+	}
+	sl := a.cur
+	off := len(sl.buf)
+	sl.buf = sl.buf[:off+n]
+	atomic.AddInt32(&sl.refs, 1)
+	// Limit the capacity so appends can not spill into the next message.
+	return sl.buf[off : off : off+n], sl
+}
+
+// acquire takes a reference on a slab, for instance while copying a message out.
+func (sl *memSlab) acquire() {
+	if sl != nil {
+		atomic.AddInt32(&sl.refs, 1)
+	}
+}
+
+// release drops a reference on a slab and makes it available for reuse when it was the last one.
+func (a *memArena) release(sl *memSlab) {
+	if sl == nil {
+		return
+	}
+	if atomic.AddInt32(&sl.refs, -1) == 0 {
+		a.mu.Lock()
+		a.recycleLocked(sl)
+		a.mu.Unlock()
+	}
+}
+
+// Lock should be held.
+func (a *memArena) releaseLocked(sl *memSlab) {
+	if atomic.AddInt32(&sl.refs, -1) == 0 {
+		a.recycleLocked(sl)
+	}
+}
+
+// Lock should be held.
+func (a *memArena) recycleLocked(sl *memSlab) {
+	if len(a.free) < memArenaMaxFree {
+		sl.buf = sl.buf[:0]
+		a.free = append(a.free, sl)
+	}
+}
+
+// drop lets go of the current slab without reusing it. Used when all messages are
+// thrown away at once without releasing them one by one, in which case their slabs
+// are left to the garbage collector.
+func (a *memArena) drop() {
+	a.mu.Lock()
+	a.cur = nil
+	a.mu.Unlock()
+}
+
+// enableArena has message payloads carved out of slabs of the given size.
+// Needs to be called before any messages are stored.
+func (ms *memStore) enableArena(slabSize int) {
+	ms.mu.Lock()
+	if ms.arena == nil {
+		ms.arena = newMemArena(slabSize)
+	}
+	ms.mu.Unlock()
+}
@@ -16,7 +16,10 @@ package server
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -200,6 +203,33 @@ func TestMemStoreTimeStamps(t *testing.T) {
 	}
 }
 
+func TestMemStoreGetSeqFromTime(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{Storage: MemoryStorage})
+	require_NoError(t, err)
+
+	subj, msg := "foo", []byte("Hello World")
+	var ts []int64
+	var smv StoreMsg
+	for i := 0; i < 100; i++ {
+		time.Sleep(time.Microsecond)
+		seq, _, err := ms.StoreMsg(subj, nil, msg)
+		require_NoError(t, err)
+		sm, err := ms.LoadMsg(seq, &smv)
+		require_NoError(t, err)
+		ts = append(ts, sm.ts)
+	}
+	// Remove interior messages.
+	for seq := uint64(20); seq < 60; seq++ {
+		_, err := ms.RemoveMsg(seq)
+		require_NoError(t, err)
+	}
+	require_True(t, ms.GetSeqFromTime(time.Unix(0, ts[9])) == 10)
+	require_True(t, ms.GetSeqFromTime(time.Unix(0, ts[79])) == 80)
+	// Times of removed messages give the next one present.
+	require_True(t, ms.GetSeqFromTime(time.Unix(0, ts[29])) == 60)
+	require_True(t, ms.GetSeqFromTime(time.Unix(0, ts[99]+1)) == 101)
+}
+
 func TestMemStorePurge(t *testing.T) {
 	ms, err := newMemStore(&StreamConfig{Storage: MemoryStorage})
 	if err != nil {
@@ -317,7 +347,7 @@ func TestMemStoreStreamStateDeleted(t *testing.T) {
 		}
 	}
 	state := ms.State()
-	if len(state.Deleted) != 0 {
+	if len(state.DeletedSeqs()) != 0 {
 		t.Fatalf("Expected deleted to be empty")
 	}
 	// Now remove some interior messages.
@@ -327,23 +357,23 @@ func TestMemStoreStreamStateDeleted(t *testing.T) {
 		expected = append(expected, seq)
 	}
 	state = ms.State()
-	if !reflect.DeepEqual(state.Deleted, expected) {
-		t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.Deleted)
+	if !reflect.DeepEqual(state.DeletedSeqs(), expected) {
+		t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.DeletedSeqs())
 	}
 	// Now fill the gap by deleting 1 and 3
 	ms.RemoveMsg(1)
 	ms.RemoveMsg(3)
 	expected = expected[2:]
 	state = ms.State()
-	if !reflect.DeepEqual(state.Deleted, expected) {
-		t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.Deleted)
+	if !reflect.DeepEqual(state.DeletedSeqs(), expected) {
+		t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.DeletedSeqs())
 	}
 	if state.FirstSeq != 5 {
 		t.Fatalf("Expected first seq to be 5, got %d", state.FirstSeq)
 	}
 	ms.Purge()
-	if state = ms.State(); len(state.Deleted) != 0 {
-		t.Fatalf("Expected no deleted after purge, got %+v\n", state.Deleted)
+	if state = ms.State(); len(state.DeletedSeqs()) != 0 {
+		t.Fatalf("Expected no deleted after purge, got %+v\n", state.DeletedSeqs())
 	}
 }
 
@@ -400,8 +430,8 @@ func TestMemStoreStreamTruncate(t *testing.T) {
 		t.Fatalf("Expected only 1 subject, got %d", state.NumSubjects)
 	}
 	expected := []uint64{10, 20}
-	if !reflect.DeepEqual(state.Deleted, expected) {
-		t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.Deleted)
+	if !reflect.DeepEqual(state.DeletedSeqs(), expected) {
+		t.Fatalf("Expected deleted to be %+v, got %+v\n", expected, state.DeletedSeqs())
 	}
 }
 
@@ -520,3 +550,731 @@ func TestMemStoreStreamCompactMultiBlockSubjectInfo(t *testing.T) {
 	state := ms.State()
 	require_True(t, state.NumSubjects == 500)
 }
+
+func TestMemStoreStoreStats(t *testing.T) {
+	cfg := &StreamConfig{
+		Name:     "TEST",
+		Storage:  MemoryStorage,
+		Subjects: []string{"foo"},
+		MaxAge:   100 * time.Millisecond,
+	}
+	ms, err := newMemStore(cfg)
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	msg := []byte("Hello World")
+	for i := 0; i < 100; i++ {
+		_, _, err := ms.StoreMsg("foo", nil, msg)
+		require_NoError(t, err)
+	}
+	msz := memStoreMsgSize("foo", nil, msg)
+
+	ss := ms.StoreStats()
+	require_True(t, ss.InMsgsRate == 100.0/storeRateWindow)
+	require_True(t, ss.InBytesRate == float64(100*msz)/storeRateWindow)
+	require_True(t, ss.ExpiredMsgsRate == 0)
+
+	checkFor(t, time.Second, 20*time.Millisecond, func() error {
+		if state := ms.State(); state.Msgs != 0 {
+			return fmt.Errorf("Expected no msgs, got %d", state.Msgs)
+		}
+		return nil
+	})
+	ss = ms.StoreStats()
+	require_True(t, ss.ExpiredMsgsRate == 100.0/storeRateWindow)
+	require_True(t, ss.ExpiredBytesRate == float64(100*msz)/storeRateWindow)
+}
+
+func TestMemStoreAgeSummary(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{Name: "TEST", Storage: MemoryStorage, Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	require_True(t, ms.AgeSummary() == nil)
+
+	// One message a second, so ranks and seconds line up.
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i := 0; i < 101; i++ {
+		require_NoError(t, ms.StoreRawMsg("foo", nil, []byte("ok"), uint64(i+1), base.Add(time.Duration(i)*time.Second).UnixNano()))
+	}
+
+	as := ms.AgeSummary()
+	require_True(t, as != nil)
+	require_True(t, as.Oldest.Equal(base))
+	require_True(t, as.P90.Equal(base.Add(10*time.Second)))
+	require_True(t, as.P50.Equal(base.Add(50*time.Second)))
+
+	// Interior deletes skip ahead to the next message.
+	for seq := uint64(51); seq <= 55; seq++ {
+		_, err = ms.RemoveMsg(seq)
+		require_NoError(t, err)
+	}
+	require_True(t, ms.AgeSummary().P50.Equal(base.Add(55*time.Second)))
+}
+
+func TestMemStoreExpiryBatch(t *testing.T) {
+	cfg := &StreamConfig{
+		Name:        "TEST",
+		Storage:     MemoryStorage,
+		Subjects:    []string{"foo"},
+		MaxAge:      100 * time.Millisecond,
+		ExpiryBatch: 500 * time.Millisecond,
+	}
+	ms, err := newMemStore(cfg)
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	start := time.Now()
+	_, _, err = ms.StoreMsg("foo", nil, []byte("Hello World"))
+	require_NoError(t, err)
+
+	// Should not have been expired before the batch window.
+	time.Sleep(250 * time.Millisecond)
+	require_True(t, ms.State().Msgs == 1)
+
+	checkFor(t, time.Second, 20*time.Millisecond, func() error {
+		if state := ms.State(); state.Msgs != 0 {
+			return fmt.Errorf("Expected no msgs, got %d", state.Msgs)
+		}
+		return nil
+	})
+	require_True(t, time.Since(start) >= 500*time.Millisecond)
+}
+
+func TestMemStoreWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &StreamConfig{
+		Name:      "TEST",
+		Storage:   MemoryStorage,
+		Subjects:  []string{"foo.*"},
+		MemoryWAL: &MemoryWAL{FlushInterval: 10 * time.Millisecond},
+	}
+	ms, err := newMemStore(cfg)
+	require_NoError(t, err)
+	require_NoError(t, ms.enableWAL(dir, time.Now().UTC()))
+
+	for i := 0; i < 10; i++ {
+		_, _, err := ms.StoreMsg(fmt.Sprintf("foo.%d", i%2), []byte("hdr"), []byte("Hello World"))
+		require_NoError(t, err)
+	}
+	ms.SkipMsg()
+	_, err = ms.RemoveMsg(5)
+	require_NoError(t, err)
+	_, err = ms.Compact(3)
+	require_NoError(t, err)
+	_, err = ms.RemoveRange(7, 8)
+	require_NoError(t, err)
+	expected := ms.State()
+
+	// Wait for the flusher to write everything out.
+	checkFor(t, time.Second, 10*time.Millisecond, func() error {
+		ms.mu.RLock()
+		pending := len(ms.wal.buf)
+		ms.mu.RUnlock()
+		if pending > 0 {
+			return fmt.Errorf("Still have %d bytes pending", pending)
+		}
+		return nil
+	})
+	ms.Stop()
+
+	ms, err = newMemStore(cfg)
+	require_NoError(t, err)
+	require_NoError(t, ms.enableWAL(dir, time.Now().UTC()))
+	defer ms.Stop()
+
+	state := ms.State()
+	require_True(t, state.Msgs == expected.Msgs)
+	require_True(t, state.Bytes == expected.Bytes)
+	require_True(t, state.FirstSeq == expected.FirstSeq)
+	require_True(t, state.LastSeq == expected.LastSeq)
+	require_True(t, state.NumDeleted == expected.NumDeleted)
+
+	sm, err := ms.LoadMsg(4, nil)
+	require_NoError(t, err)
+	require_True(t, sm.subj == "foo.1")
+	require_True(t, string(sm.hdr) == "hdr")
+	require_True(t, string(sm.msg) == "Hello World")
+	_, err = ms.LoadMsg(5, nil)
+	require_Error(t, err)
+
+	// New messages should pick up after the skipped sequence.
+	seq, _, err := ms.StoreMsg("foo.1", nil, nil)
+	require_NoError(t, err)
+	require_True(t, seq == 12)
+
+	// A torn trailing record should be ignored.
+	ms.Stop()
+	fd, err := os.OpenFile(filepath.Join(dir, memWALFile), os.O_WRONLY|os.O_APPEND, defaultFilePerms)
+	require_NoError(t, err)
+	fd.Write([]byte{memWALStore, 100, 0, 0})
+	fd.Close()
+
+	ms, err = newMemStore(cfg)
+	require_NoError(t, err)
+	require_NoError(t, ms.enableWAL(dir, time.Now().UTC()))
+	state = ms.State()
+	require_True(t, state.Msgs == expected.Msgs+1)
+	require_True(t, state.LastSeq == 12)
+
+	// Delete should remove the log.
+	require_NoError(t, ms.Delete())
+	_, err = os.Stat(filepath.Join(dir, memWALFile))
+	require_True(t, os.IsNotExist(err))
+}
+
+func TestMemStoreWALMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &StreamConfig{
+		Name:      "TEST",
+		Storage:   MemoryStorage,
+		Subjects:  []string{"foo"},
+		MaxMsgs:   10,
+		MemoryWAL: &MemoryWAL{FlushInterval: 10 * time.Millisecond, MaxBytes: 4 * 1024},
+	}
+	ms, err := newMemStore(cfg)
+	require_NoError(t, err)
+	require_NoError(t, ms.enableWAL(dir, time.Now().UTC()))
+	defer ms.Stop()
+
+	msg := bytes.Repeat([]byte("Z"), 128)
+	for i := 0; i < 500; i++ {
+		_, _, err := ms.StoreMsg("foo", nil, msg)
+		require_NoError(t, err)
+	}
+
+	// Once flushed the log should have been rewritten to hold only the last 10 msgs.
+	checkFor(t, 2*time.Second, 20*time.Millisecond, func() error {
+		fi, err := os.Stat(filepath.Join(dir, memWALFile))
+		if err != nil {
+			return err
+		}
+		if fi.Size() > 4*1024 {
+			return fmt.Errorf("WAL is %d bytes", fi.Size())
+		}
+		return nil
+	})
+}
+
+func TestMemStoreSubjectsStateRange(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: MemoryStorage})
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 20; j++ {
+			_, _, err := ms.StoreMsg(fmt.Sprintf("foo.%02d", j), nil, []byte("Hello World"))
+			require_NoError(t, err)
+		}
+	}
+
+	all := ms.SubjectsState("foo.*")
+	mss, total := ms.SubjectsStateRange("foo.*", _EMPTY_, 5)
+	require_True(t, total == 20)
+	require_True(t, len(mss) == 5)
+	for j := 0; j < 5; j++ {
+		subj := fmt.Sprintf("foo.%02d", j)
+		require_True(t, mss[subj] == all[subj])
+	}
+
+	mss, total = ms.SubjectsStateRange("foo.*", "foo.17", 5)
+	require_True(t, total == 20)
+	require_True(t, len(mss) == 2)
+	require_True(t, mss["foo.19"] == all["foo.19"])
+
+	mss, total = ms.SubjectsStateRange("bar.*", _EMPTY_, 0)
+	require_True(t, total == 0)
+	require_True(t, len(mss) == 0)
+}
+
+func TestMemStoreSubjectsStateBytes(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: MemoryStorage})
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	msg := []byte("Hello World")
+	hdr := []byte("NATS/1.0\r\nX: Y\r\n\r\n")
+	for i := 0; i < 10; i++ {
+		_, _, err := ms.StoreMsg("foo.a", nil, msg)
+		require_NoError(t, err)
+		_, _, err = ms.StoreMsg("foo.b", hdr, msg)
+		require_NoError(t, err)
+	}
+	asz, bsz := memStoreMsgSize("foo.a", nil, msg), memStoreMsgSize("foo.b", hdr, msg)
+
+	checkBytes := func(a, b uint64) {
+		t.Helper()
+		fss := ms.SubjectsState("foo.*")
+		require_True(t, fss["foo.a"].Bytes == a)
+		require_True(t, fss["foo.b"].Bytes == b)
+		mss, _ := ms.SubjectsStateRange("foo.*", _EMPTY_, 0)
+		require_True(t, reflect.DeepEqual(mss, fss))
+		require_True(t, a+b == ms.State().Bytes)
+	}
+	checkBytes(10*asz, 10*bsz)
+
+	_, err = ms.RemoveMsg(5)
+	require_NoError(t, err)
+	_, err = ms.RemoveMsg(2)
+	require_NoError(t, err)
+	checkBytes(9*asz, 9*bsz)
+
+	_, err = ms.Compact(10)
+	require_NoError(t, err)
+	checkBytes(5*asz, 6*bsz)
+
+	require_NoError(t, ms.Truncate(16))
+	checkBytes(3*asz, 4*bsz)
+}
+
+func TestMemStoreMaxBytesPerSubject(t *testing.T) {
+	msg := []byte("Hello World")
+	msz := memStoreMsgSize("foo.a", nil, msg)
+
+	cfg := &StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: MemoryStorage, MaxBytesPer: int64(3 * msz)}
+	ms, err := newMemStore(cfg)
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	var evicted []uint64
+	ms.RegisterEvictionHandler(func(m *EvictedMsg) bool {
+		require_True(t, m.Reason == EvictMaxBytesPer)
+		evicted = append(evicted, m.Sequence)
+		return true
+	})
+
+	for i := 0; i < 10; i++ {
+		_, _, err := ms.StoreMsg("foo.a", nil, msg)
+		require_NoError(t, err)
+	}
+	_, _, err = ms.StoreMsg("foo.b", nil, msg)
+	require_NoError(t, err)
+
+	ss := ms.SubjectsState("foo.a")["foo.a"]
+	require_True(t, ss.Msgs == 3)
+	require_True(t, ss.Bytes == 3*msz)
+	require_True(t, ss.First == 8)
+	require_True(t, len(evicted) == 7)
+	require_True(t, ms.State().Msgs == 4)
+
+	// A single message larger than the limit is kept.
+	big := bytes.Repeat([]byte("Z"), int(4*msz))
+	seq, _, err := ms.StoreMsg("foo.a", nil, big)
+	require_NoError(t, err)
+	ss = ms.SubjectsState("foo.a")["foo.a"]
+	require_True(t, ss.Msgs == 1)
+	require_True(t, ss.First == seq)
+
+	// Lowering the limit should be enforced on update.
+	cfg.MaxBytesPer = int64(msz)
+	require_NoError(t, ms.UpdateConfig(cfg))
+	ss = ms.SubjectsState("foo.b")["foo.b"]
+	require_True(t, ss.Msgs == 1)
+	require_True(t, ss.Bytes == msz)
+}
+
+func TestMemStoreEvictionHandler(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{Storage: MemoryStorage, MaxAge: 50 * time.Millisecond, MaxBytes: 1024})
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	var mu sync.Mutex
+	reasons := make(map[EvictionReason]int)
+	veto := true
+	ms.RegisterEvictionHandler(func(m *EvictedMsg) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if veto && m.Reason == EvictMaxAge {
+			return false
+		}
+		reasons[m.Reason]++
+		return true
+	})
+
+	msg := bytes.Repeat([]byte("Z"), 256)
+	for i := 0; i < 5; i++ {
+		_, _, err := ms.StoreMsg("foo", nil, msg)
+		require_NoError(t, err)
+	}
+	mu.Lock()
+	require_True(t, reasons[EvictMaxBytes] == 2)
+	mu.Unlock()
+
+	// Expiry is vetoed so messages stay.
+	time.Sleep(150 * time.Millisecond)
+	require_True(t, ms.State().Msgs == 3)
+
+	// Allow and make sure we expire on the next check.
+	mu.Lock()
+	veto = false
+	mu.Unlock()
+	ms.expireMsgs()
+	require_True(t, ms.State().Msgs == 0)
+	mu.Lock()
+	require_True(t, reasons[EvictMaxAge] == 3)
+	mu.Unlock()
+}
+
+func TestMemStoreRemoveRange(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: MemoryStorage})
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	var removedMsgs, removedBytes int64
+	ms.RegisterStorageUpdates(func(md, bd int64, seq uint64, subj string) {
+		if md < 0 {
+			removedMsgs, removedBytes = removedMsgs-md, removedBytes-bd
+		}
+	})
+
+	for i := 0; i < 100; i++ {
+		_, _, err := ms.StoreMsg(fmt.Sprintf("foo.%d", i%4), nil, []byte("ok"))
+		require_NoError(t, err)
+	}
+	_, err = ms.RemoveMsg(30)
+	require_NoError(t, err)
+	before := ms.State()
+	removedMsgs, removedBytes = 0, 0
+
+	_, err = ms.RemoveRange(0, 10)
+	require_Error(t, err, ErrInvalidSequenceRange)
+	_, err = ms.RemoveRange(20, 10)
+	require_Error(t, err, ErrInvalidSequenceRange)
+
+	// Interior range, including a message that was already removed.
+	removed, err := ms.RemoveRange(21, 40)
+	require_NoError(t, err)
+	require_True(t, removed == 19)
+	require_True(t, removedMsgs == 19)
+	state := ms.State()
+	require_True(t, state.Msgs == 80)
+	require_True(t, state.FirstSeq == 1)
+	require_True(t, state.NumDeleted == 20)
+	require_True(t, before.Bytes-state.Bytes == uint64(removedBytes))
+	require_True(t, ms.SubjectsState("foo.0")["foo.0"].Msgs == 20)
+
+	// Range at the front moves our first sequence.
+	removed, err = ms.RemoveRange(1, 50)
+	require_NoError(t, err)
+	require_True(t, removed == 30)
+	state = ms.State()
+	require_True(t, state.Msgs == 50)
+	require_True(t, state.FirstSeq == 51)
+	require_True(t, state.NumDeleted == 0)
+
+	// Past our last is fine and removes everything left.
+	removed, err = ms.RemoveRange(51, 1000)
+	require_NoError(t, err)
+	require_True(t, removed == 50)
+	state = ms.State()
+	require_True(t, state.Msgs == 0)
+	require_True(t, state.Bytes == 0)
+	require_True(t, state.FirstSeq == 101)
+	require_True(t, len(ms.SubjectsState("foo.*")) == 0)
+}
+
+func TestMemStoreArena(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{Name: "zzz", Subjects: []string{"foo.*"}, Storage: MemoryStorage})
+	require_NoError(t, err)
+	defer ms.Stop()
+	ms.enableArena(1024)
+
+	payload := func(seq uint64) []byte {
+		return bytes.Repeat([]byte{byte('A' + seq%26)}, 90)
+	}
+	check := func(seq uint64) {
+		t.Helper()
+		sm, err := ms.LoadMsg(seq, nil)
+		require_NoError(t, err)
+		require_True(t, string(sm.hdr) == "HDR:")
+		require_True(t, bytes.Equal(sm.msg, payload(seq)))
+		// Loads must not hand out arena memory.
+		require_True(t, sm.slab == nil)
+	}
+
+	// 94 bytes each, so 10 messages per slab.
+	for seq := uint64(1); seq <= 30; seq++ {
+		_, _, err := ms.StoreMsg("foo.bar", []byte("HDR:"), payload(seq))
+		require_NoError(t, err)
+	}
+	ms.mu.RLock()
+	s1, s2 := ms.msgs[1].slab, ms.msgs[11].slab
+	require_True(t, s1 != nil && s1 == ms.msgs[10].slab)
+	require_True(t, s2 != nil && s2 != s1)
+	ms.mu.RUnlock()
+	for seq := uint64(1); seq <= 30; seq++ {
+		check(seq)
+	}
+
+	// Removing all of the messages in a slab makes it available again.
+	for seq := uint64(1); seq <= 10; seq++ {
+		_, err := ms.RemoveMsg(seq)
+		require_NoError(t, err)
+	}
+	ms.arena.mu.Lock()
+	require_True(t, len(ms.arena.free) == 1 && ms.arena.free[0] == s1)
+	ms.arena.mu.Unlock()
+
+	// An in flight load holds on to the slab.
+	s2.acquire()
+	_, err = ms.RemoveRange(11, 20)
+	require_NoError(t, err)
+	ms.arena.mu.Lock()
+	require_True(t, len(ms.arena.free) == 1)
+	ms.arena.mu.Unlock()
+	ms.arena.release(s2)
+	ms.arena.mu.Lock()
+	require_True(t, len(ms.arena.free) == 2)
+	ms.arena.mu.Unlock()
+
+	// New messages reuse the slabs without touching what is still stored.
+	for seq := uint64(31); seq <= 60; seq++ {
+		_, _, err := ms.StoreMsg("foo.bar", []byte("HDR:"), payload(seq))
+		require_NoError(t, err)
+	}
+	ms.mu.RLock()
+	require_True(t, ms.msgs[41].slab == s1 || ms.msgs[41].slab == s2)
+	ms.mu.RUnlock()
+	for seq := uint64(21); seq <= 60; seq++ {
+		check(seq)
+	}
+
+	// Large messages get their own buffer.
+	seq, _, err := ms.StoreMsg("foo.big", nil, make([]byte, 512))
+	require_NoError(t, err)
+	ms.mu.RLock()
+	require_True(t, ms.msgs[seq].slab == nil)
+	ms.mu.RUnlock()
+
+	// Loads racing removals and new messages.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for seq := uint64(21); seq <= 60; seq++ {
+			sm, err := ms.LoadMsg(seq, nil)
+			if err == nil && !bytes.Equal(sm.msg, payload(seq)) {
+				t.Errorf("Corrupt payload for seq %d", seq)
+			}
+		}
+	}()
+	for seq := uint64(21); seq <= 60; seq++ {
+		ms.RemoveMsg(seq)
+		ms.StoreMsg("foo.bar", []byte("HDR:"), payload(seq))
+	}
+	wg.Wait()
+
+	_, err = ms.Purge()
+	require_NoError(t, err)
+	state := ms.State()
+	require_True(t, state.Msgs == 0 && state.Bytes == 0)
+}
+
+func TestMemStoreStoreMsgIfLastSubjSeq(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{Name: "TEST", Storage: MemoryStorage, Subjects: []string{"kv.>"}})
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	seq, _, err := ms.StoreMsgIfLastSubjSeq("kv.a", nil, []byte("1"), 0)
+	require_NoError(t, err)
+	require_True(t, seq == 1)
+	_, _, err = ms.StoreMsgIfLastSubjSeq("kv.a", nil, []byte("2"), 0)
+	require_Error(t, err, ErrLastSubjSeqMismatch)
+
+	// Other subjects do not count.
+	_, _, err = ms.StoreMsg("kv.b", nil, []byte("1"))
+	require_NoError(t, err)
+	seq, _, err = ms.StoreMsgIfLastSubjSeq("kv.a", nil, []byte("2"), 1)
+	require_NoError(t, err)
+	require_True(t, seq == 3)
+
+	// Removing the last message for a subject moves it back.
+	_, err = ms.RemoveMsg(3)
+	require_NoError(t, err)
+	_, _, err = ms.StoreMsgIfLastSubjSeq("kv.a", nil, []byte("3"), 3)
+	require_Error(t, err, ErrLastSubjSeqMismatch)
+	seq, _, err = ms.StoreMsgIfLastSubjSeq("kv.a", nil, []byte("3"), 1)
+	require_NoError(t, err)
+	require_True(t, seq == 4)
+
+	state := ms.State()
+	require_True(t, state.Msgs == 3)
+	require_True(t, state.LastSeq == 4)
+}
+
+func TestMemStoreDeleteRanges(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{Name: "TEST", Storage: MemoryStorage, Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	for i := 0; i < 100; i++ {
+		_, _, err = ms.StoreMsg("foo", nil, []byte("ok"))
+		require_NoError(t, err)
+	}
+	require_True(t, ms.State().DeletedRanges == nil)
+
+	for _, seq := range []uint64{10, 20, 21, 22, 23, 50} {
+		_, err = ms.RemoveMsg(seq)
+		require_NoError(t, err)
+	}
+	state := ms.State()
+	expected := DeleteRanges{{10, 1}, {20, 4}, {50, 1}}
+	if !reflect.DeepEqual(state.DeletedRanges, expected) {
+		t.Fatalf("Expected deleted ranges to be %+v, got %+v", expected, state.DeletedRanges)
+	}
+	require_True(t, state.NumDeleted == 6)
+	require_True(t, state.DeletedRanges.Contains(21))
+	require_False(t, state.DeletedRanges.Contains(24))
+
+	expected = DeleteRanges{{21, 3}}
+	if drs := ms.DeleteRanges(21, 49); !reflect.DeepEqual(drs, expected) {
+		t.Fatalf("Expected deleted ranges to be %+v, got %+v", expected, drs)
+	}
+	require_True(t, ms.DeleteRanges(24, 49) == nil)
+
+	// Mostly deleted, so derived from our messages instead of walking the range.
+	for i := 0; i < 100_000; i++ {
+		_, _, err = ms.StoreMsg("foo", nil, []byte("ok"))
+		require_NoError(t, err)
+	}
+	_, err = ms.RemoveRange(102, 100_050)
+	require_NoError(t, err)
+	expected = DeleteRanges{{10, 1}, {20, 4}, {50, 1}, {102, 99_949}}
+	if drs := ms.DeleteRanges(1, 100_100); !reflect.DeepEqual(drs, expected) {
+		t.Fatalf("Expected deleted ranges to be %+v, got %+v", expected, drs)
+	}
+	expected = DeleteRanges{{50_001, 50_050}}
+	if drs := ms.DeleteRanges(50_001, 200_000); !reflect.DeepEqual(drs, expected) {
+		t.Fatalf("Expected deleted ranges to be %+v, got %+v", expected, drs)
+	}
+}
+
+func TestMemStoreRemapSubjects(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &StreamConfig{
+		Name:      "TEST",
+		Storage:   MemoryStorage,
+		Subjects:  []string{"orders.>"},
+		MemoryWAL: &MemoryWAL{FlushInterval: 10 * time.Millisecond},
+	}
+	ms, err := newMemStore(cfg)
+	require_NoError(t, err)
+	require_NoError(t, ms.enableWAL(dir, time.Now().UTC()))
+
+	for i := 0; i < 10; i++ {
+		_, _, err := ms.StoreMsg(fmt.Sprintf("orders.old.%d", i%3), nil, []byte("ok"))
+		require_NoError(t, err)
+	}
+	_, err = ms.RemoveMsg(2)
+	require_NoError(t, err)
+	before := ms.State()
+
+	// Only remap up to and including sequence 8.
+	n, err := ms.RemapSubjects("orders.old.*", "orders.v2.$1", 8)
+	require_NoError(t, err)
+	require_True(t, n == 7)
+
+	state := ms.State()
+	require_True(t, state.Msgs == before.Msgs)
+	// New subjects are one byte shorter.
+	require_True(t, state.Bytes == before.Bytes-7)
+	require_True(t, ms.SubjectsState("orders.v2.*")["orders.v2.0"].Msgs == 3)
+	require_True(t, ms.SubjectsState("orders.v2.*")["orders.v2.1"].Msgs == 2)
+	require_True(t, ms.SubjectsState("orders.old.*")["orders.old.0"].Msgs == 1)
+	require_True(t, ms.SubjectsState("orders.old.*")["orders.old.2"].Msgs == 1)
+	require_True(t, ms.FilteredState(1, "orders.v2.>").Msgs == 7)
+
+	sm, err := ms.LoadMsg(4, nil)
+	require_NoError(t, err)
+	require_Equal(t, sm.subj, "orders.v2.0")
+	sm, err = ms.LoadLastMsg("orders.old.0", nil)
+	require_NoError(t, err)
+	require_True(t, sm.seq == 10)
+
+	// Remaps should be replayed from the WAL.
+	expected := ms.State()
+	checkFor(t, time.Second, 10*time.Millisecond, func() error {
+		ms.mu.RLock()
+		pending := len(ms.wal.buf)
+		ms.mu.RUnlock()
+		if pending > 0 {
+			return fmt.Errorf("Still have %d bytes pending", pending)
+		}
+		return nil
+	})
+	ms.Stop()
+
+	ms, err = newMemStore(cfg)
+	require_NoError(t, err)
+	require_NoError(t, ms.enableWAL(dir, time.Now().UTC()))
+	defer ms.Stop()
+
+	state = ms.State()
+	require_True(t, state.Msgs == expected.Msgs)
+	require_True(t, state.Bytes == expected.Bytes)
+	require_True(t, ms.FilteredState(1, "orders.v2.>").Msgs == 7)
+	sm, err = ms.LoadMsg(4, nil)
+	require_NoError(t, err)
+	require_Equal(t, sm.subj, "orders.v2.0")
+}
+
+func TestMemStoreScheduledMsgs(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{Name: "TEST", Storage: MemoryStorage})
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	delay := []byte("NATS/1.0\r\nNats-Delay: 1h\r\n\r\n")
+	at := []byte(fmt.Sprintf("NATS/1.0\r\nNats-Deliver-At: %s\r\n\r\n", time.Now().Add(time.Hour).Format(time.RFC3339Nano)))
+	past := []byte("NATS/1.0\r\nNats-Deliver-At: 2020-01-01T00:00:00Z\r\n\r\n")
+	for _, hdr := range [][]byte{nil, delay, at, past, delay} {
+		_, _, err := ms.StoreMsg("foo", hdr, []byte("ok"))
+		require_NoError(t, err)
+	}
+
+	sched := ms.ScheduledMsgs()
+	require_True(t, len(sched) == 3)
+	sm, err := ms.LoadMsg(2, nil)
+	require_NoError(t, err)
+	require_True(t, sched[2] == sm.ts+int64(time.Hour))
+	require_False(t, ms.IsScheduled(1))
+	require_False(t, ms.IsScheduled(4))
+
+	// Removing a scheduled message drops it from the schedule.
+	_, err = ms.RemoveMsg(3)
+	require_NoError(t, err)
+	require_False(t, ms.IsScheduled(3))
+
+	require_True(t, ms.UnscheduleMsg(2))
+	require_False(t, ms.UnscheduleMsg(2))
+	require_False(t, ms.IsScheduled(2))
+
+	require_True(t, ms.IsScheduled(5))
+	_, err = ms.Compact(6)
+	require_NoError(t, err)
+	require_True(t, len(ms.ScheduledMsgs()) == 0)
+}
+
+func TestMemStoreSubjectRetention(t *testing.T) {
+	ms, err := newMemStore(&StreamConfig{
+		Storage: MemoryStorage, Subjects: []string{"audit.*", "metrics.*"},
+		MaxAge:           time.Minute,
+		SubjectRetention: []*SubjectRetention{{Subject: "metrics.*", MaxAge: 100 * time.Millisecond}},
+	})
+	require_NoError(t, err)
+	defer ms.Stop()
+
+	for _, subj := range []string{"audit.a", "metrics.a", "audit.b", "metrics.a"} {
+		_, _, err := ms.StoreMsg(subj, nil, nil)
+		require_NoError(t, err)
+	}
+	checkFor(t, time.Second, 20*time.Millisecond, func() error {
+		if state := ms.State(); state.Msgs != 2 {
+			return fmt.Errorf("Expected 2 msgs, got %d", state.Msgs)
+		}
+		return nil
+	})
+	ss := ms.SubjectsState(">")
+	require_True(t, len(ss) == 2)
+	require_True(t, ss["audit.a"].Msgs == 1 && ss["audit.b"].Msgs == 1)
+	require_True(t, ms.State().FirstSeq == 1)
+}
@@ -0,0 +1,419 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/minio/highwayhash"
+)
+
+const (
+	// Name of the write-ahead log file inside the stream directory.
+	memWALFile = "mem.wal"
+	// Default interval at which buffered records are written out.
+	memWALDefaultFlushInterval = time.Second
+	// Default size at which the log is rewritten from current state.
+	memWALDefaultMaxBytes = 64 * 1024 * 1024
+	// op(1) + payload len(4)
+	memWALHdrSize = 5
+	// crc32 trailer
+	memWALCRCSize = 4
+)
+
+// Record types for the memory WAL.
+const (
+	memWALStore byte = iota + 1
+	memWALRemove
+	memWALSkip
+	memWALPurge
+	memWALCompact
+	memWALTruncate
+	memWALRemoveRange
+	memWALRemap
+)
+
+var errMemWALCorrupt = errors.New("memory WAL record corrupt")
+
+// memWAL is an asynchronous write-ahead log for a memStore.
+// Records are buffered under the memStore lock and written out
+// by a background flusher, so a crash may lose at most one flush interval.
+type memWAL struct {
+	mu   sync.Mutex // Serializes file access.
+	dir  string
+	ctm  time.Time
+	fd   *os.File
+	size int64
+	buf  []byte // Protected by the memStore lock.
+	fint time.Duration
+	maxb int64
+	qch  chan struct{}
+	done chan struct{}
+}
+
+// enableWAL will replay any existing log in dir and start logging new records.
+// Should be called before the store is in use.
+func (ms *memStore) enableWAL(dir string, created time.Time) error {
+	if err := os.MkdirAll(dir, defaultDirPerms); err != nil {
+		return err
+	}
+	if err := ms.writeWALMeta(dir, created); err != nil {
+		return err
+	}
+	fn := filepath.Join(dir, memWALFile)
+	if err := ms.replayWAL(fn); err != nil {
+		return err
+	}
+
+	w := &memWAL{
+		dir:  dir,
+		ctm:  created,
+		fint: memWALDefaultFlushInterval,
+		maxb: memWALDefaultMaxBytes,
+		qch:  make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if cfg := ms.cfg.MemoryWAL; cfg != nil {
+		if cfg.FlushInterval > 0 {
+			w.fint = cfg.FlushInterval
+		}
+		if cfg.MaxBytes > 0 {
+			w.maxb = cfg.MaxBytes
+		}
+	}
+
+	ms.mu.Lock()
+	ms.wal = w
+	ms.mu.Unlock()
+
+	// Rewrite from what we recovered, this also drops any partial trailing record.
+	if err := ms.compactWAL(); err != nil {
+		ms.mu.Lock()
+		ms.wal = nil
+		ms.mu.Unlock()
+		return err
+	}
+	go ms.flushWALLoop(w)
+	return nil
+}
+
+// Write our config in the same format as the filestore so we are recovered on restart.
+func (ms *memStore) writeWALMeta(dir string, created time.Time) error {
+	ms.mu.RLock()
+	b, err := json.Marshal(FileStreamInfo{Created: created, StreamConfig: ms.cfg})
+	name := ms.cfg.Name
+	ms.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	key := sha256.Sum256([]byte(name))
+	hh, err := highwayhash.New64(key[:])
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, JetStreamMetaFile), b, defaultFilePerms); err != nil {
+		return err
+	}
+	hh.Write(b)
+	checksum := hex.EncodeToString(hh.Sum(nil))
+	return os.WriteFile(filepath.Join(dir, JetStreamMetaFileSum), []byte(checksum), defaultFilePerms)
+}
+
+// Appends a record to the pending buffer.
+// Lock should be held.
+func (ms *memStore) walAppend(op byte, seq uint64, ts int64, subj string, hdr, msg []byte) {
+	if ms.wal == nil {
+		return
+	}
+	ms.wal.buf = appendWALRecord(ms.wal.buf, op, seq, ts, subj, hdr, msg)
+}
+
+// Encodes a single record.
+// Store records carry seq, ts, subject, header and message, range removes carry
+// the first seq and the last in place of ts, remaps carry the last seq, the filter
+// as subject and the destination as message, all others only seq.
+func appendWALRecord(buf []byte, op byte, seq uint64, ts int64, subj string, hdr, msg []byte) []byte {
+	plen := 8
+	if op == memWALStore {
+		plen += 8 + 2 + len(subj) + 4 + len(hdr) + len(msg)
+	} else if op == memWALRemoveRange {
+		plen += 8
+	} else if op == memWALRemap {
+		plen += 2 + len(subj) + len(msg)
+	}
+	start := len(buf)
+	buf = append(buf, op)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(plen))
+	buf = binary.LittleEndian.AppendUint64(buf, seq)
+	if op == memWALStore {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(ts))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(subj)))
+		buf = append(buf, subj...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(hdr)))
+		buf = append(buf, hdr...)
+		buf = append(buf, msg...)
+	} else if op == memWALRemoveRange {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(ts))
+	} else if op == memWALRemap {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(subj)))
+		buf = append(buf, subj...)
+		buf = append(buf, msg...)
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:]))
+}
+
+// Replays the log at fn into the store. A missing log is not an error, and
+// replay stops at the first partial or corrupt record.
+func (ms *memStore) replayWAL(fn string) error {
+	f, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var hdr [memWALHdrSize]byte
+	var rec []byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil
+		}
+		plen := int(binary.LittleEndian.Uint32(hdr[1:]))
+		if plen < 8 {
+			return nil
+		}
+		if cap(rec) < memWALHdrSize+plen+memWALCRCSize {
+			rec = make([]byte, memWALHdrSize+plen+memWALCRCSize)
+		}
+		rec = rec[:memWALHdrSize+plen+memWALCRCSize]
+		copy(rec, hdr[:])
+		if _, err := io.ReadFull(r, rec[memWALHdrSize:]); err != nil {
+			return nil
+		}
+		end := memWALHdrSize + plen
+		if crc32.ChecksumIEEE(rec[:end]) != binary.LittleEndian.Uint32(rec[end:]) {
+			return nil
+		}
+		if err := ms.applyWALRecord(hdr[0], rec[memWALHdrSize:end]); err != nil {
+			return nil
+		}
+	}
+}
+
+// Applies a single decoded record.
+func (ms *memStore) applyWALRecord(op byte, p []byte) error {
+	seq := binary.LittleEndian.Uint64(p)
+	switch op {
+	case memWALStore:
+		p = p[8:]
+		if len(p) < 8+2 {
+			return errMemWALCorrupt
+		}
+		ts := int64(binary.LittleEndian.Uint64(p))
+		slen := int(binary.LittleEndian.Uint16(p[8:]))
+		p = p[10:]
+		if len(p) < slen+4 {
+			return errMemWALCorrupt
+		}
+		subj := string(p[:slen])
+		hlen := int(binary.LittleEndian.Uint32(p[slen:]))
+		p = p[slen+4:]
+		if len(p) < hlen {
+			return errMemWALCorrupt
+		}
+		var hdr []byte
+		if hlen > 0 {
+			hdr = p[:hlen]
+		}
+		// Tolerate gaps from records that were rejected on the original run.
+		ms.skipWALTo(seq - 1)
+		ms.StoreRawMsg(subj, hdr, p[hlen:], seq, ts)
+	case memWALRemove:
+		ms.RemoveMsg(seq)
+	case memWALSkip:
+		ms.skipWALTo(seq)
+	case memWALPurge:
+		ms.Purge()
+	case memWALCompact:
+		ms.Compact(seq)
+	case memWALTruncate:
+		ms.Truncate(seq)
+	case memWALRemoveRange:
+		if len(p) < 16 {
+			return errMemWALCorrupt
+		}
+		ms.RemoveRange(seq, binary.LittleEndian.Uint64(p[8:]))
+	case memWALRemap:
+		if len(p) < 10 {
+			return errMemWALCorrupt
+		}
+		slen := int(binary.LittleEndian.Uint16(p[8:]))
+		p = p[10:]
+		if len(p) < slen {
+			return errMemWALCorrupt
+		}
+		ms.RemapSubjects(string(p[:slen]), string(p[slen:]), seq)
+	default:
+		return errMemWALCorrupt
+	}
+	return nil
+}
+
+// Moves our last sequence forward to seq without storing anything.
+func (ms *memStore) skipWALTo(seq uint64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if seq <= ms.state.LastSeq {
+		return
+	}
+	ms.state.LastSeq = seq
+	ms.state.LastTime = time.Now().UTC()
+	if ms.state.Msgs == 0 {
+		ms.state.FirstSeq = seq + 1
+		ms.state.FirstTime = time.Time{}
+	}
+}
+
+// Background flusher for the log.
+func (ms *memStore) flushWALLoop(w *memWAL) {
+	t := time.NewTicker(w.fint)
+	defer t.Stop()
+	defer close(w.done)
+	for {
+		select {
+		case <-t.C:
+			ms.flushWAL(w)
+		case <-w.qch:
+			return
+		}
+	}
+}
+
+// Writes out any buffered records and rewrites the log if it grew past its limit.
+func (ms *memStore) flushWAL(w *memWAL) {
+	ms.mu.Lock()
+	buf := w.buf
+	w.buf = nil
+	ms.mu.Unlock()
+
+	w.mu.Lock()
+	if w.fd != nil && len(buf) > 0 {
+		n, _ := w.fd.Write(buf)
+		w.size += int64(n)
+	}
+	needsCompact := w.fd != nil && w.size > w.maxb
+	w.mu.Unlock()
+
+	if needsCompact {
+		ms.compactWAL()
+	}
+}
+
+// Rewrites the log from our current state.
+func (ms *memStore) compactWAL() error {
+	ms.mu.Lock()
+	w := ms.wal
+	if w == nil {
+		ms.mu.Unlock()
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Snapshot our state in sequence order. Any buffered records are covered by this.
+	seqs := make([]uint64, 0, len(ms.msgs))
+	for seq := range ms.msgs {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	var buf []byte
+	if ms.state.FirstSeq > 1 {
+		buf = appendWALRecord(buf, memWALSkip, ms.state.FirstSeq-1, 0, _EMPTY_, nil, nil)
+	}
+	for _, seq := range seqs {
+		sm := ms.msgs[seq]
+		buf = appendWALRecord(buf, memWALStore, sm.seq, sm.ts, sm.subj, sm.hdr, sm.msg)
+	}
+	if ms.state.LastSeq > 0 && (len(seqs) == 0 || seqs[len(seqs)-1] < ms.state.LastSeq) {
+		buf = appendWALRecord(buf, memWALSkip, ms.state.LastSeq, 0, _EMPTY_, nil, nil)
+	}
+	w.buf = nil
+	ms.mu.Unlock()
+
+	fn := filepath.Join(w.dir, memWALFile)
+	tmp := fn + ".tmp"
+	if err := os.WriteFile(tmp, buf, defaultFilePerms); err != nil {
+		return err
+	}
+	if w.fd != nil {
+		w.fd.Close()
+		w.fd = nil
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND, defaultFilePerms)
+	if err != nil {
+		return err
+	}
+	w.fd, w.size = fd, int64(len(buf))
+	return nil
+}
+
+// Stops the flusher and writes out anything still buffered.
+// If remove is set the log and our stream directory are removed.
+func (ms *memStore) stopWAL(remove bool) {
+	ms.mu.Lock()
+	w := ms.wal
+	if w == nil {
+		ms.mu.Unlock()
+		return
+	}
+	ms.wal = nil
+	ms.mu.Unlock()
+
+	// Once the flusher is done nothing else will touch the buffer.
+	close(w.qch)
+	<-w.done
+	buf := w.buf
+	w.buf = nil
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fd != nil {
+		if len(buf) > 0 && !remove {
+			w.fd.Write(buf)
+		}
+		w.fd.Close()
+		w.fd = nil
+	}
+	if remove {
+		os.RemoveAll(w.dir)
+	}
+}
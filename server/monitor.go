@@ -135,6 +135,7 @@ type ConnInfo struct {
 	InBytes        int64          `json:"in_bytes"`
 	OutBytes       int64          `json:"out_bytes"`
 	NumSubs        uint32         `json:"subscriptions"`
+	GatewaySubs    int32          `json:"gateway_subjects,omitempty"`
 	Name           string         `json:"name,omitempty"`
 	Lang           string         `json:"lang,omitempty"`
 	Version        string         `json:"version,omitempty"`
@@ -150,6 +151,8 @@ type ConnInfo struct {
 	NameTag        string         `json:"name_tag,omitempty"`
 	Tags           jwt.TagList    `json:"tags,omitempty"`
 	MQTTClient     string         `json:"mqtt_client,omitempty"` // This is the MQTT client id
+	Listener       string         `json:"listener,omitempty"`    // Set if connected through an additional listener
+	BusyPoll       bool           `json:"busy_poll,omitempty"`   // Set if the read loop busy polls the connection
 }
 
 // TLSPeerCert contains basic information about a TLS peer certificate
@@ -554,6 +557,7 @@ func (ci *ConnInfo) fill(client *client, nc net.Conn, now time.Time, auth bool)
 	ci.OutMsgs = client.outMsgs
 	ci.OutBytes = client.outBytes
 	ci.NumSubs = uint32(len(client.subs))
+	ci.GatewaySubs = atomic.LoadInt32(&client.gwsubs)
 	ci.Pending = int(client.out.pb)
 	ci.Name = client.opts.Name
 	ci.Lang = client.opts.Lang
@@ -580,6 +584,10 @@ func (ci *ConnInfo) fill(client *client, nc net.Conn, now time.Time, auth bool)
 		ci.Port = int(client.port)
 		ci.IP = client.host
 	}
+	if client.lst != nil {
+		ci.Listener = client.lst.opts.Name
+	}
+	ci.BusyPoll = client.bp != nil
 }
 
 func makePeerCerts(pc []*x509.Certificate) []*TLSPeerCert {
@@ -1203,6 +1211,8 @@ type Varz struct {
 	TrustedOperatorsClaim []*jwt.OperatorClaims `json:"trusted_operators_claim,omitempty"`
 	SystemAccount         string                `json:"system_account,omitempty"`
 	PinnedAccountFail     uint64                `json:"pinned_account_fails,omitempty"`
+	PermCache             *PermCacheStats       `json:"perm_cache,omitempty"`
+	Standby               bool                  `json:"standby,omitempty"`
 }
 
 // JetStreamVarz contains basic runtime information about jetstream
@@ -1530,6 +1540,7 @@ func (s *Server) createVarz(pcpu float64, rss int64) *Varz {
 		Tags:                  opts.Tags,
 		TrustedOperatorsJwt:   opts.operatorJWT,
 		TrustedOperatorsClaim: opts.TrustedOperators,
+		Standby:               opts.Standby,
 	}
 	if len(opts.Routes) > 0 {
 		varz.Cluster.URLs = urlsToStrings(opts.Routes)
@@ -1651,6 +1662,7 @@ func (s *Server) updateVarzRuntimeFields(v *Varz, forceUpdate bool, pcpu float64
 	v.OutBytes = atomic.LoadInt64(&s.outBytes)
 	v.SlowConsumers = atomic.LoadInt64(&s.slowConsumers)
 	v.PinnedAccountFail = atomic.LoadUint64(&s.pinnedAccFail)
+	v.PermCache = s.permCache.stats()
 
 	// Make sure to reset in case we are re-using.
 	v.Subscriptions = 0
@@ -1784,6 +1796,8 @@ type Gatewayz struct {
 	Port             int                          `json:"port,omitempty"`
 	OutboundGateways map[string]*RemoteGatewayz   `json:"outbound_gateways"`
 	InboundGateways  map[string][]*RemoteGatewayz `json:"inbound_gateways"`
+	SubjectQuota     int                          `json:"subject_quota,omitempty"`
+	QuotaExceeded    int64                        `json:"subject_quota_exceeded,omitempty"`
 }
 
 // RemoteGatewayz represents information about an outbound connection to a gateway
@@ -1826,6 +1840,9 @@ func (s *Server) Gatewayz(opts *GatewayzOptions) (*Gatewayz, error) {
 		Name: gw.name,
 		Host: gw.info.Host,
 		Port: gw.info.Port,
+
+		SubjectQuota:  gw.maxcsubs,
+		QuotaExceeded: atomic.LoadInt64(&gw.sqExceeded),
 	}
 	gw.RUnlock()
 
@@ -2255,6 +2272,265 @@ func (s *Server) HandleAccountStatz(w http.ResponseWriter, r *http.Request) {
 	ResponseHandler(w, r, b)
 }
 
+// Talkerz represents the busiest subjects published to in an account.
+type Talkerz struct {
+	ID       string           `json:"server_id"`
+	Now      time.Time        `json:"now"`
+	Account  string           `json:"account"`
+	Subjects []*SubjectTalker `json:"subjects"`
+}
+
+// TalkerzOptions are options passed to Talkerz
+type TalkerzOptions struct {
+	// Account to report on, defaults to the global account.
+	Account string `json:"account"`
+	// Limit is the number of subjects to return, defaults to 10.
+	Limit int `json:"limit"`
+	// SortByBytes sorts by byte rate instead of message rate.
+	SortByBytes bool `json:"sort_by_bytes"`
+}
+
+// Talkerz returns the busiest subjects of an account over the recent past.
+func (s *Server) Talkerz(opts *TalkerzOptions) (*Talkerz, error) {
+	var o TalkerzOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Account == _EMPTY_ {
+		o.Account = globalAccountName
+	}
+	if o.Limit <= 0 {
+		o.Limit = 10
+	}
+	acc, err := s.lookupAccount(o.Account)
+	if err != nil {
+		return nil, err
+	}
+	acc.mu.RLock()
+	talkers := acc.talkers
+	acc.mu.RUnlock()
+	if talkers == nil {
+		return nil, fmt.Errorf("subject talkers not enabled")
+	}
+	now := time.Now()
+	return &Talkerz{
+		ID:       s.ID(),
+		Now:      now.UTC(),
+		Account:  acc.Name,
+		Subjects: talkers.top(o.Limit, o.SortByBytes, now),
+	}, nil
+}
+
+// HandleTalkerz process HTTP requests for the busiest subjects of an account.
+func (s *Server) HandleTalkerz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.httpReqStats[TalkerzPath]++
+	s.mu.Unlock()
+
+	limit, err := decodeInt(w, r, "limit")
+	if err != nil {
+		return
+	}
+	l, err := s.Talkerz(&TalkerzOptions{
+		Account:     r.URL.Query().Get("acc"),
+		Limit:       limit,
+		SortByBytes: r.URL.Query().Get("sort") == "bytes",
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to %s request: %v", TalkerzPath, err)
+		return
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
+// Probez represents the latency and loss measured by the synthetic traffic prober.
+type Probez struct {
+	ID       string        `json:"server_id"`
+	Now      time.Time     `json:"now"`
+	Interval time.Duration `json:"interval"`
+	Paths    []*ProbePath  `json:"paths"`
+}
+
+// ProbezOptions are options passed to Probez
+type ProbezOptions struct {
+	// Type only returns paths of this type, e.g. route or stream.
+	Type string `json:"type"`
+}
+
+// Probez returns the latency and loss of the paths measured by the prober.
+func (s *Server) Probez(opts *ProbezOptions) (*Probez, error) {
+	s.mu.RLock()
+	sp := s.prober
+	s.mu.RUnlock()
+	if sp == nil {
+		return nil, fmt.Errorf("prober not enabled")
+	}
+	paths := sp.snapshot()
+	if opts != nil && opts.Type != _EMPTY_ {
+		filtered := paths[:0]
+		for _, p := range paths {
+			if p.Type == opts.Type {
+				filtered = append(filtered, p)
+			}
+		}
+		paths = filtered
+	}
+	return &Probez{
+		ID:       s.ID(),
+		Now:      time.Now().UTC(),
+		Interval: s.getOpts().Prober.Interval,
+		Paths:    paths,
+	}, nil
+}
+
+// HandleProbez process HTTP requests for the latency and loss measured by the prober.
+func (s *Server) HandleProbez(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.httpReqStats[ProbezPath]++
+	s.mu.Unlock()
+
+	pz, err := s.Probez(&ProbezOptions{Type: r.URL.Query().Get("type")})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	b, err := json.MarshalIndent(pz, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to %s request: %v", ProbezPath, err)
+		return
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
+// Standbyz reports on a server in standby, including the JetStream assets it had on disk.
+type Standbyz struct {
+	ID       string           `json:"server_id"`
+	Now      time.Time        `json:"now"`
+	StoreDir string           `json:"store_dir,omitempty"`
+	Streams  []*StandbyStream `json:"streams,omitempty"`
+}
+
+// StandbyStream is a stream found in the store directory of a server in standby.
+type StandbyStream struct {
+	Account string        `json:"account"`
+	Name    string        `json:"name"`
+	Created time.Time     `json:"created,omitempty"`
+	Config  *StreamConfig `json:"config,omitempty"`
+	// The config can not be read when the stream is encrypted.
+	Encrypted bool     `json:"encrypted,omitempty"`
+	Blocks    int      `json:"blocks"`
+	Bytes     uint64   `json:"bytes"`
+	Consumers []string `json:"consumers,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Standbyz returns what a server in standby had on disk. Nothing is written to the
+// store directory, so it can be inspected as it was left.
+func (s *Server) Standbyz() (*Standbyz, error) {
+	opts := s.getOpts()
+	if !opts.Standby {
+		return nil, fmt.Errorf("server not in standby")
+	}
+	sz := &Standbyz{ID: s.ID(), Now: time.Now().UTC()}
+	if !opts.JetStream {
+		return sz, nil
+	}
+	sz.StoreDir = filepath.Join(os.TempDir(), JetStreamStoreDir)
+	if opts.StoreDir != _EMPTY_ {
+		sz.StoreDir = filepath.Join(opts.StoreDir, JetStreamStoreDir)
+	}
+	accs, err := os.ReadDir(sz.StoreDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sz, nil
+		}
+		return nil, err
+	}
+	for _, acc := range accs {
+		sdir := filepath.Join(sz.StoreDir, acc.Name(), streamsDir)
+		streams, err := os.ReadDir(sdir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range streams {
+			if fi.IsDir() {
+				sz.Streams = append(sz.Streams, standbyStream(acc.Name(), fi.Name(), filepath.Join(sdir, fi.Name())))
+			}
+		}
+	}
+	return sz, nil
+}
+
+// Reads what we can of a stream from its directory.
+func standbyStream(account, name, dir string) *StandbyStream {
+	ss := &StandbyStream{Account: account, Name: name}
+	if _, err := os.Stat(filepath.Join(dir, JetStreamMetaFileKey)); err == nil {
+		ss.Encrypted = true
+	} else if buf, err := os.ReadFile(filepath.Join(dir, JetStreamMetaFile)); err != nil {
+		ss.Error = err.Error()
+	} else {
+		var fsi FileStreamInfo
+		if err := json.Unmarshal(buf, &fsi); err != nil {
+			ss.Error = err.Error()
+		} else {
+			ss.Created, ss.Config = fsi.Created, &fsi.StreamConfig
+		}
+	}
+	if blks, err := os.ReadDir(filepath.Join(dir, msgDir)); err == nil {
+		for _, blk := range blks {
+			var index uint32
+			if n, _ := fmt.Sscanf(blk.Name(), blkScan, &index); n != 1 || blk.Name() != fmt.Sprintf(blkScan, index) {
+				continue
+			}
+			if fi, err := blk.Info(); err == nil {
+				ss.Blocks++
+				ss.Bytes += uint64(fi.Size())
+			}
+		}
+	}
+	if obs, err := os.ReadDir(filepath.Join(dir, consumerDir)); err == nil {
+		for _, o := range obs {
+			if o.IsDir() {
+				ss.Consumers = append(ss.Consumers, o.Name())
+			}
+		}
+	}
+	return ss
+}
+
+// HandleStandbyz process HTTP requests for what a server in standby had on disk.
+func (s *Server) HandleStandbyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.httpReqStats[StandbyzPath]++
+	s.mu.Unlock()
+
+	sz, err := s.Standbyz()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	b, err := json.MarshalIndent(sz, "", "  ")
+	if err != nil {
+		s.Errorf("Error marshaling response to %s request: %v", StandbyzPath, err)
+		return
+	}
+
+	// Handle response
+	ResponseHandler(w, r, b)
+}
+
 // ResponseHandler handles responses for monitoring routes
 func ResponseHandler(w http.ResponseWriter, r *http.Request, data []byte) {
 	// Get callback from request
@@ -2341,6 +2617,10 @@ func (reason ClosedState) String() string {
 		return "Minimum Version Required"
 	case ClusterNamesIdentical:
 		return "Cluster Names Identical"
+	case IdleConnection:
+		return "Idle Connection"
+	case ServerInStandby:
+		return "Server In Standby"
 	}
 
 	return "Unknown State"
@@ -2633,6 +2913,7 @@ type JSzOptions struct {
 	LeaderOnly bool   `json:"leader_only,omitempty"`
 	Offset     int    `json:"offset,omitempty"`
 	Limit      int    `json:"limit,omitempty"`
+	History    bool   `json:"history,omitempty"`
 }
 
 // HealthzOptions are options passed to Healthz
@@ -2652,6 +2933,8 @@ type StreamDetail struct {
 	Consumer []*ConsumerInfo     `json:"consumer_detail,omitempty"`
 	Mirror   *StreamSourceInfo   `json:"mirror,omitempty"`
 	Sources  []*StreamSourceInfo `json:"sources,omitempty"`
+	Stats    *StoreStats         `json:"stats,omitempty"`
+	History  *StreamHistory      `json:"history,omitempty"`
 }
 
 type AccountDetail struct {
@@ -2687,7 +2970,7 @@ type JSInfo struct {
 	AccountDetails []*AccountDetail `json:"account_details,omitempty"`
 }
 
-func (s *Server) accountDetail(jsa *jsAccount, optStreams, optConsumers, optCfg bool) *AccountDetail {
+func (s *Server) accountDetail(jsa *jsAccount, optStreams, optConsumers, optCfg, optHistory bool) *AccountDetail {
 	jsa.mu.RLock()
 	acc := jsa.account
 	name := acc.GetName()
@@ -2740,6 +3023,10 @@ func (s *Server) accountDetail(jsa *jsAccount, optStreams, optConsumers, optCfg
 				Config:  cfg,
 				Mirror:  stream.mirrorInfo(),
 				Sources: stream.sourcesInfo(),
+				Stats:   stream.storeStats(),
+			}
+			if optHistory {
+				sdet.History = stream.history()
 			}
 			if optConsumers {
 				for _, consumer := range stream.getPublicConsumers() {
@@ -2775,7 +3062,7 @@ func (s *Server) JszAccount(opts *JSzOptions) (*AccountDetail, error) {
 	if !ok {
 		return nil, fmt.Errorf("account %q not jetstream enabled", acc)
 	}
-	return s.accountDetail(jsa, opts.Streams, opts.Consumer, opts.Config), nil
+	return s.accountDetail(jsa, opts.Streams, opts.Consumer, opts.Config, opts.History), nil
 }
 
 // helper to get cluster info from node via dummy group
@@ -2805,7 +3092,7 @@ func (s *Server) Jsz(opts *JSzOptions) (*JSInfo, error) {
 	if opts.Limit == 0 {
 		opts.Limit = 1024
 	}
-	if opts.Consumer {
+	if opts.Consumer || opts.History {
 		opts.Streams = true
 	}
 	if opts.Streams {
@@ -2902,7 +3189,7 @@ func (s *Server) Jsz(opts *JSzOptions) (*JSInfo, error) {
 	}
 	// if wanted, obtain accounts/streams/consumer
 	for _, jsa := range accounts {
-		detail := s.accountDetail(jsa, opts.Streams, opts.Consumer, opts.Config)
+		detail := s.accountDetail(jsa, opts.Streams, opts.Consumer, opts.Config, opts.History)
 		jsi.AccountDetails = append(jsi.AccountDetails, detail)
 	}
 	return jsi, nil
@@ -2941,6 +3228,10 @@ func (s *Server) HandleJsz(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	history, err := decodeBool(w, r, "history")
+	if err != nil {
+		return
+	}
 
 	l, err := s.Jsz(&JSzOptions{
 		r.URL.Query().Get("acc"),
@@ -2950,7 +3241,8 @@ func (s *Server) HandleJsz(w http.ResponseWriter, r *http.Request) {
 		config,
 		leader,
 		offset,
-		limit})
+		limit,
+		history})
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -2966,8 +3258,16 @@ func (s *Server) HandleJsz(w http.ResponseWriter, r *http.Request) {
 }
 
 type HealthStatus struct {
-	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	Recovering []*StreamRecovery `json:"recovering,omitempty"`
+}
+
+// StreamRecovery details a stream whose store is still being recovered.
+type StreamRecovery struct {
+	Account string `json:"account"`
+	Stream  string `json:"stream"`
+	RecoveryProgress
 }
 
 // https://tools.ietf.org/id/draft-inadarei-api-health-check-05.html
@@ -3018,6 +3318,15 @@ func (s *Server) healthz(opts *HealthzOptions) *HealthStatus {
 		opts = &HealthzOptions{}
 	}
 
+	// If unhealthy, report any streams we are still recovering.
+	defer func() {
+		if health.Status != "ok" {
+			if js := s.getJetStream(); js != nil {
+				health.Recovering = js.recoveringStreams()
+			}
+		}
+	}()
+
 	if err := s.readyForConnections(time.Millisecond); err != nil {
 		health.Status = "error"
 		health.Error = err.Error()
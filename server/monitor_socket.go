@@ -0,0 +1,87 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+// Permissions of the monitoring socket if not configured, owner only.
+const defaultHTTPSocketMode = os.FileMode(0600)
+
+// Serves the monitoring endpoints over a unix socket, on its own or next to the
+// HTTP(S) port, so local agents can scrape them without another network port.
+func (s *Server) startMonitoringSocket() error {
+	opts := s.getOpts()
+	path, mode := opts.HTTPSocket, opts.HTTPSocketMode
+	if mode == 0 {
+		mode = defaultHTTPSocketMode
+	}
+
+	// Remove a socket left behind by a previous run, but nothing else.
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("can't listen to the monitor socket: %q exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("can't listen to the monitor socket: %v", err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return fmt.Errorf("can't set permissions of the monitor socket: %v", err)
+	}
+	s.Noticef("Starting http monitor on unix socket %s", path)
+
+	s.mu.Lock()
+	if s.shutdown {
+		l.Close()
+		s.mu.Unlock()
+		return nil
+	}
+	// Share the handler with the HTTP(S) port if we have one.
+	mux := s.httpHandler
+	if mux == nil {
+		mux = s.newMonitoringMux()
+		s.httpHandler = mux
+	}
+	s.httpSocket = l
+	s.mu.Unlock()
+
+	srv := &http.Server{
+		Handler:        mux,
+		MaxHeaderBytes: 1 << 20,
+		ErrorLog:       log.New(&captureHTTPServerLog{s, "monitoring: "}, _EMPTY_, 0),
+	}
+	go func() {
+		if err := srv.Serve(l); err != nil {
+			s.mu.Lock()
+			shutdown := s.shutdown
+			s.mu.Unlock()
+			if !shutdown {
+				s.Fatalf("Error starting monitor on unix socket %q: %v", path, err)
+			}
+		}
+		srv.Close()
+		s.done <- true
+	}()
+
+	return nil
+}
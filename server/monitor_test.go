@@ -15,6 +15,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -25,6 +26,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"sort"
@@ -2042,6 +2044,58 @@ func TestMonitorHandler(t *testing.T) {
 	}
 }
 
+func TestMonitorUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "monitor.sock")
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: "127.0.0.1:-1"
+		http_socket: %q
+		http_socket_mode: "0660"
+	`, sock)))
+	opts, err := ProcessConfigFile(conf)
+	require_NoError(t, err)
+	require_Equal(t, opts.HTTPSocket, sock)
+	require_True(t, opts.HTTPSocketMode == 0660)
+
+	// No HTTP port, only the socket.
+	opts.NoLog, opts.NoSigs = true, true
+	s := RunServer(opts)
+	defer s.Shutdown()
+	require_True(t, s.MonitorAddr() == nil)
+	require_True(t, s.MonitorSocketAddr() != nil)
+
+	fi, err := os.Stat(sock)
+	require_NoError(t, err)
+	require_True(t, fi.Mode()&os.ModeSocket != 0)
+	require_True(t, fi.Mode().Perm() == 0660)
+
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	defer hc.CloseIdleConnections()
+	for _, p := range []string{HealthzPath, VarzPath} {
+		resp, err := hc.Get("http://localhost" + p)
+		require_NoError(t, err)
+		resp.Body.Close()
+		require_True(t, resp.StatusCode == http.StatusOK)
+	}
+
+	// Socket is removed on shutdown, and a stale one does not prevent a restart.
+	s.Shutdown()
+	_, err = os.Stat(sock)
+	require_True(t, os.IsNotExist(err))
+
+	l, err := net.Listen("unix", sock)
+	require_NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	s = RunServer(opts)
+	defer s.Shutdown()
+	require_True(t, s.MonitorSocketAddr() != nil)
+}
+
 func TestMonitorRoutezRace(t *testing.T) {
 	resetPreviousHTTPConnections()
 	srvAOpts := DefaultMonitorOptions()
@@ -3925,7 +3979,7 @@ func TestMonitorAccountz(t *testing.T) {
 	body = string(readBody(t, fmt.Sprintf("http://127.0.0.1:%d%s?acc=$SYS", s.MonitorAddr().Port, AccountzPath)))
 	require_Contains(t, body, `"account_detail": {`)
 	require_Contains(t, body, `"account_name": "$SYS",`)
-	require_Contains(t, body, `"subscriptions": 40,`)
+	require_Contains(t, body, `"subscriptions": 48,`)
 	require_Contains(t, body, `"is_system": true,`)
 	require_Contains(t, body, `"system_account": "$SYS"`)
 
@@ -4548,3 +4602,273 @@ func TestMonitorWebsocket(t *testing.T) {
 		}
 	}
 }
+
+func TestMonitorJszHistory(t *testing.T) {
+	s := RunBasicJetStreamServer(t)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	_, err = js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy})
+	require_NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		sendStreamMsg(t, nc, "foo", "HELLO")
+	}
+	sub, err := js.PullSubscribe("foo", "dlc")
+	require_NoError(t, err)
+	msgs, err := sub.Fetch(4, nats.MaxWait(2*time.Second))
+	require_NoError(t, err)
+	for _, m := range msgs {
+		require_NoError(t, m.AckSync())
+	}
+
+	// No history unless asked for.
+	jsi, err := s.Jsz(&JSzOptions{Streams: true})
+	require_NoError(t, err)
+	require_True(t, jsi.AccountDetails[0].Streams[0].History == nil)
+
+	jsi, err = s.Jsz(&JSzOptions{History: true})
+	require_NoError(t, err)
+	require_Len(t, len(jsi.AccountDetails), 1)
+	require_Len(t, len(jsi.AccountDetails[0].Streams), 1)
+	h := jsi.AccountDetails[0].Streams[0].History
+	require_True(t, h != nil)
+	require_Len(t, len(h.Msgs), statsHistoryLen)
+
+	var msgCount, byteCount, ackCount uint64
+	for _, s := range h.Msgs {
+		msgCount += s.Msgs
+		byteCount += s.Bytes
+	}
+	for _, s := range h.Acks["dlc"] {
+		ackCount += s.Msgs
+	}
+	require_True(t, msgCount == 10)
+	require_True(t, byteCount == 10*5)
+	require_True(t, ackCount == 4)
+}
+
+func TestStatsHistoryRing(t *testing.T) {
+	var h statsHistory
+	now := time.Unix(1_000_000*60, 0)
+	h.record(now, 1, 10)
+	h.record(now.Add(30*time.Second), 2, 20)
+	h.record(now.Add(5*time.Minute), 3, 30)
+
+	samples := h.samples(now.Add(5 * time.Minute))
+	require_Len(t, len(samples), statsHistoryLen)
+	last := samples[len(samples)-1]
+	require_True(t, last.Msgs == 3 && last.Bytes == 30)
+	require_True(t, last.Start.Equal(now.Add(5*time.Minute)))
+	first := samples[len(samples)-6]
+	require_True(t, first.Start.Equal(now) && first.Msgs == 3 && first.Bytes == 30)
+
+	// After an hour the old buckets should no longer be reported.
+	samples = h.samples(now.Add(61 * time.Minute))
+	var total uint64
+	for _, s := range samples {
+		total += s.Msgs
+	}
+	require_True(t, total == 3)
+
+	// Recording into a reused bucket resets it.
+	h.record(now.Add(60*time.Minute), 1, 1)
+	samples = h.samples(now.Add(60 * time.Minute))
+	require_True(t, samples[len(samples)-1].Msgs == 1)
+}
+
+func TestMonitorTalkerz(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		http: 127.0.0.1:-1
+		subject_talkers: 4
+		accounts {
+			A { users: [{user: a, password: a}] }
+			$SYS { users: [{user: admin, password: s3cr3t!}] }
+		}
+	`))
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	nc := natsConnect(t, s.ClientURL(), nats.UserInfo("a", "a"))
+	defer nc.Close()
+
+	// A firehose by messages, one by bytes and enough others to displace each other.
+	for i := 0; i < 100; i++ {
+		nc.Publish("fire.hose", []byte("x"))
+	}
+	big := make([]byte, 1024)
+	for i := 0; i < 50; i++ {
+		nc.Publish("big.payload", big)
+	}
+	for i := 0; i < 20; i++ {
+		nc.Publish(fmt.Sprintf("other.%d", i), []byte("x"))
+	}
+	require_NoError(t, nc.Flush())
+
+	url := fmt.Sprintf("http://127.0.0.1:%d%s?acc=A", s.MonitorAddr().Port, TalkerzPath)
+	var tz Talkerz
+	require_NoError(t, json.Unmarshal(readBody(t, url), &tz))
+	require_True(t, tz.Account == "A")
+	require_True(t, len(tz.Subjects) == 4)
+	require_True(t, tz.Subjects[0].Subject == "fire.hose")
+	require_True(t, tz.Subjects[0].Msgs == 100)
+	require_True(t, tz.Subjects[0].Error == 0)
+	require_True(t, tz.Subjects[0].MsgRate > 0)
+	require_True(t, tz.Subjects[1].Subject == "big.payload")
+	require_True(t, tz.Subjects[1].Msgs == 50)
+	// The last slots keep getting displaced so are approximate.
+	require_True(t, strings.HasPrefix(tz.Subjects[3].Subject, "other."))
+	require_True(t, tz.Subjects[3].Error > 0)
+
+	tz = Talkerz{}
+	require_NoError(t, json.Unmarshal(readBody(t, url+"&sort=bytes&limit=1"), &tz))
+	require_True(t, len(tz.Subjects) == 1)
+	require_True(t, tz.Subjects[0].Subject == "big.payload")
+	require_True(t, tz.Subjects[0].Bytes >= 50*1024)
+	require_True(t, tz.Subjects[0].ByteRate > 0)
+
+	readBodyEx(t, fmt.Sprintf("http://127.0.0.1:%d%s?acc=B", s.MonitorAddr().Port, TalkerzPath), http.StatusBadRequest, textPlain)
+
+	// Also available as a system request.
+	ncSys := natsConnect(t, s.ClientURL(), nats.UserInfo("admin", "s3cr3t!"))
+	defer ncSys.Close()
+	resp, err := ncSys.Request(fmt.Sprintf(accDirectReqSubj, "A", "TALKERZ"), []byte(`{"limit":1}`), time.Second)
+	require_NoError(t, err)
+	var sresp struct {
+		Data  *Talkerz  `json:"data"`
+		Error *ApiError `json:"error"`
+	}
+	require_NoError(t, json.Unmarshal(resp.Data, &sresp))
+	require_True(t, sresp.Error == nil)
+	require_True(t, len(sresp.Data.Subjects) == 1)
+	require_True(t, sresp.Data.Subjects[0].Subject == "fire.hose")
+}
+
+func TestMonitorProbez(t *testing.T) {
+	tmpl := strings.Replace(jsClusterTempl, "leaf {", `
+	prober {
+		interval: 100ms
+		timeout: 1s
+		streams: [ TEST, {account: "$G", stream: MISSING} ]
+	}
+
+	leaf {`, 1)
+	c := createJetStreamClusterWithTemplate(t, tmpl, "R3S", 3)
+	defer c.shutdown()
+
+	nc, js := jsClientConnect(t, c.randomServer())
+	defer nc.Close()
+
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}, Replicas: 3})
+	require_NoError(t, err)
+
+	s := c.servers[0]
+	checkFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+		pz, err := s.Probez(nil)
+		if err != nil {
+			return err
+		}
+		paths := make(map[string]*ProbePath)
+		for _, p := range pz.Paths {
+			paths[p.Path] = p
+		}
+		expected := []string{ProbePathCore}
+		for _, srv := range c.servers {
+			if srv != s {
+				expected = append(expected, "route:"+srv.Name())
+			}
+			expected = append(expected, "stream:$G/TEST:"+srv.Name())
+		}
+		for _, path := range expected {
+			if p := paths[path]; p == nil || p.Received == 0 || p.LastError != _EMPTY_ {
+				return fmt.Errorf("Expected path %q to be probed, got %+v", path, p)
+			}
+		}
+		if p := paths["stream:$G/MISSING"]; p == nil || p.Lost == 0 || p.Loss != 1 || p.LastError != "stream not found" {
+			return fmt.Errorf("Expected missing stream to be lost, got %+v", p)
+		}
+		return nil
+	})
+
+	pz, err := s.Probez(&ProbezOptions{Type: ProbePathRoute})
+	require_NoError(t, err)
+	require_True(t, len(pz.Paths) == 2)
+	for _, p := range pz.Paths {
+		require_True(t, p.MinRTT > 0 && p.MinRTT <= p.MaxRTT)
+	}
+
+	// Not enabled.
+	sa := RunServer(DefaultOptions())
+	defer sa.Shutdown()
+	_, err = sa.Probez(nil)
+	require_Error(t, err)
+}
+
+func TestMonitorStandbyz(t *testing.T) {
+	storeDir := t.TempDir()
+	opts := DefaultMonitorOptions()
+	opts.Port, opts.HTTPPort = -1, -1
+	opts.JetStream, opts.StoreDir = true, storeDir
+	s := RunServer(opts)
+	defer s.Shutdown()
+
+	nc, js := jsClientConnect(t, s)
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	_, err = js.Publish("foo", []byte("hello"))
+	require_NoError(t, err)
+	_, err = js.AddConsumer("TEST", &nats.ConsumerConfig{Durable: "dlc", AckPolicy: nats.AckExplicitPolicy})
+	require_NoError(t, err)
+	nc.Close()
+
+	// Not in standby yet.
+	_, err = s.Standbyz()
+	require_Error(t, err)
+	s.Shutdown()
+
+	// Another server to stay in the cluster with.
+	ropts := DefaultMonitorOptions()
+	ropts.Port, ropts.HTTPPort = -1, -1
+	ropts.ServerName = "other"
+	ropts.Cluster.Name, ropts.Cluster.Host, ropts.Cluster.Port = "SB", "127.0.0.1", -1
+	other := RunServer(ropts)
+	defer other.Shutdown()
+
+	opts = opts.Clone()
+	opts.Standby = true
+	opts.Cluster.Name, opts.Cluster.Host, opts.Cluster.Port = "SB", "127.0.0.1", -1
+	opts.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", other.ClusterAddr().Port))
+	s = RunServer(opts)
+	defer s.Shutdown()
+	checkClusterFormed(t, s, other)
+
+	// Clients are turned away to the other server, which does not advertise us.
+	nc, err = nats.Connect(s.ClientURL(), nats.NoReconnect())
+	require_NoError(t, err)
+	require_Equal(t, nc.ConnectedServerName(), "other")
+	for _, u := range nc.DiscoveredServers() {
+		require_True(t, !strings.HasSuffix(u, fmt.Sprintf(":%d", s.Addr().(*net.TCPAddr).Port)))
+	}
+	nc.Close()
+	require_True(t, s.getJetStream() == nil)
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/", s.MonitorAddr().Port)
+	var sz Standbyz
+	require_NoError(t, json.Unmarshal(readBody(t, url+"standbyz"), &sz))
+	require_True(t, len(sz.Streams) == 1)
+	ss := sz.Streams[0]
+	require_Equal(t, ss.Account, globalAccountName)
+	require_Equal(t, ss.Name, "TEST")
+	require_True(t, ss.Config != nil && ss.Config.Name == "TEST")
+	require_True(t, ss.Blocks == 1 && ss.Bytes > 0)
+	require_True(t, len(ss.Consumers) == 1 && ss.Consumers[0] == "dlc")
+
+	var vz Varz
+	require_NoError(t, json.Unmarshal(readBody(t, url+"varz"), &vz))
+	require_True(t, vz.Standby)
+}
@@ -102,6 +102,7 @@ type GatewayOpts struct {
 	ConnectRetries    int                  `json:"connect_retries,omitempty"`
 	Gateways          []*RemoteGatewayOpts `json:"gateways,omitempty"`
 	RejectUnknown     bool                 `json:"reject_unknown,omitempty"` // config got renamed to reject_unknown_cluster
+	MaxConnSubjects   int                  `json:"max_connection_subjects,omitempty"`
 
 	// Not exported, for tests.
 	resolver         netResolver
@@ -115,11 +116,13 @@ type GatewayOpts struct {
 // NOTE: This structure is no longer used for monitoring endpoints
 // and json tags are deprecated and may be removed in the future.
 type RemoteGatewayOpts struct {
-	Name          string      `json:"name"`
-	TLSConfig     *tls.Config `json:"-"`
-	TLSTimeout    float64     `json:"tls_timeout,omitempty"`
-	URLs          []*url.URL  `json:"urls,omitempty"`
-	tlsConfigOpts *TLSConfigOpts
+	Name       string      `json:"name"`
+	TLSConfig  *tls.Config `json:"-"`
+	TLSTimeout float64     `json:"tls_timeout,omitempty"`
+	// When present, replaces the gateway pinned_certs for connections to this remote.
+	TLSPinnedCerts PinnedCertSet `json:"-"`
+	URLs           []*url.URL    `json:"urls,omitempty"`
+	tlsConfigOpts  *TLSConfigOpts
 }
 
 // LeafNodeOpts are options for a given server to accept leaf node connections and/or connect to a remote cluster.
@@ -173,9 +176,11 @@ type RemoteLeafOpts struct {
 	TLS          bool             `json:"-"`
 	TLSConfig    *tls.Config      `json:"-"`
 	TLSTimeout   float64          `json:"tls_timeout,omitempty"`
-	Hub          bool             `json:"hub,omitempty"`
-	DenyImports  []string         `json:"-"`
-	DenyExports  []string         `json:"-"`
+	// When present, replaces the leafnode pinned_certs for connections to this remote.
+	TLSPinnedCerts PinnedCertSet `json:"-"`
+	Hub            bool          `json:"hub,omitempty"`
+	DenyImports    []string      `json:"-"`
+	DenyExports    []string      `json:"-"`
 
 	// When an URL has the "ws" (or "wss") scheme, then the server will initiate the
 	// connection as a websocket connection. By default, the websocket frames will be
@@ -200,6 +205,25 @@ type JSLimitOpts struct {
 	MaxAckPending   int
 	MaxHAAssets     int
 	Duplicates      time.Duration
+	MaxAdvisories   int
+	AdvisoryWindow  time.Duration
+	ExpiryBatch     time.Duration
+}
+
+// JSAPIAuditOpts controls recording of JetStream API requests to a capped stream per account.
+type JSAPIAuditOpts struct {
+	Enabled bool
+	MaxMsgs int64
+	MaxAge  time.Duration
+}
+
+// JSSinkOpts allow streams to push their messages to external systems.
+type JSSinkOpts struct {
+	Enabled bool
+	// Hosts sinks can push to, as a host or host:port.
+	AllowedHosts []string
+	// Credentials sinks refer to by name, as user:password or a token.
+	Credentials map[string]string `json:"-"`
 }
 
 // Options block for nats-server.
@@ -219,6 +243,7 @@ type Options struct {
 	NoSigs                bool          `json:"-"`
 	NoSublistCache        bool          `json:"-"`
 	NoHeaderSupport       bool          `json:"-"`
+	NoClientCompression   bool          `json:"-"`
 	DisableShortFirstPing bool          `json:"-"`
 	Logtime               bool          `json:"-"`
 	MaxConn               int           `json:"max_connections"`
@@ -239,6 +264,8 @@ type Options struct {
 	HTTPPort              int           `json:"http_port"`
 	HTTPBasePath          string        `json:"http_base_path"`
 	HTTPSPort             int           `json:"https_port"`
+	HTTPSocket            string        `json:"http_socket,omitempty"`
+	HTTPSocketMode        os.FileMode   `json:"http_socket_mode,omitempty"`
 	AuthTimeout           float64       `json:"auth_timeout"`
 	MaxControlLine        int32         `json:"max_control_line"`
 	MaxPayload            int32         `json:"max_payload"`
@@ -254,12 +281,22 @@ type Options struct {
 	JetStreamKey          string        `json:"-"`
 	JetStreamCipher       StoreCipher   `json:"-"`
 	JetStreamUniqueTag    string
+	JetStreamCatchupTag   string
+	JetStreamTiers        map[string][]string
 	JetStreamLimits       JSLimitOpts
 	JetStreamMaxCatchup   int64
+	JetStreamCatchupRate  int64
+	JetStreamCatchupMsgs  int
+	JetStreamAPIAudit     JSAPIAuditOpts
+	JetStreamSinks        JSSinkOpts
+	JetStreamBackupKeys   map[string]string `json:"-"`
 	StoreDir              string            `json:"-"`
 	JsAccDefaultDomain    map[string]string `json:"-"` // account to domain name mapping
 	Websocket             WebsocketOpts     `json:"-"`
 	MQTT                  MQTTOpts          `json:"-"`
+	Listeners             []*ListenerOpts   `json:"-"`
+	BusyPoll              BusyPollOpts      `json:"-"`
+	CPUAffinity           CPUAffinityOpts   `json:"-"`
 	ProfPort              int               `json:"-"`
 	PidFile               string            `json:"-"`
 	PortsFileDir          string            `json:"-"`
@@ -302,9 +339,62 @@ type Options struct {
 	CustomClientAuthentication Authentication `json:"-"`
 	CustomRouterAuthentication Authentication `json:"-"`
 
+	// MsgInterceptors allows applications embedding the server to
+	// observe and filter messages, not presented as a configuration option.
+	MsgInterceptors *MsgInterceptors `json:"-"`
+
+	// LifecycleCallbacks allows applications embedding the server to be
+	// notified of state changes, not presented as a configuration option.
+	LifecycleCallbacks *LifecycleCallbacks `json:"-"`
+
+	// JetStreamTierBackend is an object store that file based streams with a
+	// TierAge move older message blocks to, not presented as a configuration option.
+	JetStreamTierBackend TierBackend `json:"-"`
+
+	// JetStreamKafkaDialer connects streams with a Kafka source to their brokers,
+	// not presented as a configuration option.
+	JetStreamKafkaDialer KafkaDialer `json:"-"`
+
+	// JetStreamRecoveryWorkers limits how many message blocks of a file based
+	// stream are recovered concurrently on startup. Defaults to GOMAXPROCS.
+	JetStreamRecoveryWorkers int `json:"-"`
+
+	// SubjectTalkers is how many subjects each account tracks to report
+	// its busiest subjects, 0 disables tracking.
+	SubjectTalkers int `json:"-"`
+
+	// Overload sets when clients are asked to back off their reconnects.
+	Overload OverloadOpts `json:"-"`
+
+	// Prober periodically sends synthetic probes to measure latency and loss.
+	Prober ProberOpts `json:"-"`
+
+	// JetStreamMemStoreArena is the size of the slabs memory based streams
+	// carve message payloads from, 0 allocates each message on its own.
+	JetStreamMemStoreArena int64 `json:"-"`
+
+	// JetStreamDataGenerator enables the API storing synthetic messages directly
+	// into streams, meant to seed test environments.
+	JetStreamDataGenerator bool `json:"-"`
+
+	// Metadata is free form information about the server, such as its hardware
+	// class or zone, shared with the other servers and returned by discovery.
+	Metadata map[string]string `json:"-"`
+
+	// Standby keeps a decommissioned server in the cluster to serve monitoring,
+	// including its prior JetStream assets, without accepting clients or hosting assets.
+	Standby bool `json:"-"`
+
+	// LatencyInjection delays writes to routes, gateways and leafnodes to rehearse
+	// WAN conditions. Only for servers built with the staging tag.
+	LatencyInjection LatencyInjectionOpts `json:"-"`
+
 	// CheckConfig configuration file syntax test was successful and exit.
 	CheckConfig bool `json:"-"`
 
+	// VerifyStore verifies the JetStream store directory, prints a report and exits.
+	VerifyStore bool `json:"-"`
+
 	// ConnectErrorReports specifies the number of failed attempts
 	// at which point server should report the failure of an initial
 	// connection to a route, gateway or leaf node.
@@ -342,6 +432,94 @@ type Options struct {
 	maxStoreSet bool
 }
 
+// OverloadOpts are options for detecting when the server is overloaded,
+// during which clients are asked to back off their reconnects.
+type OverloadOpts struct {
+	// CPU usage in percent at which we are overloaded.
+	CPU float64
+	// Resident memory in bytes at which we are overloaded.
+	Memory int64
+	// What we suggest to clients while overloaded.
+	ReconnectWait   time.Duration
+	ReconnectJitter time.Duration
+}
+
+// ProberOpts are options for the synthetic traffic prober, which measures the
+// latency and loss to the servers of our cluster, through our gateways and to
+// the replicas of some streams.
+type ProberOpts struct {
+	// How often we probe, 0 disables the prober.
+	Interval time.Duration
+	// How long we wait for a response before counting a probe as lost.
+	Timeout time.Duration
+	// Streams whose replicas we probe.
+	Streams []ProbeStream
+}
+
+// ProbeStream is a stream whose replicas are probed.
+type ProbeStream struct {
+	// Account of the stream, the global account if not set.
+	Account string
+	Stream  string
+}
+
+// ListenerOpts are options for an additional client listener.
+// Clients connecting to it use its TLS configuration instead of the
+// server's and can be limited to some accounts and a number of connections.
+type ListenerOpts struct {
+	// Name is used to refer to the listener in logs and monitoring.
+	Name string
+	// The server will accept client connections on this hostname/IP.
+	Host string
+	// The server will accept client connections on this port.
+	Port int
+
+	// TLS configuration is required for TLS to be enabled on this listener.
+	TLSConfig      *tls.Config
+	TLSTimeout     float64
+	TLSMap         bool
+	TLSPinnedCerts PinnedCertSet
+
+	// If not empty, only users bound to these accounts can connect.
+	AllowedAccounts []string
+
+	// Maximum number of connections accepted on this listener. 0 means no limit,
+	// the server's max_connections still applies.
+	MaxConn int
+}
+
+// BusyPollOpts select client connections whose read loops busy poll the socket
+// instead of waiting on the scheduler, trading CPU for lower latency.
+type BusyPollOpts struct {
+	// Connections of users bound to these accounts are selected.
+	Accounts []string
+	// Connections of these users are selected.
+	Users []string
+	// Maximum number of connections busy polling at any time.
+	MaxConn int
+	// How long a read loop keeps polling without data before it blocks again.
+	Spin time.Duration
+	// If not empty, read loops are pinned to these CPUs, in turn.
+	CPUs []int
+}
+
+// LatencyInjectionOpts are the conditions injected on each kind of connection.
+type LatencyInjectionOpts struct {
+	Routes    NetFaultOpts
+	Gateways  NetFaultOpts
+	LeafNodes NetFaultOpts
+}
+
+// NetFaultOpts are the conditions injected on a kind of connection.
+type NetFaultOpts struct {
+	// Every write is held for this long.
+	Latency time.Duration
+	// Up to this long more, picked at random for each write.
+	Jitter time.Duration
+	// Fraction of writes held for a retransmission timeout on top.
+	Loss float64
+}
+
 // WebsocketOpts are options for websocket
 type WebsocketOpts struct {
 	// The server will accept websocket client connections on this hostname/IP.
@@ -403,6 +581,12 @@ type WebsocketOpts struct {
 	// and write the response back to the client. This include the
 	// time needed for the TLS Handshake.
 	HandshakeTimeout time.Duration
+
+	// If set, these override the server's ping interval, max pings
+	// outstanding and write deadline for websocket clients.
+	PingInterval  time.Duration
+	MaxPingsOut   int
+	WriteDeadline time.Duration
 }
 
 // MQTTOpts are options for MQTT
@@ -888,6 +1072,15 @@ func (o *Options) processConfigFileLine(k string, v interface{}, errors *[]error
 		o.HTTPSPort = int(v.(int64))
 	case "http_base_path":
 		o.HTTPBasePath = v.(string)
+	case "http_socket":
+		o.HTTPSocket = v.(string)
+	case "http_socket_mode":
+		mode, err := parseFileMode(v)
+		if err != nil {
+			*errors = append(*errors, &configErr{tk, err.Error()})
+			return
+		}
+		o.HTTPSocketMode = mode
 	case "cluster":
 		err := parseCluster(tk, o, errors, warnings)
 		if err != nil {
@@ -953,6 +1146,18 @@ func (o *Options) processConfigFileLine(k string, v interface{}, errors *[]error
 		o.MaxConn = int(v.(int64))
 	case "max_traced_msg_len":
 		o.MaxTracedMsgLen = int(v.(int64))
+	case "subject_talkers":
+		o.SubjectTalkers = int(v.(int64))
+	case "overload":
+		if err := parseOverload(tk, o, errors, warnings); err != nil {
+			*errors = append(*errors, err)
+			return
+		}
+	case "prober":
+		if err := parseProber(tk, o, errors, warnings); err != nil {
+			*errors = append(*errors, err)
+			return
+		}
 	case "max_subscriptions", "max_subs":
 		o.MaxSubs = int(v.(int64))
 	case "max_sub_tokens", "max_subscription_tokens":
@@ -1168,6 +1373,12 @@ func (o *Options) processConfigFileLine(k string, v interface{}, errors *[]error
 					opts = append(opts, FetchTimeout(to))
 				}
 			}
+			if v, ok := v["encrypt"]; ok {
+				_, v := unwrapValue(v, &lt)
+				if encrypt, _ := v.(bool); encrypt {
+					opts = append(opts, EncryptAtRest())
+				}
+			}
 			if err != nil {
 				*errors = append(*errors, &configErr{tk, err.Error()})
 				return
@@ -1288,6 +1499,10 @@ func (o *Options) processConfigFileLine(k string, v interface{}, errors *[]error
 		o.NoSystemAccount = v.(bool)
 	case "no_header_support":
 		o.NoHeaderSupport = v.(bool)
+	case "standby":
+		o.Standby = v.(bool)
+	case "no_client_compression":
+		o.NoClientCompression = v.(bool)
 	case "trusted", "trusted_keys":
 		switch v := v.(type) {
 		case string:
@@ -1332,6 +1547,26 @@ func (o *Options) processConfigFileLine(k string, v interface{}, errors *[]error
 			*errors = append(*errors, err)
 			return
 		}
+	case "listeners":
+		if err := parseListeners(tk, o, errors, warnings); err != nil {
+			*errors = append(*errors, err)
+			return
+		}
+	case "busy_poll", "busy_polling":
+		if err := parseBusyPoll(tk, o, errors, warnings); err != nil {
+			*errors = append(*errors, err)
+			return
+		}
+	case "latency_injection":
+		if err := parseLatencyInjection(tk, o, errors, warnings); err != nil {
+			*errors = append(*errors, err)
+			return
+		}
+	case "cpu_affinity":
+		if err := parseCPUAffinity(tk, o, errors, warnings); err != nil {
+			*errors = append(*errors, err)
+			return
+		}
 	case "server_tags":
 		var err error
 		switch v := v.(type) {
@@ -1360,6 +1595,24 @@ func (o *Options) processConfigFileLine(k string, v interface{}, errors *[]error
 			*errors = append(*errors, err)
 			return
 		}
+	case "server_metadata":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("error parsing server metadata: unsupported type %T", v)})
+			return
+		}
+		o.Metadata = make(map[string]string, len(m))
+		for mk, mv := range m {
+			tk, mv := unwrapValue(mv, &lt)
+			switch mv := mv.(type) {
+			case string:
+				o.Metadata[mk] = mv
+			case int64, float64, bool:
+				o.Metadata[mk] = fmt.Sprint(mv)
+			default:
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("error parsing server metadata %q: unsupported type %T", mk, mv)})
+			}
+		}
 	case "default_js_domain":
 		vv, ok := v.(map[string]interface{})
 		if !ok {
@@ -1458,6 +1711,24 @@ func parseListen(v interface{}) (*hostPort, error) {
 	return hp, nil
 }
 
+// parseFileMode will parse file permissions given in octal, e.g. "0660" or 660.
+func parseFileMode(v interface{}) (os.FileMode, error) {
+	var str string
+	switch vv := v.(type) {
+	case int64:
+		str = strconv.FormatInt(vv, 10)
+	case string:
+		str = vv
+	default:
+		return 0, fmt.Errorf("expected file mode in octal, got %T", vv)
+	}
+	mode, err := strconv.ParseUint(str, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("could not parse file mode %q", str)
+	}
+	return os.FileMode(mode), nil
+}
+
 // parseCluster will parse the cluster config.
 func parseCluster(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
 	var lt token
@@ -1694,6 +1965,8 @@ func parseGateway(v interface{}, o *Options, errors *[]error, warnings *[]error)
 			o.Gateway.Gateways = gateways
 		case "reject_unknown", "reject_unknown_cluster":
 			o.Gateway.RejectUnknown = mv.(bool)
+		case "max_connection_subjects":
+			o.Gateway.MaxConnSubjects = int(mv.(int64))
 		default:
 			if !tk.IsUsedVariable() {
 				err := &unknownConfigFieldErr{
@@ -1736,70 +2009,34 @@ func parseJetStreamForAccount(v interface{}, acc *Account, errors *[]error, warn
 			return &configErr{tk, fmt.Sprintf("Expected 'enabled' or 'disabled' for string value, got '%s'", vv)}
 		}
 	case map[string]interface{}:
-		jsLimits := JetStreamAccountLimits{-1, -1, -1, -1, -1, -1, -1, false}
-		for mk, mv := range vv {
-			tk, mv = unwrapValue(mv, &lt)
-			switch strings.ToLower(mk) {
-			case "max_memory", "max_mem", "mem", "memory":
-				vv, ok := mv.(int64)
-				if !ok {
-					return &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
-				}
-				jsLimits.MaxMemory = vv
-			case "max_store", "max_file", "max_disk", "store", "disk":
-				vv, ok := mv.(int64)
-				if !ok {
-					return &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
-				}
-				jsLimits.MaxStore = vv
-			case "max_streams", "streams":
-				vv, ok := mv.(int64)
-				if !ok {
-					return &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
-				}
-				jsLimits.MaxStreams = int(vv)
-			case "max_consumers", "consumers":
-				vv, ok := mv.(int64)
-				if !ok {
-					return &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
-				}
-				jsLimits.MaxConsumers = int(vv)
-			case "max_bytes_required", "max_stream_bytes", "max_bytes":
-				vv, ok := mv.(bool)
-				if !ok {
-					return &configErr{tk, fmt.Sprintf("Expected a parseable bool for %q, got %v", mk, mv)}
-				}
-				jsLimits.MaxBytesRequired = vv
-			case "mem_max_stream_bytes", "memory_max_stream_bytes":
-				vv, ok := mv.(int64)
-				if !ok {
-					return &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
-				}
-				jsLimits.MemoryMaxStreamBytes = vv
-			case "disk_max_stream_bytes", "store_max_stream_bytes":
-				vv, ok := mv.(int64)
-				if !ok {
-					return &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
-				}
-				jsLimits.StoreMaxStreamBytes = vv
-			case "max_ack_pending":
-				vv, ok := mv.(int64)
-				if !ok {
-					return &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
-				}
-				jsLimits.MaxAckPending = int(vv)
-			default:
-				if !tk.IsUsedVariable() {
-					err := &unknownConfigFieldErr{
-						field: mk,
-						configErr: configErr{
-							token: tk,
-						},
-					}
-					*errors = append(*errors, err)
-					continue
-				}
+		// Deleted streams can be kept around for a while in case they need to be restored.
+		// Only file based streams of servers in standalone mode, see validateTrashRetention.
+		if tv, ok := vv["trash_retention"]; ok {
+			tk, tv := unwrapValue(tv, &lt)
+			if acc.jsTrash = parseDuration("trash_retention", tk, tv, errors, warnings); acc.jsTrash < 0 {
+				return &configErr{tk, "JetStream trash retention can not be negative"}
+			}
+			delete(vv, "trash_retention")
+			if len(vv) == 0 {
+				acc.jsLimits = defaultJSAccountTiers
+				return nil
+			}
+		}
+		// Limits can be split into named tiers, in which case there are no account wide limits.
+		if tv, ok := vv["tiers"]; ok {
+			if len(vv) > 1 {
+				return &configErr{tk, "JetStream account limits can not be combined with tiers"}
+			}
+			tiers, err := parseJetStreamAccountTiers(tv, errors)
+			if err != nil {
+				return err
 			}
+			acc.jsLimits = tiers
+			return nil
+		}
+		jsLimits, err := parseJetStreamAccountLimits(vv, errors)
+		if err != nil {
+			return err
 		}
 		acc.jsLimits = map[string]JetStreamAccountLimits{_EMPTY_: jsLimits}
 	default:
@@ -1808,6 +2045,103 @@ func parseJetStreamForAccount(v interface{}, acc *Account, errors *[]error, warn
 	return nil
 }
 
+// Parses the tiered jetstream limits for an account, keyed by tier name.
+func parseJetStreamAccountTiers(v interface{}, errors *[]error) (map[string]JetStreamAccountLimits, error) {
+	var lt token
+
+	tk, v := unwrapValue(v, &lt)
+	vv, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &configErr{tk, fmt.Sprintf("Expected a map to define JetStream tiers, got %T", v)}
+	}
+	tiers := make(map[string]JetStreamAccountLimits, len(vv))
+	for name, mv := range vv {
+		tk, mv = unwrapValue(mv, &lt)
+		lm, ok := mv.(map[string]interface{})
+		if !ok {
+			return nil, &configErr{tk, fmt.Sprintf("Expected a map to define limits for tier %q, got %T", name, mv)}
+		}
+		jsLimits, err := parseJetStreamAccountLimits(lm, errors)
+		if err != nil {
+			return nil, err
+		}
+		tiers[name] = jsLimits
+	}
+	return tiers, nil
+}
+
+// Parses a map of jetstream limits for an account.
+func parseJetStreamAccountLimits(vv map[string]interface{}, errors *[]error) (JetStreamAccountLimits, error) {
+	var lt token
+
+	jsLimits := JetStreamAccountLimits{-1, -1, -1, -1, -1, -1, -1, false}
+	for mk, mv := range vv {
+		tk, mv := unwrapValue(mv, &lt)
+		switch strings.ToLower(mk) {
+		case "max_memory", "max_mem", "mem", "memory":
+			vv, ok := mv.(int64)
+			if !ok {
+				return jsLimits, &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
+			}
+			jsLimits.MaxMemory = vv
+		case "max_store", "max_file", "max_disk", "store", "disk":
+			vv, ok := mv.(int64)
+			if !ok {
+				return jsLimits, &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
+			}
+			jsLimits.MaxStore = vv
+		case "max_streams", "streams":
+			vv, ok := mv.(int64)
+			if !ok {
+				return jsLimits, &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
+			}
+			jsLimits.MaxStreams = int(vv)
+		case "max_consumers", "consumers":
+			vv, ok := mv.(int64)
+			if !ok {
+				return jsLimits, &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
+			}
+			jsLimits.MaxConsumers = int(vv)
+		case "max_bytes_required", "max_stream_bytes", "max_bytes":
+			vv, ok := mv.(bool)
+			if !ok {
+				return jsLimits, &configErr{tk, fmt.Sprintf("Expected a parseable bool for %q, got %v", mk, mv)}
+			}
+			jsLimits.MaxBytesRequired = vv
+		case "mem_max_stream_bytes", "memory_max_stream_bytes":
+			vv, ok := mv.(int64)
+			if !ok {
+				return jsLimits, &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
+			}
+			jsLimits.MemoryMaxStreamBytes = vv
+		case "disk_max_stream_bytes", "store_max_stream_bytes":
+			vv, ok := mv.(int64)
+			if !ok {
+				return jsLimits, &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
+			}
+			jsLimits.StoreMaxStreamBytes = vv
+		case "max_ack_pending":
+			vv, ok := mv.(int64)
+			if !ok {
+				return jsLimits, &configErr{tk, fmt.Sprintf("Expected a parseable size for %q, got %v", mk, mv)}
+			}
+			jsLimits.MaxAckPending = int(vv)
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	return jsLimits, nil
+}
+
 // takes in a storage size as either an int or a string and returns an int64 value based on the input.
 func getStorageSize(v interface{}) (int64, error) {
 	_, ok := v.(int64)
@@ -1868,6 +2202,20 @@ func parseJetStreamLimits(v interface{}, opts *Options, errors *[]error, warning
 			if err != nil {
 				*errors = append(*errors, err)
 			}
+		case "expiry_batch":
+			var err error
+			lim.ExpiryBatch, err = time.ParseDuration(mv.(string))
+			if err != nil {
+				*errors = append(*errors, err)
+			}
+		case "max_advisories":
+			lim.MaxAdvisories = int(mv.(int64))
+		case "advisory_window":
+			var err error
+			lim.AdvisoryWindow, err = time.ParseDuration(mv.(string))
+			if err != nil {
+				*errors = append(*errors, err)
+			}
 		default:
 			if !tk.IsUsedVariable() {
 				err := &unknownConfigFieldErr{
@@ -1885,6 +2233,221 @@ func parseJetStreamLimits(v interface{}, opts *Options, errors *[]error, warning
 	return nil
 }
 
+// Parse the JetStream API audit configuration. Value can be a bool or a map of limits.
+func parseJetStreamAPIAudit(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	tk, v := unwrapValue(v, &lt)
+
+	audit := JSAPIAuditOpts{}
+
+	switch vv := v.(type) {
+	case bool:
+		audit.Enabled = vv
+	case map[string]interface{}:
+		audit.Enabled = true
+		for mk, mv := range vv {
+			tk, mv = unwrapValue(mv, &lt)
+			switch strings.ToLower(mk) {
+			case "enable", "enabled":
+				audit.Enabled = mv.(bool)
+			case "max_msgs":
+				audit.MaxMsgs = mv.(int64)
+			case "max_age":
+				var err error
+				audit.MaxAge, err = time.ParseDuration(mv.(string))
+				if err != nil {
+					*errors = append(*errors, err)
+				}
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: mk,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+					continue
+				}
+			}
+		}
+	default:
+		return &configErr{tk, fmt.Sprintf("Expected bool or map to define JetStream API audit, got %T", v)}
+	}
+	opts.JetStreamAPIAudit = audit
+	return nil
+}
+
+// Parse the stream sink configuration, a map of allowed hosts and credentials.
+func parseJetStreamSinks(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	tk, v := unwrapValue(v, &lt)
+
+	vv, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected map to define JetStream sinks, got %T", v)}
+	}
+	sinks := JSSinkOpts{Enabled: true}
+	for mk, mv := range vv {
+		tk, mv = unwrapValue(mv, &lt)
+		switch strings.ToLower(mk) {
+		case "enable", "enabled":
+			sinks.Enabled = mv.(bool)
+		case "allowed_hosts", "allow_hosts":
+			hosts, ok := mv.([]interface{})
+			if !ok {
+				return &configErr{tk, fmt.Sprintf("Expected array of hosts for JetStream sinks, got %T", mv)}
+			}
+			for _, h := range hosts {
+				htk, hv := unwrapValue(h, &lt)
+				host, ok := hv.(string)
+				if !ok || host == _EMPTY_ {
+					return &configErr{htk, fmt.Sprintf("Expected JetStream sink host to be a non empty string, got %v", hv)}
+				}
+				sinks.AllowedHosts = append(sinks.AllowedHosts, host)
+			}
+		case "credentials":
+			creds, ok := mv.(map[string]interface{})
+			if !ok {
+				return &configErr{tk, fmt.Sprintf("Expected map to define JetStream sink credentials, got %T", mv)}
+			}
+			sinks.Credentials = make(map[string]string, len(creds))
+			for name, cv := range creds {
+				ctk, cv := unwrapValue(cv, &lt)
+				cred, ok := cv.(string)
+				if !ok {
+					return &configErr{ctk, fmt.Sprintf("Expected JetStream sink credentials %q to be a string, got %T", name, cv)}
+				}
+				sinks.Credentials[name] = cred
+			}
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	if sinks.Enabled && len(sinks.AllowedHosts) == 0 {
+		return &configErr{tk, "JetStream sinks require allowed hosts"}
+	}
+	opts.JetStreamSinks = sinks
+	return nil
+}
+
+// Parse the options for memory based streams.
+func parseJetStreamMemStore(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	tk, v := unwrapValue(v, &lt)
+
+	cm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected map to define JetStream memory store, got %T", v)}
+	}
+	var arena, arenaSet bool
+	var slabSize int64
+	for mk, mv := range cm {
+		tk, mv = unwrapValue(mv, &lt)
+		switch strings.ToLower(mk) {
+		case "arena":
+			arena, arenaSet = mv.(bool), true
+		case "arena_slab_size":
+			s, err := getStorageSize(mv)
+			if err != nil || s <= 0 {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected a positive arena slab size, got %v", mv)})
+				continue
+			}
+			slabSize = s
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	// Setting a slab size enables the arena unless explicitly disabled.
+	if !arenaSet {
+		arena = slabSize > 0
+	}
+	if !arena {
+		slabSize = 0
+	} else if slabSize == 0 {
+		slabSize = defaultMemArenaSlabSize
+	}
+	opts.JetStreamMemStoreArena = slabSize
+	return nil
+}
+
+// Parse the named placement tiers, each mapping to the server tags a stream in that tier requires.
+func parseJetStreamTiers(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	tk, v := unwrapValue(v, &lt)
+
+	vv, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected a map to define JetStream tiers, got %T", v)}
+	}
+	tiers := make(map[string][]string, len(vv))
+	for name, mv := range vv {
+		tk, mv = unwrapValue(mv, &lt)
+		var tags jwt.TagList
+		switch mv := mv.(type) {
+		case string:
+			tags.Add(mv)
+		case []interface{}:
+			for _, t := range mv {
+				_, t = unwrapValue(t, &lt)
+				ts, ok := t.(string)
+				if !ok {
+					return &configErr{tk, fmt.Sprintf("error parsing tags for tier %q: unsupported type %T where string is expected", name, t)}
+				}
+				tags.Add(ts)
+			}
+		default:
+			return &configErr{tk, fmt.Sprintf("error parsing tags for tier %q: unsupported type %T", name, mv)}
+		}
+		if len(tags) == 0 {
+			return &configErr{tk, fmt.Sprintf("tier %q requires at least one tag", name)}
+		}
+		tiers[name] = tags
+	}
+	opts.JetStreamTiers = tiers
+	return nil
+}
+
+// Parse named curve25519 private keys used to seal and open stream snapshots.
+func parseJetStreamBackupKeys(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	tk, v := unwrapValue(v, &lt)
+
+	vv, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected map to define JetStream backup keys, got %T", v)}
+	}
+	keys := make(map[string]string, len(vv))
+	for name, mv := range vv {
+		tk, mv = unwrapValue(mv, &lt)
+		key, ok := mv.(string)
+		if !ok {
+			return &configErr{tk, fmt.Sprintf("Expected backup key %q to be a string, got %T", name, mv)}
+		}
+		keys[name] = key
+	}
+	opts.JetStreamBackupKeys = keys
+	return nil
+}
+
 // Parse enablement of jetstream for a server.
 func parseJetStream(v interface{}, opts *Options, errors *[]error, warnings *[]error) error {
 	var lt token
@@ -1950,14 +2513,56 @@ func parseJetStream(v interface{}, opts *Options, errors *[]error, warnings *[]e
 				if err := parseJetStreamLimits(tk, opts, errors, warnings); err != nil {
 					return err
 				}
+			case "api_audit":
+				if err := parseJetStreamAPIAudit(tk, opts, errors, warnings); err != nil {
+					return err
+				}
+			case "sinks":
+				if err := parseJetStreamSinks(tk, opts, errors, warnings); err != nil {
+					return err
+				}
+			case "backup_keys":
+				if err := parseJetStreamBackupKeys(tk, opts, errors, warnings); err != nil {
+					return err
+				}
 			case "unique_tag":
 				opts.JetStreamUniqueTag = strings.ToLower(strings.TrimSpace(mv.(string)))
+			case "catchup_tag":
+				opts.JetStreamCatchupTag = strings.ToLower(strings.TrimSpace(mv.(string)))
+			case "tiers":
+				if err := parseJetStreamTiers(tk, opts, errors, warnings); err != nil {
+					return err
+				}
 			case "max_outstanding_catchup":
 				s, err := getStorageSize(mv)
 				if err != nil {
 					return &configErr{tk, fmt.Sprintf("%s %s", strings.ToLower(mk), err)}
 				}
-				opts.JetStreamMaxCatchup = s
+				opts.JetStreamMaxCatchup = s
+			case "catchup_rate":
+				s, err := getStorageSize(mv)
+				if err != nil {
+					return &configErr{tk, fmt.Sprintf("%s %s", strings.ToLower(mk), err)}
+				}
+				opts.JetStreamCatchupRate = s
+			case "catchup_max_pending":
+				n, ok := mv.(int64)
+				if !ok || n < 0 {
+					return &configErr{tk, fmt.Sprintf("Expected a non-negative number of pending catchup messages, got %v", mv)}
+				}
+				opts.JetStreamCatchupMsgs = int(n)
+			case "data_generator":
+				opts.JetStreamDataGenerator = mv.(bool)
+			case "recovery_workers":
+				n, ok := mv.(int64)
+				if !ok || n < 0 {
+					return &configErr{tk, fmt.Sprintf("Expected a non-negative number of recovery workers, got %v", mv)}
+				}
+				opts.JetStreamRecoveryWorkers = int(n)
+			case "memstore", "mem_store":
+				if err := parseJetStreamMemStore(tk, opts, errors, warnings); err != nil {
+					return err
+				}
 			default:
 				if !tk.IsUsedVariable() {
 					err := &unknownConfigFieldErr{
@@ -2262,6 +2867,7 @@ func parseRemoteLeafNodes(v interface{}, errors *[]error, warnings *[]error) ([]
 				} else {
 					remote.TLSTimeout = float64(DEFAULT_LEAF_TLS_TIMEOUT) / float64(time.Second)
 				}
+				remote.TLSPinnedCerts = tc.PinnedCerts
 				remote.tlsConfigOpts = tc
 			case "hub":
 				remote.Hub = v.(bool)
@@ -2356,6 +2962,7 @@ func parseGateways(v interface{}, errors *[]error, warnings *[]error) ([]*Remote
 				}
 				gateway.TLSConfig = tls
 				gateway.TLSTimeout = tlsopts.Timeout
+				gateway.TLSPinnedCerts = tlsopts.PinnedCerts
 				gateway.tlsConfigOpts = tlsopts
 			case "url":
 				url, err := parseURL(v.(string), "gateway")
@@ -2413,6 +3020,9 @@ type export struct {
 	lat  *serviceLatency
 	rthr time.Duration
 	tPos uint
+	mcon int
+	mpen int
+	cttl time.Duration
 }
 
 type importStream struct {
@@ -2727,6 +3337,16 @@ func parseAccounts(v interface{}, opts *Options, errors *[]error, warnings *[]er
 						*errors = append(*errors, err)
 						continue
 					}
+				case "ping_interval":
+					acc.pingInterval = parseDuration("ping_interval", tk, mv, errors, warnings)
+				case "ping_max":
+					acc.maxPingsOut = int(mv.(int64))
+				case "write_deadline":
+					acc.writeDeadline = parseDuration("write_deadline", tk, mv, errors, warnings)
+				case "idle_timeout":
+					acc.idleTimeout = parseDuration("idle_timeout", tk, mv, errors, warnings)
+				case "tls_required":
+					acc.tlsRequired = parseTLSRequirement(tk, mv, errors)
 				default:
 					if !tk.IsUsedVariable() {
 						err := &unknownConfigFieldErr{
@@ -2837,6 +3457,22 @@ func parseAccounts(v interface{}, opts *Options, errors *[]error, warnings *[]er
 			}
 		}
 
+		if service.mcon != 0 || service.mpen != 0 {
+			if err := service.acc.SetServiceExportLimits(service.sub, service.mcon, service.mpen); err != nil {
+				msg := fmt.Sprintf("Error adding service export limits for %q: %v", service.sub, err)
+				*errors = append(*errors, &configErr{tk, msg})
+				continue
+			}
+		}
+
+		if service.cttl != 0 {
+			if err := service.acc.SetServiceExportResponseCache(service.sub, service.cttl); err != nil {
+				msg := fmt.Sprintf("Error adding service export response cache for %q: %v", service.sub, err)
+				*errors = append(*errors, &configErr{tk, msg})
+				continue
+			}
+		}
+
 		if service.lat != nil {
 			// System accounts are on be default so just make sure we have not opted out..
 			if opts.NoSystemAccount {
@@ -3024,6 +3660,10 @@ func parseExportStreamOrService(v interface{}, errors, warnings *[]error) (*expo
 		latToken   token
 		lt         token
 		accTokPos  uint
+		limToken   token
+		maxCon     int
+		maxPen     int
+		cacheTTL   time.Duration
 	)
 	defer convertPanicToErrorList(&lt, errors)
 
@@ -3174,6 +3814,34 @@ func parseExportStreamOrService(v interface{}, errors, warnings *[]error) (*expo
 			}
 		case "account_token_position":
 			accTokPos = uint(mv.(int64))
+		case "max_concurrent", "concurrency", "max_pending", "max_queued", "queue_depth":
+			limToken = tk
+			n, ok := mv.(int64)
+			if !ok || n < 0 {
+				err := &configErr{tk, fmt.Sprintf("Expected %s to be a positive number, got %v", mk, mv)}
+				*errors = append(*errors, err)
+				continue
+			}
+			switch strings.ToLower(mk) {
+			case "max_concurrent", "concurrency":
+				maxCon = int(n)
+			default:
+				maxPen = int(n)
+			}
+		case "cache_ttl", "response_cache_ttl", "response_cache":
+			limToken = tk
+			mvs, ok := mv.(string)
+			if !ok {
+				err := &configErr{tk, fmt.Sprintf("Expected response cache ttl to be a parseable time duration, got %T", mv)}
+				*errors = append(*errors, err)
+				continue
+			}
+			var err error
+			if cacheTTL, err = time.ParseDuration(mvs); err != nil || cacheTTL < 0 {
+				err := &configErr{tk, fmt.Sprintf("Expected response cache ttl to be a parseable time duration, got %q", mvs)}
+				*errors = append(*errors, err)
+				continue
+			}
 		default:
 			if !tk.IsUsedVariable() {
 				err := &unknownConfigFieldErr{
@@ -3188,9 +3856,14 @@ func parseExportStreamOrService(v interface{}, errors, warnings *[]error) (*expo
 	}
 	if curStream != nil {
 		curStream.tPos = accTokPos
+		if limToken != nil {
+			*errors = append(*errors, &configErr{limToken, "Detected service limits on non-service"})
+		}
 	}
 	if curService != nil {
 		curService.tPos = accTokPos
+		curService.mcon, curService.mpen = maxCon, maxPen
+		curService.cttl = cacheTTL
 	}
 	return curStream, curService, nil
 }
@@ -3528,6 +4201,10 @@ func parseUsers(mv interface{}, opts *Options, errors *[]error, warnings *[]erro
 				cts := parseAllowedConnectionTypes(tk, &lt, v, errors, warnings)
 				nkey.AllowedConnectionTypes = cts
 				user.AllowedConnectionTypes = cts
+			case "tls_required":
+				req := parseTLSRequirement(tk, v, errors)
+				nkey.TLS = req
+				user.TLS = req
 			default:
 				if !tk.IsUsedVariable() {
 					err := &unknownConfigFieldErr{
@@ -3968,16 +4645,17 @@ func parseTLS(v interface{}, isClientCtx bool) (t *TLSConfigOpts, retErr error)
 		case "pinned_certs":
 			ra, ok := mv.([]interface{})
 			if !ok {
-				return nil, &configErr{tk, "error parsing tls config, expected 'pinned_certs' to be a list of hex-encoded sha256 of DER encoded SubjectPublicKeyInfo"}
+				return nil, &configErr{tk, "error parsing tls config, expected 'pinned_certs' to be a list of hex-encoded sha256 of DER encoded SubjectPublicKeyInfo or certificate"}
 			}
 			if len(ra) != 0 {
 				wl := PinnedCertSet{}
 				re := regexp.MustCompile("^[A-Fa-f0-9]{64}$")
 				for _, r := range ra {
 					tk, r := unwrapValue(r, &lt)
-					entry := strings.ToLower(r.(string))
+					// Allow fingerprints in the colon separated form as printed by openssl.
+					entry := strings.ToLower(strings.ReplaceAll(r.(string), ":", _EMPTY_))
 					if !re.MatchString(entry) {
-						return nil, &configErr{tk, fmt.Sprintf("error parsing tls config, 'pinned_certs' key %s does not look like hex-encoded sha256 of DER encoded SubjectPublicKeyInfo", entry)}
+						return nil, &configErr{tk, fmt.Sprintf("error parsing tls config, 'pinned_certs' key %s does not look like hex-encoded sha256 of DER encoded SubjectPublicKeyInfo or certificate", entry)}
 					}
 					wl[entry] = struct{}{}
 				}
@@ -4070,6 +4748,115 @@ func parseStringArray(fieldName string, tk token, lt *token, mv interface{}, err
 	}
 }
 
+func parseOverload(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	om, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected overload to be a map, got %T", v)}
+	}
+	for mk, mv := range om {
+		tk, mv = unwrapValue(mv, &lt)
+		switch strings.ToLower(mk) {
+		case "cpu":
+			switch mv := mv.(type) {
+			case int64:
+				o.Overload.CPU = float64(mv)
+			case float64:
+				o.Overload.CPU = mv
+			default:
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected overload cpu to be a number, got %T", mv)})
+			}
+		case "memory", "mem":
+			o.Overload.Memory = mv.(int64)
+		case "reconnect_wait":
+			o.Overload.ReconnectWait = parseDuration(mk, tk, mv, errors, warnings)
+		case "reconnect_jitter":
+			o.Overload.ReconnectJitter = parseDuration(mk, tk, mv, errors, warnings)
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+			}
+		}
+	}
+	return nil
+}
+
+func parseProber(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	pm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected prober to be a map, got %T", v)}
+	}
+	for mk, mv := range pm {
+		tk, mv = unwrapValue(mv, &lt)
+		switch strings.ToLower(mk) {
+		case "interval":
+			o.Prober.Interval = parseDuration(mk, tk, mv, errors, warnings)
+		case "timeout":
+			o.Prober.Timeout = parseDuration(mk, tk, mv, errors, warnings)
+		case "streams":
+			sa, ok := mv.([]interface{})
+			if !ok {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected prober streams to be an array, got %T", mv)})
+				continue
+			}
+			for _, sv := range sa {
+				tk, sv := unwrapValue(sv, &lt)
+				switch sv := sv.(type) {
+				case string:
+					// Stream in the global account.
+					o.Prober.Streams = append(o.Prober.Streams, ProbeStream{Stream: sv})
+				case map[string]interface{}:
+					var ps ProbeStream
+					for k, v := range sv {
+						tk, v := unwrapValue(v, &lt)
+						switch strings.ToLower(k) {
+						case "account":
+							ps.Account = v.(string)
+						case "stream":
+							ps.Stream = v.(string)
+						default:
+							if !tk.IsUsedVariable() {
+								*errors = append(*errors, &unknownConfigFieldErr{field: k, configErr: configErr{token: tk}})
+							}
+						}
+					}
+					if ps.Stream == _EMPTY_ {
+						*errors = append(*errors, &configErr{tk, "Prober stream requires a stream name"})
+						continue
+					}
+					o.Prober.Streams = append(o.Prober.Streams, ps)
+				default:
+					*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected prober stream to be a string or a map, got %T", sv)})
+				}
+			}
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+			}
+		}
+	}
+	return nil
+}
+
 func parseWebsocket(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
 	var lt token
 	defer convertPanicToErrorList(&lt, errors)
@@ -4143,6 +4930,12 @@ func parseWebsocket(v interface{}, o *Options, errors *[]error, warnings *[]erro
 			o.Websocket.Password = auth.pass
 			o.Websocket.Token = auth.token
 			o.Websocket.AuthTimeout = auth.timeout
+		case "ping_interval":
+			o.Websocket.PingInterval = parseDuration("ping_interval", tk, mv, errors, warnings)
+		case "ping_max":
+			o.Websocket.MaxPingsOut = int(mv.(int64))
+		case "write_deadline":
+			o.Websocket.WriteDeadline = parseDuration("write_deadline", tk, mv, errors, warnings)
 		case "jwt_cookie":
 			o.Websocket.JWTCookie = mv.(string)
 		case "no_auth_user":
@@ -4163,6 +4956,261 @@ func parseWebsocket(v interface{}, o *Options, errors *[]error, warnings *[]erro
 	return nil
 }
 
+func parseListeners(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	ar, ok := v.([]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected listeners to be an array, got %T", v)}
+	}
+	for _, lv := range ar {
+		tk, lv = unwrapValue(lv, &lt)
+		lm, ok := lv.(map[string]interface{})
+		if !ok {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected listener entry to be a map, got %T", lv)})
+			continue
+		}
+		lo := &ListenerOpts{}
+		for mk, mv := range lm {
+			// Again, unwrap token value if line check is required.
+			tk, mv = unwrapValue(mv, &lt)
+			switch strings.ToLower(mk) {
+			case "name":
+				lo.Name = mv.(string)
+			case "listen":
+				hp, err := parseListen(mv)
+				if err != nil {
+					err := &configErr{tk, err.Error()}
+					*errors = append(*errors, err)
+					continue
+				}
+				lo.Host = hp.host
+				lo.Port = hp.port
+			case "port":
+				lo.Port = int(mv.(int64))
+			case "host", "net":
+				lo.Host = mv.(string)
+			case "tls":
+				tc, err := parseTLS(tk, true)
+				if err != nil {
+					*errors = append(*errors, err)
+					continue
+				}
+				if lo.TLSConfig, err = GenTLSConfig(tc); err != nil {
+					err := &configErr{tk, err.Error()}
+					*errors = append(*errors, err)
+					continue
+				}
+				lo.TLSTimeout = tc.Timeout
+				lo.TLSMap = tc.Map
+				lo.TLSPinnedCerts = tc.PinnedCerts
+			case "allowed_accounts", "accounts":
+				lo.AllowedAccounts, _ = parseStringArray("allowed accounts", tk, &lt, mv, errors, warnings)
+			case "max_connections", "max_conn":
+				lo.MaxConn = int(mv.(int64))
+			default:
+				if !tk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: mk,
+						configErr: configErr{
+							token: tk,
+						},
+					}
+					*errors = append(*errors, err)
+					continue
+				}
+			}
+		}
+		o.Listeners = append(o.Listeners, lo)
+	}
+	return nil
+}
+
+func parseBusyPoll(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	bm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected busy_poll to be a map, got %T", v)}
+	}
+	for mk, mv := range bm {
+		// Again, unwrap token value if line check is required.
+		tk, mv = unwrapValue(mv, &lt)
+		switch strings.ToLower(mk) {
+		case "accounts":
+			o.BusyPoll.Accounts, _ = parseStringArray("busy poll accounts", tk, &lt, mv, errors, warnings)
+		case "users":
+			o.BusyPoll.Users, _ = parseStringArray("busy poll users", tk, &lt, mv, errors, warnings)
+		case "max_connections", "max_conn":
+			o.BusyPoll.MaxConn = int(mv.(int64))
+		case "spin", "poll_duration":
+			o.BusyPoll.Spin = parseDuration("spin", tk, mv, errors, warnings)
+		case "cpus":
+			ar, ok := mv.([]interface{})
+			if !ok {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected busy poll cpus to be an array, got %T", mv)})
+				continue
+			}
+			for _, cv := range ar {
+				ctk, cv := unwrapValue(cv, &lt)
+				cpu, ok := cv.(int64)
+				if !ok {
+					*errors = append(*errors, &configErr{ctk, fmt.Sprintf("Expected busy poll cpu to be an integer, got %T", cv)})
+					continue
+				}
+				o.BusyPoll.CPUs = append(o.BusyPoll.CPUs, int(cpu))
+			}
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	return nil
+}
+
+func parseLatencyInjection(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	lm, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected latency_injection to be a map, got %T", v)}
+	}
+	for mk, mv := range lm {
+		// Again, unwrap token value if line check is required.
+		tk, mv = unwrapValue(mv, &lt)
+		var fo *NetFaultOpts
+		switch strings.ToLower(mk) {
+		case "routes", "cluster":
+			fo = &o.LatencyInjection.Routes
+		case "gateways", "gateway":
+			fo = &o.LatencyInjection.Gateways
+		case "leafnodes", "leafnode", "leaf":
+			fo = &o.LatencyInjection.LeafNodes
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+			}
+			continue
+		}
+		fm, ok := mv.(map[string]interface{})
+		if !ok {
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected latency injection for %s to be a map, got %T", mk, mv)})
+			continue
+		}
+		for fk, fv := range fm {
+			ftk, fv := unwrapValue(fv, &lt)
+			switch strings.ToLower(fk) {
+			case "latency", "delay":
+				fo.Latency = parseDuration("latency", ftk, fv, errors, warnings)
+			case "jitter":
+				fo.Jitter = parseDuration("jitter", ftk, fv, errors, warnings)
+			case "loss":
+				switch l := fv.(type) {
+				case float64:
+					fo.Loss = l
+				case int64:
+					fo.Loss = float64(l)
+				default:
+					*errors = append(*errors, &configErr{ftk, fmt.Sprintf("Expected loss to be a number, got %T", fv)})
+				}
+			default:
+				if !ftk.IsUsedVariable() {
+					err := &unknownConfigFieldErr{
+						field: fk,
+						configErr: configErr{
+							token: ftk,
+						},
+					}
+					*errors = append(*errors, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func parseCPUAffinity(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
+	var lt token
+	defer convertPanicToErrorList(&lt, errors)
+
+	tk, v := unwrapValue(v, &lt)
+	am, ok := v.(map[string]interface{})
+	if !ok {
+		return &configErr{tk, fmt.Sprintf("Expected cpu_affinity to be a map, got %T", v)}
+	}
+	// CPUs are given as a list like "0-15,32-47" or an array of integers.
+	parseCPUs := func(tk token, v interface{}) []int {
+		switch v := v.(type) {
+		case string:
+			cpus, err := parseCPUList(v)
+			if err != nil {
+				*errors = append(*errors, &configErr{tk, fmt.Sprintf("Invalid cpu affinity cpus: %v", err)})
+				return nil
+			}
+			return cpus
+		case []interface{}:
+			var cpus []int
+			for _, cv := range v {
+				ctk, cv := unwrapValue(cv, &lt)
+				cpu, ok := cv.(int64)
+				if !ok {
+					*errors = append(*errors, &configErr{ctk, fmt.Sprintf("Expected cpu affinity cpu to be an integer, got %T", cv)})
+					continue
+				}
+				cpus = append(cpus, int(cpu))
+			}
+			return cpus
+		default:
+			*errors = append(*errors, &configErr{tk, fmt.Sprintf("Expected cpu affinity cpus to be a string or an array, got %T", v)})
+			return nil
+		}
+	}
+	for mk, mv := range am {
+		// Again, unwrap token value if line check is required.
+		tk, mv = unwrapValue(mv, &lt)
+		switch strings.ToLower(mk) {
+		case "client_io", "clients":
+			o.CPUAffinity.ClientIO = parseCPUs(tk, mv)
+		case "filestore", "filestore_flush":
+			o.CPUAffinity.Filestore = parseCPUs(tk, mv)
+		case "raft":
+			o.CPUAffinity.Raft = parseCPUs(tk, mv)
+		default:
+			if !tk.IsUsedVariable() {
+				err := &unknownConfigFieldErr{
+					field: mk,
+					configErr: configErr{
+						token: tk,
+					},
+				}
+				*errors = append(*errors, err)
+				continue
+			}
+		}
+	}
+	return nil
+}
+
 func parseMQTT(v interface{}, o *Options, errors *[]error, warnings *[]error) error {
 	var lt token
 	defer convertPanicToErrorList(&lt, errors)
@@ -4523,6 +5571,14 @@ func setBaselineOptions(opts *Options) {
 	if opts.AuthTimeout == 0 {
 		opts.AuthTimeout = getDefaultAuthTimeout(opts.TLSConfig, opts.TLSTimeout)
 	}
+	for _, lo := range opts.Listeners {
+		if lo.Host == _EMPTY_ {
+			lo.Host = opts.Host
+		}
+		if lo.TLSTimeout == 0 {
+			lo.TLSTimeout = opts.TLSTimeout
+		}
+	}
 	if opts.Cluster.Port != 0 {
 		if opts.Cluster.Host == "" {
 			opts.Cluster.Host = DEFAULT_HOST
@@ -4712,6 +5768,8 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp,
 	fs.BoolVar(&opts.JetStream, "jetstream", false, "Enable JetStream.")
 	fs.StringVar(&opts.StoreDir, "sd", "", "Storage directory.")
 	fs.StringVar(&opts.StoreDir, "store_dir", "", "Storage directory.")
+	fs.BoolVar(&opts.VerifyStore, "verify_store", false, "Verify the storage directory and exit.")
+	fs.BoolVar(&opts.VerifyStore, "verify-store", false, "Verify the storage directory and exit.")
 
 	// The flags definition above set "default" values to some of the options.
 	// Calling Parse() here will override the default options with any value
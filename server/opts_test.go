@@ -977,6 +977,96 @@ func TestTlsPinnedCertificates(t *testing.T) {
 	check(opts.Websocket.TLSPinnedCerts)
 }
 
+func TestTlsPinnedCertificatesRemotes(t *testing.T) {
+	confFileName := createConfFile(t, []byte(`
+	leafnodes {
+		remotes [{
+			url: "tls://127.0.0.1:7422"
+			tls {
+				cert_file: "./configs/certs/server.pem"
+				key_file: "./configs/certs/key.pem"
+				pinned_certs: ["7F:83:B1:65:7F:F1:FC:53:B9:2D:C1:81:48:A1:D6:5D:FC:2D:4B:1F:A3:D6:77:28:4A:DD:D2:00:12:6D:90:69"]
+			}
+		}]
+	}
+	gateway {
+		name: "A"
+		port -1
+		gateways [
+			{
+				name: "B"
+				url: "nats://127.0.0.1:7222"
+				tls {
+					cert_file: "./configs/certs/server.pem"
+					key_file: "./configs/certs/key.pem"
+					pinned_certs: ["a8f407340dcc719864214b85ed96f98d16cbffa8f509d9fa4ca237b7bb3f9c32"]
+				}
+			}
+			{name: "C", url: "nats://127.0.0.1:7223"}
+		]
+	}`))
+	opts, err := ProcessConfigFile(confFileName)
+	if err != nil {
+		t.Fatalf("Received an error reading config file: %v", err)
+	}
+	require_True(t, len(opts.LeafNode.Remotes) == 1)
+	pinned := opts.LeafNode.Remotes[0].TLSPinnedCerts
+	require_True(t, len(pinned) == 1)
+	_, ok := pinned["7f83b1657ff1fc53b92dc18148a1d65dfc2d4b1fa3d677284addd200126d9069"]
+	require_True(t, ok)
+	require_NoError(t, validatePinnedCerts(pinned))
+
+	require_True(t, len(opts.Gateway.Gateways) == 2)
+	require_True(t, len(opts.Gateway.Gateways[0].TLSPinnedCerts) == 1)
+	require_True(t, opts.Gateway.Gateways[1].TLSPinnedCerts == nil)
+}
+
+func TestJetStreamTiersConfig(t *testing.T) {
+	confFileName := createConfFile(t, []byte(`
+	jetstream {
+		tiers {
+			fast: [SSD, nvme]
+			bulk: hdd
+		}
+	}
+	accounts {
+		A {
+			jetstream {
+				tiers {
+					fast: {max_mem: 1MB, max_file: 10MB, max_streams: 2}
+					bulk: {max_file: 1GB}
+				}
+			}
+		}
+	}`))
+	opts, err := ProcessConfigFile(confFileName)
+	require_NoError(t, err)
+	require_True(t, reflect.DeepEqual(opts.JetStreamTiers, map[string][]string{
+		"fast": {"ssd", "nvme"},
+		"bulk": {"hdd"},
+	}))
+	require_True(t, len(opts.Accounts) == 1)
+	lim := opts.Accounts[0].jsLimits
+	require_True(t, len(lim) == 2)
+	require_True(t, lim["fast"].MaxMemory == 1024*1024)
+	require_True(t, lim["fast"].MaxStreams == 2)
+	require_True(t, lim["bulk"].MaxStore == 1024*1024*1024)
+	require_True(t, lim["bulk"].MaxMemory == -1)
+
+	confFileName = createConfFile(t, []byte(`
+	accounts {
+		A {
+			jetstream {
+				max_mem: 1MB
+				tiers { fast: {max_mem: 1MB} }
+			}
+		}
+	}`))
+	_, err = ProcessConfigFile(confFileName)
+	require_Error(t, err)
+	require_Contains(t, err.Error(), "can not be combined with tiers")
+}
+
 func TestNkeyUsersDefaultPermissionsConfig(t *testing.T) {
 	confFileName := createConfFile(t, []byte(`
 	authorization {
@@ -0,0 +1,263 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Max number of distinct permission sets we will share decisions for.
+const maxPermDecisionSets = 4096
+
+// PermCacheStats reports on the shared permission decision caches.
+type PermCacheStats struct {
+	Sets          int    `json:"sets"`
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Invalidations uint64 `json:"invalidations"`
+}
+
+// decisionCache is a bounded cache of permission decisions.
+type decisionCache struct {
+	// Have these first for memory alignment due to the use of atomic.
+	sz    int32
+	prun  int32
+	cache sync.Map
+}
+
+// permDecisions caches publish and subscribe decisions for a compiled set of
+// permissions. Decisions are a function of the permissions alone, so all
+// connections with the same permissions, e.g. all connections of a user, share them.
+type permDecisions struct {
+	// Have these first for memory alignment due to the use of atomic.
+	hits   uint64
+	misses uint64
+	acc    string
+	key    string
+	// Connections using these, protected by the registry lock.
+	refs int
+	pub  decisionCache
+	sub  decisionCache
+}
+
+// permCacheRegistry tracks the shared permission decisions for a server.
+type permCacheRegistry struct {
+	mu   sync.Mutex
+	sets map[string]*permDecisions
+	// Totals for sets that have been dropped.
+	hits          uint64
+	misses        uint64
+	invalidations uint64
+}
+
+func newPermCacheRegistry() *permCacheRegistry {
+	return &permCacheRegistry{sets: make(map[string]*permDecisions)}
+}
+
+// Returns a fingerprint of the permissions. Leafnodes are evaluated differently
+// for wildcard subscriptions so they do not share with other connection kinds.
+func permsFingerprint(perms *Permissions, kind int) string {
+	b, _ := json.Marshal(perms)
+	h := sha256.New()
+	if kind == LEAF {
+		h.Write([]byte("leaf:"))
+	}
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Returns a fingerprint for fp with additional deny permissions merged in.
+func mergedPermsFingerprint(fp string, what denyType, deny []string) string {
+	h := sha256.New()
+	h.Write([]byte(fp))
+	h.Write([]byte{byte(what)})
+	h.Write([]byte(strings.Join(deny, " ")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Returns the shared decisions for the permissions with fingerprint fp in account acc.
+// These need to be released once no longer used. A nil registry will return private decisions.
+func (r *permCacheRegistry) decisions(acc, fp string) *permDecisions {
+	if r == nil {
+		return &permDecisions{acc: acc}
+	}
+	key := acc + " " + fp
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if pd := r.sets[key]; pd != nil {
+		pd.refs++
+		return pd
+	}
+	// If we are at our limit drop some, connections using them will keep them privately.
+	if len(r.sets) >= maxPermDecisionSets {
+		n := 0
+		for k, pd := range r.sets {
+			r.dropLocked(k, pd)
+			if n++; n > pruneSize {
+				break
+			}
+		}
+	}
+	pd := &permDecisions{acc: acc, key: key, refs: 1}
+	r.sets[key] = pd
+	return pd
+}
+
+// Releases decisions returned by decisions, dropping them once no connection uses them.
+func (r *permCacheRegistry) release(pd *permDecisions) {
+	if r == nil || pd == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if pd.refs--; pd.refs <= 0 && r.sets[pd.key] == pd {
+		r.dropLocked(pd.key, pd)
+	}
+}
+
+// Lock should be held.
+func (r *permCacheRegistry) dropLocked(key string, pd *permDecisions) {
+	delete(r.sets, key)
+	r.hits += atomic.LoadUint64(&pd.hits)
+	r.misses += atomic.LoadUint64(&pd.misses)
+}
+
+// Invalidates all decisions for the account, or all accounts if acc is empty.
+// The decisions are cleared in place so connections keep sharing them and
+// will rebuild them on demand.
+func (r *permCacheRegistry) invalidate(acc string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, pd := range r.sets {
+		if acc != _EMPTY_ && pd.acc != acc {
+			continue
+		}
+		pd.pub.clear()
+		pd.sub.clear()
+		r.invalidations++
+	}
+}
+
+func (r *permCacheRegistry) stats() *PermCacheStats {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ps := &PermCacheStats{
+		Sets:          len(r.sets),
+		Hits:          r.hits,
+		Misses:        r.misses,
+		Invalidations: r.invalidations,
+	}
+	for _, pd := range r.sets {
+		ps.Hits += atomic.LoadUint64(&pd.hits)
+		ps.Misses += atomic.LoadUint64(&pd.misses)
+	}
+	return ps
+}
+
+// Returns a cached decision if present and records the hit or miss.
+func (pd *permDecisions) lookup(dc *decisionCache, key string) (allowed, ok bool) {
+	v, ok := dc.cache.Load(key)
+	if ok {
+		atomic.AddUint64(&pd.hits, 1)
+		return v.(bool), true
+	}
+	atomic.AddUint64(&pd.misses, 1)
+	return false, false
+}
+
+func (dc *decisionCache) store(key string, allowed bool) {
+	dc.cache.Store(key, allowed)
+	if n := atomic.AddInt32(&dc.sz, 1); n > maxPermCacheSize {
+		dc.prune()
+	}
+}
+
+// prune will prune the cache via randomly deleting items.
+// Doing so pruneSize items at a time.
+func (dc *decisionCache) prune() {
+	// There is a case where we can invoke this from multiple go routines,
+	// (in deliverMsg() if sub.client is a LEAF), so we make sure to prune
+	// from only one go routine at a time.
+	if !atomic.CompareAndSwapInt32(&dc.prun, 0, 1) {
+		return
+	}
+	const maxPruneAtOnce = 1000
+	r := 0
+	dc.cache.Range(func(k, _ interface{}) bool {
+		dc.cache.Delete(k)
+		if r++; (r > pruneSize && atomic.LoadInt32(&dc.sz) < int32(maxPermCacheSize)) ||
+			(r > maxPruneAtOnce) {
+			return false
+		}
+		return true
+	})
+	atomic.AddInt32(&dc.sz, -int32(r))
+	atomic.StoreInt32(&dc.prun, 0)
+}
+
+func (dc *decisionCache) clear() {
+	r := 0
+	dc.cache.Range(func(k, _ interface{}) bool {
+		dc.cache.Delete(k)
+		r++
+		return true
+	})
+	atomic.AddInt32(&dc.sz, -int32(r))
+}
+
+// Sets the shared decisions for our permissions with fingerprint fp.
+// Lock should be held.
+func (c *client) setPermDecisions(fp string) {
+	var r *permCacheRegistry
+	if c.srv != nil {
+		r = c.srv.permCache
+	}
+	var acc string
+	if c.acc != nil {
+		acc = c.acc.Name
+	}
+	c.perms.fp = fp
+	pd := r.decisions(acc, fp)
+	c.perms.dc.Store(pd)
+	// Release what we had last, after taking the new ones so shared decisions
+	// for the same permissions are not dropped in between.
+	r.release(c.perms.pd)
+	c.perms.pd = pd
+}
+
+// Releases our shared decisions, when closed or our permissions are replaced.
+// Lock should be held.
+func (c *client) releasePermDecisions() {
+	if c.perms == nil || c.perms.pd == nil || c.srv == nil {
+		return
+	}
+	c.srv.permCache.release(c.perms.pd)
+	c.perms.pd = nil
+}
+
+// Returns our cached decisions.
+func (p *permissions) decisions() *permDecisions {
+	return p.dc.Load().(*permDecisions)
+}
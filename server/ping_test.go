@@ -66,3 +66,101 @@ func TestPing(t *testing.T) {
 		start = time.Now()
 	}
 }
+
+func TestPingOverrides(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: "127.0.0.1:-1"
+		ping_interval: "2m"
+		ping_max: 2
+		no_sys_acc: true
+		websocket {
+			listen: "127.0.0.1:-1"
+			no_tls: true
+			ping_interval: "30s"
+			ping_max: 5
+			write_deadline: "20s"
+		}
+		accounts {
+			IOT {
+				users [{user: iot, password: pwd}]
+				ping_interval: "50ms"
+				ping_max: 1
+				write_deadline: "30s"
+			}
+			DC {
+				users [{user: dc, password: pwd}]
+			}
+		}
+	`))
+	s, o := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	if o.Websocket.PingInterval != 30*time.Second || o.Websocket.MaxPingsOut != 5 || o.Websocket.WriteDeadline != 20*time.Second {
+		t.Fatalf("Unexpected websocket overrides: %+v", o.Websocket)
+	}
+
+	connect := func(user string) (net.Conn, *bufio.Reader) {
+		t.Helper()
+		c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", o.Port))
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+		br := bufio.NewReader(c)
+		// Wait for INFO
+		br.ReadLine()
+		c.Write([]byte(fmt.Sprintf("CONNECT {\"verbose\":false,\"user\":%q,\"pass\":\"pwd\"}\r\nPING\r\n", user)))
+		if l, _, err := br.ReadLine(); err != nil || string(l) != "PONG" {
+			t.Fatalf("Expected PONG, got %q, %v", l, err)
+		}
+		return c, br
+	}
+
+	c, br := connect("iot")
+	defer c.Close()
+	// We do not answer pings so the connection should be deemed stale quickly.
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		l, _, err := br.ReadLine()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if string(l) == "-ERR 'Stale Connection'" {
+			break
+		}
+		if string(l) != "PING" {
+			t.Fatalf("Unexpected line %q", l)
+		}
+	}
+
+	c2, br2 := connect("dc")
+	defer c2.Close()
+	// The server's ping interval applies here.
+	c2.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+	if l, _, err := br2.ReadLine(); err == nil {
+		t.Fatalf("Expected no ping, got %q", l)
+	}
+
+	checkClient := func(user string, intv time.Duration, mpo int, wdl time.Duration) {
+		t.Helper()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, cli := range s.clients {
+			cli.mu.Lock()
+			match := cli.opts.Username == user
+			if match && (cli.pingInterval() != intv || cli.maxPingsOut() != mpo || cli.out.wdl != wdl) {
+				t.Errorf("Unexpected values for %q: %v %v %v", user, cli.pingInterval(), cli.maxPingsOut(), cli.out.wdl)
+			}
+			cli.mu.Unlock()
+			if match {
+				return
+			}
+		}
+		t.Fatalf("Client %q not found", user)
+	}
+	checkClient("dc", 2*time.Minute, 2, DEFAULT_FLUSH_DEADLINE)
+
+	c3, br3 := connect("iot")
+	defer c3.Close()
+	br3.ReadLine()
+	checkClient("iot", 50*time.Millisecond, 1, 30*time.Second)
+}
@@ -0,0 +1,360 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Types of paths the prober measures.
+const (
+	ProbePathCore    = "core"
+	ProbePathRoute   = "route"
+	ProbePathGateway = "gateway"
+	ProbePathStream  = "stream"
+)
+
+const (
+	// How long we wait for a probe response by default.
+	defaultProbeTimeout = 2 * time.Second
+	// Weight of the last probe in the average round trip time.
+	probeRTTWeight = 0.2
+)
+
+// ProbePath holds the latency and loss measured for a path of the prober.
+type ProbePath struct {
+	Path      string        `json:"path"`
+	Type      string        `json:"type"`
+	Server    string        `json:"server,omitempty"`
+	Cluster   string        `json:"cluster,omitempty"`
+	Account   string        `json:"account,omitempty"`
+	Stream    string        `json:"stream,omitempty"`
+	Sent      uint64        `json:"sent"`
+	Received  uint64        `json:"received"`
+	Lost      uint64        `json:"lost"`
+	Loss      float64       `json:"loss"`
+	LastRTT   time.Duration `json:"last_rtt"`
+	AvgRTT    time.Duration `json:"avg_rtt"`
+	MinRTT    time.Duration `json:"min_rtt"`
+	MaxRTT    time.Duration `json:"max_rtt"`
+	LastProbe time.Time     `json:"last_probe,omitempty"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+// Sent to the probe responder of a server, with an account and stream to check
+// that the server has a running replica of the stream.
+type probeRequest struct {
+	Account string `json:"account,omitempty"`
+	Stream  string `json:"stream,omitempty"`
+}
+
+type probeResponse struct {
+	Server  string `json:"server"`
+	LastSeq uint64 `json:"last_seq,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// A path to probe on the next round.
+type probeTarget struct {
+	ProbePath
+	id  string
+	err string
+}
+
+// serverProber holds the results of the prober.
+type serverProber struct {
+	mu    sync.Mutex
+	paths map[string]*ProbePath
+}
+
+// Answers probes from other servers, and our own.
+func (s *Server) probeRequest(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	if reply == _EMPTY_ {
+		return
+	}
+	_, msg := c.msgParts(rmsg)
+	resp := &probeResponse{Server: s.Name()}
+	var req probeRequest
+	if len(msg) > 0 {
+		if err := json.Unmarshal(msg, &req); err != nil {
+			resp.Error = err.Error()
+		}
+	}
+	if resp.Error == _EMPTY_ && req.Stream != _EMPTY_ {
+		if acc, err := s.lookupAccount(req.Account); err != nil {
+			resp.Error = err.Error()
+		} else if mset, err := acc.lookupStream(req.Stream); err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.LastSeq = mset.lastSeq()
+		}
+	}
+	// With echo, since we answer our own probes too.
+	s.sendInternalAccountMsgWithReply(nil, reply, _EMPTY_, nil, resp, true)
+}
+
+// Periodically probes all paths we know of.
+func (s *Server) runProber() {
+	defer s.grWG.Done()
+	for {
+		interval := s.getOpts().Prober.Interval
+		if interval <= 0 {
+			return
+		}
+		select {
+		case <-s.quitCh:
+			return
+		case <-time.After(interval):
+		}
+		s.probe()
+	}
+}
+
+// Returns the paths to probe, the servers of our cluster, one server of each
+// cluster we have a gateway to, and the replicas of the configured streams.
+func (s *Server) probeTargets() []*probeTarget {
+	opts := s.getOpts()
+	ourID, ourName, ourCluster := s.ID(), s.Name(), s.ClusterName()
+
+	targets := []*probeTarget{{ProbePath: ProbePath{Path: ProbePathCore, Type: ProbePathCore, Server: ourName, Cluster: ourCluster}, id: ourID}}
+
+	// Gateways are probed through the first server by name of each cluster.
+	gws := make(map[string]*probeTarget)
+	s.nodeToInfo.Range(func(_, v interface{}) bool {
+		ni := v.(nodeInfo)
+		if ni.id == ourID || ni.offline {
+			return true
+		}
+		if ni.cluster == ourCluster {
+			targets = append(targets, &probeTarget{
+				ProbePath: ProbePath{Path: ProbePathRoute + ":" + ni.name, Type: ProbePathRoute, Server: ni.name, Cluster: ni.cluster},
+				id:        ni.id,
+			})
+		} else if s.getOutboundGatewayConnection(ni.cluster) != nil {
+			if t := gws[ni.cluster]; t == nil || ni.name < t.Server {
+				gws[ni.cluster] = &probeTarget{
+					ProbePath: ProbePath{Path: ProbePathGateway + ":" + ni.cluster, Type: ProbePathGateway, Server: ni.name, Cluster: ni.cluster},
+					id:        ni.id,
+				}
+			}
+		}
+		return true
+	})
+	for _, t := range gws {
+		targets = append(targets, t)
+	}
+
+	js := s.getJetStream()
+	for _, ps := range opts.Prober.Streams {
+		accName := ps.Account
+		if accName == _EMPTY_ {
+			accName = globalAccountName
+		}
+		path := fmt.Sprintf("%s:%s/%s", ProbePathStream, accName, ps.Stream)
+		newTarget := func(name, cluster, id string) *probeTarget {
+			return &probeTarget{
+				ProbePath: ProbePath{Path: path + ":" + name, Type: ProbePathStream, Server: name, Cluster: cluster, Account: accName, Stream: ps.Stream},
+				id:        id,
+			}
+		}
+		if js == nil {
+			t := newTarget(ourName, ourCluster, _EMPTY_)
+			t.Path, t.err = path, "jetstream not enabled"
+			targets = append(targets, t)
+			continue
+		}
+		if !js.isClustered() {
+			targets = append(targets, newTarget(ourName, ourCluster, ourID))
+			continue
+		}
+		js.mu.RLock()
+		var peers []string
+		if sa := js.streamAssignment(accName, ps.Stream); sa != nil && sa.Group != nil {
+			peers = append(peers, sa.Group.Peers...)
+		}
+		js.mu.RUnlock()
+		if len(peers) == 0 {
+			t := newTarget(_EMPTY_, _EMPTY_, _EMPTY_)
+			t.Path, t.err = path, "stream not found"
+			targets = append(targets, t)
+			continue
+		}
+		for _, peer := range peers {
+			if v, ok := s.nodeToInfo.Load(peer); ok && v != nil {
+				ni := v.(nodeInfo)
+				targets = append(targets, newTarget(ni.name, ni.cluster, ni.id))
+			} else {
+				t := newTarget(peer, _EMPTY_, _EMPTY_)
+				t.err = "replica server unknown"
+				targets = append(targets, t)
+			}
+		}
+	}
+	return targets
+}
+
+// Sends a probe to each target and waits for the responses.
+func (s *Server) probe() {
+	opts := s.getOpts().Prober
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	if timeout > opts.Interval {
+		timeout = opts.Interval
+	}
+
+	targets := s.probeTargets()
+	if len(targets) == 0 {
+		return
+	}
+
+	// Responses are recorded under rmu until we are done waiting for them.
+	var rmu sync.Mutex
+	var closed bool
+	rtts := make([]time.Duration, len(targets))
+	errs := make([]string, len(targets))
+	responded := make([]bool, len(targets))
+	pending := len(targets)
+	done := make(chan struct{})
+	// Lock should be held.
+	finish := func() {
+		if pending--; pending == 0 {
+			close(done)
+		}
+	}
+
+	s.mu.Lock()
+	if !s.eventsEnabled() || s.sys.replies == nil || s.sys.sendq == nil {
+		s.mu.Unlock()
+		return
+	}
+	start := time.Now()
+	inboxes := make([]string, 0, len(targets))
+	for i, t := range targets {
+		if t.err != _EMPTY_ || t.id == _EMPTY_ {
+			rmu.Lock()
+			finish()
+			rmu.Unlock()
+			continue
+		}
+		i := i
+		inbox := s.newRespInbox()
+		inboxes = append(inboxes, inbox)
+		s.sys.replies[inbox] = func(_ *subscription, c *client, _ *Account, _, _ string, rmsg []byte) {
+			rtt := time.Since(start)
+			_, msg := c.msgParts(rmsg)
+			var resp probeResponse
+			err := json.Unmarshal(msg, &resp)
+			rmu.Lock()
+			defer rmu.Unlock()
+			if closed || responded[i] {
+				return
+			}
+			responded[i] = true
+			if err != nil {
+				errs[i] = err.Error()
+			} else if resp.Error != _EMPTY_ {
+				errs[i] = resp.Error
+			} else {
+				rtts[i] = rtt
+			}
+			finish()
+		}
+		// With echo, so we receive our own probes.
+		req := &probeRequest{Account: t.Account, Stream: t.Stream}
+		s.sys.sendq.push(newPubMsg(nil, fmt.Sprintf(serverDirectReqSubj, t.id, "PROBE"), inbox, nil, nil, req, noCompression, true, false))
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	case <-s.quitCh:
+	}
+
+	rmu.Lock()
+	closed = true
+	rmu.Unlock()
+
+	s.mu.Lock()
+	if s.sys != nil && s.sys.replies != nil {
+		for _, inbox := range inboxes {
+			delete(s.sys.replies, inbox)
+		}
+	}
+	sp := s.prober
+	s.mu.Unlock()
+	if sp == nil {
+		return
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for i, t := range targets {
+		p := sp.paths[t.Path]
+		if p == nil {
+			p = &ProbePath{Path: t.Path, Type: t.Type, Account: t.Account, Stream: t.Stream}
+			sp.paths[t.Path] = p
+		}
+		p.Server, p.Cluster = t.Server, t.Cluster
+		p.Sent++
+		p.LastProbe = start.UTC()
+		rtt := rtts[i]
+		switch {
+		case t.err != _EMPTY_:
+			p.Lost++
+			p.LastError = t.err
+		case rtt > 0:
+			p.Received++
+			p.LastRTT, p.LastError = rtt, _EMPTY_
+			if p.MinRTT == 0 || rtt < p.MinRTT {
+				p.MinRTT = rtt
+			}
+			if rtt > p.MaxRTT {
+				p.MaxRTT = rtt
+			}
+			if p.AvgRTT == 0 {
+				p.AvgRTT = rtt
+			} else {
+				p.AvgRTT = time.Duration(probeRTTWeight*float64(rtt) + (1-probeRTTWeight)*float64(p.AvgRTT))
+			}
+		default:
+			p.Lost++
+			if errs[i] != _EMPTY_ {
+				p.LastError = errs[i]
+			} else {
+				p.LastError = "timeout"
+			}
+		}
+		p.Loss = float64(p.Lost) / float64(p.Sent)
+	}
+}
+
+// Returns a copy of the paths measured by the prober, sorted by path.
+func (sp *serverProber) snapshot() []*ProbePath {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	paths := make([]*ProbePath, 0, len(sp.paths))
+	for _, p := range sp.paths {
+		pc := *p
+		paths = append(paths, &pc)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Path < paths[j].Path })
+	return paths
+}
@@ -1632,6 +1632,9 @@ func (n *raft) run() {
 	s := n.s
 	defer s.grWG.Done()
 
+	release := s.pinToCPUs(cpuPoolRaft, s.getOpts().CPUAffinity.Raft)
+	defer release()
+
 	// We want to wait for some routing to be enabled, so we will wait for
 	// at least a route, leaf or gateway connection to be established before
 	// starting the run loop.
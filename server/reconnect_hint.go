@@ -0,0 +1,148 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server/pse"
+)
+
+const (
+	// How often we check if we are overloaded.
+	overloadCheckInterval = 5 * time.Second
+	// Defaults for what we suggest to clients while overloaded.
+	defaultOverloadReconnectWait   = 5 * time.Second
+	defaultOverloadReconnectJitter = 2 * time.Second
+)
+
+// ReconnectHint is sent to clients in the INFO protocol to suggest how they
+// should back off their reconnects, either because this server is overloaded
+// or because an operator asked for it while recovering from an incident.
+// Clients that are rejected still receive the INFO before the -ERR.
+type ReconnectHint struct {
+	Wait   time.Duration `json:"wait"`
+	Jitter time.Duration `json:"jitter,omitempty"`
+	Reason string        `json:"reason,omitempty"`
+}
+
+// BackoffOptions are options passed to set the reconnect hint of servers.
+// A zero Wait clears any hint that was set before.
+type BackoffOptions struct {
+	Wait   time.Duration `json:"wait"`
+	Jitter time.Duration `json:"jitter,omitempty"`
+	Reason string        `json:"reason,omitempty"`
+	// How long the hint stays in place, until cleared if not set.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// Backoffz is the reconnect hint a server is currently sending to clients.
+type Backoffz struct {
+	ID   string         `json:"server_id"`
+	Now  time.Time      `json:"now"`
+	Hint *ReconnectHint `json:"reconnect_hint,omitempty"`
+}
+
+// Backoff sets or clears the reconnect hint requested by an operator.
+// This takes precedence over any hint from being overloaded.
+func (s *Server) Backoff(opts *BackoffOptions) *Backoffz {
+	s.mu.Lock()
+	if s.backoffTmr != nil {
+		s.backoffTmr.Stop()
+		s.backoffTmr = nil
+	}
+	if opts == nil || opts.Wait <= 0 {
+		s.backoffHint = nil
+	} else {
+		s.backoffHint = &ReconnectHint{Wait: opts.Wait, Jitter: opts.Jitter, Reason: opts.Reason}
+		if opts.Duration > 0 {
+			hint := s.backoffHint
+			s.backoffTmr = time.AfterFunc(opts.Duration, func() {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				// Make sure this is still ours.
+				if s.backoffHint == hint {
+					s.backoffHint, s.backoffTmr = nil, nil
+					s.updateReconnectHint()
+				}
+			})
+		}
+	}
+	s.updateReconnectHint()
+	bz := &Backoffz{ID: s.info.ID, Now: time.Now().UTC(), Hint: s.info.ReconnectHint}
+	s.mu.Unlock()
+	return bz
+}
+
+// Updates the reconnect hint in our INFO and lets connected clients know if it changed.
+// Lock should be held.
+func (s *Server) updateReconnectHint() {
+	hint := s.backoffHint
+	if hint == nil {
+		hint = s.overloadHint
+	}
+	if ohint := s.info.ReconnectHint; ohint == hint || (ohint != nil && hint != nil && *ohint == *hint) {
+		return
+	}
+	s.info.ReconnectHint = hint
+	s.sendAsyncInfoToClients(true, true)
+}
+
+// Returns why we are overloaded, or an empty string if we are not.
+func (s *Server) overloaded(opts *OverloadOpts) string {
+	var pcpu float64
+	var rss, vss int64
+	pse.ProcUsage(&pcpu, &rss, &vss)
+	if opts.CPU > 0 && pcpu >= opts.CPU {
+		return "cpu"
+	}
+	if opts.Memory > 0 && rss >= opts.Memory {
+		return "memory"
+	}
+	return _EMPTY_
+}
+
+// Periodically checks if we are overloaded and asks clients to back off while we are.
+func (s *Server) overloadMonitor() {
+	defer s.grWG.Done()
+	t := time.NewTicker(overloadCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.quitCh:
+			return
+		case <-t.C:
+		}
+		opts := s.getOpts().Overload
+		reason := s.overloaded(&opts)
+
+		s.mu.Lock()
+		if reason == _EMPTY_ && s.overloadHint != nil {
+			s.Noticef("Server no longer overloaded")
+			s.overloadHint = nil
+		} else if reason != _EMPTY_ && (s.overloadHint == nil || s.overloadHint.Reason != reason) {
+			s.Warnf("Server overloaded on %s, asking clients to back off reconnects", reason)
+			wait, jitter := opts.ReconnectWait, opts.ReconnectJitter
+			if wait <= 0 {
+				wait = defaultOverloadReconnectWait
+			}
+			if jitter <= 0 {
+				jitter = defaultOverloadReconnectJitter
+			}
+			s.overloadHint = &ReconnectHint{Wait: wait, Jitter: jitter, Reason: reason}
+		}
+		s.updateReconnectHint()
+		s.mu.Unlock()
+	}
+}
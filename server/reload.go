@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"os"
 	"reflect"
 	"sort"
 	"strings"
@@ -313,6 +314,19 @@ func (u *tagsOption) IsStatszChange() bool {
 	return true
 }
 
+// metadataOption implements the option interface for the `server_metadata` setting.
+type metadataOption struct {
+	noopOption // Will be reloaded with options and gossiped with the next statsz.
+}
+
+func (u *metadataOption) Apply(server *Server) {
+	server.Noticef("Reloaded: server_metadata")
+}
+
+func (u *metadataOption) IsStatszChange() bool {
+	return true
+}
+
 // usersOption implements the option interface for the authorization `users`
 // setting.
 type usersOption struct {
@@ -539,6 +553,30 @@ func (p *pingIntervalOption) Apply(server *Server) {
 	server.Noticef("Reloaded: ping_interval = %s", p.newValue)
 }
 
+// busyPollOption implements the option interface for the `busy_poll`
+// setting.
+type busyPollOption struct {
+	noopOption
+}
+
+// Apply is a no-op because the busy poll settings are checked when clients
+// connect, connections already busy polling keep doing so.
+func (b *busyPollOption) Apply(server *Server) {
+	server.Noticef("Reloaded: busy_poll")
+}
+
+// latencyInjectionOption implements the option interface for the
+// `latency_injection` setting.
+type latencyInjectionOption struct {
+	noopOption
+}
+
+// Apply is a no-op because latency injection is set up when connections are
+// created, existing connections keep their settings.
+func (l *latencyInjectionOption) Apply(server *Server) {
+	server.Noticef("Reloaded: latency_injection")
+}
+
 // maxPingsOutOption implements the option interface for the `ping_max`
 // setting.
 type maxPingsOutOption struct {
@@ -608,6 +646,29 @@ func (jso jetStreamOption) IsStatszChange() bool {
 	return true
 }
 
+// jetStreamCatchupOption implements the option interface for the `catchup_rate`
+// and `catchup_max_pending` settings.
+type jetStreamCatchupOption struct {
+	noopOption
+	name     string
+	newValue int64
+}
+
+// Apply is a no-op because catchups pick up the options for each batch.
+func (c *jetStreamCatchupOption) Apply(s *Server) {
+	s.Noticef("Reloaded: JetStream %s = %d", c.name, c.newValue)
+}
+
+// jetStreamSinksOption implements the option interface for the jetstream `sinks` setting.
+type jetStreamSinksOption struct {
+	noopOption
+}
+
+// Apply is a no-op because sinks check the options each time they connect.
+func (c *jetStreamSinksOption) Apply(s *Server) {
+	s.Noticef("Reloaded: JetStream sinks")
+}
+
 type ocspOption struct {
 	noopOption
 	newValue *OCSPConfig
@@ -889,6 +950,10 @@ func (s *Server) reloadOptions(curOpts, newOpts *Options) error {
 	// applications starting NATS Server programmatically).
 	newOpts.CustomClientAuthentication = curOpts.CustomClientAuthentication
 	newOpts.CustomRouterAuthentication = curOpts.CustomRouterAuthentication
+	newOpts.MsgInterceptors = curOpts.MsgInterceptors
+	newOpts.LifecycleCallbacks = curOpts.LifecycleCallbacks
+	newOpts.JetStreamTierBackend = curOpts.JetStreamTierBackend
+	newOpts.JetStreamKafkaDialer = curOpts.JetStreamKafkaDialer
 
 	changed, err := s.diffOptions(newOpts)
 	if err != nil {
@@ -899,6 +964,9 @@ func (s *Server) reloadOptions(curOpts, newOpts *Options) error {
 		if err := validateOptions(newOpts); err != nil {
 			return err
 		}
+		if err := validateTrashRetention(newOpts, s.JetStreamIsClustered()); err != nil {
+			return err
+		}
 	}
 
 	// Create a context that is used to pass special info that we may need
@@ -945,9 +1013,18 @@ func imposeOrder(value interface{}) error {
 		})
 	case WebsocketOpts:
 		sort.Strings(value.AllowedOrigins)
+	case []*ListenerOpts:
+		sort.Slice(value, func(i, j int) bool {
+			return value[i].Name < value[j].Name
+		})
+	case BusyPollOpts:
+		sort.Strings(value.Accounts)
+		sort.Strings(value.Users)
+	case JSSinkOpts:
+		sort.Strings(value.AllowedHosts)
 	case string, bool, uint8, int, int32, int64, time.Duration, float64, nil, LeafNodeOpts, ClusterOpts, *tls.Config, PinnedCertSet,
 		*URLAccResolver, *MemAccResolver, *DirAccResolver, *CacheDirAccResolver, Authentication, MQTTOpts, jwt.TagList,
-		*OCSPConfig, map[string]string, JSLimitOpts, StoreCipher:
+		*OCSPConfig, map[string]string, map[string][]string, JSLimitOpts, JSAPIAuditOpts, CPUAffinityOpts, StoreCipher, *MsgInterceptors, *LifecycleCallbacks, TierBackend, KafkaDialer, OverloadOpts, ProberOpts, LatencyInjectionOpts, os.FileMode:
 		// explicitly skipped types
 	default:
 		// this will fail during unit tests
@@ -1034,6 +1111,8 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 			diffOpts = append(diffOpts, &passwordOption{})
 		case "tags":
 			diffOpts = append(diffOpts, &tagsOption{})
+		case "metadata":
+			diffOpts = append(diffOpts, &metadataOption{})
 		case "authorization":
 			diffOpts = append(diffOpts, &authorizationOption{})
 		case "authtimeout":
@@ -1267,6 +1346,12 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 					return nil, fmt.Errorf("config reload not supported for jetstream max memory and store")
 				}
 			}
+		case "jetstreamsinks":
+			diffOpts = append(diffOpts, &jetStreamSinksOption{})
+		case "jetstreamcatchuprate":
+			diffOpts = append(diffOpts, &jetStreamCatchupOption{name: "catchup_rate", newValue: newValue.(int64)})
+		case "jetstreamcatchupmsgs":
+			diffOpts = append(diffOpts, &jetStreamCatchupOption{name: "catchup_max_pending", newValue: int64(newValue.(int))})
 		case "websocket":
 			// Similar to gateways
 			tmpOld := oldValue.(WebsocketOpts)
@@ -1279,6 +1364,14 @@ func (s *Server) diffOptions(newOpts *Options) ([]option, error) {
 				return nil, fmt.Errorf("config reload not supported for %s: old=%v, new=%v",
 					field.Name, oldValue, newValue)
 			}
+		case "listeners":
+			if !listenerOptsEqual(oldValue.([]*ListenerOpts), newValue.([]*ListenerOpts)) {
+				return nil, fmt.Errorf("config reload not supported for %s", field.Name)
+			}
+		case "busypoll":
+			diffOpts = append(diffOpts, &busyPollOption{})
+		case "latencyinjection":
+			diffOpts = append(diffOpts, &latencyInjectionOption{})
 		case "mqtt":
 			diffOpts = append(diffOpts, &mqttAckWaitReload{newValue: newValue.(MQTTOpts).AckWait})
 			diffOpts = append(diffOpts, &mqttMaxAckPendingReload{newValue: newValue.(MQTTOpts).MaxAckPending})
@@ -1740,6 +1833,9 @@ func (s *Server) reloadAuthorization() {
 		resetCh <- struct{}{}
 	}
 
+	// Any permissions may have changed.
+	s.permCache.invalidate(_EMPTY_)
+
 	// Check that publish retained messages sources are still allowed to publish.
 	s.mqttCheckPubRetainedPerms()
 
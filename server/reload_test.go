@@ -4508,3 +4508,23 @@ func TestConfigReloadWithSysAccountOnly(t *testing.T) {
 		// ok
 	}
 }
+
+func TestConfigReloadClientListeners(t *testing.T) {
+	tmpl := `
+		listen: 127.0.0.1:-1
+		listeners: [
+			{name: internal, listen: 127.0.0.1:-1, max_connections: %d}
+		]
+	`
+	conf := createConfFile(t, []byte(fmt.Sprintf(tmpl, 10)))
+	s, _ := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	// Unchanged listeners, including the random port, can be reloaded.
+	require_NoError(t, s.Reload())
+
+	changeCurrentConfigContentWithNewContent(t, conf, []byte(fmt.Sprintf(tmpl, 20)))
+	err := s.Reload()
+	require_Error(t, err)
+	require_Contains(t, err.Error(), "config reload not supported for Listeners")
+}
@@ -48,6 +48,9 @@ const (
 	RouteProtoInfo
 	// RouteProtoV2 is the new route/cluster protocol that provides account support.
 	RouteProtoV2
+	// RouteProtoV3 signals a route takes a queue weight of zero for members being
+	// drained, older routes would remove the queue subscription instead.
+	RouteProtoV3
 )
 
 // Include the space for the proto
@@ -83,6 +86,10 @@ type route struct {
 	leafnodeURL  string
 	hash         string
 	idHash       string
+	// Set if the remote supports subscriptions snapshots.
+	subSnap bool
+	snapOut *subSnapOut
+	snapIn  *subSnapIn
 }
 
 type connectInfo struct {
@@ -425,6 +432,11 @@ func (c *client) processInboundRoutedMsg(msg []byte) {
 		return
 	}
 
+	// Snapshots of the remote's subscriptions are handled here.
+	if c.handleSubSnapshotMsg(msg) {
+		return
+	}
+
 	// If the subject (c.pa.subject) has the gateway prefix, this function will handle it.
 	if c.handleGatewayReply(msg) {
 		// We are done here.
@@ -603,6 +615,7 @@ func (c *client) processRouteInfo(info *Info) {
 	c.route.remoteName = info.Name
 	c.route.lnoc = info.LNOC
 	c.route.jetstream = info.JetStream
+	c.route.subSnap = info.SubSnapshot
 
 	// When sent through route INFO, if the field is set, it should be of size 1.
 	if len(info.LeafNodeURLs) == 1 {
@@ -850,6 +863,7 @@ func (c *client) canExport(subject string) bool {
 func (c *client) setRoutePermissions(perms *RoutePermissions) {
 	// Reset if some were set
 	if perms == nil {
+		c.releasePermDecisions()
 		c.perms = nil
 		c.mperms = nil
 		return
@@ -958,6 +972,10 @@ func (c *client) processRemoteUnsub(arg []byte) (err error) {
 	// We store local subs by account and subject and optionally queue name.
 	// RS- will have the arg exactly as the key.
 	key := string(arg)
+	// Snapshot in progress must not bring this back.
+	if si := c.route.snapIn; si != nil {
+		si.dead[key] = struct{}{}
+	}
 	sub, ok := c.subs[key]
 	if ok {
 		delete(c.subs, key)
@@ -1082,6 +1100,10 @@ func (c *client) processRemoteSub(argo []byte, hasOrigin bool) (err error) {
 	}
 	key := string(sub.sid)
 
+	if si := c.route.snapIn; si != nil {
+		delete(si.dead, key)
+	}
+
 	osub := c.subs[key]
 	updateGWs := false
 	delta := int32(1)
@@ -1151,6 +1173,11 @@ func (c *client) addRouteSubOrUnsubProtoToBuf(buf []byte, accName string, sub *s
 				i--
 				b[i] = digits[l%10]
 			}
+			// A weight of zero is sent for queue members of a server in lame duck mode.
+			if i == len(b) {
+				i--
+				b[i] = '0'
+			}
 			buf = append(buf, b[i:]...)
 		}
 	}
@@ -1192,6 +1219,11 @@ func (s *Server) sendSubsToRoute(route *client) {
 
 	buf := make([]byte, 0, eSize)
 
+	// Our queue members are about to go away in lame duck mode.
+	s.mu.Lock()
+	drain := s.drainingQueueMembers()
+	s.mu.Unlock()
+
 	route.mu.Lock()
 	for _, a := range accs {
 		a.mu.RLock()
@@ -1208,11 +1240,18 @@ func (s *Server) sendSubsToRoute(route *client) {
 				continue
 			}
 			sub := subscription{subject: subj, queue: qn, qw: n}
+			if drain && len(qn) > 0 && route.opts.Protocol >= RouteProtoV3 {
+				sub.qw = 0
+			}
 			buf = route.addRouteSubOrUnsubProtoToBuf(buf, a.Name, &sub, true)
 		}
 		a.mu.RUnlock()
 	}
-	route.enqueueProto(buf)
+	if route.route.subSnap && len(buf) >= routeSubSnapMinSize {
+		route.sendSubSnapshot(buf)
+	} else {
+		route.enqueueProto(buf)
+	}
 	route.mu.Unlock()
 	route.Debugf("Sent local subscriptions to route")
 }
@@ -1289,6 +1328,7 @@ func (s *Server) createRoute(conn net.Conn, rURL *url.URL) *client {
 		}
 	}
 
+	conn = s.injectLatency(conn, ROUTER)
 	c := &client{srv: s, nc: conn, opts: ClientOpts{}, kind: ROUTER, msubs: -1, mpay: -1, route: r, start: time.Now()}
 
 	// Grab server variables
@@ -1594,8 +1634,12 @@ func (s *Server) updateRouteSubscriptionMap(acc *Account, sub *subscription, del
 		routes = append(routes, route)
 	}
 	trace := atomic.LoadInt32(&s.logging.trace) == 1
+	drain := s.drainingQueueMembers()
 	s.mu.Unlock()
 
+	// Routes that do not take a weight of zero get our actual weight.
+	var osubs []*subscription
+
 	// If we are a queue subscriber we need to make sure our updates are serialized from
 	// potential multiple connections. We want to make sure that the order above is preserved
 	// here but not necessarily all updates need to be sent. We need to block and recheck the
@@ -1609,13 +1653,20 @@ func (s *Server) updateRouteSubscriptionMap(acc *Account, sub *subscription, del
 		acc.mu.Lock()
 		n = rm[key]
 		sub.qw = n
+		// When draining we keep our weight at zero so that the other servers
+		// stop sending to members that are about to go away.
+		if drain && n > 0 {
+			osub := *sub
+			osubs = []*subscription{&osub}
+			sub.qw = 0
+		}
 		// Check the last sent weight here. If same, then someone
 		// beat us to it and we can just return here. Otherwise update
-		if ls, ok := lqws[key]; ok && ls == n {
+		if ls, ok := lqws[key]; ok && ls == sub.qw {
 			acc.mu.Unlock()
 			return
 		} else if n > 0 {
-			lqws[key] = n
+			lqws[key] = sub.qw
 		}
 		acc.mu.Unlock()
 	}
@@ -1626,13 +1677,76 @@ func (s *Server) updateRouteSubscriptionMap(acc *Account, sub *subscription, del
 	// Deliver to all routes.
 	for _, route := range routes {
 		route.mu.Lock()
+		rsubs := subs
+		if osubs != nil && route.opts.Protocol < RouteProtoV3 {
+			rsubs = osubs
+		}
 		// Note that queue unsubs where n > 0 are still
 		// subscribes with a smaller weight.
-		route.sendRouteSubOrUnSubProtos(subs, n > 0, trace, route.importFilter)
+		route.sendRouteSubOrUnSubProtos(rsubs, n > 0, trace, route.importFilter)
 		route.mu.Unlock()
 	}
 }
 
+// Returns true if our queue members are about to go away, in lame duck mode
+// or when shutting down.
+// Lock should be held.
+func (s *Server) drainingQueueMembers() bool {
+	return s.ldm || s.shutdown
+}
+
+// drainRouteQueueWeights sends a weight of zero for all of our queue subscriptions
+// to our routes when entering lame duck mode or shutting down. Other servers then
+// stop delivering to our queue members, which are about to be disconnected, as long
+// as the group has members elsewhere, instead of until the unsubscribes propagate.
+// Routes that predate RouteProtoV3 keep our weights, for them zero means removal.
+func (s *Server) drainRouteQueueWeights() {
+	var _routes [32]*client
+	routes := _routes[:0]
+	s.mu.Lock()
+	for _, route := range s.routes {
+		routes = append(routes, route)
+	}
+	trace := atomic.LoadInt32(&s.logging.trace) == 1
+	s.mu.Unlock()
+	if len(routes) == 0 {
+		return
+	}
+
+	var accs []*Account
+	s.accounts.Range(func(k, v interface{}) bool {
+		accs = append(accs, v.(*Account))
+		return true
+	})
+	for _, acc := range accs {
+		var subs []*subscription
+		acc.sqmu.Lock()
+		acc.mu.Lock()
+		for key := range acc.rm {
+			i := strings.IndexByte(key, ' ')
+			if i < 0 {
+				continue
+			}
+			if ls, ok := acc.lqws[key]; ok && ls == 0 {
+				continue
+			}
+			acc.lqws[key] = 0
+			subs = append(subs, &subscription{subject: []byte(key[:i]), queue: []byte(key[i+1:])})
+		}
+		acc.mu.Unlock()
+		if len(subs) > 0 {
+			for _, route := range routes {
+				route.mu.Lock()
+				if route.opts.Protocol >= RouteProtoV3 {
+					route.sendRouteSubOrUnSubProtos(subs, true, trace, route.importFilter)
+				}
+				route.mu.Unlock()
+			}
+		}
+		acc.sqmu.Unlock()
+	}
+}
+
 // This starts the route accept loop in a go routine, unless it
 // is detected that the server has already been shutdown.
 // It will also start soliciting explicit routes.
@@ -1671,7 +1785,7 @@ func (s *Server) startRouteAcceptLoop() {
 	s.Noticef("Listening for route connections on %s",
 		net.JoinHostPort(opts.Cluster.Host, strconv.Itoa(l.Addr().(*net.TCPAddr).Port)))
 
-	proto := RouteProtoV2
+	proto := RouteProtoV3
 	// For tests, we want to be able to make this server behave
 	// as an older server so check this option to see if we should override
 	if opts.routeProto < 0 {
@@ -1701,9 +1815,10 @@ func (s *Server) startRouteAcceptLoop() {
 		Domain:       s.info.Domain,
 		Dynamic:      s.isClusterNameDynamic(),
 		LNOC:         true,
+		SubSnapshot:  true,
 	}
-	// Set this if only if advertise is not disabled
-	if !opts.Cluster.NoAdvertise {
+	// Set this if only if advertise is not disabled, and we accept clients.
+	if !opts.Cluster.NoAdvertise && !opts.Standby {
 		info.ClientConnectURLs = s.clientConnectURLs
 		info.WSConnectURLs = s.websocket.connectURLs
 	}
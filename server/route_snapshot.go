@@ -0,0 +1,271 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/nats-io/nuid"
+)
+
+// When a route is established and the remote supports it, a large interest is
+// not sent as individual RS+ protocols but as a snapshot: the protocols are
+// split in chunks, compressed, and sent as routed messages in a reserved
+// account. The receiver acknowledges each chunk with the next one it expects,
+// which paces the transfer and allows it to ask the sender to resume from any
+// chunk. Subscriptions and unsubscriptions received while the snapshot is in
+// progress are more recent than the snapshot and take precedence over it.
+
+var (
+	// Interest smaller than this is sent as regular protocols.
+	routeSubSnapMinSize = 256 * 1024
+	// Uncompressed size of the chunks.
+	routeSubSnapChunkSize = 256 * 1024
+	// Number of chunks sent ahead of the last acknowledged one.
+	routeSubSnapWindow = 8
+)
+
+const (
+	// Account and subject prefixes of the snapshot messages.
+	routeSubSnapAcc    = "$RSNAP"
+	routeSubSnapPre    = "$RSNAP."
+	routeSubSnapAckPre = "$RSNAP.ACK."
+	routeSubSnapNakPre = "$RSNAP.NAK."
+	// Number of chunks that can fail to be decoded before giving up on the route.
+	routeSubSnapMaxErrs = 3
+)
+
+// Snapshot being sent over a route.
+type subSnapOut struct {
+	id     string
+	chunks [][]byte
+	// Number of chunks sent.
+	sent int
+}
+
+// Snapshot being received from a route.
+type subSnapIn struct {
+	id    string
+	total int
+	// Next chunk expected, starting at 1.
+	next int
+	// Subscriptions removed while the snapshot is in progress.
+	dead map[string]struct{}
+	errs int
+}
+
+// Sends the RS+ protocols in buf as a snapshot.
+// Lock should be held.
+func (c *client) sendSubSnapshot(buf []byte) {
+	var chunks [][]byte
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > routeSubSnapChunkSize {
+			// Chunks end on a protocol boundary.
+			i := bytes.LastIndex(buf[:routeSubSnapChunkSize], []byte(CR_LF))
+			if i < 0 {
+				i = bytes.Index(buf, []byte(CR_LF))
+			}
+			n = i + LEN_CR_LF
+		}
+		chunks = append(chunks, s2.Encode(nil, buf[:n]))
+		buf = buf[n:]
+	}
+	c.route.snapOut = &subSnapOut{id: nuid.Next(), chunks: chunks}
+	c.sendSubSnapChunks(0)
+}
+
+// Sends the chunks of our snapshot not sent yet, up to the window past acked.
+// Lock should be held.
+func (c *client) sendSubSnapChunks(acked int) {
+	so := c.route.snapOut
+	total := len(so.chunks)
+	end := acked + routeSubSnapWindow
+	if end > total {
+		end = total
+	}
+	for ; so.sent < end; so.sent++ {
+		subj := routeSubSnapPre + so.id + "." + strconv.Itoa(so.sent+1) + "." + strconv.Itoa(total)
+		c.enqueueSubSnapMsg(subj, so.chunks[so.sent])
+	}
+}
+
+// Lock should be held.
+func (c *client) enqueueSubSnapMsg(subject string, payload []byte) {
+	buf := make([]byte, 0, msgHeadProtoLen+len(routeSubSnapAcc)+len(subject)+len(payload)+16)
+	buf = append(buf, msgHeadProto...)
+	buf = append(buf, routeSubSnapAcc...)
+	buf = append(buf, ' ')
+	buf = append(buf, subject...)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(len(payload)), 10)
+	buf = append(buf, CR_LF...)
+	buf = append(buf, payload...)
+	buf = append(buf, CR_LF...)
+	c.enqueueProto(buf)
+}
+
+// Handles the messages of a snapshot, sent or received.
+// Returns true if the routed message was one of them.
+func (c *client) handleSubSnapshotMsg(msg []byte) bool {
+	if string(c.pa.account) != routeSubSnapAcc || !bytes.HasPrefix(c.pa.subject, []byte(routeSubSnapPre)) {
+		return false
+	}
+	subject := string(c.pa.subject)
+	tokens := strings.Split(subject, tsep)
+	if len(tokens) != 4 {
+		c.Debugf("Ignoring subscriptions snapshot message on %q", subject)
+		return true
+	}
+	switch {
+	case strings.HasPrefix(subject, routeSubSnapAckPre), strings.HasPrefix(subject, routeSubSnapNakPre):
+		next, _ := strconv.Atoi(tokens[3])
+		c.processSubSnapAck(tokens[2], next, tokens[1] == "NAK")
+	default:
+		seq, _ := strconv.Atoi(tokens[2])
+		total, _ := strconv.Atoi(tokens[3])
+		c.processSubSnapChunk(tokens[1], seq, total, msg[:len(msg)-LEN_CR_LF])
+	}
+	return true
+}
+
+// Processes the acknowledgment of our snapshot by the remote.
+func (c *client) processSubSnapAck(id string, next int, resume bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.route == nil || c.isClosed() {
+		return
+	}
+	so := c.route.snapOut
+	if so == nil || so.id != id || next < 1 {
+		return
+	}
+	acked := next - 1
+	if acked >= len(so.chunks) {
+		c.route.snapOut = nil
+		c.Debugf("Sent subscriptions snapshot to route in %d chunks", len(so.chunks))
+		return
+	}
+	if resume && acked < so.sent {
+		c.Debugf("Resuming subscriptions snapshot at chunk %d", next)
+		so.sent = acked
+	}
+	c.sendSubSnapChunks(acked)
+}
+
+// Processes a chunk of the snapshot of the remote.
+func (c *client) processSubSnapChunk(id string, seq, total int, data []byte) {
+	c.mu.Lock()
+	if c.route == nil || c.isClosed() {
+		c.mu.Unlock()
+		return
+	}
+	si := c.route.snapIn
+	if si == nil {
+		// Chunks after the end of a snapshot are duplicates.
+		if seq != 1 || total < 1 {
+			c.mu.Unlock()
+			return
+		}
+		si = &subSnapIn{id: id, total: total, next: 1, dead: make(map[string]struct{})}
+		c.route.snapIn = si
+	} else if si.id != id {
+		c.mu.Unlock()
+		return
+	}
+	switch {
+	case seq < si.next:
+		c.mu.Unlock()
+		return
+	case seq > si.next:
+		// We missed some, ask for them.
+		c.sendSubSnapAck(si, true)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	buf, err := s2.Decode(nil, data)
+	if err != nil {
+		c.mu.Lock()
+		si.errs++
+		failed := si.errs > routeSubSnapMaxErrs
+		if !failed {
+			c.sendSubSnapAck(si, true)
+		}
+		c.mu.Unlock()
+		c.Errorf("Error decoding subscriptions snapshot chunk %d/%d: %v", seq, total, err)
+		if failed {
+			c.closeConnection(ParseError)
+		}
+		return
+	}
+	c.applySubSnapChunk(si, buf)
+
+	c.mu.Lock()
+	si.next++
+	if si.next > si.total {
+		c.route.snapIn = nil
+		c.Debugf("Received subscriptions snapshot from route in %d chunks", si.total)
+	}
+	if !c.isClosed() {
+		c.sendSubSnapAck(si, false)
+	}
+	c.mu.Unlock()
+}
+
+// Registers the subscriptions of a chunk, unless they are already known or
+// were removed since the snapshot was taken.
+func (c *client) applySubSnapChunk(si *subSnapIn, buf []byte) {
+	for len(buf) > 0 {
+		i := bytes.Index(buf, []byte(CR_LF))
+		if i < 0 {
+			break
+		}
+		line := buf[:i]
+		buf = buf[i+LEN_CR_LF:]
+		if !bytes.HasPrefix(line, rSubBytes) {
+			continue
+		}
+		arg := line[len(rSubBytes):]
+		key := arg
+		// Queue subscriptions have a trailing weight which is not part of the key.
+		if args := splitArg(arg); len(args) == 4 {
+			key = arg[:len(arg)-len(args[3])-1]
+		}
+		c.mu.Lock()
+		_, known := c.subs[string(key)]
+		_, dead := si.dead[string(key)]
+		c.mu.Unlock()
+		if known || dead {
+			continue
+		}
+		if err := c.processRemoteSub(arg, false); err != nil {
+			c.Errorf("Error processing subscriptions snapshot: %v", err)
+		}
+	}
+}
+
+// Lets the remote know the next chunk we expect.
+// Lock should be held.
+func (c *client) sendSubSnapAck(si *subSnapIn, resume bool) {
+	pre := routeSubSnapAckPre
+	if resume {
+		pre = routeSubSnapNakPre
+	}
+	c.enqueueSubSnapMsg(pre+si.id+"."+strconv.Itoa(si.next), nil)
+}
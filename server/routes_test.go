@@ -1757,3 +1757,90 @@ func TestRouteSaveTLSName(t *testing.T) {
 	reloadUpdateConfig(t, s2, c2And3Conf, fmt.Sprintf(tmpl, "localhost", o1.Cluster.Port))
 	checkClusterFormed(t, s1, s2, s3)
 }
+
+func TestRouteSubscriptionsSnapshot(t *testing.T) {
+	oldMin, oldChunk, oldWindow := routeSubSnapMinSize, routeSubSnapChunkSize, routeSubSnapWindow
+	routeSubSnapMinSize, routeSubSnapChunkSize, routeSubSnapWindow = 1, 1024, 2
+	defer func() {
+		routeSubSnapMinSize, routeSubSnapChunkSize, routeSubSnapWindow = oldMin, oldChunk, oldWindow
+	}()
+
+	o1 := DefaultOptions()
+	o1.NoSystemAccount = true
+	s1 := RunServer(o1)
+	defer s1.Shutdown()
+
+	nc1 := natsConnect(t, s1.ClientURL())
+	defer nc1.Close()
+	const total = 2000
+	var subs []*nats.Subscription
+	for i := 0; i < total; i++ {
+		var sub *nats.Subscription
+		if i%10 == 0 {
+			sub = natsQueueSubSync(t, nc1, fmt.Sprintf("foo.%d", i), "queue")
+		} else {
+			sub = natsSubSync(t, nc1, fmt.Sprintf("foo.%d", i))
+		}
+		subs = append(subs, sub)
+	}
+	natsFlush(t, nc1)
+
+	l := &captureDebugLogger{dbgCh: make(chan string, 1000)}
+	s1.SetLogger(l, true, false)
+
+	o2 := DefaultOptions()
+	o2.NoSystemAccount = true
+	o2.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", o1.Cluster.Port))
+	s2 := RunServer(o2)
+	defer s2.Shutdown()
+	checkClusterFormed(t, s1, s2)
+
+	// The interest of s1 gets to s2 through the snapshot.
+	checkExpectedSubs(t, total, s1, s2)
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		for _, s := range []*Server{s1, s2} {
+			s.mu.Lock()
+			for _, r := range s.routes {
+				r.mu.Lock()
+				inProgress := r.route.snapOut != nil || r.route.snapIn != nil
+				r.mu.Unlock()
+				if inProgress {
+					s.mu.Unlock()
+					return fmt.Errorf("snapshot still in progress on %s", s)
+				}
+			}
+			s.mu.Unlock()
+		}
+		return nil
+	})
+
+	// The interest was sent in more chunks than the window.
+	var sent bool
+	for len(l.dbgCh) > 0 && !sent {
+		dbg := <-l.dbgCh
+		var n int
+		i := strings.Index(dbg, "Sent subscriptions snapshot")
+		if i < 0 {
+			continue
+		}
+		if _, err := fmt.Sscanf(dbg[i:], "Sent subscriptions snapshot to route in %d chunks", &n); err == nil {
+			require_True(t, n > routeSubSnapWindow)
+			sent = true
+		}
+	}
+	require_True(t, sent)
+
+	nc2 := natsConnect(t, s2.ClientURL())
+	defer nc2.Close()
+	natsPub(t, nc2, "foo.10", []byte("hello"))
+	natsPub(t, nc2, "foo.11", []byte("hello"))
+	natsNexMsg(t, subs[10], time.Second)
+	natsNexMsg(t, subs[11], time.Second)
+
+	// Removing the interest is reflected on s2.
+	for _, sub := range subs {
+		natsUnsub(t, sub)
+	}
+	natsFlush(t, nc1)
+	checkExpectedSubs(t, 0, s1, s2)
+}
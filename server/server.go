@@ -43,6 +43,7 @@ import (
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nkeys"
 	"github.com/nats-io/nuid"
+	"golang.org/x/time/rate"
 
 	"github.com/nats-io/nats-server/v2/logger"
 )
@@ -67,6 +68,7 @@ type Info struct {
 	Host              string   `json:"host"`
 	Port              int      `json:"port"`
 	Headers           bool     `json:"headers"`
+	Compression       string   `json:"compression,omitempty"`
 	AuthRequired      bool     `json:"auth_required,omitempty"`
 	TLSRequired       bool     `json:"tls_required,omitempty"`
 	TLSVerify         bool     `json:"tls_verify,omitempty"`
@@ -84,10 +86,14 @@ type Info struct {
 	WSConnectURLs     []string `json:"ws_connect_urls,omitempty"` // Contains URLs a ws client can connect to.
 	LameDuckMode      bool     `json:"ldm,omitempty"`
 
+	// Suggested reconnect backoff while overloaded or recovering from an incident.
+	ReconnectHint *ReconnectHint `json:"reconnect_hint,omitempty"`
+
 	// Route Specific
 	Import        *SubjectPermission `json:"import,omitempty"`
 	Export        *SubjectPermission `json:"export,omitempty"`
 	LNOC          bool               `json:"lnoc,omitempty"`
+	SubSnapshot   bool               `json:"sub_snapshot,omitempty"`
 	InfoOnConnect bool               `json:"info_on_connect,omitempty"` // When true the server will respond to CONNECT with an INFO
 	ConnectInfo   bool               `json:"connect_info,omitempty"`    // When true this is the server INFO response to CONNECT
 
@@ -123,6 +129,8 @@ type Server struct {
 	reloading           bool
 	listener            net.Listener
 	listenerErr         error
+	listeners           []*clientListener
+	busyPoll            busyPollState
 	gacc                *Account
 	sys                 *internal
 	js                  *jetStream
@@ -143,6 +151,7 @@ type Server struct {
 	start               time.Time
 	http                net.Listener
 	httpHandler         http.Handler
+	httpSocket          net.Listener
 	httpBasePath        string
 	profiler            net.Listener
 	httpReqStats        map[string]uint64
@@ -285,12 +294,30 @@ type Server struct {
 	gcbOutMax int64 // Taken from JetStreamMaxCatchup or defaultMaxTotalCatchupOutBytes
 	// A global chanel to kick out stalled catchup sequences.
 	gcbKick chan struct{}
+	// Limits the rate of all catchups, from JetStreamCatchupRate.
+	gcbRate *rate.Limiter
 
 	// Total outbound syncRequests
 	syncOutSem chan struct{}
 
 	// Queue to process JS API requests that come from routes (or gateways)
 	jsAPIRoutedReqs *ipQueue
+	// Same for the requests of the system account, which are processed first.
+	jsAPIRoutedSysReqs *ipQueue
+
+	// Message lifecycle interceptors registered by embedding applications.
+	interceptors *MsgInterceptors
+
+	// Permission decisions shared by connections with the same permissions.
+	permCache *permCacheRegistry
+
+	// Reconnect hints for clients, from being overloaded or set by an operator.
+	overloadHint *ReconnectHint
+	backoffHint  *ReconnectHint
+	backoffTmr   *time.Timer
+
+	// Latency and loss measured by the synthetic traffic prober.
+	prober *serverProber
 }
 
 // For tracking JS nodes.
@@ -373,6 +400,9 @@ func NewServer(opts *Options) (*Server, error) {
 	if tlsReq && !info.TLSRequired {
 		info.TLSAvailable = true
 	}
+	if !opts.NoHeaderSupport && !opts.NoClientCompression {
+		info.Compression = CompressionS2
+	}
 
 	now := time.Now().UTC()
 
@@ -392,6 +422,8 @@ func NewServer(opts *Options) (*Server, error) {
 		rateLimitLoggingCh: make(chan time.Duration, 1),
 		leafNodeEnabled:    opts.LeafNode.Port != 0 || len(opts.LeafNode.Remotes) > 0,
 		syncOutSem:         make(chan struct{}, maxConcurrentSyncRequests),
+		interceptors:       opts.MsgInterceptors,
+		permCache:          newPermCacheRegistry(),
 	}
 
 	// Fill up the maximum in flight syncRequests for this server.
@@ -672,7 +704,7 @@ func validatePinnedCerts(pinned PinnedCertSet) error {
 	for certId := range pinned {
 		entry := strings.ToLower(certId)
 		if !re.MatchString(entry) {
-			return fmt.Errorf("error parsing 'pinned_certs' key %s does not look like lower case hex-encoded sha256 of DER encoded SubjectPublicKeyInfo", entry)
+			return fmt.Errorf("error parsing 'pinned_certs' key %s does not look like lower case hex-encoded sha256 of DER encoded SubjectPublicKeyInfo or certificate", entry)
 		}
 	}
 	return nil
@@ -715,6 +747,18 @@ func validateOptions(o *Options) error {
 	if err := validateJetStreamOptions(o); err != nil {
 		return err
 	}
+	if err := validateListenerOptions(o); err != nil {
+		return err
+	}
+	if err := validateBusyPollOptions(o); err != nil {
+		return err
+	}
+	if err := validateCPUAffinityOptions(o); err != nil {
+		return err
+	}
+	if err := validateLatencyInjectionOptions(o); err != nil {
+		return err
+	}
 	// Finally check websocket options.
 	return validateWebsocketOptions(o)
 }
@@ -1259,7 +1303,8 @@ func (s *Server) setSystemAccount(acc *Account) error {
 		sid:     1,
 		servers: make(map[string]*serverUpdate),
 		replies: make(map[string]msgHandler),
-		sendq:   s.newIPQueue("System sendQ"), // of *pubMsg
+		sendq:   s.newIPQueue("System sendQ"),  // of *pubMsg
+		asendq:  s.newIPQueue("Account sendQ"), // of *pubMsg
 		resetCh: make(chan struct{}),
 		sq:      s.newSendQ(),
 		statsz:  eventsHBInterval,
@@ -1390,6 +1435,9 @@ func (s *Server) registerAccountNoLock(acc *Account) *Account {
 	}
 	acc.srv = s
 	acc.updated = time.Now().UTC()
+	if acc.talkers == nil && s.opts != nil && s.opts.SubjectTalkers > 0 {
+		acc.talkers = newSubjectTalkers(s.opts.SubjectTalkers)
+	}
 	accName := acc.Name
 	jsEnabled := len(acc.jsLimits) > 0
 	acc.mu.Unlock()
@@ -1627,6 +1675,7 @@ func (s *Server) Start() {
 	}
 	s.Noticef("  ID:       %s", s.info.ID)
 
+	defer s.notifyReady()
 	defer s.Noticef("Server is ready")
 
 	// Check for insecure configurations.
@@ -1657,6 +1706,10 @@ func (s *Server) Start() {
 		s.Noticef("Using configuration file: %s", opts.ConfigFile)
 	}
 
+	if opts.CPUAffinity.enabled() && !cpuAffinitySupported {
+		s.Warnf("CPU affinity is only supported on Linux, ignoring cpu_affinity")
+	}
+
 	hasOperators := len(opts.TrustedOperators) > 0
 	if hasOperators {
 		s.Noticef("Trusted Operators")
@@ -1759,7 +1812,10 @@ func (s *Server) Start() {
 	// Check if JetStream has been enabled. This needs to be after
 	// the system account setup above. JetStream will create its
 	// own system account if one is not present.
-	if opts.JetStream {
+	// A server in standby hosts no assets, its prior ones are only reported on.
+	if opts.Standby {
+		s.Noticef("Server is in standby, not accepting clients or hosting JetStream assets")
+	} else if opts.JetStream {
 		// Make sure someone is not trying to enable on the system account.
 		if sa := s.SystemAccount(); sa != nil && len(sa.jsLimits) > 0 {
 			s.Fatalf("Not allowed to enable JetStream on the system account")
@@ -1824,19 +1880,19 @@ func (s *Server) Start() {
 	// Start websocket server if needed. Do this before starting the routes, and
 	// leaf node because we want to resolve the gateway host:port so that this
 	// information can be sent to other routes.
-	if opts.Websocket.Port != 0 {
+	if opts.Websocket.Port != 0 && !opts.Standby {
 		s.startWebsocketServer()
 	}
 
 	// Start up listen if we want to accept leaf node connections.
-	if opts.LeafNode.Port != 0 {
+	if opts.LeafNode.Port != 0 && !opts.Standby {
 		// Will resolve or assign the advertise address for the leafnode listener.
 		// We need that in StartRouting().
 		s.startLeafNodeAcceptLoop()
 	}
 
 	// Solicit remote servers for leaf node connections.
-	if len(opts.LeafNode.Remotes) > 0 {
+	if len(opts.LeafNode.Remotes) > 0 && !opts.Standby {
 		s.solicitLeafNodeRemotes(opts.LeafNode.Remotes)
 	}
 
@@ -1854,7 +1910,7 @@ func (s *Server) Start() {
 	clientListenReady := make(chan struct{})
 
 	// MQTT
-	if opts.MQTT.Port != 0 {
+	if opts.MQTT.Port != 0 && !opts.Standby {
 		s.startMQTT()
 	}
 
@@ -1873,11 +1929,25 @@ func (s *Server) Start() {
 		s.startGoRoutine(s.logRejectedTLSConns)
 	}
 
+	if opts.Overload.CPU > 0 || opts.Overload.Memory > 0 {
+		s.startGoRoutine(s.overloadMonitor)
+	}
+
+	if opts.Prober.Interval > 0 {
+		s.mu.Lock()
+		s.prober = &serverProber{paths: make(map[string]*ProbePath)}
+		s.mu.Unlock()
+		s.startGoRoutine(s.runProber)
+	}
+
 	// We've finished starting up.
 	close(s.startupComplete)
 
 	// Wait for clients.
 	if !opts.DontListen {
+		if len(opts.Listeners) > 0 {
+			s.startClientListeners()
+		}
 		s.AcceptLoop(clientListenReady)
 	}
 }
@@ -1920,6 +1990,9 @@ func (s *Server) Shutdown() {
 	s.grMu.Unlock()
 	s.mu.Unlock()
 
+	// Have the rest of the cluster stop sending to our queue members.
+	s.drainRouteQueueWeights()
+
 	if accRes != nil {
 		accRes.Close()
 	}
@@ -1965,6 +2038,7 @@ func (s *Server) Shutdown() {
 		s.listener.Close()
 		s.listener = nil
 	}
+	doneExpected += s.closeClientListeners()
 
 	// Kick websocket server
 	if s.websocket.server != nil {
@@ -2009,6 +2083,13 @@ func (s *Server) Shutdown() {
 		s.http = nil
 	}
 
+	// Kick HTTP monitoring over the unix socket if its running
+	if s.httpSocket != nil {
+		doneExpected++
+		s.httpSocket.Close()
+		s.httpSocket = nil
+	}
+
 	// Kick Profiling if its running
 	if s.profiler != nil {
 		doneExpected++
@@ -2138,9 +2219,24 @@ func (s *Server) AcceptLoop(clr chan struct{}) {
 // within the same process. This can be used regardless of the
 // state of the DontListen option.
 func (s *Server) InProcessConn() (net.Conn, error) {
+	return s.inProcessConn(nil)
+}
+
+// InProcessConnAsUser returns an in-process connection to the server,
+// like InProcessConn, that is authenticated as the given user regardless
+// of the configured authentication. The user's account and permissions
+// are applied to the connection.
+func (s *Server) InProcessConnAsUser(user *User) (net.Conn, error) {
+	if user == nil {
+		return nil, fmt.Errorf("user required")
+	}
+	return s.inProcessConn(user)
+}
+
+func (s *Server) inProcessConn(user *User) (net.Conn, error) {
 	pl, pr := net.Pipe()
 	if !s.startGoRoutine(func() {
-		s.createClient(pl)
+		s.createClientEx(pl, user, nil)
 		s.grWG.Done()
 	}) {
 		pl.Close()
@@ -2284,6 +2380,9 @@ func (s *Server) StartMonitoring() error {
 		}
 		err = s.startMonitoring(true)
 	}
+	if err == nil && opts.HTTPSocket != _EMPTY_ {
+		err = s.startMonitoringSocket()
+	}
 	return err
 }
 
@@ -2302,6 +2401,9 @@ const (
 	JszPath          = "/jsz"
 	HealthzPath      = "/healthz"
 	IPQueuesPath     = "/ipqueuesz"
+	TalkerzPath      = "/talkerz"
+	ProbezPath       = "/probez"
+	StandbyzPath     = "/standbyz"
 )
 
 func (s *Server) basePath(p string) string {
@@ -2382,36 +2484,7 @@ func (s *Server) startMonitoring(secure bool) error {
 	rport := httpListener.Addr().(*net.TCPAddr).Port
 	s.Noticef("Starting %s monitor on %s", monitorProtocol, net.JoinHostPort(opts.HTTPHost, strconv.Itoa(rport)))
 
-	mux := http.NewServeMux()
-
-	// Root
-	mux.HandleFunc(s.basePath(RootPath), s.HandleRoot)
-	// Varz
-	mux.HandleFunc(s.basePath(VarzPath), s.HandleVarz)
-	// Connz
-	mux.HandleFunc(s.basePath(ConnzPath), s.HandleConnz)
-	// Routez
-	mux.HandleFunc(s.basePath(RoutezPath), s.HandleRoutez)
-	// Gatewayz
-	mux.HandleFunc(s.basePath(GatewayzPath), s.HandleGatewayz)
-	// Leafz
-	mux.HandleFunc(s.basePath(LeafzPath), s.HandleLeafz)
-	// Subz
-	mux.HandleFunc(s.basePath(SubszPath), s.HandleSubsz)
-	// Subz alias for backwards compatibility
-	mux.HandleFunc(s.basePath("/subscriptionsz"), s.HandleSubsz)
-	// Stacksz
-	mux.HandleFunc(s.basePath(StackszPath), s.HandleStacksz)
-	// Accountz
-	mux.HandleFunc(s.basePath(AccountzPath), s.HandleAccountz)
-	// Accstatz
-	mux.HandleFunc(s.basePath(AccountStatzPath), s.HandleAccountStatz)
-	// Jsz
-	mux.HandleFunc(s.basePath(JszPath), s.HandleJsz)
-	// Healthz
-	mux.HandleFunc(s.basePath(HealthzPath), s.HandleHealthz)
-	// IPQueuesz
-	mux.HandleFunc(s.basePath(IPQueuesPath), s.HandleIPQueuesz)
+	mux := s.newMonitoringMux()
 
 	// Do not set a WriteTimeout because it could cause cURL/browser
 	// to return empty response or unable to display page if the
@@ -2452,6 +2525,48 @@ func (s *Server) startMonitoring(secure bool) error {
 	return nil
 }
 
+// Returns the handler for all the monitoring endpoints.
+func (s *Server) newMonitoringMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Root
+	mux.HandleFunc(s.basePath(RootPath), s.HandleRoot)
+	// Varz
+	mux.HandleFunc(s.basePath(VarzPath), s.HandleVarz)
+	// Connz
+	mux.HandleFunc(s.basePath(ConnzPath), s.HandleConnz)
+	// Routez
+	mux.HandleFunc(s.basePath(RoutezPath), s.HandleRoutez)
+	// Gatewayz
+	mux.HandleFunc(s.basePath(GatewayzPath), s.HandleGatewayz)
+	// Leafz
+	mux.HandleFunc(s.basePath(LeafzPath), s.HandleLeafz)
+	// Subz
+	mux.HandleFunc(s.basePath(SubszPath), s.HandleSubsz)
+	// Subz alias for backwards compatibility
+	mux.HandleFunc(s.basePath("/subscriptionsz"), s.HandleSubsz)
+	// Stacksz
+	mux.HandleFunc(s.basePath(StackszPath), s.HandleStacksz)
+	// Accountz
+	mux.HandleFunc(s.basePath(AccountzPath), s.HandleAccountz)
+	// Accstatz
+	mux.HandleFunc(s.basePath(AccountStatzPath), s.HandleAccountStatz)
+	// Jsz
+	mux.HandleFunc(s.basePath(JszPath), s.HandleJsz)
+	// Healthz
+	mux.HandleFunc(s.basePath(HealthzPath), s.HandleHealthz)
+	// IPQueuesz
+	mux.HandleFunc(s.basePath(IPQueuesPath), s.HandleIPQueuesz)
+	// Talkerz
+	mux.HandleFunc(s.basePath(TalkerzPath), s.HandleTalkerz)
+	// Probez
+	mux.HandleFunc(s.basePath(ProbezPath), s.HandleProbez)
+	// Standbyz
+	mux.HandleFunc(s.basePath(StandbyzPath), s.HandleStandbyz)
+
+	return mux
+}
+
 // HTTPHandler returns the http.Handler object used to handle monitoring
 // endpoints. It will return nil if the server is not configured for
 // monitoring, or if the server has not been started yet (Server.Start()).
@@ -2494,6 +2609,13 @@ func (c *tlsMixConn) Read(b []byte) (int, error) {
 }
 
 func (s *Server) createClient(conn net.Conn) *client {
+	return s.createClientEx(conn, nil, nil)
+}
+
+// Creates a client, if preauth is not nil the client will be
+// authenticated as this user regardless of the configured authentication.
+// If cl is not nil, the client connected through that additional listener.
+func (s *Server) createClientEx(conn net.Conn, preauth *User, cl *clientListener) *client {
 	// Snapshot server options.
 	opts := s.getOpts()
 
@@ -2505,7 +2627,7 @@ func (s *Server) createClient(conn net.Conn) *client {
 	}
 	now := time.Now().UTC()
 
-	c := &client{srv: s, nc: conn, opts: defaultOpts, mpay: maxPay, msubs: maxSubs, start: now, last: now}
+	c := &client{srv: s, nc: conn, opts: defaultOpts, mpay: maxPay, msubs: maxSubs, start: now, last: now, preauth: preauth, lst: cl}
 
 	c.registerWithAccount(s.globalAccount())
 
@@ -2515,6 +2637,9 @@ func (s *Server) createClient(conn net.Conn) *client {
 	s.mu.Lock()
 	// Grab JSON info string
 	info = s.copyInfo()
+	if cl != nil {
+		cl.updateInfo(&info)
+	}
 	if s.nonceRequired() {
 		// Nonce handling
 		var raw [nonceLen]byte
@@ -2572,6 +2697,14 @@ func (s *Server) createClient(conn net.Conn) *client {
 		return c
 	}
 
+	// A server in standby does not accept clients.
+	if opts.Standby {
+		s.mu.Unlock()
+		c.sendErrAndErr(ErrServerInStandby.Error())
+		c.closeConnection(ServerInStandby)
+		return nil
+	}
+
 	// If there is a max connections specified, check that adding
 	// this new client would not push us over the max
 	if opts.MaxConn > 0 && len(s.clients) >= opts.MaxConn {
@@ -2579,6 +2712,15 @@ func (s *Server) createClient(conn net.Conn) *client {
 		c.maxConnExceeded()
 		return nil
 	}
+	// Same for the limit of the listener the client connected through.
+	if cl != nil {
+		if cl.opts.MaxConn > 0 && cl.nc >= cl.opts.MaxConn {
+			s.mu.Unlock()
+			c.maxConnExceeded()
+			return nil
+		}
+		cl.nc++
+	}
 	s.clients[c.cid] = c
 
 	tlsRequired := info.TLSRequired
@@ -2590,10 +2732,15 @@ func (s *Server) createClient(conn net.Conn) *client {
 	// Connection could have been closed while sending the INFO proto.
 	isClosed := c.isClosed()
 
+	tlsConfig, tlsTimeout, tlsPinnedCerts := opts.TLSConfig, opts.TLSTimeout, opts.TLSPinnedCerts
+	if cl != nil {
+		tlsConfig, tlsTimeout, tlsPinnedCerts = cl.tlsSettings()
+	}
+
 	var pre []byte
 	// If we have both TLS and non-TLS allowed we need to see which
 	// one the client wants.
-	if !isClosed && opts.TLSConfig != nil && opts.AllowNonTLS {
+	if !isClosed && cl == nil && opts.TLSConfig != nil && opts.AllowNonTLS {
 		pre = make([]byte, 4)
 		c.nc.SetReadDeadline(time.Now().Add(secondsToDuration(opts.TLSTimeout)))
 		n, _ := io.ReadFull(c.nc, pre[:])
@@ -2622,7 +2769,7 @@ func (s *Server) createClient(conn net.Conn) *client {
 			pre = nil
 		}
 		// Performs server-side TLS handshake.
-		if err := c.doTLSServerHandshake(_EMPTY_, opts.TLSConfig, opts.TLSTimeout, opts.TLSPinnedCerts); err != nil {
+		if err := c.doTLSServerHandshake(_EMPTY_, tlsConfig, tlsTimeout, tlsPinnedCerts); err != nil {
 			c.mu.Unlock()
 			return nil
 		}
@@ -2823,6 +2970,9 @@ func (s *Server) removeClient(c *client) {
 		c.mu.Unlock()
 
 		s.mu.Lock()
+		if _, ok := s.clients[cid]; ok && c.lst != nil {
+			c.lst.nc--
+		}
 		delete(s.clients, cid)
 		if updateProtoInfoCount {
 			s.cproto--
@@ -2957,6 +3107,16 @@ func (s *Server) MonitorAddr() *net.TCPAddr {
 	return s.http.Addr().(*net.TCPAddr)
 }
 
+// MonitorSocketAddr returns the net.Addr object for the monitoring unix socket.
+func (s *Server) MonitorSocketAddr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.httpSocket == nil {
+		return nil
+	}
+	return s.httpSocket.Addr()
+}
+
 // ClusterAddr returns the net.Addr object for the route listener.
 func (s *Server) ClusterAddr() *net.TCPAddr {
 	s.mu.RLock()
@@ -3431,6 +3591,7 @@ func (s *Server) lameDuckMode() {
 	expected := 1
 	s.listener.Close()
 	s.listener = nil
+	expected += s.closeClientListeners()
 	if s.websocket.server != nil {
 		expected++
 		s.websocket.server.Close()
@@ -3448,6 +3609,11 @@ func (s *Server) lameDuckMode() {
 	}
 	s.mu.Unlock()
 
+	s.notifyLameDuckEntered()
+
+	// Have the rest of the cluster stop sending to our queue members.
+	s.drainRouteQueueWeights()
+
 	// If we are running any raftNodes transfer leaders.
 	if hadTransfers := s.transferRaftLeaders(); hadTransfers {
 		// They will transfer leadership quickly, but wait here for a second.
@@ -28,6 +28,7 @@ import (
 	"reflect"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -959,6 +960,87 @@ func TestLameDuckMode(t *testing.T) {
 	})
 }
 
+func TestLameDuckModeQueueWeights(t *testing.T) {
+	optsA := DefaultOptions()
+	optsA.Cluster.Host = "127.0.0.1"
+	optsA.Cluster.Port = -1
+	// Keep our clients connected while we check.
+	testSetLDMGracePeriod(optsA, 5*time.Second)
+	optsA.LameDuckDuration = 50 * time.Millisecond
+	srvA := RunServer(optsA)
+	defer srvA.Shutdown()
+
+	optsB := DefaultOptions()
+	optsB.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", srvA.ClusterAddr().Port))
+	srvB := RunServer(optsB)
+	defer srvB.Shutdown()
+
+	checkClusterFormed(t, srvA, srvB)
+
+	ncA := natsConnect(t, srvA.ClientURL())
+	defer ncA.Close()
+	ncB := natsConnect(t, srvB.ClientURL())
+	defer ncB.Close()
+
+	var gotA, gotB int32
+	natsQueueSub(t, ncA, "foo", "bar", func(_ *nats.Msg) { atomic.AddInt32(&gotA, 1) })
+	natsQueueSub(t, ncA, "foo", "bar", func(_ *nats.Msg) { atomic.AddInt32(&gotA, 1) })
+	natsFlush(t, ncA)
+	// Only server A has members of this group.
+	natsQueueSub(t, ncA, "baz", "bar", func(_ *nats.Msg) { atomic.AddInt32(&gotA, 1) })
+	natsFlush(t, ncA)
+	natsQueueSub(t, ncB, "foo", "bar", func(_ *nats.Msg) { atomic.AddInt32(&gotB, 1) })
+	natsFlush(t, ncB)
+	checkSubInterest(t, srvB, globalAccountName, "baz", time.Second)
+
+	remoteWeight := func(subj string) int32 {
+		r := srvB.globalAccount().sl.Match(subj)
+		for _, qsubs := range r.qsubs {
+			for _, sub := range qsubs {
+				if isRemoteQSub(sub) {
+					return atomic.LoadInt32(&sub.qw)
+				}
+			}
+		}
+		return -1
+	}
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if w := remoteWeight("foo"); w != 2 {
+			return fmt.Errorf("Expected weight of 2, got %v", w)
+		}
+		return nil
+	})
+
+	go srvA.lameDuckMode()
+	// A's members are no longer selected, unless there is no one else.
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if w := remoteWeight("foo"); w != -1 {
+			return fmt.Errorf("Expected no remote queue sub, got weight %v", w)
+		}
+		if w := remoteWeight("baz"); w != 0 {
+			return fmt.Errorf("Expected weight of 0, got %v", w)
+		}
+		return nil
+	})
+
+	// Everything published on B goes to B's member while A is draining.
+	for i := 0; i < 50; i++ {
+		natsPub(t, ncB, "foo", []byte("hello"))
+	}
+	// Unless there is no one else.
+	natsPub(t, ncB, "baz", []byte("hello"))
+	natsFlush(t, ncB)
+	checkFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if n := atomic.LoadInt32(&gotB); n != 50 {
+			return fmt.Errorf("Expected 50 messages on B, got %v", n)
+		}
+		if n := atomic.LoadInt32(&gotA); n != 1 {
+			return fmt.Errorf("Expected 1 message on A, got %v", n)
+		}
+		return nil
+	})
+}
+
 func TestLameDuckModeInfo(t *testing.T) {
 	optsA := testWSOptions()
 	optsA.Cluster.Name = "abc"
@@ -2068,3 +2150,476 @@ func TestServerRateLimitLogging(t *testing.T) {
 
 	checkLog(c1, c2)
 }
+
+func TestServerReconnectHint(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		overload {
+			cpu: 99.5
+			memory: 64GB
+			reconnect_wait: "3s"
+		}
+		accounts {
+			A { users: [{user: a, password: a}] }
+			$SYS { users: [{user: admin, password: s3cr3t!}] }
+		}
+	`))
+	s, o := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	require_True(t, o.Overload.CPU == 99.5)
+	require_True(t, o.Overload.Memory == 64*1024*1024*1024)
+	require_True(t, o.Overload.ReconnectWait == 3*time.Second)
+
+	connect := func() (net.Conn, *bufio.Reader) {
+		t.Helper()
+		c, err := net.Dial("tcp", net.JoinHostPort(o.Host, strconv.Itoa(o.Port)))
+		require_NoError(t, err)
+		return c, bufio.NewReaderSize(c, maxBufSize)
+	}
+	getInfo := func(c net.Conn, cr *bufio.Reader) *Info {
+		t.Helper()
+		for {
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			l, err := cr.ReadString('\n')
+			require_NoError(t, err)
+			if strings.HasPrefix(l, "INFO ") {
+				var info Info
+				require_NoError(t, json.Unmarshal([]byte(l[5:]), &info))
+				return &info
+			}
+		}
+	}
+
+	c, cr := connect()
+	defer c.Close()
+	require_True(t, getInfo(c, cr).ReconnectHint == nil)
+	c.Write([]byte("CONNECT {\"protocol\":1,\"verbose\":false,\"user\":\"a\",\"pass\":\"a\"}\r\nPING\r\n"))
+	l, err := cr.ReadString('\n')
+	require_NoError(t, err)
+	require_True(t, l == "PONG\r\n")
+
+	ncSys := natsConnect(t, s.ClientURL(), nats.UserInfo("admin", "s3cr3t!"))
+	defer ncSys.Close()
+	backoff := func(opts *BackoffOptions) *Backoffz {
+		t.Helper()
+		req, err := json.Marshal(opts)
+		require_NoError(t, err)
+		resp, err := ncSys.Request(fmt.Sprintf(serverPingReqSubj, "BACKOFF"), req, time.Second)
+		require_NoError(t, err)
+		var sresp struct {
+			Data  *Backoffz `json:"data"`
+			Error *ApiError `json:"error"`
+		}
+		require_NoError(t, json.Unmarshal(resp.Data, &sresp))
+		require_True(t, sresp.Error == nil)
+		return sresp.Data
+	}
+
+	// Connected clients are told right away.
+	bz := backoff(&BackoffOptions{Wait: 10 * time.Second, Jitter: time.Second, Reason: "incident"})
+	require_True(t, bz.Hint != nil)
+	require_True(t, bz.Hint.Wait == 10*time.Second)
+	hint := getInfo(c, cr).ReconnectHint
+	require_True(t, hint != nil)
+	require_True(t, *hint == ReconnectHint{Wait: 10 * time.Second, Jitter: time.Second, Reason: "incident"})
+
+	// New clients get it in their first INFO.
+	c2, cr2 := connect()
+	defer c2.Close()
+	hint = getInfo(c2, cr2).ReconnectHint
+	require_True(t, hint != nil)
+	require_True(t, hint.Wait == 10*time.Second)
+
+	// The operator hint wins over being overloaded and clearing it falls back.
+	s.mu.Lock()
+	s.overloadHint = &ReconnectHint{Wait: 3 * time.Second, Reason: "cpu"}
+	s.updateReconnectHint()
+	s.mu.Unlock()
+	bz = backoff(&BackoffOptions{})
+	require_True(t, bz.Hint != nil)
+	require_True(t, bz.Hint.Reason == "cpu")
+	hint = getInfo(c, cr).ReconnectHint
+	require_True(t, hint != nil)
+	require_True(t, hint.Reason == "cpu")
+
+	s.mu.Lock()
+	s.overloadHint = nil
+	s.updateReconnectHint()
+	s.mu.Unlock()
+	require_True(t, getInfo(c, cr).ReconnectHint == nil)
+
+	// A hint with a duration clears itself.
+	backoff(&BackoffOptions{Wait: time.Second, Duration: 100 * time.Millisecond})
+	hint = getInfo(c, cr).ReconnectHint
+	require_True(t, hint != nil)
+	require_True(t, hint.Wait == time.Second)
+	require_True(t, getInfo(c, cr).ReconnectHint == nil)
+}
+
+func TestServerClientListeners(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		listeners: [
+			{
+				name: internal
+				listen: 127.0.0.1:-1
+				allowed_accounts: [A]
+				max_connections: 1
+			}
+			{
+				name: external
+				listen: 127.0.0.1:-1
+				tls {
+					cert_file: "../test/configs/certs/server-cert.pem"
+					key_file:  "../test/configs/certs/server-key.pem"
+					ca_file:   "../test/configs/certs/ca.pem"
+					verify:    true
+				}
+			}
+		]
+		accounts {
+			A { users: [{user: a, password: a}] }
+			B { users: [{user: b, password: b}] }
+		}
+	`))
+	s, o := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	require_True(t, len(o.Listeners) == 2)
+	internal := s.ListenerAddr("internal")
+	require_True(t, internal != nil)
+	require_True(t, s.ListenerAddr("external") != nil)
+	require_True(t, s.ListenerAddr("unknown") == nil)
+
+	getInfo := func(addr net.Addr) *Info {
+		t.Helper()
+		c, err := net.Dial("tcp", addr.String())
+		require_NoError(t, err)
+		defer c.Close()
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		l, err := bufio.NewReader(c).ReadString('\n')
+		require_NoError(t, err)
+		var info Info
+		require_NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(l, "INFO ")), &info))
+		return &info
+	}
+	// The external listener requires mTLS, the main and internal ones don't.
+	info := getInfo(s.ListenerAddr("external"))
+	require_True(t, info.TLSRequired)
+	require_True(t, info.TLSVerify)
+	require_False(t, getInfo(internal).TLSRequired)
+	require_False(t, getInfo(s.Addr()).TLSRequired)
+
+	url := fmt.Sprintf("nats://%s", internal)
+
+	// Only users of account A can connect through the internal listener.
+	_, err := nats.Connect(url, nats.UserInfo("b", "b"))
+	require_Error(t, err)
+	ncb := natsConnect(t, s.ClientURL(), nats.UserInfo("b", "b"))
+	defer ncb.Close()
+
+	nca := natsConnect(t, url, nats.UserInfo("a", "a"))
+	defer nca.Close()
+	connz, err := s.Connz(&ConnzOptions{Account: "A"})
+	require_NoError(t, err)
+	require_True(t, len(connz.Conns) == 1)
+	require_Equal(t, connz.Conns[0].Listener, "internal")
+
+	// The internal listener only accepts one connection.
+	_, err = nats.Connect(url, nats.UserInfo("a", "a"))
+	require_Error(t, err)
+	nca2 := natsConnect(t, s.ClientURL(), nats.UserInfo("a", "a"))
+	defer nca2.Close()
+
+	// Which is available again once the first one is gone.
+	nca.Close()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		nc, err := nats.Connect(url, nats.UserInfo("a", "a"))
+		if err != nil {
+			return err
+		}
+		nc.Close()
+		return nil
+	})
+}
+
+func TestServerClientListenersValidation(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		listeners []*ListenerOpts
+		err       string
+	}{
+		{"no name", []*ListenerOpts{{Port: -1}}, "name is required"},
+		{"no port", []*ListenerOpts{{Name: "a"}}, "port is required"},
+		{"duplicate", []*ListenerOpts{{Name: "a", Port: -1}, {Name: "a", Port: -1}}, "duplicate name"},
+		{"same port", []*ListenerOpts{{Name: "a", Port: 4222}}, "already used"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			o := DefaultOptions()
+			o.Port = 4222
+			o.Listeners = test.listeners
+			err := validateOptions(o)
+			require_Error(t, err)
+			require_Contains(t, err.Error(), test.err)
+		})
+	}
+}
+
+func TestServerBusyPoll(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		busy_poll {
+			accounts: [MD]
+			users: [fast]
+			max_connections: 1
+			spin: "1ms"
+			cpus: [ 0 ]
+		}
+		accounts {
+			MD { users: [{user: md, password: md}] }
+			B { users: [{user: fast, password: fast}, {user: b, password: b}] }
+		}
+	`))
+	s, o := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	require_True(t, o.BusyPoll.MaxConn == 1)
+	require_True(t, o.BusyPoll.Spin == time.Millisecond)
+	require_True(t, len(o.BusyPoll.CPUs) == 1)
+
+	busyPoll := func(acc string) bool {
+		t.Helper()
+		connz, err := s.Connz(&ConnzOptions{Account: acc})
+		require_NoError(t, err)
+		require_True(t, len(connz.Conns) == 1)
+		return connz.Conns[0].BusyPoll
+	}
+
+	// Users not selected are not busy polling.
+	ncb := natsConnect(t, s.ClientURL(), nats.UserInfo("b", "b"))
+	defer ncb.Close()
+	require_False(t, busyPoll("B"))
+	ncb.Close()
+
+	nc := natsConnect(t, s.ClientURL(), nats.UserInfo("fast", "fast"))
+	defer nc.Close()
+	require_True(t, busyPoll("B"))
+	require_True(t, s.numBusyPoll() == 1)
+
+	// Messages flow as usual.
+	sub := natsSubSync(t, nc, "md.>")
+	natsFlush(t, nc)
+	for i := 0; i < 100; i++ {
+		natsPub(t, nc, "md.quote", []byte("1.0"))
+	}
+	for i := 0; i < 100; i++ {
+		natsNexMsg(t, sub, time.Second)
+	}
+
+	// The limit is reached, so the account connection is not busy polling.
+	ncmd := natsConnect(t, s.ClientURL(), nats.UserInfo("md", "md"))
+	defer ncmd.Close()
+	require_False(t, busyPoll("MD"))
+	ncmd.Close()
+
+	// Once the first is gone, the slot is available again.
+	nc.Close()
+	checkFor(t, time.Second, 15*time.Millisecond, func() error {
+		if n := s.numBusyPoll(); n != 0 {
+			return fmt.Errorf("still %d busy polling", n)
+		}
+		return nil
+	})
+	ncmd = natsConnect(t, s.ClientURL(), nats.UserInfo("md", "md"))
+	defer ncmd.Close()
+	require_True(t, busyPoll("MD"))
+}
+
+func TestServerBusyPollValidation(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		bp   BusyPollOpts
+		err  string
+	}{
+		{"max conn", BusyPollOpts{Users: []string{"a"}, MaxConn: -1}, "max connections"},
+		{"spin", BusyPollOpts{Users: []string{"a"}, Spin: -1}, "spin"},
+		{"cpus", BusyPollOpts{Users: []string{"a"}, CPUs: []int{runtime.NumCPU()}}, "cpus"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			o := DefaultOptions()
+			o.BusyPoll = test.bp
+			err := validateOptions(o)
+			require_Error(t, err)
+			require_Contains(t, err.Error(), test.err)
+		})
+	}
+}
+
+func TestServerLatencyInjection(t *testing.T) {
+	conf := createConfFile(t, []byte(`
+		listen: 127.0.0.1:-1
+		cluster { name: "abc", listen: 127.0.0.1:-1 }
+		latency_injection {
+			routes { latency: "100ms", jitter: "10ms", loss: 0.01 }
+			leafnodes { latency: "20ms" }
+		}
+	`))
+	o, err := ProcessConfigFile(conf)
+	require_NoError(t, err)
+	require_True(t, o.LatencyInjection.Routes.Latency == 100*time.Millisecond)
+	require_True(t, o.LatencyInjection.Routes.Jitter == 10*time.Millisecond)
+	require_True(t, o.LatencyInjection.Routes.Loss == 0.01)
+	require_True(t, o.LatencyInjection.LeafNodes.Latency == 20*time.Millisecond)
+
+	// Refused unless built for staging.
+	allowed := latencyInjectionAllowed
+	defer func() { latencyInjectionAllowed = allowed }()
+	latencyInjectionAllowed = false
+	err = validateOptions(o)
+	require_Error(t, err)
+	require_Contains(t, err.Error(), "staging")
+
+	latencyInjectionAllowed = true
+	o.LatencyInjection.Routes.Loss = 1
+	require_Error(t, validateOptions(o))
+
+	newOpts := func() *Options {
+		o := DefaultOptions()
+		o.Cluster.Name = "abc"
+		o.Cluster.Host = "127.0.0.1"
+		o.Cluster.Port = -1
+		o.LatencyInjection.Routes.Latency = 100 * time.Millisecond
+		return o
+	}
+	s1 := RunServer(newOpts())
+	defer s1.Shutdown()
+	o2 := newOpts()
+	o2.Routes = RoutesFromStr(fmt.Sprintf("nats://127.0.0.1:%d", s1.ClusterAddr().Port))
+	s2 := RunServer(o2)
+	defer s2.Shutdown()
+	checkClusterFormed(t, s1, s2)
+
+	nc1 := natsConnect(t, s1.ClientURL())
+	defer nc1.Close()
+	natsSub(t, nc1, "rtt", func(m *nats.Msg) { m.Respond(nil) })
+	natsFlush(t, nc1)
+	checkSubInterest(t, s2, globalAccountName, "rtt", 2*time.Second)
+
+	nc2 := natsConnect(t, s2.ClientURL())
+	defer nc2.Close()
+	// Both servers delay what they send over the route.
+	start := time.Now()
+	_, err = nc2.Request("rtt", nil, 2*time.Second)
+	require_NoError(t, err)
+	require_True(t, time.Since(start) >= 200*time.Millisecond)
+
+	// Clients are not affected.
+	start = time.Now()
+	natsFlush(t, nc1)
+	require_True(t, time.Since(start) < 100*time.Millisecond)
+}
+
+func TestServerCPUAffinity(t *testing.T) {
+	conf := createConfFile(t, []byte(fmt.Sprintf(`
+		listen: 127.0.0.1:-1
+		jetstream { store_dir: %q }
+		cpu_affinity {
+			client_io: "0"
+			filestore: [ 0 ]
+			raft: "0-0"
+		}
+	`, t.TempDir())))
+	s, o := RunServerWithConfig(conf)
+	defer s.Shutdown()
+
+	require_True(t, len(o.CPUAffinity.ClientIO) == 1)
+	require_True(t, len(o.CPUAffinity.Filestore) == 1)
+	require_True(t, len(o.CPUAffinity.Raft) == 1)
+
+	// Messages flow as usual through pinned read and write loops and file stores.
+	nc, js := jsClientConnect(t, s)
+	defer nc.Close()
+	_, err := js.AddStream(&nats.StreamConfig{Name: "TEST", Subjects: []string{"foo"}})
+	require_NoError(t, err)
+	sub := natsSubSync(t, nc, "foo")
+	natsFlush(t, nc)
+	for i := 0; i < 100; i++ {
+		_, err := js.Publish("foo", []byte("ok"))
+		require_NoError(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		natsNexMsg(t, sub, time.Second)
+	}
+	si, err := js.StreamInfo("TEST")
+	require_NoError(t, err)
+	require_True(t, si.State.Msgs == 100)
+}
+
+func TestServerCPUAffinityBoundedThreads(t *testing.T) {
+	if !cpuAffinitySupported {
+		t.Skip("cpu affinity not supported")
+	}
+	// Only so many goroutines are pinned, the others run unpinned.
+	const n = maxPinnedPerCPU + 4
+	var wg sync.WaitGroup
+	pinned, done := make(chan struct{}, n), make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := lockToCPUs("test", []int{0})
+			require_NoError(t, err)
+			defer release()
+			pinned <- struct{}{}
+			<-done
+		}()
+	}
+	for i := 0; i < n; i++ {
+		<-pinned
+	}
+	cp := getCPUPool("test", []int{0})
+	require_True(t, len(cp.slots) == maxPinnedPerCPU)
+
+	// Slots are freed when the goroutines exit.
+	close(done)
+	wg.Wait()
+	require_True(t, len(cp.slots) == 0)
+}
+
+func TestServerCPUAffinityParseAndValidation(t *testing.T) {
+	for _, test := range []struct {
+		list string
+		cpus []int
+		err  bool
+	}{
+		{"0", []int{0}, false},
+		{"0-3, 8,10-11", []int{0, 1, 2, 3, 8, 10, 11}, false},
+		{"3,1-2,2", []int{1, 2, 3}, false},
+		{"", []int{}, false},
+		{"a", nil, true},
+		{"3-1", nil, true},
+		{"-1", nil, true},
+	} {
+		cpus, err := parseCPUList(test.list)
+		if test.err {
+			require_Error(t, err)
+			continue
+		}
+		require_NoError(t, err)
+		require_True(t, reflect.DeepEqual(cpus, test.cpus))
+	}
+
+	o := DefaultOptions()
+	o.CPUAffinity.Raft = []int{runtime.NumCPU()}
+	err := validateOptions(o)
+	require_Error(t, err)
+	require_Contains(t, err.Error(), "cpu affinity")
+
+	conf := createConfFile(t, []byte(`cpu_affinity { raft: [ "a" ] }`))
+	_, err = ProcessConfigFile(conf)
+	require_Error(t, err)
+	conf = createConfFile(t, []byte(`cpu_affinity { io: "0" }`))
+	_, err = ProcessConfigFile(conf)
+	require_Error(t, err)
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Maximum number of responses we will cache for a service export.
+const svcCacheMaxEntries = 10_000
+
+// A cached response for a service export.
+type svcCacheEntry struct {
+	msg []byte
+	hdr int
+	exp int64
+}
+
+// Returns the cache key for a request on the mapped subject with this body.
+func svcCacheKey(subject string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(subject))
+	h.Write([]byte{0})
+	h.Write(body)
+	return string(h.Sum(nil))
+}
+
+// Returns the subject a request on subject is mapped to by this service import.
+func (si *serviceImport) mapRequestSubject(subject string) string {
+	if si.tr != nil {
+		// FIXME(dlc) - This could be slow, may want to look at adding cache to bare transforms?
+		to, _ := si.tr.transformSubject(subject)
+		return to
+	} else if si.usePub {
+		return subject
+	}
+	return si.to
+}
+
+// Returns true if responses for this export are cached.
+func (se *serviceExport) cachingResponses() bool {
+	if se == nil || se.acc == nil {
+		return false
+	}
+	se.acc.mu.RLock()
+	defer se.acc.mu.RUnlock()
+	return se.cacheTTL > 0
+}
+
+// Returns a cached response for this key if present and not expired.
+func (se *serviceExport) cachedResponse(key string) *svcCacheEntry {
+	acc := se.acc
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	e := se.cache[key]
+	if e == nil {
+		return nil
+	}
+	if e.exp <= time.Now().UnixNano() {
+		delete(se.cache, key)
+		return nil
+	}
+	return e
+}
+
+// Caches a response for the request with this key. Responses carrying a
+// status, like no responders, are not cached.
+func (se *serviceExport) cacheResponse(key string, msg []byte, hdr int) {
+	if hdr > 0 && !bytes.HasPrefix(msg, []byte(hdrLine)) {
+		return
+	}
+	acc := se.acc
+	acc.mu.Lock()
+	defer acc.mu.Unlock()
+	if se.cacheTTL <= 0 {
+		return
+	}
+	now := time.Now().UnixNano()
+	if se.cache == nil {
+		se.cache = make(map[string]*svcCacheEntry)
+	} else if len(se.cache) >= svcCacheMaxEntries {
+		for k, e := range se.cache {
+			if e.exp <= now {
+				delete(se.cache, k)
+			}
+		}
+		if len(se.cache) >= svcCacheMaxEntries {
+			return
+		}
+	}
+	se.cache[key] = &svcCacheEntry{copyBytes(msg), hdr, now + int64(se.cacheTTL)}
+}
+
+// ServiceExportResponseCache returns how long responses for the export are cached.
+func (a *Account) ServiceExportResponseCache(export string) (time.Duration, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	se := a.getServiceExport(export)
+	if se == nil {
+		return 0, fmt.Errorf("no export defined for %q", export)
+	}
+	return se.cacheTTL, nil
+}
+
+// SetServiceExportResponseCache will have the server cache responses of the service
+// export for ttl, keyed by the request subject and payload. Requests for a cached
+// response are answered by the server without reaching the service. This is only
+// meant for idempotent services with singleton responses. Zero disables the cache.
+func (a *Account) SetServiceExportResponseCache(export string, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("response cache ttl can not be negative")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.isClaimAccount() {
+		return fmt.Errorf("claim based accounts can not be updated directly")
+	}
+	se := a.getServiceExport(export)
+	if se == nil {
+		return fmt.Errorf("no export defined for %q", export)
+	}
+	if ttl > 0 && se.respType != Singleton {
+		return fmt.Errorf("response cache requires singleton responses")
+	}
+	se.cacheTTL = ttl
+	if ttl == 0 {
+		se.cache = nil
+	}
+	return nil
+}
+
+// Will deliver a cached response to the reply subject in the requestor's account.
+func (c *client) deliverCachedResponse(acc *Account, reply []byte, e *svcCacheEntry) bool {
+	// Save off our pubArg and route targets, they are reset below.
+	pacopy, orts := c.pa, c.in.rts
+	var lrts [routeTargetInit]routeTarget
+	c.in.rts = lrts[:0]
+
+	c.pa.subject, c.pa.reply, c.pa.deliver, c.pa.mapped = reply, nil, nil, nil
+	c.pa.size = len(e.msg) - LEN_CR_LF
+	c.pa.szb = []byte(strconv.Itoa(c.pa.size))
+	c.pa.hdr, c.pa.hdb = -1, nil
+	if e.hdr > 0 {
+		c.pa.hdr, c.pa.hdb = e.hdr, []byte(strconv.Itoa(e.hdr))
+	}
+
+	var didDeliver bool
+	rr := acc.sl.Match(string(reply))
+	if c.srv.gateway.enabled {
+		var queues [][]byte
+		didDeliver, queues = c.processMsgResults(acc, rr, e.msg, nil, reply, nil, pmrCollectQueueNames)
+		didDeliver = c.sendMsgToGateways(acc, e.msg, reply, nil, queues) || didDeliver
+	} else {
+		didDeliver, _ = c.processMsgResults(acc, rr, e.msg, nil, reply, nil, pmrNoFlag)
+	}
+
+	c.in.rts, c.pa = orts, pacopy
+	return didDeliver
+}
@@ -0,0 +1,373 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// SnapshotEncryption describes how a stream snapshot is sealed, or how a
+// sealed snapshot is to be opened on restore. Snapshots are already S2
+// compressed, the compressed stream is what gets encrypted.
+type SnapshotEncryption struct {
+	// Name of a backup key configured on the server under jetstream { backup_keys }.
+	Key string `json:"key,omitempty"`
+	// Base64 encoded curve25519 public key the data key is sealed to.
+	PublicKey string `json:"public_key,omitempty"`
+	// Base64 encoded curve25519 private key to open the data key on restore.
+	// Not accepted in clustered mode, use a configured key instead.
+	PrivateKey string `json:"private_key,omitempty"`
+	// Base64 encoded data key sealed to the public key.
+	// Returned with the snapshot response and required on restore.
+	SealedKey string `json:"sealed_key,omitempty"`
+	// Cipher used for the snapshot data.
+	Cipher string `json:"cipher,omitempty"`
+}
+
+// SnapshotManifest is sent with the final (empty) chunk of a snapshot so
+// the backup can be verified after it has been moved around.
+type SnapshotManifest struct {
+	// Number of chunks, not counting the final one.
+	Chunks int `json:"chunks"`
+	// Total number of bytes in all chunks.
+	Bytes uint64 `json:"bytes"`
+	// Digest of all chunks in order, as "SHA-256=<base64>".
+	Digest string `json:"digest"`
+	// Whether the chunks are encrypted.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// Header carrying the JSON encoded manifest on the final snapshot chunk.
+const JSSnapshotManifest = "Nats-Snapshot-Manifest"
+
+const (
+	snapshotCipherChaCha = "chacha20-poly1305"
+	// Size of plaintext sealed in each segment.
+	snapshotSealSegment = 64 * 1024
+)
+
+var (
+	errSnapshotEncryptionKey   = errors.New("one of key or public key is required")
+	errSnapshotEncryptionBoth  = errors.New("only one of key, public key or private key can be set")
+	errSnapshotSealedKey       = errors.New("sealed key is required")
+	errSnapshotCipher          = errors.New("unsupported cipher")
+	errSnapshotTruncated       = errors.New("encrypted snapshot is truncated")
+	errSnapshotTrailingData    = errors.New("encrypted snapshot has trailing data")
+	errSnapshotSegmentTooLarge = errors.New("encrypted snapshot segment too large")
+)
+
+// Decodes a base64 encoded curve25519 key.
+func decodeBackupKey(k string) (*[32]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(k)
+	if err != nil || len(b) != 32 {
+		return nil, errors.New("key must be a base64 encoded 32 byte curve25519 key")
+	}
+	var key [32]byte
+	copy(key[:], b)
+	return &key, nil
+}
+
+// Validates configured backup keys.
+func validateBackupKeys(o *Options) error {
+	for name, k := range o.JetStreamBackupKeys {
+		if _, err := decodeBackupKey(k); err != nil {
+			return fmt.Errorf("jetstream backup key %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Returns the private and public key for a configured backup key.
+func (s *Server) backupKey(name string) (priv, pub *[32]byte, err error) {
+	k, ok := s.getOpts().JetStreamBackupKeys[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("backup key %q not found", name)
+	}
+	if priv, err = decodeBackupKey(k); err != nil {
+		return nil, nil, err
+	}
+	var p [32]byte
+	curve25519.ScalarBaseMult(&p, priv)
+	return priv, &p, nil
+}
+
+// Generates a data key for the snapshot and seals it to the requested public key.
+// Returns the encryption details for the response along with the cipher to use.
+func (s *Server) newSnapshotSealer(se *SnapshotEncryption) (*SnapshotEncryption, cipher.AEAD, error) {
+	if se.PrivateKey != _EMPTY_ || se.SealedKey != _EMPTY_ {
+		return nil, nil, errors.New("private and sealed keys can not be set on snapshot")
+	}
+	if se.Cipher != _EMPTY_ && se.Cipher != snapshotCipherChaCha {
+		return nil, nil, errSnapshotCipher
+	}
+	var pub *[32]byte
+	var err error
+	switch {
+	case se.Key != _EMPTY_ && se.PublicKey != _EMPTY_:
+		return nil, nil, errSnapshotEncryptionBoth
+	case se.Key != _EMPTY_:
+		_, pub, err = s.backupKey(se.Key)
+	case se.PublicKey != _EMPTY_:
+		pub, err = decodeBackupKey(se.PublicKey)
+	default:
+		err = errSnapshotEncryptionKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	dk := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(rand.Reader, dk); err != nil {
+		return nil, nil, err
+	}
+	sealed, err := box.SealAnonymous(nil, dk, pub, rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := chacha20poly1305.New(dk)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &SnapshotEncryption{
+		Key:       se.Key,
+		PublicKey: base64.StdEncoding.EncodeToString(pub[:]),
+		SealedKey: base64.StdEncoding.EncodeToString(sealed),
+		Cipher:    snapshotCipherChaCha,
+	}, aead, nil
+}
+
+// Checks restore encryption details, allowPriv is false when the
+// details would be replicated through the meta layer.
+func checkSnapshotOpener(se *SnapshotEncryption, allowPriv bool) error {
+	switch {
+	case se.SealedKey == _EMPTY_:
+		return errSnapshotSealedKey
+	case se.Cipher != _EMPTY_ && se.Cipher != snapshotCipherChaCha:
+		return errSnapshotCipher
+	case se.Key != _EMPTY_ && se.PrivateKey != _EMPTY_:
+		return errSnapshotEncryptionBoth
+	case se.Key == _EMPTY_ && se.PrivateKey == _EMPTY_:
+		return errors.New("one of key or private key is required")
+	case se.PrivateKey != _EMPTY_ && !allowPriv:
+		return errors.New("private key not allowed in clustered mode, use a configured key")
+	}
+	return nil
+}
+
+// Opens the sealed data key and returns the cipher for the snapshot data.
+func (s *Server) openSnapshotKey(se *SnapshotEncryption) (cipher.AEAD, error) {
+	if err := checkSnapshotOpener(se, true); err != nil {
+		return nil, err
+	}
+	var priv, pub *[32]byte
+	var err error
+	if se.Key != _EMPTY_ {
+		priv, pub, err = s.backupKey(se.Key)
+	} else if priv, err = decodeBackupKey(se.PrivateKey); err == nil {
+		pub = new([32]byte)
+		curve25519.ScalarBaseMult(pub, priv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(se.SealedKey)
+	if err != nil {
+		return nil, errors.New("sealed key is not base64 encoded")
+	}
+	dk, ok := box.OpenAnonymous(nil, sealed, pub, priv)
+	if !ok {
+		return nil, errors.New("unable to open sealed key")
+	}
+	return chacha20poly1305.New(dk)
+}
+
+// Returns the nonce for the given segment.
+func snapshotSegmentNonce(nonce []byte, seg uint64) []byte {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seg)
+	return nonce
+}
+
+// Additional data marks the last segment so truncation is detected.
+var (
+	snapshotSegmentAD = []byte{0}
+	snapshotFinalAD   = []byte{1}
+)
+
+// snapshotSealer encrypts a snapshot as a sequence of length prefixed
+// segments, each sealed with the data key and its sequence as nonce.
+type snapshotSealer struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	seg   uint64
+	buf   []byte
+	nonce []byte
+	out   []byte
+	done  bool
+}
+
+func newSnapshotSealer(r io.Reader, aead cipher.AEAD) *snapshotSealer {
+	return &snapshotSealer{
+		r:     r,
+		aead:  aead,
+		buf:   make([]byte, snapshotSealSegment),
+		nonce: make([]byte, aead.NonceSize()),
+	}
+}
+
+func (ss *snapshotSealer) Read(p []byte) (int, error) {
+	for len(ss.out) == 0 {
+		if ss.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(ss.r, ss.buf)
+		ad := snapshotSegmentAD
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			ad, ss.done = snapshotFinalAD, true
+		} else if err != nil {
+			return 0, err
+		}
+		out := make([]byte, 4, 4+n+ss.aead.Overhead())
+		out = ss.aead.Seal(out, snapshotSegmentNonce(ss.nonce, ss.seg), ss.buf[:n], ad)
+		binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+		ss.out = out
+		ss.seg++
+	}
+	n := copy(p, ss.out)
+	ss.out = ss.out[n:]
+	return n, nil
+}
+
+// snapshotOpener decrypts a snapshot sealed by snapshotSealer.
+type snapshotOpener struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	seg   uint64
+	buf   []byte
+	plain []byte
+	nonce []byte
+	out   []byte
+	done  bool
+}
+
+func newSnapshotOpener(r io.Reader, aead cipher.AEAD) *snapshotOpener {
+	return &snapshotOpener{r: r, aead: aead, nonce: make([]byte, aead.NonceSize())}
+}
+
+// Opens the next segment into out.
+func (so *snapshotOpener) next() error {
+	var lb [4]byte
+	if _, err := io.ReadFull(so.r, lb[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errSnapshotTruncated
+		}
+		return err
+	}
+	sz := int(binary.BigEndian.Uint32(lb[:]))
+	if sz > snapshotSealSegment+so.aead.Overhead() {
+		return errSnapshotSegmentTooLarge
+	}
+	if cap(so.buf) < sz {
+		so.buf = make([]byte, sz)
+	}
+	so.buf = so.buf[:sz]
+	if _, err := io.ReadFull(so.r, so.buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errSnapshotTruncated
+		}
+		return err
+	}
+	nonce := snapshotSegmentNonce(so.nonce, so.seg)
+	// Open into a separate buffer since a failed open clears its output.
+	out, err := so.aead.Open(so.plain[:0], nonce, so.buf, snapshotSegmentAD)
+	if err != nil {
+		if out, err = so.aead.Open(so.plain[:0], nonce, so.buf, snapshotFinalAD); err != nil {
+			return errors.New("unable to decrypt snapshot")
+		}
+		so.done = true
+		// Nothing is allowed after the final segment.
+		if n, _ := so.r.Read(lb[:1]); n > 0 {
+			return errSnapshotTrailingData
+		}
+	}
+	so.plain, so.out = out, out
+	so.seg++
+	return nil
+}
+
+func (so *snapshotOpener) Read(p []byte) (int, error) {
+	for len(so.out) == 0 {
+		if so.done {
+			return 0, io.EOF
+		}
+		if err := so.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, so.out)
+	so.out = so.out[n:]
+	return n, nil
+}
+
+// snapshotDigest tracks the chunks of a snapshot for its manifest.
+type snapshotDigest struct {
+	h      hash.Hash
+	chunks int
+	bytes  uint64
+}
+
+func newSnapshotDigest() *snapshotDigest {
+	return &snapshotDigest{h: sha256.New()}
+}
+
+func (sd *snapshotDigest) add(chunk []byte) {
+	sd.h.Write(chunk)
+	sd.chunks++
+	sd.bytes += uint64(len(chunk))
+}
+
+func (sd *snapshotDigest) manifest(encrypted bool) *SnapshotManifest {
+	return &SnapshotManifest{
+		Chunks:    sd.chunks,
+		Bytes:     sd.bytes,
+		Digest:    "SHA-256=" + base64.StdEncoding.EncodeToString(sd.h.Sum(nil)),
+		Encrypted: encrypted,
+	}
+}
+
+// Checks what was received against the manifest of the snapshot.
+// Chunks are not compared since the restore may be chunked differently.
+func (sd *snapshotDigest) verify(m *SnapshotManifest) error {
+	got := sd.manifest(m.Encrypted)
+	if got.Bytes != m.Bytes {
+		return fmt.Errorf("snapshot manifest mismatch, expected %d bytes, got %d", m.Bytes, got.Bytes)
+	}
+	if got.Digest != m.Digest {
+		return errors.New("snapshot manifest digest mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import "time"
+
+// StatsSample holds activity counts for a one minute interval.
+type StatsSample struct {
+	Start time.Time `json:"start"`
+	Msgs  uint64    `json:"msgs"`
+	Bytes uint64    `json:"bytes,omitempty"`
+}
+
+// StreamHistory holds the recent per minute activity of a stream and
+// the acknowledgements of its consumers.
+type StreamHistory struct {
+	Msgs []StatsSample            `json:"msgs"`
+	Acks map[string][]StatsSample `json:"consumer_acks,omitempty"`
+}
+
+// Number of minutes of history we keep.
+const statsHistoryLen = 60
+
+type statsBucket struct {
+	min   int64
+	msgs  uint64
+	bytes uint64
+}
+
+// statsHistory is a ring buffer of per minute activity counts.
+// Not safe for concurrent use, the owner's lock is used for that.
+type statsHistory [statsHistoryLen]statsBucket
+
+func (h *statsHistory) record(now time.Time, msgs, bytes uint64) {
+	min := now.Unix() / 60
+	b := &h[min%statsHistoryLen]
+	if b.min != min {
+		b.min, b.msgs, b.bytes = min, 0, 0
+	}
+	b.msgs += msgs
+	b.bytes += bytes
+}
+
+// Returns the samples for the last statsHistoryLen minutes, oldest first.
+// Minutes without activity are included with zero counts.
+func (h *statsHistory) samples(now time.Time) []StatsSample {
+	cur := now.Unix() / 60
+	samples := make([]StatsSample, 0, statsHistoryLen)
+	for min := cur - statsHistoryLen + 1; min <= cur; min++ {
+		s := StatsSample{Start: time.Unix(min*60, 0).UTC()}
+		if b := &h[min%statsHistoryLen]; b.min == min {
+			s.Msgs, s.Bytes = b.msgs, b.bytes
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
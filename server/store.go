@@ -19,7 +19,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -35,6 +37,16 @@ const (
 	AnyStorage = StorageType(44)
 )
 
+// StoreCompression determines how sealed message blocks are compressed on disk.
+type StoreCompression uint8
+
+const (
+	// NoCompression stores message blocks as they are written.
+	NoCompression StoreCompression = iota
+	// S2Compression compresses message blocks with S2 once they are no longer written to.
+	S2Compression
+)
+
 var (
 	// ErrStoreClosed is returned when the store has been closed
 	ErrStoreClosed = errors.New("store is closed")
@@ -61,8 +73,14 @@ var (
 	ErrInvalidSequence = errors.New("invalid sequence")
 	// ErrSequenceMismatch is returned when storing a raw message and the expected sequence is wrong.
 	ErrSequenceMismatch = errors.New("expected sequence does not match store")
+	// ErrLastSubjSeqMismatch is returned when storing a message and the last sequence for its subject is not the expected one.
+	ErrLastSubjSeqMismatch = errors.New("expected last sequence for subject does not match store")
 	// ErrPurgeArgMismatch is returned when PurgeEx is called with sequence > 1 and keep > 0.
 	ErrPurgeArgMismatch = errors.New("sequence > 1 && keep > 0 not allowed")
+	// ErrInvalidSequenceRange is returned when RemoveRange is called with a first sequence of 0 or after the last.
+	ErrInvalidSequenceRange = errors.New("invalid sequence range")
+	// ErrStoreFrozen is returned when storing or removing messages while the store is frozen.
+	ErrStoreFrozen = errors.New("store is frozen")
 )
 
 // StoreMsg is the stored message format for messages that are retained by the Store layer.
@@ -73,32 +91,118 @@ type StoreMsg struct {
 	buf  []byte
 	seq  uint64
 	ts   int64
+	// Set when buf was carved out of a memory store arena.
+	slab *memSlab
 }
 
 // Used to call back into the upper layers to report on changes in storage resources.
 // For the cases where its a single message we will also supply sequence number and subject.
 type StorageUpdateHandler func(msgs, bytes int64, seq uint64, subj string)
 
+// EvictionReason is the limit that caused a message to be evicted.
+type EvictionReason int
+
+const (
+	EvictMaxMsgs EvictionReason = iota
+	EvictMaxBytes
+	EvictMaxAge
+	EvictMaxMsgsPer
+	EvictMaxBytesPer
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictMaxMsgs:
+		return "max_msgs"
+	case EvictMaxBytes:
+		return "max_bytes"
+	case EvictMaxAge:
+		return "max_age"
+	case EvictMaxMsgsPer:
+		return "max_msgs_per_subject"
+	case EvictMaxBytesPer:
+		return "max_bytes_per_subject"
+	default:
+		return "unknown"
+	}
+}
+
+// EvictedMsg describes a message a store is about to remove due to its limits.
+// The slices are only valid for the duration of the callback and must not be
+// retained or modified.
+type EvictedMsg struct {
+	Reason   EvictionReason
+	Subject  string
+	Sequence uint64
+	Time     time.Time
+	Header   []byte
+	Data     []byte
+}
+
+// EvictionHandler is called before a message is removed due to limits.
+// Returning false vetoes the removal, which leaves the stream over its limits
+// until they are next enforced. Handlers are called inline from the store and
+// may have store locks held, so they must be fast and not call into the stream.
+type EvictionHandler func(m *EvictedMsg) bool
+
+// Returns true if subj matches one of the subjects on hold. Messages on hold are
+// skipped when enforcing limits, max age and purges.
+func isHeldSubject(holds []string, subj string) bool {
+	for _, h := range holds {
+		if subjectIsSubsetMatch(subj, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// How long before we retry expiring a message whose eviction was vetoed.
+const evictionVetoRetry = 10 * time.Second
+
+// Returns true if h allows the removal of sm.
+func allowEviction(h EvictionHandler, reason EvictionReason, sm *StoreMsg) bool {
+	if h == nil || sm == nil {
+		return true
+	}
+	return h(&EvictedMsg{
+		Reason:   reason,
+		Subject:  sm.subj,
+		Sequence: sm.seq,
+		Time:     time.Unix(0, sm.ts).UTC(),
+		Header:   sm.hdr,
+		Data:     sm.msg,
+	})
+}
+
 type StreamStore interface {
 	StoreMsg(subject string, hdr, msg []byte) (uint64, int64, error)
+	StoreMsgIfLastSubjSeq(subject string, hdr, msg []byte, lseq uint64) (uint64, int64, error)
 	StoreRawMsg(subject string, hdr, msg []byte, seq uint64, ts int64) error
 	SkipMsg() uint64
+	Prefetch(start, n uint64)
 	LoadMsg(seq uint64, sm *StoreMsg) (*StoreMsg, error)
 	LoadNextMsg(filter string, wc bool, start uint64, smp *StoreMsg) (sm *StoreMsg, skip uint64, err error)
 	LoadLastMsg(subject string, sm *StoreMsg) (*StoreMsg, error)
 	RemoveMsg(seq uint64) (bool, error)
 	EraseMsg(seq uint64) (bool, error)
+	RemoveRange(first, last uint64) (uint64, error)
 	Purge() (uint64, error)
 	PurgeEx(subject string, seq, keep uint64) (uint64, error)
+	RemapSubjects(filter, dest string, last uint64) (uint64, error)
+	ScheduledMsgs() map[uint64]int64
+	IsScheduled(seq uint64) bool
+	UnscheduleMsg(seq uint64) bool
 	Compact(seq uint64) (uint64, error)
 	Truncate(seq uint64) error
 	GetSeqFromTime(t time.Time) uint64
 	FilteredState(seq uint64, subject string) SimpleState
 	SubjectsState(filterSubject string) map[string]SimpleState
+	SubjectsStateRange(filterSubject, startSubject string, limit int) (map[string]SimpleState, int)
 	State() StreamState
 	FastState(*StreamState)
 	Type() StorageType
 	RegisterStorageUpdates(StorageUpdateHandler)
+	RegisterEvictionHandler(EvictionHandler)
 	UpdateConfig(cfg *StreamConfig) error
 	Delete() error
 	Stop() error
@@ -107,6 +211,14 @@ type StreamStore interface {
 	RemoveConsumer(o ConsumerStore) error
 	Snapshot(deadline time.Duration, includeConsumers, checkMsgs bool) (*SnapshotResult, error)
 	Utilization() (total, reported uint64, err error)
+	StoreStats() StoreStats
+	SetFrozen(frozen bool)
+	IsFrozen() bool
+	SetHolds(subjects []string)
+	AgeSummary() *StreamAgeSummary
+	AgeHistogram(bounds []time.Duration) []*StreamAgeBucket
+	PrefixUsage(depth int) map[string]*SubjectPrefixUsage
+	DeleteRanges(first, last uint64) DeleteRanges
 }
 
 // RetentionPolicy determines how messages in a set are retained.
@@ -135,27 +247,280 @@ const (
 
 // StreamState is information about the given stream.
 type StreamState struct {
-	Msgs        uint64            `json:"messages"`
-	Bytes       uint64            `json:"bytes"`
-	FirstSeq    uint64            `json:"first_seq"`
-	FirstTime   time.Time         `json:"first_ts"`
-	LastSeq     uint64            `json:"last_seq"`
-	LastTime    time.Time         `json:"last_ts"`
-	NumSubjects int               `json:"num_subjects,omitempty"`
-	Subjects    map[string]uint64 `json:"subjects,omitempty"`
-	NumDeleted  int               `json:"num_deleted,omitempty"`
-	Deleted     []uint64          `json:"deleted,omitempty"`
-	Lost        *LostStreamData   `json:"lost,omitempty"`
-	Consumers   int               `json:"consumer_count"`
+	Msgs         uint64            `json:"messages"`
+	Bytes        uint64            `json:"bytes"`
+	FirstSeq     uint64            `json:"first_seq"`
+	FirstTime    time.Time         `json:"first_ts"`
+	LastSeq      uint64            `json:"last_seq"`
+	LastTime     time.Time         `json:"last_ts"`
+	NumSubjects  int               `json:"num_subjects,omitempty"`
+	Subjects     map[string]uint64 `json:"subjects,omitempty"`
+	SubjectBytes map[string]uint64 `json:"subject_bytes,omitempty"`
+	NumDeleted   int               `json:"num_deleted,omitempty"`
+	Deleted      []uint64          `json:"deleted,omitempty"`
+	// DeletedRanges holds the interior deletes in compressed form.
+	// Deleted is only filled in on request, see DeletedSeqs.
+	DeletedRanges DeleteRanges      `json:"deleted_ranges,omitempty"`
+	Lost          *LostStreamData   `json:"lost,omitempty"`
+	Consumers     int               `json:"consumer_count"`
+	Ages          *StreamAgeSummary `json:"ages,omitempty"`
+}
+
+// DeletedSeqs will return the interior deletes as a list of sequences,
+// expanding DeletedRanges if needed.
+func (state *StreamState) DeletedSeqs() []uint64 {
+	if len(state.Deleted) > 0 || len(state.DeletedRanges) == 0 {
+		return state.Deleted
+	}
+	return state.DeletedRanges.Expand()
+}
+
+// DeleteRange is a run of Num consecutive deleted sequences starting at First.
+type DeleteRange struct {
+	First uint64 `json:"first"`
+	Num   uint64 `json:"num"`
+}
+
+// Last will return the last sequence in the run.
+func (dr DeleteRange) Last() uint64 {
+	return dr.First + dr.Num - 1
+}
+
+// DeleteRanges is a compressed, ordered set of deleted sequences.
+type DeleteRanges []DeleteRange
+
+// Adds num deleted sequences starting at seq, which needs to be past any we already have.
+func (drs *DeleteRanges) add(seq, num uint64) {
+	if num == 0 {
+		return
+	}
+	if n := len(*drs); n > 0 {
+		if dr := &(*drs)[n-1]; dr.Last()+1 == seq {
+			dr.Num += num
+			return
+		}
+	}
+	*drs = append(*drs, DeleteRange{First: seq, Num: num})
+}
+
+// Num will return the number of deleted sequences.
+func (drs DeleteRanges) Num() uint64 {
+	var total uint64
+	for _, dr := range drs {
+		total += dr.Num
+	}
+	return total
+}
+
+// Contains will return if seq is deleted.
+func (drs DeleteRanges) Contains(seq uint64) bool {
+	i := sort.Search(len(drs), func(i int) bool { return drs[i].Last() >= seq })
+	return i < len(drs) && drs[i].First <= seq
+}
+
+// Within will return the deleted sequences between first and last inclusive.
+func (drs DeleteRanges) Within(first, last uint64) DeleteRanges {
+	var out DeleteRanges
+	i := sort.Search(len(drs), func(i int) bool { return drs[i].Last() >= first })
+	for ; i < len(drs) && drs[i].First <= last; i++ {
+		start, end := drs[i].First, drs[i].Last()
+		if start < first {
+			start = first
+		}
+		if end > last {
+			end = last
+		}
+		out.add(start, end-start+1)
+	}
+	return out
+}
+
+// Range will call cb for each deleted sequence in order until cb returns false.
+func (drs DeleteRanges) Range(cb func(seq uint64) bool) {
+	for _, dr := range drs {
+		for seq := dr.First; seq <= dr.Last(); seq++ {
+			if !cb(seq) {
+				return
+			}
+		}
+	}
+}
+
+// Expand will return all deleted sequences.
+func (drs DeleteRanges) Expand() []uint64 {
+	if len(drs) == 0 {
+		return nil
+	}
+	seqs := make([]uint64, 0, drs.Num())
+	drs.Range(func(seq uint64) bool {
+		seqs = append(seqs, seq)
+		return true
+	})
+	return seqs
+}
+
+// StreamAgeSummary is an approximate distribution of the ages of the messages in a stream.
+// P50 is the time half of the messages are older than, and P90 the time that 90% of
+// the messages are younger than, so messages before P90 are the next to age out.
+type StreamAgeSummary struct {
+	Oldest time.Time `json:"oldest"`
+	P50    time.Time `json:"p50"`
+	P90    time.Time `json:"p90"`
+}
+
+// StreamAgeBucket holds the messages younger than MaxAge and at least as old as the
+// MaxAge of the bucket before it. The last bucket has no MaxAge and holds all older messages.
+type StreamAgeBucket struct {
+	MaxAge time.Duration `json:"max_age,omitempty"`
+	Msgs   uint64        `json:"msgs"`
+	Bytes  uint64        `json:"bytes"`
+}
+
+// SubjectPrefixUsage is what a stream stores for the subjects starting with Prefix.
+type SubjectPrefixUsage struct {
+	Prefix   string `json:"prefix"`
+	Subjects int    `json:"subjects"`
+	Msgs     uint64 `json:"msgs"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// Returns age buckets for the ascending bounds, given msgs and bytes in total and a
+// function returning how many messages are younger than a timestamp. Bytes are
+// assumed to be spread evenly over the messages.
+func ageHistogram(bounds []time.Duration, msgs, bytes uint64, younger func(ts int64) uint64) []*StreamAgeBucket {
+	buckets := make([]*StreamAgeBucket, 0, len(bounds)+1)
+	share := func(n uint64) uint64 {
+		if msgs == 0 {
+			return 0
+		}
+		return uint64(float64(bytes) * float64(n) / float64(msgs))
+	}
+	now := time.Now().UnixNano()
+	var prev uint64
+	for _, bound := range bounds {
+		n := prev
+		if msgs > 0 {
+			if n = younger(now - int64(bound)); n < prev {
+				n = prev
+			} else if n > msgs {
+				n = msgs
+			}
+		}
+		buckets = append(buckets, &StreamAgeBucket{MaxAge: bound, Msgs: n - prev, Bytes: share(n) - share(prev)})
+		prev = n
+	}
+	return append(buckets, &StreamAgeBucket{Msgs: msgs - prev, Bytes: bytes - share(prev)})
+}
+
+// Returns how many of msgs, stored evenly from first to last, are stored after ts.
+func msgsStoredAfter(ts, first, last int64, msgs uint64) uint64 {
+	switch {
+	case ts < first:
+		return msgs
+	case ts >= last:
+		return 0
+	}
+	return uint64(float64(msgs) * float64(last-ts) / float64(last-first))
+}
+
+// Returns the first depth tokens of subj.
+func subjectPrefix(subj string, depth int) string {
+	for i := 0; i < len(subj); i++ {
+		if subj[i] == btsep {
+			if depth--; depth == 0 {
+				return subj[:i]
+			}
+		}
+	}
+	return subj
+}
+
+// Adds a subject with msgs and bytes to the usage of its prefix.
+func addPrefixUsage(pu map[string]*SubjectPrefixUsage, subj string, depth int, msgs, bytes uint64) {
+	prefix := subjectPrefix(subj, depth)
+	u := pu[prefix]
+	if u == nil {
+		u = &SubjectPrefixUsage{Prefix: prefix}
+		pu[prefix] = u
+	}
+	u.Subjects++
+	u.Msgs += msgs
+	u.Bytes += bytes
 }
 
 // SimpleState for filtered subject specific state.
+// Bytes is the total stored size of the messages and is only tracked
+// per subject, so it is reported by SubjectsState and SubjectsStateRange.
 type SimpleState struct {
 	Msgs  uint64 `json:"messages"`
+	Bytes uint64 `json:"bytes,omitempty"`
 	First uint64 `json:"first_seq"`
 	Last  uint64 `json:"last_seq"`
 }
 
+// Returns the delay for the next age expiration check taking into account
+// any configured expiry batching, which bounds how often the timer fires.
+func expiryFireIn(fireIn, batch time.Duration) time.Duration {
+	if batch > 0 && fireIn < batch {
+		return batch
+	}
+	return fireIn
+}
+
+// StoreStats holds rolling rates maintained by the store.
+// Rates are per second, averaged over the last storeRateWindow seconds.
+type StoreStats struct {
+	InMsgsRate       float64 `json:"in_msgs_rate"`
+	InBytesRate      float64 `json:"in_bytes_rate"`
+	ExpiredMsgsRate  float64 `json:"expired_msgs_rate"`
+	ExpiredBytesRate float64 `json:"expired_bytes_rate"`
+}
+
+// Number of seconds used to compute rolling store rates.
+const storeRateWindow = 10
+
+type rateBucket struct {
+	sec   int64
+	msgs  uint64
+	bytes uint64
+}
+
+// rateMeter tracks counts in per second buckets over storeRateWindow.
+// Not safe for concurrent use, the store lock is used for that.
+type rateMeter [storeRateWindow]rateBucket
+
+func (r *rateMeter) record(now int64, msgs, bytes uint64) {
+	b := &r[now%storeRateWindow]
+	if b.sec != now {
+		b.sec, b.msgs, b.bytes = now, 0, 0
+	}
+	b.msgs += msgs
+	b.bytes += bytes
+}
+
+func (r *rateMeter) rates(now int64) (msgs, bytes float64) {
+	for _, b := range r {
+		if b.sec > now-storeRateWindow && b.sec <= now {
+			msgs += float64(b.msgs)
+			bytes += float64(b.bytes)
+		}
+	}
+	return msgs / storeRateWindow, bytes / storeRateWindow
+}
+
+// storeRates holds the ingest and expiry rate meters for a store.
+type storeRates struct {
+	in      rateMeter
+	expired rateMeter
+}
+
+func (sr *storeRates) stats(now int64) StoreStats {
+	var ss StoreStats
+	ss.InMsgsRate, ss.InBytesRate = sr.in.rates(now)
+	ss.ExpiredMsgsRate, ss.ExpiredBytesRate = sr.expired.rates(now)
+	return ss
+}
+
 // LostStreamData indicates msgs that have been lost.
 type LostStreamData struct {
 	Msgs  []uint64 `json:"msgs"`
@@ -411,6 +776,45 @@ func (st *StorageType) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+const (
+	noCompressionString = "none"
+	s2CompressionString = "s2"
+)
+
+func (alg StoreCompression) String() string {
+	switch alg {
+	case NoCompression:
+		return "None"
+	case S2Compression:
+		return "S2"
+	default:
+		return "Unknown StoreCompression"
+	}
+}
+
+func (alg StoreCompression) MarshalJSON() ([]byte, error) {
+	switch alg {
+	case NoCompression:
+		return json.Marshal(noCompressionString)
+	case S2Compression:
+		return json.Marshal(s2CompressionString)
+	default:
+		return nil, fmt.Errorf("can not marshal %v", alg)
+	}
+}
+
+func (alg *StoreCompression) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case jsonString(noCompressionString):
+		*alg = NoCompression
+	case jsonString(s2CompressionString):
+		*alg = S2Compression
+	default:
+		return fmt.Errorf("can not unmarshal %q", data)
+	}
+	return nil
+}
+
 const (
 	ackNonePolicyString     = "none"
 	ackAllPolicyString      = "all"
@@ -549,8 +953,115 @@ func (sm *StoreMsg) clear() {
 	if sm == nil {
 		return
 	}
-	*sm = StoreMsg{_EMPTY_, nil, nil, sm.buf, 0, 0}
+	*sm = StoreMsg{_EMPTY_, nil, nil, sm.buf, 0, 0, nil}
 	if len(sm.buf) > 0 {
 		sm.buf = sm.buf[:0]
 	}
 }
+
+// CompactProgress reports on an asynchronous compaction.
+type CompactProgress struct {
+	Seq             uint64    `json:"seq"`
+	Started         time.Time `json:"started"`
+	BlocksTotal     int       `json:"blocks_total"`
+	BlocksProcessed int       `json:"blocks_processed"`
+	Purged          uint64    `json:"purged"`
+	BytesReclaimed  uint64    `json:"bytes_reclaimed"`
+	Done            bool      `json:"done"`
+	Canceled        bool      `json:"canceled,omitempty"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// CompactHandle tracks an asynchronous compaction.
+type CompactHandle struct {
+	mu   sync.Mutex
+	p    CompactProgress
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newCompactHandle(seq uint64, blocks int) *CompactHandle {
+	return &CompactHandle{
+		p:    CompactProgress{Seq: seq, Started: time.Now().UTC(), BlocksTotal: blocks},
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Progress returns a snapshot of the current progress.
+func (h *CompactHandle) Progress() CompactProgress {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.p
+}
+
+// Cancel will stop the compaction after the block currently being processed.
+func (h *CompactHandle) Cancel() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.p.Done && !h.p.Canceled {
+		h.p.Canceled = true
+		close(h.quit)
+	}
+}
+
+// Done returns a channel that will be closed once the compaction has stopped.
+func (h *CompactHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Record a processed step.
+func (h *CompactHandle) update(purged, bytes uint64) {
+	h.mu.Lock()
+	h.p.BlocksProcessed++
+	h.p.Purged += purged
+	h.p.BytesReclaimed += bytes
+	h.mu.Unlock()
+}
+
+// Mark as finished with an optional error.
+func (h *CompactHandle) finish(err error) {
+	h.mu.Lock()
+	h.p.Done = true
+	if err != nil {
+		h.p.Error = err.Error()
+	}
+	h.mu.Unlock()
+	close(h.done)
+}
+
+// subjectRange selects, in sorted order, the first limit subjects that sort after start.
+// Memory is bounded by limit regardless of how many subjects are added.
+type subjectRange struct {
+	start string
+	limit int
+	subjs []string
+	total int
+}
+
+func newSubjectRange(start string, limit int) *subjectRange {
+	return &subjectRange{start: start, limit: limit}
+}
+
+// Add a matching subject. All subjects count towards the total.
+func (sr *subjectRange) add(subj string) {
+	sr.total++
+	if sr.start != _EMPTY_ && subj <= sr.start {
+		return
+	}
+	sr.subjs = append(sr.subjs, subj)
+	// Trim once we have collected twice our limit to amortize the sorts.
+	if sr.limit > 0 && len(sr.subjs) >= 2*sr.limit {
+		sort.Strings(sr.subjs)
+		sr.subjs = sr.subjs[:sr.limit]
+	}
+}
+
+// Returns the selected subjects in sorted order.
+func (sr *subjectRange) subjects() []string {
+	sort.Strings(sr.subjs)
+	if sr.limit > 0 && len(sr.subjs) > sr.limit {
+		sr.subjs = sr.subjs[:sr.limit]
+	}
+	return sr.subjs
+}
@@ -26,6 +26,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,30 +34,105 @@ import (
 
 	"github.com/klauspost/compress/s2"
 	"github.com/nats-io/nuid"
+	"golang.org/x/time/rate"
 )
 
 // StreamConfig will determine the name, subjects and retention policy
 // for a given stream. If subjects is empty the name will be used.
 type StreamConfig struct {
-	Name         string          `json:"name"`
-	Description  string          `json:"description,omitempty"`
-	Subjects     []string        `json:"subjects,omitempty"`
-	Retention    RetentionPolicy `json:"retention"`
-	MaxConsumers int             `json:"max_consumers"`
-	MaxMsgs      int64           `json:"max_msgs"`
-	MaxBytes     int64           `json:"max_bytes"`
-	MaxAge       time.Duration   `json:"max_age"`
-	MaxMsgsPer   int64           `json:"max_msgs_per_subject"`
-	MaxMsgSize   int32           `json:"max_msg_size,omitempty"`
-	Discard      DiscardPolicy   `json:"discard"`
-	Storage      StorageType     `json:"storage"`
-	Replicas     int             `json:"num_replicas"`
-	NoAck        bool            `json:"no_ack,omitempty"`
-	Template     string          `json:"template_owner,omitempty"`
-	Duplicates   time.Duration   `json:"duplicate_window,omitempty"`
-	Placement    *Placement      `json:"placement,omitempty"`
-	Mirror       *StreamSource   `json:"mirror,omitempty"`
-	Sources      []*StreamSource `json:"sources,omitempty"`
+	Name         string           `json:"name"`
+	Description  string           `json:"description,omitempty"`
+	Subjects     []string         `json:"subjects,omitempty"`
+	Retention    RetentionPolicy  `json:"retention"`
+	MaxConsumers int              `json:"max_consumers"`
+	MaxMsgs      int64            `json:"max_msgs"`
+	MaxBytes     int64            `json:"max_bytes"`
+	MaxAge       time.Duration    `json:"max_age"`
+	MaxMsgsPer   int64            `json:"max_msgs_per_subject"`
+	MaxBytesPer  int64            `json:"max_bytes_per_subject,omitempty"`
+	MaxMsgSize   int32            `json:"max_msg_size,omitempty"`
+	Discard      DiscardPolicy    `json:"discard"`
+	Storage      StorageType      `json:"storage"`
+	Replicas     int              `json:"num_replicas"`
+	NoAck        bool             `json:"no_ack,omitempty"`
+	Template     string           `json:"template_owner,omitempty"`
+	Duplicates   time.Duration    `json:"duplicate_window,omitempty"`
+	ExpiryBatch  time.Duration    `json:"expiry_batch,omitempty"`
+	MemoryWAL    *MemoryWAL       `json:"memory_wal,omitempty"`
+	Overflow     *StreamOverflow  `json:"overflow,omitempty"`
+	Offload      *StreamOffload   `json:"offload,omitempty"`
+	RateLimit    *StreamRateLimit `json:"rate_limit,omitempty"`
+	// Transforms applied in order to published messages before they are stored.
+	Transforms []*StreamTransform `json:"transforms,omitempty"`
+	// Schemas validate the payload of published messages before they are stored.
+	Schemas      []*StreamSchema `json:"schemas,omitempty"`
+	TierAge      time.Duration   `json:"tier_age,omitempty"`
+	SyncInterval time.Duration   `json:"sync_interval,omitempty"`
+	SyncAlways   bool            `json:"sync_always,omitempty"`
+	// ScrubInterval, when set, will have data freed by removing messages overwritten with zeros this often.
+	ScrubInterval time.Duration   `json:"scrub_interval,omitempty"`
+	Placement     *Placement      `json:"placement,omitempty"`
+	Tier          string          `json:"tier,omitempty"`
+	Mirror        *StreamSource   `json:"mirror,omitempty"`
+	Sources       []*StreamSource `json:"sources,omitempty"`
+
+	// Tokens that allow streams in other accounts to source or mirror this stream.
+	SourceTokens []string `json:"source_tokens,omitempty"`
+
+	// Number of partitions the messages of this stream are stored in.
+	// Not supported in clustered mode.
+	Partitions int `json:"partitions,omitempty"`
+	// Set on the partitions of a partitioned stream, the name of that stream.
+	PartitionOf string `json:"partition_of,omitempty"`
+
+	// Limits on catching up replicas of this stream, on top of those of the server.
+	Catchup *StreamCatchup `json:"catchup,omitempty"`
+
+	// Keep a Bloom filter of the subjects in each message block so lookups
+	// of sparse subjects can skip blocks without loading them.
+	SubjectBloom bool `json:"subject_bloom,omitempty"`
+
+	// Compress message blocks once they are no longer written to. Changing this
+	// will rewrite existing blocks in the background.
+	Compression StoreCompression `json:"compression,omitempty"`
+
+	// Compress message payloads larger than this many bytes as they are stored.
+	// Consumers decompress them on delivery unless DeliverCompressed is set.
+	CompressMsgsOver int `json:"compress_msgs_over,omitempty"`
+
+	// Max age overrides for subjects, the first matching override applies.
+	SubjectRetention []*SubjectRetention `json:"subject_retention,omitempty"`
+
+	// Ingest the records of a Kafka topic into this stream.
+	Kafka *KafkaSource `json:"kafka,omitempty"`
+
+	// Push the messages of this stream to an external system.
+	Sink *StreamSink `json:"sink,omitempty"`
+
+	// Raise or lower the replicas with the number of healthy servers.
+	ReplicaScaling *ReplicaScaling `json:"replica_scaling,omitempty"`
+
+	// Warn before MaxBytes or MaxMsgs are enforced.
+	SoftLimits *SoftLimits `json:"soft_limits,omitempty"`
+
+	// Named groups that each consume a work queue stream in full. Messages are
+	// removed once acknowledged by every group.
+	ConsumptionGroups []string `json:"consumption_groups,omitempty"`
+
+	// Keep messages of an interest stream around for at least this long after
+	// they were acknowledged by all consumers.
+	InterestHold time.Duration `json:"interest_hold,omitempty"`
+
+	// Remove key value delete markers once they are this old. Requires MaxMsgsPer of 1.
+	DeleteMarkerTTL time.Duration `json:"delete_marker_ttl,omitempty"`
+
+	// Store payloads larger than this many bytes as ordered chunk messages. Clients
+	// can publish messages over max_payload to the stream, up to MaxMsgSize.
+	ChunkSize int32 `json:"chunk_size,omitempty"`
+
+	// Pause consumers and release caches once the stream saw no publishes,
+	// pull requests or acks for this long. Resumes on the next one.
+	IdlePause time.Duration `json:"idle_pause,omitempty"`
 
 	// Allow republish of the message after being sequenced and stored.
 	RePublish *RePublish `json:"republish,omitempty"`
@@ -77,16 +153,83 @@ type StreamConfig struct {
 	DenyDelete bool `json:"deny_delete"`
 	// DenyPurge will restrict the ability to purge messages.
 	DenyPurge bool `json:"deny_purge"`
+	// DeletionProtection requires the stream to be unlocked before it can be deleted or purged.
+	DeletionProtection bool `json:"deletion_protection,omitempty"`
 	// AllowRollup allows messages to be placed into the system and purge
 	// all older messages using a special msg header.
 	AllowRollup bool `json:"allow_rollup_hdrs"`
 }
 
+// MemoryWAL enables an asynchronous write-ahead log for memory based streams.
+// Records are flushed to disk every FlushInterval, and the log is rewritten from
+// the current stream state once it grows past MaxBytes. On restart the stream is
+// repopulated up to the last flushed record.
+type MemoryWAL struct {
+	FlushInterval time.Duration `json:"flush_interval,omitempty"`
+	MaxBytes      int64         `json:"max_bytes,omitempty"`
+}
+
 // RePublish is for republishing messages once committed to a stream.
+// If HeaderFilter is set, only messages having all of its headers are
+// republished, with the same value unless the value in the filter is empty.
+// Headers are set on the republished messages.
 type RePublish struct {
-	Source      string `json:"src,omitempty"`
-	Destination string `json:"dest"`
-	HeadersOnly bool   `json:"headers_only,omitempty"`
+	Source       string            `json:"src,omitempty"`
+	Destination  string            `json:"dest"`
+	HeadersOnly  bool              `json:"headers_only,omitempty"`
+	HeaderFilter map[string]string `json:"header_filter,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+}
+
+// Returns true if a message with these headers should be republished.
+func (rp *RePublish) matchesHeaders(hdr []byte) bool {
+	for k, want := range rp.HeaderFilter {
+		v := getHeader(k, hdr)
+		if v == nil || (want != _EMPTY_ && string(v) != want) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the headers to set on republished messages, as alternating keys
+// and values sorted by key.
+func (rp *RePublish) sortedHeaders() []string {
+	if len(rp.Headers) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(rp.Headers))
+	for k := range rp.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	hdrs := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		hdrs = append(hdrs, k, rp.Headers[k])
+	}
+	return hdrs
+}
+
+// Headers a republish can not set.
+var rePublishReservedHeaders = []string{JSStream, JSSubject, JSSequence, JSLastSequence, JSMsgSize}
+
+func checkRePublishHeaders(rp *RePublish) error {
+	for k := range rp.HeaderFilter {
+		if k == _EMPTY_ {
+			return errors.New("stream configuration for republish has an empty header filter name")
+		}
+	}
+	for k := range rp.Headers {
+		if k == _EMPTY_ {
+			return errors.New("stream configuration for republish has an empty header name")
+		}
+		for _, rk := range rePublishReservedHeaders {
+			if strings.EqualFold(k, rk) {
+				return fmt.Errorf("stream configuration for republish can not set header %q", k)
+			}
+		}
+	}
+	return nil
 }
 
 // JSPubAckResponse is a formal response to a publish operation.
@@ -115,14 +258,21 @@ type PubAck struct {
 
 // StreamInfo shows config and current state for this stream.
 type StreamInfo struct {
-	Config     StreamConfig        `json:"config"`
-	Created    time.Time           `json:"created"`
-	State      StreamState         `json:"state"`
-	Domain     string              `json:"domain,omitempty"`
-	Cluster    *ClusterInfo        `json:"cluster,omitempty"`
-	Mirror     *StreamSourceInfo   `json:"mirror,omitempty"`
-	Sources    []*StreamSourceInfo `json:"sources,omitempty"`
-	Alternates []StreamAlternate   `json:"alternates,omitempty"`
+	Config     StreamConfig           `json:"config"`
+	Created    time.Time              `json:"created"`
+	State      StreamState            `json:"state"`
+	Domain     string                 `json:"domain,omitempty"`
+	Cluster    *ClusterInfo           `json:"cluster,omitempty"`
+	Mirror     *StreamSourceInfo      `json:"mirror,omitempty"`
+	Sources    []*StreamSourceInfo    `json:"sources,omitempty"`
+	Alternates []StreamAlternate      `json:"alternates,omitempty"`
+	Stats      *StoreStats            `json:"stats,omitempty"`
+	Frozen     bool                   `json:"frozen,omitempty"`
+	Holds      []*SubjectHold         `json:"holds,omitempty"`
+	Partitions []*StreamPartitionInfo `json:"partitions,omitempty"`
+	Sink       *StreamSinkInfo        `json:"sink,omitempty"`
+	Recompress *RecompressProgress    `json:"recompress,omitempty"`
+	Idle       bool                   `json:"idle,omitempty"`
 }
 
 type StreamAlternate struct {
@@ -168,15 +318,21 @@ type StreamSource struct {
 	OptStartTime  *time.Time      `json:"opt_start_time,omitempty"`
 	FilterSubject string          `json:"filter_subject,omitempty"`
 	External      *ExternalStream `json:"external,omitempty"`
+	// Resumable mirrors keep a checkpoint of how far they got in the origin stream.
+	Resumable bool `json:"resumable,omitempty"`
 
 	// Internal
 	iname string // For indexing when stream names are the same for multiple sources.
 }
 
 // ExternalStream allows you to qualify access to a stream source in another account.
+// When Account is set the stream is in that account on this system, and Token
+// needs to be one of the source tokens of the stream.
 type ExternalStream struct {
 	ApiPrefix     string `json:"api"`
 	DeliverPrefix string `json:"deliver"`
+	Account       string `json:"account,omitempty"`
+	Token         string `json:"token,omitempty"`
 }
 
 // Stream is a jetstream stream of messages. When we receive a message internally destined
@@ -207,21 +363,86 @@ type stream struct {
 	ddarr     []*ddentry
 	ddindex   int
 	ddtmr     *time.Timer
+	ddfile    string      // Dedupe state file for R1 memory streams.
+	dddirty   bool        // Dedupe state needs to be written out.
+	ddstmr    *time.Timer // Timer to write out dedupe state.
+	ddwmu     sync.Mutex  // Serializes writes of the dedupe state.
 	qch       chan struct{}
 	active    bool
 	ddloaded  bool
 
 	// Mirror
-	mirror *sourceInfo
+	mirror  *sourceInfo
+	mckfile string // Checkpoint file for resumable mirrors.
+	mckseq  uint64 // Origin sequence of the last checkpoint.
+
+	// Subjects on hold, kept from limits, max age and purges.
+	holds     []*SubjectHold
+	holdsFile string
+	hmu       sync.Mutex
 
 	// Sources
 	sources map[string]*sourceInfo
 
+	// Kafka source, if we are ingesting one.
+	kafka *kafkaIngest
+	// Sink, if we are pushing to one.
+	sink *streamSink
+
 	// Indicates we have direct consumers.
 	directs int
 
 	// For republishing.
-	tr *transform
+	tr     *transform
+	rpHdrs []string
+
+	// Per minute activity history.
+	hist statsHistory
+
+	// Soft limits we are over.
+	softOver map[string]bool
+
+	// Set while fenced for a backup point, along with the timer lifting the fence.
+	fence  string
+	fenceT *time.Timer
+
+	// Acknowledged messages held for the interest hold, oldest first.
+	held  []heldMsg
+	heldT *time.Timer
+
+	// Delete markers to remove with DeleteMarkerTTL, oldest first.
+	dmarks []deleteMarker
+	dmarkT *time.Timer
+
+	// Idle tracking for IdlePause. Last activity and duration are in nanoseconds.
+	idleT   *time.Timer
+	lact    int64
+	idleDur int64
+	idle    int32
+
+	// Any asynchronous compaction.
+	compactor *CompactHandle
+
+	// Ingest rate limits.
+	rlMsgs  *rate.Limiter
+	rlBytes *rate.Limiter
+	// Messages held back by backpressure, in order, with their own lock.
+	rlMu sync.Mutex
+	rlq  []*deferredMsg
+	rlT  *time.Timer
+	// Rate limit for catching up replicas.
+	rlCatchup *rate.Limiter
+
+	// Ingest transforms.
+	xforms []*streamXform
+
+	// Ingest schema validation.
+	schemas *streamSchemas
+
+	// Scheduled messages.
+	sched     schedWheel
+	schedTmr  *time.Timer
+	schedNext int64
 
 	// For processing consumers without main stream lock.
 	clsMu sync.RWMutex
@@ -237,6 +458,7 @@ type stream struct {
 	catchup    bool
 	syncSub    *subscription
 	infoSub    *subscription
+	psyncSub   *subscription
 	clMu       sync.Mutex
 	clseq      uint64
 	clfs       uint64
@@ -269,7 +491,8 @@ type sourceInfo struct {
 	last  time.Time
 	lreq  time.Time
 	qch   chan struct{}
-	sip   bool // setup in progress
+	sip   bool     // setup in progress
+	acc   *Account // set when the source is in another account
 	wg    sync.WaitGroup
 }
 
@@ -282,6 +505,7 @@ const (
 // Headers for published messages.
 const (
 	JSMsgId               = "Nats-Msg-Id"
+	JSSourceToken         = "Nats-Source-Token"
 	JSExpectedStream      = "Nats-Expected-Stream"
 	JSExpectedLastSeq     = "Nats-Expected-Last-Sequence"
 	JSExpectedLastSubjSeq = "Nats-Expected-Last-Subject-Sequence"
@@ -412,8 +636,8 @@ func (a *Account) addStreamWithAssignment(config *StreamConfig, fsConfig *FileSt
 	}
 
 	// Check for overlapping subjects with other streams.
-	// These are not allowed for now.
-	if jsa.subjectsOverlap(cfg.Subjects, nil) {
+	// These are not allowed for now. Partitions share the subjects of their stream.
+	if cfg.PartitionOf == _EMPTY_ && jsa.subjectsOverlap(cfg.Subjects, nil) {
 		jsa.mu.Unlock()
 		return nil, NewJSStreamSubjectOverlapError()
 	}
@@ -467,6 +691,7 @@ func (a *Account) addStreamWithAssignment(config *StreamConfig, fsConfig *FileSt
 		}
 		// Assign our transform for republishing.
 		mset.tr = tr
+		mset.rpHdrs = cfg.RePublish.sortedHeaders()
 	}
 
 	jsa.streams[cfg.Name] = mset
@@ -491,11 +716,29 @@ func (a *Account) addStreamWithAssignment(config *StreamConfig, fsConfig *FileSt
 	fsCfg.StoreDir = storeDir
 	fsCfg.AsyncFlush = false
 	fsCfg.SyncInterval = 2 * time.Minute
+	fsCfg.FlushCPUs = s.getOpts().CPUAffinity.Filestore
+
+	// Our store needs to know of holds when it recovers.
+	mset.mu.Lock()
+	mset.loadSubjectHolds(storeDir)
+	fsCfg.Holds = holdSubjects(mset.holds)
+	mset.mu.Unlock()
 
 	if err := mset.setupStore(fsCfg); err != nil {
 		mset.stop(true, false)
 		return nil, NewJSStreamStoreFailedError(err)
 	}
+	mset.mu.Lock()
+	mset.setupDedupePersistence(storeDir)
+	mset.setupMirrorCheckpoint(storeDir)
+	mset.setRateLimitLocked(cfg.RateLimit)
+	mset.setCatchupLimitLocked(cfg.Catchup)
+	mset.setTransformsLocked(&cfg)
+	mset.setSchemasLocked(&cfg)
+	mset.loadDeleteMarkersLocked()
+	mset.setIdlePauseLocked()
+	mset.mu.Unlock()
+	mset.loadSchedule()
 
 	// Create our pubAck template here. Better than json marshal each time on success.
 	if domain := s.getOpts().JetStreamDomain; domain != _EMPTY_ {
@@ -531,6 +774,11 @@ func (a *Account) addStreamWithAssignment(config *StreamConfig, fsConfig *FileSt
 	// Reserve resources if MaxBytes present.
 	mset.js.reserveStreamResources(&mset.cfg)
 
+	// In clustered mode source tokens are tracked from the stream assignments.
+	if !mset.js.isClusteredNoLock() {
+		mset.js.setSourceTokens(a.Name, cfg.Name, cfg.SourceTokens)
+	}
+
 	// Call directly to set leader if not in clustered mode.
 	// This can be called though before we actually setup clustering, so check both.
 	if singleServerMode {
@@ -566,7 +814,9 @@ func (a *Account) addStreamWithAssignment(config *StreamConfig, fsConfig *FileSt
 // Sets the index name. Usually just the stream name but when the stream is external we will
 // use additional information in case the stream names are the same.
 func (ssi *StreamSource) setIndexName() {
-	if ssi.External != nil {
+	if ssi.External != nil && ssi.External.Account != _EMPTY_ {
+		ssi.iname = ssi.Name + ":" + getHash(ssi.External.Account+":"+ssi.External.ApiPrefix)
+	} else if ssi.External != nil {
 		ssi.iname = ssi.Name + ":" + getHash(ssi.External.ApiPrefix)
 	} else {
 		ssi.iname = ssi.Name
@@ -601,6 +851,12 @@ func (mset *stream) setStreamAssignment(sa *streamAssignment) {
 		mset.infoSub, _ = mset.srv.systemSubscribe(isubj, _EMPTY_, false, mset.sysc, mset.handleClusterStreamInfoRequest)
 	}
 
+	// Also listen for sync requests sent to us directly by replicas catching up from a nearby peer.
+	if mset.psyncSub == nil && mset.node != nil && sa.Sync != _EMPTY_ {
+		psubj := syncPeerSubject(sa.Sync, mset.node.ID())
+		mset.psyncSub, _ = mset.srv.systemSubscribe(psubj, _EMPTY_, false, mset.sysc, mset.handlePeerSyncRequest)
+	}
+
 	// Trigger update chan.
 	select {
 	case mset.uch <- struct{}{}:
@@ -645,6 +901,8 @@ func (mset *stream) setLeader(isLeader bool) error {
 			mset.mu.Unlock()
 			return err
 		}
+		// Pick up delivering any scheduled messages.
+		mset.armScheduleLocked()
 	} else {
 		// Stop responding to sync requests.
 		mset.stopClusterSubs()
@@ -917,7 +1175,7 @@ func (mset *stream) setCreatedTime(created time.Time) {
 // RLock minimum should be held.
 func (jsa *jsAccount) subjectsOverlap(subjects []string, self *stream) bool {
 	for _, mset := range jsa.streams {
-		if self != nil && mset == self {
+		if self != nil && mset == self || mset.cfg.PartitionOf != _EMPTY_ {
 			continue
 		}
 		for _, subj := range mset.cfg.Subjects {
@@ -956,6 +1214,10 @@ func (s *Server) checkStreamCfg(config *StreamConfig, acc *Account) (StreamConfi
 	if cfg.Storage == 0 {
 		cfg.Storage = FileStorage
 	}
+	// Deleted streams are kept in the trash on disk, which memory streams do not have.
+	if cfg.Storage == MemoryStorage && acc != nil && acc.trashRetention() > 0 {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("memory streams are not supported with a trash retention for the account"))
+	}
 	if cfg.Replicas == 0 {
 		cfg.Replicas = 1
 	}
@@ -971,6 +1233,10 @@ func (s *Server) checkStreamCfg(config *StreamConfig, acc *Account) (StreamConfi
 	if cfg.MaxMsgsPer == 0 {
 		cfg.MaxMsgsPer = -1
 	}
+	// Max bytes per subject is optional, anything not positive means unlimited.
+	if cfg.MaxBytesPer < 0 {
+		cfg.MaxBytesPer = 0
+	}
 	if cfg.MaxBytes == 0 {
 		cfg.MaxBytes = -1
 	}
@@ -1009,6 +1275,112 @@ func (s *Server) checkStreamCfg(config *StreamConfig, acc *Account) (StreamConfi
 		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("duplicates window needs to be >= 100ms"))
 	}
 
+	if cfg.ExpiryBatch == 0 && cfg.MaxAge > 0 {
+		cfg.ExpiryBatch = lim.ExpiryBatch
+	}
+	if cfg.ExpiryBatch < 0 {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("expiry batch can not be negative"))
+	}
+
+	if err := checkStreamOverflow(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkStreamOffload(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkStreamRateLimit(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkStreamCatchup(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkSubjectRetention(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkReplicaScaling(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkSoftLimits(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkConsumptionGroups(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkInterestHold(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkDeleteMarkerTTL(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkStreamChunking(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkIdlePause(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkStreamTransforms(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := checkStreamSchemas(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := s.checkStreamPartitions(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := s.checkStreamKafka(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+	if err := s.checkStreamSink(&cfg); err != nil {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+	}
+
+	if cfg.TierAge < 0 {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("tier age can not be negative"))
+	}
+	if cfg.TierAge > 0 {
+		if cfg.Storage != FileStorage {
+			return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("tier age requires file storage"))
+		}
+		if s.getOpts().JetStreamTierBackend == nil {
+			return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("tier age requires a tier backend"))
+		}
+	}
+
+	if cfg.SubjectBloom && cfg.Storage != FileStorage {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("subject bloom filters require file storage"))
+	}
+	if cfg.Compression > S2Compression {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("unknown compression %v", cfg.Compression))
+	}
+	if cfg.Compression != NoCompression && cfg.Storage != FileStorage {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("compression requires file storage"))
+	}
+	if cfg.CompressMsgsOver < 0 {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("message compression threshold can not be negative"))
+	}
+
+	if cfg.SyncInterval < 0 {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("sync interval can not be negative"))
+	}
+	if (cfg.SyncInterval > 0 || cfg.SyncAlways) && cfg.Storage != FileStorage {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("sync interval and sync always require file storage"))
+	}
+	if cfg.ScrubInterval < 0 {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("scrub interval can not be negative"))
+	}
+	if cfg.ScrubInterval > 0 && cfg.Storage != FileStorage {
+		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("scrub interval requires file storage"))
+	}
+
+	if wal := cfg.MemoryWAL; wal != nil {
+		if cfg.Storage != MemoryStorage {
+			return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("memory WAL requires memory storage"))
+		}
+		if wal.FlushInterval < 0 || wal.MaxBytes < 0 {
+			return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("memory WAL flush interval and max bytes can not be negative"))
+		}
+	}
+
 	if cfg.DenyPurge && cfg.AllowRollup {
 		return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("roll-ups require the purge permission"))
 	}
@@ -1078,7 +1450,12 @@ func (s *Server) checkStreamCfg(config *StreamConfig, acc *Account) (StreamConfi
 			return StreamConfig{}, NewJSMirrorWithSourcesError()
 		}
 		// We do not require other stream to exist anymore, but if we can see it check payloads.
-		exists, maxMsgSize, subs := hasStream(cfg.Mirror.Name)
+		var exists bool
+		var maxMsgSize int32
+		var subs []string
+		if ext := cfg.Mirror.External; ext == nil || ext.Account == _EMPTY_ {
+			exists, maxMsgSize, subs = hasStream(cfg.Mirror.Name)
+		}
 		if len(subs) > 0 {
 			streamSubs = append(streamSubs, subs...)
 		}
@@ -1092,7 +1469,10 @@ func (s *Server) checkStreamCfg(config *StreamConfig, acc *Account) (StreamConfi
 						cfg.Mirror.Name, cfg.Mirror.FilterSubject))
 			}
 		}
-		if cfg.Mirror.External != nil {
+		if err := checkStreamExternalAccount(cfg.Mirror.External, acc); err != nil {
+			return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+		}
+		if cfg.Mirror.External != nil && cfg.Mirror.External.Account == _EMPTY_ {
 			if cfg.Mirror.External.DeliverPrefix != _EMPTY_ {
 				deliveryPrefixes = append(deliveryPrefixes, cfg.Mirror.External.DeliverPrefix)
 			}
@@ -1118,6 +1498,16 @@ func (s *Server) checkStreamCfg(config *StreamConfig, acc *Account) (StreamConfi
 	}
 	if len(cfg.Sources) > 0 {
 		for _, src := range cfg.Sources {
+			if src.Resumable {
+				return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("source '%s' can not be resumable, only mirrors are", src.Name))
+			}
+			// Sources in other accounts are not checked against our own streams.
+			if src.External != nil && src.External.Account != _EMPTY_ {
+				if err := checkStreamExternalAccount(src.External, acc); err != nil {
+					return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+				}
+				continue
+			}
 			exists, maxMsgSize, subs := hasStream(src.Name)
 			if len(subs) > 0 {
 				streamSubs = append(streamSubs, subs...)
@@ -1295,6 +1685,9 @@ func (s *Server) checkStreamCfg(config *StreamConfig, acc *Account) (StreamConfi
 		if _, err := newTransform(cfg.RePublish.Source, cfg.RePublish.Destination); err != nil {
 			return StreamConfig{}, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration for republish not valid"))
 		}
+		if err := checkRePublishHeaders(cfg.RePublish); err != nil {
+			return StreamConfig{}, NewJSStreamInvalidConfigError(err)
+		}
 	}
 
 	return cfg, nil
@@ -1332,14 +1725,26 @@ func (jsa *jsAccount) configUpdateCheck(old, new *StreamConfig, s *Server) (*Str
 	if cfg.MaxConsumers != old.MaxConsumers {
 		return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not change MaxConsumers"))
 	}
-	// Can't change storage types.
+	// Storage types can only be changed for non replicated streams, we will migrate the messages.
 	if cfg.Storage != old.Storage {
-		return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not change storage type"))
+		if cfg.Replicas > 1 || old.Replicas > 1 {
+			return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not change storage type of a replicated stream"))
+		}
+		if cfg.MemoryWAL != nil || old.MemoryWAL != nil {
+			return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not change storage type with memory WAL"))
+		}
+	}
+	// Can't enable or disable the memory WAL.
+	if (cfg.MemoryWAL == nil) != (old.MemoryWAL == nil) {
+		return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not enable or disable memory WAL"))
 	}
 	// Can't change retention.
 	if cfg.Retention != old.Retention {
 		return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not change retention policy"))
 	}
+	if err := checkConsumptionGroupsUpdate(old, &cfg); err != nil {
+		return nil, NewJSStreamInvalidConfigError(err)
+	}
 	// Can not have a template owner for now.
 	if old.Template != _EMPTY_ {
 		return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update not allowed on template owned stream"))
@@ -1359,6 +1764,10 @@ func (jsa *jsAccount) configUpdateCheck(old, new *StreamConfig, s *Server) (*Str
 	if !cfg.DenyPurge && old.DenyPurge {
 		return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not cancel deny purge"))
 	}
+	// Can not change from true to false.
+	if !cfg.DeletionProtection && old.DeletionProtection {
+		return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not cancel deletion protection"))
+	}
 	// Check for mirror changes which are not allowed.
 	if !reflect.DeepEqual(cfg.Mirror, old.Mirror) {
 		return nil, NewJSStreamMirrorNotUpdatableError()
@@ -1367,6 +1776,14 @@ func (jsa *jsAccount) configUpdateCheck(old, new *StreamConfig, s *Server) (*Str
 	if !reflect.DeepEqual(cfg.RePublish, old.RePublish) {
 		return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not change RePublish"))
 	}
+	// Can't change the tier, usage and placement are tied to it.
+	if cfg.Tier != old.Tier {
+		return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not change tier"))
+	}
+	// Can't change partitions.
+	if cfg.Partitions != old.Partitions || cfg.PartitionOf != old.PartitionOf {
+		return nil, NewJSStreamInvalidConfigError(fmt.Errorf("stream configuration update can not change partitions"))
+	}
 
 	// Check on new discard new per subject.
 	if cfg.DiscardNewPer {
@@ -1398,6 +1815,11 @@ func (jsa *jsAccount) configUpdateCheck(old, new *StreamConfig, s *Server) (*Str
 	// If maxBytesDiff == 0, then that means MaxBytes didn't change.
 	// If maxBytesDiff > 0, then we want to reserve additional bytes.
 
+	// When changing storage types we need to reserve everything in the new one.
+	if cfg.Storage != old.Storage {
+		maxBytesDiff = cfg.MaxBytes
+	}
+
 	// Save the user configured MaxBytes.
 	newMaxBytes := cfg.MaxBytes
 
@@ -1438,7 +1860,9 @@ func (jsa *jsAccount) configUpdateCheck(old, new *StreamConfig, s *Server) (*Str
 		_, reserved = tieredStreamAndReservationCount(js.cluster.streams[acc.Name], tier, &cfg)
 	}
 	// reservation does not account for this stream, hence add the old value
-	reserved += int64(old.Replicas) * old.MaxBytes
+	if cfg.Storage == old.Storage {
+		reserved += int64(old.Replicas) * old.MaxBytes
+	}
 	if err := js.checkAllLimits(&selected, &cfg, reserved, maxBytesOffset); err != nil {
 		return nil, err
 	}
@@ -1470,12 +1894,19 @@ func (mset *stream) updateWithAdvisory(config *StreamConfig, sendAdvisory bool)
 	}
 
 	jsa.mu.RLock()
-	if jsa.subjectsOverlap(cfg.Subjects, mset) {
+	if cfg.PartitionOf == _EMPTY_ && jsa.subjectsOverlap(cfg.Subjects, mset) {
 		jsa.mu.RUnlock()
 		return NewJSStreamSubjectOverlapError()
 	}
 	jsa.mu.RUnlock()
 
+	// Move our messages into the new storage type.
+	if cfg.Storage != ocfg.Storage {
+		if err := mset.migrateStore(cfg); err != nil {
+			return err
+		}
+	}
+
 	mset.mu.Lock()
 	if mset.isLeader() {
 		// Now check for subject interest differences.
@@ -1590,6 +2021,49 @@ func (mset *stream) updateWithAdvisory(config *StreamConfig, sendAdvisory bool)
 
 	// Now update config and store's version of our config.
 	mset.cfg = *cfg
+	if !js.isClusteredNoLock() {
+		js.setSourceTokens(mset.acc.Name, cfg.Name, cfg.SourceTokens)
+	}
+	if !reflect.DeepEqual(cfg.RateLimit, ocfg.RateLimit) {
+		mset.setRateLimitLocked(cfg.RateLimit)
+	}
+	if !reflect.DeepEqual(cfg.Catchup, ocfg.Catchup) {
+		mset.setCatchupLimitLocked(cfg.Catchup)
+	}
+	if !reflect.DeepEqual(cfg.Transforms, ocfg.Transforms) {
+		mset.setTransformsLocked(cfg)
+	}
+	if !reflect.DeepEqual(cfg.Schemas, ocfg.Schemas) {
+		mset.setSchemasLocked(cfg)
+	}
+	if cfg.DeleteMarkerTTL != ocfg.DeleteMarkerTTL {
+		mset.loadDeleteMarkersLocked()
+	}
+	if cfg.IdlePause != ocfg.IdlePause {
+		mset.setIdlePauseLocked()
+	}
+	if !reflect.DeepEqual(cfg.Kafka, ocfg.Kafka) {
+		mset.stopKafkaSourceLocked()
+		if mset.active {
+			mset.startKafkaSourceLocked()
+		}
+	}
+	if !reflect.DeepEqual(cfg.Sink, ocfg.Sink) {
+		mset.stopSinkLocked()
+		if mset.active {
+			mset.startSinkLocked()
+		}
+	}
+
+	// Only memory streams persist their dedupe state on their own.
+	if cfg.Storage != ocfg.Storage {
+		if cfg.Storage == FileStorage {
+			mset.stopDedupePersistence(true)
+		} else {
+			mset.setupDedupePersistence(filepath.Join(mset.jsa.storeDir, streamsDir, cfg.Name))
+			mset.dedupeStateChanged()
+		}
+	}
 
 	// If we are the leader never suppress update advisory, simply send.
 	if mset.isLeader() && sendAdvisory {
@@ -1597,7 +2071,10 @@ func (mset *stream) updateWithAdvisory(config *StreamConfig, sendAdvisory bool)
 	}
 	mset.mu.Unlock()
 
-	if js != nil {
+	if js != nil && cfg.Storage != ocfg.Storage {
+		js.releaseStreamResources(&ocfg)
+		js.reserveStreamResources(cfg)
+	} else if js != nil {
 		maxBytesDiff := cfg.MaxBytes - ocfg.MaxBytes
 		if maxBytesDiff > 0 {
 			// Reserve the difference
@@ -1630,10 +2107,12 @@ func (mset *stream) purge(preq *JSApiStreamPurgeRequest) (purged uint64, err err
 		mset.mu.RUnlock()
 		return 0, errors.New("sealed stream")
 	}
-	store := mset.store
+	store, holds := mset.store, holdSubjects(mset.holds)
 	mset.mu.RUnlock()
 
-	if preq != nil {
+	if len(holds) > 0 {
+		purged, err = purgeAroundHolds(store, preq, holds)
+	} else if preq != nil {
 		purged, err = mset.store.PurgeEx(preq.Subject, preq.Sequence, preq.Keep)
 	} else {
 		purged, err = mset.store.Purge()
@@ -1672,6 +2151,57 @@ func (mset *stream) purge(preq *JSApiStreamPurgeRequest) (purged uint64, err err
 	return purged, nil
 }
 
+// Will start an asynchronous compaction up to, but not including, seq.
+// Only supported for non-clustered file based streams.
+func (mset *stream) compactAsync(seq uint64) (*CompactHandle, error) {
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+
+	fs, ok := mset.store.(*fileStore)
+	if !ok || mset.isClustered() {
+		return nil, NewJSStreamCompactNotSupportedError()
+	}
+	if len(mset.holds) > 0 {
+		return nil, NewJSStreamSubjectsOnHoldError()
+	}
+	if h := mset.compactor; h != nil && !h.Progress().Done {
+		return nil, NewJSStreamCompactInProgressError()
+	}
+	h := fs.CompactAsync(seq)
+	mset.compactor = h
+
+	// Once done make sure our consumers are updated.
+	go func() {
+		<-h.Done()
+		var state StreamState
+		fs.FastState(&state)
+		mset.clsMu.RLock()
+		for _, o := range mset.cList {
+			o.purge(state.FirstSeq, state.LastSeq)
+		}
+		mset.clsMu.RUnlock()
+	}()
+	return h, nil
+}
+
+// Returns the last asynchronous compaction, if any.
+func (mset *stream) compaction() *CompactHandle {
+	mset.mu.RLock()
+	defer mset.mu.RUnlock()
+	return mset.compactor
+}
+
+// Returns the progress of rewriting our message blocks to our compression, if any.
+func (mset *stream) recompression() *RecompressProgress {
+	mset.mu.RLock()
+	fs, ok := mset.store.(*fileStore)
+	mset.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return fs.Recompression()
+}
+
 // RemoveMsg will remove a message from a stream.
 // FIXME(dlc) - Should pick one and be consistent.
 func (mset *stream) removeMsg(seq uint64) (bool, error) {
@@ -1680,23 +2210,46 @@ func (mset *stream) removeMsg(seq uint64) (bool, error) {
 
 // DeleteMsg will remove a message from a stream.
 func (mset *stream) deleteMsg(seq uint64) (bool, error) {
+	// Hold our lock so a storage migration can not swap the store underneath us.
 	mset.mu.RLock()
+	defer mset.mu.RUnlock()
 	if mset.client == nil {
-		mset.mu.RUnlock()
 		return false, fmt.Errorf("invalid stream")
 	}
-	mset.mu.RUnlock()
 	return mset.store.RemoveMsg(seq)
 }
 
+// RemoveRange will remove all messages from first to last inclusive from a stream.
+// Returns the number of messages removed.
+func (mset *stream) removeRange(first, last uint64) (uint64, error) {
+	// Hold our lock so a storage migration can not swap the store underneath us.
+	mset.mu.RLock()
+	if mset.client == nil {
+		mset.mu.RUnlock()
+		return 0, fmt.Errorf("invalid stream")
+	}
+	removed, err := mset.store.RemoveRange(first, last)
+	mset.mu.RUnlock()
+	if removed > 0 {
+		// Single removes update our consumers through the store callback, do that here for the range.
+		mset.clsMu.RLock()
+		cList := append([]*consumer(nil), mset.cList...)
+		mset.clsMu.RUnlock()
+		for _, o := range cList {
+			o.decStreamPendingRange(first, last)
+		}
+	}
+	return removed, err
+}
+
 // EraseMsg will securely remove a message and rewrite the data with random data.
 func (mset *stream) eraseMsg(seq uint64) (bool, error) {
+	// Hold our lock so a storage migration can not swap the store underneath us.
 	mset.mu.RLock()
+	defer mset.mu.RUnlock()
 	if mset.client == nil {
-		mset.mu.RUnlock()
 		return false, fmt.Errorf("invalid stream")
 	}
-	mset.mu.RUnlock()
 	return mset.store.EraseMsg(seq)
 }
 
@@ -1865,6 +2418,7 @@ func (mset *stream) sourceInfo(si *sourceInfo) *StreamSourceInfo {
 		ssi.External = &ExternalStream{
 			ApiPrefix:     ext.ApiPrefix,
 			DeliverPrefix: ext.DeliverPrefix,
+			Account:       ext.Account,
 		}
 	}
 	return ssi
@@ -1980,7 +2534,7 @@ func (mset *stream) processInboundMirrorMsg(m *inMsg) bool {
 				needsRetry = true
 			} else if fcReply := getHeader(JSConsumerStalled, m.hdr); len(fcReply) > 0 {
 				// Other side thinks we are stalled, so send flow control reply.
-				mset.outq.sendMsg(string(fcReply), nil)
+				mset.sendSourceReply(string(fcReply))
 			}
 		}
 		mset.mu.Unlock()
@@ -2037,6 +2591,9 @@ func (mset *stream) processInboundMirrorMsg(m *inMsg) bool {
 		}
 	}
 
+	// Checkpoint what we have stored so far if resumable.
+	mset.checkpointMirrorLocked(false)
+
 	js, stype := mset.js, mset.cfg.Storage
 	mset.mu.Unlock()
 
@@ -2205,6 +2762,14 @@ func (mset *stream) setupMirrorConsumer() error {
 	var deliverSubject string
 	ext := mset.cfg.Mirror.External
 
+	acc, err := mset.externalSourceAccount(ext, mset.cfg.Mirror.Name)
+	if err != nil {
+		mirror.err = NewJSMirrorConsumerSetupFailedError(err, Unless(err))
+		mset.scheduleSetupMirrorConsumerRetryAsap()
+		return nil
+	}
+	mirror.acc = acc
+
 	if ext != nil && ext.DeliverPrefix != _EMPTY_ {
 		deliverSubject = strings.ReplaceAll(ext.DeliverPrefix+syncSubject(".M"), "..", ".")
 	} else {
@@ -2217,12 +2782,19 @@ func (mset *stream) setupMirrorConsumer() error {
 	var state StreamState
 	mset.store.FastState(&state)
 
+	// If resumable and we lost messages we had, e.g. a memory mirror that restarted,
+	// resume from our checkpoint instead of from the start.
+	lseq := state.LastSeq
+	if mset.mckseq > lseq {
+		lseq = mset.mckseq
+	}
+
 	req := &CreateConsumerRequest{
 		Stream: mset.cfg.Mirror.Name,
 		Config: ConsumerConfig{
 			DeliverSubject: deliverSubject,
 			DeliverPolicy:  DeliverByStartSequence,
-			OptStartSeq:    state.LastSeq + 1,
+			OptStartSeq:    lseq + 1,
 			AckPolicy:      AckNone,
 			AckWait:        22 * time.Hour,
 			MaxDeliver:     1,
@@ -2233,7 +2805,7 @@ func (mset *stream) setupMirrorConsumer() error {
 	}
 
 	// Only use start optionals on first time.
-	if state.Msgs == 0 && state.FirstSeq == 0 {
+	if state.Msgs == 0 && state.FirstSeq == 0 && lseq == 0 {
 		req.Config.OptStartSeq = 0
 		if mset.cfg.Mirror.OptStartSeq > 0 {
 			req.Config.OptStartSeq = mset.cfg.Mirror.OptStartSeq
@@ -2254,7 +2826,7 @@ func (mset *stream) setupMirrorConsumer() error {
 
 	respCh := make(chan *JSApiConsumerCreateResponse, 1)
 	reply := infoReplySubject()
-	crSub, err := mset.subscribeInternal(reply, func(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	crSub, err := mset.subscribeSourceInternal(mirror, reply, func(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
 		mset.unsubscribeUnlocked(sub)
 		_, msg := c.msgParts(rmsg)
 
@@ -2281,10 +2853,7 @@ func (mset *stream) setupMirrorConsumer() error {
 	} else {
 		subject = fmt.Sprintf(JSApiConsumerCreateT, mset.cfg.Mirror.Name)
 	}
-	if ext != nil {
-		subject = strings.Replace(subject, JSApiPrefix, ext.ApiPrefix, 1)
-		subject = strings.ReplaceAll(subject, "..", ".")
-	}
+	subject = externalConsumerCreateSubject(subject, ext)
 
 	// We need to create the subscription that will receive the messages prior
 	// to sending the consumer create request, because in some complex topologies
@@ -2294,7 +2863,7 @@ func (mset *stream) setupMirrorConsumer() error {
 	// Create a new queue each time
 	mirror.msgs = mset.srv.newIPQueue(qname) /* of *inMsg */
 	msgs := mirror.msgs
-	sub, err := mset.subscribeInternal(deliverSubject, func(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	sub, err := mset.subscribeSourceInternal(mirror, deliverSubject, func(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
 		hdr, msg := c.msgParts(copyBytes(rmsg)) // Need to copy.
 		mset.queueInbound(msgs, subject, reply, hdr, msg)
 	})
@@ -2309,7 +2878,7 @@ func (mset *stream) setupMirrorConsumer() error {
 	mirror.sip = true
 
 	// Send the consumer create request
-	mset.outq.send(newJSPubMsg(subject, _EMPTY_, reply, nil, b, nil, 0))
+	mset.sendSourceConsumerRequest(mirror, ext, subject, reply, b)
 
 	go func() {
 
@@ -2507,6 +3076,14 @@ func (mset *stream) setSourceConsumer(iname string, seq uint64, startTime time.T
 	var deliverSubject string
 	ext := ssi.External
 
+	acc, err := mset.externalSourceAccount(ext, ssi.Name)
+	if err != nil {
+		si.err = NewJSSourceConsumerSetupFailedError(err, Unless(err))
+		mset.scheduleSetSourceConsumerRetryAsap(si, seq, startTime)
+		return
+	}
+	si.acc = acc
+
 	if ext != nil && ext.DeliverPrefix != _EMPTY_ {
 		deliverSubject = strings.ReplaceAll(ext.DeliverPrefix+syncSubject(".S"), "..", ".")
 	} else {
@@ -2559,7 +3136,7 @@ func (mset *stream) setSourceConsumer(iname string, seq uint64, startTime time.T
 
 	respCh := make(chan *JSApiConsumerCreateResponse, 1)
 	reply := infoReplySubject()
-	crSub, err := mset.subscribeInternal(reply, func(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	crSub, err := mset.subscribeSourceInternal(si, reply, func(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
 		mset.unsubscribeUnlocked(sub)
 		_, msg := c.msgParts(rmsg)
 		var ccr JSApiConsumerCreateResponse
@@ -2582,10 +3159,7 @@ func (mset *stream) setSourceConsumer(iname string, seq uint64, startTime time.T
 	} else {
 		subject = fmt.Sprintf(JSApiConsumerCreateT, si.name)
 	}
-	if ext != nil {
-		subject = strings.Replace(subject, JSApiPrefix, ext.ApiPrefix, 1)
-		subject = strings.ReplaceAll(subject, "..", ".")
-	}
+	subject = externalConsumerCreateSubject(subject, ext)
 
 	// Marshal request.
 	b, _ := json.Marshal(req)
@@ -2598,7 +3172,7 @@ func (mset *stream) setSourceConsumer(iname string, seq uint64, startTime time.T
 	// Create a new queue each time
 	si.msgs = mset.srv.newIPQueue(qname) // of *inMsg
 	msgs := si.msgs
-	sub, err := mset.subscribeInternal(deliverSubject, func(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
+	sub, err := mset.subscribeSourceInternal(si, deliverSubject, func(sub *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
 		hdr, msg := c.msgParts(copyBytes(rmsg)) // Need to copy.
 		mset.queueInbound(msgs, subject, reply, hdr, msg)
 	})
@@ -2613,7 +3187,7 @@ func (mset *stream) setSourceConsumer(iname string, seq uint64, startTime time.T
 	si.sip = true
 
 	// Send the consumer create request
-	mset.outq.send(newJSPubMsg(subject, _EMPTY_, reply, nil, b, nil, 0))
+	mset.sendSourceConsumerRequest(si, ext, subject, reply, b)
 
 	go func() {
 
@@ -2743,7 +3317,7 @@ func (m *inMsg) isControlMsg() bool {
 func (mset *stream) sendFlowControlReply(reply string) {
 	mset.mu.RLock()
 	if mset.isLeader() && mset.outq != nil {
-		mset.outq.sendMsg(reply, nil)
+		mset.sendSourceReply(reply)
 	}
 	mset.mu.RUnlock()
 }
@@ -2755,7 +3329,7 @@ func (mset *stream) handleFlowControl(si *sourceInfo, m *inMsg) {
 	if mset.isClustered() {
 		mset.node.Propose(encodeStreamMsg(_EMPTY_, m.rply, m.hdr, nil, 0, 0))
 	} else {
-		mset.outq.sendMsg(m.rply, nil)
+		mset.sendSourceReply(m.rply)
 	}
 }
 
@@ -2794,7 +3368,7 @@ func (mset *stream) processInboundSourceMsg(si *sourceInfo, m *inMsg) bool {
 				mset.retrySourceConsumerAtSeq(si.iname, si.sseq+1)
 			} else if fcReply := getHeader(JSConsumerStalled, m.hdr); len(fcReply) > 0 {
 				// Other side thinks we are stalled, so send flow control reply.
-				mset.outq.sendMsg(string(fcReply), nil)
+				mset.sendSourceReply(string(fcReply))
 			}
 		}
 		mset.mu.Unlock()
@@ -3068,9 +3642,12 @@ func (mset *stream) subscribeToStream() error {
 	if mset.active {
 		return nil
 	}
-	for _, subject := range mset.cfg.Subjects {
-		if _, err := mset.subscribeInternal(subject, mset.processInboundJetStreamMsg); err != nil {
-			return err
+	// Partitions receive messages through their stream.
+	if mset.cfg.PartitionOf == _EMPTY_ {
+		for _, subject := range mset.cfg.Subjects {
+			if _, err := mset.subscribeInternal(subject, mset.processInboundJetStreamMsg); err != nil {
+				return err
+			}
 		}
 	}
 	// Check if we need to setup mirroring.
@@ -3083,6 +3660,8 @@ func (mset *stream) subscribeToStream() error {
 			return err
 		}
 	}
+	mset.startKafkaSourceLocked()
+	mset.startSinkLocked()
 	// Check for direct get access.
 	// We spin up followers for clustered streams in monitorStream().
 	if mset.cfg.AllowDirect {
@@ -3192,6 +3771,8 @@ func (mset *stream) unsubscribeToStream(stopping bool) error {
 	if len(mset.sources) > 0 {
 		mset.stopSourceConsumers()
 	}
+	mset.stopKafkaSourceLocked()
+	mset.stopSinkLocked()
 
 	// In case we had a direct get subscriptions.
 	if stopping {
@@ -3272,6 +3853,11 @@ func (mset *stream) unsubscribe(sub *subscription) {
 	if sub == nil || mset.client == nil {
 		return
 	}
+	// Subscriptions for sources in other accounts are on that account's client.
+	if c := sub.client; c != nil && c != mset.client {
+		c.processUnsub(sub.sid)
+		return
+	}
 	mset.client.processUnsub(sub.sid)
 }
 
@@ -3284,15 +3870,46 @@ func (mset *stream) unsubscribeUnlocked(sub *subscription) {
 func (mset *stream) setupStore(fsCfg *FileStoreConfig) error {
 	mset.mu.Lock()
 	mset.created = time.Now().UTC()
+	store, err := mset.newStoreLocked(&mset.cfg, fsCfg)
+	if err != nil {
+		mset.mu.Unlock()
+		return err
+	}
+	mset.store = store
+	mset.mu.Unlock()
+
+	mset.registerStoreHandlers(store)
 
-	switch mset.cfg.Storage {
+	return nil
+}
+
+// Creates the store for the storage type of the given config.
+// Lock should be held.
+func (mset *stream) newStoreLocked(cfg *StreamConfig, fsCfg *FileStoreConfig) (StreamStore, error) {
+	switch cfg.Storage {
 	case MemoryStorage:
-		ms, err := newMemStore(&mset.cfg)
+		ms, err := newMemStore(cfg)
 		if err != nil {
-			mset.mu.Unlock()
-			return err
+			return nil, err
 		}
-		mset.store = ms
+		if sz := mset.srv.getOpts().JetStreamMemStoreArena; sz > 0 {
+			ms.enableArena(int(sz))
+		}
+		if len(fsCfg.Holds) > 0 {
+			ms.SetHolds(fsCfg.Holds)
+		}
+		if cfg.MemoryWAL != nil {
+			// The log is written in plaintext, so do not allow it for encrypted accounts.
+			if mset.srv.jsKeyGen(mset.acc.Name) != nil {
+				ms.Stop()
+				return nil, fmt.Errorf("memory WAL not supported with encryption")
+			}
+			if err := ms.enableWAL(fsCfg.StoreDir, mset.created); err != nil {
+				ms.Stop()
+				return nil, err
+			}
+		}
+		return ms, nil
 	case FileStorage:
 		s := mset.srv
 		prf := s.jsKeyGen(mset.acc.Name)
@@ -3300,20 +3917,194 @@ func (mset *stream) setupStore(fsCfg *FileStoreConfig) error {
 			// We are encrypted here, fill in correct cipher selection.
 			fsCfg.Cipher = s.getOpts().JetStreamCipher
 		}
-		fs, err := newFileStoreWithCreated(*fsCfg, mset.cfg, mset.created, prf)
+		fsCfg.Tier = s.getOpts().JetStreamTierBackend
+		fsCfg.RecoveryHandler = s.streamRecoveryHandler(mset.acc.Name, cfg.Name)
+		fsCfg.RecoveryWorkers = s.getOpts().JetStreamRecoveryWorkers
+		return newFileStoreWithCreated(*fsCfg, *cfg, mset.created, prf)
+	}
+	return nil, fmt.Errorf("unknown storage type %v", cfg.Storage)
+}
+
+// Registers our callbacks with the store.
+func (mset *stream) registerStoreHandlers(store StreamStore) {
+	store.RegisterStorageUpdates(mset.storeUpdates)
+
+	if mi := mset.srv.interceptors; mi != nil && mi.OnEvict != nil {
+		accName, name := mset.acc.Name, mset.cfg.Name
+		store.RegisterEvictionHandler(func(m *EvictedMsg) bool {
+			return mi.OnEvict(accName, name, m)
+		})
+	}
+}
+
+// migrateStore will copy all messages and consumer state into a new store with
+// the storage type of cfg and swap it in for the current one. The bulk of the
+// messages are copied without the stream lock so we keep storing and removing
+// messages meanwhile. We then take the lock, apply whatever was purged or
+// deleted from what we copied, copy what was stored since and swap the stores.
+// The migration fails if the store was replaced while we did not hold the lock.
+func (mset *stream) migrateStore(cfg *StreamConfig) error {
+	mset.mu.Lock()
+	ostore, jsa := mset.store, mset.jsa
+	var state StreamState
+	ostore.FastState(&state)
+
+	// Make sure we will fit within the account limits for the new storage type.
+	if jsa.storageWouldExceed(cfg.Storage, mset.tier, int64(state.Bytes)) {
+		mset.mu.Unlock()
+		if cfg.Storage == MemoryStorage {
+			return NewJSMemoryResourcesExceededError()
+		}
+		return NewJSStorageResourcesExceededError()
+	}
+
+	fsCfg := &FileStoreConfig{
+		StoreDir:     filepath.Join(jsa.storeDir, streamsDir, cfg.Name),
+		SyncInterval: 2 * time.Minute,
+		Holds:        holdSubjects(mset.holds),
+		FlushCPUs:    mset.srv.getOpts().CPUAffinity.Filestore,
+	}
+	if cfg.Storage == FileStorage {
+		mset.autoTuneFileStorageBlockSize(fsCfg)
+	}
+	nstore, err := mset.newStoreLocked(cfg, fsCfg)
+	mset.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Copy over the messages, keeping sequences and any interior deletes.
+	// The bulk of the copy is done without our lock so we keep taking messages.
+	if state.FirstSeq > 1 {
+		if _, err := nstore.Compact(state.FirstSeq); err != nil {
+			nstore.Delete()
+			return err
+		}
+	}
+	last, err := copyStoreMsgs(ostore, nstore, state.FirstSeq, state.LastSeq)
+	if err != nil {
+		nstore.Delete()
+		return err
+	}
+
+	// Now catch up with what changed while we were copying and swap under our lock.
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+
+	if mset.store != ostore {
+		nstore.Delete()
+		return errors.New("stream store changed during migration")
+	}
+	ostype := mset.stype
+	state = ostore.State()
+	if jsa.storageWouldExceed(cfg.Storage, mset.tier, int64(state.Bytes)) {
+		nstore.Delete()
+		if cfg.Storage == MemoryStorage {
+			return NewJSMemoryResourcesExceededError()
+		}
+		return NewJSStorageResourcesExceededError()
+	}
+	// Anything purged or removed from what we already copied.
+	if state.FirstSeq > 1 {
+		if _, err := nstore.Compact(state.FirstSeq); err != nil {
+			nstore.Delete()
+			return err
+		}
+	}
+	for _, dr := range state.DeletedRanges {
+		if dr.First > last {
+			break
+		}
+		dlast := dr.Last()
+		if dlast > last {
+			dlast = last
+		}
+		if _, err := nstore.RemoveRange(dr.First, dlast); err != nil {
+			nstore.Delete()
+			return err
+		}
+	}
+	first := last + 1
+	if first < state.FirstSeq {
+		first = state.FirstSeq
+	}
+	if _, err := copyStoreMsgs(ostore, nstore, first, state.LastSeq); err != nil {
+		nstore.Delete()
+		return err
+	}
+
+	// Now the consumers. Create all of their stores before swapping any of them.
+	cstores := make(map[*consumer]ConsumerStore, len(mset.consumers))
+	for _, o := range mset.consumers {
+		o.mu.RLock()
+		ocs, ocfg := o.store, o.cfg
+		o.mu.RUnlock()
+		if ocs == nil {
+			continue
+		}
+		ostate, err := ocs.State()
 		if err != nil {
-			mset.mu.Unlock()
+			nstore.Delete()
 			return err
 		}
-		mset.store = fs
+		cs, err := nstore.ConsumerStore(o.name, &ocfg)
+		if err == nil {
+			err = cs.Update(ostate)
+		}
+		if err != nil {
+			nstore.Delete()
+			return err
+		}
+		cstores[o] = cs
+	}
+	for o, cs := range cstores {
+		o.mu.Lock()
+		o.store = cs
+		o.mu.Unlock()
 	}
-	mset.mu.Unlock()
 
-	mset.store.RegisterStorageUpdates(mset.storeUpdates)
+	// Stay frozen if we were.
+	if ostore.IsFrozen() {
+		nstore.SetFrozen(true)
+	}
+
+	// Swap the stores, usage for the old one is released here and the new one
+	// will report its usage when we register with it.
+	ostore.RegisterStorageUpdates(nil)
+	ostore.RegisterEvictionHandler(nil)
+	mset.store, mset.stype = nstore, cfg.Storage
+	mset.registerStoreHandlers(nstore)
+	ostore.Delete()
+	jsa.updateUsage(mset.tier, ostype, -int64(state.Bytes))
 
 	return nil
 }
 
+// Copies the messages in the range from one store into another one, skipping
+// over the ones that are gone. Returns the last sequence copied.
+func copyStoreMsgs(from, to StreamStore, first, last uint64) (uint64, error) {
+	if first == 0 {
+		first = 1
+	}
+	var smv StoreMsg
+	copied := first - 1
+	for seq := first; seq <= last; seq++ {
+		sm, err := from.LoadMsg(seq, &smv)
+		switch {
+		case err == ErrStoreMsgNotFound || err == errDeletedMsg:
+			to.SkipMsg()
+		case err != nil:
+			return copied, err
+		default:
+			if err := to.StoreRawMsg(sm.subj, sm.hdr, sm.msg, seq, sm.ts); err != nil {
+				return copied, err
+			}
+		}
+		copied = seq
+	}
+	return copied, nil
+}
+
 // Called for any updates to the underlying stream. We pass through the bytes to the
 // jetstream account. We do local processing for stream pending for consumers, but only
 // for removals.
@@ -3421,6 +4212,7 @@ func (mset *stream) storeMsgIdLocked(dde *ddentry) {
 	if mset.ddtmr == nil {
 		mset.ddtmr = time.AfterFunc(mset.cfg.Duplicates, mset.purgeMsgIds)
 	}
+	mset.dedupeStateChanged()
 }
 
 // Fast lookup of msgId.
@@ -3500,6 +4292,19 @@ func (mset *stream) queueInboundMsg(subj, rply string, hdr, msg []byte) {
 	mset.queueInbound(mset.msgs, subj, rply, hdr, msg)
 }
 
+// Loads the first message stored at or after start, on filter if set.
+func loadNextMsgFromTime(store StreamStore, start time.Time, filter string, smp *StoreMsg) (*StoreMsg, error) {
+	seq := store.GetSeqFromTime(start)
+	if seq == 0 {
+		return nil, ErrStoreMsgNotFound
+	}
+	if filter == _EMPTY_ {
+		filter = fwcs
+	}
+	sm, _, err := store.LoadNextMsg(filter, subjectHasWildcard(filter), seq, smp)
+	return sm, err
+}
+
 // processDirectGetRequest handles direct get request for stream messages.
 func (mset *stream) processDirectGetRequest(_ *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
 	_, msg := c.msgParts(rmsg)
@@ -3519,7 +4324,7 @@ func (mset *stream) processDirectGetRequest(_ *subscription, c *client, _ *Accou
 		return
 	}
 	// Check if nothing set.
-	if req.Seq == 0 && req.LastFor == _EMPTY_ && req.NextFor == _EMPTY_ {
+	if req.Seq == 0 && req.LastFor == _EMPTY_ && req.NextFor == _EMPTY_ && req.StartTime == nil {
 		hdr := []byte("NATS/1.0 408 Empty Request\r\n\r\n")
 		mset.outq.send(newJSPubMsg(reply, _EMPTY_, _EMPTY_, hdr, nil, nil, 0))
 		return
@@ -3535,6 +4340,11 @@ func (mset *stream) processDirectGetRequest(_ *subscription, c *client, _ *Accou
 		mset.outq.send(newJSPubMsg(reply, _EMPTY_, _EMPTY_, hdr, nil, nil, 0))
 		return
 	}
+	if req.StartTime != nil && (req.Seq > 0 || req.LastFor != _EMPTY_) {
+		hdr := []byte("NATS/1.0 408 Bad Request\r\n\r\n")
+		mset.outq.send(newJSPubMsg(reply, _EMPTY_, _EMPTY_, hdr, nil, nil, 0))
+		return
+	}
 
 	inlineOk := c.kind != ROUTER && c.kind != GATEWAY && c.kind != LEAF
 	if !inlineOk {
@@ -3610,7 +4420,9 @@ func (mset *stream) getDirectRequest(req *JSApiMsgGetRequest, reply string) {
 	store, name := mset.store, mset.cfg.Name
 	mset.mu.RUnlock()
 
-	if req.Seq > 0 && req.NextFor == _EMPTY_ {
+	if req.StartTime != nil {
+		sm, err = loadNextMsgFromTime(store, *req.StartTime, req.NextFor, &svp)
+	} else if req.Seq > 0 && req.NextFor == _EMPTY_ {
 		sm, err = store.LoadMsg(req.Seq, &svp)
 	} else if req.NextFor != _EMPTY_ {
 		sm, _, err = store.LoadNextMsg(req.NextFor, subjectHasWildcard(req.NextFor), req.Seq, &svp)
@@ -3643,6 +4455,7 @@ func (mset *stream) getDirectRequest(req *JSApiMsgGetRequest, reply string) {
 func (mset *stream) processInboundJetStreamMsg(_ *subscription, c *client, _ *Account, subject, reply string, rmsg []byte) {
 	mset.mu.RLock()
 	isLeader, isClustered, isSealed := mset.isLeader(), mset.isClustered(), mset.cfg.Sealed
+	partitions, isFenced := mset.cfg.Partitions, mset.fence != _EMPTY_
 	mset.mu.RUnlock()
 
 	// If we are not the leader just ignore.
@@ -3650,6 +4463,12 @@ func (mset *stream) processInboundJetStreamMsg(_ *subscription, c *client, _ *Ac
 		return
 	}
 
+	// Partitioned streams hand messages to their partitions.
+	if partitions > 0 {
+		mset.processPartitionedMsg(c, subject, reply, rmsg, partitions)
+		return
+	}
+
 	if isSealed {
 		var resp = JSPubAckResponse{
 			PubAck: &PubAck{Stream: mset.name()},
@@ -3660,14 +4479,55 @@ func (mset *stream) processInboundJetStreamMsg(_ *subscription, c *client, _ *Ac
 		return
 	}
 
+	// Writes are held off while taking a backup point.
+	if isFenced {
+		var resp = JSPubAckResponse{
+			PubAck: &PubAck{Stream: mset.name()},
+			Error:  NewJSStreamFencedError(),
+		}
+		b, _ := json.Marshal(resp)
+		mset.outq.sendMsg(reply, b)
+		return
+	}
+
 	hdr, msg := c.msgParts(rmsg)
 
+	// Check our rate limit. Messages held back are queued, anything
+	// after them needs to wait its turn as well.
+	if delay, hold, ok := mset.checkRateLimit(len(hdr) + len(msg)); !ok {
+		mset.sendRateLimitExceeded(reply)
+		return
+	} else if hold {
+		mset.deferInboundMsg(subject, reply, hdr, msg, delay)
+		return
+	}
+
 	// If we are not receiving directly from a client we should move this to another Go routine.
 	if c.kind != CLIENT {
 		mset.queueInboundMsg(subject, reply, hdr, msg)
 		return
 	}
 
+	// Run through our transforms.
+	subject, hdr, msg, err := mset.applyTransforms(subject, hdr, msg)
+	if err != nil {
+		mset.sendTransformRejected(reply)
+		return
+	}
+	if err := mset.validateSchema(subject, msg); err != nil {
+		mset.sendSchemaRejected(reply, err)
+		return
+	}
+
+	// Offload large payloads and divert oversize messages if configured.
+	hdr, msg = mset.offloadPayload(subject, hdr, msg)
+	hdr, msg = mset.divertOversizeMsg(subject, hdr, msg)
+
+	// Store payloads over our chunk size as chunks.
+	if mset.processChunkedMsg(subject, reply, hdr, msg, isClustered) {
+		return
+	}
+
 	// If we are clustered we need to propose this message to the underlying raft group.
 	if isClustered {
 		mset.processClusteredInboundMsg(subject, reply, hdr, msg)
@@ -3683,6 +4543,22 @@ var (
 
 // processJetStreamMsg is where we try to actually process the stream msg.
 func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte, lseq uint64, ts int64) error {
+	mset.noteActivity()
+
+	// Check with any interceptor registered by an embedding application. This calls
+	// into the application so is done before we lock, a rejection is handled below.
+	var rejected error
+	if mi := mset.srv.interceptors; mi != nil && mi.OnStore != nil {
+		mset.mu.RLock()
+		name, acc := mset.cfg.Name, mset.acc
+		mset.mu.RUnlock()
+		var accName string
+		if acc != nil {
+			accName = acc.Name
+		}
+		rejected = mi.OnStore(name, &InterceptedMsg{Account: accName, Subject: subject, Reply: reply, Header: hdr, Data: msg})
+	}
+
 	mset.mu.Lock()
 	c, s, store := mset.client, mset.srv, mset.store
 	if c == nil {
@@ -3787,9 +4663,8 @@ func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte,
 			}
 		}
 		// Expected last sequence per subject.
-		// If we are clustered we have prechecked seq > 0.
-		if seq, exists := getExpectedLastSeqPerSubject(hdr); exists && (!isClustered || seq == 0) {
-			// TODO(dlc) - We could make a new store func that does this all in one.
+		// If we are clustered we have prechecked seq > 0, otherwise the store checks when we store below.
+		if seq, exists := getExpectedLastSeqPerSubject(hdr); exists && isClustered && seq == 0 {
 			var smv StoreMsg
 			var fseq uint64
 			sm, err := store.LoadLastMsg(subject, &smv)
@@ -3866,6 +4741,18 @@ func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte,
 				return fmt.Errorf("rollup value invalid: %q", rollup)
 			}
 		}
+		// Check for a valid schedule.
+		if _, err := scheduledAt(hdr, 0); err != nil {
+			mset.clfs++
+			mset.mu.Unlock()
+			if canRespond {
+				resp.PubAck = &PubAck{Stream: name}
+				resp.Error = NewJSMessageScheduleInvalidError(err)
+				b, _ := json.Marshal(resp)
+				outq.sendMsg(reply, b)
+			}
+			return err
+		}
 	}
 
 	// Response Ack.
@@ -3900,6 +4787,19 @@ func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte,
 		return ErrMaxPayload
 	}
 
+	// Rejected by an interceptor.
+	if rejected != nil {
+		mset.clfs++
+		mset.mu.Unlock()
+		if canRespond {
+			resp.PubAck = &PubAck{Stream: name}
+			resp.Error = NewJSStreamMessageRejectedError(rejected)
+			response, _ = json.Marshal(resp)
+			mset.outq.sendMsg(reply, response)
+		}
+		return rejected
+	}
+
 	// Check to see if we have exceeded our limits.
 	if js.limitsExceeded(stype) {
 		s.resourcesExeededError()
@@ -3974,10 +4874,14 @@ func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte,
 	var thdrsOnly bool
 	if mset.tr != nil {
 		tsubj, _ = mset.tr.Match(subject)
-		if mset.cfg.RePublish != nil {
-			thdrsOnly = mset.cfg.RePublish.HeadersOnly
+		if rp := mset.cfg.RePublish; rp != nil {
+			thdrsOnly = rp.HeadersOnly
+			if tsubj != _EMPTY_ && len(rp.HeaderFilter) > 0 && !rp.matchesHeaders(hdr) {
+				tsubj = _EMPTY_
+			}
 		}
 	}
+	rpHdrs := mset.rpHdrs
 	republish := tsubj != _EMPTY_ && isLeader
 
 	// If we are republishing grab last sequence for this exact subject. Aids in gap detection for lightweight clients.
@@ -3988,13 +4892,21 @@ func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte,
 		}
 	}
 
+	// Compress large payloads, we keep the originals for republishing.
+	shdr, smsg := compressMsg(mset.cfg.CompressMsgsOver, hdr, msg)
+
 	// Store actual msg.
 	if lseq == 0 && ts == 0 {
-		seq, ts, err = store.StoreMsg(subject, hdr, msg)
+		// Have the store check the expected last sequence per subject so it can not change underneath us.
+		if elseq, exists := getExpectedLastSeqPerSubject(hdr); exists && !mset.isClustered() {
+			seq, ts, err = store.StoreMsgIfLastSubjSeq(subject, shdr, smsg, elseq)
+		} else {
+			seq, ts, err = store.StoreMsg(subject, shdr, smsg)
+		}
 	} else {
 		// Make sure to take into account any message assignments that we had to skip (clfs).
 		seq = lseq + 1 - clfs
-		err = store.StoreRawMsg(subject, hdr, msg, seq, ts)
+		err = store.StoreRawMsg(subject, shdr, smsg, seq, ts)
 	}
 
 	if err != nil {
@@ -4007,7 +4919,8 @@ func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte,
 		mset.mu.Unlock()
 
 		switch err {
-		case ErrMaxMsgs, ErrMaxBytes, ErrMaxMsgsPerSubject, ErrMsgTooLarge:
+		case ErrLastSubjSeqMismatch:
+		case ErrMaxMsgs, ErrMaxBytes, ErrMaxMsgsPerSubject, ErrMsgTooLarge, ErrStoreFrozen:
 			s.Debugf("JetStream failed to store a msg on stream '%s > %s': %v", accName, name, err)
 		case ErrStoreClosed:
 		default:
@@ -4016,7 +4929,18 @@ func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte,
 
 		if canRespond {
 			resp.PubAck = &PubAck{Stream: name}
-			resp.Error = NewJSStreamStoreFailedError(err, Unless(err))
+			if err == ErrLastSubjSeqMismatch {
+				var smv StoreMsg
+				var fseq uint64
+				if sm, _ := store.LoadLastMsg(subject, &smv); sm != nil {
+					fseq = sm.seq
+				}
+				resp.Error = NewJSStreamWrongLastSequenceError(fseq)
+			} else if err == ErrStoreFrozen {
+				resp.Error = NewJSStreamFrozenError()
+			} else {
+				resp.Error = NewJSStreamStoreFailedError(err, Unless(err))
+			}
 			response, _ = json.Marshal(resp)
 			mset.outq.sendMsg(reply, response)
 		}
@@ -4050,9 +4974,25 @@ func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte,
 		mset.storeMsgIdLocked(&ddentry{msgId, seq, ts})
 	}
 
+	// Withhold the message from consumers if it is scheduled.
+	if len(hdr) > 0 {
+		if due, _ := scheduledAt(hdr, ts); due > ts {
+			mset.scheduleMsgLocked(seq, due)
+		}
+		if mset.cfg.DeleteMarkerTTL > 0 && isDeleteMarker(hdr) {
+			mset.trackDeleteMarkerLocked(seq, ts)
+		}
+	}
+
 	// If here we succeeded in storing the message.
+	mset.hist.record(time.Now(), 1, uint64(len(hdr)+len(msg)))
+	softWarn, softCrossed := mset.checkSoftLimitsLocked()
 	mset.mu.Unlock()
 
+	if len(softCrossed) > 0 && isLeader {
+		mset.sendSoftLimitAdvisories(softCrossed)
+	}
+
 	// No errors, this is the normal path.
 	if rollupSub {
 		mset.purge(&JSApiStreamPurgeRequest{Subject: subject, Keep: 1})
@@ -4084,6 +5024,9 @@ func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte,
 				hdr = genHeader(hdr, JSMsgSize, strconv.Itoa(len(msg)))
 			}
 		}
+		for i := 0; i < len(rpHdrs); i += 2 {
+			hdr = genHeader(removeHeaderIfPresent(hdr, rpHdrs[i]), rpHdrs[i], rpHdrs[i+1])
+		}
 		mset.outq.send(newJSPubMsg(tsubj, _EMPTY_, _EMPTY_, copyBytes(hdr), rpMsg, nil, seq))
 	}
 
@@ -4091,7 +5034,12 @@ func (mset *stream) processJetStreamMsg(subject, reply string, hdr, msg []byte,
 	if canRespond {
 		response = append(pubAck, strconv.FormatUint(seq, 10)...)
 		response = append(response, '}')
-		mset.outq.sendMsg(reply, response)
+		if softWarn != _EMPTY_ {
+			shdr := genHeader(nil, JSSoftLimit, softWarn)
+			mset.outq.send(newJSPubMsg(reply, _EMPTY_, _EMPTY_, shdr, copyBytes(response), nil, 0))
+		} else {
+			mset.outq.sendMsg(reply, response)
+		}
 	}
 
 	// Signal consumers for new messages.
@@ -4210,7 +5158,7 @@ func newJSPubMsg(dsubj, subj, reply string, hdr, msg []byte, o *consumer, seq ui
 	// When getting something from a pool it is criticical that all fields are
 	// initialized. Doing this way guarantees that if someone adds a field to
 	// the structure, the compiler will fail the build if this line is not updated.
-	(*m) = jsPubMsg{dsubj, reply, StoreMsg{subj, hdr, msg, buf, seq, 0}, o}
+	(*m) = jsPubMsg{dsubj, reply, StoreMsg{subj, hdr, msg, buf, seq, 0, nil}, o}
 
 	return m
 }
@@ -4316,7 +5264,7 @@ func (mset *stream) internalLoop() {
 	c := s.createInternalJetStreamClient()
 	c.registerWithAccount(mset.acc)
 	defer c.closeConnection(ClientClosed)
-	outq, qch, msgs := mset.outq, mset.qch, mset.msgs
+	outq, qch, msgs, acc := mset.outq, mset.qch, mset.msgs, mset.acc
 
 	// For the ack msgs queue for interest retention.
 	var (
@@ -4338,6 +5286,8 @@ func (mset *stream) internalLoop() {
 			pms := outq.pop()
 			for _, pmi := range pms {
 				pm := pmi.(*jsPubMsg)
+				// Put back any offloaded payload.
+				rematerializePayload(acc, pm)
 				c.pa.subject = []byte(pm.dsubj)
 				c.pa.deliver = []byte(pm.subj)
 				c.pa.size = len(pm.msg) + len(pm.hdr)
@@ -4392,6 +5342,26 @@ func (mset *stream) internalLoop() {
 			for _, imi := range ims {
 				im := imi.(*inMsg)
 
+				// Run through our transforms.
+				var err error
+				if im.subj, im.hdr, im.msg, err = mset.applyTransforms(im.subj, im.hdr, im.msg); err != nil {
+					mset.sendTransformRejected(im.rply)
+					continue
+				}
+				if err = mset.validateSchema(im.subj, im.msg); err != nil {
+					mset.sendSchemaRejected(im.rply, err)
+					continue
+				}
+
+				// Offload large payloads and divert oversize messages if configured.
+				im.hdr, im.msg = mset.offloadPayload(im.subj, im.hdr, im.msg)
+				im.hdr, im.msg = mset.divertOversizeMsg(im.subj, im.hdr, im.msg)
+
+				// Store payloads over our chunk size as chunks.
+				if mset.processChunkedMsg(im.subj, im.rply, im.hdr, im.msg, isClustered) {
+					continue
+				}
+
 				// If we are clustered we need to propose this message to the underlying raft group.
 				if isClustered {
 					mset.processClusteredInboundMsg(im.subj, im.rply, im.hdr, im.msg)
@@ -4438,6 +5408,10 @@ func (mset *stream) stop(deleteFlag, advisory bool) error {
 	accName := jsa.account.Name
 	jsa.mu.Unlock()
 
+	if deleteFlag && !js.isClusteredNoLock() {
+		js.setSourceTokens(accName, mset.cfg.Name, nil)
+	}
+
 	// Clean up consumers.
 	mset.mu.Lock()
 	var obs []*consumer
@@ -4480,6 +5454,10 @@ func (mset *stream) stop(deleteFlag, advisory bool) error {
 		mset.srv.sysUnsubscribe(mset.infoSub)
 		mset.infoSub = nil
 	}
+	if mset.psyncSub != nil {
+		mset.srv.sysUnsubscribe(mset.psyncSub)
+		mset.psyncSub = nil
+	}
 
 	// Cluster cleanup
 	var sa *streamAssignment
@@ -4504,6 +5482,7 @@ func (mset *stream) stop(deleteFlag, advisory bool) error {
 		close(mset.qch)
 		mset.qch = nil
 	}
+	mset.stopHeld()
 
 	c := mset.client
 	mset.client = nil
@@ -4512,6 +5491,20 @@ func (mset *stream) stop(deleteFlag, advisory bool) error {
 		return nil
 	}
 
+	// Write out or remove any persisted dedupe state.
+	mset.stopDedupePersistence(deleteFlag)
+	mset.stopMirrorCheckpoint(deleteFlag)
+	// Memory streams keep their holds outside of their store.
+	if deleteFlag && mset.holdsFile != _EMPTY_ {
+		os.Remove(mset.holdsFile)
+	}
+
+	// Stop delivering scheduled messages.
+	mset.stopScheduleLocked()
+	mset.stopDeleteMarkersLocked()
+	mset.stopIdleLocked()
+	mset.stopDeferredMsgs()
+
 	// Cleanup duplicate timer if running.
 	if mset.ddtmr != nil {
 		mset.ddtmr.Stop()
@@ -4757,6 +5750,55 @@ func (mset *stream) state() StreamState {
 	return mset.stateWithDetail(false)
 }
 
+// Returns the per minute history for the stream and its consumers.
+func (mset *stream) history() *StreamHistory {
+	now := time.Now()
+	mset.mu.RLock()
+	sh := &StreamHistory{Msgs: mset.hist.samples(now)}
+	mset.mu.RUnlock()
+
+	for _, o := range mset.getPublicConsumers() {
+		o.mu.RLock()
+		name, samples := o.name, o.hist.samples(now)
+		o.mu.RUnlock()
+		if sh.Acks == nil {
+			sh.Acks = make(map[string][]StatsSample)
+		}
+		sh.Acks[name] = samples
+	}
+	return sh
+}
+
+// Returns the rolling rates from our store.
+func (mset *stream) storeStats() *StoreStats {
+	mset.mu.RLock()
+	store := mset.store
+	mset.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+	ss := store.StoreStats()
+	return &ss
+}
+
+// setFrozen will freeze or thaw our store.
+func (mset *stream) setFrozen(frozen bool) {
+	mset.mu.RLock()
+	store := mset.store
+	mset.mu.RUnlock()
+	if store != nil {
+		store.SetFrozen(frozen)
+	}
+}
+
+// isFrozen returns if our store is frozen.
+func (mset *stream) isFrozen() bool {
+	mset.mu.RLock()
+	store := mset.store
+	mset.mu.RUnlock()
+	return store != nil && store.IsFrozen()
+}
+
 func (mset *stream) stateWithDetail(details bool) StreamState {
 	mset.mu.RLock()
 	c, store := mset.client, mset.store
@@ -4780,10 +5822,13 @@ func (mset *stream) Store() StreamStore {
 	return mset.store
 }
 
-// Determines if the new proposed partition is unique amongst all consumers.
+// Determines if the new proposed partition is unique amongst all consumers of a consumption group.
 // Lock should be held.
-func (mset *stream) partitionUnique(partition string) bool {
+func (mset *stream) partitionUnique(group, partition string) bool {
 	for _, o := range mset.consumers {
+		if o.cfg.ConsumptionGroup != group {
+			continue
+		}
 		if o.cfg.FilterSubject == _EMPTY_ {
 			return false
 		}
@@ -4844,8 +5889,13 @@ func (mset *stream) ackMsg(o *consumer, seq uint64) {
 	case WorkQueuePolicy:
 		// Normally we just remove a message when its ack'd here but if we have direct consumers
 		// from sources and/or mirrors we need to make sure they have delivered the msg.
+		// With consumption groups every group needs to have acknowledged it.
 		mset.mu.RLock()
-		shouldRemove = mset.directs <= 0 || !mset.checkInterest(seq, o)
+		if len(mset.cfg.ConsumptionGroups) > 0 {
+			shouldRemove = !mset.groupsNeedMsg(seq, o)
+		} else {
+			shouldRemove = mset.directs <= 0 || !mset.checkInterest(seq, o)
+		}
 		mset.mu.RUnlock()
 	case InterestPolicy:
 		mset.mu.RLock()
@@ -4853,7 +5903,7 @@ func (mset *stream) ackMsg(o *consumer, seq uint64) {
 		mset.mu.RUnlock()
 	}
 
-	if shouldRemove {
+	if shouldRemove && !mset.holdAcked(seq) {
 		if _, err := mset.store.RemoveMsg(seq); err == ErrStoreEOF {
 			// This should be rare but I have seen it.
 			// The ack reached us before the actual msg with AckNone and InterestPolicy.
@@ -5080,10 +6130,17 @@ func (mset *stream) checkForOrphanMsgs() {
 	// Grabs stream state.
 	var state StreamState
 	mset.store.FastState(&state)
-	s, acc := mset.srv, mset.acc
+	s, acc, hold := mset.srv, mset.acc, mset.cfg.InterestHold
 	mset.mu.RUnlock()
 
 	if ackFloor > state.FirstSeq {
+		// We do not know when these were acknowledged, so hold all of them for the full hold.
+		if hold > 0 {
+			time.AfterFunc(hold, func() {
+				mset.purge(&JSApiStreamPurgeRequest{Sequence: ackFloor + 1})
+			})
+			return
+		}
 		req := &JSApiStreamPurgeRequest{Sequence: ackFloor + 1}
 		purged, err := mset.purge(req)
 		if err != nil {
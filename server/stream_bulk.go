@@ -0,0 +1,537 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Operations of a bulk request, the last token of JSApiStreamBulk.
+const (
+	JSStreamBulkCreate = "CREATE"
+	JSStreamBulkUpdate = "UPDATE"
+	JSStreamBulkDelete = "DELETE"
+)
+
+// JSMaxStreamBulk is the maximum number of streams in a bulk request.
+const JSMaxStreamBulk = 1000
+
+var (
+	// How long we wait for the assignments of a bulk request to be applied.
+	streamBulkTimeout = 10 * time.Second
+	// How often we check if the assignments of a bulk request were applied.
+	streamBulkCheckInterval = 50 * time.Millisecond
+)
+
+// Returns the names of the streams of a bulk request.
+func (req *JSApiStreamBulkRequest) streamNames() []string {
+	if len(req.Names) > 0 {
+		return req.Names
+	}
+	names := make([]string, 0, len(req.Streams))
+	for _, cfg := range req.Streams {
+		names = append(names, cfg.Name)
+	}
+	return names
+}
+
+// Checks the shape of a bulk request. The streams themselves are checked when processed.
+// Returns the name of the offending stream, if any.
+func checkStreamBulkRequest(op string, req *JSApiStreamBulkRequest) (string, *ApiError) {
+	switch op {
+	case JSStreamBulkCreate, JSStreamBulkUpdate:
+		if len(req.Names) > 0 {
+			return _EMPTY_, NewJSStreamBulkInvalidError(errors.New("names are only used to delete streams"))
+		}
+		for _, cfg := range req.Streams {
+			if cfg == nil {
+				return _EMPTY_, NewJSStreamBulkInvalidError(errors.New("stream configuration missing"))
+			}
+			// Partitions need their own streams created, so are done one at a time.
+			if op == JSStreamBulkCreate && cfg.Partitions > 0 {
+				return cfg.Name, NewJSStreamBulkInvalidError(errors.New("partitioned streams can not be created in bulk"))
+			}
+		}
+	case JSStreamBulkDelete:
+		if len(req.Streams) > 0 {
+			return _EMPTY_, NewJSStreamBulkInvalidError(errors.New("stream configurations are only used to create or update streams"))
+		}
+	default:
+		return _EMPTY_, NewJSStreamBulkInvalidError(fmt.Errorf("unknown operation %q", op))
+	}
+
+	names := req.streamNames()
+	if len(names) == 0 {
+		return _EMPTY_, NewJSStreamBulkInvalidError(errors.New("no streams"))
+	}
+	if len(names) > JSMaxStreamBulk {
+		return _EMPTY_, NewJSStreamBulkInvalidError(fmt.Errorf("more than %d streams", JSMaxStreamBulk))
+	}
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if name == _EMPTY_ {
+			return _EMPTY_, NewJSStreamBulkInvalidError(errors.New("stream name is required"))
+		}
+		if _, ok := seen[name]; ok {
+			return name, NewJSStreamBulkInvalidError(fmt.Errorf("stream %q listed more than once", name))
+		}
+		seen[name] = struct{}{}
+	}
+	return _EMPTY_, nil
+}
+
+// Processes a bulk request when not clustered. All streams are checked before any
+// is changed. Should creating or updating a stream still fail, the streams done
+// before it are put back. Deletes stop at the first stream failing to be deleted.
+func (s *Server) jsStreamBulkLocal(acc *Account, op string, req *JSApiStreamBulkRequest) (string, *ApiError) {
+	switch op {
+	case JSStreamBulkCreate:
+		return s.jsStreamBulkCreateLocal(acc, req.Streams)
+	case JSStreamBulkUpdate:
+		return s.jsStreamBulkUpdateLocal(acc, req.Streams)
+	default:
+		return s.jsStreamBulkDeleteLocal(acc, req.Names)
+	}
+}
+
+func (s *Server) jsStreamBulkCreateLocal(acc *Account, configs []*StreamConfig) (string, *ApiError) {
+	cfgs := make([]*StreamConfig, 0, len(configs))
+	for _, config := range configs {
+		if apiErr := s.checkStreamCreateCfg(config); apiErr != nil {
+			return config.Name, apiErr
+		}
+		cfg, apiErr := s.checkStreamCfg(config, acc)
+		if apiErr != nil {
+			return config.Name, apiErr
+		}
+		if mset, err := acc.lookupStream(cfg.Name); err == nil {
+			// Streams that exist with the same config are left as is.
+			if reflect.DeepEqual(mset.config(), cfg) {
+				continue
+			}
+			return cfg.Name, NewJSStreamNameExistError()
+		}
+		if apiErr := acc.jsNonClusteredStreamLimitsCheck(&cfg); apiErr != nil {
+			return cfg.Name, apiErr
+		}
+		cfgs = append(cfgs, &cfg)
+	}
+
+	created := make([]*stream, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		mset, err := acc.addStream(cfg)
+		if err != nil {
+			for _, mset := range created {
+				mset.delete()
+			}
+			if IsNatsErr(err, JSStreamStoreFailedF) {
+				s.Warnf("Stream create failed for '%s > %s': %v", acc, cfg.Name, err)
+				err = errStreamStoreFailed
+			}
+			return cfg.Name, NewJSStreamCreateError(err, Unless(err))
+		}
+		created = append(created, mset)
+	}
+	// Drop any history left from prior streams with the same names.
+	for _, mset := range created {
+		mset.jsa.resetStreamConfigHistory(mset.name())
+	}
+	return _EMPTY_, nil
+}
+
+func (s *Server) jsStreamBulkUpdateLocal(acc *Account, configs []*StreamConfig) (string, *ApiError) {
+	_, jsa, err := acc.checkForJetStream()
+	if err != nil {
+		return _EMPTY_, NewJSNotEnabledForAccountError()
+	}
+	cfgs := make([]*StreamConfig, 0, len(configs))
+	ocfgs := make([]*StreamConfig, 0, len(configs))
+	for _, config := range configs {
+		mset, err := acc.lookupStream(config.Name)
+		if err != nil {
+			return config.Name, NewJSStreamNotFoundError(Unless(err))
+		}
+		ocfg := mset.config()
+		if ocfg.PartitionOf != _EMPTY_ {
+			return config.Name, NewJSStreamUpdateError(errors.New("stream partitions are updated through their stream"))
+		}
+		cfg, err := jsa.configUpdateCheck(&ocfg, config, s)
+		if err != nil {
+			return config.Name, NewJSStreamUpdateError(err, Unless(err))
+		}
+		cfgs, ocfgs = append(cfgs, cfg), append(ocfgs, &ocfg)
+	}
+
+	for i, cfg := range cfgs {
+		if _, apiErr := s.jsStreamUpdateLocal(acc, cfg); apiErr != nil {
+			// Put back the streams we already updated.
+			for j := i - 1; j >= 0; j-- {
+				if _, rerr := s.jsStreamUpdateLocal(acc, ocfgs[j]); rerr != nil {
+					s.Warnf("Failed to revert bulk update of stream '%s > %s': %v", acc, ocfgs[j].Name, rerr)
+				}
+			}
+			return cfg.Name, apiErr
+		}
+	}
+	return _EMPTY_, nil
+}
+
+func (s *Server) jsStreamBulkDeleteLocal(acc *Account, names []string) (string, *ApiError) {
+	msets := make([]*stream, 0, len(names))
+	for _, name := range names {
+		mset, err := acc.lookupStream(name)
+		if err != nil {
+			return name, NewJSStreamNotFoundError(Unless(err))
+		}
+		cfg := mset.config()
+		if apiErr := acc.checkStreamUnlocked(&cfg); apiErr != nil {
+			return name, apiErr
+		}
+		if cfg.PartitionOf != _EMPTY_ {
+			return name, NewJSStreamDeleteError(errors.New("stream partitions are deleted with their stream"))
+		}
+		msets = append(msets, mset)
+	}
+
+	// Accounts with a trash retention keep deleted file based streams around so they can be restored.
+	retain := acc.trashRetention() > 0
+	for _, mset := range msets {
+		trash := retain && mset.config().Storage == FileStorage
+		if retain && !trash {
+			s.Warnf("Memory stream '%s > %s' deleted without keeping it in the trash", acc.Name, mset.name())
+		}
+		err := mset.removePartitions(trash)
+		if err == nil {
+			if trash {
+				err = mset.trash()
+			} else {
+				err = mset.delete()
+			}
+		}
+		if err != nil {
+			return mset.name(), NewJSStreamDeleteError(err, Unless(err))
+		}
+	}
+	return _EMPTY_, nil
+}
+
+// Process a clustered bulk request. All streams are checked before any assignment is
+// proposed, and the assignments are proposed in a single append entry to the meta
+// group, so they are applied all together or not at all. We respond once the meta
+// leader applied them.
+func (s *Server) jsClusteredStreamBulkRequest(ci *ClientInfo, acc *Account, op, subject, reply string, rmsg []byte, req *JSApiStreamBulkRequest) {
+	js, cc := s.getJetStreamCluster()
+	if js == nil || cc == nil {
+		return
+	}
+
+	var resp = JSApiStreamBulkResponse{ApiResponse: ApiResponse{Type: JSApiStreamBulkResponseType}}
+
+	var applied func() bool
+	switch op {
+	case JSStreamBulkCreate:
+		applied, resp.Failed, resp.Error = s.jsClusteredStreamBulkCreate(ci, acc, subject, req.Streams)
+	case JSStreamBulkUpdate:
+		applied, resp.Failed, resp.Error = s.jsClusteredStreamBulkUpdate(ci, acc, subject, req.Streams)
+	default:
+		applied, resp.Failed, resp.Error = s.jsClusteredStreamBulkDelete(ci, acc, subject, req.Names)
+	}
+	if resp.Error == nil && !js.waitForStreamBulk(applied) {
+		resp.Error = NewJSStreamBulkError(errors.New("timeout waiting for the streams to be assigned"))
+	}
+	if resp.Error != nil {
+		s.sendAPIErrResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(&resp))
+		return
+	}
+	resp.Streams = req.streamNames()
+	s.sendAPIResponse(ci, acc, subject, reply, string(rmsg), s.jsonResponse(resp))
+}
+
+// Waits for the assignments of a bulk request to be applied.
+func (js *jetStream) waitForStreamBulk(applied func() bool) bool {
+	check := func() bool {
+		js.mu.RLock()
+		defer js.mu.RUnlock()
+		return applied()
+	}
+	deadline := time.NewTimer(streamBulkTimeout)
+	defer deadline.Stop()
+	t := time.NewTicker(streamBulkCheckInterval)
+	defer t.Stop()
+	for !check() {
+		select {
+		case <-deadline.C:
+			return false
+		case <-js.srv.quitCh:
+			return false
+		case <-t.C:
+		}
+	}
+	return true
+}
+
+// Proposes the assignments of a bulk request in a single append entry.
+// Lock should be held.
+func (cc *jetStreamCluster) proposeStreamBulk(entries [][]byte) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ents := make([]*Entry, 0, len(entries))
+	for _, data := range entries {
+		ents = append(ents, &Entry{EntryNormal, data})
+	}
+	return cc.meta.ProposeDirect(ents)
+}
+
+// Returns the name of the first stream whose subjects overlap with another stream of
+// the bulk request, or with a stream of the account not part of the request.
+// Lock should be held.
+func (cc *jetStreamCluster) streamBulkSubjectsOverlap(accName string, cfgs []*StreamConfig) string {
+	bulk := make(map[string]struct{}, len(cfgs))
+	for _, cfg := range cfgs {
+		bulk[cfg.Name] = struct{}{}
+	}
+	for i, cfg := range cfgs {
+		for name, sa := range cc.streams[accName] {
+			if _, ok := bulk[name]; ok {
+				continue
+			}
+			for _, subj := range sa.Config.Subjects {
+				for _, tsubj := range cfg.Subjects {
+					if SubjectsCollide(tsubj, subj) {
+						return cfg.Name
+					}
+				}
+			}
+		}
+		for _, ocfg := range cfgs[:i] {
+			for _, subj := range ocfg.Subjects {
+				for _, tsubj := range cfg.Subjects {
+					if SubjectsCollide(tsubj, subj) {
+						return cfg.Name
+					}
+				}
+			}
+		}
+	}
+	return _EMPTY_
+}
+
+func (s *Server) jsClusteredStreamBulkCreate(ci *ClientInfo, acc *Account, subject string, configs []*StreamConfig) (func() bool, string, *ApiError) {
+	js, cc := s.getJetStreamCluster()
+
+	cfgs := make([]*StreamConfig, 0, len(configs))
+	for _, config := range configs {
+		if apiErr := s.checkStreamCreateCfg(config); apiErr != nil {
+			return nil, config.Name, apiErr
+		}
+		cfg, apiErr := s.checkStreamCfg(config, acc)
+		if apiErr != nil {
+			return nil, config.Name, apiErr
+		}
+		cfgs = append(cfgs, &cfg)
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if cc.inflight == nil {
+		cc.inflight = make(map[string]map[string]*raftGroup)
+	}
+	streams, ok := cc.inflight[acc.Name]
+	if !ok {
+		streams = make(map[string]*raftGroup)
+		cc.inflight[acc.Name] = streams
+	}
+	// The streams are added as inflight proposals as we go, so the stream limits count them.
+	var inflight []string
+	fail := func(name string, apiErr *ApiError) (func() bool, string, *ApiError) {
+		for _, name := range inflight {
+			cc.removeInflightProposal(acc.Name, name)
+		}
+		if len(streams) == 0 {
+			delete(cc.inflight, acc.Name)
+		}
+		return nil, name, apiErr
+	}
+
+	if name := cc.streamBulkSubjectsOverlap(acc.Name, cfgs); name != _EMPTY_ {
+		return fail(name, NewJSStreamSubjectOverlapError())
+	}
+
+	created := time.Now().UTC()
+	var entries [][]byte
+	for _, cfg := range cfgs {
+		if osa := js.streamAssignment(acc.Name, cfg.Name); osa != nil {
+			// Streams that exist with the same config are left as is.
+			if reflect.DeepEqual(osa.Config, cfg) {
+				continue
+			}
+			return fail(cfg.Name, NewJSStreamNameExistError())
+		}
+		if _, ok := streams[cfg.Name]; ok {
+			return fail(cfg.Name, NewJSStreamNameExistError())
+		}
+		if apiErr := js.jsClusteredStreamLimitsCheck(acc, cfg); apiErr != nil {
+			return fail(cfg.Name, apiErr)
+		}
+		rg, err := js.createGroupForStream(ci, cfg)
+		if err != nil {
+			return fail(cfg.Name, NewJSClusterNoPeersError(err))
+		}
+		rg.setPreferred()
+		streams[cfg.Name] = rg
+		inflight = append(inflight, cfg.Name)
+
+		sa := &streamAssignment{Group: rg, Sync: syncSubjForStream(), Config: cfg, Subject: subject, Client: ci, Created: created}
+		entries = append(entries, encodeAddStreamAssignment(sa))
+	}
+	if err := cc.proposeStreamBulk(entries); err != nil {
+		return fail(_EMPTY_, NewJSStreamBulkError(err))
+	}
+	if len(streams) == 0 {
+		delete(cc.inflight, acc.Name)
+	}
+
+	applied := func() bool {
+		for _, name := range inflight {
+			if sa := js.streamAssignment(acc.Name, name); sa == nil || !sa.Created.Equal(created) {
+				return false
+			}
+		}
+		return true
+	}
+	return applied, _EMPTY_, nil
+}
+
+func (s *Server) jsClusteredStreamBulkUpdate(ci *ClientInfo, acc *Account, subject string, configs []*StreamConfig) (func() bool, string, *ApiError) {
+	js, cc := s.getJetStreamCluster()
+
+	js.mu.RLock()
+	jsa := js.accounts[acc.Name]
+	ocfgs := make([]*StreamConfig, 0, len(configs))
+	for _, config := range configs {
+		osa := js.streamAssignment(acc.Name, config.Name)
+		if osa == nil {
+			js.mu.RUnlock()
+			return nil, config.Name, NewJSStreamNotFoundError()
+		}
+		ocfgs = append(ocfgs, osa.Config)
+	}
+	js.mu.RUnlock()
+	if jsa == nil {
+		return nil, _EMPTY_, NewJSNotEnabledForAccountError()
+	}
+
+	// Checked without the lock, like single updates.
+	cfgs := make([]*StreamConfig, 0, len(configs))
+	for i, config := range configs {
+		cfg, err := jsa.configUpdateCheck(ocfgs[i], config, s)
+		if err != nil {
+			return nil, config.Name, NewJSStreamUpdateError(err, Unless(err))
+		}
+		cfgs = append(cfgs, cfg)
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if name := cc.streamBulkSubjectsOverlap(acc.Name, cfgs); name != _EMPTY_ {
+		return nil, name, NewJSStreamSubjectOverlapError()
+	}
+
+	var entries [][]byte
+	for _, cfg := range cfgs {
+		osa := js.streamAssignment(acc.Name, cfg.Name)
+		if osa == nil {
+			return nil, cfg.Name, NewJSStreamNotFoundError()
+		}
+		// Check for mirror changes which are not allowed.
+		if !reflect.DeepEqual(cfg.Mirror, osa.Config.Mirror) {
+			return nil, cfg.Name, NewJSStreamMirrorNotUpdatableError()
+		}
+		// Those need peers selected and consumers remapped, so are done one at a time.
+		if cfg.Replicas != osa.Config.Replicas || !reflect.DeepEqual(cfg.Placement, osa.Config.Placement) {
+			return nil, cfg.Name, NewJSStreamBulkInvalidError(errors.New("replicas and placement can not be updated in bulk"))
+		}
+		if reflect.DeepEqual(osa.Config, cfg) {
+			continue
+		}
+		rg := osa.copyGroup().Group
+		rg.Preferred = _EMPTY_
+		sa := &streamAssignment{Group: rg, Sync: osa.Sync, Created: osa.Created, Config: cfg, Subject: subject, Client: ci}
+		sa.ConfigHistory = osa.ConfigHistory.record(osa.Created, osa.Config)
+		entries = append(entries, encodeUpdateStreamAssignment(sa))
+	}
+	if err := cc.proposeStreamBulk(entries); err != nil {
+		return nil, _EMPTY_, NewJSStreamBulkError(err)
+	}
+
+	// Compare the configs as encoded, since they went through it.
+	want := make(map[string][]byte, len(cfgs))
+	for _, cfg := range cfgs {
+		want[cfg.Name], _ = json.Marshal(cfg)
+	}
+	applied := func() bool {
+		for name, b := range want {
+			sa := js.streamAssignment(acc.Name, name)
+			if sa == nil {
+				return false
+			}
+			if cb, _ := json.Marshal(sa.Config); !bytes.Equal(cb, b) {
+				return false
+			}
+		}
+		return true
+	}
+	return applied, _EMPTY_, nil
+}
+
+func (s *Server) jsClusteredStreamBulkDelete(ci *ClientInfo, acc *Account, subject string, names []string) (func() bool, string, *ApiError) {
+	js, cc := s.getJetStreamCluster()
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	entries := make([][]byte, 0, len(names))
+	for _, name := range names {
+		osa := js.streamAssignment(acc.Name, name)
+		if osa == nil {
+			return nil, name, NewJSStreamNotFoundError()
+		}
+		if apiErr := acc.checkStreamUnlocked(osa.Config); apiErr != nil {
+			return nil, name, apiErr
+		}
+		sa := &streamAssignment{Group: osa.Group, Config: osa.Config, Subject: subject, Client: ci}
+		entries = append(entries, encodeDeleteStreamAssignment(sa))
+	}
+	if err := cc.proposeStreamBulk(entries); err != nil {
+		return nil, _EMPTY_, NewJSStreamBulkError(err)
+	}
+
+	applied := func() bool {
+		for _, name := range names {
+			if js.streamAssignment(acc.Name, name) != nil {
+				return false
+			}
+		}
+		return true
+	}
+	return applied, _EMPTY_, nil
+}
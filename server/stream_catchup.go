@@ -0,0 +1,108 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// StreamCatchup limits the traffic used to catch up the replicas of a stream
+// so a rebuilding replica does not saturate the network or the leader's disk.
+type StreamCatchup struct {
+	// Max bytes per second sent to catch up replicas.
+	Bytes int64 `json:"bytes_per_sec,omitempty"`
+	// Max messages sent to a replica that are waiting for an ack.
+	MaxPending int `json:"max_pending,omitempty"`
+}
+
+// Default max messages waiting for an ack for each catchup.
+const defaultCatchupMaxPending = 32 * 1024
+
+// Validate the catchup limits for a stream.
+func checkStreamCatchup(cfg *StreamConfig) error {
+	cl := cfg.Catchup
+	if cl == nil {
+		return nil
+	}
+	if cl.Bytes < 0 || cl.MaxPending < 0 {
+		return errors.New("catchup limits can not be negative")
+	}
+	return nil
+}
+
+// Sets up our catchup rate limiter from the config.
+// Lock should be held.
+func (mset *stream) setCatchupLimitLocked(cl *StreamCatchup) {
+	mset.rlCatchup = nil
+	if cl != nil && cl.Bytes > 0 {
+		mset.rlCatchup = rate.NewLimiter(rate.Limit(cl.Bytes), int(cl.Bytes))
+	}
+}
+
+// Returns the rate limiter and max pending messages for catching up a replica.
+// The stream limits are used over the defaults of the server. These are checked
+// for every batch so updates apply to a catchup that is running.
+func (mset *stream) catchupLimits() (*rate.Limiter, *rate.Limiter, int) {
+	s := mset.srv
+	mset.mu.RLock()
+	rl, cl := mset.rlCatchup, mset.cfg.Catchup
+	mset.mu.RUnlock()
+
+	opts := s.getOpts()
+	maxPending := defaultCatchupMaxPending
+	if cl != nil && cl.MaxPending > 0 {
+		maxPending = cl.MaxPending
+	} else if opts.JetStreamCatchupMsgs > 0 {
+		maxPending = opts.JetStreamCatchupMsgs
+	}
+	return rl, s.gcbRateLimiter(opts.JetStreamCatchupRate), maxPending
+}
+
+// Returns the rate limiter shared by all catchups of the server, nil if unlimited.
+func (s *Server) gcbRateLimiter(bps int64) *rate.Limiter {
+	s.gcbMu.Lock()
+	defer s.gcbMu.Unlock()
+	if bps <= 0 {
+		s.gcbRate = nil
+	} else if s.gcbRate == nil {
+		s.gcbRate = rate.NewLimiter(rate.Limit(bps), int(bps))
+	} else if s.gcbRate.Limit() != rate.Limit(bps) {
+		s.gcbRate.SetLimit(rate.Limit(bps))
+		s.gcbRate.SetBurst(int(bps))
+	}
+	return s.gcbRate
+}
+
+// Takes size bytes from the rate limiters, returning how long to wait before sending them.
+func catchupDelay(size int, rls ...*rate.Limiter) time.Duration {
+	now := time.Now()
+	var delay time.Duration
+	for _, rl := range rls {
+		if rl == nil {
+			continue
+		}
+		// Messages larger than our burst only need to fit in a full burst.
+		n := size
+		if n > rl.Burst() {
+			n = rl.Burst()
+		}
+		if d := rl.ReserveN(now, n).DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
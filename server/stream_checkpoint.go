@@ -0,0 +1,108 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// Name of the mirror checkpoint file inside the stream directory.
+	mirrorCheckpointFile = "mirror_checkpoint.json"
+	// How far a mirror gets in its origin stream between checkpoints.
+	mirrorCheckpointChunk = 1024
+)
+
+// How far a resumable mirror got in its origin stream.
+type mirrorCheckpoint struct {
+	Stream  string    `json:"stream"`
+	Filter  string    `json:"filter,omitempty"`
+	Seq     uint64    `json:"seq"`
+	Updated time.Time `json:"updated"`
+}
+
+// Resumable mirrors keep a checkpoint of the last origin sequence they stored, written
+// as each chunk of the catchup is stored and when the stream stops. When a mirror loses
+// its messages, e.g. a memory mirror recreated after a restart, it resumes from the
+// checkpoint instead of catching up across a leafnode or WAN from the start again.
+// Lock should be held.
+func (mset *stream) setupMirrorCheckpoint(dir string) {
+	m := mset.cfg.Mirror
+	if m == nil || !m.Resumable {
+		return
+	}
+	mset.mckfile = filepath.Join(dir, mirrorCheckpointFile)
+
+	b, err := os.ReadFile(mset.mckfile)
+	if err != nil {
+		return
+	}
+	var ck mirrorCheckpoint
+	if err := json.Unmarshal(b, &ck); err != nil {
+		mset.srv.Warnf("Could not load mirror checkpoint for stream '%s > %s': %v", mset.acc.Name, mset.cfg.Name, err)
+		return
+	}
+	// Only valid for the same origin stream.
+	if ck.Stream == m.Name && ck.Filter == m.FilterSubject {
+		mset.mckseq = ck.Seq
+	}
+}
+
+// Writes out our checkpoint if we moved a chunk past the last one, or at all if forced.
+// Lock should be held.
+func (mset *stream) checkpointMirrorLocked(force bool) {
+	if mset.mckfile == _EMPTY_ || mset.lseq <= mset.mckseq {
+		return
+	}
+	if !force && mset.lseq < mset.mckseq+mirrorCheckpointChunk {
+		return
+	}
+	ck := &mirrorCheckpoint{
+		Stream:  mset.cfg.Mirror.Name,
+		Filter:  mset.cfg.Mirror.FilterSubject,
+		Seq:     mset.lseq,
+		Updated: time.Now().UTC(),
+	}
+	b, _ := json.Marshal(ck)
+	if err := os.MkdirAll(filepath.Dir(mset.mckfile), defaultDirPerms); err != nil {
+		mset.srv.RateLimitWarnf("Could not write mirror checkpoint to %q: %v", mset.mckfile, err)
+		return
+	}
+	tmp := mset.mckfile + ".tmp"
+	if err := os.WriteFile(tmp, b, defaultFilePerms); err != nil {
+		mset.srv.RateLimitWarnf("Could not write mirror checkpoint to %q: %v", mset.mckfile, err)
+		return
+	}
+	if err := os.Rename(tmp, mset.mckfile); err == nil {
+		mset.mckseq = ck.Seq
+	}
+}
+
+// Stops keeping our mirror checkpoint. If delete is set the checkpoint is removed,
+// otherwise the last one is written out.
+// Lock should be held.
+func (mset *stream) stopMirrorCheckpoint(delete bool) {
+	if mset.mckfile == _EMPTY_ {
+		return
+	}
+	if delete {
+		os.Remove(mset.mckfile)
+	} else {
+		mset.checkpointMirrorLocked(true)
+	}
+	mset.mckfile = _EMPTY_
+}
@@ -0,0 +1,268 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/nats-io/nuid"
+)
+
+// With a ChunkSize a stream stores payloads larger than that as ordered chunk
+// messages on the same subject. The first chunk carries the original headers
+// along with the size of the whole payload, the last one the message id if any,
+// so the message only counts as published once fully stored. Only the last
+// chunk is acknowledged to the publisher.
+//
+// Clients can publish messages over max_payload to subjects of such streams,
+// up to the stream's MaxMsgSize, or maxChunkedMsgSize if that is not set.
+//
+// Consumers with ReassembleChunks deliver the chunks as the message they make
+// up, acknowledged through its first chunk. A message missing chunks for
+// longer than chunkReassemblyWait, say after a failed publish, is delivered
+// as its first chunk.
+
+// Headers of chunk messages.
+const (
+	JSChunkId    = "Nats-Chunk-Id"
+	JSChunkSeq   = "Nats-Chunk-Seq"
+	JSChunkTotal = "Nats-Chunk-Total"
+	JSChunkSize  = "Nats-Chunk-Size"
+)
+
+const (
+	// Smallest chunk size we allow.
+	minChunkSize = 1024
+	// Largest message a client can publish for chunking when the stream has no max message size.
+	maxChunkedMsgSize = 64 * 1024 * 1024
+	// How long a consumer waits for the missing chunks of a message.
+	chunkReassemblyWait = 5 * time.Second
+)
+
+// Validate the chunking configuration for a stream.
+func checkStreamChunking(cfg *StreamConfig) error {
+	if cfg.ChunkSize < 0 {
+		return errors.New("chunk size can not be negative")
+	}
+	if cfg.ChunkSize == 0 {
+		return nil
+	}
+	if cfg.ChunkSize < minChunkSize {
+		return fmt.Errorf("chunk size must be at least %d", minChunkSize)
+	}
+	if cfg.MaxMsgSize > 0 && cfg.MaxMsgSize <= cfg.ChunkSize {
+		return errors.New("chunk size must be less than max message size")
+	}
+	if cfg.Overflow != nil {
+		return errors.New("chunk size can not be combined with overflow")
+	}
+	return nil
+}
+
+// Returns true if these are the headers of a chunk message.
+func isChunk(hdr []byte) bool {
+	return len(hdr) > 0 && len(getHeader(JSChunkId, hdr)) > 0
+}
+
+// Returns true if a publish of size bytes over our max payload is allowed
+// since it is for a stream that will chunk it.
+func (c *client) chunkedPubAllowed(subject []byte, size int) bool {
+	if c.kind != CLIENT || c.acc == nil {
+		return false
+	}
+	acc := c.acc
+	acc.mu.RLock()
+	jsa := acc.js
+	acc.mu.RUnlock()
+	if jsa == nil {
+		return false
+	}
+
+	jsa.mu.RLock()
+	defer jsa.mu.RUnlock()
+	for _, mset := range jsa.streams {
+		mset.mu.RLock()
+		csz, maxMsgSize, subjects := mset.cfg.ChunkSize, int(mset.cfg.MaxMsgSize), mset.cfg.Subjects
+		mset.mu.RUnlock()
+		if csz <= 0 {
+			continue
+		}
+		if maxMsgSize <= 0 {
+			maxMsgSize = maxChunkedMsgSize
+		}
+		if size > maxMsgSize {
+			continue
+		}
+		for _, subj := range subjects {
+			if subjectIsSubsetMatch(string(subject), subj) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// If the payload is over our chunk size, store it as chunk messages.
+// Returns false if the message is not to be chunked and should be processed as is.
+func (mset *stream) processChunkedMsg(subject, reply string, hdr, msg []byte, isClustered bool) bool {
+	mset.mu.Lock()
+	csz, name := int(mset.cfg.ChunkSize), mset.cfg.Name
+	if csz <= 0 || len(msg) <= csz {
+		mset.mu.Unlock()
+		return false
+	}
+	// A duplicate is caught here since the message id is only on the last chunk.
+	msgId := getMsgId(hdr)
+	if msgId != _EMPTY_ {
+		if dde := mset.checkMsgId(msgId); dde != nil {
+			pubAck := append([]byte(nil), mset.pubAck...)
+			mset.mu.Unlock()
+			if reply != _EMPTY_ {
+				response := append(pubAck, strconv.FormatUint(dde.seq, 10)...)
+				response = append(response, ",\"duplicate\": true}"...)
+				mset.outq.sendMsg(reply, response)
+			}
+			return true
+		}
+	}
+	mset.mu.Unlock()
+
+	id, total := nuid.Next(), (len(msg)+csz-1)/csz
+	stotal := strconv.Itoa(total)
+	for i := 0; i < total; i++ {
+		var chdr []byte
+		switch i {
+		case 0:
+			chdr = removeHeaderIfPresent(copyBytes(hdr), JSMsgId)
+			chdr = genHeader(chdr, JSChunkSize, strconv.Itoa(len(msg)))
+		case total - 1:
+			if msgId != _EMPTY_ {
+				chdr = genHeader(nil, JSMsgId, msgId)
+			}
+		}
+		chdr = genHeader(chdr, JSChunkId, id)
+		chdr = genHeader(chdr, JSChunkSeq, strconv.Itoa(i+1))
+		chdr = genHeader(chdr, JSChunkTotal, stotal)
+
+		chunk := msg[i*csz:]
+		if len(chunk) > csz {
+			chunk = chunk[:csz]
+		}
+		var rply string
+		if i == total-1 {
+			rply = reply
+		}
+		var err error
+		if isClustered {
+			err = mset.processClusteredInboundMsg(subject, rply, chdr, chunk)
+		} else {
+			err = mset.processJetStreamMsg(subject, rply, chdr, chunk, 0, 0)
+		}
+		// The last chunk responded already, for the others we do so here.
+		if err != nil {
+			if rply == _EMPTY_ && reply != _EMPTY_ {
+				resp := JSPubAckResponse{PubAck: &PubAck{Stream: name}, Error: NewJSStreamStoreFailedError(err)}
+				b, _ := json.Marshal(resp)
+				mset.outq.sendMsg(reply, b)
+			}
+			return true
+		}
+	}
+	return true
+}
+
+// Loads the chunks following first, the first chunk of a chunked message.
+// Returns the sequences and payload of those chunks, or false if not all of
+// them are stored.
+func loadChunks(store StreamStore, first *StoreMsg, withData bool) ([]uint64, []byte, bool) {
+	id := getHeader(JSChunkId, first.hdr)
+	total, err := strconv.Atoi(string(getHeader(JSChunkTotal, first.hdr)))
+	if err != nil || total < 1 || string(getHeader(JSChunkSeq, first.hdr)) != "1" {
+		return nil, nil, false
+	}
+
+	var data []byte
+	if withData {
+		sz, _ := strconv.Atoi(string(getHeader(JSChunkSize, first.hdr)))
+		data = make([]byte, 0, sz)
+	}
+	seqs := make([]uint64, 0, total-1)
+	var smv StoreMsg
+	for seq, n := first.seq+1, 2; n <= total; {
+		sm, nseq, err := store.LoadNextMsg(first.subj, false, seq, &smv)
+		if err != nil || sm == nil {
+			return nil, nil, false
+		}
+		seq = nseq + 1
+		// Chunks of other messages on the same subject can be interleaved.
+		if string(getHeader(JSChunkId, sm.hdr)) != string(id) {
+			continue
+		}
+		if string(getHeader(JSChunkSeq, sm.hdr)) != strconv.Itoa(n) {
+			return nil, nil, false
+		}
+		if withData {
+			msg := sm.msg
+			if string(getHeader(JSCompressed, sm.hdr)) == s2CompressionString {
+				if msg, err = s2.Decode(nil, sm.msg); err != nil {
+					return nil, nil, false
+				}
+			}
+			data = append(data, msg...)
+		}
+		seqs = append(seqs, sm.seq)
+		n++
+	}
+	return seqs, data, true
+}
+
+// Puts the chunks of a message in place of its first chunk about to be delivered.
+// Returns false if the message can not be reassembled yet.
+// Lock should be held.
+func (o *consumer) reassembleChunks(pmsg *jsPubMsg) bool {
+	decompressPubMsg(pmsg)
+	_, data, ok := loadChunks(o.mset.store, &pmsg.StoreMsg, true)
+	if !ok {
+		// Give up after a while and deliver the first chunk as is.
+		return time.Since(time.Unix(0, pmsg.ts)) > chunkReassemblyWait
+	}
+	hdr := copyBytes(pmsg.hdr)
+	for _, key := range []string{JSChunkId, JSChunkSeq, JSChunkTotal, JSChunkSize} {
+		hdr = removeHeaderIfPresent(hdr, key)
+	}
+	// The wire buffer no longer matches so drop it.
+	pmsg.hdr, pmsg.msg, pmsg.buf = hdr, append(copyBytes(pmsg.msg), data...), nil
+	return true
+}
+
+// Returns the sequences of the other chunks of a reassembled message,
+// which are acknowledged along with its first chunk.
+// Lock should be held.
+func (o *consumer) chunkSeqs(sseq uint64) []uint64 {
+	if !o.cfg.ReassembleChunks || o.mset == nil || o.mset.store == nil {
+		return nil
+	}
+	var smv StoreMsg
+	sm, err := o.mset.store.LoadMsg(sseq, &smv)
+	if err != nil || sm == nil || !isChunk(sm.hdr) {
+		return nil
+	}
+	seqs, _, _ := loadChunks(o.mset.store, sm, false)
+	return seqs
+}
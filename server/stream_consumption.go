@@ -0,0 +1,107 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+func checkConsumptionGroups(cfg *StreamConfig) error {
+	if len(cfg.ConsumptionGroups) == 0 {
+		return nil
+	}
+	if cfg.Retention != WorkQueuePolicy {
+		return errors.New("consumption groups require work queue retention")
+	}
+	seen := make(map[string]struct{}, len(cfg.ConsumptionGroups))
+	for _, group := range cfg.ConsumptionGroups {
+		if !isValidName(group) {
+			return fmt.Errorf("consumption group %q is not a valid name", group)
+		}
+		if _, ok := seen[group]; ok {
+			return fmt.Errorf("duplicate consumption group %q", group)
+		}
+		seen[group] = struct{}{}
+	}
+	return nil
+}
+
+// Groups can be added to a stream, but not taken away since their consumers may still rely on them.
+func checkConsumptionGroupsUpdate(old, cfg *StreamConfig) error {
+	groups := make(map[string]struct{}, len(cfg.ConsumptionGroups))
+	for _, group := range cfg.ConsumptionGroups {
+		groups[group] = struct{}{}
+	}
+	for _, group := range old.ConsumptionGroups {
+		if _, ok := groups[group]; !ok {
+			return fmt.Errorf("stream configuration update can not remove consumption group %q", group)
+		}
+	}
+	return nil
+}
+
+// When a stream has consumption groups, every consumer other than those of mirrors and
+// sources needs to belong to one of them.
+func checkConsumerConsumptionGroup(scfg *StreamConfig, config *ConsumerConfig) error {
+	group := config.ConsumptionGroup
+	if group == _EMPTY_ {
+		if len(scfg.ConsumptionGroups) > 0 && !config.Direct {
+			return errors.New("stream requires consumers to belong to a consumption group")
+		}
+		return nil
+	}
+	for _, g := range scfg.ConsumptionGroups {
+		if g == group {
+			return nil
+		}
+	}
+	return fmt.Errorf("stream has no consumption group %q", group)
+}
+
+// Returns true if a group still needs the message. A group that has no consumer
+// for the message yet needs it as well, so it is there once one gets added.
+// Lock should be held.
+func (mset *stream) groupsNeedMsg(seq uint64, obs *consumer) bool {
+	var svp StoreMsg
+	sm, err := mset.store.LoadMsg(seq, &svp)
+	if err != nil {
+		return false
+	}
+	covered := make(map[string]struct{}, len(mset.cfg.ConsumptionGroups))
+	for _, o := range mset.consumers {
+		if o != obs && o.needAck(seq, sm.subj) {
+			return true
+		}
+		o.mu.RLock()
+		group, match := o.cfg.ConsumptionGroup, o.isFilteredMatch(sm.subj)
+		o.mu.RUnlock()
+		if group != _EMPTY_ && match {
+			covered[group] = struct{}{}
+		}
+	}
+	return len(covered) < len(mset.cfg.ConsumptionGroups)
+}
+
+// Returns how many consumers belong to a consumption group, or to none.
+// Lock should be held.
+func (mset *stream) numGroupConsumers(group string) int {
+	var n int
+	for _, o := range mset.consumers {
+		if o.cfg.ConsumptionGroup == group {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// Name of the dedupe state file inside the stream directory.
+	dedupeStateFile = "dedupe.json"
+	// How long we wait after a new message id before writing out the dedupe state.
+	dedupeSyncInterval = time.Second
+)
+
+// Persisted form of a ddentry.
+type dedupeEntry struct {
+	ID  string `json:"id"`
+	Seq uint64 `json:"seq"`
+	TS  int64  `json:"ts"`
+}
+
+// R1 memory streams lose their messages on restart, and with them what we need to
+// rebuild the duplicate window. For these we keep the window in a sidecar file in the
+// stream directory so duplicates are still detected once the stream is recreated.
+// Memory streams with a WAL recover their messages so rebuild the window as normal.
+// The sidecar is not encrypted so it is not used for encrypted accounts.
+// Lock should be held.
+func (mset *stream) setupDedupePersistence(dir string) {
+	cfg := &mset.cfg
+	if cfg.Storage != MemoryStorage || cfg.Replicas > 1 || cfg.MemoryWAL != nil || cfg.Duplicates <= 0 {
+		return
+	}
+	if mset.srv.jsKeyGen(mset.acc.Name) != nil {
+		return
+	}
+	mset.ddfile = filepath.Join(dir, dedupeStateFile)
+
+	b, err := os.ReadFile(mset.ddfile)
+	if err != nil {
+		return
+	}
+	var entries []dedupeEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		mset.srv.Warnf("Could not load dedupe state for stream '%s > %s': %v", mset.acc.Name, cfg.Name, err)
+		return
+	}
+	cutoff := time.Now().Add(-cfg.Duplicates).UnixNano()
+	for _, e := range entries {
+		if e.TS > cutoff && e.ID != _EMPTY_ {
+			mset.storeMsgIdLocked(&ddentry{e.ID, e.Seq, e.TS})
+		}
+	}
+	// Nothing new to write out.
+	mset.dddirty = false
+}
+
+// Marks our dedupe state as needing to be written out.
+// Lock should be held.
+func (mset *stream) dedupeStateChanged() {
+	if mset.ddfile == _EMPTY_ {
+		return
+	}
+	mset.dddirty = true
+	if mset.ddstmr == nil {
+		mset.ddstmr = time.AfterFunc(dedupeSyncInterval, mset.syncDedupeState)
+	}
+}
+
+// Writes out our dedupe state if it has changed.
+func (mset *stream) syncDedupeState() {
+	mset.mu.Lock()
+	mset.ddstmr = nil
+	b, fn := mset.dedupeStateLocked()
+	mset.mu.Unlock()
+
+	if b != nil {
+		mset.writeDedupeState(fn, b)
+	}
+}
+
+// Returns our encoded dedupe state if it needs to be written out.
+// Lock should be held.
+func (mset *stream) dedupeStateLocked() ([]byte, string) {
+	if mset.ddfile == _EMPTY_ || !mset.dddirty {
+		return nil, _EMPTY_
+	}
+	mset.dddirty = false
+	entries := make([]dedupeEntry, 0, len(mset.ddmap))
+	for _, dde := range mset.ddarr[mset.ddindex:] {
+		// Skip any that were replaced.
+		if mset.ddmap[dde.id] == dde {
+			entries = append(entries, dedupeEntry{dde.id, dde.seq, dde.ts})
+		}
+	}
+	b, _ := json.Marshal(entries)
+	return b, mset.ddfile
+}
+
+// Writes the dedupe state to fn.
+func (mset *stream) writeDedupeState(fn string, b []byte) {
+	mset.ddwmu.Lock()
+	defer mset.ddwmu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(fn), defaultDirPerms); err != nil {
+		mset.srv.RateLimitWarnf("Could not write dedupe state to %q: %v", fn, err)
+		return
+	}
+	tmp := fn + ".tmp"
+	if err := os.WriteFile(tmp, b, defaultFilePerms); err != nil {
+		mset.srv.RateLimitWarnf("Could not write dedupe state to %q: %v", fn, err)
+		return
+	}
+	os.Rename(tmp, fn)
+}
+
+// Stops persisting our dedupe state. If delete is set the state is removed,
+// otherwise any pending changes are written out.
+// Lock should be held.
+func (mset *stream) stopDedupePersistence(delete bool) {
+	if mset.ddfile == _EMPTY_ {
+		return
+	}
+	if mset.ddstmr != nil {
+		mset.ddstmr.Stop()
+		mset.ddstmr = nil
+	}
+	if delete {
+		mset.ddwmu.Lock()
+		os.Remove(mset.ddfile)
+		// Will only succeed if we were the only thing in there.
+		os.Remove(filepath.Dir(mset.ddfile))
+		mset.ddwmu.Unlock()
+	} else if b, fn := mset.dedupeStateLocked(); b != nil {
+		mset.writeDedupeState(fn, b)
+	}
+	mset.ddfile = _EMPTY_
+}
@@ -0,0 +1,141 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// Key value stores mark deleted and purged keys with a message carrying this header.
+const (
+	kvOperationHdr = "KV-Operation"
+	kvOpDel        = "DEL"
+	kvOpPurge      = "PURGE"
+)
+
+// With DeleteMarkerTTL a stream keeping a single message per subject removes
+// delete markers once they are older than the TTL, so the keys they mark go
+// away entirely without clients having to clean them up. Every server removes
+// the markers from its own store, the same way messages expire with MaxAge.
+
+// A delete marker along with when it is to be removed.
+type deleteMarker struct {
+	seq uint64
+	at  int64
+}
+
+func checkDeleteMarkerTTL(cfg *StreamConfig) error {
+	if cfg.DeleteMarkerTTL < 0 {
+		return errors.New("delete marker TTL can not be negative")
+	}
+	// With more messages per subject removing the marker would bring back the older ones.
+	if cfg.DeleteMarkerTTL > 0 && cfg.MaxMsgsPer != 1 {
+		return errors.New("delete marker TTL requires max messages per subject of 1")
+	}
+	return nil
+}
+
+// Returns true if these are the headers of a key value delete or purge marker.
+func isDeleteMarker(hdr []byte) bool {
+	if len(hdr) == 0 {
+		return false
+	}
+	op := getHeader(kvOperationHdr, hdr)
+	return bytes.Equal(op, []byte(kvOpDel)) || bytes.Equal(op, []byte(kvOpPurge))
+}
+
+// Tracks a delete marker we just stored.
+// Lock should be held.
+func (mset *stream) trackDeleteMarkerLocked(seq uint64, ts int64) {
+	ttl := mset.cfg.DeleteMarkerTTL
+	if ttl <= 0 {
+		return
+	}
+	// Markers are all kept for the same time, so our list stays ordered by when they are due.
+	mset.dmarks = append(mset.dmarks, deleteMarker{seq, ts + int64(ttl)})
+	mset.armDeleteMarkersLocked()
+}
+
+// Scans our store for the delete markers to track, on startup or when our TTL changed.
+// Lock should be held.
+func (mset *stream) loadDeleteMarkersLocked() {
+	mset.stopDeleteMarkersLocked()
+	ttl := mset.cfg.DeleteMarkerTTL
+	if ttl <= 0 || mset.store == nil {
+		return
+	}
+	var state StreamState
+	mset.store.FastState(&state)
+	var smv StoreMsg
+	for seq := state.FirstSeq; seq <= state.LastSeq; seq++ {
+		sm, nseq, err := mset.store.LoadNextMsg(fwcs, true, seq, &smv)
+		if err != nil || sm == nil {
+			break
+		}
+		if isDeleteMarker(sm.hdr) {
+			mset.dmarks = append(mset.dmarks, deleteMarker{sm.seq, sm.ts + int64(ttl)})
+		}
+		seq = nseq
+	}
+	mset.armDeleteMarkersLocked()
+}
+
+// Makes sure our timer fires for the oldest marker.
+// Lock should be held.
+func (mset *stream) armDeleteMarkersLocked() {
+	if len(mset.dmarks) == 0 || mset.dmarkT != nil {
+		return
+	}
+	d := time.Duration(mset.dmarks[0].at - time.Now().UnixNano())
+	if d < 0 {
+		d = 0
+	}
+	mset.dmarkT = time.AfterFunc(d, mset.expireDeleteMarkers)
+}
+
+// Removes the delete markers that are due. Markers replaced by a newer message
+// for their subject are gone already, removing those again is a no-op.
+func (mset *stream) expireDeleteMarkers() {
+	mset.mu.Lock()
+	if mset.client == nil {
+		mset.mu.Unlock()
+		return
+	}
+	now := time.Now().UnixNano()
+	var i int
+	var rmseqs []uint64
+	for ; i < len(mset.dmarks) && mset.dmarks[i].at <= now; i++ {
+		rmseqs = append(rmseqs, mset.dmarks[i].seq)
+	}
+	mset.dmarks = append(mset.dmarks[:0], mset.dmarks[i:]...)
+	mset.dmarkT = nil
+	mset.armDeleteMarkersLocked()
+	store := mset.store
+	mset.mu.Unlock()
+
+	for _, seq := range rmseqs {
+		store.RemoveMsg(seq)
+	}
+}
+
+// Lock should be held.
+func (mset *stream) stopDeleteMarkersLocked() {
+	if mset.dmarkT != nil {
+		mset.dmarkT.Stop()
+		mset.dmarkT = nil
+	}
+	mset.dmarks = nil
+}
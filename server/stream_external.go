@@ -0,0 +1,179 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sources and mirrors can refer to a stream in another account on this system
+// by naming the account and presenting one of the tokens of the origin stream.
+// We then talk to the JetStream API of that account and receive the messages
+// there directly, so no exports and imports need to be setup between them.
+
+// Validates an external stream that refers to a stream in another account.
+func checkStreamExternalAccount(ext *ExternalStream, acc *Account) error {
+	if ext == nil || ext.Account == _EMPTY_ {
+		return nil
+	}
+	if acc != nil && ext.Account == acc.Name {
+		return errors.New("external account can not be the account of the stream")
+	}
+	if ext.Token == _EMPTY_ {
+		return fmt.Errorf("external account %q requires a token", ext.Account)
+	}
+	if ext.ApiPrefix != _EMPTY_ && !IsValidPublishSubject(ext.ApiPrefix) {
+		return fmt.Errorf("stream external api prefix %q must be a valid subject without wildcards", ext.ApiPrefix)
+	}
+	// We send from within the other account, so only allow its own JetStream API.
+	if ext.ApiPrefix != _EMPTY_ && !strings.HasPrefix(ext.ApiPrefix, "$JS.") {
+		return fmt.Errorf("stream external api prefix %q for account %q must be a JetStream API prefix", ext.ApiPrefix, ext.Account)
+	}
+	if ext.DeliverPrefix != _EMPTY_ && !IsValidPublishSubject(ext.DeliverPrefix) {
+		return fmt.Errorf("stream external deliver prefix %q must be a valid subject without wildcards", ext.DeliverPrefix)
+	}
+	return nil
+}
+
+// Returns the account for a source in another account, or nil if the source
+// is reached through our own account. The token is checked against the origin
+// stream here, before we subscribe or send anything in the other account.
+func (mset *stream) externalSourceAccount(ext *ExternalStream, stream string) (*Account, error) {
+	if ext == nil || ext.Account == _EMPTY_ {
+		return nil, nil
+	}
+	acc, err := mset.srv.LookupAccount(ext.Account)
+	if err != nil {
+		return nil, err
+	}
+	if !mset.js.sourceTokenAllowed(acc.Name, stream, ext.Token) {
+		return nil, NewJSStreamSourceNotAuthorizedError()
+	}
+	return acc, nil
+}
+
+func sourceTokensKey(account, stream string) string {
+	return account + " > " + stream
+}
+
+// Tracks the source tokens of a stream, nil tokens remove the stream.
+// Can be called under any other lock.
+func (js *jetStream) setSourceTokens(account, stream string, tokens []string) {
+	js.stMu.Lock()
+	defer js.stMu.Unlock()
+	key := sourceTokensKey(account, stream)
+	if len(tokens) == 0 {
+		delete(js.stokens, key)
+		return
+	}
+	if js.stokens == nil {
+		js.stokens = make(map[string][]string)
+	}
+	js.stokens[key] = append([]string(nil), tokens...)
+}
+
+// Checks a token presented to source a stream of an account. This does not
+// need the stream to be on this server, so can be checked before its use.
+func (js *jetStream) sourceTokenAllowed(account, stream, token string) bool {
+	js.stMu.RLock()
+	defer js.stMu.RUnlock()
+	for _, t := range js.stokens[sourceTokensKey(account, stream)] {
+		if comparePasswords(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the subject to create a consumer for a source.
+func externalConsumerCreateSubject(subject string, ext *ExternalStream) string {
+	if ext == nil || ext.ApiPrefix == _EMPTY_ {
+		return subject
+	}
+	subject = strings.Replace(subject, JSApiPrefix, ext.ApiPrefix, 1)
+	return strings.ReplaceAll(subject, "..", ".")
+}
+
+// Subscribes for a source, on the account of the source if in another account.
+// Lock should be held.
+func (mset *stream) subscribeSourceInternal(si *sourceInfo, subject string, cb msgHandler) (*subscription, error) {
+	if si.acc != nil {
+		// Internal account clients get messages with the trailing CRLF, strip it here.
+		return si.acc.subscribeInternal(subject, func(sub *subscription, c *client, acc *Account, subject, reply string, rmsg []byte) {
+			if len(rmsg) >= LEN_CR_LF {
+				rmsg = rmsg[:len(rmsg)-LEN_CR_LF]
+			}
+			cb(sub, c, acc, subject, reply, rmsg)
+		})
+	}
+	return mset.subscribeInternal(subject, cb)
+}
+
+// Sends the request to create the consumer for a source. For sources in other
+// accounts the request is sent from that account along with our token.
+// Lock should be held.
+func (mset *stream) sendSourceConsumerRequest(si *sourceInfo, ext *ExternalStream, subject, reply string, req []byte) {
+	if si.acc == nil {
+		mset.outq.send(newJSPubMsg(subject, _EMPTY_, reply, nil, req, nil, 0))
+		return
+	}
+	// The service import for the JetStream API is on the same internal client, so we need echo.
+	hdr := map[string]string{JSSourceToken: ext.Token}
+	if err := mset.srv.sendInternalAccountMsgWithReply(si.acc, subject, reply, hdr, req, true); err != nil {
+		mset.srv.Warnf("JetStream could not send consumer create request for '%s > %s' to account %q: %v",
+			mset.acc.Name, mset.cfg.Name, si.acc.Name, err)
+	}
+}
+
+// Sends a flow control reply for one of our sources, on the account of the
+// source if in another account.
+// Lock should be held.
+func (mset *stream) sendSourceReply(reply string) {
+	if strings.HasPrefix(reply, jsFlowControlPre) {
+		stream, cname := tokenAt(reply, 3), tokenAt(reply, 4)
+		match := func(si *sourceInfo) bool {
+			return si != nil && si.acc != nil && si.name == stream && si.cname == cname
+		}
+		var si *sourceInfo
+		if match(mset.mirror) {
+			si = mset.mirror
+		} else {
+			for _, ssi := range mset.sources {
+				if match(ssi) {
+					si = ssi
+					break
+				}
+			}
+		}
+		if si != nil {
+			mset.srv.sendInternalAccountMsg(si.acc, reply, nil)
+			return
+		}
+	}
+	mset.outq.sendMsg(reply, nil)
+}
+
+// Checks a token presented to source this stream from another account.
+func (mset *stream) sourceTokenAllowed(token string) bool {
+	mset.mu.RLock()
+	defer mset.mu.RUnlock()
+	for _, t := range mset.cfg.SourceTokens {
+		if comparePasswords(t, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// The data generator stores synthetic messages straight into the store of a
+// stream, skipping the client path, the stream's ingest checks and replication,
+// so performance test environments can be seeded quickly. Limits are still
+// enforced by the store. It is disabled unless the server configuration has
+// jetstream { data_generator: true }, and only works for streams that are not
+// replicated through a raft group.
+
+const (
+	// Most messages a single request can generate.
+	maxGenerateMsgs = 100_000_000
+	// Messages stored per hold of the stream lock.
+	generateBatch = 1000
+)
+
+// Key distributions of the data generator.
+const (
+	genDistSequential = "sequential"
+	genDistUniform    = "uniform"
+	genDistZipf       = "zipf"
+)
+
+var errGenerateClustered = errors.New("data generator not supported for clustered streams")
+
+type msgGenerator struct {
+	msgs    int
+	tokens  []string
+	wcs     []int
+	keys    int
+	dist    string
+	minSz   int
+	maxSz   int
+	rng     *rand.Rand
+	zipf    *rand.Zipf
+	payload []byte
+	n       int
+}
+
+// Validates a generate request against our config and returns the generator for it.
+func (mset *stream) newMsgGenerator(req *JSApiStreamGenerateRequest) (*msgGenerator, error) {
+	mset.mu.RLock()
+	subjects, maxMsgSize, sealed := mset.cfg.Subjects, int(mset.cfg.MaxMsgSize), mset.cfg.Sealed
+	clustered := mset.isClustered()
+	mset.mu.RUnlock()
+
+	if sealed {
+		return nil, NewJSStreamSealedError()
+	}
+	if clustered {
+		return nil, errGenerateClustered
+	}
+	if req.Msgs <= 0 || req.Msgs > maxGenerateMsgs {
+		return nil, fmt.Errorf("number of messages must be between 1 and %d", maxGenerateMsgs)
+	}
+	if req.Keys < 0 || req.PayloadSize < 0 || req.MaxPayloadSize < 0 {
+		return nil, errors.New("keys and payload sizes can not be negative")
+	}
+	if req.MaxPayloadSize > 0 && req.MaxPayloadSize < req.PayloadSize {
+		return nil, errors.New("max payload size can not be less than payload size")
+	}
+
+	subject := req.Subject
+	if subject == _EMPTY_ && len(subjects) > 0 {
+		subject = subjects[0]
+	}
+	if !IsValidSubject(subject) {
+		return nil, fmt.Errorf("invalid subject %q", subject)
+	}
+
+	g := &msgGenerator{
+		msgs:   req.Msgs,
+		tokens: strings.Split(subject, tsep),
+		keys:   req.Keys,
+		dist:   req.Distribution,
+		minSz:  req.PayloadSize,
+		maxSz:  req.MaxPayloadSize,
+		rng:    rand.New(rand.NewSource(req.Seed)),
+	}
+	for i, t := range g.tokens {
+		if t == pwcs || t == fwcs {
+			g.wcs = append(g.wcs, i)
+		}
+	}
+	if g.keys == 0 {
+		g.keys = 1
+	}
+	if g.maxSz < g.minSz {
+		g.maxSz = g.minSz
+	}
+	if maxMsgSize > 0 && len(subject)+g.maxSz > maxMsgSize {
+		return nil, errors.New("payload size exceeds the stream's max message size")
+	}
+
+	// All subjects we generate need to be ours.
+	if !g.matchesSubjects(subjects) {
+		return nil, fmt.Errorf("subject %q does not match the stream's subjects", subject)
+	}
+
+	switch g.dist {
+	case _EMPTY_:
+		g.dist = genDistSequential
+	case genDistSequential, genDistUniform:
+	case genDistZipf:
+		if g.keys > 1 {
+			g.zipf = rand.NewZipf(g.rng, 1.1, 1, uint64(g.keys-1))
+		}
+	default:
+		return nil, fmt.Errorf("unknown distribution %q", g.dist)
+	}
+
+	g.payload = make([]byte, g.maxSz)
+	g.rng.Read(g.payload)
+	return g, nil
+}
+
+// Checks a generated subject against the stream subjects. Wildcards are
+// all replaced by the same key, so checking one key covers them all.
+func (g *msgGenerator) matchesSubjects(subjects []string) bool {
+	subj := g.subject(0)
+	for _, s := range subjects {
+		if subjectIsSubsetMatch(subj, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the subject for the given key.
+func (g *msgGenerator) subject(key int) string {
+	if len(g.wcs) == 0 {
+		return strings.Join(g.tokens, tsep)
+	}
+	tokens := append([]string(nil), g.tokens...)
+	k := strconv.Itoa(key)
+	for _, i := range g.wcs {
+		tokens[i] = k
+	}
+	return strings.Join(tokens, tsep)
+}
+
+// Returns the subject and payload of the next message.
+func (g *msgGenerator) next() (string, []byte) {
+	var key int
+	switch g.dist {
+	case genDistSequential:
+		key = g.n % g.keys
+	case genDistUniform:
+		key = g.rng.Intn(g.keys)
+	case genDistZipf:
+		if g.zipf != nil {
+			key = int(g.zipf.Uint64())
+		}
+	}
+	g.n++
+	sz := g.minSz
+	if g.maxSz > g.minSz {
+		sz += g.rng.Intn(g.maxSz - g.minSz + 1)
+	}
+	return g.subject(key), g.payload[:sz]
+}
+
+// Stores the generated messages into our store and lets our consumers know.
+// Returns how many messages were stored, along with the first and last sequences.
+func (mset *stream) generateMsgs(g *msgGenerator) (int, uint64, uint64, error) {
+	var generated int
+	var fseq, lseq uint64
+	defer func() {
+		if generated > 0 {
+			for _, o := range mset.getConsumers() {
+				o.signalNewMessages()
+			}
+		}
+	}()
+
+	for generated < g.msgs {
+		mset.mu.Lock()
+		if mset.isClustered() {
+			mset.mu.Unlock()
+			return generated, fseq, lseq, errGenerateClustered
+		}
+		store := mset.store
+		if store == nil || mset.client == nil {
+			mset.mu.Unlock()
+			return generated, fseq, lseq, ErrStoreClosed
+		}
+		for i := 0; i < generateBatch && generated < g.msgs; i++ {
+			subj, msg := g.next()
+			seq, _, err := store.StoreMsg(subj, nil, msg)
+			if err != nil {
+				mset.mu.Unlock()
+				return generated, fseq, lseq, err
+			}
+			if fseq == 0 {
+				fseq = seq
+			}
+			lseq = seq
+			mset.lseq = seq
+			generated++
+		}
+		mset.mu.Unlock()
+	}
+	return generated, fseq, lseq, nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Name of the file inside the stream directory holding the subject holds.
+const subjectHoldsFile = "holds.json"
+
+var errPurgeKeepWithHolds = errors.New("purge with keep needs a literal subject while subjects are on hold")
+
+// SubjectHold keeps the messages on subjects matching Subject from being removed by the
+// limits, max age or purges of a stream until it is released, e.g. for a litigation hold,
+// without having to seal the whole stream.
+type SubjectHold struct {
+	Subject string    `json:"subject"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Returns the subjects of holds.
+func holdSubjects(holds []*SubjectHold) []string {
+	if len(holds) == 0 {
+		return nil
+	}
+	subjs := make([]string, 0, len(holds))
+	for _, h := range holds {
+		subjs = append(subjs, h.Subject)
+	}
+	return subjs
+}
+
+// Loads the holds kept in dir. This is done before our store is created, so it
+// recovers with the holds in place.
+// Lock should be held.
+func (mset *stream) loadSubjectHolds(dir string) {
+	mset.holdsFile = filepath.Join(dir, subjectHoldsFile)
+	b, err := os.ReadFile(mset.holdsFile)
+	if err != nil {
+		return
+	}
+	var holds []*SubjectHold
+	if err := json.Unmarshal(b, &holds); err != nil {
+		mset.srv.Warnf("Could not load subject holds for stream '%s > %s': %v", mset.acc.Name, mset.cfg.Name, err)
+		return
+	}
+	mset.holds = holds
+}
+
+// Writes out holds, or removes the file when there are none.
+// Lock should be held.
+func (mset *stream) writeSubjectHoldsLocked(holds []*SubjectHold) error {
+	if mset.holdsFile == _EMPTY_ {
+		return nil
+	}
+	if len(holds) == 0 {
+		if err := os.Remove(mset.holdsFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(holds)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(mset.holdsFile), defaultDirPerms); err != nil {
+		return err
+	}
+	tmp := mset.holdsFile + ".tmp"
+	if err := os.WriteFile(tmp, b, defaultFilePerms); err != nil {
+		return err
+	}
+	return os.Rename(tmp, mset.holdsFile)
+}
+
+// Places a hold on subject created at created, or releases it. Holds are written out
+// before they are placed on our store. Returns the holds of the stream.
+func (mset *stream) setSubjectHold(subject, reason string, release bool, created time.Time) ([]*SubjectHold, error) {
+	// Serializes placing holds on our store.
+	mset.hmu.Lock()
+	defer mset.hmu.Unlock()
+
+	mset.mu.Lock()
+	holds := make([]*SubjectHold, 0, len(mset.holds)+1)
+	var found bool
+	for _, h := range mset.holds {
+		if h.Subject == subject {
+			found = true
+			if release {
+				continue
+			}
+		}
+		holds = append(holds, h)
+	}
+	if release && !found {
+		mset.mu.Unlock()
+		return nil, NewJSStreamHoldNotFoundError()
+	}
+	if !found {
+		holds = append(holds, &SubjectHold{Subject: subject, Reason: reason, Created: created})
+	}
+	if err := mset.writeSubjectHoldsLocked(holds); err != nil {
+		mset.mu.Unlock()
+		return nil, err
+	}
+	mset.holds = holds
+	store := mset.store
+	mset.mu.Unlock()
+
+	// Releasing a hold applies the limits to its messages right away, so done without our lock.
+	if store != nil {
+		store.SetHolds(holdSubjects(holds))
+	}
+	return mset.subjectHolds(), nil
+}
+
+// Replaces all holds of the stream, used when catching up from a snapshot in clustered mode.
+func (mset *stream) replaceSubjectHolds(holds []*SubjectHold) error {
+	mset.hmu.Lock()
+	defer mset.hmu.Unlock()
+
+	mset.mu.Lock()
+	if err := mset.writeSubjectHoldsLocked(holds); err != nil {
+		mset.mu.Unlock()
+		return err
+	}
+	mset.holds = holds
+	store := mset.store
+	mset.mu.Unlock()
+
+	if store != nil {
+		store.SetHolds(holdSubjects(holds))
+	}
+	return nil
+}
+
+// Returns a copy of the holds of the stream.
+func (mset *stream) subjectHolds() []*SubjectHold {
+	mset.mu.RLock()
+	defer mset.mu.RUnlock()
+	if len(mset.holds) == 0 {
+		return nil
+	}
+	holds := make([]*SubjectHold, 0, len(mset.holds))
+	for _, h := range mset.holds {
+		hc := *h
+		holds = append(holds, &hc)
+	}
+	return holds
+}
+
+// With subjects on hold a purge is done for each subject matching its filter that is not
+// on hold. A keep is then applied per subject, so needs a literal filter subject.
+func purgeAroundHolds(store StreamStore, preq *JSApiStreamPurgeRequest, holds []string) (uint64, error) {
+	filter, seq, keep := fwcs, uint64(0), uint64(0)
+	if preq != nil {
+		if preq.Subject != _EMPTY_ {
+			filter = preq.Subject
+		}
+		seq, keep = preq.Sequence, preq.Keep
+	}
+	if keep > 0 && subjectHasWildcard(filter) {
+		return 0, errPurgeKeepWithHolds
+	}
+	var purged uint64
+	for subj := range store.SubjectsState(filter) {
+		if isHeldSubject(holds, subj) {
+			continue
+		}
+		n, err := store.PurgeEx(subj, seq, keep)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
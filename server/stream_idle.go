@@ -0,0 +1,158 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// With an IdlePause a stream that saw no publishes, pull requests or acks for
+// that long goes idle. Its consumers are paused, which stops their redelivery
+// timers, and the caches of its store are released. The next publish, pull
+// request or ack resumes the stream and its consumers, so this is transparent
+// to clients other than redeliveries being held while idle. Each server tracks
+// this for its own copy of the stream.
+
+// Smallest idle pause we allow.
+const minIdlePause = time.Second
+
+func checkIdlePause(cfg *StreamConfig) error {
+	if cfg.IdlePause < 0 {
+		return errors.New("idle pause can not be negative")
+	}
+	if cfg.IdlePause > 0 && cfg.IdlePause < minIdlePause {
+		return errors.New("idle pause must be at least 1s")
+	}
+	return nil
+}
+
+// Sets up idle tracking for our current config, resuming if no longer configured.
+// Lock should be held.
+func (mset *stream) setIdlePauseLocked() {
+	d := mset.cfg.IdlePause
+	atomic.StoreInt64(&mset.idleDur, int64(d))
+	if d <= 0 {
+		if mset.idleT != nil {
+			mset.idleT.Stop()
+			mset.idleT = nil
+		}
+		mset.resumeFromIdleLocked()
+		return
+	}
+	atomic.StoreInt64(&mset.lact, time.Now().UnixNano())
+	if mset.idleT == nil {
+		mset.idleT = time.AfterFunc(d, mset.checkIdle)
+	} else {
+		mset.idleT.Reset(d)
+	}
+}
+
+// Notes activity on this stream, resuming it if idle.
+func (mset *stream) noteActivity() {
+	if atomic.LoadInt64(&mset.idleDur) <= 0 {
+		return
+	}
+	atomic.StoreInt64(&mset.lact, time.Now().UnixNano())
+	if atomic.LoadInt32(&mset.idle) == 1 {
+		mset.mu.Lock()
+		mset.resumeFromIdleLocked()
+		mset.mu.Unlock()
+	}
+}
+
+// Returns true if we are idle and paused.
+func (mset *stream) isIdle() bool {
+	return atomic.LoadInt32(&mset.idle) == 1
+}
+
+// Invoked by our idle timer to go idle if there was no activity for our idle pause.
+func (mset *stream) checkIdle() {
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+
+	d := mset.cfg.IdlePause
+	if mset.client == nil || d <= 0 || mset.idleT == nil {
+		return
+	}
+	if since := time.Since(time.Unix(0, atomic.LoadInt64(&mset.lact))); since < d {
+		mset.idleT.Reset(d - since)
+		return
+	}
+	atomic.StoreInt32(&mset.idle, 1)
+	// Activity could have come in while we were checking, that one would not resume us.
+	if time.Since(time.Unix(0, atomic.LoadInt64(&mset.lact))) < d {
+		atomic.StoreInt32(&mset.idle, 0)
+		mset.idleT.Reset(d)
+		return
+	}
+
+	for _, o := range mset.getConsumers() {
+		o.pauseIdle()
+	}
+	if fs, ok := mset.store.(*fileStore); ok {
+		fs.expireCaches()
+	}
+	mset.srv.Debugf("JetStream stream '%s > %s' idle for %v, paused", mset.acc.Name, mset.cfg.Name, d)
+	// Our timer is not needed until we resume.
+}
+
+// Resumes our consumers if we were idle and restarts our idle timer.
+// Lock should be held.
+func (mset *stream) resumeFromIdleLocked() {
+	if !atomic.CompareAndSwapInt32(&mset.idle, 1, 0) {
+		return
+	}
+	for _, o := range mset.getConsumers() {
+		o.resumeIdle()
+	}
+	if d := mset.cfg.IdlePause; d > 0 && mset.idleT != nil {
+		mset.idleT.Reset(d)
+	}
+}
+
+// Lock should be held.
+func (mset *stream) stopIdleLocked() {
+	if mset.idleT != nil {
+		mset.idleT.Stop()
+		mset.idleT = nil
+	}
+	atomic.StoreInt64(&mset.idleDur, 0)
+}
+
+// Pauses us while our stream is idle, holding off redeliveries.
+func (o *consumer) pauseIdle() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.mset == nil {
+		return
+	}
+	o.idle = true
+	stopAndClearTimer(&o.ptmr)
+}
+
+// Resumes us once our stream is active again.
+func (o *consumer) resumeIdle() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.idle || o.mset == nil {
+		return
+	}
+	o.idle = false
+	if len(o.pending) > 0 && o.ptmr == nil {
+		o.ptmr = time.AfterFunc(o.ackWait(0), o.checkPending)
+	}
+	o.signalNewMessages()
+}
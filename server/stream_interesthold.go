@@ -0,0 +1,92 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"time"
+)
+
+// A message all consumers acknowledged, removed once held for long enough.
+type heldMsg struct {
+	seq uint64
+	at  int64
+}
+
+func checkInterestHold(cfg *StreamConfig) error {
+	if cfg.InterestHold < 0 {
+		return errors.New("interest hold can not be negative")
+	}
+	if cfg.InterestHold > 0 && cfg.Retention != InterestPolicy {
+		return errors.New("interest hold requires interest retention")
+	}
+	return nil
+}
+
+// Holds on to a message that lost all interest when configured to do so.
+// Returns false if it should be removed right away.
+func (mset *stream) holdAcked(seq uint64) bool {
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+
+	hold := mset.cfg.InterestHold
+	if hold <= 0 || mset.client == nil {
+		return false
+	}
+	// Holds are all of the same length, so our list stays ordered by when they are due.
+	mset.held = append(mset.held, heldMsg{seq, time.Now().Add(hold).UnixNano()})
+	if mset.heldT == nil {
+		mset.heldT = time.AfterFunc(hold, mset.expireHeld)
+	}
+	return true
+}
+
+// Removes the messages held for long enough, unless a consumer created in the
+// meantime has an interest in them. Those are held again once acknowledged.
+func (mset *stream) expireHeld() {
+	mset.mu.Lock()
+	if mset.client == nil {
+		mset.mu.Unlock()
+		return
+	}
+	now := time.Now().UnixNano()
+	var i int
+	var rmseqs []uint64
+	for ; i < len(mset.held) && mset.held[i].at <= now; i++ {
+		if seq := mset.held[i].seq; !mset.checkInterest(seq, nil) {
+			rmseqs = append(rmseqs, seq)
+		}
+	}
+	mset.held = append(mset.held[:0], mset.held[i:]...)
+	if len(mset.held) > 0 {
+		mset.heldT.Reset(time.Duration(mset.held[0].at - now))
+	} else {
+		mset.heldT = nil
+	}
+	store := mset.store
+	mset.mu.Unlock()
+
+	for _, seq := range rmseqs {
+		store.RemoveMsg(seq)
+	}
+}
+
+// Lock should be held.
+func (mset *stream) stopHeld() {
+	if mset.heldT != nil {
+		mset.heldT.Stop()
+		mset.heldT = nil
+	}
+	mset.held = nil
+}
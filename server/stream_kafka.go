@@ -0,0 +1,363 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KafkaSource configures a stream to ingest the records of a Kafka topic.
+// The server does not include a Kafka client, so this is only available to
+// servers embedded with a KafkaDialer, see WithJetStreamKafkaDialer. Streams
+// with a Kafka source are rejected by servers without one.
+type KafkaSource struct {
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+	Group   string   `json:"group,omitempty"`
+	// Subject the records are stored on, the topic if not set.
+	Subject string `json:"subject,omitempty"`
+}
+
+// KafkaRecord is a record read from a Kafka topic.
+type KafkaRecord struct {
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+}
+
+// KafkaClient reads the records of a single topic. Implementations wrap a Kafka client library.
+type KafkaClient interface {
+	// Fetch returns the next records of the topic. Offsets holds the next offset to read for
+	// the partitions we have stored records from, any other partition starts from the committed
+	// offset of the consumer group. It should wait up to maxWait for records to be available.
+	Fetch(offsets map[int32]int64, maxWait time.Duration) ([]*KafkaRecord, error)
+	// Commit stores the next offsets to read for the consumer group.
+	Commit(offsets map[int32]int64) error
+	Close() error
+}
+
+// KafkaDialer connects to the brokers of a stream's Kafka source.
+type KafkaDialer interface {
+	Dial(src *KafkaSource) (KafkaClient, error)
+}
+
+// Headers of messages ingested from Kafka.
+const (
+	JSKafkaTopic     = "Nats-Kafka-Topic"
+	JSKafkaPartition = "Nats-Kafka-Partition"
+	JSKafkaOffset    = "Nats-Kafka-Offset"
+	// Base64 encoded since keys can be binary.
+	JSKafkaKey = "Nats-Kafka-Key"
+	// Next offsets to read for all partitions, on the last record of each batch.
+	JSKafkaOffsets = "Nats-Kafka-Offsets"
+)
+
+const (
+	// How long a fetch waits for records.
+	kafkaFetchWait = time.Second
+	// How long to wait before retrying after an error.
+	kafkaRetryWait = 2 * time.Second
+)
+
+// State of a running Kafka ingest.
+type kafkaIngest struct {
+	src     *KafkaSource
+	offsets map[int32]int64
+	qch     chan struct{}
+}
+
+func (s *Server) checkStreamKafka(cfg *StreamConfig) error {
+	ks := cfg.Kafka
+	if ks == nil {
+		return nil
+	}
+	if cfg.Mirror != nil {
+		return errors.New("kafka source not allowed on mirrors")
+	}
+	if len(ks.Brokers) == 0 || ks.Topic == _EMPTY_ {
+		return errors.New("kafka source requires brokers and a topic")
+	}
+	if subj := ks.subject(); !IsValidLiteralSubject(subj) {
+		return fmt.Errorf("kafka source subject %q is not a valid literal subject", subj)
+	}
+	if s.getOpts().JetStreamKafkaDialer == nil {
+		return errors.New("kafka source requires a server embedded with a kafka dialer")
+	}
+	return nil
+}
+
+// Returns the subject records are stored on.
+func (ks *KafkaSource) subject() string {
+	if ks.Subject != _EMPTY_ {
+		return ks.Subject
+	}
+	return ks.Topic
+}
+
+// Starts ingesting our Kafka source, only the leader does this. The last record
+// of each batch carries the offsets we stored up to, so whichever server leads
+// resumes from our own messages. Records are also stored with a message id for
+// their topic, partition and offset, so any read again are dropped.
+// Lock should be held.
+func (mset *stream) startKafkaSourceLocked() {
+	ks := mset.cfg.Kafka
+	if ks == nil || mset.kafka != nil {
+		return
+	}
+	s := mset.srv
+	dialer := s.getOpts().JetStreamKafkaDialer
+	if dialer == nil {
+		s.Warnf("Kafka source for stream '%s > %s' requires a kafka dialer", mset.acc.Name, mset.cfg.Name)
+		return
+	}
+	src := *ks
+	ki := &kafkaIngest{
+		src:     &src,
+		offsets: make(map[int32]int64),
+		qch:     make(chan struct{}),
+	}
+	mset.kafka = ki
+	s.startGoRoutine(func() { mset.runKafkaIngest(ki, dialer) })
+}
+
+// Lock should be held.
+func (mset *stream) stopKafkaSourceLocked() {
+	if mset.kafka != nil {
+		close(mset.kafka.qch)
+		mset.kafka = nil
+	}
+}
+
+func (mset *stream) runKafkaIngest(ki *kafkaIngest, dialer KafkaDialer) {
+	s := mset.srv
+	defer s.grWG.Done()
+
+	mset.mu.RLock()
+	accName, name := mset.acc.Name, mset.cfg.Name
+	mset.mu.RUnlock()
+
+	if offsets := mset.lastKafkaOffsets(ki.src); offsets != nil {
+		ki.offsets = offsets
+	}
+
+	var kc KafkaClient
+	defer func() {
+		if kc != nil {
+			kc.Close()
+		}
+	}()
+
+	// Returns false if we should exit.
+	retry := func() bool {
+		select {
+		case <-ki.qch:
+			return false
+		case <-s.quitCh:
+			return false
+		case <-time.After(kafkaRetryWait):
+			return true
+		}
+	}
+
+	topic, subj := ki.src.Topic, ki.src.subject()
+	for {
+		select {
+		case <-ki.qch:
+			return
+		case <-s.quitCh:
+			return
+		default:
+		}
+		if kc == nil {
+			var err error
+			if kc, err = dialer.Dial(ki.src); err != nil {
+				kc = nil
+				s.RateLimitWarnf("Error connecting kafka source %q for '%s > %s': %v", topic, accName, name, err)
+				if !retry() {
+					return
+				}
+				continue
+			}
+		}
+		offsets := make(map[int32]int64, len(ki.offsets))
+		for p, off := range ki.offsets {
+			offsets[p] = off
+		}
+		recs, err := kc.Fetch(offsets, kafkaFetchWait)
+		if err != nil {
+			s.RateLimitWarnf("Error fetching from kafka source %q for '%s > %s': %v", topic, accName, name, err)
+			kc.Close()
+			kc = nil
+			if !retry() {
+				return
+			}
+			continue
+		}
+		// The last record we store checkpoints the offsets of the whole batch.
+		last, next := -1, make(map[int32]int64, len(ki.offsets))
+		for p, off := range ki.offsets {
+			next[p] = off
+		}
+		for i, r := range recs {
+			if off, ok := next[r.Partition]; !ok || r.Offset >= off {
+				next[r.Partition] = r.Offset + 1
+				last = i
+			}
+		}
+		var stored int
+		var failed bool
+		for i, r := range recs {
+			// Skip anything we already have.
+			if off, ok := ki.offsets[r.Partition]; ok && r.Offset < off {
+				continue
+			}
+			var ck string
+			if i == last {
+				ck = encodeKafkaOffsets(next)
+			}
+			if err := mset.processKafkaRecord(subj, topic, r, ck); err != nil && err != errMsgIdDuplicate {
+				s.RateLimitWarnf("Error storing record from kafka source %q for '%s > %s': %v", topic, accName, name, err)
+				failed = true
+				break
+			}
+			ki.offsets[r.Partition] = r.Offset + 1
+			stored++
+		}
+		if stored > 0 {
+			if err := kc.Commit(ki.offsets); err != nil {
+				s.RateLimitWarnf("Error committing offsets of kafka source %q for '%s > %s': %v", topic, accName, name, err)
+			}
+		}
+		// Try again from where we failed later.
+		if failed && !retry() {
+			return
+		}
+	}
+}
+
+// Stores a record, proposing it to our group if we are clustered. A non empty
+// checkpoint is stored along as the offsets to resume from.
+func (mset *stream) processKafkaRecord(subj, topic string, r *KafkaRecord, ck string) error {
+	var hdr []byte
+	for k, v := range r.Headers {
+		hdr = genHeader(hdr, k, v)
+	}
+	hdr = genHeader(hdr, JSMsgId, fmt.Sprintf("%s:%d:%d", topic, r.Partition, r.Offset))
+	hdr = genHeader(hdr, JSKafkaTopic, topic)
+	hdr = genHeader(hdr, JSKafkaPartition, strconv.FormatInt(int64(r.Partition), 10))
+	hdr = genHeader(hdr, JSKafkaOffset, strconv.FormatInt(r.Offset, 10))
+	if len(r.Key) > 0 {
+		hdr = genHeader(hdr, JSKafkaKey, base64.StdEncoding.EncodeToString(r.Key))
+	}
+	if ck != _EMPTY_ {
+		hdr = genHeader(hdr, JSKafkaOffsets, ck)
+	}
+
+	mset.mu.RLock()
+	node := mset.node
+	mset.mu.RUnlock()
+
+	if node != nil {
+		return mset.processClusteredInboundMsg(subj, _EMPTY_, hdr, r.Value)
+	}
+	return mset.processJetStreamMsg(subj, _EMPTY_, hdr, r.Value, 0, 0)
+}
+
+// Returns the offsets of the last checkpoint for our source stored in our stream,
+// nil if there is none.
+func (mset *stream) lastKafkaOffsets(src *KafkaSource) map[int32]int64 {
+	mset.mu.RLock()
+	store := mset.store
+	mset.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+
+	subj := src.subject()
+	var smv StoreMsg
+	sm, err := store.LoadLastMsg(subj, &smv)
+	if err != nil {
+		return nil
+	}
+	var state StreamState
+	store.FastState(&state)
+	// Others can publish to our subject as well, so walk back to our last checkpoint.
+	for seq := sm.seq; seq > 0 && seq >= state.FirstSeq; seq-- {
+		sm, err := store.LoadMsg(seq, &smv)
+		if err != nil || sm.subj != subj {
+			continue
+		}
+		ck := getHeader(JSKafkaOffsets, sm.hdr)
+		if len(ck) == 0 {
+			continue
+		}
+		if string(getHeader(JSKafkaTopic, sm.hdr)) != src.Topic {
+			return nil
+		}
+		offsets, err := decodeKafkaOffsets(string(ck))
+		if err != nil {
+			mset.srv.Warnf("Could not load kafka offsets for stream '%s > %s': %v", mset.accName(), mset.name(), err)
+			return nil
+		}
+		return offsets
+	}
+	return nil
+}
+
+// Encodes offsets as "<partition>:<offset>" pairs, separated by commas.
+func encodeKafkaOffsets(offsets map[int32]int64) string {
+	ps := make([]int32, 0, len(offsets))
+	for p := range offsets {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+	var sb strings.Builder
+	for i, p := range ps {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.FormatInt(int64(p), 10))
+		sb.WriteByte(':')
+		sb.WriteString(strconv.FormatInt(offsets[p], 10))
+	}
+	return sb.String()
+}
+
+func decodeKafkaOffsets(ck string) (map[int32]int64, error) {
+	offsets := make(map[int32]int64)
+	for _, e := range strings.Split(ck, ",") {
+		ps, offs, ok := strings.Cut(e, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid kafka offset %q", e)
+		}
+		p, err := strconv.ParseInt(ps, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kafka partition %q", ps)
+		}
+		off, err := strconv.ParseInt(offs, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kafka offset %q", offs)
+		}
+		offsets[int32(p)] = off
+	}
+	return offsets, nil
+}
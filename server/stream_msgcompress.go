@@ -0,0 +1,53 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/klauspost/compress/s2"
+)
+
+// Header set on messages whose payload the stream compressed, holding the compression used.
+const JSCompressed = "Nats-Compressed"
+
+// Returns the header and payload to store for a message, compressed when the payload
+// is larger than threshold and compressing it actually saves space.
+// Messages that already have a JSCompressed header are left alone.
+func compressMsg(threshold int, hdr, msg []byte) ([]byte, []byte) {
+	if threshold <= 0 || len(msg) <= threshold || len(getHeader(JSCompressed, hdr)) > 0 {
+		return hdr, msg
+	}
+	cmsg := s2.Encode(nil, msg)
+	if len(cmsg) >= len(msg) {
+		return hdr, msg
+	}
+	return genHeader(hdr, JSCompressed, s2CompressionString), cmsg
+}
+
+// Replaces a compressed payload with the original one and drops the JSCompressed header.
+// Payloads we can not decompress are delivered as is.
+func decompressPubMsg(pmsg *jsPubMsg) {
+	if string(getHeader(JSCompressed, pmsg.hdr)) != s2CompressionString {
+		return
+	}
+	msg, err := s2.Decode(nil, pmsg.msg)
+	if err != nil {
+		return
+	}
+	hdr := removeHeaderIfPresent(copyBytes(pmsg.hdr), JSCompressed)
+	pmsg.buf = append(append(pmsg.buf[:0], hdr...), msg...)
+	pmsg.hdr, pmsg.msg = pmsg.buf[:len(hdr):len(hdr)], pmsg.buf[len(hdr):]
+	if len(hdr) == 0 {
+		pmsg.hdr = nil
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nats-io/nuid"
+)
+
+// StreamOffload moves payloads over Threshold bytes into an object store bucket.
+// The stream keeps a pointer message with the original headers, and the payload
+// is put back in place for consumer deliveries and direct gets.
+// The bucket needs to exist and not be clustered. Objects are not removed along
+// with their pointer messages, so the bucket should have its own limits.
+type StreamOffload struct {
+	ObjectStore string `json:"object_store"`
+	Threshold   int64  `json:"threshold"`
+}
+
+// Headers for pointer messages to offloaded payloads.
+const (
+	JSOffloadBucket = "Nats-Offload-Bucket"
+	JSOffloadKey    = "Nats-Offload-Key"
+	JSOffloadSize   = "Nats-Offload-Size"
+)
+
+// Validate the offload configuration for a stream.
+func checkStreamOffload(cfg *StreamConfig) error {
+	ocfg := cfg.Offload
+	if ocfg == nil {
+		return nil
+	}
+	if !isValidName(ocfg.ObjectStore) {
+		return errors.New("offload object store name is not valid")
+	}
+	if ocfg.Threshold <= 0 {
+		return errors.New("offload threshold must be greater than 0")
+	}
+	return nil
+}
+
+// If the payload is over our offload threshold, store it in the object store
+// and return the pointer message to store instead.
+// On any error the original message is returned and stored as normal.
+func (mset *stream) offloadPayload(subject string, hdr, msg []byte) ([]byte, []byte) {
+	mset.mu.RLock()
+	ocfg, name, acc := mset.cfg.Offload, mset.cfg.Name, mset.acc
+	mset.mu.RUnlock()
+
+	if ocfg == nil || int64(len(msg)) <= ocfg.Threshold || acc == nil {
+		return hdr, msg
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", name, subject, nuid.Next())
+	if _, err := putObject(acc, ocfg.ObjectStore, key, nil, msg); err != nil {
+		mset.srv.RateLimitWarnf("JetStream could not offload payload on stream '%s > %s': %v", acc.Name, name, err)
+		return hdr, msg
+	}
+
+	phdr := genHeader(hdr, JSOffloadBucket, ocfg.ObjectStore)
+	phdr = genHeader(phdr, JSOffloadKey, key)
+	return genHeader(phdr, JSOffloadSize, strconv.Itoa(len(msg))), nil
+}
+
+// Puts an offloaded payload back in place of a pointer message about to be sent.
+// On any error the pointer message is sent as is.
+func rematerializePayload(acc *Account, pm *jsPubMsg) {
+	if len(pm.msg) > 0 || len(pm.hdr) == 0 || acc == nil {
+		return
+	}
+	bucket := getHeader(JSOffloadBucket, pm.hdr)
+	if len(bucket) == 0 {
+		return
+	}
+	key := getHeader(JSOffloadKey, pm.hdr)
+	msg, err := getObject(acc, string(bucket), string(key))
+	if err != nil {
+		acc.srv.RateLimitWarnf("JetStream could not load offloaded payload %q for account %q: %v", key, acc.Name, err)
+		return
+	}
+	hdr := copyBytes(pm.hdr)
+	hdr = removeHeaderIfPresent(hdr, JSOffloadBucket)
+	hdr = removeHeaderIfPresent(hdr, JSOffloadKey)
+	hdr = removeHeaderIfPresent(hdr, JSOffloadSize)
+	// The wire buffer no longer matches so drop it.
+	pm.hdr, pm.msg, pm.buf = hdr, msg, nil
+}
@@ -0,0 +1,295 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+// StreamOverflow diverts messages larger than the stream's MaxMsgSize to another
+// stream or to an object store bucket. A pointer message holding only headers
+// that reference the diverted message is stored in the original's place.
+// The overflow target needs to exist and not be clustered.
+type StreamOverflow struct {
+	Stream      string `json:"stream,omitempty"`
+	ObjectStore string `json:"object_store,omitempty"`
+}
+
+// Headers for pointer messages to diverted oversize messages.
+const (
+	JSOverflowStream   = "Nats-Overflow-Stream"
+	JSOverflowSequence = "Nats-Overflow-Sequence"
+	JSOverflowBucket   = "Nats-Overflow-Bucket"
+	JSOverflowKey      = "Nats-Overflow-Key"
+	JSOverflowSize     = "Nats-Overflow-Size"
+)
+
+const (
+	// Object store layout, compatible with the client object store.
+	objStreamPre    = "OBJ_"
+	objChunkSubjT   = "$O.%s.C.%s"
+	objMetaSubjT    = "$O.%s.M.%s"
+	objMaxChunkSize = 128 * 1024
+
+	// Pointer messages still need to fit within the stream's max message size.
+	overflowMinMaxMsgSize = 512
+)
+
+// Headers we carry over to a pointer message so dedupe and
+// publish expectations still apply to it.
+var overflowCarryHeaders = []string{
+	JSMsgId,
+	JSExpectedStream,
+	JSExpectedLastSeq,
+	JSExpectedLastSubjSeq,
+	JSExpectedLastMsgId,
+	JSMsgRollup,
+}
+
+var errOverflowClustered = errors.New("overflow target can not be clustered")
+
+// Validate the overflow configuration for a stream.
+func checkStreamOverflow(cfg *StreamConfig) error {
+	ocfg := cfg.Overflow
+	if ocfg == nil {
+		return nil
+	}
+	if cfg.MaxMsgSize < overflowMinMaxMsgSize {
+		return fmt.Errorf("overflow requires a max message size of at least %d", overflowMinMaxMsgSize)
+	}
+	if (ocfg.Stream == _EMPTY_) == (ocfg.ObjectStore == _EMPTY_) {
+		return errors.New("overflow requires exactly one of stream or object store")
+	}
+	if ocfg.Stream != _EMPTY_ {
+		if !isValidName(ocfg.Stream) {
+			return errors.New("overflow stream name is not valid")
+		}
+		if ocfg.Stream == cfg.Name {
+			return errors.New("overflow stream can not be the stream itself")
+		}
+	}
+	if ocfg.ObjectStore != _EMPTY_ && !isValidName(ocfg.ObjectStore) {
+		return errors.New("overflow object store name is not valid")
+	}
+	return nil
+}
+
+// If the message is over our max message size and we have an overflow configured,
+// divert it and return the pointer message to store instead.
+// On any error the original message is returned and will be rejected as before.
+func (mset *stream) divertOversizeMsg(subject string, hdr, msg []byte) ([]byte, []byte) {
+	mset.mu.RLock()
+	ocfg, maxMsgSize, name, acc := mset.cfg.Overflow, int(mset.cfg.MaxMsgSize), mset.cfg.Name, mset.acc
+	mset.mu.RUnlock()
+
+	if ocfg == nil || maxMsgSize < 0 || len(hdr)+len(msg) <= maxMsgSize || acc == nil {
+		return hdr, msg
+	}
+
+	var phdr []byte
+	var err error
+	if ocfg.Stream != _EMPTY_ {
+		phdr, err = divertToStream(acc, ocfg.Stream, subject, hdr, msg)
+	} else {
+		phdr, err = divertToObjectStore(acc, ocfg.ObjectStore, name, subject, hdr, msg)
+	}
+	if err != nil {
+		mset.srv.RateLimitWarnf("JetStream could not divert oversize msg on stream '%s > %s': %v", acc.Name, name, err)
+		return hdr, msg
+	}
+
+	phdr = genHeader(phdr, JSOverflowSize, strconv.Itoa(len(hdr)+len(msg)))
+	for _, key := range overflowCarryHeaders {
+		if v := getHeader(key, hdr); len(v) > 0 {
+			phdr = genHeader(phdr, key, string(v))
+		}
+	}
+	return phdr, nil
+}
+
+// Store the whole message in the overflow stream.
+func divertToStream(acc *Account, oname, subject string, hdr, msg []byte) ([]byte, error) {
+	omset, err := acc.lookupStream(oname)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := omset.storeDirect(subject, hdr, msg)
+	if err != nil {
+		return nil, err
+	}
+	phdr := genHeader(nil, JSOverflowStream, oname)
+	return genHeader(phdr, JSOverflowSequence, strconv.FormatUint(seq, 10)), nil
+}
+
+// Object store meta record, mirrors the client object info.
+type objectInfo struct {
+	Name    string      `json:"name"`
+	Headers http.Header `json:"headers,omitempty"`
+	Bucket  string      `json:"bucket"`
+	NUID    string      `json:"nuid"`
+	Size    uint64      `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Chunks  uint32      `json:"chunks"`
+	Digest  string      `json:"digest,omitempty"`
+}
+
+// Store the message payload as an object, keeping any headers in the object meta.
+func divertToObjectStore(acc *Account, bucket, stream, subject string, hdr, msg []byte) ([]byte, error) {
+	info, err := putObject(acc, bucket, fmt.Sprintf("%s/%s/%s", stream, subject, nuid.Next()), hdr, msg)
+	if err != nil {
+		return nil, err
+	}
+	phdr := genHeader(nil, JSOverflowBucket, bucket)
+	return genHeader(phdr, JSOverflowKey, info.Name), nil
+}
+
+// Writes msg as the object name into bucket, with any headers kept in the object meta.
+func putObject(acc *Account, bucket, name string, hdr, msg []byte) (*objectInfo, error) {
+	omset, err := acc.lookupStream(objStreamPre + bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	id := nuid.Next()
+	info := &objectInfo{
+		Name:    name,
+		Bucket:  bucket,
+		NUID:    id,
+		Size:    uint64(len(msg)),
+		ModTime: time.Now().UTC(),
+	}
+	if len(hdr) > 0 {
+		tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(hdr)))
+		tp.ReadLine() // skip over first line, contains version
+		if mh, err := tp.ReadMIMEHeader(); err == nil {
+			info.Headers = http.Header(mh)
+		}
+	}
+
+	chunkSubj := fmt.Sprintf(objChunkSubjT, bucket, id)
+	for data := msg; len(data) > 0; {
+		chunk := data
+		if len(chunk) > objMaxChunkSize {
+			chunk = chunk[:objMaxChunkSize]
+		}
+		if _, err := omset.storeDirect(chunkSubj, nil, chunk); err != nil {
+			return nil, err
+		}
+		info.Chunks++
+		data = data[len(chunk):]
+	}
+	sum := sha256.Sum256(msg)
+	info.Digest = "SHA-256=" + base64.URLEncoding.EncodeToString(sum[:])
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := omset.storeDirect(objMetaSubject(bucket, name), nil, b); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Reads back the payload of the object name from bucket.
+func getObject(acc *Account, bucket, name string) ([]byte, error) {
+	omset, err := acc.lookupStream(objStreamPre + bucket)
+	if err != nil {
+		return nil, err
+	}
+	omset.mu.RLock()
+	store := omset.store
+	omset.mu.RUnlock()
+	if store == nil {
+		return nil, ErrStoreClosed
+	}
+
+	var smv StoreMsg
+	sm, err := store.LoadLastMsg(objMetaSubject(bucket, name), &smv)
+	if err != nil {
+		return nil, err
+	}
+	var info objectInfo
+	if err := json.Unmarshal(sm.msg, &info); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, info.Size)
+	chunkSubj := fmt.Sprintf(objChunkSubjT, bucket, info.NUID)
+	for seq, n := uint64(0), uint32(0); n < info.Chunks; n++ {
+		sm, nseq, err := store.LoadNextMsg(chunkSubj, false, seq, &smv)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sm.msg...)
+		seq = nseq + 1
+	}
+	if uint64(len(data)) != info.Size {
+		return nil, fmt.Errorf("object %q size mismatch", name)
+	}
+	return data, nil
+}
+
+func objMetaSubject(bucket, name string) string {
+	return fmt.Sprintf(objMetaSubjT, bucket, base64.URLEncoding.EncodeToString([]byte(name)))
+}
+
+// Stores a message directly into our store and signals consumers.
+// This is only used for diverted messages which have already been accepted
+// by the originating stream, so we only check our own max message size.
+func (mset *stream) storeDirect(subject string, hdr, msg []byte) (uint64, error) {
+	mset.mu.Lock()
+	if mset.isClustered() {
+		mset.mu.Unlock()
+		return 0, errOverflowClustered
+	}
+	store := mset.store
+	if store == nil || mset.client == nil {
+		mset.mu.Unlock()
+		return 0, ErrStoreClosed
+	}
+	if mset.cfg.MaxMsgSize >= 0 && len(hdr)+len(msg) > int(mset.cfg.MaxMsgSize) {
+		mset.mu.Unlock()
+		return 0, ErrMaxPayload
+	}
+	seq, _, err := store.StoreMsg(subject, hdr, msg)
+	if err != nil {
+		mset.mu.Unlock()
+		return 0, err
+	}
+	mset.lseq = seq
+	numConsumers := len(mset.consumers)
+	mset.mu.Unlock()
+
+	if numConsumers > 0 {
+		mset.sigq.push(newCMsg(subject, seq))
+		select {
+		case mset.sch <- struct{}{}:
+		default:
+		}
+	}
+	return seq, nil
+}
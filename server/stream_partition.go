@@ -0,0 +1,309 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// A partitioned stream is a stream with a Partitions count. It does not store
+// messages itself, instead the server creates that many partition streams,
+// named after it, that store the messages published to its subjects based on
+// a hash of the subject. So all messages on a subject are in the same partition,
+// in order. The limits of the stream apply to each of its partitions.
+// Consumers created on a partitioned stream are created on all its partitions
+// and need to be push consumers, their info is the sum of theirs.
+//
+// Partitioned streams are only available on servers that are not clustered.
+// The partitions are local streams the server hands messages to, while in
+// clustered mode every stream is placed on its own raft group by the meta
+// leader, so a stream and its partitions could end up on different servers.
+// Creating one in clustered mode is refused by the meta leader.
+
+// StreamPartitionInfo shows information about a partition of a stream.
+type StreamPartitionInfo struct {
+	Name  string      `json:"name"`
+	State StreamState `json:"state"`
+}
+
+var errStreamPartitionsClustered = errors.New("stream partitions not supported in clustered mode")
+
+// Maximum number of partitions of a stream.
+const maxStreamPartitions = 256
+
+// Returns the name of a partition of a stream.
+func streamPartitionName(stream string, i int) string {
+	return stream + "-" + strconv.Itoa(i)
+}
+
+// Returns the partition a message on this subject belongs to.
+func streamPartitionIndex(subject string, partitions int) int {
+	h := fnv.New32a()
+	h.Write([]byte(subject))
+	return int(h.Sum32() % uint32(partitions))
+}
+
+func (s *Server) checkStreamPartitions(cfg *StreamConfig) error {
+	if cfg.PartitionOf != _EMPTY_ {
+		if cfg.Partitions != 0 {
+			return errors.New("stream partition can not be partitioned")
+		}
+		return nil
+	}
+	if cfg.Partitions == 0 {
+		return nil
+	}
+	if cfg.Partitions < 2 || cfg.Partitions > maxStreamPartitions {
+		return fmt.Errorf("stream partitions must be between 2 and %d", maxStreamPartitions)
+	}
+	if cfg.Mirror != nil || len(cfg.Sources) > 0 {
+		return errors.New("stream partitions not allowed with mirrors or sources")
+	}
+	if s.JetStreamIsClustered() {
+		return errStreamPartitionsClustered
+	}
+	if !isValidName(streamPartitionName(cfg.Name, cfg.Partitions-1)) {
+		return errors.New("stream partition names are not valid")
+	}
+	return nil
+}
+
+// Returns the config of a partition of a partitioned stream.
+func (cfg *StreamConfig) partitionConfig(i int) (StreamConfig, error) {
+	var pcfg StreamConfig
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return pcfg, err
+	}
+	if err := json.Unmarshal(b, &pcfg); err != nil {
+		return pcfg, err
+	}
+	pcfg.Name = streamPartitionName(cfg.Name, i)
+	pcfg.Partitions, pcfg.PartitionOf = 0, cfg.Name
+	return pcfg, nil
+}
+
+// Returns true if this is a partitioned stream.
+func (mset *stream) isPartitioned() bool {
+	mset.mu.RLock()
+	defer mset.mu.RUnlock()
+	return mset.cfg.Partitions > 0
+}
+
+// Creates the partitions of a partitioned stream.
+func (a *Account) addStreamPartitions(mset *stream) error {
+	cfg := mset.config()
+	for i := 0; i < cfg.Partitions; i++ {
+		pcfg, err := cfg.partitionConfig(i)
+		if err != nil {
+			return err
+		}
+		if _, err := a.addStream(&pcfg); err != nil {
+			return fmt.Errorf("partition %q: %v", pcfg.Name, err)
+		}
+	}
+	return nil
+}
+
+// Updates the partitions of a partitioned stream to its config.
+func (mset *stream) updatePartitions() error {
+	cfg := mset.config()
+	for i := 0; i < cfg.Partitions; i++ {
+		pset, err := mset.partition(i)
+		if err != nil {
+			return err
+		}
+		pcfg, err := cfg.partitionConfig(i)
+		if err != nil {
+			return err
+		}
+		if err := pset.update(&pcfg); err != nil {
+			return fmt.Errorf("partition %q: %v", pcfg.Name, err)
+		}
+	}
+	return nil
+}
+
+// Removes the partitions of a partitioned stream, deleting them or moving them
+// to the trash.
+func (mset *stream) removePartitions(trash bool) error {
+	cfg := mset.config()
+	for i := 0; i < cfg.Partitions; i++ {
+		pset, err := mset.partition(i)
+		if err != nil {
+			continue
+		}
+		if trash {
+			err = pset.trash()
+		} else {
+			err = pset.delete()
+		}
+		if err != nil {
+			return fmt.Errorf("partition %q: %v", pset.name(), err)
+		}
+	}
+	return nil
+}
+
+// Returns a partition of our stream.
+func (mset *stream) partition(i int) (*stream, error) {
+	mset.mu.RLock()
+	acc, name := mset.acc, mset.cfg.Name
+	mset.mu.RUnlock()
+	return acc.lookupStream(streamPartitionName(name, i))
+}
+
+// Hands a message published to a partitioned stream to its partition.
+func (mset *stream) processPartitionedMsg(c *client, subject, reply string, rmsg []byte, partitions int) {
+	pset, err := mset.partition(streamPartitionIndex(subject, partitions))
+	if err != nil {
+		if reply != _EMPTY_ {
+			resp := JSPubAckResponse{PubAck: &PubAck{Stream: mset.name()}, Error: NewJSStreamNotFoundError()}
+			b, _ := json.Marshal(resp)
+			mset.outq.sendMsg(reply, b)
+		}
+		return
+	}
+	pset.processInboundJetStreamMsg(nil, c, nil, subject, reply, rmsg)
+}
+
+// Returns the state of a partitioned stream, the sum of the state of its
+// partitions, along with theirs.
+func (mset *stream) partitionsState() (StreamState, []*StreamPartitionInfo) {
+	var state StreamState
+	var infos []*StreamPartitionInfo
+	cfg := mset.config()
+	for i := 0; i < cfg.Partitions; i++ {
+		pset, err := mset.partition(i)
+		if err != nil {
+			continue
+		}
+		ps := pset.state()
+		infos = append(infos, &StreamPartitionInfo{Name: pset.name(), State: ps})
+		state.Msgs += ps.Msgs
+		state.Bytes += ps.Bytes
+		state.NumDeleted += ps.NumDeleted
+		state.NumSubjects += ps.NumSubjects
+		if ps.Msgs > 0 {
+			if state.FirstTime.IsZero() || ps.FirstTime.Before(state.FirstTime) {
+				state.FirstTime = ps.FirstTime
+			}
+			if ps.LastTime.After(state.LastTime) {
+				state.LastTime = ps.LastTime
+			}
+		}
+	}
+	if len(infos) > 0 {
+		state.Consumers = infos[0].State.Consumers
+	}
+	return state, infos
+}
+
+// Creates a consumer on all the partitions of our stream.
+func (mset *stream) addPartitionsConsumer(config *ConsumerConfig) (*ConsumerInfo, error) {
+	if config.DeliverSubject == _EMPTY_ {
+		return nil, errors.New("consumers of partitioned streams need a deliver subject")
+	}
+	// All partitions need the same name, pick one now for ephemerals.
+	if config.Durable == _EMPTY_ && config.Name == _EMPTY_ {
+		config.Name = createConsumerName()
+	}
+	partitions := mset.config().Partitions
+	for i := 0; i < partitions; i++ {
+		pset, err := mset.partition(i)
+		if err != nil {
+			return nil, err
+		}
+		ccfg := *config
+		if _, err := pset.addConsumer(&ccfg); err != nil {
+			return nil, fmt.Errorf("partition %q: %v", pset.name(), err)
+		}
+	}
+	name := config.Name
+	if name == _EMPTY_ {
+		name = config.Durable
+	}
+	return mset.partitionsConsumerInfo(name), nil
+}
+
+// Returns the info of a consumer of a partitioned stream, the sum of the
+// info of the consumer on all partitions, or nil if not found.
+func (mset *stream) partitionsConsumerInfo(name string) *ConsumerInfo {
+	var info *ConsumerInfo
+	partitions := mset.config().Partitions
+	for i := 0; i < partitions; i++ {
+		pset, err := mset.partition(i)
+		if err != nil {
+			return nil
+		}
+		o := pset.lookupConsumer(name)
+		if o == nil {
+			return nil
+		}
+		oi := o.info()
+		if oi == nil {
+			return nil
+		}
+		if info == nil {
+			info = &ConsumerInfo{
+				Stream:    mset.name(),
+				Name:      oi.Name,
+				Created:   oi.Created,
+				Config:    oi.Config,
+				PushBound: oi.PushBound,
+			}
+		}
+		info.Delivered.Consumer += oi.Delivered.Consumer
+		info.AckFloor.Consumer += oi.AckFloor.Consumer
+		info.Delivered.Last = laterTime(info.Delivered.Last, oi.Delivered.Last)
+		info.AckFloor.Last = laterTime(info.AckFloor.Last, oi.AckFloor.Last)
+		info.NumAckPending += oi.NumAckPending
+		info.NumRedelivered += oi.NumRedelivered
+		info.NumWaiting += oi.NumWaiting
+		info.NumPending += oi.NumPending
+	}
+	return info
+}
+
+func laterTime(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
+
+// Deletes a consumer from all the partitions of our stream.
+// Returns false if not found.
+func (mset *stream) deletePartitionsConsumer(name string) (bool, error) {
+	var found bool
+	partitions := mset.config().Partitions
+	for i := 0; i < partitions; i++ {
+		pset, err := mset.partition(i)
+		if err != nil {
+			continue
+		}
+		if o := pset.lookupConsumer(name); o != nil {
+			found = true
+			if err := o.delete(); err != nil {
+				return found, err
+			}
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// StreamRateLimit limits how fast messages can be published to a stream.
+// Messages over the limit are rejected, unless Backpressure is set in which
+// case they are held back for up to MaxWait and stored once it is their turn,
+// which slows down publishers waiting for their acks. Messages are held in a
+// queue of the stream, never in the connection they came in on.
+type StreamRateLimit struct {
+	Msgs         int64         `json:"msgs_per_sec,omitempty"`
+	Bytes        int64         `json:"bytes_per_sec,omitempty"`
+	Backpressure bool          `json:"backpressure,omitempty"`
+	MaxWait      time.Duration `json:"max_wait,omitempty"`
+}
+
+// Default for how long we hold up a publisher when applying backpressure.
+const defaultStreamRateLimitMaxWait = time.Second
+
+// Validate the rate limit configuration for a stream.
+func checkStreamRateLimit(cfg *StreamConfig) error {
+	rl := cfg.RateLimit
+	if rl == nil {
+		return nil
+	}
+	if rl.Msgs < 0 || rl.Bytes < 0 || rl.MaxWait < 0 {
+		return errors.New("rate limit values can not be negative")
+	}
+	if rl.Msgs == 0 && rl.Bytes == 0 {
+		return errors.New("rate limit requires messages or bytes per second")
+	}
+	if cfg.Mirror != nil {
+		return errors.New("rate limit not allowed on mirrors")
+	}
+	if rl.Backpressure && rl.MaxWait == 0 {
+		rl.MaxWait = defaultStreamRateLimitMaxWait
+	}
+	return nil
+}
+
+// Sets up our rate limiters from the config.
+// Lock should be held.
+func (mset *stream) setRateLimitLocked(rl *StreamRateLimit) {
+	mset.rlMsgs, mset.rlBytes = nil, nil
+	if rl == nil {
+		return
+	}
+	// Allow bursts of up to a second worth of messages and bytes.
+	if rl.Msgs > 0 {
+		mset.rlMsgs = rate.NewLimiter(rate.Limit(rl.Msgs), int(rl.Msgs))
+	}
+	if rl.Bytes > 0 {
+		mset.rlBytes = rate.NewLimiter(rate.Limit(rl.Bytes), int(rl.Bytes))
+	}
+}
+
+// A message held back by our rate limit, along with when it is its turn.
+type deferredMsg struct {
+	im  *inMsg
+	due time.Time
+}
+
+// Checks an inbound message against our rate limit. If the message needs to be
+// held back, returns true along with how long until its turn. The last value is
+// false if the message is over the limit and needs to be rejected.
+func (mset *stream) checkRateLimit(size int) (time.Duration, bool, bool) {
+	mset.mu.RLock()
+	rlMsgs, rlBytes, rl := mset.rlMsgs, mset.rlBytes, mset.cfg.RateLimit
+	mset.mu.RUnlock()
+
+	if rl == nil {
+		return 0, false, true
+	}
+
+	now := time.Now()
+	var delay time.Duration
+	var rsv []*rate.Reservation
+	if rlMsgs != nil {
+		r := rlMsgs.ReserveN(now, 1)
+		rsv = append(rsv, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+	if rlBytes != nil {
+		// Messages larger than our burst only need to fit in a full burst.
+		if size > rlBytes.Burst() {
+			size = rlBytes.Burst()
+		}
+		r := rlBytes.ReserveN(now, size)
+		rsv = append(rsv, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+	if delay == 0 {
+		// Can not go ahead of messages held back before us.
+		return 0, rl.Backpressure && mset.hasDeferredMsgs(), true
+	}
+	if rl.Backpressure && delay <= rl.MaxWait {
+		return delay, true, true
+	}
+	// Give back what we took.
+	for _, r := range rsv {
+		r.CancelAt(now)
+	}
+	return 0, false, false
+}
+
+// Returns true if we hold back messages for our rate limit, or released
+// ones are still waiting to be processed.
+func (mset *stream) hasDeferredMsgs() bool {
+	mset.rlMu.Lock()
+	defer mset.rlMu.Unlock()
+	return len(mset.rlq) > 0 || mset.rlT != nil || mset.msgs.len() > 0
+}
+
+// Holds back an inbound message until its turn, when it is queued
+// for our internal loop like any message not from a client.
+func (mset *stream) deferInboundMsg(subj, rply string, hdr, msg []byte, delay time.Duration) {
+	if len(hdr) > 0 {
+		hdr = copyBytes(hdr)
+	}
+	if len(msg) > 0 {
+		msg = copyBytes(msg)
+	}
+	mset.rlMu.Lock()
+	defer mset.rlMu.Unlock()
+	due := time.Now().Add(delay)
+	// Keep our queue in order, a message can not go before the ones ahead of it.
+	if n := len(mset.rlq); n > 0 && due.Before(mset.rlq[n-1].due) {
+		due = mset.rlq[n-1].due
+	}
+	mset.rlq = append(mset.rlq, &deferredMsg{&inMsg{subj, rply, hdr, msg}, due})
+	if mset.rlT == nil {
+		mset.rlT = time.AfterFunc(delay, mset.releaseDeferredMsgs)
+	}
+}
+
+// Queues the held back messages whose turn it is.
+func (mset *stream) releaseDeferredMsgs() {
+	mset.rlMu.Lock()
+	defer mset.rlMu.Unlock()
+	if mset.rlT == nil {
+		return
+	}
+	now := time.Now()
+	var i int
+	for ; i < len(mset.rlq) && !mset.rlq[i].due.After(now); i++ {
+		im := mset.rlq[i].im
+		mset.queueInbound(mset.msgs, im.subj, im.rply, im.hdr, im.msg)
+		mset.rlq[i] = nil
+	}
+	mset.rlq = mset.rlq[i:]
+	if len(mset.rlq) == 0 {
+		mset.rlq, mset.rlT = nil, nil
+		return
+	}
+	mset.rlT.Reset(time.Until(mset.rlq[0].due))
+}
+
+// Drops any held back messages, on stop.
+func (mset *stream) stopDeferredMsgs() {
+	mset.rlMu.Lock()
+	defer mset.rlMu.Unlock()
+	if mset.rlT != nil {
+		mset.rlT.Stop()
+		mset.rlT = nil
+	}
+	mset.rlq = nil
+}
+
+// Lets a publisher know they are over our rate limit.
+func (mset *stream) sendRateLimitExceeded(reply string) {
+	if reply == _EMPTY_ {
+		return
+	}
+	resp := JSPubAckResponse{
+		PubAck: &PubAck{Stream: mset.name()},
+		Error:  NewJSStreamRateLimitExceededError(),
+	}
+	b, _ := json.Marshal(resp)
+	mset.outq.sendMsg(reply, b)
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Checks that a remap request is valid for this stream.
+// The remapped subjects need to stay within the stream's subjects and can not
+// match the filter again, which keeps replaying a remap idempotent.
+func (mset *stream) checkRemapRequest(req *JSApiStreamRemapRequest) error {
+	mset.mu.RLock()
+	cfg := mset.cfg
+	mset.mu.RUnlock()
+
+	if cfg.Mirror != nil {
+		return errors.New("can not remap subjects of a mirror")
+	}
+	if req.Filter == _EMPTY_ || req.Destination == _EMPTY_ {
+		return errors.New("filter and destination are required")
+	}
+	tr, err := newTransform(req.Filter, req.Destination)
+	if err != nil {
+		return fmt.Errorf("invalid remap %q to %q: %v", req.Filter, req.Destination, err)
+	}
+	dest := tr.destFilter()
+	if SubjectsCollide(req.Filter, dest) {
+		return fmt.Errorf("remapped subjects %q overlap filter %q", dest, req.Filter)
+	}
+	var inStream bool
+	for _, subj := range cfg.Subjects {
+		if subjectIsSubsetMatch(dest, subj) {
+			inStream = true
+			break
+		}
+	}
+	if !inStream {
+		return fmt.Errorf("remapped subjects %q are not part of the stream subjects", dest)
+	}
+	return nil
+}
+
+// Returns a subject filter that matches all subjects this transform can produce.
+func (tr *transform) destFilter() string {
+	if len(tr.dtokmftypes) == 0 {
+		return tr.dest
+	}
+	var nda []string
+	for i, token := range tr.dtoks {
+		switch tr.dtokmftypes[i] {
+		case NoTransform:
+			nda = append(nda, token)
+		case Split, SplitFromLeft, SplitFromRight:
+			// These can produce more than one token.
+			return strings.Join(append(nda, fwcs), tsep)
+		default:
+			nda = append(nda, pwcs)
+		}
+	}
+	return strings.Join(nda, tsep)
+}
+
+// Will rewrite the subject of all messages up to and including last that match filter.
+// Consumers will have their num pending recalculated.
+func (mset *stream) remapSubjects(filter, dest string, last uint64) (uint64, error) {
+	mset.mu.RLock()
+	if mset.client == nil || mset.store == nil {
+		mset.mu.RUnlock()
+		return 0, errors.New("invalid stream")
+	}
+	if mset.cfg.Sealed {
+		mset.mu.RUnlock()
+		return 0, errors.New("sealed stream")
+	}
+	store := mset.store
+	mset.mu.RUnlock()
+
+	remapped, err := store.RemapSubjects(filter, dest, last)
+	if err != nil || remapped == 0 {
+		return remapped, err
+	}
+
+	mset.clsMu.RLock()
+	for _, o := range mset.cList {
+		o.mu.Lock()
+		if o.cfg.FilterSubject != _EMPTY_ {
+			o.streamNumPending()
+		}
+		o.mu.Unlock()
+	}
+	mset.clsMu.RUnlock()
+
+	return remapped, nil
+}
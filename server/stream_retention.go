@@ -0,0 +1,70 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// SubjectRetention overrides the max age of a stream for the subjects matching a filter,
+// e.g. keeping `audit.>` for a year while `metrics.>` only keeps an hour.
+// A MaxAge of 0 keeps the matching messages regardless of the stream's max age.
+type SubjectRetention struct {
+	Subject string        `json:"subject"`
+	MaxAge  time.Duration `json:"max_age"`
+}
+
+// Returns the max age of messages on subj, from the first matching override
+// or the stream's max age otherwise.
+func (cfg *StreamConfig) maxAgeFor(subj string) time.Duration {
+	for _, sr := range cfg.SubjectRetention {
+		if subjectIsSubsetMatch(subj, sr.Subject) {
+			return sr.MaxAge
+		}
+	}
+	return cfg.MaxAge
+}
+
+// Returns the shortest max age any message can have, 0 if messages never expire.
+// This is what the stores use for their age check timers.
+func (cfg *StreamConfig) expiryAge() time.Duration {
+	age := cfg.MaxAge
+	for _, sr := range cfg.SubjectRetention {
+		if sr.MaxAge > 0 && (age == 0 || sr.MaxAge < age) {
+			age = sr.MaxAge
+		}
+	}
+	return age
+}
+
+func checkSubjectRetention(cfg *StreamConfig) error {
+	seen := make(map[string]struct{}, len(cfg.SubjectRetention))
+	for _, sr := range cfg.SubjectRetention {
+		if sr == nil || !IsValidSubject(sr.Subject) {
+			return fmt.Errorf("subject retention requires a valid subject")
+		}
+		if _, ok := seen[sr.Subject]; ok {
+			return fmt.Errorf("duplicate subject retention for %q", sr.Subject)
+		}
+		seen[sr.Subject] = struct{}{}
+		if sr.MaxAge < 0 {
+			return fmt.Errorf("subject retention max age for %q can not be negative", sr.Subject)
+		}
+		if sr.MaxAge > 0 && sr.MaxAge < 100*time.Millisecond {
+			return fmt.Errorf("subject retention max age for %q needs to be >= 100ms", sr.Subject)
+		}
+	}
+	return nil
+}
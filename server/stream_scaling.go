@@ -0,0 +1,226 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+// ReplicaScaling lets the meta leader raise or lower the replicas of a clustered
+// stream within Min and Max, following how many servers of its cluster are healthy.
+type ReplicaScaling struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// JSStreamReplicasScaledAdvisoryType is sent when the replicas of a stream were scaled.
+const JSStreamReplicasScaledAdvisoryType = "io.nats.jetstream.advisory.v1.stream_replicas_scaled"
+
+// JSStreamReplicasScaledAdvisory indicates that the replicas of a stream were raised or lowered.
+type JSStreamReplicasScaledAdvisory struct {
+	TypedEvent
+	Account string `json:"account,omitempty"`
+	Stream  string `json:"stream"`
+	From    int    `json:"from"`
+	To      int    `json:"to"`
+	// Healthy servers of the stream's cluster when we decided to scale.
+	Healthy int    `json:"healthy"`
+	Domain  string `json:"domain,omitempty"`
+}
+
+var (
+	// How often the meta leader checks if streams should be scaled.
+	replicaScalingInterval = 10 * time.Second
+	// How long we wait for a stream to be scaled.
+	replicaScalingTimeout = 30 * time.Second
+	// How often we check if a stream was scaled.
+	replicaScalingCheckInterval = 250 * time.Millisecond
+)
+
+func checkReplicaScaling(cfg *StreamConfig) error {
+	rs := cfg.ReplicaScaling
+	if rs == nil {
+		return nil
+	}
+	if rs.Min < 1 || rs.Max > StreamMaxReplicas || rs.Min > rs.Max {
+		return fmt.Errorf("replica scaling requires 1 <= min <= max <= %d", StreamMaxReplicas)
+	}
+	if cfg.Replicas < rs.Min || cfg.Replicas > rs.Max {
+		return errors.New("replicas need to be within the replica scaling min and max")
+	}
+	return nil
+}
+
+// A stream the meta leader decided to scale.
+type replicaScale struct {
+	sa      *streamAssignment
+	cfg     StreamConfig
+	healthy int
+}
+
+// Returns the number of healthy servers we could place the stream on.
+// Lock should be held.
+func (js *jetStream) healthyPeers(sa *streamAssignment) int {
+	s, cc := js.srv, js.cluster
+	ourID := cc.meta.ID()
+	// Peers we have not heard from for this long are not healthy.
+	seen := make(map[string]bool)
+	for _, p := range cc.meta.Peers() {
+		if p.ID == ourID || time.Since(p.Last) < lostQuorumInterval {
+			seen[p.ID] = true
+		}
+	}
+	seen[ourID] = true
+
+	var tags []string
+	if sa.Config.Placement != nil {
+		tags = sa.Config.Placement.Tags
+	}
+	var healthy int
+	s.nodeToInfo.Range(func(k, v interface{}) bool {
+		ni := v.(nodeInfo)
+		if ni.offline || !ni.js || ni.cluster != sa.Group.Cluster || !seen[k.(string)] {
+			return true
+		}
+		for _, t := range tags {
+			if !ni.tags.Contains(t) {
+				return true
+			}
+		}
+		healthy++
+		return true
+	})
+	return healthy
+}
+
+// Called periodically by the meta leader. A stream is scaled once we computed the
+// same replica count for it twice in a row, so servers that restart quickly do not
+// make their streams scale down and back up.
+func (js *jetStream) checkReplicaScaling() {
+	js.mu.Lock()
+	cc := js.cluster
+	if cc == nil || !cc.isLeader() || cc.scaling {
+		js.mu.Unlock()
+		return
+	}
+	pending := make(map[*streamAssignment]int)
+	var scales []*replicaScale
+	for _, asa := range cc.streams {
+		for _, sa := range asa {
+			rs := sa.Config.ReplicaScaling
+			// Skip streams being created, moved or scaled.
+			if rs == nil || sa.Group == nil || !sa.responded || len(sa.Group.Peers) != sa.Config.Replicas {
+				continue
+			}
+			healthy := js.healthyPeers(sa)
+			want := healthy
+			if want < rs.Min {
+				want = rs.Min
+			} else if want > rs.Max {
+				want = rs.Max
+			}
+			if want == sa.Config.Replicas {
+				continue
+			}
+			if cc.scalePending[sa] != want {
+				pending[sa] = want
+				continue
+			}
+			cfg := *sa.Config
+			cfg.Replicas = want
+			scales = append(scales, &replicaScale{sa: sa, cfg: cfg, healthy: healthy})
+		}
+	}
+	cc.scalePending = pending
+	if len(scales) == 0 {
+		js.mu.Unlock()
+		return
+	}
+	cc.scaling = true
+	js.mu.Unlock()
+
+	s := js.srv
+	s.startGoRoutine(func() {
+		defer s.grWG.Done()
+		for _, rs := range scales {
+			js.scaleStream(rs)
+		}
+		js.mu.Lock()
+		cc.scaling = false
+		js.mu.Unlock()
+	})
+}
+
+// Updates the replicas of a stream, like a stream update request, and sends an
+// advisory once the stream was scaled.
+func (js *jetStream) scaleStream(rs *replicaScale) {
+	s := js.srv
+	accName, name, from := rs.sa.Client.serviceAccount(), rs.cfg.Name, rs.sa.Config.Replicas
+	acc, err := s.lookupAccount(accName)
+	if err != nil {
+		return
+	}
+	s.Noticef("JetStream scaling stream '%s > %s' from %d to %d replicas, %d healthy servers",
+		accName, name, from, rs.cfg.Replicas, rs.healthy)
+
+	// The response to the update could come from ourselves, so we watch the assignment instead.
+	subject := fmt.Sprintf(JSApiStreamUpdateT, name)
+	s.jsClusteredStreamUpdateRequest(rs.sa.Client, acc, subject, _EMPTY_, nil, &rs.cfg, nil)
+
+	scaled := func() bool {
+		js.mu.RLock()
+		defer js.mu.RUnlock()
+		sa := js.streamAssignment(accName, name)
+		return sa != nil && sa.Config.Replicas == rs.cfg.Replicas && len(sa.Group.Peers) == rs.cfg.Replicas
+	}
+	deadline := time.NewTimer(replicaScalingTimeout)
+	defer deadline.Stop()
+	t := time.NewTicker(replicaScalingCheckInterval)
+	defer t.Stop()
+	for !scaled() {
+		select {
+		case <-deadline.C:
+			s.Warnf("JetStream timed out scaling stream '%s > %s' to %d replicas", accName, name, rs.cfg.Replicas)
+			return
+		case <-s.quitCh:
+			return
+		case <-t.C:
+		}
+	}
+
+	subj := JSAdvisoryStreamScaledPre + "." + name
+	adv := &JSStreamReplicasScaledAdvisory{
+		TypedEvent: TypedEvent{
+			Type: JSStreamReplicasScaledAdvisoryType,
+			ID:   nuid.Next(),
+			Time: time.Now().UTC(),
+		},
+		Stream:  name,
+		From:    from,
+		To:      rs.cfg.Replicas,
+		Healthy: rs.healthy,
+		Domain:  s.getOpts().JetStreamDomain,
+	}
+	// Send to the user's account if not the system account.
+	if acc != s.SystemAccount() {
+		s.publishAdvisory(acc, subj, adv)
+	}
+	// Now do system level one. Place account info in adv, and nil account means system.
+	adv.Account = acc.GetName()
+	s.publishAdvisory(nil, subj, adv)
+}
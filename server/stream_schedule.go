@@ -0,0 +1,273 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"container/heap"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Headers for scheduled messages. A message published with either a delay
+// relative to when it was stored, or an absolute RFC3339 time, is stored but
+// withheld from consumers until then. When due it is stored again as a new
+// message carrying the sequence of the original, which is then removed.
+const (
+	JSDelay             = "Nats-Delay"
+	JSDeliverAt         = "Nats-Deliver-At"
+	JSScheduledSequence = "Nats-Scheduled-Sequence"
+)
+
+const (
+	// Resolution of the schedule wheel.
+	schedTick = 10 * time.Millisecond
+	// How long to wait before trying to deliver a scheduled message again.
+	schedRetryInterval = time.Second
+)
+
+// Headers that are not carried over when a scheduled message is delivered.
+var schedStripHeaders = []string{
+	JSDelay,
+	JSDeliverAt,
+	JSMsgId,
+	JSExpectedStream,
+	JSExpectedLastSeq,
+	JSExpectedLastSubjSeq,
+	JSExpectedLastMsgId,
+	JSMsgRollup,
+}
+
+// Returns the time in unix nanoseconds a message stored at ts is scheduled
+// for, or 0 if the message is not scheduled.
+func scheduledAt(hdr []byte, ts int64) (int64, error) {
+	if len(hdr) == 0 {
+		return 0, nil
+	}
+	at, delay := getHeader(JSDeliverAt, hdr), getHeader(JSDelay, hdr)
+	switch {
+	case len(at) > 0 && len(delay) > 0:
+		return 0, fmt.Errorf("%s and %s are mutually exclusive", JSDeliverAt, JSDelay)
+	case len(at) > 0:
+		t, err := time.Parse(time.RFC3339Nano, string(at))
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s value %q", JSDeliverAt, at)
+		}
+		return t.UnixNano(), nil
+	case len(delay) > 0:
+		d, err := time.ParseDuration(string(delay))
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid %s value %q", JSDelay, delay)
+		}
+		return ts + int64(d), nil
+	}
+	return 0, nil
+}
+
+// schedWheel is a hashed timing wheel of scheduled messages. Sequences are
+// bucketed by their due time rounded up to schedTick, with a min heap of
+// bucket times to find the next one to fire.
+type schedWheel struct {
+	slots map[int64][]uint64
+	times slotHeap
+}
+
+// Min heap of slot times.
+type slotHeap []int64
+
+func (h slotHeap) Len() int           { return len(h) }
+func (h slotHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h slotHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *slotHeap) Push(x interface{}) {
+	*h = append(*h, x.(int64))
+}
+
+func (h *slotHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+func (w *schedWheel) add(seq uint64, due int64) {
+	if w.slots == nil {
+		w.slots = make(map[int64][]uint64)
+	}
+	tick := int64(schedTick)
+	slot := (due + tick - 1) / tick
+	if _, ok := w.slots[slot]; !ok {
+		heap.Push(&w.times, slot)
+	}
+	w.slots[slot] = append(w.slots[slot], seq)
+}
+
+// Returns when the next slot is due in unix nanoseconds, 0 if empty.
+func (w *schedWheel) next() int64 {
+	if len(w.times) == 0 {
+		return 0
+	}
+	return w.times[0] * int64(schedTick)
+}
+
+// Removes and returns all sequences due at now.
+func (w *schedWheel) expire(now int64) []uint64 {
+	var seqs []uint64
+	for len(w.times) > 0 && w.times[0]*int64(schedTick) <= now {
+		slot := heap.Pop(&w.times).(int64)
+		seqs = append(seqs, w.slots[slot]...)
+		delete(w.slots, slot)
+	}
+	return seqs
+}
+
+func (w *schedWheel) isEmpty() bool {
+	return len(w.times) == 0
+}
+
+// Loads the scheduled messages from our store, used when the stream is created.
+func (mset *stream) loadSchedule() {
+	mset.mu.Lock()
+	defer mset.mu.Unlock()
+	if mset.store == nil {
+		return
+	}
+	for seq, due := range mset.store.ScheduledMsgs() {
+		mset.sched.add(seq, due)
+	}
+	mset.armScheduleLocked()
+}
+
+// Adds a scheduled message to our wheel.
+// Lock should be held.
+func (mset *stream) scheduleMsgLocked(seq uint64, due int64) {
+	mset.sched.add(seq, due)
+	mset.armScheduleLocked()
+}
+
+// Makes sure our timer fires for the next scheduled message.
+// Lock should be held.
+func (mset *stream) armScheduleLocked() {
+	next := mset.sched.next()
+	if next == 0 {
+		return
+	}
+	if mset.schedTmr != nil && mset.schedNext > 0 && mset.schedNext <= next {
+		return
+	}
+	d := time.Duration(next - time.Now().UnixNano())
+	if d < 0 {
+		d = 0
+	}
+	mset.schedNext = next
+	if mset.schedTmr != nil {
+		mset.schedTmr.Reset(d)
+	} else {
+		mset.schedTmr = time.AfterFunc(d, mset.runSchedule)
+	}
+}
+
+// Timer callback that delivers the scheduled messages that are due.
+func (mset *stream) runSchedule() {
+	mset.mu.Lock()
+	mset.schedNext = 0
+	if mset.client == nil || mset.store == nil {
+		mset.mu.Unlock()
+		return
+	}
+	// Only the leader delivers, the other replicas keep their wheel in case they take over.
+	if mset.isClustered() && !mset.isLeader() {
+		mset.mu.Unlock()
+		return
+	}
+	seqs := mset.sched.expire(time.Now().UnixNano())
+	mset.armScheduleLocked()
+	mset.mu.Unlock()
+
+	for _, seq := range seqs {
+		mset.deliverScheduled(seq)
+	}
+}
+
+// Stops our schedule timer.
+// Lock should be held.
+func (mset *stream) stopScheduleLocked() {
+	if mset.schedTmr != nil {
+		mset.schedTmr.Stop()
+		mset.schedTmr = nil
+	}
+	mset.schedNext = 0
+}
+
+// Returns true if the message at seq is withheld from consumers.
+func (mset *stream) isScheduled(seq uint64) bool {
+	if mset.store == nil {
+		return false
+	}
+	return mset.store.IsScheduled(seq)
+}
+
+// Delivers a scheduled message by storing it again as a new message, and then
+// removing the original.
+func (mset *stream) deliverScheduled(seq uint64) {
+	mset.mu.RLock()
+	store, node, name, isMirror := mset.store, mset.node, mset.cfg.Name, mset.cfg.Mirror != nil
+	active := mset.active
+	mset.mu.RUnlock()
+
+	// If we are not accepting messages leave it, it will be picked up again when we do.
+	if store == nil || !store.IsScheduled(seq) || (node == nil && !active && !isMirror) {
+		return
+	}
+	var smv StoreMsg
+	sm, err := store.LoadMsg(seq, &smv)
+	if err != nil {
+		// Removed before it was due.
+		store.UnscheduleMsg(seq)
+		return
+	}
+	// Mirrors can not store new messages, so deliver in place.
+	if isMirror {
+		store.UnscheduleMsg(seq)
+		return
+	}
+
+	subj, hdr, msg := sm.subj, copyBytes(sm.hdr), copyBytes(sm.msg)
+	for _, key := range schedStripHeaders {
+		hdr = removeHeaderIfPresent(hdr, key)
+	}
+	hdr = genHeader(hdr, JSScheduledSequence, strconv.FormatUint(seq, 10))
+
+	if node != nil {
+		err = mset.processClusteredInboundMsg(subj, _EMPTY_, hdr, msg)
+	} else {
+		err = mset.processJetStreamMsg(subj, _EMPTY_, hdr, msg, 0, 0)
+	}
+	if err != nil {
+		mset.srv.Warnf("JetStream failed to deliver scheduled message %d for '%s > %s': %v", seq, mset.accName(), name, err)
+		mset.mu.Lock()
+		mset.scheduleMsgLocked(seq, time.Now().Add(schedRetryInterval).UnixNano())
+		mset.mu.Unlock()
+		return
+	}
+
+	// Now remove the original.
+	if node != nil {
+		md := streamMsgDelete{Seq: seq, NoErase: true, Stream: name}
+		node.Propose(encodeMsgDelete(&md))
+	} else {
+		mset.removeMsg(seq)
+	}
+}
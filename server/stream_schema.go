@@ -0,0 +1,1052 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// StreamSchema validates the payload of messages published to a stream, or to
+// the subjects matching Filter, before they are stored. Schemas are checked in
+// order and the first one matching the subject applies.
+//
+// For type "json" Definition is a JSON Schema document. For type "protobuf"
+// it is a base64 encoded FileDescriptorSet and Message is the fully qualified
+// name of the message type payloads must decode as.
+type StreamSchema struct {
+	Filter     string `json:"filter,omitempty"`
+	Type       string `json:"type"`
+	Definition string `json:"definition"`
+	Message    string `json:"message,omitempty"`
+}
+
+const (
+	schemaTypeJSON     = "json"
+	schemaTypeProtobuf = "protobuf"
+	// Max number of subjects we cache validators for.
+	schemaCacheMax = 16 * 1024
+	// Max nesting we will follow in payloads.
+	schemaMaxDepth = 64
+)
+
+// A payload validator for a schema.
+type schemaValidator interface {
+	validate(msg []byte) error
+}
+
+// A compiled schema with its subject filter.
+type streamSchema struct {
+	filter string
+	wc     bool
+	v      schemaValidator
+}
+
+// Compiled schemas of a stream along with the validator to use per subject.
+type streamSchemas struct {
+	list  []*streamSchema
+	mu    sync.Mutex
+	cache map[string]*streamSchema
+}
+
+// Validate the schemas of a stream.
+func checkStreamSchemas(cfg *StreamConfig) error {
+	if len(cfg.Schemas) == 0 {
+		return nil
+	}
+	if cfg.Mirror != nil {
+		return errors.New("schemas not allowed on mirrors")
+	}
+	_, err := compileStreamSchemas(cfg)
+	return err
+}
+
+// Compiles the schemas of a stream config.
+func compileStreamSchemas(cfg *StreamConfig) (*streamSchemas, error) {
+	if len(cfg.Schemas) == 0 {
+		return nil, nil
+	}
+	ss := &streamSchemas{cache: make(map[string]*streamSchema)}
+	for i, sc := range cfg.Schemas {
+		if sc == nil {
+			return nil, fmt.Errorf("schema %d is empty", i+1)
+		}
+		if sc.Filter != _EMPTY_ && !IsValidSubject(sc.Filter) {
+			return nil, fmt.Errorf("schema %d has invalid filter %q", i+1, sc.Filter)
+		}
+		var v schemaValidator
+		var err error
+		switch sc.Type {
+		case schemaTypeJSON:
+			if sc.Message != _EMPTY_ {
+				return nil, fmt.Errorf("schema %d can not set a message for type %q", i+1, sc.Type)
+			}
+			v, err = compileJSONSchema(sc.Definition)
+		case schemaTypeProtobuf:
+			v, err = compileProtoSchema(sc.Definition, sc.Message)
+		default:
+			return nil, fmt.Errorf("schema %d has unknown type %q", i+1, sc.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("schema %d is invalid: %v", i+1, err)
+		}
+		ss.list = append(ss.list, &streamSchema{filter: sc.Filter, wc: subjectHasWildcard(sc.Filter), v: v})
+	}
+	return ss, nil
+}
+
+// Sets up our schemas from the config.
+// Lock should be held.
+func (mset *stream) setSchemasLocked(cfg *StreamConfig) {
+	// The config has been checked already.
+	mset.schemas, _ = compileStreamSchemas(cfg)
+}
+
+func (sc *streamSchema) matches(subject string) bool {
+	if sc.filter == _EMPTY_ {
+		return true
+	}
+	if sc.wc {
+		return subjectIsSubsetMatch(subject, sc.filter)
+	}
+	return subject == sc.filter
+}
+
+// Returns the schema for the subject, if any.
+func (ss *streamSchemas) forSubject(subject string) *streamSchema {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if sc, ok := ss.cache[subject]; ok {
+		return sc
+	}
+	var found *streamSchema
+	for _, sc := range ss.list {
+		if sc.matches(subject) {
+			found = sc
+			break
+		}
+	}
+	if len(ss.cache) >= schemaCacheMax {
+		ss.cache = make(map[string]*streamSchema)
+	}
+	ss.cache[subject] = found
+	return found
+}
+
+// Validates the payload of a message against the schema for its subject.
+func (mset *stream) validateSchema(subject string, msg []byte) error {
+	mset.mu.RLock()
+	ss := mset.schemas
+	mset.mu.RUnlock()
+
+	if ss == nil {
+		return nil
+	}
+	if sc := ss.forSubject(subject); sc != nil {
+		return sc.v.validate(msg)
+	}
+	return nil
+}
+
+// Lets a publisher know their message failed schema validation.
+func (mset *stream) sendSchemaRejected(reply string, err error) {
+	if reply == _EMPTY_ {
+		return
+	}
+	resp := JSPubAckResponse{
+		PubAck: &PubAck{Stream: mset.name()},
+		Error:  NewJSStreamSchemaValidationError(err),
+	}
+	b, _ := json.Marshal(resp)
+	mset.outq.sendMsg(reply, b)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// JSON Schema
+////////////////////////////////////////////////////////////////////////////////
+
+// A compiled JSON Schema. This covers the commonly used validation keywords,
+// annotations and unknown keywords are ignored and references are rejected.
+type jsonSchema struct {
+	never      bool // The "false" schema.
+	types      []string
+	enum       []interface{}
+	constant   []interface{} // Zero or one value.
+	props      map[string]*jsonSchema
+	required   []string
+	addl       *jsonSchema
+	items      *jsonSchema
+	minItems   int
+	maxItems   int
+	unique     bool
+	minLen     int
+	maxLen     int
+	pattern    *regexp.Regexp
+	min, max   *float64
+	xmin, xmax *float64
+	multipleOf float64
+	allOf      []*jsonSchema
+	anyOf      []*jsonSchema
+	oneOf      []*jsonSchema
+	not        *jsonSchema
+}
+
+var jsonSchemaTypes = map[string]struct{}{
+	"null": {}, "boolean": {}, "object": {}, "array": {}, "number": {}, "integer": {}, "string": {},
+}
+
+func compileJSONSchema(def string) (*jsonSchema, error) {
+	var v interface{}
+	d := json.NewDecoder(strings.NewReader(def))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("definition is not valid JSON: %v", err)
+	}
+	return parseJSONSchema(v, 0)
+}
+
+func parseJSONSchema(v interface{}, depth int) (*jsonSchema, error) {
+	if depth > schemaMaxDepth {
+		return nil, errors.New("definition nested too deep")
+	}
+	switch vv := v.(type) {
+	case bool:
+		return &jsonSchema{never: !vv, minLen: -1, maxLen: -1, minItems: -1, maxItems: -1}, nil
+	case map[string]interface{}:
+		return parseJSONSchemaObject(vv, depth)
+	}
+	return nil, errors.New("schema must be an object or boolean")
+}
+
+func parseJSONSchemaObject(m map[string]interface{}, depth int) (*jsonSchema, error) {
+	js := &jsonSchema{minLen: -1, maxLen: -1, minItems: -1, maxItems: -1}
+	sub := func(k string, v interface{}) (*jsonSchema, error) {
+		s, err := parseJSONSchema(v, depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", k, err)
+		}
+		return s, nil
+	}
+	subs := func(k string, v interface{}) ([]*jsonSchema, error) {
+		l, ok := v.([]interface{})
+		if !ok || len(l) == 0 {
+			return nil, fmt.Errorf("%s must be a non-empty array", k)
+		}
+		var out []*jsonSchema
+		for _, e := range l {
+			s, err := sub(k, e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	num := func(k string, v interface{}) (*float64, error) {
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a number", k)
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", k)
+		}
+		return &f, nil
+	}
+	count := func(k string, v interface{}) (int, error) {
+		f, err := num(k, v)
+		if err != nil || *f < 0 || *f != math.Trunc(*f) {
+			return 0, fmt.Errorf("%s must be a non-negative integer", k)
+		}
+		return int(*f), nil
+	}
+
+	var err error
+	for k, v := range m {
+		switch k {
+		case "$ref", "$dynamicRef", "$recursiveRef":
+			return nil, fmt.Errorf("%s is not supported", k)
+		case "type":
+			switch t := v.(type) {
+			case string:
+				js.types = []string{t}
+			case []interface{}:
+				for _, e := range t {
+					s, ok := e.(string)
+					if !ok {
+						return nil, errors.New("type must be a string or array of strings")
+					}
+					js.types = append(js.types, s)
+				}
+			default:
+				return nil, errors.New("type must be a string or array of strings")
+			}
+			for _, t := range js.types {
+				if _, ok := jsonSchemaTypes[t]; !ok {
+					return nil, fmt.Errorf("unknown type %q", t)
+				}
+			}
+		case "enum":
+			l, ok := v.([]interface{})
+			if !ok {
+				return nil, errors.New("enum must be an array")
+			}
+			js.enum = l
+		case "const":
+			js.constant = []interface{}{v}
+		case "properties":
+			pm, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("properties must be an object")
+			}
+			js.props = make(map[string]*jsonSchema, len(pm))
+			for name, pv := range pm {
+				if js.props[name], err = sub("properties/"+name, pv); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			l, ok := v.([]interface{})
+			if !ok {
+				return nil, errors.New("required must be an array of strings")
+			}
+			for _, e := range l {
+				s, ok := e.(string)
+				if !ok {
+					return nil, errors.New("required must be an array of strings")
+				}
+				js.required = append(js.required, s)
+			}
+		case "additionalProperties":
+			if js.addl, err = sub(k, v); err != nil {
+				return nil, err
+			}
+		case "items":
+			if js.items, err = sub(k, v); err != nil {
+				return nil, err
+			}
+		case "minItems":
+			if js.minItems, err = count(k, v); err != nil {
+				return nil, err
+			}
+		case "maxItems":
+			if js.maxItems, err = count(k, v); err != nil {
+				return nil, err
+			}
+		case "uniqueItems":
+			b, ok := v.(bool)
+			if !ok {
+				return nil, errors.New("uniqueItems must be a boolean")
+			}
+			js.unique = b
+		case "minLength":
+			if js.minLen, err = count(k, v); err != nil {
+				return nil, err
+			}
+		case "maxLength":
+			if js.maxLen, err = count(k, v); err != nil {
+				return nil, err
+			}
+		case "pattern":
+			p, ok := v.(string)
+			if !ok {
+				return nil, errors.New("pattern must be a string")
+			}
+			if js.pattern, err = regexp.Compile(p); err != nil {
+				return nil, fmt.Errorf("pattern is invalid: %v", err)
+			}
+		case "minimum":
+			if js.min, err = num(k, v); err != nil {
+				return nil, err
+			}
+		case "maximum":
+			if js.max, err = num(k, v); err != nil {
+				return nil, err
+			}
+		case "exclusiveMinimum":
+			if js.xmin, err = num(k, v); err != nil {
+				return nil, err
+			}
+		case "exclusiveMaximum":
+			if js.xmax, err = num(k, v); err != nil {
+				return nil, err
+			}
+		case "multipleOf":
+			f, err := num(k, v)
+			if err != nil || *f <= 0 {
+				return nil, errors.New("multipleOf must be a positive number")
+			}
+			js.multipleOf = *f
+		case "allOf":
+			if js.allOf, err = subs(k, v); err != nil {
+				return nil, err
+			}
+		case "anyOf":
+			if js.anyOf, err = subs(k, v); err != nil {
+				return nil, err
+			}
+		case "oneOf":
+			if js.oneOf, err = subs(k, v); err != nil {
+				return nil, err
+			}
+		case "not":
+			if js.not, err = sub(k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return js, nil
+}
+
+func (js *jsonSchema) validate(msg []byte) error {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(msg))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return errors.New("payload is not valid JSON")
+	}
+	if d.More() {
+		return errors.New("payload has data after the JSON value")
+	}
+	return js.check(v, "/")
+}
+
+// Returns the JSON Schema type of a decoded value.
+func jsonTypeOf(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if _, err := strconv.ParseInt(string(vv), 10, 64); err == nil {
+			return "integer"
+		}
+		if f, err := vv.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+// Compares two decoded values, numbers by value.
+func jsonEqual(a, b interface{}) bool {
+	if na, ok := a.(json.Number); ok {
+		nb, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fa, erra := na.Float64()
+		fb, errb := nb.Float64()
+		return erra == nil && errb == nil && fa == fb
+	}
+	switch av := a.(type) {
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, e := range av {
+			if f, ok := bv[k]; !ok || !jsonEqual(e, f) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func jsonPath(path, elem string) string {
+	elem = strings.ReplaceAll(strings.ReplaceAll(elem, "~", "~0"), "/", "~1")
+	if path == "/" {
+		return path + elem
+	}
+	return path + "/" + elem
+}
+
+func (js *jsonSchema) check(v interface{}, path string) error {
+	if js.never {
+		return fmt.Errorf("%q is not allowed", path)
+	}
+	vt := jsonTypeOf(v)
+	if len(js.types) > 0 {
+		var ok bool
+		for _, t := range js.types {
+			if t == vt || t == "number" && vt == "integer" {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%q expected %s, got %s", path, strings.Join(js.types, " or "), vt)
+		}
+	}
+	if len(js.constant) > 0 && !jsonEqual(js.constant[0], v) {
+		return fmt.Errorf("%q does not match the constant value", path)
+	}
+	if js.enum != nil {
+		var ok bool
+		for _, e := range js.enum {
+			if jsonEqual(e, v) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%q is not one of the allowed values", path)
+		}
+	}
+
+	switch vv := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(vv)
+		if js.minLen >= 0 && n < js.minLen {
+			return fmt.Errorf("%q is shorter than %d characters", path, js.minLen)
+		}
+		if js.maxLen >= 0 && n > js.maxLen {
+			return fmt.Errorf("%q is longer than %d characters", path, js.maxLen)
+		}
+		if js.pattern != nil && !js.pattern.MatchString(vv) {
+			return fmt.Errorf("%q does not match pattern %q", path, js.pattern.String())
+		}
+	case json.Number:
+		f, err := vv.Float64()
+		if err != nil {
+			return fmt.Errorf("%q is not a valid number", path)
+		}
+		if js.min != nil && f < *js.min {
+			return fmt.Errorf("%q is less than %v", path, *js.min)
+		}
+		if js.max != nil && f > *js.max {
+			return fmt.Errorf("%q is greater than %v", path, *js.max)
+		}
+		if js.xmin != nil && f <= *js.xmin {
+			return fmt.Errorf("%q is not greater than %v", path, *js.xmin)
+		}
+		if js.xmax != nil && f >= *js.xmax {
+			return fmt.Errorf("%q is not less than %v", path, *js.xmax)
+		}
+		if js.multipleOf > 0 {
+			if q := f / js.multipleOf; q != math.Trunc(q) {
+				return fmt.Errorf("%q is not a multiple of %v", path, js.multipleOf)
+			}
+		}
+	case []interface{}:
+		if js.minItems >= 0 && len(vv) < js.minItems {
+			return fmt.Errorf("%q has fewer than %d items", path, js.minItems)
+		}
+		if js.maxItems >= 0 && len(vv) > js.maxItems {
+			return fmt.Errorf("%q has more than %d items", path, js.maxItems)
+		}
+		if js.unique {
+			for i := range vv {
+				for j := i + 1; j < len(vv); j++ {
+					if jsonEqual(vv[i], vv[j]) {
+						return fmt.Errorf("%q has duplicate items", path)
+					}
+				}
+			}
+		}
+		if js.items != nil {
+			for i, e := range vv {
+				if err := js.items.check(e, jsonPath(path, strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, r := range js.required {
+			if _, ok := vv[r]; !ok {
+				return fmt.Errorf("%q is missing required property %q", path, r)
+			}
+		}
+		// Check properties in order so errors are stable.
+		keys := make([]string, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ps := js.props[k]
+			if ps == nil {
+				ps = js.addl
+			}
+			if ps == nil {
+				continue
+			}
+			if err := ps.check(vv[k], jsonPath(path, k)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, s := range js.allOf {
+		if err := s.check(v, path); err != nil {
+			return err
+		}
+	}
+	if len(js.anyOf) > 0 {
+		var ok bool
+		for _, s := range js.anyOf {
+			if s.check(v, path) == nil {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%q does not match any of the allowed schemas", path)
+		}
+	}
+	if len(js.oneOf) > 0 {
+		var n int
+		for _, s := range js.oneOf {
+			if s.check(v, path) == nil {
+				n++
+			}
+		}
+		if n != 1 {
+			return fmt.Errorf("%q must match exactly one schema, matched %d", path, n)
+		}
+	}
+	if js.not != nil && js.not.check(v, path) == nil {
+		return fmt.Errorf("%q matches a schema it must not match", path)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Protobuf
+////////////////////////////////////////////////////////////////////////////////
+
+// Protobuf wire types.
+const (
+	protoVarint     = 0
+	protoFixed64    = 1
+	protoBytes      = 2
+	protoStartGroup = 3
+	protoEndGroup   = 4
+	protoFixed32    = 5
+)
+
+// Field types and labels from descriptor.proto.
+const (
+	protoTypeDouble   = 1
+	protoTypeFloat    = 2
+	protoTypeGroup    = 10
+	protoTypeMessage  = 11
+	protoTypeString   = 9
+	protoTypeBytes    = 12
+	protoTypeFixed32  = 7
+	protoTypeFixed64  = 6
+	protoTypeSFixed32 = 15
+	protoTypeSFixed64 = 16
+	protoTypeMax      = 18
+
+	protoLabelRequired = 2
+	protoLabelRepeated = 3
+)
+
+type protoField struct {
+	name     string
+	num      uint64
+	label    uint64
+	typ      uint64
+	typeName string
+	msg      *protoMessage
+}
+
+type protoMessage struct {
+	name     string
+	proto3   bool
+	fields   map[uint64]*protoField
+	required []*protoField
+}
+
+// A compiled protobuf schema validating payloads decode as a message type.
+type protoSchema struct {
+	msg *protoMessage
+}
+
+var errProtoTruncated = errors.New("truncated")
+
+// Reads a tag or varint from b, returning the rest.
+func protoVarintAt(b []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, errProtoTruncated
+	}
+	return v, b[n:], nil
+}
+
+// Walks the fields of an encoded message calling fn for each with its value.
+// For groups the value is the group's content.
+func protoFields(b []byte, fn func(num uint64, wt int, v []byte) error) error {
+	for len(b) > 0 {
+		tag, rest, err := protoVarintAt(b)
+		if err != nil {
+			return err
+		}
+		num, wt := tag>>3, int(tag&7)
+		if num == 0 || num > math.MaxInt32 {
+			return fmt.Errorf("invalid field number %d", num)
+		}
+		var v []byte
+		switch wt {
+		case protoVarint:
+			_, after, err := protoVarintAt(rest)
+			if err != nil {
+				return err
+			}
+			v, rest = rest[:len(rest)-len(after)], after
+		case protoFixed64, protoFixed32:
+			n := 8
+			if wt == protoFixed32 {
+				n = 4
+			}
+			if len(rest) < n {
+				return errProtoTruncated
+			}
+			v, rest = rest[:n], rest[n:]
+		case protoBytes:
+			l, after, err := protoVarintAt(rest)
+			if err != nil {
+				return err
+			}
+			if l > uint64(len(after)) {
+				return errProtoTruncated
+			}
+			v, rest = after[:l], after[l:]
+		case protoStartGroup:
+			if v, rest, err = protoGroup(rest, num, 0); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid wire type %d", wt)
+		}
+		if err := fn(num, wt, v); err != nil {
+			return err
+		}
+		b = rest
+	}
+	return nil
+}
+
+// Finds the end of a group, returning its content and what follows.
+func protoGroup(b []byte, num uint64, depth int) ([]byte, []byte, error) {
+	if depth > schemaMaxDepth {
+		return nil, nil, errors.New("nested too deep")
+	}
+	start := b
+	for len(b) > 0 {
+		pos := len(start) - len(b)
+		tag, rest, err := protoVarintAt(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch wt := int(tag & 7); wt {
+		case protoEndGroup:
+			if tag>>3 != num {
+				return nil, nil, errors.New("mismatched end group")
+			}
+			return start[:pos], rest, nil
+		case protoStartGroup:
+			if _, rest, err = protoGroup(rest, tag>>3, depth+1); err != nil {
+				return nil, nil, err
+			}
+		default:
+			if rest, err = protoSkip(rest, wt); err != nil {
+				return nil, nil, err
+			}
+		}
+		b = rest
+	}
+	return nil, nil, errProtoTruncated
+}
+
+// Skips a value of the given wire type.
+func protoSkip(b []byte, wt int) ([]byte, error) {
+	switch wt {
+	case protoVarint:
+		_, rest, err := protoVarintAt(b)
+		return rest, err
+	case protoFixed64:
+		if len(b) < 8 {
+			return nil, errProtoTruncated
+		}
+		return b[8:], nil
+	case protoFixed32:
+		if len(b) < 4 {
+			return nil, errProtoTruncated
+		}
+		return b[4:], nil
+	case protoBytes:
+		l, rest, err := protoVarintAt(b)
+		if err != nil {
+			return nil, err
+		}
+		if l > uint64(len(rest)) {
+			return nil, errProtoTruncated
+		}
+		return rest[l:], nil
+	}
+	return nil, fmt.Errorf("invalid wire type %d", wt)
+}
+
+// Returns the wire type a field type is encoded with.
+func protoWireType(typ uint64) int {
+	switch typ {
+	case protoTypeDouble, protoTypeFixed64, protoTypeSFixed64:
+		return protoFixed64
+	case protoTypeFloat, protoTypeFixed32, protoTypeSFixed32:
+		return protoFixed32
+	case protoTypeString, protoTypeBytes, protoTypeMessage:
+		return protoBytes
+	case protoTypeGroup:
+		return protoStartGroup
+	}
+	return protoVarint
+}
+
+func protoUint(v []byte) uint64 {
+	u, _ := binary.Uvarint(v)
+	return u
+}
+
+// Decodes a FileDescriptorSet into messages keyed by fully qualified name.
+func parseProtoDescriptors(b []byte) (map[string]*protoMessage, []*protoField, error) {
+	msgs := make(map[string]*protoMessage)
+	var fields []*protoField
+
+	var parseMsg func(b []byte, scope string, proto3 bool) error
+	parseMsg = func(b []byte, scope string, proto3 bool) error {
+		m := &protoMessage{proto3: proto3, fields: make(map[uint64]*protoField)}
+		var nested [][]byte
+		err := protoFields(b, func(num uint64, wt int, v []byte) error {
+			switch {
+			case num == 1 && wt == protoBytes:
+				m.name = string(v)
+			case num == 2 && wt == protoBytes:
+				f := &protoField{}
+				if err := protoFields(v, func(num uint64, wt int, v []byte) error {
+					switch {
+					case num == 1 && wt == protoBytes:
+						f.name = string(v)
+					case num == 3 && wt == protoVarint:
+						f.num = protoUint(v)
+					case num == 4 && wt == protoVarint:
+						f.label = protoUint(v)
+					case num == 5 && wt == protoVarint:
+						f.typ = protoUint(v)
+					case num == 6 && wt == protoBytes:
+						f.typeName = string(v)
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+				m.fields[f.num] = f
+			case num == 3 && wt == protoBytes:
+				nested = append(nested, v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if m.name == _EMPTY_ {
+			return errors.New("message without a name")
+		}
+		m.name = scope + "." + m.name
+		if _, ok := msgs[m.name]; ok {
+			return fmt.Errorf("duplicate message %q", m.name[1:])
+		}
+		msgs[m.name] = m
+		for _, f := range m.fields {
+			if f.num == 0 || f.typ == 0 || f.typ > protoTypeMax {
+				return fmt.Errorf("message %q has an invalid field %q", m.name[1:], f.name)
+			}
+			if f.label == protoLabelRequired {
+				m.required = append(m.required, f)
+			}
+			fields = append(fields, f)
+		}
+		for _, n := range nested {
+			if err := parseMsg(n, m.name, proto3); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := protoFields(b, func(num uint64, wt int, v []byte) error {
+		if num != 1 || wt != protoBytes {
+			return nil
+		}
+		var pkg string
+		var proto3 bool
+		var types [][]byte
+		if err := protoFields(v, func(num uint64, wt int, v []byte) error {
+			switch {
+			case num == 2 && wt == protoBytes:
+				pkg = string(v)
+			case num == 4 && wt == protoBytes:
+				types = append(types, v)
+			case num == 12 && wt == protoBytes:
+				proto3 = string(v) == "proto3"
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		scope := _EMPTY_
+		if pkg != _EMPTY_ {
+			scope = "." + pkg
+		}
+		for _, t := range types {
+			if err := parseMsg(t, scope, proto3); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return msgs, fields, err
+}
+
+func compileProtoSchema(def, message string) (*protoSchema, error) {
+	if message == _EMPTY_ {
+		return nil, errors.New("message is required")
+	}
+	b, err := base64.StdEncoding.DecodeString(def)
+	if err != nil {
+		return nil, errors.New("definition must be a base64 encoded FileDescriptorSet")
+	}
+	msgs, fields, err := parseProtoDescriptors(b)
+	if err != nil {
+		return nil, fmt.Errorf("definition is not a valid FileDescriptorSet: %v", err)
+	}
+	// Resolve message field types, we only need fully qualified names
+	// which is what protoc emits in descriptor sets.
+	for _, f := range fields {
+		if f.typ != protoTypeMessage && f.typ != protoTypeGroup {
+			continue
+		}
+		if f.msg = msgs[f.typeName]; f.msg == nil {
+			return nil, fmt.Errorf("field %q references unknown message %q", f.name, f.typeName)
+		}
+	}
+	if !strings.HasPrefix(message, ".") {
+		message = "." + message
+	}
+	m := msgs[message]
+	if m == nil {
+		return nil, fmt.Errorf("message %q not found in definition", message[1:])
+	}
+	return &protoSchema{msg: m}, nil
+}
+
+func (ps *protoSchema) validate(msg []byte) error {
+	return ps.msg.check(msg, 0)
+}
+
+func (m *protoMessage) check(b []byte, depth int) error {
+	if depth > schemaMaxDepth {
+		return errors.New("payload nested too deep")
+	}
+	var seen map[uint64]struct{}
+	if len(m.required) > 0 {
+		seen = make(map[uint64]struct{}, len(m.required))
+	}
+	// Errors from our fields already say where they happened.
+	var ferr error
+	err := protoFields(b, func(num uint64, wt int, v []byte) error {
+		ferr = m.checkField(num, wt, v, seen, depth)
+		return ferr
+	})
+	if ferr != nil {
+		return ferr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", m.name[1:], err)
+	}
+	for _, f := range m.required {
+		if _, ok := seen[f.num]; !ok {
+			return fmt.Errorf("%s: missing required field %q", m.name[1:], f.name)
+		}
+	}
+	return nil
+}
+
+func (m *protoMessage) checkField(num uint64, wt int, v []byte, seen map[uint64]struct{}, depth int) error {
+	f := m.fields[num]
+	if f == nil {
+		// Unknown fields are allowed.
+		return nil
+	}
+	if seen != nil {
+		seen[num] = struct{}{}
+	}
+	ewt := protoWireType(f.typ)
+	if wt != ewt {
+		// Repeated scalars may be packed.
+		if f.label == protoLabelRepeated && wt == protoBytes && ewt != protoBytes && ewt != protoStartGroup {
+			for len(v) > 0 {
+				var err error
+				if v, err = protoSkip(v, ewt); err != nil {
+					return fmt.Errorf("%s.%s: invalid packed value", m.name[1:], f.name)
+				}
+			}
+			return nil
+		}
+		return fmt.Errorf("%s.%s: wrong wire type %d", m.name[1:], f.name, wt)
+	}
+	switch f.typ {
+	case protoTypeString:
+		if m.proto3 && !utf8.Valid(v) {
+			return fmt.Errorf("%s.%s: invalid UTF-8", m.name[1:], f.name)
+		}
+	case protoTypeMessage, protoTypeGroup:
+		return f.msg.check(v, depth+1)
+	}
+	return nil
+}
@@ -0,0 +1,527 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamSink pushes the messages of a stream to an external system, either a
+// webhook or a Kafka topic. Sinks need to be enabled for the server, and can
+// only push to the hosts it allows, see JSSinkOpts.
+type StreamSink struct {
+	// An http(s) webhook that messages are POSTed to.
+	URL string `json:"url,omitempty"`
+	// Name of the server's sink credentials to push to the URL with.
+	Credentials string `json:"credentials,omitempty"`
+	// A Kafka topic, produced to through the server's kafka dialer.
+	Kafka *KafkaSink `json:"kafka,omitempty"`
+	// Only push messages matching this subject.
+	FilterSubject string `json:"filter_subject,omitempty"`
+}
+
+// MarshalJSON leaves out any userinfo of the URL, as our config is echoed
+// in stream info and advisories. Credentials are not allowed in there, but
+// never show them in case a URL somehow has them.
+func (sk StreamSink) MarshalJSON() ([]byte, error) {
+	type sink StreamSink
+	c := sink(sk)
+	if u, err := url.Parse(c.URL); err == nil && u.User != nil {
+		u.User = nil
+		c.URL = u.String()
+	}
+	return json.Marshal(&c)
+}
+
+// KafkaSink is a Kafka topic a stream pushes its messages to.
+type KafkaSink struct {
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+}
+
+// KafkaProducer writes records to a Kafka topic.
+type KafkaProducer interface {
+	Produce(topic string, recs []*KafkaRecord) error
+	Close() error
+}
+
+// KafkaSinkDialer is implemented by kafka dialers that can also produce, for streams with a Kafka sink.
+type KafkaSinkDialer interface {
+	DialProducer(sink *KafkaSink) (KafkaProducer, error)
+}
+
+// StreamSinkInfo shows how far a stream's sink got.
+type StreamSinkInfo struct {
+	// Last stream sequence delivered to the sink.
+	Delivered uint64 `json:"delivered"`
+	// Time since the last message was delivered.
+	Active time.Duration `json:"active"`
+	// Last error delivering to the sink, if it is failing.
+	Error string `json:"error,omitempty"`
+}
+
+// Headers added to messages pushed to a sink.
+const (
+	JSSinkStream   = "Nats-Sink-Stream"
+	JSSinkSequence = "Nats-Sink-Sequence"
+)
+
+const (
+	// Name of the sink checkpoint file inside the stream directory.
+	sinkCheckpointFile = "sink_checkpoint.json"
+	// Max messages pushed at once.
+	sinkBatchSize = 256
+	// How often to look for new messages when caught up.
+	sinkPollInterval = 250 * time.Millisecond
+	// Bounds on how long to wait before retrying a failing sink.
+	sinkMinRetryWait = 250 * time.Millisecond
+	sinkMaxRetryWait = 30 * time.Second
+	// Timeout connecting and pushing to the sink.
+	sinkTimeout = 10 * time.Second
+)
+
+// Stored in the checkpoint file, the sink it was for and how far we got.
+type sinkCheckpoint struct {
+	Target  string    `json:"target"`
+	Seq     uint64    `json:"seq"`
+	Updated time.Time `json:"updated"`
+}
+
+// State of a running sink.
+type streamSink struct {
+	cfg    *StreamSink
+	file   string
+	seq    uint64
+	active time.Time
+	err    string
+	qch    chan struct{}
+}
+
+// A connection to a sink.
+type sinkTarget interface {
+	send(stream string, msgs []*StoreMsg) error
+	close()
+}
+
+func (s *Server) checkStreamSink(cfg *StreamConfig) error {
+	sk := cfg.Sink
+	if sk == nil {
+		return nil
+	}
+	so := &s.getOpts().JetStreamSinks
+	if !so.Enabled {
+		return errors.New("stream sinks are not enabled for this server")
+	}
+	if cfg.Retention != LimitsPolicy {
+		return errors.New("stream sinks require limits retention")
+	}
+	if sk.FilterSubject != _EMPTY_ && !IsValidSubject(sk.FilterSubject) {
+		return errors.New("stream sink filter subject is not valid")
+	}
+	if (sk.URL == _EMPTY_) == (sk.Kafka == nil) {
+		return errors.New("stream sink requires either a url or a kafka topic")
+	}
+	if sk.Kafka != nil {
+		if len(sk.Kafka.Brokers) == 0 || sk.Kafka.Topic == _EMPTY_ {
+			return errors.New("stream sink kafka topic requires brokers and a topic")
+		}
+		if sk.Credentials != _EMPTY_ {
+			return errors.New("stream sink credentials only apply to urls")
+		}
+		if _, ok := s.getOpts().JetStreamKafkaDialer.(KafkaSinkDialer); !ok {
+			return errors.New("stream sink kafka topic requires a kafka dialer that can produce")
+		}
+		return so.checkKafkaSink(sk.Kafka)
+	}
+	u, err := url.Parse(sk.URL)
+	if err != nil {
+		return fmt.Errorf("stream sink url is not valid: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("stream sink url scheme %q not supported", u.Scheme)
+	}
+	if u.User != nil {
+		return errors.New("stream sink url can not have credentials, refer to the server's sink credentials instead")
+	}
+	if sk.Credentials != _EMPTY_ {
+		if _, ok := so.Credentials[sk.Credentials]; !ok {
+			return fmt.Errorf("stream sink credentials %q not found", sk.Credentials)
+		}
+	}
+	return so.checkURL(u)
+}
+
+// Checks that sinks may push to the host of the url.
+func (so *JSSinkOpts) checkURL(u *url.URL) error {
+	port := u.Port()
+	if port == _EMPTY_ {
+		if port = "80"; u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if !so.hostAllowed(u.Hostname(), port) {
+		return fmt.Errorf("stream sink host %q is not allowed", u.Host)
+	}
+	return nil
+}
+
+// Checks that sinks may push to all brokers of the topic.
+func (so *JSSinkOpts) checkKafkaSink(ks *KafkaSink) error {
+	for _, b := range ks.Brokers {
+		host, port, err := net.SplitHostPort(b)
+		if err != nil {
+			return fmt.Errorf("stream sink kafka broker %q is not valid: %v", b, err)
+		}
+		if !so.hostAllowed(host, port) {
+			return fmt.Errorf("stream sink host %q is not allowed", b)
+		}
+	}
+	return nil
+}
+
+// Returns true if the host is allowed, either as is or along with the port.
+func (so *JSSinkOpts) hostAllowed(host, port string) bool {
+	for _, ah := range so.AllowedHosts {
+		if h, p, err := net.SplitHostPort(ah); err == nil {
+			if strings.EqualFold(h, host) && p == port {
+				return true
+			}
+		} else if strings.EqualFold(ah, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Identifies the target, so a checkpoint is not used for a different one.
+func (sk *StreamSink) target() string {
+	if sk.Kafka != nil {
+		return "kafka:" + sk.Kafka.Topic
+	}
+	return sk.URL + "#" + sk.FilterSubject
+}
+
+// Starts pushing our messages to our sink, only the leader does this. We keep a
+// checkpoint of the last sequence pushed, written after every batch, so pushing
+// resumes there after a restart. Delivery is at least once, messages carry our
+// stream name and sequence so the other end can drop duplicates.
+// Lock should be held.
+func (mset *stream) startSinkLocked() {
+	sk := mset.cfg.Sink
+	if sk == nil || mset.sink != nil {
+		return
+	}
+	cfg := *sk
+	ss := &streamSink{
+		cfg:  &cfg,
+		file: filepath.Join(mset.jsa.storeDir, streamsDir, mset.cfg.Name, sinkCheckpointFile),
+		qch:  make(chan struct{}),
+	}
+	if b, err := os.ReadFile(ss.file); err == nil {
+		var ck sinkCheckpoint
+		if err := json.Unmarshal(b, &ck); err != nil {
+			mset.srv.Warnf("Could not load sink checkpoint for stream '%s > %s': %v", mset.acc.Name, mset.cfg.Name, err)
+		} else if ck.Target == cfg.target() {
+			ss.seq = ck.Seq
+		}
+	}
+	mset.sink = ss
+	mset.srv.startGoRoutine(func() { mset.runSink(ss) })
+}
+
+// Lock should be held.
+func (mset *stream) stopSinkLocked() {
+	if mset.sink != nil {
+		close(mset.sink.qch)
+		mset.sink = nil
+	}
+}
+
+func (mset *stream) sinkInfo() *StreamSinkInfo {
+	mset.mu.RLock()
+	defer mset.mu.RUnlock()
+	ss := mset.sink
+	if ss == nil {
+		return nil
+	}
+	si := &StreamSinkInfo{Delivered: ss.seq, Error: ss.err}
+	if !ss.active.IsZero() {
+		si.Active = time.Since(ss.active)
+	}
+	return si
+}
+
+func (mset *stream) runSink(ss *streamSink) {
+	s := mset.srv
+	defer s.grWG.Done()
+
+	mset.mu.RLock()
+	accName, name, store := mset.acc.Name, mset.cfg.Name, mset.store
+	mset.mu.RUnlock()
+
+	var t sinkTarget
+	defer func() {
+		if t != nil {
+			t.close()
+		}
+	}()
+
+	// Returns false if we should exit.
+	wait := func(d time.Duration) bool {
+		select {
+		case <-ss.qch:
+			return false
+		case <-s.quitCh:
+			return false
+		case <-time.After(d):
+			return true
+		}
+	}
+	// Records the outcome of a push and returns how long to wait before retrying.
+	retryWait := sinkMinRetryWait
+	failed := func(err error) time.Duration {
+		s.RateLimitWarnf("Error pushing stream '%s > %s' to its sink: %v", accName, name, err)
+		mset.mu.Lock()
+		ss.err = err.Error()
+		mset.mu.Unlock()
+		if t != nil {
+			t.close()
+			t = nil
+		}
+		w := retryWait
+		if retryWait *= 2; retryWait > sinkMaxRetryWait {
+			retryWait = sinkMaxRetryWait
+		}
+		return w
+	}
+
+	filter := ss.cfg.FilterSubject
+	wc := subjectHasWildcard(filter)
+	for {
+		select {
+		case <-ss.qch:
+			return
+		case <-s.quitCh:
+			return
+		default:
+		}
+
+		mset.mu.RLock()
+		seq := ss.seq
+		mset.mu.RUnlock()
+
+		var msgs []*StoreMsg
+		for len(msgs) < sinkBatchSize {
+			sm, nseq, err := store.LoadNextMsg(filter, wc, seq+1, new(StoreMsg))
+			if err != nil || sm == nil {
+				// Nothing else matches up to the last sequence we looked at.
+				if err == ErrStoreEOF && nseq > seq && len(msgs) == 0 {
+					seq = nseq
+				}
+				break
+			}
+			msgs = append(msgs, sm)
+			seq = sm.seq
+		}
+		if len(msgs) == 0 {
+			mset.mu.Lock()
+			if seq > ss.seq {
+				ss.seq = seq
+			}
+			mset.mu.Unlock()
+			if !wait(sinkPollInterval) {
+				return
+			}
+			continue
+		}
+
+		if t == nil {
+			var err error
+			if t, err = dialSink(s, ss.cfg); err != nil {
+				t = nil
+				if !wait(failed(err)) {
+					return
+				}
+				continue
+			}
+		}
+		if err := t.send(name, msgs); err != nil {
+			if !wait(failed(err)) {
+				return
+			}
+			continue
+		}
+		retryWait = sinkMinRetryWait
+
+		mset.mu.Lock()
+		ss.seq, ss.active, ss.err = seq, time.Now(), _EMPTY_
+		mset.mu.Unlock()
+		mset.checkpointSink(ss, seq)
+	}
+}
+
+// Writes out the last sequence pushed to our sink.
+func (mset *stream) checkpointSink(ss *streamSink, seq uint64) {
+	// Do not write anything once stopped, our stream may be gone.
+	select {
+	case <-ss.qch:
+		return
+	default:
+	}
+	b, _ := json.Marshal(&sinkCheckpoint{Target: ss.cfg.target(), Seq: seq, Updated: time.Now().UTC()})
+	if err := os.MkdirAll(filepath.Dir(ss.file), defaultDirPerms); err != nil {
+		mset.srv.RateLimitWarnf("Could not write sink checkpoint to %q: %v", ss.file, err)
+		return
+	}
+	tmp := ss.file + ".tmp"
+	if err := os.WriteFile(tmp, b, defaultFilePerms); err != nil {
+		mset.srv.RateLimitWarnf("Could not write sink checkpoint to %q: %v", ss.file, err)
+		return
+	}
+	os.Rename(tmp, ss.file)
+}
+
+// Connects to our sink, checking it against the server's current sink options.
+func dialSink(s *Server, sk *StreamSink) (sinkTarget, error) {
+	opts := s.getOpts()
+	so := &opts.JetStreamSinks
+	if !so.Enabled {
+		return nil, errors.New("stream sinks are not enabled for this server")
+	}
+	if sk.Kafka != nil {
+		if err := so.checkKafkaSink(sk.Kafka); err != nil {
+			return nil, err
+		}
+		kd, ok := opts.JetStreamKafkaDialer.(KafkaSinkDialer)
+		if !ok {
+			return nil, errors.New("no kafka dialer that can produce")
+		}
+		kp, err := kd.DialProducer(sk.Kafka)
+		if err != nil {
+			return nil, err
+		}
+		return &kafkaSinkTarget{kp: kp, topic: sk.Kafka.Topic}, nil
+	}
+	u, err := url.Parse(sk.URL)
+	if err != nil {
+		return nil, err
+	}
+	if err := so.checkURL(u); err != nil {
+		return nil, err
+	}
+	t := &httpSinkTarget{url: sk.URL}
+	if sk.Credentials != _EMPTY_ {
+		creds, ok := so.Credentials[sk.Credentials]
+		if !ok {
+			return nil, fmt.Errorf("stream sink credentials %q not found", sk.Credentials)
+		}
+		t.user, t.pass, t.basic = strings.Cut(creds, ":")
+	}
+	// Do not follow redirects, they could lead anywhere.
+	t.hc = &http.Client{
+		Timeout:       sinkTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return t, nil
+}
+
+// Returns the headers of a message with our stream and sequence added.
+func sinkHeader(stream string, sm *StoreMsg) []byte {
+	hdr := genHeader(sm.hdr, JSSinkStream, stream)
+	return genHeader(hdr, JSSinkSequence, strconv.FormatUint(sm.seq, 10))
+}
+
+// Pushes messages one by one as POST requests, with their subject and headers as request headers.
+// With credentials of the form user:password we use basic auth, otherwise the user is a bearer token.
+type httpSinkTarget struct {
+	url   string
+	user  string
+	pass  string
+	basic bool
+	hc    *http.Client
+}
+
+func (t *httpSinkTarget) send(stream string, msgs []*StoreMsg) error {
+	for _, sm := range msgs {
+		req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(sm.msg))
+		if err != nil {
+			return err
+		}
+		hdr := sinkHeader(stream, sm)
+		mh, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(hdr[len(hdrLine):]))).ReadMIMEHeader()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		for k, v := range mh {
+			req.Header[k] = v
+		}
+		req.Header.Set("Nats-Subject", sm.subj)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if t.basic {
+			req.SetBasicAuth(t.user, t.pass)
+		} else if t.user != _EMPTY_ {
+			req.Header.Set("Authorization", "Bearer "+t.user)
+		}
+		resp, err := t.hc.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+func (t *httpSinkTarget) close() {
+	t.hc.CloseIdleConnections()
+}
+
+type kafkaSinkTarget struct {
+	kp    KafkaProducer
+	topic string
+}
+
+func (t *kafkaSinkTarget) send(stream string, msgs []*StoreMsg) error {
+	recs := make([]*KafkaRecord, 0, len(msgs))
+	for _, sm := range msgs {
+		h := map[string]string{
+			"Nats-Subject": sm.subj,
+			JSSinkStream:   stream,
+			JSSinkSequence: strconv.FormatUint(sm.seq, 10),
+		}
+		recs = append(recs, &KafkaRecord{Key: []byte(sm.subj), Value: sm.msg, Headers: h})
+	}
+	return t.kp.Produce(t.topic, recs)
+}
+
+func (t *kafkaSinkTarget) close() {
+	t.kp.Close()
+}
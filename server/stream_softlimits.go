@@ -0,0 +1,153 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+// SoftLimits warn when a stream gets close to its MaxBytes or MaxMsgs, before
+// the discard policy kicks in. Thresholds are a percentage of those limits.
+type SoftLimits struct {
+	MaxBytesPct int `json:"max_bytes_pct,omitempty"`
+	MaxMsgsPct  int `json:"max_msgs_pct,omitempty"`
+	// WarnHeader will add a JSSoftLimit header to pub acks while over a soft limit.
+	WarnHeader bool `json:"warn_header,omitempty"`
+}
+
+// Header added to pub acks listing the soft limits a stream is over.
+const JSSoftLimit = "Nats-Soft-Limit"
+
+// Names of the soft limits, as used in advisories and the JSSoftLimit header.
+const (
+	softLimitMaxBytes = "max_bytes"
+	softLimitMaxMsgs  = "max_msgs"
+)
+
+// JSStreamSoftLimitAdvisoryType is sent when a stream crosses one of its soft limits.
+const JSStreamSoftLimitAdvisoryType = "io.nats.jetstream.advisory.v1.stream_soft_limit"
+
+// JSStreamSoftLimitAdvisory indicates that a stream went over, or back under, a soft limit.
+type JSStreamSoftLimitAdvisory struct {
+	TypedEvent
+	Account   string `json:"account,omitempty"`
+	Stream    string `json:"stream"`
+	Limit     string `json:"limit"`
+	Threshold int    `json:"threshold_pct"`
+	Current   uint64 `json:"current"`
+	Max       int64  `json:"max"`
+	// Exceeded is false once the stream went back under the threshold.
+	Exceeded bool   `json:"exceeded"`
+	Domain   string `json:"domain,omitempty"`
+}
+
+func checkSoftLimits(cfg *StreamConfig) error {
+	sl := cfg.SoftLimits
+	if sl == nil {
+		return nil
+	}
+	if sl.MaxBytesPct < 0 || sl.MaxBytesPct > 100 || sl.MaxMsgsPct < 0 || sl.MaxMsgsPct > 100 {
+		return errors.New("soft limit thresholds need to be between 0 and 100 percent")
+	}
+	if sl.MaxBytesPct > 0 && cfg.MaxBytes <= 0 {
+		return errors.New("soft limit on bytes requires max bytes")
+	}
+	if sl.MaxMsgsPct > 0 && cfg.MaxMsgs <= 0 {
+		return errors.New("soft limit on messages requires max messages")
+	}
+	return nil
+}
+
+// A soft limit that went over or back under its threshold.
+type softLimitCrossing struct {
+	limit     string
+	threshold int
+	current   uint64
+	max       int64
+	exceeded  bool
+}
+
+// Checks the soft limits against the current state of the store. Returns the value
+// for the JSSoftLimit header, if we should add one, and the limits that changed since
+// we last checked. Since this is only checked on stores we find out we went back
+// under a threshold with the next message.
+// Lock should be held.
+func (mset *stream) checkSoftLimitsLocked() (string, []*softLimitCrossing) {
+	sl := mset.cfg.SoftLimits
+	if sl == nil {
+		mset.softOver = nil
+		return _EMPTY_, nil
+	}
+	var state StreamState
+	mset.store.FastState(&state)
+
+	var over []string
+	var crossed []*softLimitCrossing
+	check := func(limit string, pct int, current uint64, max int64) {
+		if pct <= 0 || max <= 0 {
+			return
+		}
+		exceeded := current*100 >= uint64(max)*uint64(pct)
+		if exceeded {
+			over = append(over, limit)
+		}
+		if was := mset.softOver[limit]; was != exceeded {
+			if mset.softOver == nil {
+				mset.softOver = make(map[string]bool)
+			}
+			mset.softOver[limit] = exceeded
+			crossed = append(crossed, &softLimitCrossing{limit, pct, current, max, exceeded})
+		}
+	}
+	check(softLimitMaxBytes, sl.MaxBytesPct, state.Bytes, mset.cfg.MaxBytes)
+	check(softLimitMaxMsgs, sl.MaxMsgsPct, state.Msgs, mset.cfg.MaxMsgs)
+
+	if !sl.WarnHeader || len(over) == 0 {
+		return _EMPTY_, crossed
+	}
+	return strings.Join(over, ","), crossed
+}
+
+// Sends an advisory for each soft limit we went over or back under.
+func (mset *stream) sendSoftLimitAdvisories(crossed []*softLimitCrossing) {
+	s, acc, name := mset.srv, mset.account(), mset.name()
+	subj := JSAdvisoryStreamSoftLimitPre + "." + name
+	for _, c := range crossed {
+		adv := &JSStreamSoftLimitAdvisory{
+			TypedEvent: TypedEvent{
+				Type: JSStreamSoftLimitAdvisoryType,
+				ID:   nuid.Next(),
+				Time: time.Now().UTC(),
+			},
+			Stream:    name,
+			Limit:     c.limit,
+			Threshold: c.threshold,
+			Current:   c.current,
+			Max:       c.max,
+			Exceeded:  c.exceeded,
+			Domain:    s.getOpts().JetStreamDomain,
+		}
+		// Send to the user's account if not the system account.
+		if acc != s.SystemAccount() {
+			s.publishAdvisory(acc, subj, adv)
+		}
+		// Now do system level one. Place account info in adv, and nil account means system.
+		adv.Account = acc.GetName()
+		s.publishAdvisory(nil, subj, adv)
+	}
+}
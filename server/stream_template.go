@@ -0,0 +1,260 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StreamTemplateOverride changes the config of a single stream created from a template.
+// Zero values keep the template's.
+type StreamTemplateOverride struct {
+	MaxMsgs  int64         `json:"max_msgs,omitempty"`
+	MaxBytes int64         `json:"max_bytes,omitempty"`
+	MaxAge   time.Duration `json:"max_age,omitempty"`
+	Replicas int           `json:"num_replicas,omitempty"`
+}
+
+// Bounds on how often we look for idle streams of a template.
+const (
+	minTemplateIdleCheck = time.Second
+	maxTemplateIdleCheck = time.Minute
+)
+
+// With a StreamName, templates bind placeholders like {region} in their subject
+// patterns, e.g. `orders.{region}.>`. The first message on a subject matching a
+// pattern creates the stream named after the bound values, e.g. ORDERS_{region},
+// with the patterns of the template rendered with those values as its subjects.
+// Streams created from a template that have not stored a message for the
+// template's IdleTimeout, and have no consumers, are deleted again.
+
+// Returns the placeholder names of a subject pattern, in order.
+func templatePlaceholders(pattern string) []string {
+	var names []string
+	for _, tok := range strings.Split(pattern, tsep) {
+		if isTemplatePlaceholder(tok) {
+			names = append(names, tok[1:len(tok)-1])
+		}
+	}
+	return names
+}
+
+func isTemplatePlaceholder(tok string) bool {
+	if len(tok) < 3 || tok[0] != '{' || tok[len(tok)-1] != '}' {
+		return false
+	}
+	for _, r := range tok[1 : len(tok)-1] {
+		if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the pattern with its placeholders as wildcards, for subscribing.
+func templateWildcard(pattern string) string {
+	toks := strings.Split(pattern, tsep)
+	for i, tok := range toks {
+		if isTemplatePlaceholder(tok) {
+			toks[i] = pwcs
+		}
+	}
+	return strings.Join(toks, tsep)
+}
+
+// Binds the placeholders of the pattern to the tokens of a literal subject.
+func bindTemplatePattern(pattern, subject string) (map[string]string, bool) {
+	ptoks, stoks := strings.Split(pattern, tsep), strings.Split(subject, tsep)
+	vals := make(map[string]string)
+	for i, ptok := range ptoks {
+		if ptok == fwcs {
+			return vals, len(stoks) > i
+		}
+		if i >= len(stoks) {
+			return nil, false
+		}
+		switch {
+		case isTemplatePlaceholder(ptok):
+			vals[ptok[1:len(ptok)-1]] = stoks[i]
+		case ptok == pwcs, ptok == stoks[i]:
+		default:
+			return nil, false
+		}
+	}
+	return vals, len(ptoks) == len(stoks)
+}
+
+// Replaces the placeholders in s with their values.
+func renderTemplate(s string, vals map[string]string) string {
+	for name, val := range vals {
+		s = strings.ReplaceAll(s, "{"+name+"}", val)
+	}
+	return s
+}
+
+func checkStreamTemplateV2(tc *StreamTemplateConfig) error {
+	if tc.StreamName == _EMPTY_ {
+		if len(tc.Subjects) > 0 || len(tc.Overrides) > 0 || tc.IdleTimeout != 0 {
+			return errors.New("template subjects, overrides and idle timeout require a stream name")
+		}
+		return nil
+	}
+	if len(tc.Subjects) == 0 {
+		return errors.New("template with a stream name requires subjects")
+	}
+	if len(tc.Config.Subjects) > 0 {
+		return errors.New("template with a stream name takes its subjects from the template")
+	}
+	if tc.IdleTimeout < 0 {
+		return errors.New("template idle timeout can not be negative")
+	}
+	var names []string
+	for i, pattern := range tc.Subjects {
+		if !IsValidSubject(templateWildcard(pattern)) {
+			return fmt.Errorf("template subject %q is not valid", pattern)
+		}
+		pn := templatePlaceholders(pattern)
+		sort.Strings(pn)
+		if len(pn) == 0 {
+			return fmt.Errorf("template subject %q has no placeholders", pattern)
+		}
+		if i == 0 {
+			names = pn
+		} else if strings.Join(pn, ",") != strings.Join(names, ",") {
+			return errors.New("template subjects need to have the same placeholders")
+		}
+	}
+	// Each distinct set of values needs its own stream.
+	for _, name := range names {
+		if !strings.Contains(tc.StreamName, "{"+name+"}") {
+			return fmt.Errorf("template stream name needs to contain placeholder {%s}", name)
+		}
+	}
+	for name, o := range tc.Overrides {
+		if o == nil || o.Replicas < 0 || o.Replicas > StreamMaxReplicas {
+			return fmt.Errorf("template override for %q is not valid", name)
+		}
+	}
+	return nil
+}
+
+// Returns the name and subjects of the stream for a message on subject, if any.
+func (t *streamTemplate) instanceFor(subject string) (string, []string, bool) {
+	if t.StreamName == _EMPTY_ {
+		return canonicalName(subject), []string{subject}, true
+	}
+	for _, pattern := range t.Subjects {
+		vals, ok := bindTemplatePattern(pattern, subject)
+		if !ok {
+			continue
+		}
+		subjects := make([]string, 0, len(t.Subjects))
+		for _, p := range t.Subjects {
+			subjects = append(subjects, renderTemplate(p, vals))
+		}
+		return renderTemplate(t.StreamName, vals), subjects, true
+	}
+	return _EMPTY_, nil, false
+}
+
+// Applies any override for the stream we are about to create.
+func (t *streamTemplate) applyOverride(cfg *StreamConfig) {
+	o := t.Overrides[cfg.Name]
+	if o == nil {
+		return
+	}
+	if o.MaxMsgs != 0 {
+		cfg.MaxMsgs = o.MaxMsgs
+	}
+	if o.MaxBytes != 0 {
+		cfg.MaxBytes = o.MaxBytes
+	}
+	if o.MaxAge != 0 {
+		cfg.MaxAge = o.MaxAge
+	}
+	if o.Replicas != 0 {
+		cfg.Replicas = o.Replicas
+	}
+}
+
+// Returns how often to look for idle streams.
+func templateIdleCheckInterval(idle time.Duration) time.Duration {
+	if idle/2 < minTemplateIdleCheck {
+		return minTemplateIdleCheck
+	}
+	if idle/2 > maxTemplateIdleCheck {
+		return maxTemplateIdleCheck
+	}
+	return idle / 2
+}
+
+// Lock should be held.
+func (t *streamTemplate) startIdleCheck() {
+	if t.IdleTimeout > 0 && t.idleTmr == nil {
+		t.idleTmr = time.AfterFunc(templateIdleCheckInterval(t.IdleTimeout), t.checkIdleStreams)
+	}
+}
+
+// Lock should be held.
+func (t *streamTemplate) stopIdleCheck() {
+	if t.idleTmr != nil {
+		t.idleTmr.Stop()
+		t.idleTmr = nil
+	}
+}
+
+// Deletes the streams of this template that have been idle for too long.
+func (t *streamTemplate) checkIdleStreams() {
+	t.mu.Lock()
+	if t.tc == nil {
+		t.mu.Unlock()
+		return
+	}
+	c, acc, idle := t.tc, t.tc.acc, t.IdleTimeout
+	names := append([]string(nil), t.streams...)
+	t.mu.Unlock()
+
+	var removed bool
+	for _, name := range names {
+		mset, err := acc.lookupStream(name)
+		if err != nil {
+			continue
+		}
+		last := mset.state().LastTime
+		if created := mset.createdTime(); last.Before(created) {
+			last = created
+		}
+		if time.Since(last) < idle || mset.numConsumers() > 0 {
+			continue
+		}
+		if err := mset.delete(); err != nil {
+			c.Warnf("JetStream could not delete idle stream '%s > %s' of template %q: %v", acc.Name, name, t.Name, err)
+			continue
+		}
+		removed = true
+	}
+	if removed {
+		acc.validateStreams(t)
+	}
+
+	t.mu.Lock()
+	if t.idleTmr != nil {
+		t.idleTmr.Reset(templateIdleCheckInterval(idle))
+	}
+	t.mu.Unlock()
+}
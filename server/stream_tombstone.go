@@ -0,0 +1,78 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Headers for tombstone records left behind when a message is removed.
+// A tombstone is stored on the subject of the removed message with no payload.
+const (
+	JSTombstoneSequence = "Nats-Tombstone-Sequence"
+	JSTombstoneReason   = "Nats-Tombstone-Reason"
+)
+
+var errTombstoneClustered = errors.New("tombstones not supported for clustered streams")
+
+// Removes or erases the message at seq and leaves a tombstone record carrying
+// the sequence and reason in its place. Tombstones are only delivered to
+// consumers with DeliverTombstones set.
+// This is only supported for streams that are not clustered.
+func (mset *stream) removeMsgWithTombstone(seq uint64, erase bool, reason string) (bool, error) {
+	mset.mu.RLock()
+	store, clustered := mset.store, mset.isClustered()
+	mset.mu.RUnlock()
+
+	if clustered {
+		return false, errTombstoneClustered
+	}
+	if store == nil {
+		return false, ErrStoreClosed
+	}
+
+	var smv StoreMsg
+	sm, err := store.LoadMsg(seq, &smv)
+	if err == ErrStoreMsgNotFound || err == ErrStoreEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	subj := sm.subj
+
+	var removed bool
+	if erase {
+		removed, err = mset.eraseMsg(seq)
+	} else {
+		removed, err = mset.removeMsg(seq)
+	}
+	if err != nil || !removed {
+		return removed, err
+	}
+
+	hdr := genHeader(nil, JSTombstoneSequence, strconv.FormatUint(seq, 10))
+	if reason != _EMPTY_ {
+		hdr = genHeader(hdr, JSTombstoneReason, reason)
+	}
+	if _, err := mset.storeDirect(subj, hdr, nil); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// Returns whether the stored message is a tombstone record.
+func isTombstone(hdr []byte) bool {
+	return len(hdr) > 0 && len(getHeader(JSTombstoneSequence, hdr)) > 0
+}
@@ -0,0 +1,188 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// StreamTransform is a step in the pipeline of transforms applied, in order, to
+// messages published to a stream before they are stored. A step applies to the
+// messages matching Filter, or all messages if empty, and does exactly one of:
+// map the subject to Destination, set Headers, or reject messages with a
+// payload larger than MaxPayload. Later steps see the result of earlier ones.
+type StreamTransform struct {
+	Filter      string            `json:"filter,omitempty"`
+	Destination string            `json:"dest,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	MaxPayload  int32             `json:"max_payload,omitempty"`
+}
+
+// A transform step ready to be applied.
+type streamXform struct {
+	filter string
+	wc     bool
+	tr     *transform
+	hdrs   []string // Alternating keys and values, sorted by key.
+	maxp   int
+}
+
+var errTransformRejected = errors.New("message rejected by stream transform")
+
+// Validate the transforms of a stream.
+func checkStreamTransforms(cfg *StreamConfig) error {
+	if len(cfg.Transforms) == 0 {
+		return nil
+	}
+	if cfg.Mirror != nil {
+		return errors.New("transforms not allowed on mirrors")
+	}
+	_, err := compileStreamTransforms(cfg)
+	return err
+}
+
+// Compiles the transforms of a stream config.
+func compileStreamTransforms(cfg *StreamConfig) ([]*streamXform, error) {
+	var xfs []*streamXform
+	for i, st := range cfg.Transforms {
+		if st == nil {
+			return nil, fmt.Errorf("transform %d is empty", i+1)
+		}
+		var actions int
+		if st.Destination != _EMPTY_ {
+			actions++
+		}
+		if len(st.Headers) > 0 {
+			actions++
+		}
+		if st.MaxPayload != 0 {
+			actions++
+		}
+		if actions != 1 {
+			return nil, fmt.Errorf("transform %d needs exactly one of destination, headers or max payload", i+1)
+		}
+		if st.Filter != _EMPTY_ && !IsValidSubject(st.Filter) {
+			return nil, fmt.Errorf("transform %d has invalid filter %q", i+1, st.Filter)
+		}
+		xf := &streamXform{filter: st.Filter, wc: subjectHasWildcard(st.Filter)}
+		switch {
+		case st.Destination != _EMPTY_:
+			if st.Filter == _EMPTY_ {
+				return nil, fmt.Errorf("transform %d requires a filter to map subjects", i+1)
+			}
+			tr, err := newTransform(st.Filter, st.Destination)
+			if err != nil {
+				return nil, fmt.Errorf("transform %d can not map %q to %q: %v", i+1, st.Filter, st.Destination, err)
+			}
+			dest := tr.destFilter()
+			var inStream bool
+			for _, subj := range cfg.Subjects {
+				if subjectIsSubsetMatch(dest, subj) {
+					inStream = true
+					break
+				}
+			}
+			if !inStream {
+				return nil, fmt.Errorf("transform %d maps to subjects %q that are not part of the stream subjects", i+1, dest)
+			}
+			xf.tr = tr
+		case len(st.Headers) > 0:
+			keys := make([]string, 0, len(st.Headers))
+			for k := range st.Headers {
+				if k == _EMPTY_ {
+					return nil, fmt.Errorf("transform %d has an empty header name", i+1)
+				}
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				xf.hdrs = append(xf.hdrs, k, st.Headers[k])
+			}
+		default:
+			if st.MaxPayload < 0 {
+				return nil, fmt.Errorf("transform %d max payload can not be negative", i+1)
+			}
+			xf.maxp = int(st.MaxPayload)
+		}
+		xfs = append(xfs, xf)
+	}
+	return xfs, nil
+}
+
+// Sets up our transforms from the config.
+// Lock should be held.
+func (mset *stream) setTransformsLocked(cfg *StreamConfig) {
+	// The config has been checked already.
+	mset.xforms, _ = compileStreamTransforms(cfg)
+}
+
+func (xf *streamXform) matches(subject string) bool {
+	if xf.filter == _EMPTY_ {
+		return true
+	}
+	if xf.wc {
+		return subjectIsSubsetMatch(subject, xf.filter)
+	}
+	return subject == xf.filter
+}
+
+// Runs a message through our transforms, returning the transformed message or
+// an error if it was rejected.
+func (mset *stream) applyTransforms(subject string, hdr, msg []byte) (string, []byte, []byte, error) {
+	mset.mu.RLock()
+	xfs := mset.xforms
+	mset.mu.RUnlock()
+
+	var copied bool
+	for _, xf := range xfs {
+		if !xf.matches(subject) {
+			continue
+		}
+		switch {
+		case xf.tr != nil:
+			if to, err := xf.tr.transformSubject(subject); err == nil {
+				subject = to
+			}
+		case len(xf.hdrs) > 0:
+			// Inbound headers may be backed by the client's buffer.
+			if !copied {
+				hdr, copied = copyBytes(hdr), true
+			}
+			for i := 0; i < len(xf.hdrs); i += 2 {
+				hdr = genHeader(removeHeaderIfPresent(hdr, xf.hdrs[i]), xf.hdrs[i], xf.hdrs[i+1])
+			}
+		default:
+			if len(msg) > xf.maxp {
+				return subject, hdr, msg, errTransformRejected
+			}
+		}
+	}
+	return subject, hdr, msg, nil
+}
+
+// Lets a publisher know a transform rejected their message.
+func (mset *stream) sendTransformRejected(reply string) {
+	if reply == _EMPTY_ {
+		return
+	}
+	resp := JSPubAckResponse{
+		PubAck: &PubAck{Stream: mset.name()},
+		Error:  NewJSStreamMessageExceedsMaximumError(),
+	}
+	b, _ := json.Marshal(resp)
+	mset.outq.sendMsg(reply, b)
+}
@@ -0,0 +1,184 @@
+// Copyright 2023 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How much traffic each window of the subject talkers covers.
+// Rates are reported over the previous and current window.
+const talkersWindow = 10 * time.Second
+
+// subjectTalkers tracks the busiest subjects published to in an account.
+// This uses a space-saving sketch, so only a fixed number of subjects are tracked
+// and counts for subjects that displaced others are approximate, but the heavy
+// hitters are always present.
+type subjectTalkers struct {
+	mu    sync.Mutex
+	size  int
+	start time.Time
+	cur   *talkersSketch
+	prev  *talkersSketch
+	pdur  time.Duration
+}
+
+type talkersSketch struct {
+	counts map[string]*talkerCount
+	h      talkersHeap
+}
+
+type talkerCount struct {
+	subj  string
+	msgs  uint64
+	bytes uint64
+	// How many of our msgs could belong to subjects we displaced.
+	err   uint64
+	index int
+}
+
+// Min heap on msgs so we know which subject to displace.
+type talkersHeap []*talkerCount
+
+func (h talkersHeap) Len() int           { return len(h) }
+func (h talkersHeap) Less(i, j int) bool { return h[i].msgs < h[j].msgs }
+func (h talkersHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *talkersHeap) Push(x interface{}) {
+	tc := x.(*talkerCount)
+	tc.index = len(*h)
+	*h = append(*h, tc)
+}
+func (h *talkersHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	tc := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return tc
+}
+
+func newSubjectTalkers(size int) *subjectTalkers {
+	return &subjectTalkers{size: size, start: time.Now(), cur: newTalkersSketch(size)}
+}
+
+func newTalkersSketch(size int) *talkersSketch {
+	return &talkersSketch{counts: make(map[string]*talkerCount, size), h: make(talkersHeap, 0, size)}
+}
+
+// Moves to a new window if the current one has passed.
+// Lock should be held.
+func (st *subjectTalkers) rotate(now time.Time) {
+	elapsed := now.Sub(st.start)
+	if elapsed < talkersWindow {
+		return
+	}
+	// If we missed a whole window there is nothing recent to carry over.
+	if elapsed < 2*talkersWindow {
+		st.prev, st.pdur = st.cur, elapsed
+	} else {
+		st.prev, st.pdur = nil, 0
+	}
+	st.cur, st.start = newTalkersSketch(st.size), now
+}
+
+// record counts a message of sz bytes published to subject.
+func (st *subjectTalkers) record(subject []byte, sz int, now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.rotate(now)
+	sk := st.cur
+	if tc := sk.counts[string(subject)]; tc != nil {
+		tc.msgs++
+		tc.bytes += uint64(sz)
+		heap.Fix(&sk.h, tc.index)
+		return
+	}
+	if len(sk.h) < st.size {
+		tc := &talkerCount{subj: string(subject), msgs: 1, bytes: uint64(sz)}
+		sk.counts[tc.subj] = tc
+		heap.Push(&sk.h, tc)
+		return
+	}
+	// Displace the least busy subject, taking over its counts.
+	tc := sk.h[0]
+	delete(sk.counts, tc.subj)
+	tc.subj, tc.err = string(subject), tc.msgs
+	tc.msgs++
+	tc.bytes += uint64(sz)
+	sk.counts[tc.subj] = tc
+	heap.Fix(&sk.h, 0)
+}
+
+// SubjectTalker is the approximate traffic for a subject over the last talkers window.
+type SubjectTalker struct {
+	Subject  string  `json:"subject"`
+	Msgs     uint64  `json:"msgs"`
+	Bytes    uint64  `json:"bytes"`
+	MsgRate  float64 `json:"msg_rate"`
+	ByteRate float64 `json:"byte_rate"`
+	// Upper bound on how much Msgs may be over counted.
+	Error uint64 `json:"error,omitempty"`
+}
+
+// top returns the n busiest subjects sorted by message rate, or byte rate if byBytes is set.
+func (st *subjectTalkers) top(n int, byBytes bool, now time.Time) []*SubjectTalker {
+	st.mu.Lock()
+	st.rotate(now)
+	dur := st.pdur + now.Sub(st.start)
+	talkers := make(map[string]*SubjectTalker, len(st.cur.counts))
+	for _, sk := range []*talkersSketch{st.prev, st.cur} {
+		if sk == nil {
+			continue
+		}
+		for subj, tc := range sk.counts {
+			t := talkers[subj]
+			if t == nil {
+				t = &SubjectTalker{Subject: subj}
+				talkers[subj] = t
+			}
+			t.Msgs += tc.msgs
+			t.Bytes += tc.bytes
+			t.Error += tc.err
+		}
+	}
+	st.mu.Unlock()
+
+	top := make([]*SubjectTalker, 0, len(talkers))
+	for _, t := range talkers {
+		if secs := dur.Seconds(); secs > 0 {
+			t.MsgRate, t.ByteRate = float64(t.Msgs)/secs, float64(t.Bytes)/secs
+		}
+		top = append(top, t)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if byBytes && top[i].Bytes != top[j].Bytes {
+			return top[i].Bytes > top[j].Bytes
+		}
+		if top[i].Msgs != top[j].Msgs {
+			return top[i].Msgs > top[j].Msgs
+		}
+		return top[i].Subject < top[j].Subject
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
@@ -652,9 +652,16 @@ func addNodeToResults(n *node, results *SublistResult) {
 			nqsub := make([]*subscription, 0, len(qr))
 			results.qsubs = append(results.qsubs, nqsub)
 		}
+		// Remote queue subs with a weight of zero belong to servers in lame duck mode,
+		// we only use them if there is no one else.
+		var drained []*subscription
 		for sub := range qr {
 			if isRemoteQSub(sub) {
 				ns := atomic.LoadInt32(&sub.qw)
+				if ns <= 0 {
+					drained = append(drained, sub)
+					continue
+				}
 				// Shadow these subscriptions
 				for n := 0; n < int(ns); n++ {
 					results.qsubs[i] = append(results.qsubs[i], sub)
@@ -663,6 +670,9 @@ func addNodeToResults(n *node, results *SublistResult) {
 				results.qsubs[i] = append(results.qsubs[i], sub)
 			}
 		}
+		if len(results.qsubs[i]) == 0 {
+			results.qsubs[i] = append(results.qsubs[i], drained...)
+		}
 	}
 }
 
@@ -629,7 +629,7 @@ func (c *client) wsEnqueueCloseMessage(reason ClosedState) {
 		status = wsCloseStatusNormalClosure
 	case AuthenticationTimeout, AuthenticationViolation, SlowConsumerPendingBytes, SlowConsumerWriteDeadline,
 		MaxAccountConnectionsExceeded, MaxConnectionsExceeded, MaxControlLineExceeded, MaxSubscriptionsExceeded,
-		MissingAccount, AuthenticationExpired, Revocation:
+		MissingAccount, AuthenticationExpired, Revocation, IdleConnection, ServerInStandby:
 		status = wsCloseStatusPolicyViolation
 	case TLSHandshakeError:
 		status = wsCloseStatusTLSHandshake
@@ -14,8 +14,10 @@
 package test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"testing"
 	"time"
@@ -202,6 +204,55 @@ func TestNewRouteConnectSubs(t *testing.T) {
 	}
 }
 
+func TestNewRouteQueueWeightsOnShutdown(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		proto   int
+		drained bool
+	}{
+		{"current", server.RouteProtoV3, true},
+		// Older routes would take a weight of zero as an unsubscribe.
+		{"old", server.RouteProtoV2, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, opts := runNewRouteServer(t)
+			defer s.Shutdown()
+
+			c := createClientConn(t, opts.Host, opts.Port)
+			defer c.Close()
+
+			send, expect := setupConn(t, c)
+			send("SUB foo bar 1\r\n")
+			send("PING\r\n")
+			expect(pongRe)
+
+			rc := createRouteConn(t, opts.Cluster.Host, opts.Cluster.Port)
+			defer rc.Close()
+
+			routeID := "RTEST_NEW:33"
+			routeSend, routeExpect := setupRouteEx(t, rc, opts, routeID)
+			info := checkInfoMsg(t, rc)
+			info.ID = routeID
+			info.Name = ""
+			info.Proto = test.proto
+			b, err := json.Marshal(info)
+			if err != nil {
+				t.Fatalf("Could not marshal test route info: %v", err)
+			}
+			routeSend(fmt.Sprintf("INFO %s\r\n", b))
+			routeExpect(rsubRe)
+
+			// Our queue member is drained before the route goes away.
+			s.Shutdown()
+			rc.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf, _ := io.ReadAll(rc)
+			if drained := bytes.Contains(buf, []byte("RS+ $G foo bar 0\r\n")); drained != test.drained {
+				t.Fatalf("Expected drained to be %v, got %q", test.drained, buf)
+			}
+		})
+	}
+}
+
 func TestNewRouteConnectSubsWithAccount(t *testing.T) {
 	s, opts := runNewRouteServer(t)
 	defer s.Shutdown()
@@ -15,8 +15,10 @@ package test
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"net"
@@ -1965,6 +1967,154 @@ func TestTLSPinnedCertsRoute(t *testing.T) {
 	checkNumRoutes(t, srv, 0)
 }
 
+func TestTLSPinnedCertsLeafNodeRemote(t *testing.T) {
+	tmplHub := `
+	host: localhost
+	port: -1
+	leafnodes {
+		port: -1
+		tls {
+			ca_file: "configs/certs/ca.pem"
+			cert_file: "configs/certs/server-cert.pem"
+			key_file: "configs/certs/server-key.pem"
+		}
+	}`
+	// The remote pins the hub's certificate, which replaces the leafnode pinned_certs.
+	tmplSpoke := `
+	host: localhost
+	port: -1
+	leafnodes {
+		tls {
+			cert_file: "configs/certs/server-cert.pem"
+			key_file: "configs/certs/server-key.pem"
+			pinned_certs: ["aaaaaaaa09fde09451411ba3b42c0f74727d61a974c69fd3cf5257f39c75f0e9"]
+		}
+		remotes [{
+			url: "tls://localhost:%d"
+			tls {
+				ca_file: "configs/certs/ca.pem"
+				pinned_certs: ["%s"]
+			}
+		}]
+	}`
+
+	confHub := createConfFile(t, []byte(tmplHub))
+	hub, o := RunServerWithConfig(confHub)
+	defer hub.Shutdown()
+
+	// Pin the hub by the fingerprint of its certificate, as printed by openssl.
+	data, err := os.ReadFile("configs/certs/server-cert.pem")
+	if err != nil {
+		t.Fatalf("Error reading certificate: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	sum := sha256.Sum256(block.Bytes)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	fingerprint := strings.Join(parts, ":")
+
+	confSpoke := createConfFile(t, []byte(fmt.Sprintf(tmplSpoke, o.LeafNode.Port, fingerprint)))
+	spoke, _ := RunServerWithConfig(confSpoke)
+	defer spoke.Shutdown()
+
+	checkLeafNodeConnected(t, hub)
+	checkLeafNodeConnected(t, spoke)
+	spoke.Shutdown()
+	checkLeafNodeConnections(t, hub, 0)
+
+	// A remote pinned to another certificate should not connect, even though the CA is trusted.
+	confSpoke = createConfFile(t, []byte(fmt.Sprintf(tmplSpoke, o.LeafNode.Port,
+		"bbbbbbbb09fde09451411ba3b42c0f74727d61a974c69fd3cf5257f39c75f0e9")))
+	spoke, _ = RunServerWithConfig(confSpoke)
+	defer spoke.Shutdown()
+
+	time.Sleep(250 * time.Millisecond)
+	checkLeafNodeConnections(t, hub, 0)
+	checkLeafNodeConnections(t, spoke, 0)
+}
+
+func TestTLSPinnedCertsGatewayRemote(t *testing.T) {
+	// A pins the certificate of B in its remote, so this is what B's inbound
+	// connection needs to present, on top of what the gateway tls block allows.
+	tmplA := `
+	host: localhost
+	port: -1
+	gateway {
+		name: "A"
+		port: -1
+		tls {
+			ca_file: "configs/certs/ca.pem"
+			cert_file: "configs/certs/server-cert.pem"
+			key_file: "configs/certs/server-key.pem"
+			verify: true
+		}
+		gateways [{
+			name: "B"
+			url: "tls://localhost:1"
+			tls {
+				ca_file: "configs/certs/ca.pem"
+				cert_file: "configs/certs/server-cert.pem"
+				key_file: "configs/certs/server-key.pem"
+				pinned_certs: ["%s"]
+			}
+		}]
+	}`
+	tmplB := `
+	host: localhost
+	port: -1
+	gateway {
+		name: "B"
+		port: -1
+		tls {
+			ca_file: "configs/certs/ca.pem"
+			cert_file: "configs/certs/server-cert.pem"
+			key_file: "configs/certs/server-key.pem"
+		}
+		gateways [{
+			name: "A"
+			url: "tls://localhost:%d"
+		}]
+	}`
+
+	data, err := os.ReadFile("configs/certs/server-cert.pem")
+	if err != nil {
+		t.Fatalf("Error reading certificate: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	sum := sha256.Sum256(block.Bytes)
+	fingerprint := fmt.Sprintf("%x", sum)
+
+	confA := createConfFile(t, []byte(fmt.Sprintf(tmplA, fingerprint)))
+	srvA, oA := RunServerWithConfig(confA)
+	defer srvA.Shutdown()
+
+	confB := createConfFile(t, []byte(fmt.Sprintf(tmplB, oA.Gateway.Port)))
+	srvB, _ := RunServerWithConfig(confB)
+	defer srvB.Shutdown()
+
+	waitForOutboundGateways(t, srvB, 1, 2*time.Second)
+	srvB.Shutdown()
+	srvA.Shutdown()
+
+	// Now A pins another certificate for B, so B's connection to A gets rejected,
+	// even though it is trusted by A's gateway tls block.
+	confA = createConfFile(t, []byte(fmt.Sprintf(tmplA,
+		"bbbbbbbb09fde09451411ba3b42c0f74727d61a974c69fd3cf5257f39c75f0e9")))
+	srvA, oA = RunServerWithConfig(confA)
+	defer srvA.Shutdown()
+
+	confB = createConfFile(t, []byte(fmt.Sprintf(tmplB, oA.Gateway.Port)))
+	srvB, _ = RunServerWithConfig(confB)
+	defer srvB.Shutdown()
+
+	// Give B enough time to have tried to connect.
+	time.Sleep(2 * time.Second)
+	waitForOutboundGateways(t, srvB, 0, 2*time.Second)
+	waitForOutboundGateways(t, srvA, 0, 2*time.Second)
+}
+
 func TestAllowNonTLSReload(t *testing.T) {
 	tmpl := `
 		listen: "127.0.0.1:-1"